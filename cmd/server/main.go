@@ -1,68 +1,987 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"grpc-firstls/internal/alerting"
+	"grpc-firstls/internal/buildinfo"
 	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/grpcapi"
 	"grpc-firstls/internal/handlers"
+	"grpc-firstls/internal/kafka"
+	"grpc-firstls/internal/leader"
+	"grpc-firstls/internal/live"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
 	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/nats"
+	"grpc-firstls/internal/oidc"
+	"grpc-firstls/internal/provisioning"
+	"grpc-firstls/internal/proxy"
 	"grpc-firstls/internal/redis"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/server"
 	"grpc-firstls/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
 )
 
 func main() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
+	configFlag := flag.String("config", "", "YAML or TOML configuration file (default $CONFIG_FILE)")
+	standaloneFlag := flag.Bool("standalone", false, "Keep keys and rate limit counters in memory, without Postgres or Redis (same as STANDALONE=true)")
+	flag.Parse()
+	if *standaloneFlag {
+		os.Setenv("STANDALONE", "true")
 	}
 
-	// Load configuration
-	cfg := config.Load()
+	// Load environment variables; the process environment wins over .env,
+	// also on reloads
+	processEnv := environmentKeys()
+	envErr := godotenv.Load()
 
-	// Initialize database
-	db, err := database.NewConnection(cfg.DatabaseURL)
+	// Load configuration; environment variables override the config file
+	configFile := *configFlag
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Build the logger everything else writes through
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fmt.Fprintln(os.Stderr, "Invalid logging configuration:", err)
+		os.Exit(1)
 	}
-	defer db.Close()
+	logger, err := logging.NewWithLevel(logLevel, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid logging configuration:", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+	zap.RedirectStdLog(logger)
+	if envErr != nil {
+		logger.Info("No .env file found, using system environment variables")
+	}
+	logger.Info("Configuration loaded", zap.String("environment", cfg.Environment))
+	gin.SetMode(cfg.GinMode)
 
-	// Initialize Redis
-	redisClient, err := redis.NewClient(cfg.RedisURL)
+	// Replace credentials kept in a secrets manager
+	secretsProvider, err := config.NewSecretsProvider(cfg.Secrets)
 	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+		logger.Fatal("Invalid secrets provider configuration", zap.Error(err))
+	}
+	if secretsProvider != nil {
+		resolveCtx, cancelResolve := context.WithTimeout(context.Background(), 30*time.Second)
+		err := cfg.ResolveSecrets(resolveCtx, secretsProvider)
+		cancelResolve()
+		if err != nil {
+			logger.Fatal("Failed to load secrets", zap.Error(err))
+		}
+	}
+
+	// Initialize database
+	db, err := database.NewConnection(cfg.DatabaseURL, database.WithPool(database.PoolConfig(cfg.DatabasePool)))
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	metrics.RegisterDatabase(db.DB, db.Dialect().Name())
+	// Local SQLite databases are created and migrated by the server itself
+	if applied, err := db.Migrate(context.Background()); err != nil {
+		logger.Fatal("Failed to migrate database", zap.Error(err))
+	} else if applied > 0 {
+		logger.Info("Applied database migrations", zap.String("database", db.Dialect().Name()), zap.Int("count", applied))
+	}
+
+	// Standalone mode starts from the last snapshot, if there is one
+	var snapshotter *database.Snapshotter
+	if cfg.Standalone.Enabled {
+		if cfg.Standalone.SnapshotFile == "" {
+			logger.Warn("Running standalone without STANDALONE_SNAPSHOT_FILE, keys and counters are lost on exit")
+		} else {
+			restored, err := db.RestoreSnapshot(context.Background(), cfg.Standalone.SnapshotFile)
+			if err != nil {
+				logger.Fatal("Failed to restore snapshot", zap.String("path", cfg.Standalone.SnapshotFile), zap.Error(err))
+			}
+			logger.Info("Running standalone", zap.String("snapshot", cfg.Standalone.SnapshotFile), zap.Bool("restored", restored))
+			snapshotter = database.NewSnapshotter(db, cfg.Standalone.SnapshotFile, cfg.Standalone.SnapshotInterval)
+		}
+	}
+
+	// Key validation and listings read from replicas when there are any
+	var keyRepositoryOptions []repository.SQLOption
+	var replicas *database.Replicas
+	if len(cfg.DatabaseReplicaURLs) > 0 {
+		replicas, err = database.NewReplicas(db, cfg.DatabaseReplicaURLs, cfg.DatabaseReplicaMaxLag, cfg.DatabaseHealthCheckInterval,
+			database.WithPool(database.PoolConfig(cfg.DatabasePool)))
+		if err != nil {
+			logger.Fatal("Failed to open database replicas", zap.Error(err))
+		}
+		replicas.RegisterMetrics()
+		keyRepositoryOptions = append(keyRepositoryOptions, repository.WithReadReplicas(replicas))
+	}
+
+	// Rate limit counters live in Redis, or in the database for deployments
+	// that run without Redis
+	var counters redis.ClientInterface
+	var redisClient *redis.Client
+	var tenantClients []*redis.Client
+	var counterStore *database.CounterStore
+	isolatedTenants := map[string]redis.ClientInterface{}
+	if cfg.RateLimitBackend == "postgres" {
+		counterStore, err = database.NewCounterStore(db, cfg.RateLimitConfig.WindowJitter, cfg.CounterCleanupInterval)
+		if err != nil {
+			logger.Fatal("Invalid rate limit backend", zap.Error(err))
+		}
+		if err := counterStore.CheckSchema(context.Background()); err != nil {
+			logger.Fatal("Failed to open rate limit counters", zap.Error(err))
+		}
+		counters = counterStore
+	} else {
+		redisOptions := []redis.ClientOption{redis.WithWindowJitter(cfg.RateLimitConfig.WindowJitter), redis.WithNamespace(cfg.RedisNamespace)}
+		redisClient, err = redis.NewClient(cfg.RedisURL, redisOptions...)
+		if err != nil {
+			logger.Fatal("Failed to connect to Redis", zap.Error(err))
+		}
+		counters = redisClient
+
+		// Organizations isolated on a Redis of their own
+		for tenant, tenantURL := range cfg.RedisTenantURLs {
+			tenantClient, err := redis.NewClient(tenantURL, redisOptions...)
+			if err != nil {
+				logger.Fatal("Failed to connect to tenant Redis", zap.String("organization_id", tenant), zap.Error(err))
+			}
+			tenantClients = append(tenantClients, tenantClient)
+			isolatedTenants[tenant] = tenantClient
+		}
 	}
-	defer redisClient.Close()
 
 	// Initialize services
-	apiKeyService := services.NewAPIKeyService(db)
-	rateLimitService := services.NewRateLimitService(redisClient, cfg.RateLimitConfig)
+	keyHashing, err := services.NewKeyHashing(cfg.KeyHashAlgorithm, cfg.KeyHashPepper)
+	if err != nil {
+		logger.Fatal("Invalid API key hashing configuration", zap.Error(err))
+	}
+	apiKeyOptions := []services.APIKeyServiceOption{
+		services.WithKeyHashing(keyHashing),
+		services.WithLogger(logger),
+		services.WithRetry(database.RetryPolicy(cfg.DatabaseRetry)),
+	}
+	var planOptions []services.PlanServiceOption
+	var organizationOptions []services.OrganizationServiceOption
+	var keyInvalidations *services.KeyInvalidationBroadcaster
+	if cfg.KeyCache.Size > 0 {
+		// Serve repeat validations of a key from memory
+		keyCache := services.NewKeyCache(cfg.KeyCache.Size, cfg.KeyCache.TTL)
+		// and tell the other instances about changed keys
+		var invalidator services.KeyInvalidator = keyCache
+		if redisClient != nil && cfg.KeyCache.InvalidationChannel != "" {
+			keyInvalidations = services.NewKeyInvalidationBroadcaster(keyCache, redisClient, cfg.KeyCache.InvalidationChannel)
+			invalidator = keyInvalidations
+		}
+		apiKeyOptions = append(apiKeyOptions, services.WithKeyCache(keyCache), services.WithKeyInvalidator(invalidator))
+		planOptions = append(planOptions, services.WithPlanKeyInvalidator(invalidator))
+		organizationOptions = append(organizationOptions, services.WithOrganizationKeyInvalidator(invalidator))
+	}
+	// Publish key lifecycle and limit events to NATS
+	var keyEvents events.Publishers
+	var eventBus *nats.EventBus
+	if cfg.NATS.URL != "" {
+		eventBus, err = nats.Connect(cfg.NATS, counters, logger)
+		if err != nil {
+			logger.Fatal("Failed to connect to NATS", zap.Error(err))
+		}
+		keyEvents = append(keyEvents, eventBus)
+	}
+	// and stream them, with every rate limit decision, to admin clients
+	var liveEvents *live.Hub
+	if cfg.LiveEvents.Enabled {
+		liveEvents = live.NewHub(cfg.LiveEvents.BufferSize)
+		keyEvents = append(keyEvents, liveEvents)
+	}
+	if len(keyEvents) > 0 {
+		apiKeyOptions = append(apiKeyOptions, services.WithEventPublisher(keyEvents))
+	}
+	apiKeyService := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db, keyRepositoryOptions...), apiKeyOptions...)
+	rateLimitService := services.NewRateLimitService(counters, cfg.RateLimitConfig,
+		services.WithTenants(redis.NewTenants(counters, isolatedTenants)))
+	planService := services.NewPlanService(db, planOptions...)
+
+	// Bring the declared plans and keys into the database before serving
+	if cfg.ProvisioningFile != "" {
+		file, err := provisioning.Load(cfg.ProvisioningFile)
+		if err != nil {
+			logger.Fatal("Failed to load provisioning file", zap.Error(err))
+		}
+		report, err := provisioning.Reconcile(context.Background(), file, planService, apiKeyService)
+		if err != nil {
+			logger.Fatal("Failed to provision plans and keys", zap.String("path", cfg.ProvisioningFile), zap.Error(err))
+		}
+		logger.Info("Provisioned plans and keys",
+			zap.String("path", cfg.ProvisioningFile),
+			zap.Strings("plans_created", report.PlansCreated),
+			zap.Strings("plans_updated", report.PlansUpdated),
+			zap.Strings("keys_created", report.Keys.Created),
+			zap.Strings("keys_updated", report.Keys.Updated),
+			zap.Int("unchanged", len(report.PlansUnchanged)+len(report.Keys.Unchanged)),
+		)
+	}
+
+	// Background workers run until ctx is cancelled at shutdown
+	ctx, cancel := context.WithCancel(logging.WithContext(context.Background(), logger))
+	defer cancel()
+	var workers sync.WaitGroup
+	runWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	// Check the database in the background so /readyz and database_up
+	// notice an outage; the first check runs before the server starts
+	dbHealth := database.NewHealthMonitor(db.CheckSchema, cfg.DatabaseHealthCheckInterval, 5*time.Second)
+	dbHealth.Refresh(ctx)
+	runWorker(dbHealth.Run)
+	if replicas != nil {
+		replicas.Refresh(ctx)
+		runWorker(replicas.Run)
+	}
+
+	// Jobs that must not run on several replicas at once run on the elected
+	// leader only, when leader election is enabled
+	runSingleton := runWorker
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled {
+		elector = leader.NewElector(redisClient, cfg.LeaderElection)
+		runSingleton = elector.Go
+		logger.Info("Leader election enabled", zap.String("key", cfg.LeaderElection.Key), zap.String("instance", elector.ID()))
+	}
+
+	if counterStore != nil {
+		runWorker(counterStore.Run)
+	}
+	if snapshotter != nil {
+		runWorker(snapshotter.Run)
+	}
+	if keyInvalidations != nil {
+		runWorker(keyInvalidations.Run)
+	}
+
+	if eventBus != nil {
+		// Serve counter resets sent to the control subject
+		eventBus.ServeControl(apiKeyService, rateLimitService)
+		runWorker(eventBus.Run)
+	}
+
+	// Deactivate expired keys in the background
+	expiryPublisher := append(events.Publishers{events.NewLogPublisher(logger)}, keyEvents...)
+	sweeper := services.NewExpirySweeper(db, expiryPublisher, cfg.KeyExpirySweepInterval)
+	runSingleton(sweeper.Run)
+
+	// Record key usage off the request path, flushed in batches
+	lastUsedTracker := services.NewLastUsedTracker(db, cfg.LastUsedFlushInterval)
+	runWorker(lastUsedTracker.Run)
+
+	// Count requests in Redis and persist them to Postgres periodically
+	usageService := services.NewUsageService(counters, db, cfg.UsageFlushInterval)
+	runSingleton(usageService.Run)
+
+	// Log each authenticated request to usage_logs, written in batches
+	var usageLoggers []services.UsageLogger
+	if cfg.UsageLog.Enabled {
+		usageLogWriter := services.NewUsageLogWriter(db, cfg.UsageLog.BufferSize, cfg.UsageLog.BatchSize, cfg.UsageLog.FlushInterval)
+		runWorker(usageLogWriter.Run)
+		usageLoggers = append(usageLoggers, usageLogWriter)
+
+		// and roll them up into hourly and daily totals for analytics
+		if cfg.UsageRollup.Enabled {
+			rollup := services.NewUsageRollupJob(db, cfg.UsageRollup.Interval, cfg.UsageRollup.Lookback)
+			runSingleton(rollup.Run)
+		}
+	}
+	// and stream it to Kafka for analytics
+	if len(cfg.Kafka.Brokers) > 0 {
+		usageProducer := kafka.NewProducer(cfg.Kafka)
+		runWorker(usageProducer.Run)
+		usageLoggers = append(usageLoggers, usageProducer)
+	}
+	if liveEvents != nil {
+		usageLoggers = append(usageLoggers, liveEvents)
+	}
+
+	// Generate usage reports requested through the admin API. Workers claim
+	// each report, so every replica can take part.
+	var exportService *services.ExportService
+	if cfg.UsageLog.Enabled && cfg.Exports.Enabled {
+		exportService = services.NewExportService(db, cfg.Exports)
+		runWorker(exportService.Run)
+	}
+
+	// Send signed alerts to the webhooks of keys that exceed their limits
+	var webhookService *services.WebhookService
+	if cfg.Webhooks.Enabled {
+		webhookService = services.NewWebhookService(db, counters, cfg.Webhooks)
+		runWorker(webhookService.Run)
+	}
+	var limitAlerters services.LimitAlerters
+	if webhookService != nil {
+		limitAlerters = append(limitAlerters, webhookService)
+	}
+	if eventBus != nil {
+		limitAlerters = append(limitAlerters, eventBus)
+	}
+
+	// Alert Slack and PagerDuty on failing responses, Redis outages and keys
+	// refused at a high rate
+	var monitor *alerting.Monitor
+	if cfg.Alerting.Enabled() {
+		var notifiers []alerting.Notifier
+		if cfg.Alerting.SlackWebhookURL != "" {
+			notifiers = append(notifiers, alerting.NewSlackNotifier(cfg.Alerting.SlackWebhookURL))
+		}
+		if cfg.Alerting.PagerDutyRoutingKey != "" {
+			notifiers = append(notifiers, alerting.NewPagerDutyNotifier(cfg.Alerting.PagerDutyRoutingKey))
+		}
+		// and show them on admin dashboards
+		if liveEvents != nil {
+			notifiers = append(notifiers, alerting.NewEventNotifier(liveEvents))
+		}
+		var redisCheck func(ctx context.Context) error
+		if redisClient != nil {
+			redisCheck = func(ctx context.Context) error {
+				return redisClient.Ping(ctx).Err()
+			}
+		}
+		monitor = alerting.NewMonitor(cfg.Alerting, redisCheck, notifiers...)
+		runWorker(monitor.Run)
+		limitAlerters = append(limitAlerters, monitor)
+	}
+
+	// Delete rows that have outlived their retention period
+	retention := services.NewRetentionJob(db, []services.RetentionPolicy{
+		{Table: "usage_logs", Column: "created_at", Period: cfg.Retention.UsageLogs},
+		{Table: "limit_overrides", Column: "expires_at", Period: cfg.Retention.LimitOverrides},
+		{Table: "webhook_deliveries", Column: "created_at", Period: cfg.Retention.WebhookDeliveries},
+		{Table: "usage_exports", Column: "created_at", Period: cfg.Retention.Exports},
+		{Table: "idempotency_keys", Column: "created_at", Period: cfg.Retention.IdempotencyKeys},
+	}, cfg.Retention.Interval)
+	runSingleton(retention.Run)
+	if elector != nil {
+		runWorker(elector.Run)
+	}
+
+	// Feature flags come from the configuration, optionally toggled at
+	// runtime through Redis
+	var flagStore services.FeatureFlagStore
+	if cfg.FeatureFlags.Redis && counterStore != nil {
+		flagStore = counterStore
+	} else if cfg.FeatureFlags.Redis {
+		flagStore = redisClient
+	}
+	featureFlags := services.NewFeatureFlagService(cfg.FeatureFlags.Flags, flagStore, cfg.FeatureFlags.RefreshInterval)
+	runWorker(featureFlags.Run)
+
+	// Settings that can change while running are read from the snapshot
+	snapshot := config.NewSnapshot(cfg)
+	reloadConfig := newConfigReloader(snapshot, configFile, processEnv, logLevel, rateLimitService, featureFlags)
+	runWorker(func(ctx context.Context) {
+		reloadOnSIGHUP(ctx, reloadConfig)
+	})
+
+	// The public API can be put into maintenance while the admin API and
+	// health checks keep working
+	maintenance := middleware.NewMaintenanceMode(middleware.MaintenanceState{
+		Enabled:    cfg.Maintenance.Enabled,
+		RetryAfter: cfg.Maintenance.RetryAfter,
+		Message:    cfg.Maintenance.Message,
+	})
+	if cfg.Maintenance.Enabled {
+		logger.Warn("Starting in maintenance mode, the public API answers 503")
+	}
 
 	// Initialize handlers
-	handler := handlers.NewHandler(apiKeyService, rateLimitService)
+	handlerOptions := []handlers.Option{
+		handlers.WithMaintenance(maintenance),
+		handlers.WithConfigReloader(reloadConfig),
+		handlers.WithFeatureFlags(featureFlags),
+		handlers.WithPlanService(planService),
+		handlers.WithOrganizationService(services.NewOrganizationService(db, organizationOptions...)),
+		handlers.WithUsageService(usageService),
+		handlers.WithRotationGracePeriod(cfg.KeyRotationGracePeriod),
+		handlers.WithAdminRateLimiter(rateLimitService, cfg.RateLimitConfig.Admin.ByIP),
+		handlers.WithAuthFailureTracker(rateLimitService),
+		handlers.WithIdempotency(services.NewIdempotencyService(db, cfg.Retention.IdempotencyKeys)),
+		handlers.WithLegacyRoutes(cfg.LegacyRoutes, cfg.LegacyRoutesSunset),
+		handlers.WithBuildInfo(buildInfo(cfg)),
+		handlers.WithProfiling(cfg.ProfilingEnabled),
+		handlers.WithReadinessCheck("database", dbHealth.Check),
+	}
+	if webhookService != nil {
+		handlerOptions = append(handlerOptions, handlers.WithWebhookService(webhookService))
+	}
+	// Usage analytics are aggregated from the usage logs, or their rollups
+	if cfg.UsageLog.Enabled {
+		var analyticsOptions []services.AnalyticsServiceOption
+		if cfg.UsageRollup.Enabled {
+			analyticsOptions = append(analyticsOptions, services.WithUsageRollups())
+		}
+		handlerOptions = append(handlerOptions, handlers.WithAnalyticsService(services.NewAnalyticsService(db, analyticsOptions...)))
+	}
+	if exportService != nil {
+		handlerOptions = append(handlerOptions, handlers.WithExportService(exportService))
+	}
+	if liveEvents != nil {
+		handlerOptions = append(handlerOptions,
+			handlers.WithLiveEvents(liveEvents, cfg.LiveEvents.Heartbeat),
+			handlers.WithLiveCounterInterval(cfg.LiveEvents.CounterInterval),
+		)
+	}
+	if redisClient != nil {
+		handlerOptions = append(handlerOptions, handlers.WithReadinessCheck("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}))
+	}
+	// Admin callers are authenticated the same way over REST and gRPC
+	var adminAuthenticators []middleware.AdminAuthenticator
+	var adminCredentials *middleware.ReloadableAdminCredentials
+	if len(cfg.AdminTokens) > 0 {
+		credentials, err := middleware.ParseAdminCredentials(cfg.AdminTokens)
+		if err != nil {
+			logger.Fatal("Invalid admin credentials", zap.Error(err))
+		}
+		adminCredentials = middleware.NewReloadableAdminCredentials(credentials)
+		adminAuthenticators = append(adminAuthenticators, adminCredentials)
+	}
+	if cfg.OIDC.IssuerURL != "" {
+		roleMapping, err := middleware.ParseRoleMapping(cfg.OIDC.RoleMapping)
+		if err != nil {
+			logger.Fatal("Invalid OIDC role mapping", zap.Error(err))
+		}
+		adminAuthenticators = append(adminAuthenticators, &middleware.OIDCAuthenticator{
+			Verifier:    oidc.NewVerifier(cfg.OIDC.IssuerURL, cfg.OIDC.Audience, cfg.OIDC.JWKSCacheTTL),
+			RoleClaim:   cfg.OIDC.RoleClaim,
+			RoleMapping: roleMapping,
+
+			OrganizationClaim: cfg.OIDC.OrganizationClaim,
+		})
+	}
+	if len(adminAuthenticators) == 0 {
+		logger.Warn("Neither ADMIN_TOKENS nor OIDC_ISSUER_URL is set, the admin API is unauthenticated")
+	}
+	for _, authenticator := range adminAuthenticators {
+		handlerOptions = append(handlerOptions, handlers.WithAdminAuthenticator(authenticator))
+	}
+	// Clients may trade their key for short-lived access tokens
+	var accessTokens *services.AccessTokenService
+	if cfg.AccessTokens.Secret != "" {
+		accessTokens = services.NewAccessTokenService(apiKeyService, []byte(cfg.AccessTokens.Secret), cfg.AccessTokens.TTL)
+		handlerOptions = append(handlerOptions, handlers.WithAccessTokens(accessTokens))
+	}
+	rateLimitOptions := []middleware.RateLimitOption{
+		middleware.WithSkipPaths(func() []string {
+			return snapshot.Load().RateLimitConfig.SkipPaths
+		}),
+		middleware.WithSkipMethods(func() []string {
+			return snapshot.Load().RateLimitConfig.SkipMethods
+		}),
+		middleware.WithSkipCIDRs(func() []string {
+			return snapshot.Load().RateLimitConfig.SkipCIDRs
+		}),
+		middleware.WithUniqueLimits(cfg.RateLimitConfig.UniqueLimits),
+		middleware.WithEndUserHeader(cfg.RateLimitConfig.EndUserHeader),
+		middleware.WithUsageRecorder(lastUsedTracker),
+		middleware.WithUsageCounter(usageService),
+		middleware.WithSignatureMaxSkew(cfg.SignatureMaxSkew),
+		middleware.WithAuthFailureTracker(rateLimitService),
+		middleware.WithStandardHeaders(cfg.RateLimitConfig.StandardHeaders),
+		middleware.WithFailOpen(cfg.RateLimitConfig.FailOpen),
+	}
+	if len(limitAlerters) > 0 {
+		rateLimitOptions = append(rateLimitOptions, middleware.WithLimitAlerter(limitAlerters))
+	}
+	if accessTokens != nil {
+		rateLimitOptions = append(rateLimitOptions, middleware.WithTokenValidator(accessTokens))
+	}
+	// Tokens of an existing identity provider are rate limited without keys
+	if cfg.JWT.IssuerURL != "" {
+		rateLimitOptions = append(rateLimitOptions, middleware.WithTokenValidator(&middleware.ExternalTokenValidator{
+			Verifier:      oidc.NewVerifier(cfg.JWT.IssuerURL, cfg.JWT.Audience, cfg.JWT.JWKSCacheTTL),
+			SubjectClaim:  cfg.JWT.SubjectClaim,
+			RequestsClaim: cfg.JWT.RequestsClaim,
+			WindowClaim:   cfg.JWT.WindowClaim,
+		}))
+	}
+	// Repeat GET requests are answered from Redis without being counted
+	if cfg.ResponseCache.Enabled && redisClient != nil {
+		rateLimitOptions = append(rateLimitOptions, middleware.WithResponseCache(services.NewResponseCache(redisClient, cfg.ResponseCache.TTL, cfg.ResponseCache.KeyBy, cfg.RateLimitConfig.EndUserHeader)))
+	}
+	// Only the /api endpoints and, in proxy mode, forwarded requests are
+	// rate limited; the admin API authenticates its callers itself
+	rateLimit := middleware.RateLimit(apiKeyService, rateLimitService, rateLimitOptions...)
+	handlerOptions = append(handlerOptions, handlers.WithAPIMiddleware(rateLimit))
+	handler := handlers.NewHandler(apiKeyService, rateLimitService, handlerOptions...)
+
+	// Follow credential rotations in the secrets manager
+	if secretsProvider != nil {
+		watches := secretWatches(logger, cfg.Secrets, db, redisClient, adminCredentials)
+		runWorker(func(ctx context.Context) {
+			config.WatchSecrets(ctx, secretsProvider, cfg.Secrets.RefreshInterval, watches)
+		})
+	}
+
+	// The access log goes to the service logs unless it has its own output
+	accessLog, closeAccessLog, err := openAccessLog(cfg.AccessLog)
+	if err != nil {
+		logger.Fatal("Failed to open access log", zap.Error(err))
+	}
 
 	// Setup router
-	router := gin.Default()
+	router := newRouter(cfg, logger, accessLog...)
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
 
 	// Add middleware
-	router.Use(middleware.CORS())
-	router.Use(middleware.RateLimit(apiKeyService, rateLimitService))
+	router.Use(middleware.CORSWithConfig(func() config.CORSConfig {
+		return snapshot.Load().CORS
+	}))
+	router.Use(middleware.Maintenance(maintenance))
+	if monitor != nil {
+		router.Use(middleware.RecordStatus(monitor))
+	}
+	if len(usageLoggers) > 0 {
+		router.Use(middleware.UsageLog(usageLoggers...))
+	}
+	if cfg.KeyMetrics.Labels != metrics.KeyLabelsNone {
+		keyRequests, err := metrics.NewKeyRequests(cfg.KeyMetrics.Labels, cfg.KeyMetrics.TopN, cfg.KeyMetrics.HashBuckets)
+		if err != nil {
+			logger.Fatal("Invalid key metrics settings", zap.Error(err))
+		}
+		metrics.Registry.MustRegister(keyRequests)
+		router.Use(middleware.KeyMetrics(keyRequests))
+	}
+
+	// In proxy mode, requests for paths the service doesn't serve itself
+	// are forwarded once they pass the middleware above and rate limiting
+	if cfg.Proxy.Upstream != "" {
+		forward, err := proxy.New(cfg.Proxy)
+		if err != nil {
+			logger.Fatal("Invalid proxy configuration", zap.Error(err))
+		}
+		router.NoRoute(rateLimit, forward)
+		logger.Info("Proxying to upstream", zap.String("upstream", cfg.Proxy.Upstream), zap.Duration("timeout", cfg.Proxy.Timeout))
+	}
+
+	servers, apiTLSConfig, err := newAPIServers(cfg.TLS, cfg.ListenAddresses, router)
+	if err != nil {
+		logger.Fatal("Invalid listener configuration", zap.Error(err))
+	}
+	logger.Info("Server starting", zap.Strings("addresses", cfg.ListenAddresses), zap.Bool("tls", cfg.TLS.Enabled()))
+
+	// Setup routes; the admin API gets its own listeners when configured
+	if len(cfg.AdminListener.Addresses) == 0 {
+		handler.SetupRoutes(router)
+	} else {
+		handler.SetupAPIRoutes(router)
+		adminServers, err := newAdminServers(cfg.AdminListener, cfg.TLS, newRouter(cfg, logger, accessLog...), handler)
+		if err != nil {
+			logger.Fatal("Invalid admin listener configuration", zap.Error(err))
+		}
+		servers = append(servers, adminServers...)
+		logger.Info("Admin server starting",
+			zap.Strings("addresses", cfg.AdminListener.Addresses),
+			zap.Bool("tls", cfg.AdminListener.TLSCertFile != ""),
+			zap.Bool("client_certificates", cfg.AdminListener.TLSClientCAFile != ""),
+		)
+	}
+
+	// The gRPC API gets its own listeners and shares the services above
+	var grpcServer *grpcapi.Server
+	if len(cfg.GRPC.Addresses) > 0 {
+		grpcOptions := []grpcapi.Option{
+			grpcapi.WithRotationGracePeriod(cfg.KeyRotationGracePeriod),
+			grpcapi.WithUsageRecorder(lastUsedTracker),
+			grpcapi.WithUsageCounter(usageService),
+			grpcapi.WithReflection(cfg.GRPC.Reflection),
+			grpcapi.WithAuthFailureTracker(rateLimitService),
+			grpcapi.WithTrustedProxies(cfg.TrustedProxies),
+			grpcapi.WithEnvoyDescriptors(grpcapi.EnvoyDescriptors{
+				APIKey:   cfg.GRPC.EnvoyAPIKeyDescriptor,
+				EndUser:  cfg.GRPC.EnvoyEndUserDescriptor,
+				ClientIP: cfg.GRPC.EnvoyClientIPDescriptor,
+			}),
+		}
+		for _, authenticator := range adminAuthenticators {
+			grpcOptions = append(grpcOptions, grpcapi.WithAdminAuthenticator(authenticator))
+		}
+		if len(limitAlerters) > 0 {
+			grpcOptions = append(grpcOptions, grpcapi.WithLimitAlerter(limitAlerters))
+		}
+		grpcTLSConfig, err := newGRPCTLSConfig(cfg.TLS, apiTLSConfig, cfg.GRPC.Addresses, cfg.GRPC.AllowPlaintext)
+		if err != nil {
+			logger.Fatal("Invalid gRPC listener configuration", zap.Error(err))
+		}
+		if grpcTLSConfig != nil {
+			grpcOptions = append(grpcOptions, grpcapi.WithTLS(grpcTLSConfig))
+		}
+		grpcServer = grpcapi.NewServer(apiKeyService, rateLimitService, grpcOptions...)
+		for _, address := range cfg.GRPC.Addresses {
+			servers = append(servers, server.GRPC("grpc "+address, address, grpcServer.Server))
+		}
+		logger.Info("gRPC server starting", zap.Strings("addresses", cfg.GRPC.Addresses), zap.Bool("tls", grpcTLSConfig != nil), zap.Bool("reflection", cfg.GRPC.Reflection))
+	}
+
+	// Serve until SIGTERM or SIGINT, then fail readiness and drain
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	shutdown := server.ShutdownConfig{Delay: cfg.ShutdownDelay, Timeout: cfg.ShutdownTimeout}
+	runErr := server.Run(signalCtx, shutdown, func() {
+		// A second signal terminates immediately
+		stopSignals()
+		handler.SetDraining(true)
+		// End the event streams, which would otherwise hold the servers open
+		if liveEvents != nil {
+			liveEvents.Close()
+		}
+		if grpcServer != nil {
+			grpcServer.Drain()
+		}
+		logger.Info("Shutting down", zap.Duration("delay", shutdown.Delay), zap.Duration("timeout", shutdown.Timeout))
+	}, servers...)
+
+	// In-flight requests are done; let the workers flush before the
+	// connections they use are closed
+	cancel()
+	workers.Wait()
+	if snapshotter != nil {
+		if err := snapshotter.Save(context.Background()); err != nil {
+			logger.Error("Failed to save snapshot", zap.Error(err))
+		}
+	}
+	if redisClient != nil {
+		if err := redisClient.Close(); err != nil {
+			logger.Error("Failed to close Redis connection", zap.Error(err))
+		}
+	}
+	for _, tenantClient := range tenantClients {
+		if err := tenantClient.Close(); err != nil {
+			logger.Error("Failed to close tenant Redis connection", zap.Error(err))
+		}
+	}
+	if replicas != nil {
+		if err := replicas.Close(); err != nil {
+			logger.Error("Failed to close database replica connections", zap.Error(err))
+		}
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("Failed to close database connection", zap.Error(err))
+	}
+	if err := closeAccessLog(); err != nil {
+		logger.Error("Failed to close access log", zap.Error(err))
+	}
+
+	if runErr != nil {
+		logger.Fatal("Server stopped", zap.Error(runErr))
+	}
+	logger.Info("Server stopped")
+}
+
+// newConfigReloader returns a function that re-reads .env, the environment
+// and configFile, if any, and applies the settings config.Config.Reloaded
+// lists. An invalid configuration is rejected as a whole.
+func newConfigReloader(snapshot *config.Snapshot, configFile string, processEnv map[string]bool, logLevel zap.AtomicLevel, rateLimitService *services.RateLimitService, featureFlags *services.FeatureFlagService) handlers.ConfigReloader {
+	var mu sync.Mutex
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		logger := logging.FromContext(ctx)
+
+		values, err := godotenv.Read()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Failed to reload configuration", zap.Error(err))
+			return fmt.Errorf("reading .env: %w", err)
+		}
+		for key, value := range values {
+			if !processEnv[key] {
+				os.Setenv(key, value)
+			}
+		}
+
+		loaded, err := config.LoadFile(configFile)
+		if err != nil {
+			logger.Error("Failed to reload configuration", zap.Error(err))
+			return err
+		}
+		current := snapshot.Load()
+		if loaded.Environment != current.Environment {
+			err := fmt.Errorf("APP_ENV changed from %s to %s, which needs a restart", current.Environment, loaded.Environment)
+			logger.Error("Failed to reload configuration", zap.Error(err))
+			return err
+		}
+		next := current.Reloaded(loaded)
+		level, err := logging.ParseLevel(next.LogLevel)
+		if err != nil {
+			logger.Error("Failed to reload configuration", zap.Error(err))
+			return err
+		}
+
+		logLevel.SetLevel(level.Level())
+		rateLimitService.SetConfig(next.RateLimitConfig)
+		featureFlags.SetDefaults(next.FeatureFlags.Flags)
+		snapshot.Store(next)
+		logger.Info("Configuration reloaded", zap.String("log_level", next.LogLevel))
+		return nil
+	}
+}
+
+// reloadOnSIGHUP calls reload on every SIGHUP until ctx is done
+func reloadOnSIGHUP(ctx context.Context, reload handlers.ConfigReloader) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			// Failures are logged by reload
+			reload(ctx)
+		}
+	}
+}
+
+// environmentKeys returns the names of the variables set in the process
+// environment
+func environmentKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		keys[key] = true
+	}
+	return keys
+}
+
+// newRouter returns an engine that recovers from panics, tags every request
+// with a request ID, writes an access log line for it, compresses responses
+// and limits request body sizes
+func newRouter(cfg *config.Config, logger *zap.Logger, accessLog ...middleware.AccessLogOption) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.Recovery())
+	router.Use(middleware.RequestID())
+	router.Use(middleware.AccessLog(logger, accessLog...))
+	if cfg.Compression.Enabled {
+		router.Use(middleware.Compress(cfg.Compression))
+	}
+	router.Use(middleware.BodyLimit(cfg.MaxBodyBytes, cfg.BodyLimits...))
+	return router
+}
+
+// openAccessLog returns the options sending the access log to the output
+// configured for it, if any, and a function closing that output
+func openAccessLog(cfg config.AccessLogConfig) ([]middleware.AccessLogOption, func() error, error) {
+	var output io.Writer
+	closeOutput := func() error { return nil }
+	switch cfg.Output {
+	case "":
+		return nil, closeOutput, nil
+	case "stdout":
+		output = os.Stdout
+	case "stderr":
+		output = os.Stderr
+	default:
+		file, err := logging.OpenRotatingFile(cfg.Output, int64(cfg.MaxSizeMB)<<20, cfg.RotateInterval, cfg.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		output, closeOutput = file, file.Close
+	}
+	return []middleware.AccessLogOption{middleware.WithAccessLogOutput(output, cfg.Format)}, closeOutput, nil
+}
+
+// buildInfo describes the build along with the optional features enabled by
+// configuration
+func buildInfo(cfg *config.Config) buildinfo.Info {
+	info := buildinfo.Get()
+	enabled := map[string]bool{
+		"acme":             len(cfg.TLS.ACMEDomains) > 0,
+		"admin_tokens":     len(cfg.AdminTokens) > 0,
+		"oidc":             cfg.OIDC.IssuerURL != "",
+		"alerting":         cfg.Alerting.Enabled(),
+		"admin_listener":   len(cfg.AdminListener.Addresses) > 0,
+		"admin_mtls":       cfg.AdminListener.TLSClientCAFile != "",
+		"grpc":             len(cfg.GRPC.Addresses) > 0,
+		"kafka":            len(cfg.Kafka.Brokers) > 0,
+		"leader_election":  cfg.LeaderElection.Enabled,
+		"live_events":      cfg.LiveEvents.Enabled,
+		"nats":             cfg.NATS.URL != "",
+		"proxy":            cfg.Proxy.Upstream != "",
+		"runtime_flags":    cfg.FeatureFlags.Redis,
+		"legacy_routes":    cfg.LegacyRoutes,
+		"pprof":            cfg.ProfilingEnabled,
+		"secrets_provider": cfg.Secrets.Provider != "",
+		"standard_headers": cfg.RateLimitConfig.StandardHeaders,
+		"tls":              cfg.TLS.Enabled(),
+		"unique_limits":    len(cfg.RateLimitConfig.UniqueLimits) > 0,
+		"webhooks":         cfg.Webhooks.Enabled,
+	}
+	for feature, on := range enabled {
+		if on {
+			info.Features = append(info.Features, feature)
+		}
+	}
+	sort.Strings(info.Features)
+	return info
+}
+
+// secretWatches applies rotated secrets to the running connections
+func secretWatches(logger *zap.Logger, cfg config.SecretsConfig, db *database.DB, redisClient *redis.Client, adminCredentials *middleware.ReloadableAdminCredentials) []config.SecretWatch {
+	var watches []config.SecretWatch
+	if cfg.DatabaseURLRef != "" {
+		watches = append(watches, config.SecretWatch{Ref: cfg.DatabaseURLRef, OnChange: func(value string) {
+			if err := db.UpdateURL(value); err != nil {
+				logger.Error("Failed to apply rotated DATABASE_URL", zap.Error(err))
+			}
+		}})
+	}
+	if cfg.RedisURLRef != "" && redisClient != nil {
+		watches = append(watches, config.SecretWatch{Ref: cfg.RedisURLRef, OnChange: func(value string) {
+			if err := redisClient.UpdateURL(value); err != nil {
+				logger.Error("Failed to apply rotated REDIS_URL", zap.Error(err))
+			}
+		}})
+	}
+	if cfg.AdminTokensRef != "" && adminCredentials != nil {
+		watches = append(watches, config.SecretWatch{Ref: cfg.AdminTokensRef, OnChange: func(value string) {
+			credentials, err := middleware.ParseAdminCredentials(config.SplitList(value))
+			if err != nil {
+				logger.Error("Failed to apply rotated ADMIN_TOKENS", zap.Error(err))
+				return
+			}
+			adminCredentials.Replace(credentials)
+		}})
+	}
+	return watches
+}
+
+// newAPIServers builds a server for each API listen address, serving HTTPS
+// when TLS is configured, and the ACME HTTP-01 challenge server when one is
+// needed. It also returns their TLS configuration, nil without TLS.
+func newAPIServers(cfg config.TLSConfig, addresses []string, router *gin.Engine) ([]server.Server, *tls.Config, error) {
+	var tlsConfig *tls.Config
+	var servers []server.Server
+	if cfg.Enabled() {
+		var err error
+		tlsConfig, err = server.NewTLSConfig("")
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := server.ApplyTLSPolicy(tlsConfig, cfg.MinVersion, cfg.CipherPolicy); err != nil {
+			return nil, nil, err
+		}
+
+		if len(cfg.ACMEDomains) > 0 {
+			manager := server.NewACMEManager(cfg.ACMEDomains, cfg.ACMECacheDir, cfg.ACMEEmail)
+			tlsConfig.GetCertificate = manager.GetCertificate
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, "h2", "http/1.1", acme.ALPNProto)
+			if cfg.ACMEHTTPPort != "" {
+				servers = append(servers, server.Server{
+					Name:  "acme",
+					HTTP:  &http.Server{Handler: manager.HTTPHandler(nil)},
+					Serve: server.ServeOn(":"+cfg.ACMEHTTPPort, "", ""),
+				})
+			}
+		}
+	}
+
+	for _, address := range addresses {
+		servers = append(servers, server.Server{
+			Name:  "api " + address,
+			HTTP:  &http.Server{Handler: router, TLSConfig: tlsConfig},
+			Serve: server.ServeOn(address, cfg.CertFile, cfg.KeyFile),
+		})
+	}
+	return servers, tlsConfig, nil
+}
+
+// newGRPCTLSConfig returns the TLS configuration of the gRPC listeners: the
+// API listener's, with its certificate loaded up front since gRPC doesn't
+// take certificate files. Without TLS it is nil, and plaintext is refused
+// on TCP listeners unless allowPlaintext is set.
+func newGRPCTLSConfig(cfg config.TLSConfig, apiTLSConfig *tls.Config, addresses []string, allowPlaintext bool) (*tls.Config, error) {
+	if apiTLSConfig == nil {
+		for _, address := range addresses {
+			if !strings.HasPrefix(address, "unix:") && !allowPlaintext {
+				return nil, fmt.Errorf("gRPC listener %s would send API keys in plaintext: configure TLS, or set GRPC_ALLOW_PLAINTEXT=true", address)
+			}
+		}
+		return nil, nil
+	}
+
+	tlsConfig := apiTLSConfig.Clone()
+	if cfg.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
 
-	// Setup routes
-	handler.SetupRoutes(router)
+// newAdminServers builds a server for each admin listen address
+func newAdminServers(cfg config.AdminListenerConfig, tlsPolicy config.TLSConfig, adminRouter *gin.Engine, handler *handlers.Handler) ([]server.Server, error) {
+	adminRouter.GET("/health", handler.HealthCheck)
+	adminRouter.GET("/livez", handler.Livez)
+	adminRouter.GET("/readyz", handler.Readyz)
+	adminRouter.GET("/version", handler.Version)
+	adminRouter.GET("/metrics", gin.WrapH(metrics.Handler()))
+	handler.SetupAdminRoutes(adminRouter)
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	var tlsConfig *tls.Config
+	if cfg.TLSCertFile != "" {
+		var err error
+		tlsConfig, err = server.NewTLSConfig(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := server.ApplyTLSPolicy(tlsConfig, tlsPolicy.MinVersion, tlsPolicy.CipherPolicy); err != nil {
+			return nil, err
+		}
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	var servers []server.Server
+	for _, address := range cfg.Addresses {
+		servers = append(servers, server.Server{
+			Name:  "admin " + address,
+			HTTP:  &http.Server{Handler: adminRouter, TLSConfig: tlsConfig},
+			Serve: server.ServeOn(address, cfg.TLSCertFile, cfg.TLSKeyFile),
+		})
 	}
+	return servers, nil
 }
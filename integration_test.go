@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
 	"grpc-firstls/internal/handlers"
 	"grpc-firstls/internal/middleware"
@@ -42,13 +44,13 @@ func setupIntegrationTest(t *testing.T) *IntegrationTestSetup {
 	apiKeyService := NewMockAPIKeyService()
 	rateLimitService := NewMockRateLimitService()
 
-	// Create handler
-	handler := handlers.NewHandler(apiKeyService, rateLimitService)
+	// Create handler, rate limiting the API endpoints as the server does
+	handler := handlers.NewHandler(apiKeyService, rateLimitService,
+		handlers.WithAPIMiddleware(middleware.RateLimit(apiKeyService, rateLimitService)))
 
 	// Setup router
 	router := gin.New()
 	router.Use(middleware.CORS())
-	router.Use(middleware.RateLimit(apiKeyService, rateLimitService))
 	handler.SetupRoutes(router)
 
 	return &IntegrationTestSetup{
@@ -75,11 +77,11 @@ func NewMockAPIKeyService() *MockAPIKeyService {
 	}
 }
 
-func (m *MockAPIKeyService) ValidateAPIKey(apiKey string) (*database.APIKey, error) {
+func (m *MockAPIKeyService) ValidateAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
 	// Check if the API key exists in our mock storage
 	if storedKey, exists := m.apiKeys[apiKey]; exists {
 		if !storedKey.IsActive {
-			return nil, fmt.Errorf("API key is inactive")
+			return nil, fmt.Errorf("%w: API key is inactive", services.ErrInvalidAPIKey)
 		}
 		return storedKey, nil
 	}
@@ -97,10 +99,10 @@ func (m *MockAPIKeyService) ValidateAPIKey(apiKey string) (*database.APIKey, err
 			UpdatedAt:              time.Now(),
 		}, nil
 	}
-	return nil, fmt.Errorf("invalid API key")
+	return nil, services.ErrInvalidAPIKey
 }
 
-func (m *MockAPIKeyService) CreateAPIKey(name string, rateLimitRequests int, rateLimitWindowSeconds int) (string, error) {
+func (m *MockAPIKeyService) CreateAPIKey(ctx context.Context, params services.CreateAPIKeyParams) (string, error) {
 	// Generate a mock API key
 	apiKey := fmt.Sprintf("ak_%d_%x", time.Now().Unix(), time.Now().UnixNano())
 
@@ -108,21 +110,115 @@ func (m *MockAPIKeyService) CreateAPIKey(name string, rateLimitRequests int, rat
 	m.apiKeys[apiKey] = &database.APIKey{
 		ID:                     fmt.Sprintf("id_%d", time.Now().UnixNano()),
 		KeyHash:                "mock-hash",
-		Name:                   name,
-		RateLimitRequests:      rateLimitRequests,
-		RateLimitWindowSeconds: rateLimitWindowSeconds,
-		IsActive:               true,
-		CreatedAt:              time.Now(),
-		UpdatedAt:              time.Now(),
+		KeyPrefix:              services.KeyPrefix(apiKey),
+		Name:                   params.Name,
+		RateLimitRequests:      params.RateLimitRequests,
+		RateLimitWindowSeconds: params.RateLimitWindowSeconds,
+		PlanID:                 params.PlanID,
+
+		EndUserLimitRequests:      params.EndUserLimitRequests,
+		EndUserLimitWindowSeconds: params.EndUserLimitWindowSeconds,
+		IsActive:                  true,
+		CreatedAt:                 time.Now(),
+		UpdatedAt:                 time.Now(),
 	}
 
 	return apiKey, nil
 }
 
-func (m *MockAPIKeyService) DeactivateAPIKey(apiKey string) error {
+func (m *MockAPIKeyService) CreateAPIKeys(ctx context.Context, params []services.CreateAPIKeyParams) ([]string, error) {
+	apiKeys := make([]string, len(params))
+	for i := range params {
+		apiKeys[i], _ = m.CreateAPIKey(ctx, params[i])
+	}
+	return apiKeys, nil
+}
+
+func (m *MockAPIKeyService) ListAPIKeys(ctx context.Context, filter services.APIKeyFilter) ([]*database.APIKey, error) {
+	apiKeys := []*database.APIKey{}
+	for _, storedKey := range m.apiKeys {
+		if filter.Owner != "" && !strings.EqualFold(storedKey.OwnerName, filter.Owner) && !strings.EqualFold(storedKey.OwnerEmail, filter.Owner) {
+			continue
+		}
+		apiKeys = append(apiKeys, storedKey)
+	}
+	return apiKeys, nil
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyOwner(ctx context.Context, apiKey string, ownerName string, ownerEmail string) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	storedKey.OwnerName = ownerName
+	storedKey.OwnerEmail = ownerEmail
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) RenameAPIKey(ctx context.Context, apiKey string, name string) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	storedKey.Name = name
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyAlertThresholds(ctx context.Context, apiKey string, thresholds []int64) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	storedKey.AlertThresholds = thresholds
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyRefundLimit(ctx context.Context, apiKey string, limit int) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	storedKey.RefundLimit = limit
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyLimitResponse(ctx context.Context, apiKey string, response *database.LimitResponse) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	storedKey.LimitResponse = response
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) ListSubKeys(ctx context.Context, parentID string) ([]*database.APIKey, error) {
+	apiKeys := []*database.APIKey{}
+	for _, storedKey := range m.apiKeys {
+		if storedKey.ParentID == parentID {
+			apiKeys = append(apiKeys, storedKey)
+		}
+	}
+	return apiKeys, nil
+}
+
+func (m *MockAPIKeyService) GetAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	if storedKey, exists := m.apiKeys[apiKey]; exists {
+		return storedKey, nil
+	}
+	for _, storedKey := range m.apiKeys {
+		if storedKey.ID == apiKey {
+			return storedKey, nil
+		}
+	}
+	return nil, services.ErrAPIKeyNotFound
+}
+
+func (m *MockAPIKeyService) DeleteAPIKey(ctx context.Context, apiKey string) error {
 	// Check if the API key exists in our mock storage
 	if storedKey, exists := m.apiKeys[apiKey]; exists {
+		deletedAt := time.Now()
 		storedKey.IsActive = false
+		storedKey.DeletedAt = &deletedAt
 		return nil
 	}
 
@@ -130,6 +226,73 @@ func (m *MockAPIKeyService) DeactivateAPIKey(apiKey string) error {
 	return nil
 }
 
+func (m *MockAPIKeyService) RestoreAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	storedKey, err := m.GetAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if storedKey.DeletedAt == nil {
+		return nil, services.ErrAPIKeyNotFound
+	}
+	storedKey.IsActive = true
+	storedKey.DeletedAt = nil
+	return storedKey, nil
+}
+
+func (m *MockAPIKeyService) PurgeAPIKey(ctx context.Context, apiKey string) (string, error) {
+	storedKey, exists := m.apiKeys[apiKey]
+	if !exists {
+		return "", services.ErrAPIKeyNotFound
+	}
+
+	delete(m.apiKeys, apiKey)
+	return storedKey.ID, nil
+}
+
+func (m *MockAPIKeyService) CreateLimitOverride(ctx context.Context, apiKey string, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	storedKey, exists := m.apiKeys[apiKey]
+	if !exists {
+		return nil, services.ErrAPIKeyNotFound
+	}
+
+	storedKey.OverrideRequests = rateLimitRequests
+	storedKey.OverrideExpiresAt = &expiresAt
+
+	return &database.LimitOverride{
+		ID:                fmt.Sprintf("override_%d", time.Now().UnixNano()),
+		APIKeyID:          storedKey.ID,
+		RateLimitRequests: rateLimitRequests,
+		ExpiresAt:         expiresAt,
+		CreatedAt:         time.Now(),
+	}, nil
+}
+
+func (m *MockAPIKeyService) RotateAPIKey(ctx context.Context, apiKey string, gracePeriod time.Duration) (*services.RotatedAPIKey, error) {
+	storedKey, exists := m.apiKeys[apiKey]
+	if !exists {
+		return nil, services.ErrAPIKeyNotFound
+	}
+
+	// Both secrets map to the same record; the grace period isn't simulated
+	newAPIKey := fmt.Sprintf("ak_%d_%x", time.Now().Unix(), time.Now().UnixNano())
+	m.apiKeys[newAPIKey] = storedKey
+
+	return &services.RotatedAPIKey{
+		ID:                   storedKey.ID,
+		APIKey:               newAPIKey,
+		KeyPrefix:            services.KeyPrefix(newAPIKey),
+		PreviousKeyExpiresAt: time.Now().Add(gracePeriod),
+	}, nil
+}
+
+func (m *MockAPIKeyService) ExportAPIKeys(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	return []*database.ExportedAPIKey{}, nil
+}
+
+func (m *MockAPIKeyService) ImportAPIKeys(ctx context.Context, keys []*database.ExportedAPIKey, dryRun bool) (*services.ImportReport, error) {
+	return &services.ImportReport{DryRun: dryRun, Skipped: []string{}, Errors: []services.ImportError{}}, nil
+}
+
 // MockRateLimitService for integration testing
 type MockRateLimitService struct {
 	counters map[string]int64
@@ -179,6 +342,147 @@ func (m *MockRateLimitService) GetRateLimitStatus(ctx context.Context, apiKey *d
 	}, nil
 }
 
+func (m *MockRateLimitService) CheckEndUserLimit(ctx context.Context, apiKey *database.APIKey, endUserID string) (*services.RateLimitResult, error) {
+	key := fmt.Sprintf("rate_limit:%s:user:%s", apiKey.ID, endUserID)
+	m.counters[key]++
+
+	limit := int64(apiKey.EndUserLimitRequests)
+	remaining := limit - m.counters[key]
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &services.RateLimitResult{
+		Allowed:   limit <= 0 || m.counters[key] <= limit,
+		Remaining: remaining,
+		ResetTime: time.Now().Add(time.Duration(apiKey.RateLimitWindowSeconds) * time.Second),
+		Limit:     limit,
+	}, nil
+}
+
+func (m *MockRateLimitService) CheckOrganizationLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	key := fmt.Sprintf("rate_limit:org:%s", apiKey.OrganizationID)
+	m.counters[key]++
+
+	limit := int64(apiKey.OrganizationLimitRequests)
+	remaining := limit - m.counters[key]
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &services.RateLimitResult{
+		Allowed:   limit <= 0 || m.counters[key] <= limit,
+		Remaining: remaining,
+		ResetTime: time.Now().Add(time.Duration(apiKey.OrganizationLimitWindowSeconds) * time.Second),
+		Limit:     limit,
+	}, nil
+}
+
+func (m *MockRateLimitService) CheckTokenLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	key := fmt.Sprintf("rate_limit:%s:token:%s", apiKey.ID, apiKey.TokenID)
+	m.counters[key]++
+
+	limit := int64(apiKey.TokenLimitRequests)
+	remaining := limit - m.counters[key]
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &services.RateLimitResult{
+		Allowed:   limit <= 0 || m.counters[key] <= limit,
+		Remaining: remaining,
+		ResetTime: time.Now().Add(time.Duration(apiKey.RateLimitWindowSeconds) * time.Second),
+		Limit:     limit,
+	}, nil
+}
+
+func (m *MockRateLimitService) CheckUniqueLimit(ctx context.Context, apiKey *database.APIKey, rule config.UniqueLimitRule, value string) (*services.RateLimitResult, error) {
+	return &services.RateLimitResult{
+		Allowed:   true,
+		Remaining: int64(rule.MaxUnique),
+		ResetTime: time.Now().Add(rule.Window),
+		Limit:     int64(rule.MaxUnique),
+	}, nil
+}
+
+func (m *MockRateLimitService) RefundRateLimit(ctx context.Context, apiKey *database.APIKey, units int64) (*services.RefundResult, error) {
+	if apiKey.RefundLimit <= 0 {
+		return nil, services.ErrRefundsNotAllowed
+	}
+	key := fmt.Sprintf("rate_limit:%s", apiKey.ID)
+	refunded := units
+	if refunded > m.counters[key] {
+		refunded = m.counters[key]
+	}
+	m.counters[key] -= refunded
+
+	status, err := m.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &services.RefundResult{Refunded: refunded, Status: status}, nil
+}
+
+func (m *MockRateLimitService) ReserveRateLimit(ctx context.Context, apiKey *database.APIKey, units int64, ttl time.Duration) (*services.ReservationResult, error) {
+	if units > int64(apiKey.RateLimitRequests) {
+		return nil, services.ErrReservationTooLarge
+	}
+	key := fmt.Sprintf("rate_limit:%s", apiKey.ID)
+	if m.counters[key]+units > int64(apiKey.RateLimitRequests) {
+		status, err := m.GetRateLimitStatus(ctx, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return &services.ReservationResult{Status: status}, nil
+	}
+	m.counters[key] += units
+
+	reservation := &services.Reservation{ID: fmt.Sprintf("rsv_%d", len(m.counters)), Units: units, ExpiresAt: time.Now().Add(ttl)}
+	m.counters[fmt.Sprintf("rate_limit:%s:reservations:%s", apiKey.ID, reservation.ID)] = units
+	status, err := m.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &services.ReservationResult{Reservation: reservation, Status: status}, nil
+}
+
+func (m *MockRateLimitService) CommitReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	return m.settleReservation(ctx, apiKey, id, false)
+}
+
+func (m *MockRateLimitService) CancelReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	return m.settleReservation(ctx, apiKey, id, true)
+}
+
+func (m *MockRateLimitService) settleReservation(ctx context.Context, apiKey *database.APIKey, id string, release bool) (*services.SettledReservation, error) {
+	reservationKey := fmt.Sprintf("rate_limit:%s:reservations:%s", apiKey.ID, id)
+	units, ok := m.counters[reservationKey]
+	if !ok {
+		return nil, services.ErrReservationNotFound
+	}
+	delete(m.counters, reservationKey)
+	if release {
+		m.counters[fmt.Sprintf("rate_limit:%s", apiKey.ID)] -= units
+	}
+
+	status, err := m.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &services.SettledReservation{Units: units, Status: status}, nil
+}
+
+func (m *MockRateLimitService) ClearKeyState(ctx context.Context, apiKeyID string) (int64, error) {
+	var deleted int64
+	for key := range m.counters {
+		if strings.Contains(key, apiKeyID) {
+			delete(m.counters, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
 func TestIntegration_CreateAPIKeyAndUseIt(t *testing.T) {
 	setup := setupIntegrationTest(t)
 
@@ -370,14 +674,14 @@ func TestIntegration_CompleteWorkflow(t *testing.T) {
 	assert.Equal(t, "Request processed successfully", testResponse["message"])
 	assert.Equal(t, "Integration test message", testResponse["echo"])
 
-	// Step 6: Deactivate API key
+	// Step 6: Delete API key
 	req, _ = http.NewRequest("DELETE", "/admin/api-keys/"+apiKey, nil)
 	w = httptest.NewRecorder()
 	setup.Router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	// Step 7: Try to use deactivated API key
+	// Step 7: Try to use deleted API key
 	req, _ = http.NewRequest("GET", "/api/status", nil)
 	req.Header.Set("X-API-Key", apiKey)
 	w = httptest.NewRecorder()
@@ -385,6 +689,21 @@ func TestIntegration_CompleteWorkflow(t *testing.T) {
 
 	// Should fail with invalid API key
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Step 8: Restore API key, which then works again
+	req, _ = http.NewRequest("POST", "/admin/api-keys/"+apiKey+"/restore", nil)
+	w = httptest.NewRecorder()
+	setup.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/api/status", nil)
+	req.Header.Set("X-API-Key", apiKey)
+	w = httptest.NewRecorder()
+	setup.Router.ServeHTTP(w, req)
+
+	// Authenticated again, with its limit still used up by the steps above
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
 }
 
 func TestIntegration_ErrorHandling(t *testing.T) {
@@ -0,0 +1,300 @@
+// Package alerting notifies Slack and PagerDuty when the service is
+// unhealthy: too many failing responses, Redis unreachable, or a key being
+// refused at a high rate. Keys reaching their alert thresholds are reported
+// as warnings too.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/services"
+
+	"go.uber.org/zap"
+)
+
+// Alert severities, as PagerDuty names them
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Keys of the alerts the monitor raises; key breach alerts are keyed by
+// KeyBreachAlert followed by the API key ID, and threshold alerts by
+// ThresholdAlert followed by the API key ID and the threshold
+const (
+	ErrorRateAlert = "error_rate"
+	RedisAlert     = "redis_unreachable"
+	KeyBreachAlert = "key_breach:"
+	ThresholdAlert = "key_threshold:"
+)
+
+// redisCheckTimeout bounds the Redis check of each interval
+const redisCheckTimeout = 5 * time.Second
+
+// Alert is a condition worth waking someone for, or its resolution
+type Alert struct {
+	// Identifies the condition, so notifications about it are deduplicated
+	Key      string
+	Severity string
+	Summary  string
+	Details  map[string]interface{}
+	Resolved bool
+	// Notices are one-off warnings that are never resolved, so they don't
+	// open incidents
+	Notice bool
+}
+
+// alertState tracks the notifications of a condition between checks
+type alertState struct {
+	// Whether the last notification sent was a trigger that hasn't been
+	// resolved yet
+	open       bool
+	notifiedAt time.Time
+}
+
+// Monitor counts responses and limit refusals as they happen, and checks
+// them, along with Redis, against the thresholds of AlertingConfig on every
+// interval. A condition is notified when it starts and again at most once
+// per cooldown while it lasts, and resolved when it clears; a condition that
+// returns within the cooldown of its last notification stays quiet.
+type Monitor struct {
+	cfg        config.AlertingConfig
+	notifiers  []Notifier
+	redisCheck func(ctx context.Context) error
+
+	mu            sync.Mutex
+	requests      int
+	failures      int
+	breaches      map[string]int
+	thresholds    map[string]Alert
+	redisFailures int
+	alerts        map[string]*alertState
+}
+
+// NewMonitor sends alerts to notifiers. redisCheck, when not nil, is called
+// on every interval to tell whether Redis is reachable.
+func NewMonitor(cfg config.AlertingConfig, redisCheck func(ctx context.Context) error, notifiers ...Notifier) *Monitor {
+	return &Monitor{
+		cfg:        cfg,
+		notifiers:  notifiers,
+		redisCheck: redisCheck,
+		breaches:   map[string]int{},
+		thresholds: map[string]Alert{},
+		alerts:     map[string]*alertState{},
+	}
+}
+
+// RecordStatus counts a response towards the error rate
+func (m *Monitor) RecordStatus(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	if status >= 500 {
+		m.failures++
+	}
+}
+
+// NotifyLimitExceeded counts a refused request towards its key's breaches,
+// or queues a warning for a key that reached one of its alert thresholds
+func (m *Monitor) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *services.RateLimitResult) error {
+	if eventType == events.LimitThresholdReached {
+		alert := thresholdAlert(apiKey, result)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.thresholds[alert.Key] = alert
+		return nil
+	}
+
+	if m.cfg.KeyBreaches <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.breaches[apiKey.LimitKeyID()]++
+	return nil
+}
+
+// thresholdAlert warns that a key reached one of its alert thresholds
+func thresholdAlert(apiKey *database.APIKey, result *services.RateLimitResult) Alert {
+	event := services.LimitExceededEvent(apiKey, events.LimitThresholdReached, result)
+	limit := "rate limit"
+	if result.ThresholdQuota {
+		limit = "quota"
+	}
+	details := map[string]interface{}{"api_key_id": event.APIKeyID}
+	for key, value := range event.Data {
+		details[key] = value
+	}
+	return Alert{
+		Key:      fmt.Sprintf("%s%s:%d", ThresholdAlert, event.APIKeyID, result.Threshold),
+		Severity: SeverityWarning,
+		Summary:  fmt.Sprintf("API key %s used %d%% of its %s", event.APIKeyID, result.Threshold, limit),
+		Details:  details,
+		Notice:   true,
+	}
+}
+
+// Run checks the thresholds on every interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+// check compares what was counted since the previous check with the
+// thresholds and sends the resulting notifications
+func (m *Monitor) check(ctx context.Context) {
+	m.mu.Lock()
+	requests, failures, breaches, thresholds := m.requests, m.failures, m.breaches, m.thresholds
+	m.requests, m.failures, m.breaches, m.thresholds = 0, 0, map[string]int{}, map[string]Alert{}
+	m.mu.Unlock()
+
+	// Thresholds are reached once per window, so their warnings are never
+	// resolved; a key reaching one again every window is only reminded of
+	// it once per cooldown
+	var firing []Alert
+	for _, key := range sortedAlertKeys(thresholds) {
+		firing = append(firing, thresholds[key])
+	}
+
+	if requests > 0 && requests >= m.cfg.MinRequests {
+		if rate := float64(failures) / float64(requests); rate >= m.cfg.ErrorRate {
+			firing = append(firing, Alert{
+				Key:      ErrorRateAlert,
+				Severity: SeverityCritical,
+				Summary:  fmt.Sprintf("%.1f%% of responses failed with a 5xx status in the last %s", rate*100, m.cfg.Interval),
+				Details:  map[string]interface{}{"responses": requests, "failed": failures, "threshold": m.cfg.ErrorRate},
+			})
+		}
+	}
+
+	if m.redisCheck != nil {
+		checkCtx, cancel := context.WithTimeout(ctx, redisCheckTimeout)
+		err := m.redisCheck(checkCtx)
+		cancel()
+		if err != nil {
+			m.redisFailures++
+			if m.redisFailures >= m.cfg.RedisFailures {
+				firing = append(firing, Alert{
+					Key:      RedisAlert,
+					Severity: SeverityCritical,
+					Summary:  fmt.Sprintf("Redis has been unreachable for %d checks in a row", m.redisFailures),
+					Details:  map[string]interface{}{"error": err.Error()},
+				})
+			}
+		} else {
+			m.redisFailures = 0
+		}
+	}
+
+	for keyID, refused := range breaches {
+		if refused >= m.cfg.KeyBreaches {
+			firing = append(firing, Alert{
+				Key:      KeyBreachAlert + keyID,
+				Severity: SeverityWarning,
+				Summary:  fmt.Sprintf("API key %s was refused %d requests for exceeding its limits in the last %s", keyID, refused, m.cfg.Interval),
+				Details:  map[string]interface{}{"api_key_id": keyID, "refused": refused, "threshold": m.cfg.KeyBreaches},
+			})
+		}
+	}
+
+	m.update(ctx, firing)
+}
+
+// update notifies the conditions that started or are due a reminder, and
+// resolves the ones that cleared. Notices are deduplicated the same way but
+// never resolved.
+func (m *Monitor) update(ctx context.Context, firing []Alert) {
+	now := time.Now()
+	current := make(map[string]bool, len(firing))
+	for _, alert := range firing {
+		current[alert.Key] = true
+		state := m.alerts[alert.Key]
+		if state == nil {
+			state = &alertState{}
+			m.alerts[alert.Key] = state
+		}
+		if !state.notifiedAt.IsZero() && now.Sub(state.notifiedAt) < m.cfg.Cooldown {
+			continue
+		}
+		state.open, state.notifiedAt = !alert.Notice, now
+		m.notify(ctx, alert)
+	}
+
+	for key, state := range m.alerts {
+		if current[key] {
+			continue
+		}
+		if state.open {
+			state.open = false
+			m.notify(ctx, Alert{Key: key, Summary: resolvedSummary(key), Resolved: true})
+		}
+		// Forget conditions once they can notify again, so keys that
+		// stopped breaching don't pile up
+		if now.Sub(state.notifiedAt) >= m.cfg.Cooldown {
+			delete(m.alerts, key)
+		}
+	}
+}
+
+func (m *Monitor) notify(ctx context.Context, alert Alert) {
+	for _, notifier := range m.notifiers {
+		if err := notifier.Notify(ctx, alert); err != nil {
+			metrics.AlertNotifications.WithLabelValues(notifier.Name(), "failed").Inc()
+			logging.FromContext(ctx).Error("Failed to send alert", zap.String("notifier", notifier.Name()), zap.String("alert", alert.Key), zap.Error(err))
+			continue
+		}
+		metrics.AlertNotifications.WithLabelValues(notifier.Name(), "sent").Inc()
+	}
+}
+
+func resolvedSummary(key string) string {
+	switch {
+	case key == ErrorRateAlert:
+		return "The share of 5xx responses is back below the threshold"
+	case key == RedisAlert:
+		return "Redis is reachable again"
+	case strings.HasPrefix(key, KeyBreachAlert):
+		return fmt.Sprintf("API key %s is no longer refused at a high rate", strings.TrimPrefix(key, KeyBreachAlert))
+	}
+	return key + " resolved"
+}
+
+func sortedAlertKeys(alerts map[string]Alert) []string {
+	keys := make([]string, 0, len(alerts))
+	for key := range alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(details map[string]interface{}) []string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Ensure Monitor implements services.LimitAlerter
+var _ services.LimitAlerter = (*Monitor)(nil)
@@ -0,0 +1,181 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func newTestMonitor(redisCheck func(ctx context.Context) error) (*Monitor, *recordingNotifier) {
+	notifier := &recordingNotifier{}
+	monitor := NewMonitor(config.AlertingConfig{
+		Interval:      time.Minute,
+		Cooldown:      time.Hour,
+		ErrorRate:     0.1,
+		MinRequests:   10,
+		RedisFailures: 2,
+		KeyBreaches:   3,
+	}, redisCheck, notifier)
+	return monitor, notifier
+}
+
+func TestMonitor_ErrorRate(t *testing.T) {
+	monitor, notifier := newTestMonitor(nil)
+	ctx := context.Background()
+
+	// Too few responses to judge
+	for i := 0; i < 5; i++ {
+		monitor.RecordStatus(500)
+	}
+	monitor.check(ctx)
+	assert.Empty(t, notifier.alerts)
+
+	for i := 0; i < 20; i++ {
+		monitor.RecordStatus(200)
+	}
+	for i := 0; i < 5; i++ {
+		monitor.RecordStatus(503)
+	}
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, ErrorRateAlert, notifier.alerts[0].Key)
+	assert.Equal(t, SeverityCritical, notifier.alerts[0].Severity)
+	assert.Contains(t, notifier.alerts[0].Summary, "20.0%")
+
+	for i := 0; i < 20; i++ {
+		monitor.RecordStatus(200)
+	}
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 2)
+	assert.True(t, notifier.alerts[1].Resolved)
+	assert.Equal(t, ErrorRateAlert, notifier.alerts[1].Key)
+}
+
+func TestMonitor_CooldownSilencesRepeats(t *testing.T) {
+	monitor, notifier := newTestMonitor(nil)
+	ctx := context.Background()
+	fail := func() {
+		for i := 0; i < 10; i++ {
+			monitor.RecordStatus(500)
+		}
+	}
+
+	fail()
+	monitor.check(ctx)
+	fail()
+	monitor.check(ctx)
+	assert.Len(t, notifier.alerts, 1, "still firing within the cooldown")
+
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 2)
+	assert.True(t, notifier.alerts[1].Resolved)
+
+	// Flapping back within the cooldown stays quiet, including its recovery
+	fail()
+	monitor.check(ctx)
+	monitor.check(ctx)
+	assert.Len(t, notifier.alerts, 2)
+
+	// Once the cooldown has passed, the condition is notified again
+	monitor.alerts[ErrorRateAlert].notifiedAt = time.Now().Add(-2 * time.Hour)
+	fail()
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 3)
+	assert.False(t, notifier.alerts[2].Resolved)
+}
+
+func TestMonitor_RedisFailures(t *testing.T) {
+	redisErr := errors.New("connection refused")
+	var failing bool
+	monitor, notifier := newTestMonitor(func(ctx context.Context) error {
+		if failing {
+			return redisErr
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	failing = true
+	monitor.check(ctx)
+	assert.Empty(t, notifier.alerts, "a single failed check is tolerated")
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, RedisAlert, notifier.alerts[0].Key)
+	assert.Equal(t, "connection refused", notifier.alerts[0].Details["error"])
+
+	failing = false
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 2)
+	assert.True(t, notifier.alerts[1].Resolved)
+}
+
+func TestMonitor_KeyBreaches(t *testing.T) {
+	monitor, notifier := newTestMonitor(nil)
+	ctx := context.Background()
+	result := &services.RateLimitResult{}
+
+	for i := 0; i < 3; i++ {
+		// Sub-keys count towards their parent
+		require.NoError(t, monitor.NotifyLimitExceeded(ctx, &database.APIKey{ID: "sub-key", ParentID: "parent-key"}, "rate_limit.exceeded", result))
+	}
+	require.NoError(t, monitor.NotifyLimitExceeded(ctx, &database.APIKey{ID: "quiet-key"}, "rate_limit.exceeded", result))
+	monitor.check(ctx)
+
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, KeyBreachAlert+"parent-key", notifier.alerts[0].Key)
+	assert.Equal(t, SeverityWarning, notifier.alerts[0].Severity)
+	assert.Equal(t, 3, notifier.alerts[0].Details["refused"])
+}
+
+func TestMonitor_Thresholds(t *testing.T) {
+	monitor, notifier := newTestMonitor(nil)
+	ctx := context.Background()
+	apiKey := &database.APIKey{ID: "key-1"}
+	result := &services.RateLimitResult{Allowed: true, Limit: 100, Window: time.Minute, Threshold: 80, ThresholdCrossed: true}
+
+	// Reported once per check, without counting as breaches
+	require.NoError(t, monitor.NotifyLimitExceeded(ctx, apiKey, "limit.threshold_reached", result))
+	require.NoError(t, monitor.NotifyLimitExceeded(ctx, apiKey, "limit.threshold_reached", result))
+	monitor.check(ctx)
+
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, ThresholdAlert+"key-1:80", notifier.alerts[0].Key)
+	assert.Equal(t, SeverityWarning, notifier.alerts[0].Severity)
+	assert.Equal(t, "API key key-1 used 80% of its rate limit", notifier.alerts[0].Summary)
+	assert.True(t, notifier.alerts[0].Notice)
+
+	// Notices are never resolved
+	monitor.check(ctx)
+	assert.Len(t, notifier.alerts, 1)
+
+	// A threshold reached again in the next window is quiet within the
+	// cooldown, and notified again after it
+	require.NoError(t, monitor.NotifyLimitExceeded(ctx, apiKey, "limit.threshold_reached", result))
+	monitor.check(ctx)
+	assert.Len(t, notifier.alerts, 1)
+
+	monitor.alerts[ThresholdAlert+"key-1:80"].notifiedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, monitor.NotifyLimitExceeded(ctx, apiKey, "limit.threshold_reached", result))
+	monitor.check(ctx)
+	require.Len(t, notifier.alerts, 2)
+	assert.True(t, notifier.alerts[1].Notice)
+}
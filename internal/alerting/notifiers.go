@@ -0,0 +1,170 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/events"
+)
+
+// notifyTimeout bounds one notification request
+const notifyTimeout = 10 * time.Second
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Notifier sends alerts, and their resolutions, to where people see them
+type Notifier interface {
+	// Name labels the notifier in metrics and logs
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// SlackNotifier posts alerts to a Slack incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{url: webhookURL, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+func (n *SlackNotifier) Name() string { return "slack" }
+
+func (n *SlackNotifier) Notify(ctx context.Context, alert Alert) error {
+	var text strings.Builder
+	if alert.Resolved {
+		fmt.Fprintf(&text, ":white_check_mark: *Resolved:* %s", alert.Summary)
+	} else {
+		fmt.Fprintf(&text, ":rotating_light: *[%s]* %s", alert.Severity, alert.Summary)
+		for _, name := range sortedKeys(alert.Details) {
+			fmt.Fprintf(&text, "\n• %s: %v", name, alert.Details[name])
+		}
+	}
+	return postJSON(ctx, n.client, n.url, map[string]string{"text": text.String()})
+}
+
+// PagerDutyNotifier triggers and resolves PagerDuty incidents through the
+// Events API v2. Incidents are deduplicated by the alert's key, so a
+// repeated alert adds to the open incident instead of opening another.
+// Notices are not sent.
+type PagerDutyNotifier struct {
+	routingKey string
+	url        string
+	source     string
+	client     *http.Client
+}
+
+func NewPagerDutyNotifier(routingKey string) *PagerDutyNotifier {
+	source, err := os.Hostname()
+	if err != nil {
+		source = "rate-limiter"
+	}
+	return &PagerDutyNotifier{
+		routingKey: routingKey,
+		url:        PagerDutyEventsURL,
+		source:     source,
+		client:     &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+func (n *PagerDutyNotifier) Name() string { return "pagerduty" }
+
+func (n *PagerDutyNotifier) Notify(ctx context.Context, alert Alert) error {
+	if alert.Notice {
+		return nil
+	}
+	event := map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    "rate-limiter:" + alert.Key,
+	}
+	if alert.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]interface{}{
+			"summary":        alert.Summary,
+			"source":         n.source,
+			"severity":       alert.Severity,
+			"component":      "rate-limiter",
+			"custom_details": alert.Details,
+		}
+	}
+	return postJSON(ctx, n.client, n.url, event)
+}
+
+// Types of the events an EventNotifier publishes
+const (
+	AlertTriggered = "alert.triggered"
+	AlertResolved  = "alert.resolved"
+)
+
+// EventNotifier publishes alerts as events, e.g. to the live hub so admin
+// dashboards show them as they are raised
+type EventNotifier struct {
+	publisher events.Publisher
+}
+
+func NewEventNotifier(publisher events.Publisher) *EventNotifier {
+	return &EventNotifier{publisher: publisher}
+}
+
+func (n *EventNotifier) Name() string { return "events" }
+
+func (n *EventNotifier) Notify(ctx context.Context, alert Alert) error {
+	event := events.Event{
+		Type:      AlertTriggered,
+		Timestamp: time.Now().UTC(),
+		Data: map[string]interface{}{
+			"key":     alert.Key,
+			"summary": alert.Summary,
+		},
+	}
+	if alert.Resolved {
+		event.Type = AlertResolved
+	} else {
+		event.Data["severity"] = alert.Severity
+		event.Data["details"] = alert.Details
+	}
+	// Key breaches and thresholds concern one key, so they can be followed
+	// by key ID
+	if strings.HasPrefix(alert.Key, KeyBreachAlert) {
+		event.APIKeyID = strings.TrimPrefix(alert.Key, KeyBreachAlert)
+	}
+	if strings.HasPrefix(alert.Key, ThresholdAlert) {
+		event.APIKeyID, _, _ = strings.Cut(strings.TrimPrefix(alert.Key, ThresholdAlert), ":")
+	}
+	return n.publisher.Publish(ctx, event)
+}
+
+// postJSON posts body and fails unless the response is a 2xx
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
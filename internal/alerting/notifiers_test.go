@@ -0,0 +1,113 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureServer answers every request with status and decodes its JSON body
+// into received
+func captureServer(t *testing.T, status int, received *map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		*received = nil
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(received))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var received map[string]interface{}
+	server := captureServer(t, http.StatusOK, &received)
+	notifier := NewSlackNotifier(server.URL)
+
+	require.NoError(t, notifier.Notify(context.Background(), Alert{
+		Key:      RedisAlert,
+		Severity: SeverityCritical,
+		Summary:  "Redis has been unreachable for 3 checks in a row",
+		Details:  map[string]interface{}{"error": "connection refused"},
+	}))
+	assert.Contains(t, received["text"], "*[critical]* Redis has been unreachable")
+	assert.Contains(t, received["text"], "error: connection refused")
+
+	require.NoError(t, notifier.Notify(context.Background(), Alert{Key: RedisAlert, Summary: "Redis is reachable again", Resolved: true}))
+	assert.Contains(t, received["text"], "*Resolved:* Redis is reachable again")
+}
+
+func TestSlackNotifier_Failure(t *testing.T) {
+	var received map[string]interface{}
+	server := captureServer(t, http.StatusNotFound, &received)
+
+	err := NewSlackNotifier(server.URL).Notify(context.Background(), Alert{Key: RedisAlert})
+	assert.ErrorContains(t, err, "unexpected status 404")
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	var received map[string]interface{}
+	server := captureServer(t, http.StatusAccepted, &received)
+	notifier := NewPagerDutyNotifier("routing-key")
+	notifier.url = server.URL
+
+	require.NoError(t, notifier.Notify(context.Background(), Alert{
+		Key:      ErrorRateAlert,
+		Severity: SeverityCritical,
+		Summary:  "20.0% of responses failed",
+		Details:  map[string]interface{}{"failed": 5},
+	}))
+	assert.Equal(t, "routing-key", received["routing_key"])
+	assert.Equal(t, "trigger", received["event_action"])
+	assert.Equal(t, "rate-limiter:error_rate", received["dedup_key"])
+	payload := received["payload"].(map[string]interface{})
+	assert.Equal(t, "20.0% of responses failed", payload["summary"])
+	assert.Equal(t, "critical", payload["severity"])
+	assert.NotEmpty(t, payload["source"])
+
+	require.NoError(t, notifier.Notify(context.Background(), Alert{Key: ErrorRateAlert, Resolved: true}))
+	assert.Equal(t, "resolve", received["event_action"])
+	assert.Equal(t, "rate-limiter:error_rate", received["dedup_key"])
+	assert.NotContains(t, received, "payload")
+
+	// Notices don't page
+	received = nil
+	require.NoError(t, notifier.Notify(context.Background(), Alert{Key: ThresholdAlert + "key-1:80", Severity: SeverityWarning, Notice: true}))
+	assert.Nil(t, received)
+}
+
+// recordingPublisher keeps the events published to it
+type recordingPublisher []events.Event
+
+func (p *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	*p = append(*p, event)
+	return nil
+}
+
+func TestEventNotifier(t *testing.T) {
+	var published recordingPublisher
+	notifier := NewEventNotifier(&published)
+
+	require.NoError(t, notifier.Notify(context.Background(), Alert{
+		Key:      KeyBreachAlert + "key-1",
+		Severity: SeverityWarning,
+		Summary:  "API key key-1 was refused 50 requests",
+		Details:  map[string]interface{}{"api_key_id": "key-1", "refused": 50},
+	}))
+	require.NoError(t, notifier.Notify(context.Background(), Alert{Key: RedisAlert, Summary: "Redis is reachable again", Resolved: true}))
+
+	require.Len(t, published, 2)
+	assert.Equal(t, AlertTriggered, published[0].Type)
+	assert.Equal(t, "key-1", published[0].APIKeyID)
+	assert.Equal(t, SeverityWarning, published[0].Data["severity"])
+	assert.Equal(t, AlertResolved, published[1].Type)
+	assert.Empty(t, published[1].APIKeyID)
+	assert.Equal(t, "Redis is reachable again", published[1].Data["summary"])
+}
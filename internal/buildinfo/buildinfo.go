@@ -0,0 +1,72 @@
+// Package buildinfo describes the running build. The variables are set at
+// link time, e.g.
+//
+//	go build -ldflags "-X grpc-firstls/internal/buildinfo.Version=v1.4.0 \
+//		-X grpc-firstls/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X grpc-firstls/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+
+	// Comma separated features compiled into the build
+	Features = ""
+)
+
+// Info is the build information reported by /version
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features"`
+}
+
+// Get returns the build information. Commit and build time fall back to the
+// VCS details the Go toolchain embeds when building from a checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Features:  []string{},
+	}
+	for _, feature := range strings.Split(Features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			info.Features = append(info.Features, feature)
+		}
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		vcs := map[string]string{}
+		for _, setting := range build.Settings {
+			vcs[setting.Key] = setting.Value
+		}
+		if info.Commit == "" && vcs["vcs.revision"] != "" {
+			info.Commit = vcs["vcs.revision"]
+			if vcs["vcs.modified"] == "true" {
+				info.Commit += "-dirty"
+			}
+		}
+		if info.BuildTime == "" {
+			info.BuildTime = vcs["vcs.time"]
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
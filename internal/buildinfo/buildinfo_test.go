@@ -0,0 +1,31 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet_LinkerValues(t *testing.T) {
+	defer func(version, commit, buildTime, features string) {
+		Version, Commit, BuildTime, Features = version, commit, buildTime, features
+	}(Version, Commit, BuildTime, Features)
+
+	Version, Commit, BuildTime, Features = "v1.2.3", "abc123", "2025-01-01T00:00:00Z", "grpc, ,pprof"
+
+	info := Get()
+	assert.Equal(t, "v1.2.3", info.Version)
+	assert.Equal(t, "abc123", info.Commit)
+	assert.Equal(t, "2025-01-01T00:00:00Z", info.BuildTime)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, []string{"grpc", "pprof"}, info.Features)
+}
+
+func TestGet_Defaults(t *testing.T) {
+	info := Get()
+	assert.Equal(t, "dev", info.Version)
+	assert.NotEmpty(t, info.Commit)
+	assert.NotEmpty(t, info.BuildTime)
+	assert.NotNil(t, info.Features)
+}
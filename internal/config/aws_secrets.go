@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awsSecretsManagerService = "secretsmanager"
+	awsAmzDateFormat         = "20060102T150405Z"
+)
+
+// AWSSecretsManagerProvider reads secrets from AWS Secrets Manager with
+// static credentials (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and, for
+// temporary credentials, AWS_SESSION_TOKEN). References are secret names or
+// ARNs, optionally with a field of a JSON secret, e.g.
+// "prod/rate-limiter#database_url".
+type AWSSecretsManagerProvider struct {
+	region          string
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	httpClient      *http.Client
+	now             func() time.Time
+}
+
+func NewAWSSecretsManagerProvider(cfg AWSConfig) (*AWSSecretsManagerProvider, error) {
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("aws-secrets-manager secrets provider requires AWS_REGION")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("aws-secrets-manager secrets provider requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", cfg.Region)
+	}
+	return &AWSSecretsManagerProvider{
+		region:          cfg.Region,
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+		sessionToken:    cfg.SessionToken,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		now:             time.Now,
+	}, nil
+}
+
+// GetSecret implements SecretsProvider
+func (p *AWSSecretsManagerProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	secretID, field := splitSecretRef(ref)
+
+	payload, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+	signAWSRequest(req, payload, p.accessKeyID, p.secretAccessKey, p.region, awsSecretsManagerService, p.now())
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from secrets manager: %w", secretID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Type string `json:"__type"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = json.Unmarshal(body, &apiError)
+		return "", fmt.Errorf("unexpected status %d reading %s from secrets manager: %s", resp.StatusCode, secretID, apiError.Type)
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode secrets manager response for %s: %w", secretID, err)
+	}
+
+	value := secret.SecretString
+	if value == "" && secret.SecretBinary != "" {
+		binary, err := base64.StdEncoding.DecodeString(secret.SecretBinary)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode binary secret %s: %w", secretID, err)
+		}
+		value = string(binary)
+	}
+	return secretField(value, field)
+}
+
+// signAWSRequest adds an AWS Signature Version 4 Authorization header to req,
+// signing the host and every header already set on it
+func signAWSRequest(req *http.Request, payload []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(awsAmzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(payload),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
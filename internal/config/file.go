@@ -0,0 +1,411 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSettings maps the settings of each configuration file section to the
+// environment variable they stand in for
+var fileSettings = map[string]map[string]string{
+	"server": {
+		"environment":            "APP_ENV",
+		"gin_mode":               "GIN_MODE",
+		"port":                   "PORT",
+		"listen_addresses":       "LISTEN_ADDRESSES",
+		"trusted_proxies":        "TRUSTED_PROXIES",
+		"max_body_bytes":         "MAX_BODY_BYTES",
+		"body_limits":            "BODY_LIMITS",
+		"compression_enabled":    "COMPRESSION_ENABLED",
+		"compression_min_size":   "COMPRESSION_MIN_SIZE",
+		"compression_types":      "COMPRESSION_CONTENT_TYPES",
+		"compression_brotli":     "COMPRESSION_BROTLI",
+		"shutdown_delay":         "SHUTDOWN_DELAY",
+		"shutdown_timeout":       "SHUTDOWN_TIMEOUT",
+		"maintenance_mode":       "MAINTENANCE_MODE",
+		"maintenance_retry":      "MAINTENANCE_RETRY_AFTER",
+		"maintenance_message":    "MAINTENANCE_MESSAGE",
+		"legacy_routes":          "LEGACY_ROUTES",
+		"legacy_routes_sunset":   "LEGACY_ROUTES_SUNSET",
+		"pprof_enabled":          "PPROF_ENABLED",
+		"tls_cert_file":          "TLS_CERT_FILE",
+		"tls_key_file":           "TLS_KEY_FILE",
+		"tls_min_version":        "TLS_MIN_VERSION",
+		"tls_cipher_policy":      "TLS_CIPHER_POLICY",
+		"acme_domains":           "ACME_DOMAINS",
+		"acme_email":             "ACME_EMAIL",
+		"acme_cache_dir":         "ACME_CACHE_DIR",
+		"acme_http_port":         "ACME_HTTP_PORT",
+		"admin_port":             "ADMIN_PORT",
+		"admin_listen_addresses": "ADMIN_LISTEN_ADDRESSES",
+		"admin_tls_cert_file":    "ADMIN_TLS_CERT_FILE",
+		"admin_tls_key_file":     "ADMIN_TLS_KEY_FILE",
+		"admin_tls_client_ca":    "ADMIN_TLS_CLIENT_CA_FILE",
+	},
+	"proxy": {
+		"upstream":        "PROXY_UPSTREAM",
+		"timeout":         "PROXY_TIMEOUT",
+		"forward_api_key": "PROXY_FORWARD_API_KEY",
+		"preserve_host":   "PROXY_PRESERVE_HOST",
+	},
+	"grpc": {
+		"port":             "GRPC_PORT",
+		"listen_addresses": "GRPC_LISTEN_ADDRESSES",
+		"reflection":       "GRPC_REFLECTION",
+		"allow_plaintext":  "GRPC_ALLOW_PLAINTEXT",
+
+		"envoy_api_key_descriptor":   "GRPC_ENVOY_API_KEY_DESCRIPTOR",
+		"envoy_end_user_descriptor":  "GRPC_ENVOY_END_USER_DESCRIPTOR",
+		"envoy_client_ip_descriptor": "GRPC_ENVOY_CLIENT_IP_DESCRIPTOR",
+	},
+	"database": {
+		"url":                      "DATABASE_URL",
+		"url_secret":               "DATABASE_URL_SECRET",
+		"max_open_conns":           "DB_MAX_OPEN_CONNS",
+		"max_idle_conns":           "DB_MAX_IDLE_CONNS",
+		"conn_max_lifetime":        "DB_CONN_MAX_LIFETIME",
+		"conn_max_idle_time":       "DB_CONN_MAX_IDLE_TIME",
+		"retry_attempts":           "DB_RETRY_ATTEMPTS",
+		"retry_base_delay":         "DB_RETRY_BASE_DELAY",
+		"retry_max_delay":          "DB_RETRY_MAX_DELAY",
+		"health_check_interval":    "DB_HEALTH_CHECK_INTERVAL",
+		"replica_urls":             "DATABASE_REPLICA_URLS",
+		"replica_max_lag":          "DB_REPLICA_MAX_LAG",
+		"last_used_flush_interval": "LAST_USED_FLUSH_INTERVAL",
+		"usage_flush_interval":     "USAGE_FLUSH_INTERVAL",
+		"usage_log_enabled":        "USAGE_LOG_ENABLED",
+		"usage_log_buffer_size":    "USAGE_LOG_BUFFER_SIZE",
+		"usage_log_batch_size":     "USAGE_LOG_BATCH_SIZE",
+		"usage_log_flush_interval": "USAGE_LOG_FLUSH_INTERVAL",
+		"usage_rollup_enabled":     "USAGE_ROLLUP_ENABLED",
+		"usage_rollup_interval":    "USAGE_ROLLUP_INTERVAL",
+		"usage_rollup_lookback":    "USAGE_ROLLUP_LOOKBACK",
+		"provisioning_file":        "PROVISIONING_FILE",
+	},
+	"retention": {
+		"interval":           "RETENTION_INTERVAL",
+		"usage_logs":         "USAGE_LOG_RETENTION",
+		"limit_overrides":    "LIMIT_OVERRIDE_RETENTION",
+		"webhook_deliveries": "WEBHOOK_DELIVERY_RETENTION",
+		"exports":            "EXPORT_RETENTION",
+		"idempotency_keys":   "IDEMPOTENCY_KEY_RETENTION",
+	},
+	"kafka": {
+		"brokers":        "KAFKA_BROKERS",
+		"usage_topic":    "KAFKA_USAGE_TOPIC",
+		"client_id":      "KAFKA_CLIENT_ID",
+		"buffer_size":    "KAFKA_BUFFER_SIZE",
+		"batch_size":     "KAFKA_BATCH_SIZE",
+		"flush_interval": "KAFKA_FLUSH_INTERVAL",
+	},
+	"alerting": {
+		"slack_webhook_url":     "ALERT_SLACK_WEBHOOK_URL",
+		"pagerduty_routing_key": "ALERT_PAGERDUTY_ROUTING_KEY",
+		"interval":              "ALERT_INTERVAL",
+		"cooldown":              "ALERT_COOLDOWN",
+		"error_rate":            "ALERT_ERROR_RATE",
+		"min_requests":          "ALERT_MIN_REQUESTS",
+		"redis_failures":        "ALERT_REDIS_FAILURES",
+		"key_breaches":          "ALERT_KEY_BREACHES",
+	},
+	"nats": {
+		"url":                  "NATS_URL",
+		"credentials_file":     "NATS_CREDENTIALS_FILE",
+		"subject_prefix":       "NATS_SUBJECT_PREFIX",
+		"control_subject":      "NATS_CONTROL_SUBJECT",
+		"limit_event_throttle": "NATS_LIMIT_EVENT_THROTTLE",
+	},
+	"webhooks": {
+		"enabled":      "WEBHOOKS_ENABLED",
+		"timeout":      "WEBHOOK_TIMEOUT",
+		"max_attempts": "WEBHOOK_MAX_ATTEMPTS",
+		"throttle":     "WEBHOOK_THROTTLE",
+		"buffer_size":  "WEBHOOK_BUFFER_SIZE",
+	},
+	"exports": {
+		"enabled":       "EXPORTS_ENABLED",
+		"poll_interval": "EXPORT_POLL_INTERVAL",
+		"max_rows":      "EXPORT_MAX_ROWS",
+	},
+	"standalone": {
+		"enabled":           "STANDALONE",
+		"snapshot_file":     "STANDALONE_SNAPSHOT_FILE",
+		"snapshot_interval": "STANDALONE_SNAPSHOT_INTERVAL",
+	},
+	"redis": {
+		"url":         "REDIS_URL",
+		"url_secret":  "REDIS_URL_SECRET",
+		"namespace":   "REDIS_NAMESPACE",
+		"tenant_urls": "REDIS_TENANT_URLS",
+	},
+	"leader_election": {
+		"enabled":        "LEADER_ELECTION_ENABLED",
+		"key":            "LEADER_ELECTION_KEY",
+		"lease_ttl":      "LEADER_LEASE_TTL",
+		"renew_interval": "LEADER_RENEW_INTERVAL",
+	},
+	"response_cache": {
+		"enabled": "RESPONSE_CACHE_ENABLED",
+		"ttl":     "RESPONSE_CACHE_TTL",
+		"key_by":  "RESPONSE_CACHE_KEY_BY",
+	},
+	"key_metrics": {
+		"labels":       "KEY_METRICS_LABELS",
+		"top_n":        "KEY_METRICS_TOP_N",
+		"hash_buckets": "KEY_METRICS_HASH_BUCKETS",
+	},
+	"live_events": {
+		"enabled":          "LIVE_EVENTS_ENABLED",
+		"buffer_size":      "LIVE_EVENTS_BUFFER_SIZE",
+		"heartbeat":        "LIVE_EVENTS_HEARTBEAT",
+		"counter_interval": "LIVE_EVENTS_COUNTER_INTERVAL",
+	},
+	"rate_limit": {
+		"backend":                  "RATE_LIMIT_BACKEND",
+		"counter_cleanup_interval": "COUNTER_CLEANUP_INTERVAL",
+		"default_requests":         "DEFAULT_RATE_LIMIT_REQUESTS",
+		"default_window":           "DEFAULT_RATE_LIMIT_WINDOW",
+		"window_jitter":            "RATE_LIMIT_WINDOW_JITTER",
+		"standard_headers":         "RATE_LIMIT_STANDARD_HEADERS",
+		"fail_open":                "RATE_LIMIT_FAIL_OPEN",
+		"skip_paths":               "RATE_LIMIT_SKIP_PATHS",
+		"skip_methods":             "RATE_LIMIT_SKIP_METHODS",
+		"skip_cidrs":               "RATE_LIMIT_SKIP_CIDRS",
+		"end_user_header":          "END_USER_HEADER",
+		"unique_limits":            "UNIQUE_LIMITS",
+		"penalty_threshold":        "PENALTY_THRESHOLD",
+		"penalty_period":           "PENALTY_PERIOD",
+		"penalty_base_cooldown":    "PENALTY_BASE_COOLDOWN",
+		"penalty_max_cooldown":     "PENALTY_MAX_COOLDOWN",
+		"auth_failure_threshold":   "AUTH_FAILURE_THRESHOLD",
+		"auth_failure_period":      "AUTH_FAILURE_PERIOD",
+		"auth_lockout_duration":    "AUTH_LOCKOUT_DURATION",
+		"admin_requests":           "ADMIN_RATE_LIMIT_REQUESTS",
+		"admin_window":             "ADMIN_RATE_LIMIT_WINDOW",
+		"admin_by":                 "ADMIN_RATE_LIMIT_BY",
+	},
+	"cors": {
+		"allowed_origins":   "CORS_ALLOWED_ORIGINS",
+		"allowed_methods":   "CORS_ALLOWED_METHODS",
+		"allowed_headers":   "CORS_ALLOWED_HEADERS",
+		"allow_credentials": "CORS_ALLOW_CREDENTIALS",
+	},
+	"logging": {
+		"level":                  "LOG_LEVEL",
+		"format":                 "LOG_FORMAT",
+		"access_output":          "ACCESS_LOG_OUTPUT",
+		"access_format":          "ACCESS_LOG_FORMAT",
+		"access_max_size_mb":     "ACCESS_LOG_MAX_SIZE_MB",
+		"access_rotate_interval": "ACCESS_LOG_ROTATE_INTERVAL",
+		"access_max_backups":     "ACCESS_LOG_MAX_BACKUPS",
+	},
+	"features": {
+		"flags":            "FEATURE_FLAGS",
+		"redis":            "FEATURE_FLAGS_REDIS",
+		"refresh_interval": "FEATURE_FLAGS_REFRESH_INTERVAL",
+	},
+	"auth": {
+		"admin_tokens":                "ADMIN_TOKENS",
+		"admin_tokens_secret":         "ADMIN_TOKENS_SECRET",
+		"key_hash_algorithm":          "API_KEY_HASH_ALGORITHM",
+		"key_hash_pepper":             "API_KEY_PEPPER",
+		"key_rotation_grace_period":   "KEY_ROTATION_GRACE_PERIOD",
+		"key_expiry_sweep_interval":   "KEY_EXPIRY_SWEEP_INTERVAL",
+		"key_cache_size":              "KEY_CACHE_SIZE",
+		"key_cache_ttl":               "KEY_CACHE_TTL",
+		"key_cache_channel":           "KEY_CACHE_INVALIDATION_CHANNEL",
+		"signature_max_skew":          "SIGNATURE_MAX_SKEW",
+		"oidc_issuer_url":             "OIDC_ISSUER_URL",
+		"oidc_audience":               "OIDC_AUDIENCE",
+		"oidc_role_claim":             "OIDC_ROLE_CLAIM",
+		"oidc_role_mapping":           "OIDC_ROLE_MAPPING",
+		"oidc_organization_claim":     "OIDC_ORGANIZATION_CLAIM",
+		"oidc_jwks_cache_ttl":         "OIDC_JWKS_CACHE_TTL",
+		"access_token_secret":         "ACCESS_TOKEN_SECRET",
+		"access_token_ttl":            "ACCESS_TOKEN_TTL",
+		"jwt_issuer_url":              "JWT_ISSUER_URL",
+		"jwt_audience":                "JWT_AUDIENCE",
+		"jwt_jwks_cache_ttl":          "JWT_JWKS_CACHE_TTL",
+		"jwt_subject_claim":           "JWT_SUBJECT_CLAIM",
+		"jwt_rate_limit_claim":        "JWT_RATE_LIMIT_CLAIM",
+		"jwt_rate_limit_window_claim": "JWT_RATE_LIMIT_WINDOW_CLAIM",
+	},
+}
+
+// ruleSettings are lists of rules, which are separated by semicolons rather
+// than commas
+var ruleSettings = map[string]bool{
+	"BODY_LIMITS":   true,
+	"UNIQUE_LIMITS": true,
+}
+
+// readConfigFile parses a YAML (.yaml, .yml) or TOML (.toml) configuration
+// file into the environment variables its settings stand in for, along with
+// the settings of each profile under the "profiles" section. Unknown
+// sections, settings and profiles are rejected so typos don't go unnoticed.
+func readConfigFile(path string) (map[string]string, map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var sections map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &sections)
+	case ".toml":
+		err = toml.Unmarshal(data, &sections)
+	default:
+		return nil, nil, fmt.Errorf("config file %s: unsupported format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	profiles := map[string]map[string]string{}
+	if entries, ok := sections["profiles"]; ok {
+		delete(sections, "profiles")
+		tables, ok := entries.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("config file %s: section \"profiles\" must be a table of profiles", path)
+		}
+		for _, profile := range sortedKeys(tables) {
+			if _, ok := profileDefaults[profile]; !ok {
+				return nil, nil, fmt.Errorf("config file %s: unknown profile %q, expected dev, staging or prod", path, profile)
+			}
+			profileSections, ok := tables[profile].(map[string]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("config file %s: profile %q must be a table of sections", path, profile)
+			}
+			values, err := sectionValues(profileSections, "profiles."+profile+".")
+			if err != nil {
+				return nil, nil, fmt.Errorf("config file %s: %w", path, err)
+			}
+			if _, ok := values["APP_ENV"]; ok {
+				return nil, nil, fmt.Errorf("config file %s: profiles.%s.server.environment can't select another profile", path, profile)
+			}
+			profiles[profile] = values
+		}
+	}
+
+	values, err := sectionValues(sections, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, profiles, nil
+}
+
+// sectionValues converts sections of settings into the environment
+// variables they stand in for. prefix locates the sections in errors.
+func sectionValues(sections map[string]interface{}, prefix string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, section := range sortedKeys(sections) {
+		settings, ok := fileSettings[section]
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q", prefix+section)
+		}
+		entries, ok := sections[section].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("section %q must be a table of settings", prefix+section)
+		}
+
+		for _, name := range sortedKeys(entries) {
+			key, ok := settings[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown setting %s%s.%s", prefix, section, name)
+			}
+			separator := ","
+			if ruleSettings[key] {
+				separator = ";"
+			}
+			value, err := settingValue(entries[name], separator)
+			if err != nil {
+				return nil, fmt.Errorf("%s%s.%s: %w", prefix, section, name, err)
+			}
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// settingValue formats a setting the way its environment variable is
+// written, joining lists with separator
+func settingValue(value interface{}, separator string) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return "", fmt.Errorf("lists cannot be nested")
+			}
+			formatted, err := settingValue(item, separator)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatted)
+		}
+		return strings.Join(items, separator), nil
+	case map[string]interface{}:
+		// e.g. feature flags as name: true
+		pairs := make([]string, 0, len(v))
+		for _, name := range sortedKeys(v) {
+			formatted, err := settingValue(v[name], separator)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, name+"="+formatted)
+		}
+		return strings.Join(pairs, separator), nil
+	case fmt.Stringer:
+		// TOML local dates and times
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// fileSettingName returns the configuration file name of the setting that
+// stands in for the environment variable key, e.g. "logging.level"
+func fileSettingName(key string) string {
+	for section, settings := range fileSettings {
+		for name, settingKey := range settings {
+			if settingKey == key {
+				return section + "." + name
+			}
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadFile_YAML(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+server:
+  listen_addresses: [":8080", "unix:/run/rate-limiter.sock"]
+  max_body_bytes: 4096
+  body_limits:
+    - POST /api/test 512
+    - POST /admin/api-keys 1024
+  legacy_routes_sunset: 2027-01-31
+database:
+  url: postgres://file/rate_limiter
+rate_limit:
+  default_requests: 50
+  default_window: 1m
+  standard_headers: false
+cors:
+  allowed_origins:
+    - https://app.example.com
+logging:
+  level: debug
+auth:
+  admin_tokens: ["admin:file-token"]
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{":8080", "unix:/run/rate-limiter.sock"}, cfg.ListenAddresses)
+	assert.Equal(t, int64(4096), cfg.MaxBodyBytes)
+	assert.Len(t, cfg.BodyLimits, 2)
+	assert.Equal(t, time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC), cfg.LegacyRoutesSunset.UTC())
+	assert.Equal(t, "postgres://file/rate_limiter", cfg.DatabaseURL)
+	assert.Equal(t, 50, cfg.RateLimitConfig.DefaultRequests)
+	assert.Equal(t, time.Minute, cfg.RateLimitConfig.DefaultWindow)
+	assert.False(t, cfg.RateLimitConfig.StandardHeaders)
+	assert.Equal(t, []string{"https://app.example.com"}, cfg.CORS.AllowedOrigins)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, []string{"admin:file-token"}, cfg.AdminTokens)
+
+	// Settings missing from the file keep their defaults
+	assert.Equal(t, "redis://localhost:6379", cfg.RedisURL)
+	assert.Equal(t, 10*time.Minute, cfg.RateLimitConfig.Penalty.Period)
+	assert.Zero(t, cfg.RateLimitConfig.Penalty.Threshold, "penalties are off by default")
+}
+
+func TestLoadFile_TOML(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+[rate_limit]
+default_requests = 25
+unique_limits = ["POST /api/test header:X-Target-ID 100 1h"]
+
+[logging]
+format = "console"
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 25, cfg.RateLimitConfig.DefaultRequests)
+	assert.Len(t, cfg.RateLimitConfig.UniqueLimits, 1)
+	assert.Equal(t, "console", cfg.LogFormat)
+}
+
+func TestLoadFile_EnvironmentTakesPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `
+rate_limit:
+  default_requests: 50
+logging:
+  level: debug
+`)
+	t.Setenv("DEFAULT_RATE_LIMIT_REQUESTS", "75")
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 75, cfg.RateLimitConfig.DefaultRequests)
+	assert.Equal(t, "debug", cfg.LogLevel)
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{"unknown section", "config.yaml", "metrics:\n  enabled: true\n", `unknown section "metrics"`},
+		{"unknown setting", "config.yaml", "logging:\n  levle: debug\n", "unknown setting logging.levle"},
+		{"section is not a table", "config.yaml", "logging: debug\n", `section "logging" must be a table`},
+		{"nested list", "config.yaml", "cors:\n  allowed_origins: [[a]]\n", "lists cannot be nested"},
+		{"invalid syntax", "config.toml", "[logging\n", "config file"},
+		{"unsupported format", "config.json", "{}", `unsupported format ".json"`},
+		{"unknown profile", "config.yaml", "profiles:\n  qa:\n    logging:\n      level: debug\n", `unknown profile "qa"`},
+		{"unknown profile setting", "config.yaml", "profiles:\n  dev:\n    logging:\n      levle: debug\n", "unknown setting profiles.dev.logging.levle"},
+		{"profile selects profile", "config.yaml", "profiles:\n  dev:\n    server:\n      environment: prod\n", "can't select another profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writeConfigFile(t, tt.file, tt.content))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	_, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "reading config file")
+}
+
+func TestLoadFile_EmptyPathReadsEnvironment(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "warn")
+
+	cfg, err := LoadFile("")
+	require.NoError(t, err)
+	assert.Equal(t, "warn", cfg.LogLevel)
+}
+
+func TestLoadFile_Example(t *testing.T) {
+	cfg, err := LoadFile("../../config.example.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.RateLimitConfig.DefaultRequests)
+}
+
+func TestLoadFile_NamesInvalidFileSettings(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "rate_limit:\n  default_window: hourly\n")
+
+	_, err := LoadFile(path)
+	assert.ErrorContains(t, err, `rate_limit.default_window (DEFAULT_RATE_LIMIT_WINDOW): "hourly" is not a duration`)
+}
+
+func TestLoadFile_FeatureFlags(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+features:
+  flags:
+    shadow_mode: true
+    anonymous_tier: false
+  redis: true
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"shadow_mode": true, "anonymous_tier": false}, cfg.FeatureFlags.Flags)
+	assert.True(t, cfg.FeatureFlags.Redis)
+
+	t.Setenv("FEATURE_FLAGS", "sliding_window, shadow_mode=off")
+	_, err = LoadFile("")
+	assert.ErrorContains(t, err, `FEATURE_FLAGS: "shadow_mode=off" is not name=true or name=false`)
+
+	t.Setenv("FEATURE_FLAGS", "sliding_window, shadow_mode=false")
+	cfg, err = LoadFile("")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"sliding_window": true, "shadow_mode": false}, cfg.FeatureFlags.Flags)
+}
+
+func TestLoadFile_RedisTenants(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+redis:
+  namespace: ratelimiter
+  tenant_urls:
+    org-acme: redis://redis-acme:6379/0
+    org-globex: redis://localhost:6379/3
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "ratelimiter", cfg.RedisNamespace)
+	assert.Equal(t, map[string]string{"org-acme": "redis://redis-acme:6379/0", "org-globex": "redis://localhost:6379/3"}, cfg.RedisTenantURLs)
+
+	t.Setenv("REDIS_TENANT_URLS", "org-acme")
+	_, err = LoadFile("")
+	assert.ErrorContains(t, err, `REDIS_TENANT_URLS: "org-acme" is not tenant=url`)
+}
+
+func TestLoadFile_Standalone(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+database:
+  url: postgres://file/rate_limiter
+  replica_urls: [postgres://replica/rate_limiter]
+standalone:
+  enabled: true
+  snapshot_file: /var/lib/rate-limiter/standalone.db
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "sqlite::memory:", cfg.DatabaseURL)
+	assert.Equal(t, 1, cfg.DatabasePool.MaxOpenConns)
+	assert.Empty(t, cfg.DatabaseReplicaURLs)
+	assert.Equal(t, "postgres", cfg.RateLimitBackend)
+	assert.Equal(t, "/var/lib/rate-limiter/standalone.db", cfg.Standalone.SnapshotFile)
+	assert.Equal(t, time.Minute, cfg.Standalone.SnapshotInterval)
+}
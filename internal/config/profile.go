@@ -0,0 +1,46 @@
+package config
+
+// Profiles selected with APP_ENV
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+// profileDefaults are the defaults each profile changes, keyed by
+// environment variable. They apply only when neither the environment nor
+// the configuration file sets the variable.
+var profileDefaults = map[string]map[string]string{
+	ProfileDev: {
+		"GIN_MODE":                    "debug",
+		"LOG_FORMAT":                  "console",
+		"LOG_LEVEL":                   "debug",
+		"RATE_LIMIT_FAIL_OPEN":        "true",
+		"DEFAULT_RATE_LIMIT_REQUESTS": "1000",
+		"DEFAULT_RATE_LIMIT_WINDOW":   "1h",
+	},
+	ProfileStaging: {
+		"GIN_MODE":                    "release",
+		"LOG_FORMAT":                  "json",
+		"LOG_LEVEL":                   "debug",
+		"RATE_LIMIT_FAIL_OPEN":        "true",
+		"DEFAULT_RATE_LIMIT_REQUESTS": "100",
+		"DEFAULT_RATE_LIMIT_WINDOW":   "1h",
+	},
+	ProfileProd: {
+		"GIN_MODE":                    "release",
+		"LOG_FORMAT":                  "json",
+		"LOG_LEVEL":                   "info",
+		"RATE_LIMIT_FAIL_OPEN":        "false",
+		"DEFAULT_RATE_LIMIT_REQUESTS": "100",
+		"DEFAULT_RATE_LIMIT_WINDOW":   "1h",
+	},
+}
+
+// useProfile selects the profile named by APP_ENV, taking its defaults and
+// the configuration file's overrides for it
+func (env *source) useProfile(overrides map[string]map[string]string) {
+	env.profile = env.getEnvAsChoice("APP_ENV", ProfileProd, ProfileDev, ProfileStaging, ProfileProd)
+	env.defaults = profileDefaults[env.profile]
+	env.overrides = overrides[env.profile]
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_Profiles(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, ProfileProd, cfg.Environment)
+	assert.Equal(t, "release", cfg.GinMode)
+	assert.Equal(t, "json", cfg.LogFormat)
+	assert.False(t, cfg.RateLimitConfig.FailOpen)
+	assert.Equal(t, 100, cfg.RateLimitConfig.DefaultRequests)
+
+	t.Setenv("APP_ENV", "dev")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, ProfileDev, cfg.Environment)
+	assert.Equal(t, "debug", cfg.GinMode)
+	assert.Equal(t, "console", cfg.LogFormat)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.True(t, cfg.RateLimitConfig.FailOpen)
+	assert.Equal(t, 1000, cfg.RateLimitConfig.DefaultRequests)
+
+	// Explicit settings win over the profile's defaults
+	t.Setenv("LOG_FORMAT", "json")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, "json", cfg.LogFormat)
+
+	t.Setenv("APP_ENV", "production")
+	_, err = Load()
+	assert.ErrorContains(t, err, `APP_ENV: "production" must be one of dev, staging, prod`)
+}
+
+func TestLoadFile_ProfileOverrides(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+server:
+  environment: staging
+rate_limit:
+  default_requests: 50
+  default_window: 1m
+profiles:
+  staging:
+    rate_limit:
+      default_requests: 500
+    logging:
+      level: warn
+  prod:
+    rate_limit:
+      default_requests: 5
+`)
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, ProfileStaging, cfg.Environment)
+	assert.Equal(t, 500, cfg.RateLimitConfig.DefaultRequests)
+	assert.Equal(t, time.Minute, cfg.RateLimitConfig.DefaultWindow)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	assert.True(t, cfg.RateLimitConfig.FailOpen)
+
+	// The environment selects the profile over the file, and still
+	// overrides the profile's settings
+	t.Setenv("APP_ENV", "prod")
+	cfg, err = LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 5, cfg.RateLimitConfig.DefaultRequests)
+	assert.Equal(t, "info", cfg.LogLevel)
+
+	t.Setenv("DEFAULT_RATE_LIMIT_REQUESTS", "7")
+	cfg, err = LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7, cfg.RateLimitConfig.DefaultRequests)
+}
+
+func TestLoadFile_NamesInvalidProfileSettings(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "profiles:\n  prod:\n    rate_limit:\n      default_window: hourly\n")
+
+	_, err := LoadFile(path)
+	assert.ErrorContains(t, err, `profiles.prod.rate_limit.default_window (DEFAULT_RATE_LIMIT_WINDOW): "hourly" is not a duration`)
+}
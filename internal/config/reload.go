@@ -0,0 +1,48 @@
+package config
+
+import "sync/atomic"
+
+// Snapshot holds the current configuration so it can be replaced while the
+// server runs, e.g. on SIGHUP. Readers call Load on every use instead of
+// keeping a copy, and always see a complete configuration.
+type Snapshot struct {
+	current atomic.Pointer[Config]
+}
+
+func NewSnapshot(cfg *Config) *Snapshot {
+	s := &Snapshot{}
+	s.Store(cfg)
+	return s
+}
+
+// Load returns the current configuration, which must not be modified
+func (s *Snapshot) Load() *Config {
+	return s.current.Load()
+}
+
+// Store replaces the current configuration
+func (s *Snapshot) Store(cfg *Config) {
+	s.current.Store(cfg)
+}
+
+// Reloaded returns a copy of c with the settings that can change while the
+// server runs taken from loaded: the default rate limits, penalties, admin
+// throttle and auth failure lockout, the skip paths, methods and CIDRs, the
+// CORS policy, the configured feature flags and the log level. Everything
+// else keeps its current value and needs a restart to change.
+func (c *Config) Reloaded(loaded *Config) *Config {
+	next := *c
+	next.RateLimitConfig.DefaultRequests = loaded.RateLimitConfig.DefaultRequests
+	next.RateLimitConfig.DefaultWindow = loaded.RateLimitConfig.DefaultWindow
+	next.RateLimitConfig.Penalty = loaded.RateLimitConfig.Penalty
+	next.RateLimitConfig.Admin.Requests = loaded.RateLimitConfig.Admin.Requests
+	next.RateLimitConfig.Admin.Window = loaded.RateLimitConfig.Admin.Window
+	next.RateLimitConfig.AuthFailures = loaded.RateLimitConfig.AuthFailures
+	next.RateLimitConfig.SkipPaths = loaded.RateLimitConfig.SkipPaths
+	next.RateLimitConfig.SkipMethods = loaded.RateLimitConfig.SkipMethods
+	next.RateLimitConfig.SkipCIDRs = loaded.RateLimitConfig.SkipCIDRs
+	next.CORS = loaded.CORS
+	next.FeatureFlags.Flags = loaded.FeatureFlags.Flags
+	next.LogLevel = loaded.LogLevel
+	return &next
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_Store(t *testing.T) {
+	first := &Config{LogLevel: "info"}
+	snapshot := NewSnapshot(first)
+	assert.Same(t, first, snapshot.Load())
+
+	second := &Config{LogLevel: "debug"}
+	snapshot.Store(second)
+	assert.Same(t, second, snapshot.Load())
+}
+
+func TestConfig_Reloaded(t *testing.T) {
+	current := &Config{
+		DatabaseURL: "postgres://current",
+		RateLimitConfig: RateLimitConfig{
+			DefaultRequests: 100,
+			DefaultWindow:   time.Hour,
+			EndUserHeader:   "X-End-User-ID",
+			Admin:           AdminRateLimitConfig{Requests: 300, Window: time.Minute},
+		},
+		LogLevel: "info",
+	}
+	loaded := &Config{
+		DatabaseURL: "postgres://loaded",
+		RateLimitConfig: RateLimitConfig{
+			DefaultRequests: 50,
+			DefaultWindow:   time.Minute,
+			EndUserHeader:   "X-Customer-ID",
+			SkipPaths:       []string{"/public/*"},
+			SkipMethods:     []string{"OPTIONS"},
+			SkipCIDRs:       []string{"10.0.0.0/8"},
+			Admin:           AdminRateLimitConfig{Requests: 10, Window: time.Second, ByIP: true},
+		},
+		CORS:     CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		LogLevel: "debug",
+	}
+
+	next := current.Reloaded(loaded)
+
+	assert.Equal(t, 50, next.RateLimitConfig.DefaultRequests)
+	assert.Equal(t, time.Minute, next.RateLimitConfig.DefaultWindow)
+	assert.Equal(t, []string{"/public/*"}, next.RateLimitConfig.SkipPaths)
+	assert.Equal(t, []string{"OPTIONS"}, next.RateLimitConfig.SkipMethods)
+	assert.Equal(t, []string{"10.0.0.0/8"}, next.RateLimitConfig.SkipCIDRs)
+	assert.Equal(t, 10, next.RateLimitConfig.Admin.Requests)
+	assert.Equal(t, []string{"https://app.example.com"}, next.CORS.AllowedOrigins)
+	assert.Equal(t, "debug", next.LogLevel)
+
+	// Settings wired up at startup keep their values
+	assert.Equal(t, "postgres://current", next.DatabaseURL)
+	assert.Equal(t, "X-End-User-ID", next.RateLimitConfig.EndUserHeader)
+	assert.False(t, next.RateLimitConfig.Admin.ByIP)
+	assert.Equal(t, 100, current.RateLimitConfig.DefaultRequests)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// Supported values of SECRETS_PROVIDER
+const (
+	SecretsProviderVault             = "vault"
+	SecretsProviderAWSSecretsManager = "aws-secrets-manager"
+)
+
+// SecretsProvider fetches a secret by reference. References have the form
+// "name#field": name identifies the secret in the backing store and field
+// selects one value of a structured secret. Without a field the whole secret
+// is returned, which must then be a plain string.
+type SecretsProvider interface {
+	GetSecret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretsConfig selects where credentials are read from. Each *Ref field is a
+// secret reference that, when set, overrides the matching environment value.
+type SecretsConfig struct {
+	Provider        string
+	RefreshInterval time.Duration
+
+	DatabaseURLRef string
+	RedisURLRef    string
+	AdminTokensRef string
+
+	Vault VaultConfig
+	AWS   AWSConfig
+}
+
+type VaultConfig struct {
+	Address   string
+	Token     string
+	Namespace string
+}
+
+type AWSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Overrides the regional endpoint, e.g. for LocalStack
+	Endpoint string
+}
+
+func (env source) loadSecretsConfig() SecretsConfig {
+	return SecretsConfig{
+		Provider:        env.getEnv("SECRETS_PROVIDER", ""),
+		RefreshInterval: env.getEnvAsDuration("SECRETS_REFRESH_INTERVAL", "5m"),
+		DatabaseURLRef:  env.getEnv("DATABASE_URL_SECRET", ""),
+		RedisURLRef:     env.getEnv("REDIS_URL_SECRET", ""),
+		AdminTokensRef:  env.getEnv("ADMIN_TOKENS_SECRET", ""),
+		Vault: VaultConfig{
+			Address:   env.getEnv("VAULT_ADDR", "http://127.0.0.1:8200"),
+			Token:     env.getEnv("VAULT_TOKEN", ""),
+			Namespace: env.getEnv("VAULT_NAMESPACE", ""),
+		},
+		AWS: AWSConfig{
+			Region:          env.getEnv("AWS_REGION", env.getEnv("AWS_DEFAULT_REGION", "")),
+			AccessKeyID:     env.getEnv("AWS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: env.getEnv("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    env.getEnv("AWS_SESSION_TOKEN", ""),
+			Endpoint:        env.getEnv("AWS_SECRETS_MANAGER_ENDPOINT", ""),
+		},
+	}
+}
+
+// NewSecretsProvider returns the provider selected by cfg, or nil when no
+// provider is configured
+func NewSecretsProvider(cfg SecretsConfig) (SecretsProvider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case SecretsProviderVault:
+		return NewVaultProvider(cfg.Vault)
+	case SecretsProviderAWSSecretsManager:
+		return NewAWSSecretsManagerProvider(cfg.AWS)
+	}
+	return nil, fmt.Errorf("unsupported secrets provider %q", cfg.Provider)
+}
+
+// ResolveSecrets replaces the credentials that have a secret reference with
+// their current values from provider
+func (c *Config) ResolveSecrets(ctx context.Context, provider SecretsProvider) error {
+	for _, secret := range c.secretBindings() {
+		value, err := provider.GetSecret(ctx, secret.ref)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", secret.name, err)
+		}
+		secret.apply(value)
+	}
+	return nil
+}
+
+type secretBinding struct {
+	name  string
+	ref   string
+	apply func(value string)
+}
+
+func (c *Config) secretBindings() []secretBinding {
+	var bindings []secretBinding
+	if c.Secrets.DatabaseURLRef != "" {
+		bindings = append(bindings, secretBinding{"DATABASE_URL", c.Secrets.DatabaseURLRef, func(v string) { c.DatabaseURL = v }})
+	}
+	if c.Secrets.RedisURLRef != "" {
+		bindings = append(bindings, secretBinding{"REDIS_URL", c.Secrets.RedisURLRef, func(v string) { c.RedisURL = v }})
+	}
+	if c.Secrets.AdminTokensRef != "" {
+		bindings = append(bindings, secretBinding{"ADMIN_TOKENS", c.Secrets.AdminTokensRef, func(v string) { c.AdminTokens = SplitList(v) }})
+	}
+	return bindings
+}
+
+// SecretWatch is notified when the secret behind ref changes
+type SecretWatch struct {
+	Ref      string
+	OnChange func(value string)
+}
+
+// WatchSecrets polls provider every interval and calls OnChange for each
+// watch whose secret has changed since the previous poll, so rotated
+// credentials are picked up without a restart. It returns when ctx is done.
+func WatchSecrets(ctx context.Context, provider SecretsProvider, interval time.Duration, watches []SecretWatch) {
+	if interval <= 0 || len(watches) == 0 {
+		return
+	}
+
+	current := make([]string, len(watches))
+	for i, watch := range watches {
+		value, err := provider.GetSecret(ctx, watch.Ref)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to read secret", zap.String("ref", watch.Ref), zap.Error(err))
+		}
+		current[i] = value
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for i, watch := range watches {
+				value, err := provider.GetSecret(ctx, watch.Ref)
+				if err != nil {
+					logging.FromContext(ctx).Error("Failed to refresh secret", zap.String("ref", watch.Ref), zap.Error(err))
+					continue
+				}
+				if value != current[i] {
+					current[i] = value
+					logging.FromContext(ctx).Info("Secret changed, applying new value", zap.String("ref", watch.Ref))
+					watch.OnChange(value)
+				}
+			}
+		}
+	}
+}
+
+// splitSecretRef splits "name#field" into its parts
+func splitSecretRef(ref string) (string, string) {
+	name, field, _ := strings.Cut(ref, "#")
+	return name, field
+}
+
+// secretField extracts field from a JSON object secret, or returns the
+// secret itself when no field is requested
+func secretField(secret string, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select field %q", field)
+	}
+	return lookupField(values, field)
+}
+
+func lookupField(values map[string]interface{}, field string) (string, error) {
+	value, ok := values[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("secret field %q is not a string", field)
+	}
+	return s, nil
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSecretsProvider struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func (p *fakeSecretsProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.secrets[ref]
+	if !ok {
+		return "", assert.AnError
+	}
+	return value, nil
+}
+
+func (p *fakeSecretsProvider) set(ref, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.secrets[ref] = value
+}
+
+func TestResolveSecrets(t *testing.T) {
+	cfg := &Config{
+		DatabaseURL: "postgres://env",
+		RedisURL:    "redis://env",
+		Secrets: SecretsConfig{
+			DatabaseURLRef: "db#url",
+			AdminTokensRef: "admin",
+		},
+	}
+	provider := &fakeSecretsProvider{secrets: map[string]string{
+		"db#url": "postgres://vault",
+		"admin":  "admin:a, viewer:b",
+	}}
+
+	require.NoError(t, cfg.ResolveSecrets(context.Background(), provider))
+	assert.Equal(t, "postgres://vault", cfg.DatabaseURL)
+	assert.Equal(t, "redis://env", cfg.RedisURL)
+	assert.Equal(t, []string{"admin:a", "viewer:b"}, cfg.AdminTokens)
+
+	cfg.Secrets.RedisURLRef = "missing"
+	err := cfg.ResolveSecrets(context.Background(), provider)
+	assert.ErrorContains(t, err, "REDIS_URL")
+}
+
+func TestWatchSecrets_CallsOnChange(t *testing.T) {
+	provider := &fakeSecretsProvider{secrets: map[string]string{"db": "v1"}}
+	changes := make(chan string, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchSecrets(ctx, provider, 10*time.Millisecond, []SecretWatch{
+		{Ref: "db", OnChange: func(value string) { changes <- value }},
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	provider.set("db", "v2")
+
+	select {
+	case value := <-changes:
+		assert.Equal(t, "v2", value)
+	case <-time.After(time.Second):
+		t.Fatal("OnChange was not called after the secret changed")
+	}
+}
+
+func TestNewSecretsProvider(t *testing.T) {
+	provider, err := NewSecretsProvider(SecretsConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, provider)
+
+	_, err = NewSecretsProvider(SecretsConfig{Provider: "unknown"})
+	assert.Error(t, err)
+
+	_, err = NewSecretsProvider(SecretsConfig{Provider: SecretsProviderVault, Vault: VaultConfig{Address: "http://vault"}})
+	assert.ErrorContains(t, err, "VAULT_TOKEN")
+
+	_, err = NewSecretsProvider(SecretsConfig{Provider: SecretsProviderAWSSecretsManager, AWS: AWSConfig{Region: "us-east-1"}})
+	assert.ErrorContains(t, err, "AWS_ACCESS_KEY_ID")
+}
+
+func TestVaultProvider_GetSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/rate-limiter":
+			w.Write([]byte(`{"data":{"data":{"database_url":"postgres://kv2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/rate-limiter":
+			w.Write([]byte(`{"data":{"value":"redis://kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewVaultProvider(VaultConfig{Address: server.URL, Token: "root"})
+	require.NoError(t, err)
+
+	value, err := provider.GetSecret(context.Background(), "secret/data/rate-limiter#database_url")
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres://kv2", value)
+
+	value, err = provider.GetSecret(context.Background(), "kv/rate-limiter")
+	assert.NoError(t, err)
+	assert.Equal(t, "redis://kv1", value)
+
+	_, err = provider.GetSecret(context.Background(), "secret/data/rate-limiter#missing")
+	assert.ErrorContains(t, err, "missing")
+
+	_, err = provider.GetSecret(context.Background(), "secret/data/other#value")
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestAWSSecretsManagerProvider_GetSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request")
+		assert.Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+
+		var body struct{ SecretId string }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch body.SecretId {
+		case "prod/rate-limiter":
+			w.Write([]byte(`{"SecretString":"{\"redis_url\":\"redis://aws\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewAWSSecretsManagerProvider(AWSConfig{
+		Region:          "eu-west-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		Endpoint:        server.URL,
+	})
+	require.NoError(t, err)
+
+	value, err := provider.GetSecret(context.Background(), "prod/rate-limiter#redis_url")
+	assert.NoError(t, err)
+	assert.Equal(t, "redis://aws", value)
+
+	_, err = provider.GetSecret(context.Background(), "prod/other")
+	assert.ErrorContains(t, err, "ResourceNotFoundException")
+}
+
+// Test vector "get-vanilla" from the AWS Signature Version 4 test suite
+func TestSignAWSRequest_TestSuiteVector(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, "+
+			"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
@@ -0,0 +1,527 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/logging"
+)
+
+// ValidationError lists every problem found in a configuration, so they can
+// all be fixed at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration as a whole: URL formats, limits and
+// intervals that must be positive, and settings that need or exclude each
+// other. It returns a *ValidationError listing every problem found.
+func (c *Config) Validate() error {
+	if problems := c.validate(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// positive reports a duration that must be greater than zero
+func (p *problems) positive(key string, value time.Duration) {
+	if value <= 0 {
+		p.add("%s must be positive, got %s", key, value)
+	}
+}
+
+// notNegative reports a number that must not be negative
+func (p *problems) notNegative(key string, value int64) {
+	if value < 0 {
+		p.add("%s must not be negative, got %d", key, value)
+	}
+}
+
+func (c *Config) validate() []string {
+	var p problems
+
+	// Connections; values kept in a secrets manager are checked when used
+	if c.Secrets.DatabaseURLRef == "" {
+		p.checkURL("DATABASE_URL", c.DatabaseURL, true, "postgres", "postgresql", "mysql", "mariadb", "sqlite")
+	}
+	if c.RateLimitBackend == "redis" && c.Secrets.RedisURLRef == "" {
+		p.checkURL("REDIS_URL", c.RedisURL, false, "redis", "rediss", "unix")
+	}
+	if strings.ContainsAny(c.RedisNamespace, "*?[]\\ ") {
+		p.add("REDIS_NAMESPACE must not contain spaces or glob characters, got %q", c.RedisNamespace)
+	}
+	if len(c.RedisTenantURLs) > 0 && c.RateLimitBackend != "redis" {
+		p.add("REDIS_TENANT_URLS needs Redis (RATE_LIMIT_BACKEND=redis)")
+	}
+	tenants := make([]string, 0, len(c.RedisTenantURLs))
+	for tenant := range c.RedisTenantURLs {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	for _, tenant := range tenants {
+		p.checkURL("REDIS_TENANT_URLS "+tenant, c.RedisTenantURLs[tenant], false, "redis", "rediss", "unix")
+	}
+	if c.RateLimitBackend == "postgres" {
+		if scheme := strings.SplitN(c.DatabaseURL, ":", 2)[0]; scheme == "mysql" || scheme == "mariadb" {
+			p.add("RATE_LIMIT_BACKEND=postgres needs a Postgres or SQLite DATABASE_URL, got %s", scheme)
+		}
+		p.positive("COUNTER_CLEANUP_INTERVAL", c.CounterCleanupInterval)
+	}
+	c.validateSecrets(&p)
+	if c.Standalone.Enabled {
+		if c.Secrets.DatabaseURLRef != "" {
+			p.add("DATABASE_URL_SECRET can't be used with STANDALONE, which keeps data in memory")
+		}
+		if c.Standalone.SnapshotFile != "" {
+			p.positive("STANDALONE_SNAPSHOT_INTERVAL", c.Standalone.SnapshotInterval)
+		}
+	}
+	if election := c.LeaderElection; election.Enabled {
+		if c.RateLimitBackend != "redis" {
+			p.add("LEADER_ELECTION_ENABLED needs Redis (RATE_LIMIT_BACKEND=redis)")
+		}
+		if election.Key == "" {
+			p.add("LEADER_ELECTION_KEY must not be empty")
+		}
+		p.positive("LEADER_RENEW_INTERVAL", election.RenewInterval)
+		if election.LeaseTTL <= election.RenewInterval {
+			p.add("LEADER_LEASE_TTL (%s) must be longer than LEADER_RENEW_INTERVAL (%s)", election.LeaseTTL, election.RenewInterval)
+		}
+	}
+	if cache := c.ResponseCache; cache.Enabled {
+		if c.RateLimitBackend != "redis" {
+			p.add("RESPONSE_CACHE_ENABLED needs Redis (RATE_LIMIT_BACKEND=redis)")
+		}
+		p.positive("RESPONSE_CACHE_TTL", cache.TTL)
+		if cache.KeyBy != "url" && cache.KeyBy != "path" {
+			p.add("RESPONSE_CACHE_KEY_BY: %q must be one of url, path", cache.KeyBy)
+		}
+	}
+	pool := c.DatabasePool
+	p.notNegative("DB_MAX_OPEN_CONNS", int64(pool.MaxOpenConns))
+	p.notNegative("DB_MAX_IDLE_CONNS", int64(pool.MaxIdleConns))
+	if pool.MaxOpenConns > 0 && pool.MaxIdleConns > pool.MaxOpenConns {
+		p.add("DB_MAX_IDLE_CONNS (%d) must not be greater than DB_MAX_OPEN_CONNS (%d)", pool.MaxIdleConns, pool.MaxOpenConns)
+	}
+	if pool.ConnMaxLifetime < 0 {
+		p.add("DB_CONN_MAX_LIFETIME must not be negative, got %s", pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime < 0 {
+		p.add("DB_CONN_MAX_IDLE_TIME must not be negative, got %s", pool.ConnMaxIdleTime)
+	}
+	retry := c.DatabaseRetry
+	p.notNegative("DB_RETRY_ATTEMPTS", int64(retry.Attempts))
+	if retry.BaseDelay < 0 {
+		p.add("DB_RETRY_BASE_DELAY must not be negative, got %s", retry.BaseDelay)
+	}
+	if retry.MaxDelay < retry.BaseDelay {
+		p.add("DB_RETRY_MAX_DELAY (%s) must not be shorter than DB_RETRY_BASE_DELAY (%s)", retry.MaxDelay, retry.BaseDelay)
+	}
+	p.positive("DB_HEALTH_CHECK_INTERVAL", c.DatabaseHealthCheckInterval)
+	for _, replicaURL := range c.DatabaseReplicaURLs {
+		p.checkURL("DATABASE_REPLICA_URLS", replicaURL, true, "postgres", "postgresql", "mysql", "mariadb", "sqlite")
+		if c.Secrets.DatabaseURLRef == "" && databaseFamily(replicaURL) != databaseFamily(c.DatabaseURL) {
+			p.add("DATABASE_REPLICA_URLS must use the same database as DATABASE_URL (%s)", databaseFamily(c.DatabaseURL))
+		}
+	}
+	if len(c.DatabaseReplicaURLs) > 0 {
+		p.positive("DB_REPLICA_MAX_LAG", c.DatabaseReplicaMaxLag)
+	}
+
+	// Listeners
+	if len(c.ListenAddresses) == 0 {
+		p.add("no API listen address: set PORT or LISTEN_ADDRESSES")
+	}
+	for _, address := range c.ListenAddresses {
+		p.checkAddress("LISTEN_ADDRESSES", address)
+	}
+	for _, address := range c.AdminListener.Addresses {
+		p.checkAddress("ADMIN_LISTEN_ADDRESSES", address)
+	}
+	for _, address := range c.GRPC.Addresses {
+		p.checkAddress("GRPC_LISTEN_ADDRESSES", address)
+	}
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				p.add("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+			}
+		}
+	}
+	c.validateTLS(&p)
+	if c.Proxy.Upstream != "" {
+		if u, err := url.Parse(c.Proxy.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.add("PROXY_UPSTREAM must be an http:// or https:// URL, got %q", c.Proxy.Upstream)
+		}
+		p.positive("PROXY_TIMEOUT", c.Proxy.Timeout)
+	}
+
+	// Rate limiting
+	limits := c.RateLimitConfig
+	if limits.DefaultRequests <= 0 {
+		p.add("DEFAULT_RATE_LIMIT_REQUESTS must be positive, got %d", limits.DefaultRequests)
+	}
+	p.positive("DEFAULT_RATE_LIMIT_WINDOW", limits.DefaultWindow)
+	if limits.WindowJitter < 0 {
+		p.add("RATE_LIMIT_WINDOW_JITTER must not be negative, got %s", limits.WindowJitter)
+	}
+	p.notNegative("PENALTY_THRESHOLD", int64(limits.Penalty.Threshold))
+	if limits.Penalty.Threshold > 0 {
+		p.positive("PENALTY_PERIOD", limits.Penalty.Period)
+		p.positive("PENALTY_BASE_COOLDOWN", limits.Penalty.BaseCooldown)
+		if limits.Penalty.MaxCooldown < limits.Penalty.BaseCooldown {
+			p.add("PENALTY_MAX_COOLDOWN (%s) must not be shorter than PENALTY_BASE_COOLDOWN (%s)", limits.Penalty.MaxCooldown, limits.Penalty.BaseCooldown)
+		}
+	}
+	p.notNegative("AUTH_FAILURE_THRESHOLD", int64(limits.AuthFailures.Threshold))
+	if limits.AuthFailures.Threshold > 0 {
+		p.positive("AUTH_FAILURE_PERIOD", limits.AuthFailures.Period)
+		p.positive("AUTH_LOCKOUT_DURATION", limits.AuthFailures.Lockout)
+	}
+	p.notNegative("ADMIN_RATE_LIMIT_REQUESTS", int64(limits.Admin.Requests))
+	if limits.Admin.Requests > 0 {
+		p.positive("ADMIN_RATE_LIMIT_WINDOW", limits.Admin.Window)
+	}
+	for _, path := range limits.SkipPaths {
+		if !strings.HasPrefix(path, "/") {
+			p.add("RATE_LIMIT_SKIP_PATHS: %q must start with /", path)
+		}
+	}
+	for _, method := range limits.SkipMethods {
+		if method == "" || strings.Trim(method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			p.add("RATE_LIMIT_SKIP_METHODS: %q is not an upper-case HTTP method", method)
+		}
+	}
+	for _, cidr := range limits.SkipCIDRs {
+		if net.ParseIP(cidr) == nil {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				p.add("RATE_LIMIT_SKIP_CIDRS: %q is not an IP address or CIDR range", cidr)
+			}
+		}
+	}
+
+	// Background work and keys
+	p.positive("KEY_ROTATION_GRACE_PERIOD", c.KeyRotationGracePeriod)
+	p.positive("KEY_EXPIRY_SWEEP_INTERVAL", c.KeyExpirySweepInterval)
+	p.notNegative("KEY_CACHE_SIZE", int64(c.KeyCache.Size))
+	if c.KeyCache.Size > 0 {
+		p.positive("KEY_CACHE_TTL", c.KeyCache.TTL)
+	}
+	p.positive("LAST_USED_FLUSH_INTERVAL", c.LastUsedFlushInterval)
+	p.positive("USAGE_FLUSH_INTERVAL", c.UsageFlushInterval)
+	p.positive("SIGNATURE_MAX_SKEW", c.SignatureMaxSkew)
+	if c.UsageLog.Enabled {
+		if c.UsageLog.BufferSize < 1 {
+			p.add("USAGE_LOG_BUFFER_SIZE must be at least 1, got %d", c.UsageLog.BufferSize)
+		}
+		if c.UsageLog.BatchSize < 1 {
+			p.add("USAGE_LOG_BATCH_SIZE must be at least 1, got %d", c.UsageLog.BatchSize)
+		}
+		p.positive("USAGE_LOG_FLUSH_INTERVAL", c.UsageLog.FlushInterval)
+		if c.UsageRollup.Enabled {
+			p.positive("USAGE_ROLLUP_INTERVAL", c.UsageRollup.Interval)
+			p.positive("USAGE_ROLLUP_LOOKBACK", c.UsageRollup.Lookback)
+			// Re-aggregating hours whose logs were deleted would empty
+			// their rollups
+			if c.Retention.UsageLogs > 0 && c.UsageRollup.Lookback >= c.Retention.UsageLogs {
+				p.add("USAGE_ROLLUP_LOOKBACK must be shorter than USAGE_LOG_RETENTION, got %s and %s", c.UsageRollup.Lookback, c.Retention.UsageLogs)
+			}
+		}
+	}
+	if c.LiveEvents.Enabled {
+		if c.LiveEvents.BufferSize < 1 {
+			p.add("LIVE_EVENTS_BUFFER_SIZE must be at least 1, got %d", c.LiveEvents.BufferSize)
+		}
+		p.positive("LIVE_EVENTS_HEARTBEAT", c.LiveEvents.Heartbeat)
+		p.positive("LIVE_EVENTS_COUNTER_INTERVAL", c.LiveEvents.CounterInterval)
+	}
+	switch c.KeyMetrics.Labels {
+	case "none", "key", "plan":
+	case "top_n":
+		if c.KeyMetrics.TopN < 1 {
+			p.add("KEY_METRICS_TOP_N must be at least 1, got %d", c.KeyMetrics.TopN)
+		}
+	case "hash":
+		if c.KeyMetrics.HashBuckets < 1 {
+			p.add("KEY_METRICS_HASH_BUCKETS must be at least 1, got %d", c.KeyMetrics.HashBuckets)
+		}
+	default:
+		p.add("KEY_METRICS_LABELS: %q must be one of none, key, top_n, hash, plan", c.KeyMetrics.Labels)
+	}
+	p.positive("RETENTION_INTERVAL", c.Retention.Interval)
+	if c.Retention.UsageLogs < 0 {
+		p.add("USAGE_LOG_RETENTION must not be negative, got %s", c.Retention.UsageLogs)
+	}
+	if c.Retention.LimitOverrides < 0 {
+		p.add("LIMIT_OVERRIDE_RETENTION must not be negative, got %s", c.Retention.LimitOverrides)
+	}
+	if c.Retention.WebhookDeliveries < 0 {
+		p.add("WEBHOOK_DELIVERY_RETENTION must not be negative, got %s", c.Retention.WebhookDeliveries)
+	}
+	if c.Retention.Exports < 0 {
+		p.add("EXPORT_RETENTION must not be negative, got %s", c.Retention.Exports)
+	}
+	if c.Retention.IdempotencyKeys < 0 {
+		p.add("IDEMPOTENCY_KEY_RETENTION must not be negative, got %s", c.Retention.IdempotencyKeys)
+	}
+	if len(c.Kafka.Brokers) > 0 {
+		for _, broker := range c.Kafka.Brokers {
+			if _, _, err := net.SplitHostPort(broker); err != nil {
+				p.add("KAFKA_BROKERS: %q is not host:port", broker)
+			}
+		}
+		if c.Kafka.Topic == "" {
+			p.add("KAFKA_USAGE_TOPIC is required when KAFKA_BROKERS is set")
+		}
+		if c.Kafka.BufferSize < 1 {
+			p.add("KAFKA_BUFFER_SIZE must be at least 1, got %d", c.Kafka.BufferSize)
+		}
+		if c.Kafka.BatchSize < 1 {
+			p.add("KAFKA_BATCH_SIZE must be at least 1, got %d", c.Kafka.BatchSize)
+		}
+		p.positive("KAFKA_FLUSH_INTERVAL", c.Kafka.FlushInterval)
+	}
+	if c.Alerting.Enabled() {
+		if c.Alerting.SlackWebhookURL != "" {
+			p.checkURL("ALERT_SLACK_WEBHOOK_URL", c.Alerting.SlackWebhookURL, false, "https", "http")
+		}
+		p.positive("ALERT_INTERVAL", c.Alerting.Interval)
+		p.positive("ALERT_COOLDOWN", c.Alerting.Cooldown)
+		if c.Alerting.ErrorRate <= 0 || c.Alerting.ErrorRate > 1 {
+			p.add("ALERT_ERROR_RATE must be above 0 and at most 1, got %g", c.Alerting.ErrorRate)
+		}
+		p.notNegative("ALERT_MIN_REQUESTS", int64(c.Alerting.MinRequests))
+		if c.Alerting.RedisFailures < 1 {
+			p.add("ALERT_REDIS_FAILURES must be at least 1, got %d", c.Alerting.RedisFailures)
+		}
+		p.notNegative("ALERT_KEY_BREACHES", int64(c.Alerting.KeyBreaches))
+	}
+	if c.NATS.URL != "" {
+		for _, server := range strings.Split(c.NATS.URL, ",") {
+			p.checkURL("NATS_URL", strings.TrimSpace(server), false, "nats", "tls", "ws", "wss")
+		}
+		p.checkSubject("NATS_SUBJECT_PREFIX", c.NATS.SubjectPrefix)
+		if c.NATS.ControlSubject != "" {
+			p.checkSubject("NATS_CONTROL_SUBJECT", c.NATS.ControlSubject)
+		}
+		p.positive("NATS_LIMIT_EVENT_THROTTLE", c.NATS.LimitEventThrottle)
+	}
+	if c.Webhooks.Enabled {
+		p.positive("WEBHOOK_TIMEOUT", c.Webhooks.Timeout)
+		p.positive("WEBHOOK_THROTTLE", c.Webhooks.Throttle)
+		if c.Webhooks.MaxAttempts < 1 {
+			p.add("WEBHOOK_MAX_ATTEMPTS must be at least 1, got %d", c.Webhooks.MaxAttempts)
+		}
+		if c.Webhooks.BufferSize < 1 {
+			p.add("WEBHOOK_BUFFER_SIZE must be at least 1, got %d", c.Webhooks.BufferSize)
+		}
+	}
+	if c.Exports.Enabled {
+		p.positive("EXPORT_POLL_INTERVAL", c.Exports.PollInterval)
+		if c.Exports.MaxRows < 1 {
+			p.add("EXPORT_MAX_ROWS must be at least 1, got %d", c.Exports.MaxRows)
+		}
+	}
+
+	// Admin authentication
+	if c.OIDC.IssuerURL != "" {
+		p.checkURL("OIDC_ISSUER_URL", c.OIDC.IssuerURL, false, "https", "http")
+		if c.OIDC.Audience == "" {
+			p.add("OIDC_AUDIENCE is required when OIDC_ISSUER_URL is set")
+		}
+	}
+	if c.AccessTokens.Secret != "" {
+		if len(c.AccessTokens.Secret) < 32 {
+			p.add("ACCESS_TOKEN_SECRET must be at least 32 bytes, got %d", len(c.AccessTokens.Secret))
+		}
+		p.positive("ACCESS_TOKEN_TTL", c.AccessTokens.TTL)
+	}
+	if c.JWT.IssuerURL != "" {
+		p.checkURL("JWT_ISSUER_URL", c.JWT.IssuerURL, false, "https", "http")
+		if c.JWT.Audience == "" {
+			p.add("JWT_AUDIENCE is required when JWT_ISSUER_URL is set")
+		}
+		if c.JWT.SubjectClaim == "" {
+			p.add("JWT_SUBJECT_CLAIM must not be empty")
+		}
+	}
+	adminAuth := len(c.AdminTokens) > 0 || c.Secrets.AdminTokensRef != "" || c.OIDC.IssuerURL != ""
+	if c.ProfilingEnabled && !adminAuth {
+		p.add("PPROF_ENABLED requires admin authentication (ADMIN_TOKENS or OIDC_ISSUER_URL)")
+	}
+
+	// Requests and responses
+	p.notNegative("MAX_BODY_BYTES", c.MaxBodyBytes)
+	p.notNegative("COMPRESSION_MIN_SIZE", int64(c.Compression.MinSize))
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			p.add("CORS_ALLOWED_ORIGINS: %q is not an origin like https://app.example.com", origin)
+		}
+	}
+	if len(c.CORS.AllowedMethods) == 0 {
+		p.add("CORS_ALLOWED_METHODS must not be empty")
+	}
+
+	if c.Maintenance.RetryAfter < 0 {
+		p.add("MAINTENANCE_RETRY_AFTER must not be negative, got %s", c.Maintenance.RetryAfter)
+	}
+
+	if c.FeatureFlags.Redis {
+		p.positive("FEATURE_FLAGS_REFRESH_INTERVAL", c.FeatureFlags.RefreshInterval)
+	}
+
+	// Operations
+	if c.ShutdownDelay < 0 {
+		p.add("SHUTDOWN_DELAY must not be negative, got %s", c.ShutdownDelay)
+	}
+	if c.ShutdownTimeout < 0 {
+		p.add("SHUTDOWN_TIMEOUT must not be negative, got %s", c.ShutdownTimeout)
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		p.add("LOG_LEVEL: %q must be one of debug, info, warn, error", c.LogLevel)
+	}
+	if c.AccessLog.Format == "combined" && c.AccessLog.Output == "" {
+		p.add("ACCESS_LOG_FORMAT=combined requires ACCESS_LOG_OUTPUT")
+	}
+	p.notNegative("ACCESS_LOG_MAX_SIZE_MB", int64(c.AccessLog.MaxSizeMB))
+	p.notNegative("ACCESS_LOG_MAX_BACKUPS", int64(c.AccessLog.MaxBackups))
+	if c.AccessLog.RotateInterval < 0 {
+		p.add("ACCESS_LOG_ROTATE_INTERVAL must not be negative, got %s", c.AccessLog.RotateInterval)
+	}
+
+	return p
+}
+
+func (c *Config) validateTLS(p *problems) {
+	tls := c.TLS
+	if len(tls.ACMEDomains) > 0 && (tls.CertFile != "" || tls.KeyFile != "") {
+		p.add("ACME_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		p.add("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if tls.ACMEHTTPPort != "" {
+		if len(tls.ACMEDomains) == 0 {
+			p.add("ACME_HTTP_PORT requires ACME_DOMAINS")
+		}
+		p.checkPort("ACME_HTTP_PORT", tls.ACMEHTTPPort)
+	}
+
+	admin := c.AdminListener
+	if (admin.TLSCertFile == "") != (admin.TLSKeyFile == "") {
+		p.add("ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE must be set together")
+	}
+	if admin.TLSClientCAFile != "" && admin.TLSCertFile == "" {
+		p.add("ADMIN_TLS_CLIENT_CA_FILE requires ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE")
+	}
+	if admin.TLSCertFile != "" && len(admin.Addresses) == 0 {
+		p.add("ADMIN_TLS_CERT_FILE requires ADMIN_PORT or ADMIN_LISTEN_ADDRESSES")
+	}
+}
+
+func (c *Config) validateSecrets(p *problems) {
+	secrets := c.Secrets
+	if secrets.Provider == "" {
+		refs := []struct{ key, ref string }{
+			{"DATABASE_URL_SECRET", secrets.DatabaseURLRef},
+			{"REDIS_URL_SECRET", secrets.RedisURLRef},
+			{"ADMIN_TOKENS_SECRET", secrets.AdminTokensRef},
+		}
+		for _, secret := range refs {
+			if secret.ref != "" {
+				p.add("%s requires SECRETS_PROVIDER", secret.key)
+			}
+		}
+		return
+	}
+	if _, err := NewSecretsProvider(secrets); err != nil {
+		p.add("SECRETS_PROVIDER: %v", err)
+	}
+	if secrets.RefreshInterval < 0 {
+		p.add("SECRETS_REFRESH_INTERVAL must not be negative, got %s", secrets.RefreshInterval)
+	}
+}
+
+// checkURL reports a value that isn't a URL with one of schemes. With
+// allowDSN, connection strings without a scheme ("host=... dbname=...")
+// are accepted too.
+func (p *problems) checkURL(key, value string, allowDSN bool, schemes ...string) {
+	if allowDSN && !strings.Contains(value, "://") && strings.Contains(value, "=") {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		p.add("%s is not a valid URL: %v", key, err)
+		return
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return
+		}
+	}
+	p.add("%s must be a %s:// URL", key, strings.Join(schemes, ":// or "))
+}
+
+// checkSubject reports a value that isn't a NATS subject without wildcards:
+// dot-separated tokens, none of them empty
+func (p *problems) checkSubject(key, subject string) {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			p.add("%s: %q is not a NATS subject without wildcards", key, subject)
+			return
+		}
+	}
+}
+
+// databaseFamily groups database URL schemes that share a server and SQL
+// dialect, as database.DialectForURL does
+func databaseFamily(databaseURL string) string {
+	switch {
+	case strings.HasPrefix(databaseURL, "mysql://"), strings.HasPrefix(databaseURL, "mariadb://"):
+		return "mysql"
+	case strings.HasPrefix(databaseURL, "sqlite:"):
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// checkAddress reports a listen address that is neither "host:port" nor
+// "unix:/path"
+func (p *problems) checkAddress(key, address string) {
+	if strings.HasPrefix(address, "unix:") {
+		if strings.TrimPrefix(address, "unix:") == "" {
+			p.add("%s: %q needs a socket path", key, address)
+		}
+		return
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		p.add("%s: %q is not host:port or unix:/path", key, address)
+		return
+	}
+	p.checkPort(key, port)
+}
+
+func (p *problems) checkPort(key, port string) {
+	if number, err := strconv.Atoi(port); err != nil || number < 0 || number > 65535 {
+		p.add("%s: %q is not a valid port", key, port)
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{":8080"}, cfg.ListenAddresses)
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	t.Setenv("DEFAULT_RATE_LIMIT_REQUESTS", "lots")
+	t.Setenv("DEFAULT_RATE_LIMIT_WINDOW", "0s")
+	t.Setenv("PENALTY_PERIOD", "10 minutes")
+	t.Setenv("COMPRESSION_BROTLI", "maybe")
+	t.Setenv("LEGACY_ROUTES_SUNSET", "next year")
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("BODY_LIMITS", "POST /api/test;POST /api/test 100")
+	t.Setenv("UNIQUE_LIMITS", "POST /api/test cookie:id 10 1h")
+	t.Setenv("REDIS_URL", "localhost:6379")
+	t.Setenv("TLS_CERT_FILE", "/etc/tls.crt")
+	t.Setenv("ACME_DOMAINS", "api.example.com")
+	t.Setenv("OIDC_ISSUER_URL", "https://login.example.com")
+
+	_, err := Load()
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	for _, problem := range []string{
+		`DEFAULT_RATE_LIMIT_REQUESTS: "lots" is not an integer`,
+		`PENALTY_PERIOD: "10 minutes" is not a duration, e.g. 30s or 1h`,
+		`UNIQUE_LIMITS: "POST /api/test cookie:id 10 1h" needs a header:, query: or param: field`,
+		`BODY_LIMITS: "POST /api/test" is not a "METHOD ROUTE BYTES" rule`,
+		`COMPRESSION_BROTLI: "maybe" is not a boolean`,
+		`LEGACY_ROUTES_SUNSET: "next year" is not an RFC 3339 timestamp or YYYY-MM-DD date`,
+		`LOG_FORMAT: "xml" must be one of json, console`,
+		"REDIS_URL must be a redis:// or rediss:// or unix:// URL",
+		"DEFAULT_RATE_LIMIT_WINDOW must be positive, got 0s",
+		"ACME_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE",
+		"TLS_CERT_FILE and TLS_KEY_FILE must be set together",
+		"OIDC_AUDIENCE is required when OIDC_ISSUER_URL is set",
+	} {
+		assert.Contains(t, validationErr.Problems, problem)
+	}
+	assert.Contains(t, err.Error(), "invalid configuration:\n  - ")
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg, err := Load()
+		require.NoError(t, err)
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		problem string
+	}{
+		{"database scheme", func(c *Config) { c.DatabaseURL = "sqlserver://localhost/db" }, "DATABASE_URL must be a postgres:// or postgresql:// or mysql:// or mariadb:// or sqlite:// URL"},
+		{"database pool", func(c *Config) { c.DatabasePool.MaxIdleConns = 50 }, "DB_MAX_IDLE_CONNS (50) must not be greater than DB_MAX_OPEN_CONNS (25)"},
+		{"database retry delays", func(c *Config) { c.DatabaseRetry.MaxDelay = time.Millisecond }, "DB_RETRY_MAX_DELAY (1ms) must not be shorter than DB_RETRY_BASE_DELAY (50ms)"},
+		{"database health check", func(c *Config) { c.DatabaseHealthCheckInterval = 0 }, "DB_HEALTH_CHECK_INTERVAL must be positive, got 0s"},
+		{"replica scheme", func(c *Config) { c.DatabaseReplicaURLs = []string{"mysql://replica/db"} }, "DATABASE_REPLICA_URLS must use the same database as DATABASE_URL (postgres)"},
+		{"replica lag", func(c *Config) {
+			c.DatabaseReplicaURLs = []string{"postgres://replica/db"}
+			c.DatabaseReplicaMaxLag = 0
+		}, "DB_REPLICA_MAX_LAG must be positive, got 0s"},
+		{"listen address", func(c *Config) { c.ListenAddresses = []string{"8080"} }, `LISTEN_ADDRESSES: "8080" is not host:port or unix:/path`},
+		{"no listen address", func(c *Config) { c.ListenAddresses = nil }, "no API listen address: set PORT or LISTEN_ADDRESSES"},
+		{"socket path", func(c *Config) { c.AdminListener.Addresses = []string{"unix:"} }, `ADMIN_LISTEN_ADDRESSES: "unix:" needs a socket path`},
+		{"grpc address", func(c *Config) { c.GRPC.Addresses = []string{"localhost"} }, `GRPC_LISTEN_ADDRESSES: "localhost" is not host:port or unix:/path`},
+		{"proxy upstream", func(c *Config) { c.Proxy.Upstream = "backend:8080" }, `PROXY_UPSTREAM must be an http:// or https:// URL, got "backend:8080"`},
+		{"trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.internal"} }, `TRUSTED_PROXIES: "proxy.internal" is not an IP address or CIDR range`},
+		{"penalty cooldowns", func(c *Config) { c.RateLimitConfig.Penalty.Threshold, c.RateLimitConfig.Penalty.MaxCooldown = 5, 0 }, "PENALTY_MAX_COOLDOWN (0s) must not be shorter than PENALTY_BASE_COOLDOWN (5m0s)"},
+		{"admin throttle", func(c *Config) { c.RateLimitConfig.Admin.Requests = -1 }, "ADMIN_RATE_LIMIT_REQUESTS must not be negative, got -1"},
+		{"skip path", func(c *Config) { c.RateLimitConfig.SkipPaths = []string{"public"} }, `RATE_LIMIT_SKIP_PATHS: "public" must start with /`},
+		{"skip method", func(c *Config) { c.RateLimitConfig.SkipMethods = []string{"options"} }, `RATE_LIMIT_SKIP_METHODS: "options" is not an upper-case HTTP method`},
+		{"skip cidr", func(c *Config) { c.RateLimitConfig.SkipCIDRs = []string{"10.0.0.0/33"} }, `RATE_LIMIT_SKIP_CIDRS: "10.0.0.0/33" is not an IP address or CIDR range`},
+		{"flush interval", func(c *Config) { c.UsageFlushInterval = 0 }, "USAGE_FLUSH_INTERVAL must be positive, got 0s"},
+		{"usage log batch", func(c *Config) { c.UsageLog.BatchSize = 0 }, "USAGE_LOG_BATCH_SIZE must be at least 1, got 0"},
+		{"usage rollup lookback", func(c *Config) { c.UsageRollup.Lookback = 720 * time.Hour }, "USAGE_ROLLUP_LOOKBACK must be shorter than USAGE_LOG_RETENTION, got 720h0m0s and 720h0m0s"},
+		{"export rows", func(c *Config) { c.Exports.MaxRows = 0 }, "EXPORT_MAX_ROWS must be at least 1, got 0"},
+		{"retention", func(c *Config) { c.Retention.UsageLogs = -time.Hour }, "USAGE_LOG_RETENTION must not be negative, got -1h0m0s"},
+		{"alert error rate", func(c *Config) { c.Alerting.PagerDutyRoutingKey = "routing-key"; c.Alerting.ErrorRate = 5 }, "ALERT_ERROR_RATE must be above 0 and at most 1, got 5"},
+		{"nats subject", func(c *Config) { c.NATS.URL = "nats://localhost:4222"; c.NATS.SubjectPrefix = "events.>" }, `NATS_SUBJECT_PREFIX: "events.>" is not a NATS subject without wildcards`},
+		{"leader lease", func(c *Config) { c.LeaderElection.Enabled, c.LeaderElection.LeaseTTL = true, 5*time.Second }, "LEADER_LEASE_TTL (5s) must be longer than LEADER_RENEW_INTERVAL (5s)"},
+		{"leader election without redis", func(c *Config) { c.LeaderElection.Enabled, c.RateLimitBackend = true, "postgres" }, "LEADER_ELECTION_ENABLED needs Redis (RATE_LIMIT_BACKEND=redis)"},
+		{"response cache without redis", func(c *Config) { c.ResponseCache.Enabled, c.RateLimitBackend = true, "postgres" }, "RESPONSE_CACHE_ENABLED needs Redis (RATE_LIMIT_BACKEND=redis)"},
+		{"response cache key", func(c *Config) { c.ResponseCache.Enabled, c.ResponseCache.KeyBy = true, "query" }, `RESPONSE_CACHE_KEY_BY: "query" must be one of url, path`},
+		{"redis namespace", func(c *Config) { c.RedisNamespace = "rate limiter*" }, `REDIS_NAMESPACE must not contain spaces or glob characters, got "rate limiter*"`},
+		{"redis tenant url", func(c *Config) { c.RedisTenantURLs = map[string]string{"org-acme": "http://redis-acme"} }, "REDIS_TENANT_URLS org-acme must be a redis:// or rediss:// or unix:// URL"},
+		{"key metrics labels", func(c *Config) { c.KeyMetrics.Labels = "keys" }, `KEY_METRICS_LABELS: "keys" must be one of none, key, top_n, hash, plan`},
+		{"key metrics top n", func(c *Config) { c.KeyMetrics.Labels, c.KeyMetrics.TopN = "top_n", 0 }, "KEY_METRICS_TOP_N must be at least 1, got 0"},
+		{"live events buffer", func(c *Config) { c.LiveEvents.Enabled, c.LiveEvents.BufferSize = true, 0 }, "LIVE_EVENTS_BUFFER_SIZE must be at least 1, got 0"},
+		{"kafka broker", func(c *Config) { c.Kafka.Brokers = []string{"kafka"} }, `KAFKA_BROKERS: "kafka" is not host:port`},
+		{"webhook attempts", func(c *Config) { c.Webhooks.Enabled, c.Webhooks.MaxAttempts = true, 0 }, "WEBHOOK_MAX_ATTEMPTS must be at least 1, got 0"},
+		{"postgres backend on MySQL", func(c *Config) {
+			c.RateLimitBackend, c.DatabaseURL = "postgres", "mysql://root@localhost:3306/rate_limiter"
+		}, "RATE_LIMIT_BACKEND=postgres needs a Postgres or SQLite DATABASE_URL, got mysql"},
+		{"pprof without admin auth", func(c *Config) { c.ProfilingEnabled = true }, "PPROF_ENABLED requires admin authentication (ADMIN_TOKENS or OIDC_ISSUER_URL)"},
+		{"JWT audience", func(c *Config) { c.JWT.IssuerURL = "https://login.example.com" }, "JWT_AUDIENCE is required when JWT_ISSUER_URL is set"},
+		{"access token secret", func(c *Config) { c.AccessTokens.Secret = "short" }, "ACCESS_TOKEN_SECRET must be at least 32 bytes, got 5"},
+		{"admin client CA", func(c *Config) { c.AdminListener.TLSClientCAFile = "/etc/ca.crt" }, "ADMIN_TLS_CLIENT_CA_FILE requires ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE"},
+		{"admin TLS without listener", func(c *Config) {
+			c.AdminListener.TLSCertFile, c.AdminListener.TLSKeyFile = "/etc/admin.crt", "/etc/admin.key"
+		}, "ADMIN_TLS_CERT_FILE requires ADMIN_PORT or ADMIN_LISTEN_ADDRESSES"},
+		{"CORS origin", func(c *Config) { c.CORS.AllowedOrigins = []string{"app.example.com"} }, `CORS_ALLOWED_ORIGINS: "app.example.com" is not an origin like https://app.example.com`},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, `LOG_LEVEL: "loud" must be one of debug, info, warn, error`},
+		{"combined access log", func(c *Config) { c.AccessLog.Format = "combined" }, "ACCESS_LOG_FORMAT=combined requires ACCESS_LOG_OUTPUT"},
+		{"secret without provider", func(c *Config) { c.Secrets.RedisURLRef = "secret/redis" }, "REDIS_URL_SECRET requires SECRETS_PROVIDER"},
+		{"secrets provider", func(c *Config) { c.Secrets.Provider = SecretsProviderVault }, "SECRETS_PROVIDER: vault secrets provider requires VAULT_TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+
+			var validationErr *ValidationError
+			require.True(t, errors.As(cfg.Validate(), &validationErr))
+			assert.Equal(t, []string{tt.problem}, validationErr.Problems)
+		})
+	}
+
+	// Connection strings without a scheme are accepted for Postgres
+	cfg := valid()
+	cfg.DatabaseURL = "host=localhost dbname=rate_limiter sslmode=disable"
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultDefaultField is read when a Vault reference doesn't name a field
+const vaultDefaultField = "value"
+
+// VaultProvider reads secrets from HashiCorp Vault's HTTP API using a token.
+// References are API paths below /v1 with a field, e.g.
+// "secret/data/rate-limiter#database_url". Both KV v1 and KV v2 mounts are
+// supported.
+type VaultProvider struct {
+	address    string
+	token      string
+	namespace  string
+	httpClient *http.Client
+}
+
+func NewVaultProvider(cfg VaultConfig) (*VaultProvider, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("vault secrets provider requires VAULT_ADDR")
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("vault secrets provider requires VAULT_TOKEN")
+	}
+	return &VaultProvider{
+		address:    strings.TrimSuffix(cfg.Address, "/"),
+		token:      cfg.Token,
+		namespace:  cfg.Namespace,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// GetSecret implements SecretsProvider
+func (p *VaultProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	path, field := splitSecretRef(ref)
+	if field == "" {
+		field = vaultDefaultField
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from vault: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d reading %s from vault", resp.StatusCode, path)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response for %s: %w", path, err)
+	}
+
+	// KV v2 nests the secret's fields under data.data, next to its metadata
+	values := body.Data
+	if nested, ok := values["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := values["metadata"]; hasMetadata {
+			values = nested
+		}
+	}
+	return lookupField(values, field)
+}
@@ -0,0 +1,584 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// ErrCounterNotFound is returned by CounterStore.GetRateLimitCount for
+// counters that don't exist or have expired
+var ErrCounterNotFound = errors.New("counter not found")
+
+// CounterStore keeps rate limit counters, distinct-value sets and usage
+// counter hashes in the database, for deployments that run without Redis.
+// It has the same methods as the Redis client, so the rate limit and usage
+// services use it unchanged.
+//
+// Every counter is a single row updated with an upsert, which the database
+// applies atomically; distinct-value sets, which need a read before the
+// write, are serialized per set with an advisory lock on Postgres. Expiry
+// times are Unix milliseconds. Expired rows are ignored when read and
+// deleted by Run.
+type CounterStore struct {
+	db           DBInterface
+	windowJitter time.Duration
+	interval     time.Duration
+}
+
+// NewCounterStore keeps counters in db, a Postgres or SQLite database, adding
+// up to windowJitter to every new window like the Redis client does. Run
+// deletes expired rows every interval.
+func NewCounterStore(db DBInterface, windowJitter, interval time.Duration) (*CounterStore, error) {
+	if DialectOf(db) == MySQL {
+		return nil, fmt.Errorf("database counters need Postgres or SQLite, not %s", MySQL.Name())
+	}
+	return &CounterStore{db: db, windowJitter: windowJitter, interval: interval}, nil
+}
+
+// CheckSchema verifies that the counter tables exist
+func (s *CounterStore) CheckSchema(ctx context.Context) error {
+	for _, probe := range []string{
+		`SELECT key, value, expires_at FROM rate_limit_counters LIMIT 0`,
+		`SELECT key, member, expires_at FROM rate_limit_set_members LIMIT 0`,
+		`SELECT key, id, units, release_at, expires_at FROM rate_limit_reservations LIMIT 0`,
+		`SELECT key, field, value FROM rate_limit_hash_fields LIMIT 0`,
+	} {
+		rows, err := s.db.QueryContext(ctx, probe)
+		if err != nil {
+			return fmt.Errorf("counter tables not found: %w", err)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// IncrementRateLimit increments the counter for a fixed window and returns the
+// new count together with the time until the window resets.
+func (s *CounterStore) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	now := time.Now().UnixMilli()
+	expiresAt := now + (window + s.jitterFor(window)).Milliseconds()
+
+	// An expired window, or a counter stored without expiry, starts afresh
+	query := `
+		INSERT INTO rate_limit_counters (key, value, expires_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN rate_limit_counters.expires_at > $3 THEN rate_limit_counters.value + 1 ELSE 1 END,
+			expires_at = CASE WHEN rate_limit_counters.expires_at > $3 THEN rate_limit_counters.expires_at ELSE excluded.expires_at END
+		RETURNING value, expires_at
+	`
+
+	var count, storedExpiresAt int64
+	if err := s.db.QueryRowContext(ctx, query, key, expiresAt, now).Scan(&count, &storedExpiresAt); err != nil {
+		return 0, 0, err
+	}
+	return count, time.Duration(storedExpiresAt-now) * time.Millisecond, nil
+}
+
+// RefundRateLimit takes back up to units from the counter of the current
+// window, for requests that shouldn't have been counted. No more than
+// maxRefunds units are refunded per window, tracked in refundsKey, which
+// expires with the counter. Returns the units refunded, which is 0 when the
+// window has ended.
+func (s *CounterStore) RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error) {
+	var refund int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		now := time.Now().UnixMilli()
+
+		// Serialize refunds of the counter. On SQLite the delete below takes
+		// the database's write lock, which does the same.
+		if tx.Dialect() == Postgres {
+			if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE key = $1 AND expires_at <= $2`, refundsKey, now); err != nil {
+			return err
+		}
+
+		var count, expiresAt int64
+		err := tx.QueryRowContext(ctx, `SELECT value, expires_at FROM rate_limit_counters WHERE key = $1 AND expires_at > $2`, key, now).Scan(&count, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var refunded int64
+		err = tx.QueryRowContext(ctx, `SELECT value FROM rate_limit_counters WHERE key = $1`, refundsKey).Scan(&refunded)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		refund = units
+		for _, ceiling := range []int64{count, maxRefunds - refunded} {
+			if ceiling < refund {
+				refund = ceiling
+			}
+		}
+		if refund <= 0 {
+			refund = 0
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rate_limit_counters SET value = value - $2 WHERE key = $1`, key, refund); err != nil {
+			return err
+		}
+		query := `
+			INSERT INTO rate_limit_counters (key, value, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET value = rate_limit_counters.value + excluded.value, expires_at = excluded.expires_at
+		`
+		_, err = tx.ExecContext(ctx, query, refundsKey, refund, expiresAt)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return refund, nil
+}
+
+// ReserveRateLimit adds units to the counter key, if that leaves it within
+// limit, as reservation id recorded in reservationsKey. A reservation holds
+// its units until it is settled with SettleReservation, or is released,
+// taking its units off the counter again, once ttl has passed without it
+// being settled. Reservations end with the window, whose length is window
+// if the reservation starts one. Returns whether the units were reserved,
+// the counter's value and the time until the window resets.
+func (s *CounterStore) ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error) {
+	var reserved bool
+	var count, expiresAt, now int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		now = time.Now().UnixMilli()
+		var err error
+		count, expiresAt, _, err = releaseExpiredReservations(ctx, tx, key, reservationsKey, now)
+		if err != nil || count+units > limit {
+			return err
+		}
+
+		// Requests counted meanwhile by IncrementRateLimit, which doesn't
+		// wait for the lock, are kept
+		query := `
+			INSERT INTO rate_limit_counters (key, value, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET
+				value = CASE WHEN rate_limit_counters.expires_at > $4 THEN rate_limit_counters.value + $2 ELSE $2 END,
+				expires_at = CASE WHEN rate_limit_counters.expires_at > $4 THEN rate_limit_counters.expires_at ELSE excluded.expires_at END
+			RETURNING value, expires_at
+		`
+		newExpiresAt := now + (window + s.jitterFor(window)).Milliseconds()
+		if err := tx.QueryRowContext(ctx, query, key, units, newExpiresAt, now).Scan(&count, &expiresAt); err != nil {
+			return err
+		}
+
+		releaseAt := now + ttl.Milliseconds()
+		if releaseAt > expiresAt {
+			releaseAt = expiresAt
+		}
+		query = `INSERT INTO rate_limit_reservations (key, id, units, release_at, expires_at) VALUES ($1, $2, $3, $4, $5)`
+		if _, err := tx.ExecContext(ctx, query, reservationsKey, id, units, releaseAt, expiresAt); err != nil {
+			return err
+		}
+		reserved = true
+		return nil
+	})
+	if err != nil {
+		return false, 0, 0, err
+	}
+	var remaining time.Duration
+	if expiresAt > now {
+		remaining = time.Duration(expiresAt-now) * time.Millisecond
+	}
+	return reserved, count, remaining, nil
+}
+
+// SettleReservation ends reservation id of the counter key before its
+// release. Its units stay counted, unless release is set, when they are
+// taken off the counter. Returns the reservation's units, or 0 if it
+// doesn't exist, was released or belonged to an earlier window.
+func (s *CounterStore) SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error) {
+	var units int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		count, _, _, err := releaseExpiredReservations(ctx, tx, key, reservationsKey, time.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `DELETE FROM rate_limit_reservations WHERE key = $1 AND id = $2 RETURNING units`, reservationsKey, id).Scan(&units)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil || !release {
+			return err
+		}
+		if units < count {
+			count = units
+		}
+		if count > 0 {
+			_, err = tx.ExecContext(ctx, `UPDATE rate_limit_counters SET value = value - $2 WHERE key = $1`, key, count)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return units, nil
+}
+
+// ReleaseExpiredReservations releases the reservations of the counter key
+// that weren't settled in time, and returns the units taken off the counter
+func (s *CounterStore) ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error) {
+	var released int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		var err error
+		_, _, released, err = releaseExpiredReservations(ctx, tx, key, reservationsKey, time.Now().UnixMilli())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return released, nil
+}
+
+// releaseExpiredReservations starts the reservation changes of the counter
+// key: it drops the reservations of earlier windows and releases those not
+// settled by now, taking their units off the counter. Returns the counter's
+// value and expiry, both 0 without an unexpired counter, and the units
+// released.
+func releaseExpiredReservations(ctx context.Context, tx *Tx, key, reservationsKey string, now int64) (int64, int64, int64, error) {
+	// Serialize changes to the reservations. On SQLite the delete below
+	// takes the database's write lock, which does the same.
+	if tx.Dialect() == Postgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, reservationsKey); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_reservations WHERE key = $1 AND expires_at <= $2`, reservationsKey, now); err != nil {
+		return 0, 0, 0, err
+	}
+
+	var count, expiresAt int64
+	err := tx.QueryRowContext(ctx, `SELECT value, expires_at FROM rate_limit_counters WHERE key = $1 AND expires_at > $2`, key, now).Scan(&count, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM rate_limit_reservations WHERE key = $1 AND release_at <= $2 RETURNING units`, reservationsKey, now)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+	var released int64
+	for rows.Next() {
+		var units int64
+		if err := rows.Scan(&units); err != nil {
+			return 0, 0, 0, err
+		}
+		released += units
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+	rows.Close()
+
+	if released > count {
+		released = count
+	}
+	if released > 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE rate_limit_counters SET value = value - $2 WHERE key = $1`, key, released); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return count - released, expiresAt, released, nil
+}
+
+// jitterFor returns a random jitter for a new window, never more than the
+// window itself
+func (s *CounterStore) jitterFor(window time.Duration) time.Duration {
+	maxJitter := s.windowJitter
+	if maxJitter > window {
+		maxJitter = window
+	}
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxJitter)))
+}
+
+// GetRateLimitCount returns a counter's value, or ErrCounterNotFound
+func (s *CounterStore) GetRateLimitCount(ctx context.Context, key string) (int64, error) {
+	query := `SELECT value FROM rate_limit_counters WHERE key = $1 AND (expires_at IS NULL OR expires_at > $2)`
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, query, key, time.Now().UnixMilli()).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrCounterNotFound
+	}
+	return count, err
+}
+
+// GetTTL returns the remaining time to live of a counter, or 0 if the counter
+// does not exist or has no expiry.
+func (s *CounterStore) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	query := `SELECT expires_at FROM rate_limit_counters WHERE key = $1`
+
+	now := time.Now().UnixMilli()
+	var expiresAt sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !expiresAt.Valid || expiresAt.Int64 <= now {
+		return 0, nil
+	}
+	return time.Duration(expiresAt.Int64-now) * time.Millisecond, nil
+}
+
+// SetWithExpiry stores value in a counter that expires after ttl, or never
+// with a zero ttl
+func (s *CounterStore) SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		expiresAt = sql.NullInt64{Int64: time.Now().Add(ttl).UnixMilli(), Valid: true}
+	}
+
+	query := `
+		INSERT INTO rate_limit_counters (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+	`
+	_, err := s.db.ExecContext(ctx, query, key, value, expiresAt)
+	return err
+}
+
+// AddUniqueMember records member in the distinct-value set at key, refusing
+// new members once the set holds maxMembers. Members already in the set are
+// always allowed. The set expires window after its first member was added.
+func (s *CounterStore) AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error) {
+	var allowed bool
+	var count int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		now := time.Now().UnixMilli()
+
+		// Serialize changes to the set. On SQLite the delete below takes the
+		// database's write lock, which does the same.
+		if tx.Dialect() == Postgres {
+			if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_set_members WHERE key = $1 AND expires_at <= $2`, key, now); err != nil {
+			return err
+		}
+
+		var isMember bool
+		var expiresAt sql.NullInt64
+		query := `
+			SELECT COUNT(*), COALESCE(MAX(CASE WHEN member = $2 THEN 1 ELSE 0 END), 0) = 1, MIN(expires_at)
+			FROM rate_limit_set_members WHERE key = $1
+		`
+		if err := tx.QueryRowContext(ctx, query, key, member).Scan(&count, &isMember, &expiresAt); err != nil {
+			return err
+		}
+		if isMember {
+			allowed = true
+			return nil
+		}
+		if count >= maxMembers {
+			return nil
+		}
+
+		if !expiresAt.Valid {
+			expiresAt.Int64 = now + window.Milliseconds()
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO rate_limit_set_members (key, member, expires_at) VALUES ($1, $2, $3)`, key, member, expiresAt.Int64); err != nil {
+			return err
+		}
+		allowed = true
+		count++
+		return nil
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, count, nil
+}
+
+// DeleteByPattern removes every counter, set, hash and reservation list
+// whose key matches any of the glob patterns, in which only * is special,
+// and returns how many were deleted
+func (s *CounterStore) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	var deleted int64
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		for _, pattern := range patterns {
+			like := globToLike(pattern)
+
+			var keys int64
+			query := `
+				SELECT COUNT(DISTINCT key) FROM (
+					SELECT key FROM rate_limit_set_members WHERE key LIKE $1 ESCAPE '\'
+					UNION ALL
+					SELECT key FROM rate_limit_hash_fields WHERE key LIKE $1 ESCAPE '\'
+					UNION ALL
+					SELECT key FROM rate_limit_reservations WHERE key LIKE $1 ESCAPE '\'
+				) AS matched
+			`
+			if err := tx.QueryRowContext(ctx, query, like).Scan(&keys); err != nil {
+				return err
+			}
+			deleted += keys
+
+			for _, table := range []string{"rate_limit_set_members", "rate_limit_hash_fields", "rate_limit_reservations"} {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE key LIKE $1 ESCAPE '\'`, like); err != nil {
+					return err
+				}
+			}
+			result, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE key LIKE $1 ESCAPE '\'`, like)
+			if err != nil {
+				return err
+			}
+			counters, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += counters
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// globToLike converts a glob pattern with * wildcards to a LIKE pattern
+// escaped with \
+func globToLike(pattern string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(pattern)
+	return strings.ReplaceAll(escaped, "*", "%")
+}
+
+// IncrementHashField adds delta to a field of a counter hash
+func (s *CounterStore) IncrementHashField(ctx context.Context, key string, field string, delta int64) error {
+	query := `
+		INSERT INTO rate_limit_hash_fields (key, field, value) VALUES ($1, $2, $3)
+		ON CONFLICT (key, field) DO UPDATE SET value = rate_limit_hash_fields.value + excluded.value
+	`
+	_, err := s.db.ExecContext(ctx, query, key, field, delta)
+	return err
+}
+
+// DrainHash returns and removes every field of a counter hash in one
+// statement, so increments that arrive during a drain are kept for the next
+func (s *CounterStore) DrainHash(ctx context.Context, key string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `DELETE FROM rate_limit_hash_fields WHERE key = $1 RETURNING field, value`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var field string
+		var count int64
+		if err := rows.Scan(&field, &count); err != nil {
+			return nil, err
+		}
+		counts[field] = count
+	}
+	return counts, rows.Err()
+}
+
+// featureFlagsKey holds the runtime feature flag toggles as 1/0 hash fields
+const featureFlagsKey = "feature_flags"
+
+// LoadFeatureFlags returns the runtime feature flag toggles
+func (s *CounterStore) LoadFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT field, value FROM rate_limit_hash_fields WHERE key = $1`, featureFlagsKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		flags[name] = value != 0
+	}
+	return flags, rows.Err()
+}
+
+// SetFeatureFlag stores a runtime feature flag toggle
+func (s *CounterStore) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	query := `
+		INSERT INTO rate_limit_hash_fields (key, field, value) VALUES ($1, $2, $3)
+		ON CONFLICT (key, field) DO UPDATE SET value = excluded.value
+	`
+	_, err := s.db.ExecContext(ctx, query, featureFlagsKey, name, value)
+	return err
+}
+
+// DeleteFeatureFlag removes a runtime feature flag toggle
+func (s *CounterStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limit_hash_fields WHERE key = $1 AND field = $2`, featureFlagsKey, name)
+	return err
+}
+
+// Run deletes expired counters and set members every interval until ctx is
+// cancelled
+func (s *CounterStore) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error("Failed to delete expired counters", zap.Error(err))
+			}
+		}
+	}
+}
+
+// DeleteExpired deletes expired counters, set members and reservations and
+// returns how many rows were deleted
+func (s *CounterStore) DeleteExpired(ctx context.Context) (int64, error) {
+	now := time.Now().UnixMilli()
+	var deleted int64
+	for _, table := range []string{"rate_limit_counters", "rate_limit_set_members", "rate_limit_reservations"} {
+		result, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE expires_at <= $1`, now)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete expired rows from %s: %w", table, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCounterStore(t *testing.T) *CounterStore {
+	db, err := NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(context.Background())
+	require.NoError(t, err)
+
+	store, err := NewCounterStore(db, 0, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, store.CheckSchema(context.Background()))
+	return store
+}
+
+func TestCounterStore_IncrementRateLimit(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	count, ttl, err := store.IncrementRateLimit(ctx, "rate_limit:a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	// Concurrent increments are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := store.IncrementRateLimit(ctx, "rate_limit:a", time.Minute)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	count, err = store.GetRateLimitCount(ctx, "rate_limit:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(21), count)
+
+	// An expired window starts again at one
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:b", time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	count, ttl, err = store.IncrementRateLimit(ctx, "rate_limit:b", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Greater(t, ttl, 50*time.Second)
+
+	_, err = store.GetRateLimitCount(ctx, "rate_limit:missing")
+	assert.ErrorIs(t, err, ErrCounterNotFound)
+}
+
+func TestCounterStore_RefundRateLimit(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, _, err := store.IncrementRateLimit(ctx, "rate_limit:a", time.Minute)
+		require.NoError(t, err)
+	}
+
+	refunded, err := store.RefundRateLimit(ctx, "rate_limit:a", "rate_limit:a:refunds", 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), refunded)
+	count, err := store.GetRateLimitCount(ctx, "rate_limit:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// No more than the refund limit is refunded per window
+	refunded, err = store.RefundRateLimit(ctx, "rate_limit:a", "rate_limit:a:refunds", 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), refunded)
+	refunded, err = store.RefundRateLimit(ctx, "rate_limit:a", "rate_limit:a:refunds", 1, 3)
+	require.NoError(t, err)
+	assert.Zero(t, refunded)
+
+	// Nor more than the window counted, and nothing once it has ended
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:b", time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	refunded, err = store.RefundRateLimit(ctx, "rate_limit:b", "rate_limit:b:refunds", 5, 10)
+	require.NoError(t, err)
+	assert.Zero(t, refunded)
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:c", time.Minute)
+	require.NoError(t, err)
+	refunded, err = store.RefundRateLimit(ctx, "rate_limit:c", "rate_limit:c:refunds", 5, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), refunded)
+}
+
+func TestCounterStore_Reservations(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	// Reserved units count right away, and only what fits is reserved
+	reserved, count, ttl, err := store.ReserveRateLimit(ctx, "rate_limit:a", "rate_limit:a:reservations", "r1", 6, 10, time.Minute, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, int64(6), count)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:a", time.Minute)
+	require.NoError(t, err)
+	reserved, count, _, err = store.ReserveRateLimit(ctx, "rate_limit:a", "rate_limit:a:reservations", "r2", 4, 10, time.Minute, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, int64(7), count)
+
+	// Cancelling gives the units back; settling twice finds nothing
+	units, err := store.SettleReservation(ctx, "rate_limit:a", "rate_limit:a:reservations", "r1", true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), units)
+	count, err = store.GetRateLimitCount(ctx, "rate_limit:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	units, err = store.SettleReservation(ctx, "rate_limit:a", "rate_limit:a:reservations", "r1", false)
+	require.NoError(t, err)
+	assert.Zero(t, units)
+
+	// Committed units stay counted
+	_, _, _, err = store.ReserveRateLimit(ctx, "rate_limit:a", "rate_limit:a:reservations", "r3", 3, 10, time.Minute, time.Minute)
+	require.NoError(t, err)
+	units, err = store.SettleReservation(ctx, "rate_limit:a", "rate_limit:a:reservations", "r3", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), units)
+	count, err = store.GetRateLimitCount(ctx, "rate_limit:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	// Reservations not settled in time are released
+	_, _, _, err = store.ReserveRateLimit(ctx, "rate_limit:a", "rate_limit:a:reservations", "r4", 5, 10, time.Minute, time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	released, err := store.ReleaseExpiredReservations(ctx, "rate_limit:a", "rate_limit:a:reservations")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), released)
+	count, err = store.GetRateLimitCount(ctx, "rate_limit:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+	units, err = store.SettleReservation(ctx, "rate_limit:a", "rate_limit:a:reservations", "r4", false)
+	require.NoError(t, err)
+	assert.Zero(t, units)
+
+	// Reservations end with their window, and don't release units of the next
+	_, _, _, err = store.ReserveRateLimit(ctx, "rate_limit:b", "rate_limit:b:reservations", "r5", 2, 10, time.Millisecond, time.Minute)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:b", time.Minute)
+	require.NoError(t, err)
+	units, err = store.SettleReservation(ctx, "rate_limit:b", "rate_limit:b:reservations", "r5", true)
+	require.NoError(t, err)
+	assert.Zero(t, units)
+	count, err = store.GetRateLimitCount(ctx, "rate_limit:b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestCounterStore_SetWithExpiry(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.SetWithExpiry(ctx, "penalty:a", 3, time.Hour))
+	ttl, err := store.GetTTL(ctx, "penalty:a")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, ttl, float64(time.Second))
+	level, err := store.GetRateLimitCount(ctx, "penalty:a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), level)
+
+	ttl, err = store.GetTTL(ctx, "penalty:missing")
+	require.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	require.NoError(t, store.SetWithExpiry(ctx, "penalty:a", 0, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	ttl, err = store.GetTTL(ctx, "penalty:a")
+	require.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	deleted, err := store.DeleteExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
+
+func TestCounterStore_AddUniqueMember(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	for _, member := range []string{"a", "b"} {
+		allowed, _, err := store.AddUniqueMember(ctx, "unique:k", member, 2, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, count, err := store.AddUniqueMember(ctx, "unique:k", "c", 2, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(2), count)
+
+	// Values already seen are always allowed
+	allowed, count, err = store.AddUniqueMember(ctx, "unique:k", "a", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestCounterStore_Hashes(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.IncrementHashField(ctx, "usage:pending", "key-1", 2))
+	require.NoError(t, store.IncrementHashField(ctx, "usage:pending", "key-1", 3))
+	require.NoError(t, store.IncrementHashField(ctx, "usage:pending", "key-2", 1))
+
+	counts, err := store.DrainHash(ctx, "usage:pending")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"key-1": 5, "key-2": 1}, counts)
+
+	counts, err = store.DrainHash(ctx, "usage:pending")
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+
+	require.NoError(t, store.SetFeatureFlag(ctx, "beta", true))
+	require.NoError(t, store.SetFeatureFlag(ctx, "legacy", false))
+	require.NoError(t, store.DeleteFeatureFlag(ctx, "legacy"))
+	flags, err := store.LoadFeatureFlags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"beta": true}, flags)
+}
+
+func TestCounterStore_DeleteByPattern(t *testing.T) {
+	store := newCounterStore(t)
+	ctx := context.Background()
+
+	_, _, err := store.IncrementRateLimit(ctx, "rate_limit:key_1", time.Minute)
+	require.NoError(t, err)
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:key_1:user:u", time.Minute)
+	require.NoError(t, err)
+	_, _, err = store.AddUniqueMember(ctx, "unique:key_1:GET:/x:id", "v", 5, time.Minute)
+	require.NoError(t, err)
+	// _ is literal in patterns, so this key doesn't match
+	_, _, err = store.IncrementRateLimit(ctx, "rate_limit:keyX1", time.Minute)
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteByPattern(ctx, "rate_limit:key_1", "rate_limit:key_1:*", "unique:key_1:*")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+
+	count, err := store.GetRateLimitCount(ctx, "rate_limit:keyX1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestNewCounterStore_MySQL(t *testing.T) {
+	_, err := NewCounterStore(&DB{dialect: MySQL}, 0, time.Minute)
+	assert.ErrorContains(t, err, "Postgres or SQLite")
+}
@@ -1,31 +1,210 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-
-	_ "github.com/lib/pq"
+	"sync"
+	"time"
 )
 
+// rotatedConnMaxLifetime bounds how long connections opened with replaced
+// credentials stay in the pool
+const rotatedConnMaxLifetime = 5 * time.Minute
+
 type DB struct {
 	*sql.DB
+	connector *rotatingConnector
+	dialect   Dialect
+	pool      PoolConfig
+}
+
+// PoolConfig bounds the connection pool; see the matching sql.DB setters.
+// Zero MaxOpenConns, ConnMaxLifetime and ConnMaxIdleTime mean no limit, and
+// zero MaxIdleConns keeps no idle connections.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Option configures optional DB behaviour
+type Option func(*DB)
+
+// WithPool bounds the connection pool. Without it the pool uses the
+// database/sql defaults: unlimited open connections, two idle ones and no
+// lifetime limits.
+func WithPool(pool PoolConfig) Option {
+	return func(db *DB) {
+		db.pool = pool
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
 }
 
-func NewConnection(databaseURL string) (*DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+// NewConnection opens a connection pool for databaseURL. mysql:// and
+// mariadb:// URLs use MySQL, sqlite: URLs a local SQLite file; anything else
+// is a Postgres URL or connection string.
+func NewConnection(databaseURL string, opts ...Option) (*DB, error) {
+	db, err := open(databaseURL, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &DB{db}, nil
+	return db, nil
+}
+
+// open creates a connection pool for databaseURL without connecting yet
+func open(databaseURL string, opts ...Option) (*DB, error) {
+	dialect := DialectForURL(databaseURL)
+	if _, err := dialect.connector(databaseURL); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	connector := &rotatingConnector{dsn: databaseURL, dialect: dialect}
+	db := &DB{DB: sql.OpenDB(connector), connector: connector, dialect: dialect}
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db, nil
+}
+
+// Dialect returns the SQL dialect of the database
+func (db *DB) Dialect() Dialect {
+	if db.dialect == nil {
+		return Postgres
+	}
+	return db.dialect
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query, args = db.Dialect().Rebind(query, args)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
 }
 
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = db.Dialect().Rebind(query, args)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args = db.Dialect().Rebind(query, args)
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// UpdateURL switches new connections to databaseURL, e.g. after the
+// database password was rotated. Existing connections keep working and are
+// retired from the pool within a few minutes.
+func (db *DB) UpdateURL(databaseURL string) error {
+	if dialect := DialectForURL(databaseURL); dialect != db.Dialect() {
+		return fmt.Errorf("invalid database URL: can't switch from %s to %s", db.Dialect().Name(), dialect.Name())
+	}
+	if _, err := db.Dialect().connector(databaseURL); err != nil {
+		return fmt.Errorf("invalid database URL: %w", err)
+	}
+	db.connector.setDSN(databaseURL)
+	if db.pool.ConnMaxLifetime <= 0 || db.pool.ConnMaxLifetime > rotatedConnMaxLifetime {
+		db.SetConnMaxLifetime(rotatedConnMaxLifetime)
+	}
+	return nil
+}
+
+// rotatingConnector opens each connection with the current DSN
+type rotatingConnector struct {
+	mu      sync.RWMutex
+	dsn     string
+	dialect Dialect
+}
+
+func (c *rotatingConnector) setDSN(dsn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dsn = dsn
+}
+
+func (c *rotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	dsn := c.dsn
+	c.mu.RUnlock()
+
+	connector, err := c.dialect.connector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *rotatingConnector) Driver() driver.Driver {
+	return c.dialect.driver()
+}
+
+// InitSchema creates any missing tables, columns and indexes
 func (db *DB) InitSchema() error {
-	query := `
+	if db.Dialect().migrations() != nil {
+		_, err := db.Migrate(context.Background())
+		return err
+	}
+	_, err := db.Exec(db.Dialect().schema())
+	return err
+}
+
+const postgresSchema = `
+	CREATE TABLE IF NOT EXISTS plans (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		name VARCHAR(64) UNIQUE NOT NULL,
+		rate_limit_requests INTEGER NOT NULL,
+		rate_limit_window_seconds INTEGER NOT NULL,
+		quota_requests INTEGER NOT NULL DEFAULT 0,
+		quota_period_seconds INTEGER NOT NULL DEFAULT 0,
+		burst_requests INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	INSERT INTO plans (name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests)
+	VALUES
+		('free', 60, 60, 10000, 2592000, 0),
+		('pro', 600, 60, 1000000, 2592000, 100),
+		('enterprise', 6000, 60, 0, 0, 1000)
+	ON CONFLICT (name) DO NOTHING;
+
+	CREATE TABLE IF NOT EXISTS organizations (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		name VARCHAR(255) UNIQUE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS projects (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		organization_id UUID NOT NULL REFERENCES organizations(id),
+		name VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		UNIQUE (organization_id, name)
+	);
+
 	CREATE TABLE IF NOT EXISTS api_keys (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 		key_hash VARCHAR(255) UNIQUE NOT NULL,
@@ -37,10 +216,452 @@ func (db *DB) InitSchema() error {
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 	);
 
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id);
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS end_user_limit_requests INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS end_user_limit_window_seconds INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_hash VARCHAR(255);
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_expires_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(16) NOT NULL DEFAULT '';
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_origins TEXT[];
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS lifetime_requests BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(255);
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS hash_version SMALLINT NOT NULL DEFAULT 1;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES api_keys(id) ON DELETE CASCADE;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_name VARCHAR(255);
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_email VARCHAR(255);
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS alert_thresholds INTEGER[];
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS refund_limit INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS limit_response JSONB;
+	ALTER TABLE plans ADD COLUMN IF NOT EXISTS limit_response JSONB;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS project_id UUID REFERENCES projects(id);
+	-- Keys deactivated by hand before deletes became restorable count as
+	-- deleted since their last update, so they can be restored; expired keys
+	-- stay deactivated. Backfilled once, when the column is added.
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'api_keys' AND column_name = 'deleted_at'
+		) THEN
+			ALTER TABLE api_keys ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+			UPDATE api_keys SET deleted_at = COALESCE(updated_at, NOW()), is_active = true
+			WHERE is_active = false AND (expires_at IS NULL OR expires_at > NOW());
+		END IF;
+	END $$;
+	ALTER TABLE organizations ADD COLUMN IF NOT EXISTS rate_limit_requests INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE organizations ADD COLUMN IF NOT EXISTS rate_limit_window_seconds INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE organizations ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id);
+	ALTER TABLE organizations ADD COLUMN IF NOT EXISTS max_keys INTEGER NOT NULL DEFAULT 0;
+
 	CREATE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash);
 	CREATE INDEX IF NOT EXISTS idx_api_keys_is_active ON api_keys(is_active);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_plan_id ON api_keys(plan_id);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_key_prefix ON api_keys(key_prefix);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys(expires_at) WHERE is_active = true;
+	CREATE INDEX IF NOT EXISTS idx_api_keys_parent_id ON api_keys(parent_id);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_owner_email ON api_keys(LOWER(owner_email));
+	CREATE INDEX IF NOT EXISTS idx_api_keys_project_id ON api_keys(project_id);
+
+	CREATE TABLE IF NOT EXISTS limit_overrides (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		rate_limit_requests INTEGER NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_limit_overrides_api_key_id ON limit_overrides(api_key_id, expires_at);
+
+	CREATE TABLE IF NOT EXISTS api_key_usage_daily (
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		day DATE NOT NULL,
+		request_count BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (api_key_id, day)
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_logs (
+		id BIGSERIAL PRIMARY KEY,
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		method VARCHAR(16) NOT NULL,
+		route VARCHAR(255) NOT NULL,
+		status_code SMALLINT NOT NULL,
+		cost INTEGER NOT NULL DEFAULT 1,
+		decision VARCHAR(32) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_logs_api_key_id ON usage_logs(api_key_id, created_at);
+
+	-- Hourly and daily usage totals per key and route, rolled up from usage_logs
+	CREATE TABLE IF NOT EXISTS usage_rollups_hourly (
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		route VARCHAR(255) NOT NULL,
+		bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+		requests BIGINT NOT NULL,
+		limited BIGINT NOT NULL,
+		cost BIGINT NOT NULL,
+		PRIMARY KEY (api_key_id, bucket_start, route)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_rollups_hourly_bucket_start ON usage_rollups_hourly(bucket_start);
+
+	CREATE TABLE IF NOT EXISTS usage_rollups_daily (
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		route VARCHAR(255) NOT NULL,
+		bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+		requests BIGINT NOT NULL,
+		limited BIGINT NOT NULL,
+		cost BIGINT NOT NULL,
+		PRIMARY KEY (api_key_id, bucket_start, route)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_rollups_daily_bucket_start ON usage_rollups_daily(bucket_start);
+
+	-- Usage reports requested through POST /admin/exports, generated in the background
+	CREATE TABLE IF NOT EXISTS usage_exports (
+		id UUID PRIMARY KEY,
+		status VARCHAR(16) NOT NULL,
+		format VARCHAR(16) NOT NULL,
+		api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE,
+		period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+		period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+		row_count BIGINT NOT NULL DEFAULT 0,
+		content BYTEA,
+		error VARCHAR(1024) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		started_at TIMESTAMP WITH TIME ZONE,
+		completed_at TIMESTAMP WITH TIME ZONE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_exports_status ON usage_exports(status, created_at);
+
+	-- Usage reports in parts, in order of seq; reports generated before are
+	-- kept in usage_exports.content
+	CREATE TABLE IF NOT EXISTS usage_export_chunks (
+		export_id UUID NOT NULL REFERENCES usage_exports(id) ON DELETE CASCADE,
+		seq INTEGER NOT NULL,
+		content BYTEA NOT NULL,
+		PRIMARY KEY (export_id, seq)
+	);
+
+	-- Responses to admin requests sent with an Idempotency-Key header, replayed to
+	-- retries of the same request; a status code of 0 means still in progress
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope VARCHAR(255) NOT NULL,
+		idempotency_key VARCHAR(255) NOT NULL,
+		request_hash CHAR(64) NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type VARCHAR(255) NOT NULL DEFAULT '',
+		response_body BYTEA,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (scope, idempotency_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+	-- Where limit alerts of a key are sent, and the log of sending them
+	CREATE TABLE IF NOT EXISTS webhooks (
+		api_key_id UUID PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		secret VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY,
+		api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		event_type VARCHAR(64) NOT NULL,
+		url TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		error VARCHAR(1024) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		delivered_at TIMESTAMP WITH TIME ZONE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_api_key_id ON webhook_deliveries(api_key_id, created_at);
+
+	-- Rate limit state for RATE_LIMIT_BACKEND=postgres. Counters are rebuilt by
+	-- traffic, so they skip the write-ahead log like Redis skips persistence;
+	-- unflushed usage counts in rate_limit_hash_fields are kept.
+	CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_counters (
+		key TEXT PRIMARY KEY,
+		value BIGINT NOT NULL DEFAULT 0,
+		expires_at BIGINT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expires_at ON rate_limit_counters(expires_at);
+
+	CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_set_members (
+		key TEXT NOT NULL,
+		member TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (key, member)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_set_members_expires_at ON rate_limit_set_members(expires_at);
+
+	CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_reservations (
+		key TEXT NOT NULL,
+		id TEXT NOT NULL,
+		units BIGINT NOT NULL,
+		release_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (key, id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_reservations_expires_at ON rate_limit_reservations(expires_at);
+
+	CREATE TABLE IF NOT EXISTS rate_limit_hash_fields (
+		key TEXT NOT NULL,
+		field TEXT NOT NULL,
+		value BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (key, field)
+	);
 	`
 
-	_, err := db.Exec(query)
-	return err
+// mysqlSchema is postgresSchema for MySQL 8 and MariaDB 10.5+. UUIDs are
+// stored as text and list columns as JSON arrays.
+const mysqlSchema = `
+	CREATE TABLE IF NOT EXISTS plans (
+		id CHAR(36) PRIMARY KEY,
+		name VARCHAR(64) UNIQUE NOT NULL,
+		rate_limit_requests INTEGER NOT NULL,
+		rate_limit_window_seconds INTEGER NOT NULL,
+		quota_requests INTEGER NOT NULL DEFAULT 0,
+		quota_period_seconds INTEGER NOT NULL DEFAULT 0,
+		burst_requests INTEGER NOT NULL DEFAULT 0,
+		limit_response JSON,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6)
+	);
+
+	INSERT IGNORE INTO plans (id, name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests)
+	VALUES
+		(UUID(), 'free', 60, 60, 10000, 2592000, 0),
+		(UUID(), 'pro', 600, 60, 1000000, 2592000, 100),
+		(UUID(), 'enterprise', 6000, 60, 0, 0, 1000);
+
+	CREATE TABLE IF NOT EXISTS organizations (
+		id CHAR(36) PRIMARY KEY,
+		name VARCHAR(255) UNIQUE NOT NULL,
+		rate_limit_requests INT NOT NULL DEFAULT 0,
+		rate_limit_window_seconds INT NOT NULL DEFAULT 0,
+		plan_id CHAR(36),
+		max_keys INT NOT NULL DEFAULT 0,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		FOREIGN KEY (plan_id) REFERENCES plans(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS projects (
+		id CHAR(36) PRIMARY KEY,
+		organization_id CHAR(36) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		UNIQUE KEY uq_projects_organization_name (organization_id, name),
+		FOREIGN KEY (organization_id) REFERENCES organizations(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id CHAR(36) PRIMARY KEY,
+		key_hash VARCHAR(255) UNIQUE NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		rate_limit_requests INTEGER NOT NULL DEFAULT 100,
+		rate_limit_window_seconds INTEGER NOT NULL DEFAULT 3600,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		plan_id CHAR(36),
+		end_user_limit_requests INTEGER NOT NULL DEFAULT 0,
+		end_user_limit_window_seconds INTEGER NOT NULL DEFAULT 0,
+		previous_key_hash VARCHAR(255),
+		previous_key_expires_at DATETIME(6),
+		expires_at DATETIME(6),
+		key_prefix VARCHAR(16) NOT NULL DEFAULT '',
+		allowed_cidrs JSON,
+		allowed_origins JSON,
+		last_used_at DATETIME(6),
+		lifetime_requests BIGINT NOT NULL DEFAULT 0,
+		signing_secret VARCHAR(255),
+		hash_version SMALLINT NOT NULL DEFAULT 1,
+		parent_id CHAR(36),
+		owner_name VARCHAR(255),
+		owner_email VARCHAR(255),
+		alert_thresholds JSON,
+		refund_limit INTEGER NOT NULL DEFAULT 0,
+		limit_response JSON,
+		project_id CHAR(36),
+		deleted_at DATETIME(6),
+		INDEX idx_api_keys_is_active (is_active),
+		INDEX idx_api_keys_previous_key_hash (previous_key_hash),
+		INDEX idx_api_keys_key_prefix (key_prefix),
+		INDEX idx_api_keys_expires_at (expires_at),
+		INDEX idx_api_keys_owner_email (owner_email),
+		FOREIGN KEY (plan_id) REFERENCES plans(id),
+		FOREIGN KEY (parent_id) REFERENCES api_keys(id) ON DELETE CASCADE,
+		FOREIGN KEY (project_id) REFERENCES projects(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS limit_overrides (
+		id CHAR(36) PRIMARY KEY,
+		api_key_id CHAR(36) NOT NULL,
+		rate_limit_requests INTEGER NOT NULL,
+		expires_at DATETIME(6) NOT NULL,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		INDEX idx_limit_overrides_api_key_id (api_key_id, expires_at),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS api_key_usage_daily (
+		api_key_id CHAR(36) NOT NULL,
+		day DATE NOT NULL,
+		request_count BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (api_key_id, day),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_logs (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		api_key_id CHAR(36) NOT NULL,
+		method VARCHAR(16) NOT NULL,
+		route VARCHAR(255) NOT NULL,
+		status_code SMALLINT NOT NULL,
+		cost INTEGER NOT NULL DEFAULT 1,
+		decision VARCHAR(32) NOT NULL DEFAULT '',
+		created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		INDEX idx_usage_logs_api_key_id (api_key_id, created_at),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_rollups_hourly (
+		api_key_id CHAR(36) NOT NULL,
+		route VARCHAR(255) NOT NULL,
+		bucket_start DATETIME(6) NOT NULL,
+		requests BIGINT NOT NULL,
+		limited BIGINT NOT NULL,
+		cost BIGINT NOT NULL,
+		PRIMARY KEY (api_key_id, bucket_start, route),
+		INDEX idx_usage_rollups_hourly_bucket_start (bucket_start),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_rollups_daily (
+		api_key_id CHAR(36) NOT NULL,
+		route VARCHAR(255) NOT NULL,
+		bucket_start DATETIME(6) NOT NULL,
+		requests BIGINT NOT NULL,
+		limited BIGINT NOT NULL,
+		cost BIGINT NOT NULL,
+		PRIMARY KEY (api_key_id, bucket_start, route),
+		INDEX idx_usage_rollups_daily_bucket_start (bucket_start),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_exports (
+		id CHAR(36) PRIMARY KEY,
+		status VARCHAR(16) NOT NULL,
+		format VARCHAR(16) NOT NULL,
+		api_key_id CHAR(36),
+		period_start DATETIME(6) NOT NULL,
+		period_end DATETIME(6) NOT NULL,
+		row_count BIGINT NOT NULL DEFAULT 0,
+		content LONGBLOB,
+		error VARCHAR(1024) NOT NULL DEFAULT '',
+		created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		started_at DATETIME(6),
+		completed_at DATETIME(6),
+		INDEX idx_usage_exports_status (status, created_at),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	-- Usage reports in parts, in order of seq; reports generated before are
+	-- kept in usage_exports.content
+	CREATE TABLE IF NOT EXISTS usage_export_chunks (
+		export_id CHAR(36) NOT NULL,
+		seq INT NOT NULL,
+		content LONGBLOB NOT NULL,
+		PRIMARY KEY (export_id, seq),
+		FOREIGN KEY (export_id) REFERENCES usage_exports(id) ON DELETE CASCADE
+	);
+
+	-- Responses to admin requests sent with an Idempotency-Key header, replayed to
+	-- retries of the same request; a status code of 0 means still in progress
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope VARCHAR(255) NOT NULL,
+		idempotency_key VARCHAR(255) NOT NULL,
+		request_hash CHAR(64) NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type VARCHAR(255) NOT NULL DEFAULT '',
+		response_body LONGBLOB,
+		created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		PRIMARY KEY (scope, idempotency_key),
+		INDEX idx_idempotency_keys_created_at (created_at)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		api_key_id CHAR(36) PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret VARCHAR(255) NOT NULL,
+		created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id CHAR(36) PRIMARY KEY,
+		api_key_id CHAR(36) NOT NULL,
+		event_type VARCHAR(64) NOT NULL,
+		url TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		error VARCHAR(1024) NOT NULL DEFAULT '',
+		created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		delivered_at DATETIME(6),
+		INDEX idx_webhook_deliveries_api_key_id (api_key_id, created_at),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	);
+	`
+
+// schemaProbes select the most recently added columns of every table, so
+// they fail until the schema in InitSchema (and scripts/init-db*.sql) has been
+// applied. Extend them whenever the schema changes.
+var schemaProbes = []string{
+	`SELECT id, quota_requests, burst_requests, limit_response FROM plans LIMIT 0`,
+	`SELECT id, name, rate_limit_requests, rate_limit_window_seconds, plan_id, max_keys FROM organizations LIMIT 0`,
+	`SELECT id, organization_id, name FROM projects LIMIT 0`,
+	`SELECT id, plan_id, hash_version, parent_id, owner_name, owner_email, lifetime_requests, alert_thresholds, refund_limit, limit_response, project_id, deleted_at FROM api_keys LIMIT 0`,
+	`SELECT id, api_key_id, expires_at FROM limit_overrides LIMIT 0`,
+	`SELECT api_key_id, day, request_count FROM api_key_usage_daily LIMIT 0`,
+	`SELECT id, api_key_id, route, status_code, cost, decision FROM usage_logs LIMIT 0`,
+	`SELECT api_key_id, route, bucket_start, requests, limited, cost FROM usage_rollups_hourly LIMIT 0`,
+	`SELECT api_key_id, route, bucket_start, requests, limited, cost FROM usage_rollups_daily LIMIT 0`,
+	`SELECT id, status, format, api_key_id, period_start, period_end, row_count, content, started_at, completed_at FROM usage_exports LIMIT 0`,
+	`SELECT export_id, seq, content FROM usage_export_chunks LIMIT 0`,
+	`SELECT scope, idempotency_key, request_hash, status_code, content_type, response_body FROM idempotency_keys LIMIT 0`,
+	`SELECT api_key_id, url, secret FROM webhooks LIMIT 0`,
+	`SELECT id, api_key_id, event_type, status, attempts, response_status, delivered_at FROM webhook_deliveries LIMIT 0`,
+}
+
+// CheckSchema verifies that the database is reachable and its schema is up
+// to date
+func (db *DB) CheckSchema(ctx context.Context) error {
+	for _, probe := range schemaProbes {
+		rows, err := db.QueryContext(ctx, probe)
+		if err != nil {
+			return fmt.Errorf("schema not up to date: %w", err)
+		}
+		rows.Close()
+	}
+	return nil
 }
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConnection_Pool(t *testing.T) {
+	db, err := NewConnection("sqlite://"+filepath.Join(t.TempDir(), "rate_limiter.db"), WithPool(PoolConfig{
+		MaxOpenConns:    5,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: time.Hour,
+		ConnMaxIdleTime: time.Minute,
+	}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, 5, db.Stats().MaxOpenConnections)
+	assert.Equal(t, 1, db.Stats().OpenConnections, "the ping connection stays idle")
+}
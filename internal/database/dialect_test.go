@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialectForURL(t *testing.T) {
+	assert.Equal(t, MySQL, DialectForURL("mysql://app:secret@db:3306/rate_limiter"))
+	assert.Equal(t, MySQL, DialectForURL("mariadb://db/rate_limiter"))
+	assert.Equal(t, Postgres, DialectForURL("postgres://db/rate_limiter"))
+	assert.Equal(t, Postgres, DialectForURL("host=db dbname=rate_limiter"))
+}
+
+func TestMySQLRebind(t *testing.T) {
+	query, args := MySQL.Rebind(
+		`UPDATE api_keys SET key_hash = $3 WHERE id = $1 AND (key_hash = $2 OR previous_key_hash = $2)`,
+		[]interface{}{"id", "old", "new"},
+	)
+	assert.Equal(t, `UPDATE api_keys SET key_hash = ? WHERE id = ? AND (key_hash = ? OR previous_key_hash = ?)`, query)
+	assert.Equal(t, []interface{}{"new", "id", "old", "old"}, args)
+
+	query, args = MySQL.Rebind(`SELECT id FROM api_keys WHERE `+MySQL.In("key_hash", "$1")+` AND name = $2`, []interface{}{MySQL.List([]string{"a", "b"}), "x"})
+	assert.Equal(t, `SELECT id FROM api_keys WHERE key_hash IN (?, ?) AND name = ?`, query)
+	assert.Equal(t, []interface{}{"a", "b", "x"}, args)
+
+	query, args = MySQL.Rebind(`SELECT id FROM api_keys WHERE `+MySQL.In("key_hash", "$1"), []interface{}{MySQL.List(nil)})
+	assert.Equal(t, `SELECT id FROM api_keys WHERE key_hash IN (NULL)`, query)
+	assert.Empty(t, args)
+
+	// Placeholders beyond the arguments are left for the driver to reject
+	query, _ = MySQL.Rebind(`SELECT $2`, []interface{}{1})
+	assert.Equal(t, `SELECT $2`, query)
+}
+
+func TestMySQLArray(t *testing.T) {
+	value, err := MySQL.Array([]string{"10.0.0.0/8", "192.168.1.0/24"}).(driver.Valuer).Value()
+	require.NoError(t, err)
+	assert.Equal(t, `["10.0.0.0/8","192.168.1.0/24"]`, string(value.([]byte)))
+
+	value, err = MySQL.Array([]string(nil)).(driver.Valuer).Value()
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	var cidrs []string
+	require.NoError(t, MySQL.Array(&cidrs).(sql.Scanner).Scan([]byte(`["10.0.0.0/8"]`)))
+	assert.Equal(t, []string{"10.0.0.0/8"}, cidrs)
+	require.NoError(t, MySQL.Array(&cidrs).(sql.Scanner).Scan(nil))
+	assert.Nil(t, cidrs)
+
+	value, err = MySQL.Array([]int64{80, 100}).(driver.Valuer).Value()
+	require.NoError(t, err)
+	assert.Equal(t, `[80,100]`, string(value.([]byte)))
+
+	var thresholds []int64
+	require.NoError(t, MySQL.Array(&thresholds).(sql.Scanner).Scan(`[80,100]`))
+	assert.Equal(t, []int64{80, 100}, thresholds)
+}
+
+func TestMySQLConfig(t *testing.T) {
+	cfg, err := mysqlConfig("mysql://app:p%40ss@db/rate_limiter?tls=skip-verify")
+	require.NoError(t, err)
+	assert.Equal(t, "app", cfg.User)
+	assert.Equal(t, "p@ss", cfg.Passwd)
+	assert.Equal(t, "db:3306", cfg.Addr)
+	assert.Equal(t, "rate_limiter", cfg.DBName)
+	assert.Equal(t, "skip-verify", cfg.TLSConfig)
+	assert.True(t, cfg.ParseTime)
+	assert.Equal(t, time.UTC, cfg.Loc)
+
+	_, err = mysqlConfig("mysql:///rate_limiter")
+	assert.Error(t, err)
+}
+
+func TestNewUUID(t *testing.T) {
+	id, err := NewUUID()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+}
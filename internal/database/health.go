@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// HealthMonitor checks the database in the background, so readiness probes
+// and metrics reflect an outage without each probe waiting on the database
+type HealthMonitor struct {
+	check    func(ctx context.Context) error
+	interval time.Duration
+	timeout  time.Duration
+
+	mu      sync.RWMutex
+	err     error
+	checked bool
+}
+
+// NewHealthMonitor runs check, e.g. DB.CheckSchema, every interval with the
+// given timeout
+func NewHealthMonitor(check func(ctx context.Context) error, interval, timeout time.Duration) *HealthMonitor {
+	return &HealthMonitor{check: check, interval: interval, timeout: timeout}
+}
+
+// Run checks immediately and then on every tick until ctx is cancelled
+func (m *HealthMonitor) Run(ctx context.Context) {
+	m.Refresh(ctx)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh checks the database now and logs when it becomes unreachable or
+// recovers
+func (m *HealthMonitor) Refresh(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	err := m.check(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	wasHealthy := !m.checked || m.err == nil
+	m.err, m.checked = err, true
+	m.mu.Unlock()
+
+	if err != nil {
+		metrics.DatabaseUp.Set(0)
+		if wasHealthy {
+			logging.FromContext(ctx).Error("Database unreachable", zap.Error(err))
+		}
+		return
+	}
+	metrics.DatabaseUp.Set(1)
+	if !wasHealthy {
+		logging.FromContext(ctx).Info("Database connection restored")
+	}
+}
+
+// Check returns the result of the latest check, for use as a readiness
+// check. Before the first check has finished the database counts as
+// unavailable.
+func (m *HealthMonitor) Check(context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.checked {
+		return fmt.Errorf("not checked yet")
+	}
+	return m.err
+}
@@ -1,14 +1,28 @@
 package database
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier runs queries, on a connection pool or in a transaction. Every
+// query takes a context so cancelled requests and timeouts release their
+// connection promptly.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
 
 // DBInterface defines the interface for database operations
 type DBInterface interface {
-	QueryRow(query string, args ...interface{}) *sql.Row
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	Querier
 	Close() error
-	Ping() error
+	PingContext(ctx context.Context) error
 }
 
 // Ensure DB implements DBInterface
 var _ DBInterface = (*DB)(nil)
+
+// Ensure Tx implements Querier
+var _ Querier = (*Tx)(nil)
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// LimitResponse replaces the standard 429 response to requests refused for
+// exceeding a key's rate limit or quota, e.g. to point customers at an
+// upgrade page. The body and header values may use the {limit},
+// {remaining}, {reset} and {retry_after} variables.
+type LimitResponse struct {
+	// Body is sent as JSON when it is valid JSON and as plain text
+	// otherwise, unless Headers sets a Content-Type
+	Body    string            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Value stores the response as a JSON object, or NULL when there is none
+func (r *LimitResponse) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// ScanLimitResponse returns a scan destination that stores a limit_response
+// column in *dest, leaving it nil for NULL
+func ScanLimitResponse(dest **LimitResponse) sql.Scanner {
+	return limitResponseScanner{dest}
+}
+
+type limitResponseScanner struct {
+	dest **LimitResponse
+}
+
+func (s limitResponseScanner) Scan(src interface{}) error {
+	var encoded []byte
+	switch src := src.(type) {
+	case nil:
+		*s.dest = nil
+		return nil
+	case []byte:
+		encoded = src
+	case string:
+		encoded = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into a limit response", src)
+	}
+
+	var response LimitResponse
+	if err := json.Unmarshal(encoded, &response); err != nil {
+		return err
+	}
+	*s.dest = &response
+	return nil
+}
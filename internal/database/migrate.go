@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Migrate applies the dialect's migrations that haven't run yet, in file
+// name order, and returns how many were applied. Each one runs in its own
+// transaction and is recorded in schema_migrations. Postgres and MySQL have
+// no migrations; their schema comes from the scripts/init-db*.sql files.
+func (db *DB) Migrate(ctx context.Context) (int, error) {
+	migrations := db.Dialect().migrations()
+	if migrations == nil {
+		return 0, nil
+	}
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version VARCHAR(255) PRIMARY KEY, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	names, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	applied := 0
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+
+		var count int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&count); err != nil {
+			return applied, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		body, err := fs.ReadFile(migrations, name)
+		if err != nil {
+			return applied, err
+		}
+		if err := db.applyMigration(ctx, version, string(body)); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", version, err)
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+func (db *DB) applyMigration(ctx context.Context, version, body string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	query, args := db.Dialect().Rebind(`INSERT INTO schema_migrations (version) VALUES ($1)`, []interface{}{version})
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+-- Initial SQLite schema for local development; mirrors scripts/init-db.sql.
+-- UUIDs and times are stored as text, list columns as JSON arrays.
+
+CREATE TABLE plans (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name VARCHAR(64) UNIQUE NOT NULL,
+    rate_limit_requests INTEGER NOT NULL,
+    rate_limit_window_seconds INTEGER NOT NULL,
+    quota_requests INTEGER NOT NULL DEFAULT 0,
+    quota_period_seconds INTEGER NOT NULL DEFAULT 0,
+    burst_requests INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+INSERT INTO plans (name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests)
+VALUES
+    ('free', 60, 60, 10000, 2592000, 0),
+    ('pro', 600, 60, 1000000, 2592000, 100),
+    ('enterprise', 6000, 60, 0, 0, 1000);
+
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    rate_limit_requests INTEGER NOT NULL DEFAULT 100,
+    rate_limit_window_seconds INTEGER NOT NULL DEFAULT 3600,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    plan_id TEXT REFERENCES plans(id),
+    end_user_limit_requests INTEGER NOT NULL DEFAULT 0,
+    end_user_limit_window_seconds INTEGER NOT NULL DEFAULT 0,
+    previous_key_hash VARCHAR(255),
+    previous_key_expires_at DATETIME,
+    expires_at DATETIME,
+    key_prefix VARCHAR(16) NOT NULL DEFAULT '',
+    allowed_cidrs TEXT,
+    allowed_origins TEXT,
+    last_used_at DATETIME,
+    lifetime_requests BIGINT NOT NULL DEFAULT 0,
+    signing_secret VARCHAR(255),
+    hash_version SMALLINT NOT NULL DEFAULT 1,
+    parent_id TEXT REFERENCES api_keys(id) ON DELETE CASCADE,
+    owner_name VARCHAR(255),
+    owner_email VARCHAR(255)
+);
+
+CREATE INDEX idx_api_keys_is_active ON api_keys(is_active);
+CREATE INDEX idx_api_keys_plan_id ON api_keys(plan_id);
+CREATE INDEX idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
+CREATE INDEX idx_api_keys_key_prefix ON api_keys(key_prefix);
+CREATE INDEX idx_api_keys_expires_at ON api_keys(expires_at) WHERE is_active = true;
+CREATE INDEX idx_api_keys_parent_id ON api_keys(parent_id);
+CREATE INDEX idx_api_keys_owner_email ON api_keys(LOWER(owner_email));
+
+CREATE TABLE limit_overrides (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    rate_limit_requests INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX idx_limit_overrides_api_key_id ON limit_overrides(api_key_id, expires_at);
+
+CREATE TABLE api_key_usage_daily (
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+);
+
+-- Sample key for local testing: the SHA-256 hash of 'hello'
+INSERT INTO api_keys (key_hash, name, rate_limit_requests, rate_limit_window_seconds)
+VALUES ('2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824', 'Test API Key', 10, 60);
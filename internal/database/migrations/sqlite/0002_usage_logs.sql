@@ -0,0 +1,14 @@
+-- One row per authenticated request, written in batches by the usage log writer
+
+CREATE TABLE usage_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    status_code SMALLINT NOT NULL,
+    cost INTEGER NOT NULL DEFAULT 1,
+    decision VARCHAR(32) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX idx_usage_logs_api_key_id ON usage_logs(api_key_id, created_at);
@@ -0,0 +1,26 @@
+-- Rate limit state for RATE_LIMIT_BACKEND=postgres, which also runs on
+-- SQLite for local development. Expiry times are Unix milliseconds.
+
+CREATE TABLE rate_limit_counters (
+    key TEXT PRIMARY KEY,
+    value INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER
+);
+
+CREATE INDEX idx_rate_limit_counters_expires_at ON rate_limit_counters(expires_at);
+
+CREATE TABLE rate_limit_set_members (
+    key TEXT NOT NULL,
+    member TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,
+    PRIMARY KEY (key, member)
+);
+
+CREATE INDEX idx_rate_limit_set_members_expires_at ON rate_limit_set_members(expires_at);
+
+CREATE TABLE rate_limit_hash_fields (
+    key TEXT NOT NULL,
+    field TEXT NOT NULL,
+    value INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, field)
+);
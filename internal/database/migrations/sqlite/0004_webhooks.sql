@@ -0,0 +1,25 @@
+-- Where limit alerts of a key are sent, and the log of sending them
+
+CREATE TABLE webhooks (
+    api_key_id TEXT PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    url TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    delivered_at DATETIME
+);
+
+CREATE INDEX idx_webhook_deliveries_api_key_id ON webhook_deliveries(api_key_id, created_at);
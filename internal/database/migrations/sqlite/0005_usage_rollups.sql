@@ -0,0 +1,25 @@
+-- Hourly and daily usage totals per key and route, rolled up from usage_logs
+
+CREATE TABLE usage_rollups_hourly (
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    route VARCHAR(255) NOT NULL,
+    bucket_start DATETIME NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route)
+);
+
+CREATE INDEX idx_usage_rollups_hourly_bucket_start ON usage_rollups_hourly(bucket_start);
+
+CREATE TABLE usage_rollups_daily (
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    route VARCHAR(255) NOT NULL,
+    bucket_start DATETIME NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route)
+);
+
+CREATE INDEX idx_usage_rollups_daily_bucket_start ON usage_rollups_daily(bucket_start);
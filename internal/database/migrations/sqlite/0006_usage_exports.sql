@@ -0,0 +1,18 @@
+-- Usage reports requested through POST /admin/exports, generated in the background
+
+CREATE TABLE usage_exports (
+    id TEXT PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    api_key_id TEXT REFERENCES api_keys(id) ON DELETE CASCADE,
+    period_start DATETIME NOT NULL,
+    period_end DATETIME NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    content BLOB,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    started_at DATETIME,
+    completed_at DATETIME
+);
+
+CREATE INDEX idx_usage_exports_status ON usage_exports(status, created_at);
@@ -0,0 +1,4 @@
+-- Percentages of the rate limit or quota that raise a warning when reached,
+-- as a JSON array (NULL = none)
+
+ALTER TABLE api_keys ADD COLUMN alert_thresholds TEXT;
@@ -0,0 +1,22 @@
+-- Tenants of the platform and their projects; keys belong to a project
+-- (NULL = not part of any organization)
+
+CREATE TABLE organizations (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name VARCHAR(255) UNIQUE NOT NULL,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE projects (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    organization_id TEXT NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    created_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at DATETIME DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    UNIQUE (organization_id, name)
+);
+
+ALTER TABLE api_keys ADD COLUMN project_id TEXT REFERENCES projects(id);
+
+CREATE INDEX idx_api_keys_project_id ON api_keys(project_id);
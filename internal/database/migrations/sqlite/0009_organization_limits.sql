@@ -0,0 +1,5 @@
+-- Ceiling on the requests of all of an organization's keys together
+-- (0 = none)
+
+ALTER TABLE organizations ADD COLUMN rate_limit_requests INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN rate_limit_window_seconds INTEGER NOT NULL DEFAULT 0;
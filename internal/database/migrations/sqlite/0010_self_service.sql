@@ -0,0 +1,5 @@
+-- Plan of the keys an organization creates itself through /v1/me, and how
+-- many active keys it may hold (0 = no cap)
+
+ALTER TABLE organizations ADD COLUMN plan_id TEXT REFERENCES plans(id);
+ALTER TABLE organizations ADD COLUMN max_keys INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+-- Responses to admin requests sent with an Idempotency-Key header, replayed to
+-- retries of the same request; a status code of 0 means still in progress
+
+CREATE TABLE idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response_body BLOB,
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (scope, idempotency_key)
+);
+
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
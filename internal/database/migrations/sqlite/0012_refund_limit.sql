@@ -0,0 +1,3 @@
+-- Units a key may refund per rate limit window (0 = refunds not allowed)
+
+ALTER TABLE api_keys ADD COLUMN refund_limit INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- Reserved units of a counter, released at release_at unless settled before;
+-- expires_at is the end of the counter's window they were reserved in.
+-- Times are Unix milliseconds.
+
+CREATE TABLE rate_limit_reservations (
+    key TEXT NOT NULL,
+    id TEXT NOT NULL,
+    units INTEGER NOT NULL,
+    release_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    PRIMARY KEY (key, id)
+);
+
+CREATE INDEX idx_rate_limit_reservations_expires_at ON rate_limit_reservations(expires_at);
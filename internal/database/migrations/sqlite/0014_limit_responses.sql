@@ -0,0 +1,5 @@
+-- Custom response to requests over the rate limit or quota, as a JSON object
+-- with a body and headers (NULL = the plan's, or the standard one)
+
+ALTER TABLE api_keys ADD COLUMN limit_response TEXT;
+ALTER TABLE plans ADD COLUMN limit_response TEXT;
@@ -0,0 +1,3 @@
+-- When the key was deleted (NULL = not deleted); deleted keys can be restored
+
+ALTER TABLE api_keys ADD COLUMN deleted_at DATETIME;
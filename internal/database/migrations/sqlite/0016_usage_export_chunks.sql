@@ -0,0 +1,10 @@
+-- Usage reports in parts, in order of seq, so they are neither built nor
+-- served whole in memory; reports generated before are kept in
+-- usage_exports.content
+
+CREATE TABLE usage_export_chunks (
+    export_id TEXT NOT NULL REFERENCES usage_exports(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    content BLOB NOT NULL,
+    PRIMARY KEY (export_id, seq)
+);
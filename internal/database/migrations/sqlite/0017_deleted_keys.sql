@@ -0,0 +1,8 @@
+-- Keys deactivated by hand before deletes became restorable count as deleted
+-- since their last update, so they can be restored. Keys deactivated
+-- because they expired stay as they are.
+
+UPDATE api_keys
+SET deleted_at = COALESCE(updated_at, strftime('%Y-%m-%d %H:%M:%f', 'now')), is_active = true
+WHERE is_active = false AND deleted_at IS NULL
+    AND (expires_at IS NULL OR expires_at > strftime('%Y-%m-%d %H:%M:%f', 'now'));
@@ -5,12 +5,318 @@ import (
 )
 
 type APIKey struct {
-	ID                    string    `json:"id" db:"id"`
-	KeyHash               string    `json:"-" db:"key_hash"`
-	Name                  string    `json:"name" db:"name"`
-	RateLimitRequests     int       `json:"rate_limit_requests" db:"rate_limit_requests"`
-	RateLimitWindowSeconds int      `json:"rate_limit_window_seconds" db:"rate_limit_window_seconds"`
-	IsActive              bool      `json:"is_active" db:"is_active"`
-	CreatedAt             time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt             time.Time `json:"updated_at" db:"updated_at"`
+	ID                     string    `json:"id" db:"id"`
+	KeyHash                string    `json:"-" db:"key_hash"`
+	KeyPrefix              string    `json:"key_prefix" db:"key_prefix"`
+	Name                   string    `json:"name" db:"name"`
+	RateLimitRequests      int       `json:"rate_limit_requests" db:"rate_limit_requests"`
+	RateLimitWindowSeconds int       `json:"rate_limit_window_seconds" db:"rate_limit_window_seconds"`
+	IsActive               bool      `json:"is_active" db:"is_active"`
+	CreatedAt              time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at" db:"updated_at"`
+
+	// Plan settings, resolved from the plans table when the key references a plan
+	PlanID             string `json:"plan_id,omitempty" db:"plan_id"`
+	QuotaRequests      int    `json:"quota_requests" db:"quota_requests"`
+	QuotaPeriodSeconds int    `json:"quota_period_seconds" db:"quota_period_seconds"`
+	BurstRequests      int    `json:"burst_requests" db:"burst_requests"`
+
+	// Who to contact about the key, e.g. before deactivating a noisy key
+	OwnerName  string `json:"owner_name,omitempty" db:"owner_name"`
+	OwnerEmail string `json:"owner_email,omitempty" db:"owner_email"`
+
+	// Sub-keys share their parent's limits, plan and quota but have their own
+	// secret, restrictions and lifecycle
+	ParentID string `json:"parent_id,omitempty" db:"parent_id"`
+
+	// Source networks allowed to use the key; empty means unrestricted
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty" db:"allowed_cidrs"`
+
+	// Browser origins allowed to use the key; empty means unrestricted
+	AllowedOrigins []string `json:"allowed_origins,omitempty" db:"allowed_origins"`
+
+	// Keys with a signing secret only accept HMAC-signed requests. The secret
+	// is needed to verify signatures, so unlike the key it is stored as is.
+	SigningSecret    string `json:"-" db:"signing_secret"`
+	RequireSignature bool   `json:"require_signature" db:"-"`
+
+	// Which KeyHashing version produced KeyHash; older hashes are upgraded
+	// when the key is next used
+	HashVersion int `json:"-" db:"hash_version"`
+
+	// Last successful authentication, recorded asynchronously in batches
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+
+	// Keys past ExpiresAt are rejected and later deactivated by the sweeper
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+
+	// When the key was deleted; deleted keys are rejected and left out of
+	// listings, but keep their settings and history until restored or purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+
+	// Per-end-user sublimit (0 disables); a window of 0 uses the key's window
+	EndUserLimitRequests      int `json:"end_user_limit_requests" db:"end_user_limit_requests"`
+	EndUserLimitWindowSeconds int `json:"end_user_limit_window_seconds" db:"end_user_limit_window_seconds"`
+
+	// Project the key belongs to and that project's organization, both empty
+	// for keys outside any organization; sub-keys are in their parent's
+	ProjectID      string `json:"project_id,omitempty" db:"project_id"`
+	OrganizationID string `json:"organization_id,omitempty" db:"-"`
+
+	// The organization's ceiling across all of its keys, loaded with the key
+	// for enforcement (0 = none)
+	OrganizationLimitRequests      int `json:"-" db:"-"`
+	OrganizationLimitWindowSeconds int `json:"-" db:"-"`
+
+	// Percentages of the rate limit or quota at which requests get a warning
+	// header and an alert is raised, ascending; sub-keys use their parent's
+	AlertThresholds []int64 `json:"alert_thresholds,omitempty" db:"alert_thresholds"`
+
+	// Units of its rate limit the key may refund per window, for requests
+	// whose downstream call failed (0 = refunds not allowed); sub-keys use
+	// their parent's
+	RefundLimit int `json:"refund_limit,omitempty" db:"refund_limit"`
+
+	// Custom response to requests refused for exceeding the rate limit or
+	// quota (nil = the standard one). Set on the key, or inherited from its
+	// plan when it has none; sub-keys use their parent's.
+	LimitResponse *LimitResponse `json:"limit_response,omitempty" db:"limit_response"`
+
+	// Active temporary limit override, if any
+	OverrideRequests  int        `json:"override_requests,omitempty" db:"override_requests"`
+	OverrideExpiresAt *time.Time `json:"override_expires_at,omitempty" db:"override_expires_at"`
+
+	// When a sub-key's parent expires, which ends the sub-key as well; set
+	// on keys looked up to validate a request
+	ParentExpiresAt *time.Time `json:"-" db:"-"`
+
+	// The caller of a token issued by an external identity provider, with
+	// its limits taken from the token's claims; there is no such key in
+	// api_keys
+	External bool `json:"-" db:"-"`
+
+	// The access token a request authenticated with, if any, and the limit
+	// it was scoped down to on top of the key's (0 = the key's limits only);
+	// a window of 0 uses the key's window
+	TokenID                 string `json:"-" db:"-"`
+	TokenLimitRequests      int    `json:"-" db:"-"`
+	TokenLimitWindowSeconds int    `json:"-" db:"-"`
+}
+
+// LimitKeyID identifies the key whose counters a request is charged to: the
+// parent for sub-keys, otherwise the key itself
+func (k *APIKey) LimitKeyID() string {
+	if k.ParentID != "" {
+		return k.ParentID
+	}
+	return k.ID
+}
+
+// Plan is a named tier (free, pro, enterprise) whose limits are inherited by
+// the API keys that reference it unless the key overrides them.
+type Plan struct {
+	ID                     string `json:"id" db:"id"`
+	Name                   string `json:"name" db:"name"`
+	RateLimitRequests      int    `json:"rate_limit_requests" db:"rate_limit_requests"`
+	RateLimitWindowSeconds int    `json:"rate_limit_window_seconds" db:"rate_limit_window_seconds"`
+	QuotaRequests          int    `json:"quota_requests" db:"quota_requests"`
+	QuotaPeriodSeconds     int    `json:"quota_period_seconds" db:"quota_period_seconds"`
+	BurstRequests          int    `json:"burst_requests" db:"burst_requests"`
+
+	// Custom response to refused requests of the plan's keys without one of
+	// their own (nil = the standard one)
+	LimitResponse *LimitResponse `json:"limit_response,omitempty" db:"limit_response"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Organization is a tenant of the platform. Its keys are grouped in
+// projects, and admin credentials scoped to it only see those keys.
+type Organization struct {
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+
+	// Ceiling on the requests of all of the organization's keys together,
+	// on top of their own limits (0 = none); a window of 0 uses the default
+	RateLimitRequests      int `json:"rate_limit_requests" db:"rate_limit_requests"`
+	RateLimitWindowSeconds int `json:"rate_limit_window_seconds" db:"rate_limit_window_seconds"`
+
+	// Plan of the keys the organization creates itself through the
+	// self-service API (empty = self-service is off), and how many active
+	// keys it may hold (0 = no cap)
+	PlanID  string `json:"plan_id,omitempty" db:"plan_id"`
+	MaxKeys int    `json:"max_keys" db:"max_keys"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Project groups an organization's API keys, e.g. per application or
+// environment
+type Project struct {
+	ID             string    `json:"id" db:"id"`
+	OrganizationID string    `json:"organization_id" db:"organization_id"`
+	Name           string    `json:"name" db:"name"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// LimitOverride is a temporary rate limit boost for an API key, honored until
+// ExpiresAt.
+type LimitOverride struct {
+	ID                string    `json:"id" db:"id"`
+	APIKeyID          string    `json:"api_key_id" db:"api_key_id"`
+	RateLimitRequests int       `json:"rate_limit_requests" db:"rate_limit_requests"`
+	ExpiresAt         time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// KeyUsage holds the persisted request counters for an API key. Counts are
+// flushed from Redis periodically, so they may lag by the flush interval.
+type KeyUsage struct {
+	APIKeyID         string       `json:"api_key_id"`
+	LifetimeRequests int64        `json:"lifetime_requests"`
+	Daily            []DailyUsage `json:"daily"`
+}
+
+// DailyUsage is the number of requests a key made on one UTC day
+type DailyUsage struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+}
+
+// UsageLog records one authenticated request: the matched route, the
+// response status, how much of the key's limit it cost and the rate limiter's
+// decision (see the rate_limit field of the access log)
+type UsageLog struct {
+	ID         int64     `json:"id"`
+	APIKeyID   string    `json:"api_key_id"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	StatusCode int       `json:"status_code"`
+	Cost       int       `json:"cost"`
+	Decision   string    `json:"decision"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// How long the request took to serve; not stored in usage_logs
+	Latency time.Duration `json:"-"`
+}
+
+// UsageBucket aggregates a key's usage logs over one period of an analytics
+// time series
+type UsageBucket struct {
+	Start    time.Time `json:"start"`
+	Requests int64     `json:"requests"`
+	// Requests refused with 429 Too Many Requests
+	Limited     int64   `json:"limited"`
+	AverageCost float64 `json:"average_cost"`
+}
+
+// KeyAnalytics is the time series of a key's usage between From and To, one
+// bucket per Granularity, including buckets without requests
+type KeyAnalytics struct {
+	APIKeyID    string        `json:"api_key_id"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Granularity string        `json:"granularity"`
+	Buckets     []UsageBucket `json:"buckets"`
+}
+
+// KeyConsumption is what a key used of the service over a window, from its
+// usage logs
+type KeyConsumption struct {
+	APIKeyID  string `json:"api_key_id"`
+	Name      string `json:"name"`
+	KeyPrefix string `json:"key_prefix"`
+	Requests  int64  `json:"requests"`
+	// Requests refused with 429 Too Many Requests, and their share of all
+	// requests
+	Limited     int64   `json:"limited"`
+	LimitedRate float64 `json:"limited_rate"`
+	Cost        int64   `json:"cost"`
+}
+
+// TopConsumers ranks the keys used since Since by request volume, by share
+// of requests refused with 429 and by total cost
+type TopConsumers struct {
+	Window        string           `json:"window"`
+	Since         time.Time        `json:"since"`
+	ByRequests    []KeyConsumption `json:"by_requests"`
+	ByLimitedRate []KeyConsumption `json:"by_limited_rate"`
+	ByCost        []KeyConsumption `json:"by_cost"`
+}
+
+// UsageExport is a usage report of the usage logs between From and To,
+// optionally of one key, generated in the background. Status is "pending"
+// until a worker picks it up, then "running" and finally "completed", when
+// the report can be downloaded, or "failed" with Error.
+type UsageExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	APIKeyID    string     `json:"api_key_id,omitempty"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	Rows        int64      `json:"rows"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Webhook is where a key's limit alerts are sent. Deliveries are signed with
+// Secret, which is only returned when the webhook is set.
+type Webhook struct {
+	APIKeyID  string    `json:"api_key_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery records sending one event to a key's webhook. Status is
+// "pending" until the event is delivered, or "failed" once every attempt
+// was refused.
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	APIKeyID       string     `json:"api_key_id"`
+	EventType      string     `json:"event_type"`
+	URL            string     `json:"url"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// ExportedAPIKey is an API key as exported for backups and migrations
+// between environments: its stored hash and settings, without its signing
+// secret, rotation state or usage. The plan is referenced by name, since
+// plan IDs differ between environments. The hash only validates where the
+// same KEY_HASH_ALGORITHM and pepper are configured.
+type ExportedAPIKey struct {
+	ID                        string     `json:"id"`
+	KeyHash                   string     `json:"key_hash"`
+	HashVersion               int        `json:"hash_version"`
+	KeyPrefix                 string     `json:"key_prefix"`
+	Name                      string     `json:"name"`
+	IsActive                  bool       `json:"is_active"`
+	RateLimitRequests         int        `json:"rate_limit_requests"`
+	RateLimitWindowSeconds    int        `json:"rate_limit_window_seconds"`
+	Plan                      string     `json:"plan,omitempty"`
+	EndUserLimitRequests      int        `json:"end_user_limit_requests"`
+	EndUserLimitWindowSeconds int        `json:"end_user_limit_window_seconds"`
+	AllowedCIDRs              []string   `json:"allowed_cidrs,omitempty"`
+	AllowedOrigins            []string   `json:"allowed_origins,omitempty"`
+	RequireSignature          bool       `json:"require_signature"`
+	ParentID                  string     `json:"parent_id,omitempty"`
+	OwnerName                 string     `json:"owner_name,omitempty"`
+	OwnerEmail                string     `json:"owner_email,omitempty"`
+	ExpiresAt                 *time.Time `json:"expires_at,omitempty"`
+	CreatedAt                 time.Time  `json:"created_at"`
+	DeletedAt                 *time.Time `json:"deleted_at,omitempty"`
 }
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Replicas spreads reads over read replicas of a primary database. A replica
+// that can't be reached or lags the primary by more than the allowed lag is
+// skipped until it catches up; while no replica is usable, reads go to the
+// primary. Writes always go to the primary.
+//
+// Replicas implements DBInterface, so it can stand in for the primary
+// wherever only reads are made.
+type Replicas struct {
+	primary  *DB
+	replicas []*replica
+	maxLag   time.Duration
+	interval time.Duration
+	next     uint32
+}
+
+type replica struct {
+	db      *DB
+	name    string
+	healthy atomic.Bool
+
+	// checked is set after the first Refresh, so a replica that is down at
+	// startup is logged too
+	checked bool
+}
+
+// NewReplicas opens a connection pool for each of replicaURLs, which must
+// use the primary's dialect, and checks them every interval. Replicas start
+// out unused until Refresh or Run finds them healthy.
+func NewReplicas(primary *DB, replicaURLs []string, maxLag, interval time.Duration, opts ...Option) (*Replicas, error) {
+	r := &Replicas{primary: primary, maxLag: maxLag, interval: interval}
+	names := replicaNames(replicaURLs)
+	for i, replicaURL := range replicaURLs {
+		if dialect := DialectForURL(replicaURL); dialect != primary.Dialect() {
+			r.Close()
+			return nil, fmt.Errorf("replica must be %s like the primary, got %s", primary.Dialect().Name(), dialect.Name())
+		}
+		db, err := open(replicaURL, opts...)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.replicas = append(r.replicas, &replica{db: db, name: names[i]})
+	}
+	return r, nil
+}
+
+// replicaName identifies a replica in logs and metrics by its host, without
+// credentials
+func replicaName(replicaURL string, index int) string {
+	if u, err := url.Parse(replicaURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "replica-" + strconv.Itoa(index)
+}
+
+// replicaNames names each of replicaURLs with replicaName. Replicas on the
+// same host, or a URL listed twice, get their index appended, since the
+// metrics of each name can only be registered once.
+func replicaNames(replicaURLs []string) []string {
+	names := make([]string, len(replicaURLs))
+	used := make(map[string]bool, len(replicaURLs))
+	for i, replicaURL := range replicaURLs {
+		name := replicaName(replicaURL, i)
+		if used[name] {
+			name += "-" + strconv.Itoa(i)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// RegisterMetrics exports the connection pool statistics of each replica,
+// labelled with db_name "replica:<host>"
+func (r *Replicas) RegisterMetrics() {
+	for _, rep := range r.replicas {
+		metrics.RegisterDatabase(rep.db.DB, "replica:"+rep.name)
+	}
+}
+
+// Run checks the replicas on every tick until ctx is cancelled
+func (r *Replicas) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh checks every replica's reachability and replication lag now and
+// logs replicas that are taken out of or put back into rotation
+func (r *Replicas) Refresh(ctx context.Context) {
+	for _, rep := range r.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, r.interval)
+		lag, err := rep.db.Dialect().replicationLag(checkCtx, rep.db.DB)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && lag > r.maxLag {
+			err = fmt.Errorf("replication lag %s exceeds %s", lag, r.maxLag)
+		}
+
+		if err == nil {
+			metrics.DatabaseReplicaLag.WithLabelValues(rep.name).Set(lag.Seconds())
+		}
+		healthy := err == nil
+		if rep.healthy.Swap(healthy) != healthy || (!healthy && !rep.checked) {
+			if healthy {
+				logging.FromContext(ctx).Info("Database replica in use", zap.String("replica", rep.name), zap.Duration("lag", lag))
+			} else {
+				logging.FromContext(ctx).Warn("Database replica skipped", zap.String("replica", rep.name), zap.Error(err))
+			}
+		}
+		rep.checked = true
+		up := 0.0
+		if healthy {
+			up = 1
+		}
+		metrics.DatabaseReplicaUp.WithLabelValues(rep.name).Set(up)
+	}
+}
+
+// reader returns the next healthy replica, or the primary if there is none
+func (r *Replicas) reader() *DB {
+	n := len(r.replicas)
+	start := int(atomic.AddUint32(&r.next, 1))
+	for i := 0; i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; rep.healthy.Load() {
+			return rep.db
+		}
+	}
+	return r.primary
+}
+
+// Dialect returns the primary's dialect, which all replicas share
+func (r *Replicas) Dialect() Dialect {
+	return r.primary.Dialect()
+}
+
+func (r *Replicas) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.reader().QueryRowContext(ctx, query, args...)
+}
+
+func (r *Replicas) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.reader().QueryContext(ctx, query, args...)
+}
+
+// ExecContext runs on the primary
+func (r *Replicas) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+// PingContext pings the primary; replicas are checked by Refresh
+func (r *Replicas) PingContext(ctx context.Context) error {
+	return r.primary.PingContext(ctx)
+}
+
+// Close closes the replicas' connection pools. The primary is left open.
+func (r *Replicas) Close() error {
+	var firstErr error
+	for _, rep := range r.replicas {
+		if err := rep.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var _ DBInterface = (*Replicas)(nil)
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"grpc-firstls/internal/metrics"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// RetryPolicy retries operations that failed with a transient error, waiting
+// an exponentially growing, jittered delay between attempts
+type RetryPolicy struct {
+	// Attempts is the total number of tries; 1 or less disables retries
+	Attempts int
+
+	// BaseDelay is the wait before the first retry, doubled for each
+	// following one up to MaxDelay (0 for no limit)
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't transient,
+// the attempts are used up or ctx is done. It returns fn's last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt < p.Attempts && IsTransient(err) && ctx.Err() == nil; attempt++ {
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		metrics.DatabaseRetries.Inc()
+		err = fn()
+	}
+	return err
+}
+
+// delay returns the wait before retry number attempt: half the exponential
+// delay plus a random share of the other half, so callers that failed
+// together don't retry together
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay > 0 && (p.MaxDelay == 0 || delay < p.MaxDelay) && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// IsTransient reports whether err is likely to go away on its own, such as a
+// dropped connection, a database that is restarting or out of connections,
+// or a deadlock. Errors in the query or its data are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"53", // insufficient resources, e.g. too many connections
+			"57": // operator intervention, e.g. the server is shutting down
+			return pqErr.Code != "57014" // query_canceled
+		case "40": // serialization failure or deadlock
+			return true
+		}
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, // too many connections
+			1205, // lock wait timeout
+			1213: // deadlock
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	transient := []error{
+		driver.ErrBadConn,
+		fmt.Errorf("failed to validate API key: %w", driver.ErrBadConn),
+		&pq.Error{Code: "08006"}, // connection_failure
+		&pq.Error{Code: "57P01"}, // admin_shutdown
+		&pq.Error{Code: "53300"}, // too_many_connections
+		&pq.Error{Code: "40P01"}, // deadlock_detected
+		&mysql.MySQLError{Number: 1213},
+		mysql.ErrInvalidConn,
+	}
+	for _, err := range transient {
+		assert.True(t, IsTransient(err), "%v", err)
+	}
+
+	permanent := []error{
+		nil,
+		sql.ErrNoRows,
+		context.Canceled,
+		context.DeadlineExceeded,
+		&pq.Error{Code: "23505"}, // unique_violation
+		&pq.Error{Code: "57014"}, // query_canceled
+		&mysql.MySQLError{Number: 1062},
+		errors.New("invalid API key"),
+	}
+	for _, err := range permanent {
+		assert.False(t, IsTransient(err), "%v", err)
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Attempts run out
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, 3, calls)
+
+	// Errors that aren't transient are returned at once
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return sql.ErrNoRows
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.Equal(t, 1, calls)
+
+	// The zero policy doesn't retry
+	calls = 0
+	_ = RetryPolicy{}.Do(context.Background(), func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_DoStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := RetryPolicy{Attempts: 5, BaseDelay: time.Hour}.Do(ctx, func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second, 40: time.Second} {
+		delay := policy.delay(attempt)
+		assert.GreaterOrEqual(t, delay, max/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, delay, max, "attempt %d", attempt)
+	}
+}
+
+func TestHealthMonitor(t *testing.T) {
+	var checkErr error
+	monitor := NewHealthMonitor(func(context.Context) error { return checkErr }, time.Minute, time.Second)
+
+	assert.Error(t, monitor.Check(context.Background()), "unchecked database counts as unavailable")
+
+	monitor.Refresh(context.Background())
+	assert.NoError(t, monitor.Check(context.Background()))
+
+	checkErr = errors.New("connection refused")
+	monitor.Refresh(context.Background())
+	assert.EqualError(t, monitor.Check(context.Background()), "connection refused")
+
+	checkErr = nil
+	monitor.Refresh(context.Background())
+	assert.NoError(t, monitor.Check(context.Background()))
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// SaveSnapshot writes a copy of a SQLite database to path. The copy is
+// written next to path and renamed over it, so a crash never leaves a
+// partial snapshot behind.
+func (db *DB) SaveSnapshot(ctx context.Context, path string) error {
+	if db.dialect != SQLite {
+		return fmt.Errorf("snapshots need SQLite, not %s", db.dialect.Name())
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale snapshot: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM INTO $1`, tmp); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the rows of a migrated SQLite database with those
+// of the snapshot at path and reports whether there was one. Snapshots taken
+// before later migrations restore too: columns the snapshot lacks get their
+// defaults.
+func (db *DB) RestoreSnapshot(ctx context.Context, path string) (bool, error) {
+	if db.dialect != SQLite {
+		return false, fmt.Errorf("snapshots need SQLite, not %s", db.dialect.Name())
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+
+	// ATTACH and the foreign key switch apply to one connection, so the
+	// statements below run on a dedicated one without rebinding
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS snapshot`, path); err != nil {
+		return false, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE snapshot`)
+
+	// Tables are copied in any order, so references are only checked once
+	// every row is back
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return false, err
+	}
+	defer conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`)
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT s.name FROM snapshot.sqlite_master s
+		JOIN main.sqlite_master m ON m.name = s.name AND m.type = 'table'
+		WHERE s.type = 'table' AND s.name NOT LIKE 'sqlite_%' AND s.name <> 'schema_migrations'
+	`)
+	if err != nil {
+		return false, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return false, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		var columns string
+		err := tx.QueryRowContext(ctx, `
+			SELECT group_concat('"' || s.name || '"', ', ')
+			FROM pragma_table_info(?, 'snapshot') s
+			JOIN pragma_table_info(?, 'main') m ON m.name = s.name
+		`, table, table).Scan(&columns)
+		if err != nil {
+			return false, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM main."`+table+`"`); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", table, err)
+		}
+		query := `INSERT INTO main."` + table + `" (` + columns + `) SELECT ` + columns + ` FROM snapshot."` + table + `"`
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", table, err)
+		}
+	}
+
+	var violations int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_foreign_key_check`).Scan(&violations); err != nil {
+		return false, err
+	}
+	if violations > 0 {
+		return false, fmt.Errorf("snapshot has %d rows referencing missing rows", violations)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Snapshotter saves a SQLite database to a file at an interval, so an
+// in-memory database survives restarts
+type Snapshotter struct {
+	db       *DB
+	path     string
+	interval time.Duration
+}
+
+// NewSnapshotter saves db to path every interval
+func NewSnapshotter(db *DB, path string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{db: db, path: path, interval: interval}
+}
+
+// Run saves a snapshot on every tick until ctx is cancelled. The final
+// snapshot is left to the caller, once the workers writing to the database
+// have stopped.
+func (s *Snapshotter) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error("Failed to save snapshot", zap.String("path", s.path), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Save writes a snapshot now
+func (s *Snapshotter) Save(ctx context.Context) error {
+	return s.db.SaveSnapshot(ctx, s.path)
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemoryDB(t *testing.T) *DB {
+	db, err := NewConnection("sqlite::memory:", WithPool(PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+func TestSnapshot_SaveAndRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "standalone.db")
+
+	source := newMemoryDB(t)
+	var keyID string
+	err := source.QueryRow(`INSERT INTO api_keys (key_hash, name) VALUES ($1, $2) RETURNING id`, "hash", "Snapshot").Scan(&keyID)
+	require.NoError(t, err)
+	_, err = source.Exec(`INSERT INTO limit_overrides (api_key_id, rate_limit_requests, expires_at) VALUES ($1, 50, '2100-01-01 00:00:00.000')`, keyID)
+	require.NoError(t, err)
+	require.NoError(t, source.SaveSnapshot(ctx, path))
+
+	target := newMemoryDB(t)
+	restored, err := target.RestoreSnapshot(ctx, path)
+	require.NoError(t, err)
+	assert.True(t, restored)
+
+	var name string
+	require.NoError(t, target.QueryRow(`SELECT name FROM api_keys WHERE id = $1`, keyID).Scan(&name))
+	assert.Equal(t, "Snapshot", name)
+	var keys, overrides int
+	require.NoError(t, target.QueryRow(`SELECT COUNT(*) FROM api_keys`).Scan(&keys))
+	require.NoError(t, target.QueryRow(`SELECT COUNT(*) FROM limit_overrides`).Scan(&overrides))
+	assert.Equal(t, 2, keys, "the sample key and the new key, not a second sample key")
+	assert.Equal(t, 1, overrides)
+
+	// Foreign keys are enforced again afterwards
+	_, err = target.Exec(`INSERT INTO limit_overrides (api_key_id, rate_limit_requests, expires_at) VALUES ($1, 50, '2100-01-01 00:00:00.000')`, "missing")
+	assert.Error(t, err)
+}
+
+func TestSnapshot_RestoreMissing(t *testing.T) {
+	restored, err := newMemoryDB(t).RestoreSnapshot(context.Background(), filepath.Join(t.TempDir(), "missing.db"))
+	require.NoError(t, err)
+	assert.False(t, restored)
+}
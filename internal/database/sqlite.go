@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// sqliteTimeFormat matches strftime('%Y-%m-%d %H:%M:%f'), so stored times
+// compare correctly as text
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// sqliteNow is the current UTC time in sqliteTimeFormat
+const sqliteNow = "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+// Rebind uses ? placeholders, since SQLite treats $1 as a named parameter,
+// and stores times as UTC text in sqliteTimeFormat
+func (sqliteDialect) Rebind(query string, args []interface{}) (string, []interface{}) {
+	return rebindQuestionMarks(query, args, sqliteValue)
+}
+
+func sqliteValue(arg interface{}) interface{} {
+	switch v := arg.(type) {
+	case time.Time:
+		return v.UTC().Format(sqliteTimeFormat)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC().Format(sqliteTimeFormat)
+	}
+	return arg
+}
+
+func (sqliteDialect) Now() string { return sqliteNow }
+
+func (sqliteDialect) AddSeconds(expr, placeholder string) string {
+	return "strftime('%Y-%m-%d %H:%M:%f', " + expr + ", " + placeholder + " || ' seconds')"
+}
+
+func (sqliteDialect) DaysAgo(placeholder string) string {
+	return "date('now', '-' || " + placeholder + " || ' days')"
+}
+
+// Bucket relies on integer division, so the bucket size must be bound as an
+// integer
+func (sqliteDialect) Bucket(expr, placeholder string) string {
+	return "CAST(strftime('%s', " + expr + ") AS INTEGER) / " + placeholder + " * " + placeholder
+}
+
+func (sqliteDialect) Text(column string) string { return column }
+
+func (sqliteDialect) In(column, placeholder string) string {
+	return column + " IN (" + placeholder + ")"
+}
+
+func (sqliteDialect) List(values []string) interface{} { return questionMarkList(values) }
+
+func (sqliteDialect) Array(a interface{}) interface{} { return newJSONArray(a) }
+
+func (sqliteDialect) Returning() bool { return true }
+
+// connector opens the database file named by a sqlite:path URL, e.g.
+// sqlite:rate_limiter.db or sqlite:///var/lib/rate-limiter/keys.db. The
+// file is created if it doesn't exist.
+func (sqliteDialect) connector(databaseURL string) (driver.Connector, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(databaseURL, "sqlite:"), "//")
+	if path == "" {
+		return nil, fmt.Errorf("database URL has no file path")
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	dsn := "file:" + path + separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	return sqliteConnector{dsn: dsn}, nil
+}
+
+func (sqliteDialect) driver() driver.Driver { return &sqlite.Driver{} }
+
+func (sqliteDialect) schema() string { return "" }
+
+func (sqliteDialect) migrations() fs.FS {
+	migrations, err := fs.Sub(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
+
+// replicationLag only checks that db is reachable: SQLite has no
+// replication of its own, so a replica is a copy kept current by other means
+func (sqliteDialect) replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	return 0, db.PingContext(ctx)
+}
+
+// sqliteConnector opens connections by DSN; the driver has no connector of
+// its own
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.Driver().Open(c.dsn)
+}
+
+func (sqliteConnector) Driver() driver.Driver { return &sqlite.Driver{} }
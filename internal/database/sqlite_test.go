@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite_Migrate(t *testing.T) {
+	db, err := NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	applied, err := db.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 17, applied)
+	assert.NoError(t, db.CheckSchema(ctx))
+
+	applied, err = db.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+
+	var plans int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM plans WHERE name IN ($1, $2)`, "free", "pro").Scan(&plans))
+	assert.Equal(t, 2, plans)
+}
+
+func TestSQLite_Times(t *testing.T) {
+	db, err := NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.InitSchema())
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	var id string
+	err = db.QueryRow(`INSERT INTO api_keys (key_hash, name, expires_at) VALUES ($1, $2, $3) RETURNING id`, "hash", "Times", expiresAt).Scan(&id)
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+
+	var stored, createdAt time.Time
+	var future bool
+	err = db.QueryRow(`SELECT expires_at, created_at, expires_at > `+SQLite.Now()+` FROM api_keys WHERE id = $1`, id).Scan(&stored, &createdAt, &future)
+	require.NoError(t, err)
+	assert.True(t, stored.Equal(expiresAt))
+	assert.WithinDuration(t, time.Now(), createdAt, time.Minute)
+	assert.True(t, future)
+}
+
+func TestSQLite_InvalidURL(t *testing.T) {
+	_, err := NewConnection("sqlite://")
+	assert.ErrorContains(t, err, "no file path")
+}
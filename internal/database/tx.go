@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Tx is a transaction that rewrites queries for its dialect, like DB
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// Dialect returns the SQL dialect of the transaction's database
+func (tx *Tx) Dialect() Dialect {
+	return tx.dialect
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query, args = tx.dialect.Rebind(query, args)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = tx.dialect.Rebind(query, args)
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args = tx.dialect.Rebind(query, args)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+// Savepoint runs fn under the savepoint name. If fn returns an error its
+// statements are rolled back to the savepoint and the transaction stays
+// usable; on Postgres a failed statement otherwise aborts the whole
+// transaction.
+func (tx *Tx) Savepoint(ctx context.Context, name string, fn func() error) error {
+	if _, err := tx.Tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if err := fn(); err != nil {
+		if _, rollbackErr := tx.Tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr)
+		}
+		return err
+	}
+	if _, err := tx.Tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// ErrNoTransactions is returned by RunInTx for connections that can't begin
+// a transaction
+var ErrNoTransactions = errors.New("database does not support transactions")
+
+// RunInTx runs fn in a transaction on db, a *DB or plain *sql.DB. The
+// transaction is committed if fn returns nil and rolled back if it returns
+// an error or panics, so fn's statements take effect together or not at
+// all. Called with a *Tx, fn joins that transaction instead.
+func RunInTx(ctx context.Context, db Querier, fn func(tx *Tx) error) error {
+	if tx, ok := db.(*Tx); ok {
+		return fn(tx)
+	}
+	beginner, ok := db.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return ErrNoTransactions
+	}
+
+	sqlTx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer sqlTx.Rollback()
+
+	if err := fn(&Tx{Tx: sqlTx, dialect: DialectOf(db)}); err != nil {
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunInTx(t *testing.T) {
+	db, err := NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE names (name TEXT UNIQUE)`)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	count := func() int {
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM names`).Scan(&n))
+		return n
+	}
+	insert := func(q Querier, name string) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO names (name) VALUES ($1)`, name)
+		return err
+	}
+
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		require.NoError(t, insert(tx, "a"))
+		// Nested calls join the transaction
+		return RunInTx(ctx, tx, func(nested *Tx) error {
+			assert.Same(t, tx, nested)
+			return insert(nested, "b")
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count())
+
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		require.NoError(t, insert(tx, "c"))
+		return insert(tx, "a")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, count(), "a failed transaction is rolled back")
+
+	failure := errors.New("failure")
+	assert.ErrorIs(t, RunInTx(ctx, db, func(tx *Tx) error {
+		require.NoError(t, insert(tx, "d"))
+		return failure
+	}), failure)
+	assert.Equal(t, 2, count())
+
+	assert.Panics(t, func() {
+		RunInTx(ctx, db, func(tx *Tx) error {
+			require.NoError(t, insert(tx, "e"))
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 2, count(), "a panic rolls back")
+
+	assert.ErrorIs(t, RunInTx(ctx, &Replicas{primary: db}, func(tx *Tx) error { return nil }), ErrNoTransactions)
+}
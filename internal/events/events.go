@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Event types emitted for API key lifecycle changes and for keys reaching
+// or exceeding their limits
+const (
+	APIKeyCreated     = "api_key.created"
+	APIKeyUpdated     = "api_key.updated"
+	APIKeyRotated     = "api_key.rotated"
+	APIKeyDeactivated = "api_key.deactivated"
+	APIKeyRestored    = "api_key.restored"
+	APIKeyPurged      = "api_key.purged"
+	APIKeyExpired     = "api_key.expired"
+	RateLimitExceeded = "rate_limit.exceeded"
+	QuotaExceeded     = "quota.exceeded"
+	// A key's usage reached one of its alert thresholds
+	LimitThresholdReached = "limit.threshold_reached"
+)
+
+// Event describes something that happened to an API key
+type Event struct {
+	// Unique ID of the event, set for events delivered by webhook
+	ID        string                 `json:"id,omitempty"`
+	Type      string                 `json:"type"`
+	APIKeyID  string                 `json:"api_key_id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Publisher delivers events to interested consumers
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Publishers delivers each event to every one of its publishers and returns
+// the first error
+type Publishers []Publisher
+
+func (p Publishers) Publish(ctx context.Context, event Event) error {
+	var first error
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// LogPublisher writes events to a logger
+type LogPublisher struct {
+	logger *zap.Logger
+}
+
+func NewLogPublisher(logger *zap.Logger) *LogPublisher {
+	return &LogPublisher{logger: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event Event) error {
+	p.logger.Info("event",
+		zap.String("type", event.Type),
+		zap.String("api_key_id", event.APIKeyID),
+		zap.Time("timestamp", event.Timestamp),
+		zap.Any("data", event.Data),
+	)
+	return nil
+}
+
+// Ensure LogPublisher and Publishers implement Publisher
+var (
+	_ Publisher = (*LogPublisher)(nil)
+	_ Publisher = Publishers(nil)
+)
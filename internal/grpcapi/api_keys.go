@@ -0,0 +1,275 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type apiKeyServer struct {
+	ratelimitv1.UnimplementedAPIKeyServiceServer
+
+	apiKeyService       services.APIKeyServiceInterface
+	rotationGracePeriod time.Duration
+}
+
+func (s *apiKeyServer) CreateAPIKey(ctx context.Context, request *ratelimitv1.CreateAPIKeyRequest) (*ratelimitv1.CreateAPIKeyResponse, error) {
+	params, err := s.createParams(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, err := s.apiKeyService.CreateAPIKey(ctx, params)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create API key: %v", err)
+	}
+	return &ratelimitv1.CreateAPIKeyResponse{
+		ApiKey:        apiKey,
+		KeyPrefix:     services.KeyPrefix(apiKey),
+		SigningSecret: params.SigningSecret,
+	}, nil
+}
+
+// createParams validates request and fills in its defaults like the admin
+// API does
+func (s *apiKeyServer) createParams(ctx context.Context, request *ratelimitv1.CreateAPIKeyRequest) (services.CreateAPIKeyParams, error) {
+	invalid := func(message string) (services.CreateAPIKeyParams, error) {
+		return services.CreateAPIKeyParams{}, status.Error(codes.InvalidArgument, message)
+	}
+
+	if request.Name == "" {
+		return invalid("name is required")
+	}
+	if request.EndUserLimitRequests < 0 || request.EndUserLimitWindowSeconds < 0 {
+		return invalid("end user limits must not be negative")
+	}
+	if request.OwnerEmail != "" {
+		if _, err := mail.ParseAddress(request.OwnerEmail); err != nil {
+			return invalid("owner_email must be an email address")
+		}
+	}
+
+	var expiresAt *time.Time
+	if request.ExpiresAt != nil {
+		t := request.ExpiresAt.AsTime()
+		if !t.After(time.Now()) {
+			return invalid("expires_at must be in the future")
+		}
+		expiresAt = &t
+	}
+
+	allowedCIDRs, err := services.NormalizeCIDRs(request.AllowedCidrs)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	allowedOrigins, err := services.NormalizeOrigins(request.AllowedOrigins)
+	if err != nil {
+		return invalid(err.Error())
+	}
+
+	// Outside the admin API, which takes a project, keys of an organization
+	// can only be created as sub-keys of its keys
+	organizationID := callerOrganization(ctx)
+	if organizationID != "" && request.ParentKey == "" {
+		return services.CreateAPIKeyParams{}, status.Error(codes.PermissionDenied, "Credentials scoped to an organization can only create sub-keys here; create other keys through the admin API with a project_id")
+	}
+
+	var parentID, projectID string
+	if request.ParentKey != "" {
+		if request.RateLimitRequests != 0 || request.RateLimitWindowSeconds != 0 || request.PlanId != "" ||
+			request.EndUserLimitRequests != 0 || request.EndUserLimitWindowSeconds != 0 {
+			return invalid("sub-keys inherit their limits and plan from the parent key")
+		}
+
+		parent, err := s.apiKeyService.GetAPIKey(ctx, request.ParentKey)
+		if err != nil {
+			return services.CreateAPIKeyParams{}, keyError(err, "Parent API key not found", "Failed to create API key")
+		}
+		if organizationID != "" && parent.OrganizationID != organizationID {
+			return services.CreateAPIKeyParams{}, status.Error(codes.NotFound, "Parent API key not found")
+		}
+		if parent.ParentID != "" || !parent.IsActive {
+			return invalid("parent_key must be an active key that is not itself a sub-key")
+		}
+		parentID, projectID = parent.ID, parent.ProjectID
+	}
+
+	// Keys on a plan or a parent inherit its limits instead of the defaults
+	rateLimitRequests, rateLimitWindowSeconds := int(request.RateLimitRequests), int(request.RateLimitWindowSeconds)
+	if request.PlanId == "" && parentID == "" {
+		if rateLimitRequests <= 0 {
+			rateLimitRequests = 100
+		}
+		if rateLimitWindowSeconds <= 0 {
+			rateLimitWindowSeconds = 3600
+		}
+	}
+
+	var signingSecret string
+	if request.RequireSignature {
+		signingSecret, err = services.GenerateSigningSecret()
+		if err != nil {
+			return services.CreateAPIKeyParams{}, status.Errorf(codes.Internal, "Failed to create API key: %v", err)
+		}
+	}
+
+	return services.CreateAPIKeyParams{
+		Name:                   request.Name,
+		RateLimitRequests:      rateLimitRequests,
+		RateLimitWindowSeconds: rateLimitWindowSeconds,
+		PlanID:                 request.PlanId,
+
+		EndUserLimitRequests:      int(request.EndUserLimitRequests),
+		EndUserLimitWindowSeconds: int(request.EndUserLimitWindowSeconds),
+		ExpiresAt:                 expiresAt,
+		AllowedCIDRs:              allowedCIDRs,
+		AllowedOrigins:            allowedOrigins,
+		SigningSecret:             signingSecret,
+		ParentID:                  parentID,
+		OwnerName:                 request.OwnerName,
+		OwnerEmail:                request.OwnerEmail,
+		ProjectID:                 projectID,
+	}, nil
+}
+
+func (s *apiKeyServer) GetAPIKey(ctx context.Context, request *ratelimitv1.GetAPIKeyRequest) (*ratelimitv1.APIKey, error) {
+	apiKey, err := s.scopedKey(ctx, request.Key)
+	if err != nil {
+		return nil, err
+	}
+	return apiKeyMessage(apiKey), nil
+}
+
+func (s *apiKeyServer) ListAPIKeys(ctx context.Context, request *ratelimitv1.ListAPIKeysRequest) (*ratelimitv1.ListAPIKeysResponse, error) {
+	apiKeys, err := s.apiKeyService.ListAPIKeys(ctx, services.APIKeyFilter{
+		Owner:          request.Owner,
+		OrganizationID: callerOrganization(ctx),
+		IncludeDeleted: request.IncludeDeleted,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to list API keys: %v", err)
+	}
+
+	response := &ratelimitv1.ListAPIKeysResponse{ApiKeys: make([]*ratelimitv1.APIKey, 0, len(apiKeys))}
+	for _, apiKey := range apiKeys {
+		response.ApiKeys = append(response.ApiKeys, apiKeyMessage(apiKey))
+	}
+	return response, nil
+}
+
+// DeactivateAPIKey soft-deletes the key; RestoreAPIKey brings it back
+func (s *apiKeyServer) DeactivateAPIKey(ctx context.Context, request *ratelimitv1.DeactivateAPIKeyRequest) (*ratelimitv1.DeactivateAPIKeyResponse, error) {
+	if callerOrganization(ctx) != "" {
+		if _, err := s.scopedKey(ctx, request.Key); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.apiKeyService.DeleteAPIKey(ctx, request.Key); err != nil {
+		return nil, keyError(err, "API key not found", "Failed to delete API key")
+	}
+	return &ratelimitv1.DeactivateAPIKeyResponse{}, nil
+}
+
+func (s *apiKeyServer) RestoreAPIKey(ctx context.Context, request *ratelimitv1.RestoreAPIKeyRequest) (*ratelimitv1.APIKey, error) {
+	if callerOrganization(ctx) != "" {
+		if _, err := s.scopedKey(ctx, request.Key); err != nil {
+			return nil, err
+		}
+	}
+	apiKey, err := s.apiKeyService.RestoreAPIKey(ctx, request.Key)
+	if err != nil {
+		return nil, keyError(err, "API key not found or not deleted", "Failed to restore API key")
+	}
+	return apiKeyMessage(apiKey), nil
+}
+
+func (s *apiKeyServer) RotateAPIKey(ctx context.Context, request *ratelimitv1.RotateAPIKeyRequest) (*ratelimitv1.RotateAPIKeyResponse, error) {
+	if request.GracePeriodSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "grace_period_seconds must not be negative")
+	}
+	gracePeriod := s.rotationGracePeriod
+	if request.GracePeriodSeconds > 0 {
+		gracePeriod = time.Duration(request.GracePeriodSeconds) * time.Second
+	}
+
+	if callerOrganization(ctx) != "" {
+		if _, err := s.scopedKey(ctx, request.Key); err != nil {
+			return nil, err
+		}
+	}
+	rotated, err := s.apiKeyService.RotateAPIKey(ctx, request.Key, gracePeriod)
+	if err != nil {
+		return nil, keyError(err, "API key not found", "Failed to rotate API key")
+	}
+	return &ratelimitv1.RotateAPIKeyResponse{
+		Id:                   rotated.ID,
+		ApiKey:               rotated.APIKey,
+		KeyPrefix:            rotated.KeyPrefix,
+		PreviousKeyExpiresAt: timestamppb.New(rotated.PreviousKeyExpiresAt),
+	}, nil
+}
+
+// scopedKey returns the key that key refers to, or NOT_FOUND when it
+// doesn't exist or the caller's credential is scoped to another
+// organization, so scoped callers can't tell other tenants' keys exist
+func (s *apiKeyServer) scopedKey(ctx context.Context, key string) (*database.APIKey, error) {
+	apiKey, err := s.apiKeyService.GetAPIKey(ctx, key)
+	if err != nil {
+		return nil, keyError(err, "API key not found", "Failed to get API key")
+	}
+	if organizationID := callerOrganization(ctx); organizationID != "" && apiKey.OrganizationID != organizationID {
+		return nil, status.Error(codes.NotFound, "API key not found")
+	}
+	return apiKey, nil
+}
+
+// keyError maps services.ErrAPIKeyNotFound to NOT_FOUND and anything else
+// to INTERNAL
+func keyError(err error, notFound, failed string) error {
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		return status.Error(codes.NotFound, notFound)
+	}
+	return status.Errorf(codes.Internal, "%s: %v", failed, err)
+}
+
+func apiKeyMessage(apiKey *database.APIKey) *ratelimitv1.APIKey {
+	return &ratelimitv1.APIKey{
+		Id:                        apiKey.ID,
+		KeyPrefix:                 apiKey.KeyPrefix,
+		Name:                      apiKey.Name,
+		RateLimitRequests:         int32(apiKey.RateLimitRequests),
+		RateLimitWindowSeconds:    int32(apiKey.RateLimitWindowSeconds),
+		IsActive:                  apiKey.IsActive,
+		PlanId:                    apiKey.PlanID,
+		ParentId:                  apiKey.ParentID,
+		OwnerName:                 apiKey.OwnerName,
+		OwnerEmail:                apiKey.OwnerEmail,
+		AllowedCidrs:              apiKey.AllowedCIDRs,
+		AllowedOrigins:            apiKey.AllowedOrigins,
+		RequireSignature:          apiKey.RequireSignature,
+		EndUserLimitRequests:      int32(apiKey.EndUserLimitRequests),
+		EndUserLimitWindowSeconds: int32(apiKey.EndUserLimitWindowSeconds),
+		ExpiresAt:                 timestamp(apiKey.ExpiresAt),
+		LastUsedAt:                timestamp(apiKey.LastUsedAt),
+		CreatedAt:                 timestamppb.New(apiKey.CreatedAt),
+		UpdatedAt:                 timestamppb.New(apiKey.UpdatedAt),
+		DeletedAt:                 timestamp(apiKey.DeletedAt),
+	}
+}
+
+// timestamp converts an optional time, leaving the field unset for nil
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
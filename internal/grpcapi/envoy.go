@@ -0,0 +1,135 @@
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// EnvoyDescriptors names the descriptor entries Envoy's rate limit service
+// protocol reads the API key, end user and client IP from
+type EnvoyDescriptors struct {
+	APIKey   string
+	EndUser  string
+	ClientIP string
+}
+
+// DefaultEnvoyDescriptors matches Envoy's remote_address action and
+// request_headers actions with descriptor_key api_key and end_user
+var DefaultEnvoyDescriptors = EnvoyDescriptors{
+	APIKey:   "api_key",
+	EndUser:  "end_user",
+	ClientIP: "remote_address",
+}
+
+// envoyRateLimitServer answers Envoy's (and Istio's) ShouldRateLimit calls
+// with the same checks as CheckRateLimit. Every descriptor naming an API key
+// is one check of that key; descriptors without one aren't limited.
+type envoyRateLimitServer struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	checker     *rateLimitServer
+	descriptors EnvoyDescriptors
+}
+
+// envoyCheck is what a descriptor asks to be checked
+type envoyCheck struct {
+	apiKey, endUser, clientIP string
+}
+
+func (s *envoyRateLimitServer) ShouldRateLimit(ctx context.Context, request *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	response := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+
+	// Identical descriptors in one call count as a single request
+	checked := make(map[envoyCheck]*rlsv3.RateLimitResponse_DescriptorStatus)
+	for _, descriptor := range request.Descriptors {
+		var check envoyCheck
+		for _, entry := range descriptor.Entries {
+			switch entry.Key {
+			case s.descriptors.APIKey:
+				check.apiKey = entry.Value
+			case s.descriptors.EndUser:
+				check.endUser = entry.Value
+			case s.descriptors.ClientIP:
+				check.clientIP = entry.Value
+			}
+		}
+
+		descriptorStatus, ok := checked[check]
+		if !ok {
+			var err error
+			descriptorStatus, err = s.check(ctx, check)
+			if err != nil {
+				return nil, err
+			}
+			checked[check] = descriptorStatus
+		}
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			response.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		response.Statuses = append(response.Statuses, descriptorStatus)
+	}
+	return response, nil
+}
+
+// check runs CheckRateLimit for a descriptor. Keys that are rejected outright,
+// e.g. invalid ones, are reported over the limit, since the protocol has no
+// other way to deny a request. Errors are left to Envoy's failure mode.
+func (s *envoyRateLimitServer) check(ctx context.Context, check envoyCheck) (*rlsv3.RateLimitResponse_DescriptorStatus, error) {
+	if check.apiKey == "" {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}, nil
+	}
+
+	result, err := s.checker.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{
+		ApiKey:    check.apiKey,
+		EndUserId: check.endUser,
+		ClientIp:  check.clientIP,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unauthenticated, codes.PermissionDenied, codes.ResourceExhausted:
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OVER_LIMIT}, nil
+	default:
+		return nil, err
+	}
+
+	rateLimit := result.RateLimit
+	if result.Reason == "end_user_limited" {
+		rateLimit = result.EndUserRateLimit
+	}
+	descriptorStatus := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code:               rlsv3.RateLimitResponse_OK,
+		CurrentLimit:       envoyLimit(rateLimit),
+		LimitRemaining:     uint32(rateLimit.Remaining),
+		DurationUntilReset: durationpb.New(time.Duration(rateLimit.RetryAfterSeconds) * time.Second),
+	}
+	if !result.Allowed {
+		descriptorStatus.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	return descriptorStatus, nil
+}
+
+// envoyUnits are the windows Envoy can express a limit in
+var envoyUnits = map[int64]rlsv3.RateLimitResponse_RateLimit_Unit{
+	1:     rlsv3.RateLimitResponse_RateLimit_SECOND,
+	60:    rlsv3.RateLimitResponse_RateLimit_MINUTE,
+	3600:  rlsv3.RateLimitResponse_RateLimit_HOUR,
+	86400: rlsv3.RateLimitResponse_RateLimit_DAY,
+}
+
+// envoyLimit converts a limit to Envoy's requests per unit, which it uses for
+// the RateLimit-* headers it can add to responses. Windows that aren't
+// exactly one unit long have no equivalent and are left out.
+func envoyLimit(rateLimit *ratelimitv1.RateLimit) *rlsv3.RateLimitResponse_RateLimit {
+	unit, ok := envoyUnits[rateLimit.WindowSeconds]
+	if !ok {
+		return nil
+	}
+	return &rlsv3.RateLimitResponse_RateLimit{RequestsPerUnit: uint32(rateLimit.Limit), Unit: unit}
+}
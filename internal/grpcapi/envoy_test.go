@@ -0,0 +1,108 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+
+	ratelimitcommonv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func descriptor(entries ...string) *ratelimitcommonv3.RateLimitDescriptor {
+	descriptor := &ratelimitcommonv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		descriptor.Entries = append(descriptor.Entries, &ratelimitcommonv3.RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+	}
+	return descriptor
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	conn := newTestServer(t)
+	ctx := context.Background()
+	envoy := rlsv3.NewRateLimitServiceClient(conn)
+
+	created, err := ratelimitv1.NewAPIKeyServiceClient(conn).CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{
+		Name:                   "Envoy",
+		RateLimitRequests:      3,
+		RateLimitWindowSeconds: 60,
+		EndUserLimitRequests:   1,
+	})
+	require.NoError(t, err)
+
+	request := &rlsv3.RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{
+			descriptor("api_key", created.ApiKey, "remote_address", "192.0.2.1"),
+			descriptor("generic_key", "unrelated"),
+		},
+	}
+	response, err := envoy.ShouldRateLimit(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, response.OverallCode)
+	require.Len(t, response.Statuses, 2)
+	assert.Equal(t, uint32(3), response.Statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_MINUTE, response.Statuses[0].CurrentLimit.Unit)
+	assert.Equal(t, uint32(2), response.Statuses[0].LimitRemaining)
+	assert.Positive(t, response.Statuses[0].DurationUntilReset.AsDuration())
+	// Descriptors without an API key aren't limited
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, response.Statuses[1].Code)
+	assert.Nil(t, response.Statuses[1].CurrentLimit)
+
+	// The end user's share runs out first
+	response, err = envoy.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{
+			descriptor("api_key", created.ApiKey, "end_user", "user-1"),
+			descriptor("api_key", created.ApiKey, "end_user", "user-1"),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, response.OverallCode, "identical descriptors count once")
+	response, err = envoy.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{descriptor("api_key", created.ApiKey, "end_user", "user-1")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	assert.Equal(t, uint32(1), response.Statuses[0].CurrentLimit.RequestsPerUnit)
+
+	response, err = envoy.ShouldRateLimit(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, response.Statuses[0].Code)
+	assert.Equal(t, uint32(0), response.Statuses[0].LimitRemaining)
+
+	// Invalid keys are denied, as the protocol has no other answer
+	response, err = envoy.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{descriptor("api_key", "ak_invalid")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+}
+
+func TestShouldRateLimit_Descriptors(t *testing.T) {
+	conn := newTestServer(t, WithEnvoyDescriptors(EnvoyDescriptors{APIKey: "x-api-key", ClientIP: "client_ip"}), WithTrustedProxies([]string{"127.0.0.0/8"}))
+	ctx := context.Background()
+
+	created, err := ratelimitv1.NewAPIKeyServiceClient(conn).CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{
+		Name:         "Office",
+		AllowedCidrs: []string{"10.0.0.0/8"},
+	})
+	require.NoError(t, err)
+
+	envoy := rlsv3.NewRateLimitServiceClient(conn)
+	response, err := envoy.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{descriptor("x-api-key", created.ApiKey, "client_ip", "10.1.2.3")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, response.OverallCode)
+	assert.NotNil(t, response.Statuses[0].CurrentLimit)
+
+	response, err = envoy.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitcommonv3.RateLimitDescriptor{descriptor("x-api-key", created.ApiKey, "client_ip", "192.0.2.1")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+}
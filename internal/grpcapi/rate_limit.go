@@ -0,0 +1,270 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type rateLimitServer struct {
+	ratelimitv1.UnimplementedRateLimitServiceServer
+
+	apiKeyService    services.APIKeyServiceInterface
+	rateLimitService services.RateLimitServiceInterface
+	usageRecorder    services.UsageRecorder
+	usageCounter     services.UsageCounter
+	limitAlerter     services.LimitAlerter
+	authFailures     services.AuthFailureTracker
+	// Callers whose client_ip is trusted, e.g. Envoy or another proxy
+	trustedProxies []*net.IPNet
+}
+
+// CheckRateLimit applies the checks the REST API's middleware applies to a
+// request, in the same order
+func (s *rateLimitServer) CheckRateLimit(ctx context.Context, request *ratelimitv1.CheckRateLimitRequest) (*ratelimitv1.CheckRateLimitResponse, error) {
+	clientIP := s.clientIP(ctx, request.ClientIp)
+	apiKey, err := s.validate(ctx, request.ApiKey, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if !middleware.ClientIPAllowed(clientIP, apiKey.AllowedCIDRs) {
+		return nil, status.Error(codes.PermissionDenied, "This API key may not be used from your network")
+	}
+	// Signatures cover the HTTP request, which there is none of here
+	if apiKey.RequireSignature {
+		return nil, status.Error(codes.PermissionDenied, "This API key only accepts signed HTTP requests")
+	}
+
+	if s.usageRecorder != nil {
+		s.usageRecorder.RecordUse(apiKey.ID)
+	}
+
+	result, err := s.rateLimitService.CheckRateLimit(ctx, apiKey)
+	if err != nil {
+		logging.FromContext(ctx).Error("Rate limit check failed", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "Unable to check rate limit")
+	}
+	response := &ratelimitv1.CheckRateLimitResponse{
+		Allowed:   result.Allowed && !result.Penalized(),
+		RateLimit: rateLimitMessage(result),
+		ApiKeyId:  apiKey.ID,
+	}
+	if result.Penalized() {
+		response.Reason = "penalized"
+		return response, nil
+	}
+	if !result.Allowed {
+		response.Reason = "limited"
+		if s.limitAlerter != nil {
+			eventType := events.RateLimitExceeded
+			if result.QuotaExceeded {
+				eventType = events.QuotaExceeded
+			}
+			if err := s.limitAlerter.NotifyLimitExceeded(ctx, apiKey, eventType, result); err != nil {
+				logging.FromContext(ctx).Error("Failed to raise limit alert", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+			}
+		}
+		return response, nil
+	}
+	if result.ThresholdCrossed && s.limitAlerter != nil {
+		if err := s.limitAlerter.NotifyLimitExceeded(ctx, apiKey, events.LimitThresholdReached, result); err != nil {
+			logging.FromContext(ctx).Error("Failed to raise threshold alert", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+		}
+	}
+
+	// Refusals for the organization's ceiling report its window instead of
+	// the key's
+	if apiKey.OrganizationLimitRequests > 0 {
+		organizationResult, err := s.rateLimitService.CheckOrganizationLimit(ctx, apiKey)
+		if err != nil {
+			logging.FromContext(ctx).Error("Rate limit check failed", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+			return nil, status.Error(codes.Unavailable, "Unable to check rate limit")
+		}
+		if !organizationResult.Allowed {
+			response.Allowed = false
+			response.Reason = "organization_limited"
+			response.RateLimit = rateLimitMessage(organizationResult)
+			return response, nil
+		}
+	}
+
+	if request.EndUserId != "" && apiKey.EndUserLimitRequests > 0 {
+		endUserResult, err := s.rateLimitService.CheckEndUserLimit(ctx, apiKey, request.EndUserId)
+		if err != nil {
+			logging.FromContext(ctx).Error("Rate limit check failed", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+			return nil, status.Error(codes.Unavailable, "Unable to check rate limit")
+		}
+		response.EndUserRateLimit = rateLimitMessage(endUserResult)
+		if !endUserResult.Allowed {
+			response.Allowed = false
+			response.Reason = "end_user_limited"
+			return response, nil
+		}
+	}
+
+	// Usage counting must never fail the check
+	if s.usageCounter != nil {
+		if err := s.usageCounter.IncrementUsage(ctx, apiKey.ID); err != nil {
+			logging.FromContext(ctx).Error("Failed to count usage", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+		}
+	}
+	return response, nil
+}
+
+func (s *rateLimitServer) GetStatus(ctx context.Context, request *ratelimitv1.GetStatusRequest) (*ratelimitv1.GetStatusResponse, error) {
+	apiKey, err := s.validate(ctx, request.ApiKey, s.clientIP(ctx, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.rateLimitService.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to get rate limit status", zap.String("key_prefix", apiKey.KeyPrefix), zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "Unable to get rate limit status")
+	}
+	return &ratelimitv1.GetStatusResponse{
+		ApiKey:    apiKeyMessage(apiKey),
+		RateLimit: rateLimitMessage(result),
+	}, nil
+}
+
+// validate returns the key a call was made with, locking out clients at
+// clientIP that keep sending invalid keys as the REST API does
+func (s *rateLimitServer) validate(ctx context.Context, key, clientIP string) (*database.APIKey, error) {
+	if key == "" {
+		return nil, status.Error(codes.InvalidArgument, "api_key is required")
+	}
+
+	trackFailures := s.authFailures != nil && clientIP != ""
+	if trackFailures {
+		lockout, err := s.authFailures.AuthLockout(ctx, clientIP)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to check auth lockout", zap.String("client_ip", clientIP), zap.Error(err))
+		}
+		if lockout > 0 {
+			return nil, errAuthLockout
+		}
+	}
+
+	apiKey, err := s.apiKeyService.ValidateAPIKey(ctx, key)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidAPIKey) {
+			logging.FromContext(ctx).Error("Failed to validate API key", zap.Error(err))
+			return nil, status.Error(codes.Unavailable, "Unable to validate the API key")
+		}
+		if trackFailures {
+			lockout, err := s.authFailures.RecordAuthFailure(ctx, clientIP)
+			if err != nil {
+				logging.FromContext(ctx).Error("Failed to record auth failure", zap.String("client_ip", clientIP), zap.Error(err))
+			}
+			if lockout > 0 {
+				return nil, errAuthLockout
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "The provided API key is invalid or inactive")
+	}
+	return apiKey, nil
+}
+
+// errAuthLockout refuses calls from clients locked out for sending invalid
+// keys
+var errAuthLockout = status.Error(codes.ResourceExhausted, "Too many invalid API keys were sent from your network. Please try again later.")
+
+// clientIP returns the address a call is checked for: claimed, the
+// client_ip of the request, when the caller is a trusted proxy, and the
+// caller's own address otherwise
+func (s *rateLimitServer) clientIP(ctx context.Context, claimed string) string {
+	caller := peerIP(ctx)
+	if claimed == "" || !ipInNetworks(caller, s.trustedProxies) {
+		return caller
+	}
+	return claimed
+}
+
+// parseNetworks parses IP addresses and CIDR ranges, skipping invalid ones
+func parseNetworks(entries []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return networks
+}
+
+// ipInNetworks reports whether ip is in any of networks
+func ipInNetworks(ip string, networks []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the IP address of the caller, or "" when it isn't
+// connected over IP, e.g. through a Unix socket
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func rateLimitMessage(result *services.RateLimitResult) *ratelimitv1.RateLimit {
+	message := &ratelimitv1.RateLimit{
+		Limit:             result.Limit,
+		Remaining:         result.Remaining,
+		ResetTime:         timestamppb.New(result.ResetTime),
+		RetryAfterSeconds: secondsUntil(result.ResetTime),
+		WindowSeconds:     int64(result.Window / time.Second),
+		Penalty:           &ratelimitv1.Penalty{Active: result.Penalized()},
+	}
+	if result.Penalized() {
+		message.RetryAfterSeconds = secondsUntil(result.PenaltyExpiresAt)
+		message.Penalty.ExpiresAt = timestamppb.New(result.PenaltyExpiresAt)
+		message.Penalty.Level = result.PenaltyLevel
+	}
+	return message
+}
+
+func secondsUntil(t time.Time) int64 {
+	seconds := int64(math.Ceil(time.Until(t).Seconds()))
+	if seconds < 0 {
+		return 0
+	}
+	return seconds
+}
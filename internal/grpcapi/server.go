@@ -0,0 +1,207 @@
+// Package grpcapi serves the rate limit checks and key management of the
+// REST API over gRPC, on top of the same services
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"runtime/debug"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRotationGracePeriod is how long a rotated key's previous secret stays
+// valid unless configured otherwise
+const DefaultRotationGracePeriod = 24 * time.Hour
+
+type options struct {
+	adminAuthenticators []middleware.AdminAuthenticator
+	rotationGracePeriod time.Duration
+	usageRecorder       services.UsageRecorder
+	usageCounter        services.UsageCounter
+	limitAlerter        services.LimitAlerter
+	authFailures        services.AuthFailureTracker
+	trustedProxies      []string
+	reflection          bool
+	envoyDescriptors    EnvoyDescriptors
+	tlsConfig           *tls.Config
+}
+
+// Option configures optional Server behaviour
+type Option func(*options)
+
+// WithAdminAuthenticator requires APIKeyService calls to carry an admin token
+// accepted by authenticator, and enforces the same minimum roles as the
+// admin API. Without any, key management is unauthenticated.
+func WithAdminAuthenticator(authenticator middleware.AdminAuthenticator) Option {
+	return func(o *options) {
+		o.adminAuthenticators = append(o.adminAuthenticators, authenticator)
+	}
+}
+
+// WithRotationGracePeriod sets the default grace period for key rotations
+func WithRotationGracePeriod(gracePeriod time.Duration) Option {
+	return func(o *options) {
+		if gracePeriod > 0 {
+			o.rotationGracePeriod = gracePeriod
+		}
+	}
+}
+
+// WithUsageRecorder records every successful authentication, e.g. to keep
+// last_used_at up to date
+func WithUsageRecorder(recorder services.UsageRecorder) Option {
+	return func(o *options) {
+		o.usageRecorder = recorder
+	}
+}
+
+// WithUsageCounter counts every checked request that passes all limits
+func WithUsageCounter(counter services.UsageCounter) Option {
+	return func(o *options) {
+		o.usageCounter = counter
+	}
+}
+
+// WithLimitAlerter raises an alert for keys whose checks are refused for
+// exceeding their rate limit or quota, or reach one of their alert thresholds
+func WithLimitAlerter(alerter services.LimitAlerter) Option {
+	return func(o *options) {
+		o.limitAlerter = alerter
+	}
+}
+
+// WithAuthFailureTracker locks out client IPs that send too many invalid
+// API keys, as the REST API does
+func WithAuthFailureTracker(tracker services.AuthFailureTracker) Option {
+	return func(o *options) {
+		o.authFailures = tracker
+	}
+}
+
+// WithTrustedProxies sets the IP addresses and CIDR ranges of callers, such
+// as Envoy, whose client_ip is used to check a key's allowed networks and
+// auth lockouts. Other callers are checked by their own address.
+func WithTrustedProxies(proxies []string) Option {
+	return func(o *options) {
+		o.trustedProxies = proxies
+	}
+}
+
+// WithTLS serves the API over TLS with tlsConfig instead of plaintext
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// WithReflection registers the server reflection service, so tools like
+// grpcurl can call the API without its .proto files
+func WithReflection(enabled bool) Option {
+	return func(o *options) {
+		o.reflection = enabled
+	}
+}
+
+// WithEnvoyDescriptors sets the descriptor entries Envoy's rate limit
+// service protocol reads from. Empty names keep their defaults.
+func WithEnvoyDescriptors(descriptors EnvoyDescriptors) Option {
+	return func(o *options) {
+		if descriptors.APIKey != "" {
+			o.envoyDescriptors.APIKey = descriptors.APIKey
+		}
+		if descriptors.EndUser != "" {
+			o.envoyDescriptors.EndUser = descriptors.EndUser
+		}
+		if descriptors.ClientIP != "" {
+			o.envoyDescriptors.ClientIP = descriptors.ClientIP
+		}
+	}
+}
+
+// Server is the gRPC server with the RateLimitService, APIKeyService, Envoy's
+// rate limit service and the standard health services registered
+type Server struct {
+	*grpc.Server
+	health *health.Server
+}
+
+// NewServer returns a server for the rate limit and key management services.
+// Start it with Serve and stop it with GracefulStop.
+func NewServer(apiKeyService services.APIKeyServiceInterface, rateLimitService services.RateLimitServiceInterface, opts ...Option) *Server {
+	o := &options{rotationGracePeriod: DefaultRotationGracePeriod, envoyDescriptors: DefaultEnvoyDescriptors}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recoveryInterceptor, adminAuthInterceptor(o.adminAuthenticators)),
+	}
+	if o.tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(o.tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	checker := &rateLimitServer{
+		apiKeyService:    apiKeyService,
+		rateLimitService: rateLimitService,
+		usageRecorder:    o.usageRecorder,
+		usageCounter:     o.usageCounter,
+		limitAlerter:     o.limitAlerter,
+		authFailures:     o.authFailures,
+		trustedProxies:   parseNetworks(o.trustedProxies),
+	}
+	ratelimitv1.RegisterRateLimitServiceServer(grpcServer, checker)
+	rlsv3.RegisterRateLimitServiceServer(grpcServer, &envoyRateLimitServer{checker: checker, descriptors: o.envoyDescriptors})
+	ratelimitv1.RegisterAPIKeyServiceServer(grpcServer, &apiKeyServer{
+		apiKeyService:       apiKeyService,
+		rotationGracePeriod: o.rotationGracePeriod,
+	})
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if o.reflection {
+		reflection.Register(grpcServer)
+	}
+	return &Server{Server: grpcServer, health: healthServer}
+}
+
+// Drain makes the health service report NOT_SERVING, so clients and load
+// balancers stop sending new calls before the server stops
+func (s *Server) Drain() {
+	s.health.Shutdown()
+}
+
+// recoveryInterceptor turns a panic in a handler into an INTERNAL error,
+// logging the stack and counting it in metrics.PanicsRecovered, instead of
+// crashing the process
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		metrics.PanicsRecovered.Inc()
+		logging.FromContext(ctx).Error("Panic recovered",
+			zap.Any("panic", recovered),
+			zap.String("method", info.FullMethod),
+			zap.ByteString("stack", debug.Stack()),
+		)
+		err = status.Error(codes.Internal, "The server encountered an unexpected error")
+	}()
+	return handler(ctx, req)
+}
@@ -0,0 +1,369 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer serves the gRPC API on a loopback port, so callers have an
+// IP address, backed by a SQLite database for keys and counters
+func newTestServer(t *testing.T, opts ...Option) *grpc.ClientConn {
+	return dialTestServer(t, startTestServer(t, opts...), insecure.NewCredentials())
+}
+
+// startTestServer serves the gRPC API as newTestServer does, returning its
+// address
+func startTestServer(t *testing.T, opts ...Option) string {
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(context.Background())
+	require.NoError(t, err)
+	counters, err := database.NewCounterStore(db, 0, time.Minute)
+	require.NoError(t, err)
+
+	apiKeyService := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	rateLimitService := services.NewRateLimitService(counters, config.RateLimitConfig{DefaultRequests: 100, DefaultWindow: time.Hour})
+	srv := NewServer(apiKeyService, rateLimitService, opts...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	return listener.Addr().String()
+}
+
+func dialTestServer(t *testing.T, address string, creds credentials.TransportCredentials) *grpc.ClientConn {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// selfSignedCertificate returns a certificate for 127.0.0.1 and a pool
+// trusting it
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "grpc test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestServer_TLS(t *testing.T) {
+	certificate, pool := selfSignedCertificate(t)
+	address := startTestServer(t, WithTLS(&tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}))
+	ctx := context.Background()
+
+	conn := dialTestServer(t, address, credentials.NewTLS(&tls.Config{RootCAs: pool}))
+	_, err := ratelimitv1.NewRateLimitServiceClient(conn).CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: "ak_invalid"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "calls are served over TLS")
+
+	// Plaintext clients can't connect, so keys never cross the wire in clear
+	plaintext := dialTestServer(t, address, insecure.NewCredentials())
+	callCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_, err = ratelimitv1.NewRateLimitServiceClient(plaintext).CheckRateLimit(callCtx, &ratelimitv1.CheckRateLimitRequest{ApiKey: "ak_invalid"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	conn := newTestServer(t)
+	ctx := context.Background()
+	keys := ratelimitv1.NewAPIKeyServiceClient(conn)
+	limits := ratelimitv1.NewRateLimitServiceClient(conn)
+
+	created, err := keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{
+		Name:                 "gRPC",
+		RateLimitRequests:    2,
+		EndUserLimitRequests: 1,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ApiKey)
+	assert.Equal(t, services.KeyPrefix(created.ApiKey), created.KeyPrefix)
+
+	response, err := limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: created.ApiKey, EndUserId: "user-1"})
+	require.NoError(t, err)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, int64(2), response.RateLimit.Limit)
+	assert.Equal(t, int64(1), response.RateLimit.Remaining)
+	assert.Equal(t, int64(1), response.EndUserRateLimit.Limit)
+
+	// The end user's share is used up before the key's limit
+	response, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: created.ApiKey, EndUserId: "user-1"})
+	require.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "end_user_limited", response.Reason)
+
+	response, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: created.ApiKey})
+	require.NoError(t, err)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "limited", response.Reason)
+	assert.Positive(t, response.RateLimit.RetryAfterSeconds)
+
+	// Status doesn't count a request
+	statusResponse, err := limits.GetStatus(ctx, &ratelimitv1.GetStatusRequest{ApiKey: created.ApiKey})
+	require.NoError(t, err)
+	assert.Equal(t, "gRPC", statusResponse.ApiKey.Name)
+	assert.Equal(t, int64(0), statusResponse.RateLimit.Remaining)
+	assert.False(t, statusResponse.RateLimit.Penalty.Active)
+
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: "ak_invalid"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCheckRateLimit_Restrictions(t *testing.T) {
+	conn := newTestServer(t, WithTrustedProxies([]string{"127.0.0.1"}))
+	ctx := context.Background()
+	keys := ratelimitv1.NewAPIKeyServiceClient(conn)
+	limits := ratelimitv1.NewRateLimitServiceClient(conn)
+
+	restricted, err := keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Office", AllowedCidrs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: restricted.ApiKey, ClientIp: "10.1.2.3"})
+	assert.NoError(t, err)
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: restricted.ApiKey, ClientIp: "192.0.2.1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: restricted.ApiKey})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "callers are checked by their own address")
+
+	signed, err := keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Signed", RequireSignature: true})
+	require.NoError(t, err)
+	assert.NotEmpty(t, signed.SigningSecret)
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: signed.ApiKey})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestCheckRateLimit_UntrustedClientIP(t *testing.T) {
+	conn := newTestServer(t)
+	ctx := context.Background()
+
+	restricted, err := ratelimitv1.NewAPIKeyServiceClient(conn).CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Office", AllowedCidrs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	// Only trusted proxies may say whom they are checking for
+	_, err = ratelimitv1.NewRateLimitServiceClient(conn).CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: restricted.ApiKey, ClientIp: "10.1.2.3"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// fakeAuthFailures locks clients out after threshold failures
+type fakeAuthFailures struct {
+	threshold int
+	failures  map[string]int
+}
+
+func (f *fakeAuthFailures) AuthLockout(ctx context.Context, clientIP string) (time.Duration, error) {
+	if f.failures[clientIP] >= f.threshold {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeAuthFailures) RecordAuthFailure(ctx context.Context, clientIP string) (time.Duration, error) {
+	f.failures[clientIP]++
+	return f.AuthLockout(ctx, clientIP)
+}
+
+func TestCheckRateLimit_AuthLockout(t *testing.T) {
+	failures := &fakeAuthFailures{threshold: 2, failures: map[string]int{}}
+	conn := newTestServer(t, WithAuthFailureTracker(failures))
+	ctx := context.Background()
+	limits := ratelimitv1.NewRateLimitServiceClient(conn)
+
+	created, err := ratelimitv1.NewAPIKeyServiceClient(conn).CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Valid"})
+	require.NoError(t, err)
+
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: "ak_guess_1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// The client_ip of untrusted callers doesn't move the lockout elsewhere
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: "ak_guess_2", ClientIp: "192.0.2.1"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 2, failures.failures["127.0.0.1"])
+
+	// Locked out clients are refused even with a valid key
+	_, err = limits.CheckRateLimit(ctx, &ratelimitv1.CheckRateLimitRequest{ApiKey: created.ApiKey})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = limits.GetStatus(ctx, &ratelimitv1.GetStatusRequest{ApiKey: created.ApiKey})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestAPIKeyService(t *testing.T) {
+	conn := newTestServer(t)
+	ctx := context.Background()
+	keys := ratelimitv1.NewAPIKeyServiceClient(conn)
+
+	_, err := keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Bad", AllowedCidrs: []string{"nope"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := keys.CreateAPIKey(ctx, &ratelimitv1.CreateAPIKeyRequest{Name: "Managed", OwnerEmail: "team@example.com"})
+	require.NoError(t, err)
+
+	apiKey, err := keys.GetAPIKey(ctx, &ratelimitv1.GetAPIKeyRequest{Key: created.ApiKey})
+	require.NoError(t, err)
+	assert.Equal(t, "Managed", apiKey.Name)
+	assert.Equal(t, int32(100), apiKey.RateLimitRequests)
+	assert.Equal(t, int32(3600), apiKey.RateLimitWindowSeconds)
+
+	listed, err := keys.ListAPIKeys(ctx, &ratelimitv1.ListAPIKeysRequest{Owner: "team@example.com"})
+	require.NoError(t, err)
+	require.Len(t, listed.ApiKeys, 1)
+	assert.Equal(t, apiKey.Id, listed.ApiKeys[0].Id)
+
+	rotated, err := keys.RotateAPIKey(ctx, &ratelimitv1.RotateAPIKeyRequest{Key: apiKey.Id, GracePeriodSeconds: 60})
+	require.NoError(t, err)
+	assert.NotEqual(t, created.ApiKey, rotated.ApiKey)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), rotated.PreviousKeyExpiresAt.AsTime(), 5*time.Second)
+
+	_, err = keys.DeactivateAPIKey(ctx, &ratelimitv1.DeactivateAPIKeyRequest{Key: apiKey.Id})
+	require.NoError(t, err)
+	apiKey, err = keys.GetAPIKey(ctx, &ratelimitv1.GetAPIKeyRequest{Key: apiKey.Id})
+	require.NoError(t, err)
+	assert.False(t, apiKey.IsActive)
+	assert.NotNil(t, apiKey.DeletedAt)
+
+	// Deleted keys are only listed on request, and can be restored once
+	listed, err = keys.ListAPIKeys(ctx, &ratelimitv1.ListAPIKeysRequest{Owner: "team@example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, listed.ApiKeys)
+	listed, err = keys.ListAPIKeys(ctx, &ratelimitv1.ListAPIKeysRequest{Owner: "team@example.com", IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, listed.ApiKeys, 1)
+	assert.NotNil(t, listed.ApiKeys[0].DeletedAt)
+
+	apiKey, err = keys.RestoreAPIKey(ctx, &ratelimitv1.RestoreAPIKeyRequest{Key: apiKey.Id})
+	require.NoError(t, err)
+	assert.True(t, apiKey.IsActive)
+	assert.Nil(t, apiKey.DeletedAt)
+	_, err = keys.RestoreAPIKey(ctx, &ratelimitv1.RestoreAPIKeyRequest{Key: apiKey.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = keys.GetAPIKey(ctx, &ratelimitv1.GetAPIKeyRequest{Key: "00000000-0000-0000-0000-000000000000"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAPIKeyService_AdminAuth(t *testing.T) {
+	credentials, err := middleware.ParseAdminCredentials([]string{"viewer:view-token", "admin:admin-token"})
+	require.NoError(t, err)
+	conn := newTestServer(t, WithAdminAuthenticator(credentials))
+	keys := ratelimitv1.NewAPIKeyServiceClient(conn)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err = keys.ListAPIKeys(context.Background(), &ratelimitv1.ListAPIKeysRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = keys.ListAPIKeys(withToken("wrong"), &ratelimitv1.ListAPIKeysRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = keys.ListAPIKeys(withToken("view-token"), &ratelimitv1.ListAPIKeysRequest{})
+	assert.NoError(t, err)
+	_, err = keys.CreateAPIKey(withToken("view-token"), &ratelimitv1.CreateAPIKeyRequest{Name: "Denied"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	created, err := keys.CreateAPIKey(withToken("admin-token"), &ratelimitv1.CreateAPIKeyRequest{Name: "Allowed"})
+	require.NoError(t, err)
+
+	// Rate limit checks are authenticated by the API key alone
+	_, err = ratelimitv1.NewRateLimitServiceClient(conn).CheckRateLimit(context.Background(), &ratelimitv1.CheckRateLimitRequest{ApiKey: created.ApiKey})
+	assert.NoError(t, err)
+}
+
+func TestAPIKeyService_OrganizationScope(t *testing.T) {
+	credentials, err := middleware.ParseAdminCredentials([]string{"admin:platform-token", "admin@org-acme:acme-token"})
+	require.NoError(t, err)
+	conn := newTestServer(t, WithAdminAuthenticator(credentials))
+	keys := ratelimitv1.NewAPIKeyServiceClient(conn)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	created, err := keys.CreateAPIKey(withToken("platform-token"), &ratelimitv1.CreateAPIKeyRequest{Name: "Platform"})
+	require.NoError(t, err)
+
+	// Keys outside the caller's organization don't exist for it
+	_, err = keys.GetAPIKey(withToken("acme-token"), &ratelimitv1.GetAPIKeyRequest{Key: created.ApiKey})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = keys.DeactivateAPIKey(withToken("acme-token"), &ratelimitv1.DeactivateAPIKeyRequest{Key: created.ApiKey})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	listed, err := keys.ListAPIKeys(withToken("acme-token"), &ratelimitv1.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, listed.ApiKeys)
+
+	// Without a project in the request, scoped callers can only create sub-keys
+	_, err = keys.CreateAPIKey(withToken("acme-token"), &ratelimitv1.CreateAPIKeyRequest{Name: "Denied"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = keys.CreateAPIKey(withToken("acme-token"), &ratelimitv1.CreateAPIKeyRequest{Name: "Child", ParentKey: created.ApiKey})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_HealthAndReflection(t *testing.T) {
+	conn := newTestServer(t, WithReflection(true))
+	ctx := context.Background()
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	reply, err := stream.Recv()
+	require.NoError(t, err)
+	var names []string
+	for _, service := range reply.GetListServicesResponse().Service {
+		names = append(names, service.Name)
+	}
+	assert.Contains(t, names, "ratelimit.v1.RateLimitService")
+	assert.Contains(t, names, "ratelimit.v1.APIKeyService")
+}
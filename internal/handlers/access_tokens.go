@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// WithAccessTokens enables the OAuth2 token endpoint and the token exchange
+// endpoint, which issue access tokens for API keys
+func WithAccessTokens(accessTokens services.AccessTokenServiceInterface) Option {
+	return func(h *Handler) {
+		h.accessTokens = accessTokens
+	}
+}
+
+// WithAuthFailureTracker locks out clients of the OAuth2 token endpoint that
+// keep sending invalid client credentials, as the API does for invalid keys
+func WithAuthFailureTracker(tracker services.AuthFailureTracker) Option {
+	return func(h *Handler) {
+		h.authFailures = tracker
+	}
+}
+
+// tokenEndpoint returns the handlers of the OAuth2 token endpoint, throttled
+// per client IP when an admin rate limiter is configured
+func (h *Handler) tokenEndpoint() []gin.HandlerFunc {
+	if h.adminRateLimiter == nil {
+		return []gin.HandlerFunc{h.IssueAccessToken}
+	}
+	return []gin.HandlerFunc{middleware.TokenEndpointRateLimit(h.adminRateLimiter), h.IssueAccessToken}
+}
+
+// accessTokenRequest is an OAuth2 token request (RFC 6749, section 4.4).
+// The client ID is the key's ID and the client secret the API key; they are
+// sent in the body or with HTTP Basic authentication.
+type accessTokenRequest struct {
+	GrantType    string `form:"grant_type" json:"grant_type" binding:"required"`
+	ClientID     string `form:"client_id" json:"client_id"`
+	ClientSecret string `form:"client_secret" json:"client_secret"`
+}
+
+// IssueAccessToken exchanges client credentials for an access token that
+// authenticates as the key until it expires. Errors use the OAuth2 error
+// codes.
+func (h *Handler) IssueAccessToken(c *gin.Context) {
+	var request accessTokenRequest
+	if err := c.ShouldBind(&request); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		return
+	}
+	if request.GrantType != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the client_credentials grant is supported")
+		return
+	}
+
+	clientID, clientSecret := request.ClientID, request.ClientSecret
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		clientID, clientSecret = username, password
+	}
+	if clientID == "" || clientSecret == "" {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client credentials are required")
+		return
+	}
+
+	// Clients locked out for guessing secrets are refused before validation
+	ctx := c.Request.Context()
+	if h.authFailures != nil {
+		lockout, err := h.authFailures.AuthLockout(ctx, c.ClientIP())
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to check auth lockout", zap.String("client_ip", c.ClientIP()), zap.Error(err))
+		}
+		if lockout > 0 {
+			tokenLockout(c, lockout)
+			return
+		}
+	}
+
+	apiKey, err := h.apiKeyService.ValidateAPIKey(ctx, clientSecret)
+	if err != nil && !errors.Is(err, services.ErrInvalidAPIKey) {
+		logging.FromContext(ctx).Error("Failed to validate client credentials", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, middleware.ErrorBody(c, gin.H{
+			"error":             "temporarily_unavailable",
+			"error_description": "Unable to validate the client credentials right now. Please try again later.",
+		}))
+		return
+	}
+	if err != nil || apiKey.ID != clientID {
+		if h.authFailures != nil {
+			lockout, err := h.authFailures.RecordAuthFailure(ctx, c.ClientIP())
+			if err != nil {
+				logging.FromContext(ctx).Error("Failed to record auth failure", zap.String("client_ip", c.ClientIP()), zap.Error(err))
+			}
+			if lockout > 0 {
+				tokenLockout(c, lockout)
+				return
+			}
+		}
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client credentials are invalid or the API key is inactive")
+		return
+	}
+
+	accessToken, err := h.accessTokens.IssueToken(apiKey, services.TokenScope{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to issue access token",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken.Token,
+		"token_type":   "Bearer",
+		"expires_in":   int(h.accessTokens.TTL().Seconds()),
+	})
+}
+
+// tokenExchangeRequest optionally scopes an exchanged token down from its
+// key: a limit of its own, per window in seconds, and a shorter lifetime
+type tokenExchangeRequest struct {
+	RateLimitRequests      int `json:"rate_limit_requests" binding:"gte=0"`
+	RateLimitWindowSeconds int `json:"rate_limit_window_seconds" binding:"gte=0"`
+	ExpiresIn              int `json:"expires_in" binding:"gte=0"`
+}
+
+// ExchangeToken trades the API key a request authenticated with for a
+// short-lived access token, so browser and mobile clients never hold the
+// key itself. Tokens can't be exchanged for further tokens.
+func (h *Handler) ExchangeToken(c *gin.Context) {
+	apiKey, exists := c.Get("api_key")
+	if !exists {
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
+			"error": "API key not found in context",
+		}))
+		return
+	}
+	apiKeyRecord := apiKey.(*database.APIKey)
+	if apiKeyRecord.TokenID != "" || apiKeyRecord.External {
+		respond(c, http.StatusForbidden, middleware.ErrorBody(c, gin.H{
+			"error":   "API key required",
+			"message": "Tokens can only be obtained with an API key, not with another token",
+		}))
+		return
+	}
+
+	var request tokenExchangeRequest
+
+	// The body is optional; without it the token has the key's limits
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(middleware.InvalidBody(c, err))
+			return
+		}
+	}
+
+	accessToken, err := h.accessTokens.IssueToken(apiKeyRecord, services.TokenScope{
+		RateLimitRequests:      request.RateLimitRequests,
+		RateLimitWindowSeconds: request.RateLimitWindowSeconds,
+		TTL:                    time.Duration(request.ExpiresIn) * time.Second,
+	})
+	if err != nil {
+		respond(c, http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to issue access token",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	respond(c, http.StatusOK, gin.H{
+		"access_token": accessToken.Token,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessToken.ExpiresAt.Sub(h.now()).Seconds()),
+		"expires_at":   accessToken.ExpiresAt,
+	})
+}
+
+// tokenLockout refuses a client locked out for sending invalid credentials
+func tokenLockout(c *gin.Context, lockout time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(lockout.Seconds())))
+	oauthError(c, http.StatusTooManyRequests, "invalid_client", "Too many invalid client credentials were sent from your network. Please try again later.")
+}
+
+// oauthError answers with an OAuth2 error response
+func oauthError(c *gin.Context, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.JSON(status, middleware.ErrorBody(c, gin.H{
+		"error":             code,
+		"error_description": description,
+	}))
+}
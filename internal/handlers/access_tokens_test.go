@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAccessTokenTestRouter() (*gin.Engine, *MockAPIKeyService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	accessTokens := services.NewAccessTokenService(nil, []byte("0123456789abcdef0123456789abcdef"), 15*time.Minute)
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAccessTokens(accessTokens))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+	return router, mockAPIKeyService
+}
+
+func requestAccessToken(router *gin.Engine, form url.Values, clientID, clientSecret string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/v1/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.0.2.1:1234"
+	if clientID != "" {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIssueAccessToken(t *testing.T) {
+	router, mockAPIKeyService := setupAccessTokenTestRouter()
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_secret").Return(createTestAPIKey(), nil)
+
+	w := requestAccessToken(router, url.Values{"grant_type": {"client_credentials"}}, "test-id-123", "ak_secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, strings.Count(response.AccessToken, "."))
+	assert.Equal(t, "Bearer", response.TokenType)
+	assert.Equal(t, 900, response.ExpiresIn)
+
+	// Credentials may also be sent in the body
+	w = requestAccessToken(router, url.Values{"grant_type": {"client_credentials"}, "client_id": {"test-id-123"}, "client_secret": {"ak_secret"}}, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestIssueAccessToken_Errors(t *testing.T) {
+	router, mockAPIKeyService := setupAccessTokenTestRouter()
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_secret").Return(createTestAPIKey(), nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_unknown").Return(nil, services.ErrInvalidAPIKey)
+
+	w := requestAccessToken(router, url.Values{"grant_type": {"password"}}, "test-id-123", "ak_secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"unsupported_grant_type"`)
+
+	w = requestAccessToken(router, url.Values{}, "test-id-123", "ak_secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"invalid_request"`)
+
+	// The client ID must be the ID of the key
+	for _, clientID := range []string{"other-id", ""} {
+		w = requestAccessToken(router, url.Values{"grant_type": {"client_credentials"}}, clientID, "ak_secret")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"invalid_client"`)
+	}
+
+	w = requestAccessToken(router, url.Values{"grant_type": {"client_credentials"}}, "test-id-123", "ak_unknown")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+}
+
+// fakeAuthFailures locks a client out after threshold failures
+type fakeAuthFailures struct {
+	threshold int
+	failures  map[string]int
+}
+
+func (f *fakeAuthFailures) AuthLockout(ctx context.Context, clientIP string) (time.Duration, error) {
+	if f.failures[clientIP] >= f.threshold {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeAuthFailures) RecordAuthFailure(ctx context.Context, clientIP string) (time.Duration, error) {
+	f.failures[clientIP]++
+	if f.failures[clientIP] >= f.threshold {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+// fakeAdminLimiter allows limit requests per caller
+type fakeAdminLimiter struct {
+	limit  int64
+	counts map[string]int64
+}
+
+func (l *fakeAdminLimiter) CheckAdminLimit(ctx context.Context, caller string) (*services.RateLimitResult, error) {
+	l.counts[caller]++
+	return &services.RateLimitResult{
+		Allowed:   l.counts[caller] <= l.limit,
+		Limit:     l.limit,
+		ResetTime: time.Now().Add(time.Minute),
+	}, nil
+}
+
+func TestIssueAccessToken_Lockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_secret").Return(createTestAPIKey(), nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_guess").Return(nil, services.ErrInvalidAPIKey)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_outage").Return(nil, errors.New("connection refused"))
+
+	authFailures := &fakeAuthFailures{threshold: 3, failures: map[string]int{}}
+	accessTokens := services.NewAccessTokenService(nil, []byte("0123456789abcdef0123456789abcdef"), 15*time.Minute)
+	router := gin.New()
+	NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAccessTokens(accessTokens), WithAuthFailureTracker(authFailures)).SetupRoutes(router)
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	// Outages aren't the client's fault and aren't counted
+	w := requestAccessToken(router, form, "test-id-123", "ak_outage")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"temporarily_unavailable"`)
+	assert.Empty(t, authFailures.failures)
+
+	// Unknown secrets and mismatched client IDs both count
+	assert.Equal(t, http.StatusUnauthorized, requestAccessToken(router, form, "test-id-123", "ak_guess").Code)
+	assert.Equal(t, http.StatusUnauthorized, requestAccessToken(router, form, "other-id", "ak_secret").Code)
+	w = requestAccessToken(router, form, "test-id-123", "ak_guess")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// Locked out clients are refused even with valid credentials
+	w = requestAccessToken(router, form, "test-id-123", "ak_secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"invalid_client"`)
+}
+
+func TestIssueAccessToken_Throttled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_secret").Return(createTestAPIKey(), nil)
+
+	limiter := &fakeAdminLimiter{limit: 2, counts: map[string]int64{}}
+	accessTokens := services.NewAccessTokenService(nil, []byte("0123456789abcdef0123456789abcdef"), 15*time.Minute)
+	router := gin.New()
+	NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAccessTokens(accessTokens), WithAdminRateLimiter(limiter, false)).SetupRoutes(router)
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	assert.Equal(t, http.StatusOK, requestAccessToken(router, form, "test-id-123", "ak_secret").Code)
+	assert.Equal(t, http.StatusOK, requestAccessToken(router, form, "test-id-123", "ak_secret").Code)
+	w := requestAccessToken(router, form, "test-id-123", "ak_secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "Token rate limit exceeded")
+
+	// Token requests are counted per client IP, apart from admin requests
+	assert.Equal(t, map[string]int64{"oauth:192.0.2.1": 3}, limiter.counts)
+}
+
+func TestExchangeToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	accessTokens := services.NewAccessTokenService(nil, []byte("0123456789abcdef0123456789abcdef"), 15*time.Minute)
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithAccessTokens(accessTokens))
+
+	apiKey := createTestAPIKey()
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key", apiKey)
+		c.Next()
+	})
+	handler.SetupRoutes(router)
+
+	exchange := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/api/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := exchange("")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, strings.Count(response.AccessToken, "."))
+	assert.Equal(t, "Bearer", response.TokenType)
+	assert.InDelta(t, 900, response.ExpiresIn, 1)
+
+	// Tokens may be scoped down to a lower limit and a shorter lifetime
+	w = exchange(`{"rate_limit_requests": 10, "expires_in": 60}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.InDelta(t, 60, response.ExpiresIn, 1)
+
+	assert.Equal(t, http.StatusBadRequest, exchange(`{"rate_limit_requests": -1}`).Code)
+
+	// A token can't be exchanged for another one
+	apiKey.TokenID = "tok-1"
+	w = exchange("")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"API key required"`)
+}
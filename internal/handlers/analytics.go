@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAnalyticsPeriod is how far back analytics go without a from
+// parameter
+const defaultAnalyticsPeriod = 24 * time.Hour
+
+// Bounds of the top consumers report's parameters
+const (
+	maxTopConsumersWindow = 30 * 24 * time.Hour
+	maxTopConsumers       = 100
+)
+
+// WithAnalyticsService enables the usage analytics endpoints, which
+// aggregate the usage logs
+func WithAnalyticsService(analyticsService services.AnalyticsServiceInterface) Option {
+	return func(h *Handler) {
+		h.analyticsService = analyticsService
+	}
+}
+
+// GetAPIKeyAnalytics returns a key's requests, 429 responses and average
+// cost per minute, hour or day (the granularity parameter, default hour)
+// between the RFC 3339 times from and to, by default the last 24 hours
+func (h *Handler) GetAPIKeyAnalytics(c *gin.Context) {
+	query, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	apiKey, ok := h.pathKey(c)
+	if !ok {
+		return
+	}
+
+	analytics, err := h.analyticsService.KeyAnalytics(c.Request.Context(), apiKey.ID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get analytics",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"analytics": analytics})
+}
+
+// analyticsQuery parses the from, to and granularity parameters, answering
+// the request when they are invalid
+func (h *Handler) analyticsQuery(c *gin.Context) (services.AnalyticsQuery, bool) {
+	query := services.AnalyticsQuery{To: h.now(), Granularity: c.DefaultQuery("granularity", "hour")}
+	invalid := func(message string) (services.AnalyticsQuery, bool) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": message,
+		}))
+		return query, false
+	}
+
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("to must be an RFC 3339 time")
+		}
+		query.To = to
+	}
+	query.From = query.To.Add(-defaultAnalyticsPeriod)
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("from must be an RFC 3339 time")
+		}
+		query.From = from
+	}
+
+	if err := services.ValidateAnalyticsQuery(query); err != nil {
+		return invalid(err.Error())
+	}
+	return query, true
+}
+
+// GetTopConsumers ranks the keys used within the window parameter (default
+// 24h, at most 720h) by request volume, 429 rate and cost, listing limit
+// keys (default 10) in each ranking. The 429 ranking leaves out keys with
+// fewer than min_requests requests (default 10). Callers scoped to an
+// organization only see its keys.
+func (h *Handler) GetTopConsumers(c *gin.Context) {
+	query := services.TopConsumersQuery{Window: 24 * time.Hour, Limit: 10, MinRequests: 10, OrganizationID: middleware.AdminOrganization(c)}
+	invalid := func(message string) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": message,
+		}))
+	}
+
+	if value := c.Query("window"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 || window > maxTopConsumersWindow {
+			invalid("window must be a duration such as 24h, at most 720h")
+			return
+		}
+		query.Window = window
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTopConsumers {
+			invalid(fmt.Sprintf("limit must be between 1 and %d", maxTopConsumers))
+			return
+		}
+		query.Limit = limit
+	}
+	if value := c.Query("min_requests"); value != "" {
+		minRequests, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || minRequests < 0 {
+			invalid("min_requests must be a non-negative integer")
+			return
+		}
+		query.MinRequests = minRequests
+	}
+
+	top, err := h.analyticsService.TopConsumers(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get top consumers",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"top_consumers": top})
+}
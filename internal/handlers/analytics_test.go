@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAnalyticsService is a mock implementation of AnalyticsServiceInterface
+type MockAnalyticsService struct {
+	mock.Mock
+}
+
+func (m *MockAnalyticsService) KeyAnalytics(ctx context.Context, apiKeyID string, query services.AnalyticsQuery) (*database.KeyAnalytics, error) {
+	args := m.Called(ctx, apiKeyID, query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.KeyAnalytics), args.Error(1)
+}
+
+func (m *MockAnalyticsService) TopConsumers(ctx context.Context, query services.TopConsumersQuery) (*database.TopConsumers, error) {
+	args := m.Called(ctx, query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.TopConsumers), args.Error(1)
+}
+
+func setupAnalyticsTestRouter() (*gin.Engine, *MockAPIKeyService, *MockAnalyticsService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockAnalyticsService := &MockAnalyticsService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAnalyticsService(mockAnalyticsService))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockAPIKeyService, mockAnalyticsService
+}
+
+func TestGetAPIKeyAnalytics_Success(t *testing.T) {
+	router, mockAPIKeyService, mockAnalyticsService := setupAnalyticsTestRouter()
+
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC)
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockAnalyticsService.On("KeyAnalytics", mock.Anything, "test-id-123", services.AnalyticsQuery{From: from, To: to, Granularity: "day"}).Return(&database.KeyAnalytics{
+		APIKeyID:    "test-id-123",
+		Granularity: "day",
+		Buckets: []database.UsageBucket{
+			{Start: from, Requests: 120, Limited: 20, AverageCost: 1.5},
+			{Start: from.Add(24 * time.Hour)},
+		},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/analytics?from=2025-06-01T00:00:00Z&to=2025-06-03T00:00:00Z&granularity=day", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	analytics := response["analytics"].(map[string]interface{})
+	buckets := analytics["buckets"].([]interface{})
+	require.Len(t, buckets, 2)
+	assert.Equal(t, float64(20), buckets[0].(map[string]interface{})["limited"])
+	assert.Equal(t, 1.5, buckets[0].(map[string]interface{})["average_cost"])
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockAnalyticsService.AssertExpectations(t)
+}
+
+func TestGetAPIKeyAnalytics_DefaultsToLastDayByHour(t *testing.T) {
+	router, mockAPIKeyService, mockAnalyticsService := setupAnalyticsTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockAnalyticsService.On("KeyAnalytics", mock.Anything, "test-id-123", mock.MatchedBy(func(query services.AnalyticsQuery) bool {
+		return query.Granularity == "hour" && query.To.Sub(query.From) == 24*time.Hour && time.Since(query.To) < time.Minute
+	})).Return(&database.KeyAnalytics{}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/analytics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockAnalyticsService.AssertExpectations(t)
+}
+
+func TestGetAPIKeyAnalytics_InvalidQuery(t *testing.T) {
+	router, _, mockAnalyticsService := setupAnalyticsTestRouter()
+
+	for _, query := range []string{
+		"granularity=week",
+		"from=yesterday",
+		"to=2025-06-01",
+		"from=2025-06-02T00:00:00Z&to=2025-06-01T00:00:00Z",
+		"from=2024-01-01T00:00:00Z&to=2025-06-01T00:00:00Z&granularity=minute",
+	} {
+		req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/analytics?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	mockAnalyticsService.AssertNotCalled(t, "KeyAnalytics", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetAPIKeyAnalytics_KeyNotFound(t *testing.T) {
+	router, mockAPIKeyService, _ := setupAnalyticsTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "missing-key").Return(nil, services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/missing-key/analytics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetTopConsumers(t *testing.T) {
+	router, _, mockAnalyticsService := setupAnalyticsTestRouter()
+
+	mockAnalyticsService.On("TopConsumers", mock.Anything, services.TopConsumersQuery{Window: time.Hour, Limit: 5, MinRequests: 10}).Return(&database.TopConsumers{
+		Window:     "1h0m0s",
+		ByRequests: []database.KeyConsumption{{APIKeyID: "test-id-123", Requests: 500, Limited: 50, LimitedRate: 0.1, Cost: 500}},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/analytics/top?window=1h&limit=5", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	top := response["top_consumers"].(map[string]interface{})
+	byRequests := top["by_requests"].([]interface{})
+	require.Len(t, byRequests, 1)
+	assert.Equal(t, 0.1, byRequests[0].(map[string]interface{})["limited_rate"])
+	mockAnalyticsService.AssertExpectations(t)
+}
+
+func TestGetTopConsumers_InvalidQuery(t *testing.T) {
+	router, _, mockAnalyticsService := setupAnalyticsTestRouter()
+
+	for _, query := range []string{"window=day", "window=-1h", "window=1000h", "limit=0", "limit=101", "min_requests=-1"} {
+		req, _ := http.NewRequest("GET", "/admin/analytics/top?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	mockAnalyticsService.AssertNotCalled(t, "TopConsumers", mock.Anything, mock.Anything)
+}
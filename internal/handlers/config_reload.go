@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigReloader re-reads the configuration and applies the settings that
+// can change while the server runs
+type ConfigReloader func(ctx context.Context) error
+
+// WithConfigReloader enables POST /admin/config/reload, which calls reload
+func WithConfigReloader(reload ConfigReloader) Option {
+	return func(h *Handler) {
+		h.configReloader = reload
+	}
+}
+
+// ReloadConfig applies the current configuration without a restart. A
+// configuration that fails validation is rejected and the running one kept.
+func (h *Handler) ReloadConfig(c *gin.Context) {
+	if err := h.configReloader(c.Request.Context()); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to reload configuration",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
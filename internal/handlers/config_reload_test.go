@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveConfigReload(t *testing.T, reload ConfigReloader, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"operator:operator-token", "admin:admin-token"})
+	require.NoError(t, err)
+
+	opts := []Option{WithAdminCredentials(credentials)}
+	if reload != nil {
+		opts = append(opts, WithConfigReloader(reload))
+	}
+	router := gin.New()
+	NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, opts...).SetupRoutes(router)
+
+	req, _ := http.NewRequest("POST", "/v1/admin/config/reload", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReloadConfig(t *testing.T) {
+	reloads := 0
+	reload := func(ctx context.Context) error {
+		reloads++
+		return nil
+	}
+
+	assert.Equal(t, http.StatusForbidden, serveConfigReload(t, reload, "operator-token").Code)
+	assert.Equal(t, 0, reloads)
+
+	w := serveConfigReload(t, reload, "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"reloaded"}`, w.Body.String())
+	assert.Equal(t, 1, reloads)
+}
+
+func TestReloadConfig_InvalidConfiguration(t *testing.T) {
+	w := serveConfigReload(t, func(ctx context.Context) error {
+		return errors.New(`invalid log level "loud"`)
+	}, "admin-token")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid log level")
+}
+
+func TestReloadConfig_DisabledWithoutReloader(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, serveConfigReload(t, nil, "admin-token").Code)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Response encodings offered on the /api endpoints, in order of preference
+// when the client accepts several
+var responseFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+	binding.MIMEPROTOBUF,
+	"application/protobuf",
+}
+
+// respond writes body in the encoding requested by the Accept header: JSON
+// (the default), MessagePack, or Protobuf as a google.protobuf.Struct
+// message. Bodies are normalised through JSON first, so every encoding
+// carries the same field names and values, e.g. RFC 3339 timestamps.
+func respond(c *gin.Context, status int, body interface{}) {
+	c.Header("Vary", "Accept")
+
+	format := c.NegotiateFormat(responseFormats...)
+	if format == "" || format == binding.MIMEJSON {
+		c.JSON(status, body)
+		return
+	}
+
+	value, err := jsonValue(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to encode response",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	switch format {
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Render(status, render.MsgPack{Data: value})
+	default:
+		message, err := structpb.NewValue(value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+				"error":   "Failed to encode response",
+				"message": err.Error(),
+			}))
+			return
+		}
+		// Bodies are always objects, so the value is a Struct
+		c.ProtoBuf(status, message.GetStructValue())
+	}
+}
+
+// jsonValue converts body to the generic maps, slices and scalars it would
+// decode to from JSON
+func jsonValue(body interface{}) (interface{}, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
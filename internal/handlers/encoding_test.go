@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func getStatusWithAccept(t *testing.T, accept string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Set("api_key", createTestAPIKey())
+
+	_, _, _, handler := setupTestRouter()
+	handler.GetStatus(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	return w
+}
+
+func TestRespond_DefaultsToJSON(t *testing.T) {
+	for _, accept := range []string{"", "application/json", "*/*", "text/html"} {
+		w := getStatusWithAccept(t, accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json", "Accept: %q", accept)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	}
+}
+
+func TestRespond_MessagePack(t *testing.T) {
+	w := getStatusWithAccept(t, "application/msgpack")
+
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/msgpack")
+
+	var response map[string]interface{}
+	require.NoError(t, binding.MsgPack.BindBody(w.Body.Bytes(), &response))
+	// Gin's codec decodes msgpack strings into interface{} values as raw bytes
+	assert.Equal(t, "authenticated", string(response["status"].([]byte)))
+}
+
+func TestRespond_Protobuf(t *testing.T) {
+	w := getStatusWithAccept(t, "application/x-protobuf")
+
+	assert.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+
+	var response structpb.Struct
+	require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "authenticated", response.Fields["status"].GetStringValue())
+	apiKey := response.Fields["api_key"].GetStructValue()
+	assert.Equal(t, "test-id-123", apiKey.Fields["id"].GetStringValue())
+}
+
+func TestRespond_PrefersClientOrder(t *testing.T) {
+	w := getStatusWithAccept(t, "application/x-protobuf, application/json;q=0.5")
+
+	assert.False(t, bytes.HasPrefix(w.Body.Bytes(), []byte("{")))
+	assert.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type featureFlagRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// WithFeatureFlags enables the feature flag endpoints
+func WithFeatureFlags(featureFlags services.FeatureFlagServiceInterface) Option {
+	return func(h *Handler) {
+		h.featureFlags = featureFlags
+	}
+}
+
+func (h *Handler) ListFeatureFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"features": h.featureFlags.List()})
+}
+
+// SetFeatureFlag toggles a flag on every instance until it is reset
+func (h *Handler) SetFeatureFlag(c *gin.Context) {
+	var request featureFlagRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	name := c.Param("name")
+	if err := h.featureFlags.Set(c.Request.Context(), name, *request.Enabled); err != nil {
+		h.featureFlagError(c, "Failed to set feature flag", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feature": services.FeatureFlag{
+		Name:    name,
+		Enabled: *request.Enabled,
+		Source:  services.FeatureFlagSourceRuntime,
+	}})
+}
+
+// ResetFeatureFlag removes a runtime toggle so the flag follows the
+// configuration again
+func (h *Handler) ResetFeatureFlag(c *gin.Context) {
+	name := c.Param("name")
+	if err := h.featureFlags.Reset(c.Request.Context(), name); err != nil {
+		h.featureFlagError(c, "Failed to reset feature flag", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feature": services.FeatureFlag{
+		Name:    name,
+		Enabled: h.featureFlags.Enabled(name),
+		Source:  services.FeatureFlagSourceConfig,
+	}})
+}
+
+func (h *Handler) featureFlagError(c *gin.Context, message string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrRuntimeFlagsDisabled):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrInvalidFlagName):
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, middleware.ErrorBody(c, gin.H{
+		"error":   message,
+		"message": err.Error(),
+	}))
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryFlagStore struct {
+	flags map[string]bool
+}
+
+func (s *memoryFlagStore) LoadFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	copied := map[string]bool{}
+	for name, enabled := range s.flags {
+		copied[name] = enabled
+	}
+	return copied, nil
+}
+
+func (s *memoryFlagStore) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	s.flags[name] = enabled
+	return nil
+}
+
+func (s *memoryFlagStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	delete(s.flags, name)
+	return nil
+}
+
+func serveFeatureFlags(t *testing.T, flags services.FeatureFlagServiceInterface, method, path, body, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"viewer:viewer-token", "admin:admin-token"})
+	require.NoError(t, err)
+
+	opts := []Option{WithAdminCredentials(credentials)}
+	if flags != nil {
+		opts = append(opts, WithFeatureFlags(flags))
+	}
+	router := gin.New()
+	NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, opts...).SetupRoutes(router)
+
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestFeatureFlags_ListAndToggle(t *testing.T) {
+	store := &memoryFlagStore{flags: map[string]bool{}}
+	flags := services.NewFeatureFlagService(map[string]bool{"shadow_mode": true}, store, time.Minute)
+
+	w := serveFeatureFlags(t, flags, "GET", "/v1/admin/features", "", "viewer-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"features":[{"name":"shadow_mode","enabled":true,"source":"config"}]}`, w.Body.String())
+
+	w = serveFeatureFlags(t, flags, "PUT", "/v1/admin/features/shadow_mode", `{"enabled":false}`, "viewer-token")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serveFeatureFlags(t, flags, "PUT", "/v1/admin/features/shadow_mode", `{"enabled":false}`, "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"feature":{"name":"shadow_mode","enabled":false,"source":"runtime"}}`, w.Body.String())
+	assert.False(t, flags.Enabled("shadow_mode"))
+
+	w = serveFeatureFlags(t, flags, "DELETE", "/v1/admin/features/shadow_mode", "", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"feature":{"name":"shadow_mode","enabled":true,"source":"config"}}`, w.Body.String())
+}
+
+func TestFeatureFlags_Errors(t *testing.T) {
+	configOnly := services.NewFeatureFlagService(map[string]bool{"shadow_mode": true}, nil, time.Minute)
+	w := serveFeatureFlags(t, configOnly, "PUT", "/v1/admin/features/shadow_mode", `{"enabled":false}`, "admin-token")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	runtime := services.NewFeatureFlagService(nil, &memoryFlagStore{flags: map[string]bool{}}, time.Minute)
+	w = serveFeatureFlags(t, runtime, "PUT", "/v1/admin/features/Shadow!", `{"enabled":true}`, "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveFeatureFlags(t, runtime, "PUT", "/v1/admin/features/shadow_mode", `{}`, "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveFeatureFlags(t, nil, "GET", "/v1/admin/features", "", "admin-token")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -1,46 +1,362 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"grpc-firstls/internal/buildinfo"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/live"
+	"grpc-firstls/internal/middleware"
 	"grpc-firstls/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
+// DefaultRotationGracePeriod is how long a rotated key's previous secret stays
+// valid unless configured otherwise
+const DefaultRotationGracePeriod = 24 * time.Hour
+
 type Handler struct {
-	apiKeyService    services.APIKeyServiceInterface
-	rateLimitService services.RateLimitServiceInterface
+	apiKeyService       services.APIKeyServiceInterface
+	rateLimitService    services.RateLimitServiceInterface
+	planService         services.PlanServiceInterface
+	organizationService services.OrganizationServiceInterface
+	usageService        services.UsageServiceInterface
+	analyticsService    services.AnalyticsServiceInterface
+	webhookService      services.WebhookServiceInterface
+	exportService       services.ExportServiceInterface
+	featureFlags        services.FeatureFlagServiceInterface
+	maintenance         *middleware.MaintenanceMode
+	accessTokens        services.AccessTokenServiceInterface
+	authFailures        services.AuthFailureTracker
+
+	rotationGracePeriod time.Duration
+
+	adminAuthenticators []middleware.AdminAuthenticator
+
+	adminRateLimiter   services.AdminRateLimiter
+	adminRateLimitByIP bool
+	idempotency        services.IdempotencyStore
+
+	apiMiddleware []gin.HandlerFunc
+
+	legacyRoutes bool
+	legacySunset time.Time
+
+	profiling bool
+
+	configReloader ConfigReloader
+
+	liveEvents          *live.Hub
+	liveEventsHeartbeat time.Duration
+	liveCounterInterval time.Duration
+
+	readinessChecks []ReadinessCheck
+	draining        atomic.Bool
+	buildInfo       buildinfo.Info
+
+	now func() time.Time
+}
+
+// Option configures optional Handler dependencies
+type Option func(*Handler)
+
+// WithPlanService enables the plan management endpoints
+func WithPlanService(planService services.PlanServiceInterface) Option {
+	return func(h *Handler) {
+		h.planService = planService
+	}
+}
+
+// WithUsageService enables the key usage endpoint
+func WithUsageService(usageService services.UsageServiceInterface) Option {
+	return func(h *Handler) {
+		h.usageService = usageService
+	}
+}
+
+// WithRotationGracePeriod sets the default grace period for key rotations
+func WithRotationGracePeriod(gracePeriod time.Duration) Option {
+	return func(h *Handler) {
+		if gracePeriod > 0 {
+			h.rotationGracePeriod = gracePeriod
+		}
+	}
+}
+
+// WithAdminCredentials requires admin endpoints to be called with one of
+// credentials and enforces each endpoint's minimum role. Without it the admin
+// API is unauthenticated.
+func WithAdminCredentials(credentials middleware.AdminCredentials) Option {
+	return WithAdminAuthenticator(credentials)
 }
 
-func NewHandler(apiKeyService services.APIKeyServiceInterface, rateLimitService services.RateLimitServiceInterface) *Handler {
-	return &Handler{
-		apiKeyService:    apiKeyService,
-		rateLimitService: rateLimitService,
+// WithAdminAuthenticator accepts admin callers verified by authenticator, e.g.
+// a middleware.OIDCAuthenticator for single sign-on. May be combined with
+// WithAdminCredentials; the first authenticator to accept a token wins.
+func WithAdminAuthenticator(authenticator middleware.AdminAuthenticator) Option {
+	return func(h *Handler) {
+		h.adminAuthenticators = append(h.adminAuthenticators, authenticator)
 	}
 }
 
+// WithAdminRateLimiter throttles the admin endpoints per admin credential,
+// or per client IP when byIP is set
+func WithAdminRateLimiter(limiter services.AdminRateLimiter, byIP bool) Option {
+	return func(h *Handler) {
+		h.adminRateLimiter = limiter
+		h.adminRateLimitByIP = byIP
+	}
+}
+
+// WithIdempotency lets admin POST requests be retried safely with an
+// Idempotency-Key header, replaying the stored response of the first attempt
+func WithIdempotency(store services.IdempotencyStore) Option {
+	return func(h *Handler) {
+		h.idempotency = store
+	}
+}
+
+// WithAPIMiddleware runs middleware, e.g. middleware.RateLimit, in front of
+// the /api endpoints of every API version. The health, documentation,
+// OAuth2 token, self-service and admin endpoints authenticate their callers
+// themselves and don't run it.
+func WithAPIMiddleware(middleware ...gin.HandlerFunc) Option {
+	return func(h *Handler) {
+		h.apiMiddleware = append(h.apiMiddleware, middleware...)
+	}
+}
+
+// WithLegacyRoutes controls whether the unversioned /api and /admin paths
+// are still served. They answer with deprecation headers, including sunset
+// when it is set.
+func WithLegacyRoutes(enabled bool, sunset time.Time) Option {
+	return func(h *Handler) {
+		h.legacyRoutes = enabled
+		h.legacySunset = sunset
+	}
+}
+
+// WithProfiling serves net/http/pprof under /debug/pprof on the admin
+// router, behind the same authentication and throttling as the admin API
+func WithProfiling(enabled bool) Option {
+	return func(h *Handler) {
+		h.profiling = enabled
+	}
+}
+
+// WithClock tells the time with now, e.g. a fixed clock in tests, when
+// checking requested expiry times and working out retry_after, expires_in
+// and the default analytics range
+func WithClock(now func() time.Time) Option {
+	return func(h *Handler) {
+		if now != nil {
+			h.now = now
+		}
+	}
+}
+
+func NewHandler(apiKeyService services.APIKeyServiceInterface, rateLimitService services.RateLimitServiceInterface, opts ...Option) *Handler {
+	h := &Handler{
+		apiKeyService:       apiKeyService,
+		rateLimitService:    rateLimitService,
+		rotationGracePeriod: DefaultRotationGracePeriod,
+		liveCounterInterval: DefaultLiveCounterInterval,
+		legacyRoutes:        true,
+		buildInfo:           buildinfo.Get(),
+		now:                 time.Now,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// SetupRoutes registers all endpoints on a single router
 func (h *Handler) SetupRoutes(router *gin.Engine) {
-	// Health check endpoint (no rate limiting)
+	h.SetupAPIRoutes(router)
+	h.SetupAdminRoutes(router)
+}
+
+// SetupAdminRoutes registers the key and plan management endpoints under
+// /admin of every API version, e.g. on a separate admin listener
+func (h *Handler) SetupAdminRoutes(router gin.IRouter) {
+	for _, version := range h.versions() {
+		h.registerAdminRoutes(router.Group(version.Prefix), version.Admin)
+	}
+	if h.legacyRoutes {
+		legacy := router.Group("", middleware.Deprecated(CurrentAPIVersion, h.legacySunset))
+		h.registerAdminRoutes(legacy, h.registerAdminEndpoints)
+	}
+	if h.profiling {
+		h.registerProfiling(h.adminGroup(router, "/debug/pprof"))
+	}
+}
+
+func (h *Handler) registerAdminRoutes(router gin.IRouter, register func(gin.IRouter)) {
+	admin := h.adminGroup(router, "/admin")
+	admin.Use(middleware.ETag())
+	register(admin)
+}
+
+// adminGroup returns a group under path that authenticates and throttles
+// admin callers
+func (h *Handler) adminGroup(router gin.IRouter, path string) *gin.RouterGroup {
+	admin := router.Group(path)
+	// Throttling by IP comes first so failed authentication attempts count too
+	if h.adminRateLimiter != nil && h.adminRateLimitByIP {
+		admin.Use(middleware.AdminRateLimit(h.adminRateLimiter, true))
+	}
+	if len(h.adminAuthenticators) > 0 {
+		admin.Use(middleware.AdminAuth(h.adminAuthenticators...))
+	}
+	if h.adminRateLimiter != nil && !h.adminRateLimitByIP {
+		admin.Use(middleware.AdminRateLimit(h.adminRateLimiter, false))
+	}
+	if h.idempotency != nil {
+		admin.Use(middleware.Idempotency(h.idempotency))
+	}
+	return admin
+}
+
+func (h *Handler) registerAdminEndpoints(admin gin.IRouter) {
+	admin.GET("/api-keys", h.authorize(middleware.RoleViewer, h.ListAPIKeys)...)
+	admin.POST("/api-keys", h.authorize(middleware.RoleOperator, h.CreateAPIKey)...)
+	admin.POST("/api-keys/bulk", h.authorize(middleware.RoleOperator, h.CreateAPIKeys)...)
+	admin.GET("/api-keys/:key", h.authorize(middleware.RoleViewer, h.GetAPIKey)...)
+	admin.PUT("/api-keys/:key/owner", h.authorize(middleware.RoleOperator, h.UpdateAPIKeyOwner)...)
+	admin.PUT("/api-keys/:key/alert-thresholds", h.authorize(middleware.RoleOperator, h.UpdateAPIKeyAlertThresholds)...)
+	admin.PUT("/api-keys/:key/refund-limit", h.authorize(middleware.RoleOperator, h.UpdateAPIKeyRefundLimit)...)
+	admin.PUT("/api-keys/:key/limit-response", h.authorize(middleware.RoleOperator, h.UpdateAPIKeyLimitResponse)...)
+	admin.DELETE("/api-keys/:key", h.authorize(middleware.RoleAdmin, h.DeleteAPIKey)...)
+	admin.POST("/api-keys/:key/restore", h.authorize(middleware.RoleAdmin, h.RestoreAPIKey)...)
+	admin.DELETE("/api-keys/:key/purge", h.authorize(middleware.RoleAdmin, h.PurgeAPIKey)...)
+	admin.POST("/api-keys/:key/override", h.authorize(middleware.RoleOperator, h.CreateLimitOverride)...)
+	admin.POST("/api-keys/:key/rotate", h.authorize(middleware.RoleOperator, h.RotateAPIKey)...)
+	admin.GET("/api-keys/:key/sub-keys", h.authorize(middleware.RoleViewer, h.ListSubKeys)...)
+	admin.GET("/export", h.authorizePlatform(middleware.RoleAdmin, h.ExportAPIKeys)...)
+	admin.POST("/import", h.authorizePlatform(middleware.RoleAdmin, h.ImportAPIKeys)...)
+
+	if h.usageService != nil {
+		admin.GET("/api-keys/:key/usage", h.authorize(middleware.RoleViewer, h.GetAPIKeyUsage)...)
+	}
+
+	if h.analyticsService != nil {
+		admin.GET("/api-keys/:key/analytics", h.authorize(middleware.RoleViewer, h.GetAPIKeyAnalytics)...)
+		admin.GET("/analytics/top", h.authorize(middleware.RoleViewer, h.GetTopConsumers)...)
+	}
+
+	if h.exportService != nil {
+		admin.POST("/exports", h.authorizePlatform(middleware.RoleViewer, h.CreateUsageExport)...)
+		admin.GET("/exports/:id", h.authorizePlatform(middleware.RoleViewer, h.GetUsageExport)...)
+		admin.GET("/exports/:id/download", h.authorizePlatform(middleware.RoleViewer, h.DownloadUsageExport)...)
+	}
+
+	if h.webhookService != nil {
+		admin.GET("/api-keys/:key/webhook", h.authorize(middleware.RoleViewer, h.GetWebhook)...)
+		admin.PUT("/api-keys/:key/webhook", h.authorize(middleware.RoleOperator, h.SetWebhook)...)
+		admin.DELETE("/api-keys/:key/webhook", h.authorize(middleware.RoleOperator, h.DeleteWebhook)...)
+		admin.GET("/api-keys/:key/webhook/deliveries", h.authorize(middleware.RoleViewer, h.ListWebhookDeliveries)...)
+	}
+
+	if h.planService != nil {
+		admin.GET("/plans", h.authorize(middleware.RoleViewer, h.ListPlans)...)
+		admin.POST("/plans", h.authorizePlatform(middleware.RoleAdmin, h.CreatePlan)...)
+		admin.GET("/plans/:id", h.authorize(middleware.RoleViewer, h.GetPlan)...)
+		admin.PUT("/plans/:id", h.authorizePlatform(middleware.RoleAdmin, h.UpdatePlan)...)
+		admin.DELETE("/plans/:id", h.authorizePlatform(middleware.RoleAdmin, h.DeletePlan)...)
+		admin.POST("/plans/:id/reassign", h.authorizePlatform(middleware.RoleAdmin, h.ReassignPlan)...)
+	}
+
+	if h.organizationService != nil {
+		admin.GET("/organizations", h.authorize(middleware.RoleViewer, h.ListOrganizations)...)
+		admin.POST("/organizations", h.authorizePlatform(middleware.RoleAdmin, h.CreateOrganization)...)
+		admin.GET("/organizations/:id", h.authorize(middleware.RoleViewer, h.GetOrganization)...)
+		admin.PUT("/organizations/:id", h.authorizePlatform(middleware.RoleAdmin, h.UpdateOrganization)...)
+		admin.GET("/organizations/:id/projects", h.authorize(middleware.RoleViewer, h.ListProjects)...)
+		admin.POST("/organizations/:id/projects", h.authorize(middleware.RoleOperator, h.CreateProject)...)
+	}
+
+	if h.featureFlags != nil {
+		admin.GET("/features", h.authorizePlatform(middleware.RoleViewer, h.ListFeatureFlags)...)
+		admin.PUT("/features/:name", h.authorizePlatform(middleware.RoleAdmin, h.SetFeatureFlag)...)
+		admin.DELETE("/features/:name", h.authorizePlatform(middleware.RoleAdmin, h.ResetFeatureFlag)...)
+	}
+
+	if h.maintenance != nil {
+		admin.GET("/maintenance", h.authorizePlatform(middleware.RoleViewer, h.GetMaintenance)...)
+		admin.POST("/maintenance", h.authorizePlatform(middleware.RoleAdmin, h.SetMaintenance)...)
+	}
+
+	if h.configReloader != nil {
+		admin.POST("/config/reload", h.authorizePlatform(middleware.RoleAdmin, h.ReloadConfig)...)
+	}
+
+	if h.liveEvents != nil {
+		admin.GET("/events/stream", h.authorizePlatform(middleware.RoleViewer, h.StreamEvents)...)
+		admin.GET("/events/socket", h.authorizePlatform(middleware.RoleViewer, h.LiveSocket)...)
+	}
+}
+
+// SetupAPIRoutes registers the health check, the rate limited endpoints, the
+// OAuth2 token endpoint and the self-service endpoints of every API version.
+// Only the rate limited endpoints run the WithAPIMiddleware middleware.
+func (h *Handler) SetupAPIRoutes(router gin.IRouter) {
+	// Health check endpoints (no rate limiting, unversioned for probes)
 	router.GET("/health", h.HealthCheck)
+	h.registerProbes(router)
+
+	// API documentation (no rate limiting)
+	router.GET("/openapi.json", h.OpenAPI)
+	router.GET("/docs", h.Docs)
+	router.GET("/docs/assets/*file", h.DocsAsset)
 
-	// API key management endpoints (admin functionality)
-	admin := router.Group("/admin")
-	{
-		admin.POST("/api-keys", h.CreateAPIKey)
-		admin.DELETE("/api-keys/:key", h.DeactivateAPIKey)
+	for _, version := range h.versions() {
+		version.API(router.Group(version.Prefix+"/api", h.apiMiddleware...))
+		if h.accessTokens != nil {
+			router.POST(version.Prefix+"/oauth/token", h.tokenEndpoint()...)
+		}
+		if h.selfServiceEnabled() {
+			selfService := h.adminGroup(router.Group(version.Prefix), "/me")
+			selfService.Use(middleware.ETag())
+			version.SelfService(selfService)
+		}
+	}
+	if h.legacyRoutes {
+		legacy := router.Group("/api", middleware.Deprecated(CurrentAPIVersion, h.legacySunset))
+		h.registerAPIEndpoints(legacy.Group("", h.apiMiddleware...))
 	}
+}
 
-	// Protected endpoints (with rate limiting)
-	api := router.Group("/api")
-	{
-		api.GET("/status", h.GetStatus)
-		api.GET("/rate-limit", h.GetRateLimitStatus)
-		api.POST("/test", h.TestEndpoint)
+// Protected endpoints (with rate limiting)
+func (h *Handler) registerAPIEndpoints(api gin.IRouter) {
+	api.GET("/status", h.GetStatus)
+	api.GET("/rate-limit", h.GetRateLimitStatus)
+	api.POST("/rate-limit/refund", h.RefundRateLimit)
+	api.POST("/rate-limit/reservations", h.ReserveRateLimit)
+	api.POST("/rate-limit/reservations/:id/commit", h.CommitReservation)
+	api.DELETE("/rate-limit/reservations/:id", h.CancelReservation)
+	api.POST("/test", h.TestEndpoint)
+	if h.accessTokens != nil {
+		api.POST("/token", h.ExchangeToken)
 	}
 }
 
+// authorize prefixes an admin handler with its minimum role check when admin
+// authentication is configured, and for callers scoped to an organization
+// with the check that its :key, if any, is one of the organization's
+func (h *Handler) authorize(role middleware.Role, handler gin.HandlerFunc) []gin.HandlerFunc {
+	if len(h.adminAuthenticators) == 0 {
+		return []gin.HandlerFunc{handler}
+	}
+	return []gin.HandlerFunc{middleware.RequireRole(role), h.requireKeyScope, handler}
+}
+
 func (h *Handler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "healthy",
@@ -48,92 +364,790 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 	})
 }
 
+type createAPIKeyRequest struct {
+	Name                   string `json:"name" binding:"required"`
+	RateLimitRequests      int    `json:"rate_limit_requests"`
+	RateLimitWindowSeconds int    `json:"rate_limit_window_seconds"`
+	PlanID                 string `json:"plan_id"`
+
+	EndUserLimitRequests      int `json:"end_user_limit_requests" binding:"gte=0"`
+	EndUserLimitWindowSeconds int `json:"end_user_limit_window_seconds" binding:"gte=0"`
+
+	ExpiresAt      *time.Time `json:"expires_at"`
+	AllowedCIDRs   []string   `json:"allowed_cidrs"`
+	AllowedOrigins []string   `json:"allowed_origins"`
+
+	RequireSignature bool `json:"require_signature"`
+
+	// Creates a sub-key sharing this key's limits; accepts an ID or key
+	ParentKey string `json:"parent_key"`
+
+	// Project the key belongs to; required for callers scoped to an
+	// organization. Sub-keys belong to their parent's project.
+	ProjectID string `json:"project_id"`
+
+	OwnerName  string `json:"owner_name"`
+	OwnerEmail string `json:"owner_email" binding:"omitempty,email"`
+}
+
 func (h *Handler) CreateAPIKey(c *gin.Context) {
-	var request struct {
-		Name                   string `json:"name" binding:"required"`
-		RateLimitRequests      int    `json:"rate_limit_requests"`
-		RateLimitWindowSeconds int    `json:"rate_limit_window_seconds"`
+	var request createAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
 	}
 
+	params, rejected := h.apiKeyParams(c, &request)
+	if rejected != nil {
+		c.JSON(rejected.status, middleware.ErrorBody(c, gin.H{
+			"error":   rejected.title,
+			"message": rejected.message,
+		}))
+		return
+	}
+
+	apiKey, err := h.apiKeyService.CreateAPIKey(c.Request.Context(), params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdAPIKey(apiKey, &request, params))
+}
+
+// bulkCreateAPIKeysRequest holds up to 100 keys, which are created in a
+// single transaction
+type bulkCreateAPIKeysRequest struct {
+	Keys []createAPIKeyRequest `json:"keys" binding:"required,min=1,max=100,dive"`
+}
+
+// CreateAPIKeys creates several keys at once. Either all of them are
+// created or, if any is invalid or fails, none is.
+func (h *Handler) CreateAPIKeys(c *gin.Context) {
+	var request bulkCreateAPIKeysRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	params := make([]services.CreateAPIKeyParams, len(request.Keys))
+	for i := range request.Keys {
+		var rejected *apiKeyRejection
+		if params[i], rejected = h.apiKeyParams(c, &request.Keys[i]); rejected != nil {
+			c.JSON(rejected.status, middleware.ErrorBody(c, gin.H{
+				"error":   rejected.title,
+				"message": fmt.Sprintf("keys[%d]: %s", i, rejected.message),
+				"index":   i,
+			}))
+			return
+		}
+	}
+
+	apiKeys, err := h.apiKeyService.CreateAPIKeys(c.Request.Context(), params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create API keys",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	created := make([]gin.H, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		created[i] = createdAPIKey(apiKey, &request.Keys[i], params[i])
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_keys": created})
+}
+
+// apiKeyRejection is why a create request can't be served
+type apiKeyRejection struct {
+	status  int
+	title   string
+	message string
+}
+
+// apiKeyParams validates request and fills in its defaults
+func (h *Handler) apiKeyParams(c *gin.Context, request *createAPIKeyRequest) (services.CreateAPIKeyParams, *apiKeyRejection) {
+	invalid := func(message string) (services.CreateAPIKeyParams, *apiKeyRejection) {
+		return services.CreateAPIKeyParams{}, &apiKeyRejection{http.StatusBadRequest, "Invalid request", message}
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(h.now()) {
+		return invalid("expires_at must be in the future")
+	}
+
+	allowedCIDRs, err := services.NormalizeCIDRs(request.AllowedCIDRs)
+	if err != nil {
+		return invalid(err.Error())
+	}
+
+	allowedOrigins, err := services.NormalizeOrigins(request.AllowedOrigins)
+	if err != nil {
+		return invalid(err.Error())
+	}
+
+	var parent *database.APIKey
+	if request.ParentKey != "" {
+		if request.RateLimitRequests != 0 || request.RateLimitWindowSeconds != 0 || request.PlanID != "" ||
+			request.EndUserLimitRequests != 0 || request.EndUserLimitWindowSeconds != 0 {
+			return invalid("sub-keys inherit their limits and plan from the parent key")
+		}
+
+		parent, err = h.apiKeyService.GetAPIKey(c.Request.Context(), request.ParentKey)
+		if err != nil {
+			if errors.Is(err, services.ErrAPIKeyNotFound) {
+				return services.CreateAPIKeyParams{}, &apiKeyRejection{http.StatusNotFound, "Parent API key not found", err.Error()}
+			}
+			return services.CreateAPIKeyParams{}, &apiKeyRejection{http.StatusInternalServerError, "Failed to create API key", err.Error()}
+		}
+
+		if organizationID := middleware.AdminOrganization(c); organizationID != "" && parent.OrganizationID != organizationID {
+			return services.CreateAPIKeyParams{}, &apiKeyRejection{http.StatusNotFound, "Parent API key not found", services.ErrAPIKeyNotFound.Error()}
+		}
+		if parent.ParentID != "" || !parent.IsActive {
+			return invalid("parent_key must be an active key that is not itself a sub-key")
+		}
+		if request.ProjectID != "" && request.ProjectID != parent.ProjectID {
+			return invalid("sub-keys belong to the parent key's project")
+		}
+		request.ProjectID = parent.ProjectID
+	} else if rejected := h.checkProject(c, request.ProjectID); rejected != nil {
+		return services.CreateAPIKeyParams{}, rejected
+	}
+
+	// Set defaults if not provided; keys on a plan inherit the plan's limits instead
+	if request.PlanID == "" && parent == nil {
+		if request.RateLimitRequests <= 0 {
+			request.RateLimitRequests = 100
+		}
+		if request.RateLimitWindowSeconds <= 0 {
+			request.RateLimitWindowSeconds = 3600 // 1 hour
+		}
+	}
+
+	// The signing secret is returned once, alongside the key
+	var signingSecret string
+	if request.RequireSignature {
+		signingSecret, err = services.GenerateSigningSecret()
+		if err != nil {
+			return services.CreateAPIKeyParams{}, &apiKeyRejection{http.StatusInternalServerError, "Failed to create API key", err.Error()}
+		}
+	}
+
+	return services.CreateAPIKeyParams{
+		Name:                   request.Name,
+		RateLimitRequests:      request.RateLimitRequests,
+		RateLimitWindowSeconds: request.RateLimitWindowSeconds,
+		PlanID:                 request.PlanID,
+
+		EndUserLimitRequests:      request.EndUserLimitRequests,
+		EndUserLimitWindowSeconds: request.EndUserLimitWindowSeconds,
+		ExpiresAt:                 request.ExpiresAt,
+		AllowedCIDRs:              allowedCIDRs,
+		AllowedOrigins:            allowedOrigins,
+		SigningSecret:             signingSecret,
+		ParentID:                  parentID(parent),
+		OwnerName:                 request.OwnerName,
+		OwnerEmail:                request.OwnerEmail,
+		ProjectID:                 request.ProjectID,
+	}, nil
+}
+
+// checkProject rejects a new key's project unless it exists and, for callers
+// scoped to an organization, is one of the organization's. Those callers
+// must give one.
+func (h *Handler) checkProject(c *gin.Context, projectID string) *apiKeyRejection {
+	organizationID := middleware.AdminOrganization(c)
+	if projectID == "" {
+		if organizationID != "" {
+			return &apiKeyRejection{http.StatusBadRequest, "Invalid request", "project_id is required for credentials scoped to an organization"}
+		}
+		return nil
+	}
+	if h.organizationService == nil {
+		return &apiKeyRejection{http.StatusBadRequest, "Invalid request", "projects are not enabled"}
+	}
+
+	project, err := h.organizationService.GetProject(c.Request.Context(), projectID)
+	if err == nil && organizationID != "" && project.OrganizationID != organizationID {
+		err = services.ErrProjectNotFound
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrProjectNotFound) {
+			return &apiKeyRejection{http.StatusNotFound, "Project not found", services.ErrProjectNotFound.Error()}
+		}
+		return &apiKeyRejection{http.StatusInternalServerError, "Failed to create API key", err.Error()}
+	}
+	return nil
+}
+
+// createdAPIKey is the response describing a new key
+func createdAPIKey(apiKey string, request *createAPIKeyRequest, params services.CreateAPIKeyParams) gin.H {
+	response := gin.H{
+		"api_key":    apiKey,
+		"key_prefix": services.KeyPrefix(apiKey),
+		"name":       request.Name,
+	}
+	if params.ParentID != "" {
+		response["parent_id"] = params.ParentID
+	} else {
+		response["rate_limit"] = gin.H{
+			"requests":       request.RateLimitRequests,
+			"window_seconds": request.RateLimitWindowSeconds,
+		}
+	}
+	if request.PlanID != "" {
+		response["plan_id"] = request.PlanID
+	}
+	if request.EndUserLimitRequests > 0 {
+		response["end_user_limit"] = gin.H{
+			"requests":       request.EndUserLimitRequests,
+			"window_seconds": request.EndUserLimitWindowSeconds,
+		}
+	}
+	if request.ExpiresAt != nil {
+		response["expires_at"] = request.ExpiresAt
+	}
+	if len(params.AllowedCIDRs) > 0 {
+		response["allowed_cidrs"] = params.AllowedCIDRs
+	}
+	if len(params.AllowedOrigins) > 0 {
+		response["allowed_origins"] = params.AllowedOrigins
+	}
+	if params.SigningSecret != "" {
+		response["signing_secret"] = params.SigningSecret
+	}
+	if request.OwnerName != "" {
+		response["owner_name"] = request.OwnerName
+	}
+	if request.OwnerEmail != "" {
+		response["owner_email"] = request.OwnerEmail
+	}
+	if params.ProjectID != "" {
+		response["project_id"] = params.ProjectID
+	}
+	return response
+}
+
+func parentID(parent *database.APIKey) string {
+	if parent == nil {
+		return ""
+	}
+	return parent.ID
+}
+
+// ListAPIKeys returns a page of keys, or with ?owner= only those whose owner
+// name or email matches; see listQuery for paging, sorting and filtering.
+// Deleted keys are only listed with ?include_deleted=true. Callers scoped to
+// an organization only see its keys.
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	query, err := parseKeyListQuery(c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+	includeDeleted, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid include_deleted",
+			"message": "include_deleted must be true or false",
+		}))
+		return
+	}
+
+	h.listKeyPage(c, "api_keys", services.APIKeyFilter{
+		Owner:          c.Query("owner"),
+		OrganizationID: middleware.AdminOrganization(c),
+		IncludeDeleted: includeDeleted,
+	}, query)
+}
+
+// listKeyPage writes the page of keys matching filter that query asks for.
+// It lists one key more than the page holds to tell whether there is a next
+// page.
+func (h *Handler) listKeyPage(c *gin.Context, field string, filter services.APIKeyFilter, query listQuery) {
+	filter.Filters, filter.Sort, filter.After, filter.Limit = query.filters, query.sort, query.after, query.limit+1
+
+	apiKeys, err := h.apiKeyService.ListAPIKeys(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidListQuery) {
+			invalidListQuery(c, err)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to list API keys",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	if len(apiKeys) <= query.limit {
+		listResponse(c, field, apiKeys, "")
+		return
+	}
+	last := apiKeys[query.limit-1]
+	listResponse(c, field, apiKeys[:query.limit], encodeKeyCursor(services.KeyPosition{
+		Values:    query.sort.Values(last),
+		CreatedAt: last.CreatedAt,
+		ID:        last.ID,
+	}))
+}
+
+func (h *Handler) GetAPIKey(c *gin.Context) {
+	apiKey, err := h.apiKeyService.GetAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+// ListSubKeys returns a page of the sub-keys created under a key
+func (h *Handler) ListSubKeys(c *gin.Context) {
+	query, err := parseKeyListQuery(c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	parent, err := h.apiKeyService.GetAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	h.listKeyPage(c, "sub_keys", services.APIKeyFilter{ParentID: parent.ID}, query)
+}
+
+// GetAPIKeyUsage returns a key's persisted lifetime and daily request counts.
+// The optional days query parameter (default 30, max 365) limits the history,
+// which is paged, sorted and filtered like other lists.
+func (h *Handler) GetAPIKeyUsage(c *gin.Context) {
+	query, err := parseListQuery[database.DailyUsage](c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	days := 30
+	if value := c.Query("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 365 {
+			c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+				"error":   "Invalid request",
+				"message": "days must be between 1 and 365",
+			}))
+			return
+		}
+		days = parsed
+	}
+
+	apiKey, err := h.apiKeyService.GetAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	usage, err := h.usageService.GetUsage(c.Request.Context(), apiKey.ID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get usage",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	var nextCursor string
+	usage.Daily, nextCursor = applyListQuery(usage.Daily, query)
+	listResponse(c, "usage", usage, nextCursor)
+}
+
+type ownerRequest struct {
+	OwnerName  string `json:"owner_name"`
+	OwnerEmail string `json:"owner_email" binding:"omitempty,email"`
+}
+
+// UpdateAPIKeyOwner sets the contact details of a key's owner
+func (h *Handler) UpdateAPIKeyOwner(c *gin.Context) {
+	var request ownerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	apiKey, err := h.apiKeyService.UpdateAPIKeyOwner(c.Request.Context(), c.Param("key"), request.OwnerName, request.OwnerEmail)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to update API key owner",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+type alertThresholdsRequest struct {
+	// Percentages of the rate limit or quota, from 1 to 100; empty clears them
+	Thresholds []int64 `json:"thresholds"`
+}
+
+// UpdateAPIKeyAlertThresholds sets the percentages of its rate limit or quota
+// at which a key's responses carry an X-RateLimit-Warning header and an alert
+// is raised
+func (h *Handler) UpdateAPIKeyAlertThresholds(c *gin.Context) {
+	var request alertThresholdsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	thresholds, err := services.NormalizeAlertThresholds(request.Thresholds)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
 			"error":   "Invalid request",
 			"message": err.Error(),
-		})
+		}))
 		return
 	}
 
-	// Set defaults if not provided
-	if request.RateLimitRequests <= 0 {
-		request.RateLimitRequests = 100
+	apiKey, err := h.apiKeyService.UpdateAPIKeyAlertThresholds(c.Request.Context(), c.Param("key"), thresholds)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to update API key alert thresholds",
+			"message": err.Error(),
+		}))
+		return
 	}
-	if request.RateLimitWindowSeconds <= 0 {
-		request.RateLimitWindowSeconds = 3600 // 1 hour
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+type refundLimitRequest struct {
+	// Units the key may refund per window; 0 disallows refunds
+	RefundLimit *int `json:"refund_limit" binding:"required,gte=0"`
+}
+
+// UpdateAPIKeyRefundLimit sets how many units of its rate limit a key may
+// give back per window through the refund endpoint
+func (h *Handler) UpdateAPIKeyRefundLimit(c *gin.Context) {
+	var request refundLimitRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
 	}
 
-	apiKey, err := h.apiKeyService.CreateAPIKey(
-		request.Name,
-		request.RateLimitRequests,
-		request.RateLimitWindowSeconds,
-	)
+	apiKey, err := h.apiKeyService.UpdateAPIKeyRefundLimit(c.Request.Context(), c.Param("key"), *request.RefundLimit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to create API key",
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to update API key refund limit",
 			"message": err.Error(),
-		})
+		}))
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"api_key": apiKey,
-		"name":    request.Name,
-		"rate_limit": gin.H{
-			"requests":       request.RateLimitRequests,
-			"window_seconds": request.RateLimitWindowSeconds,
-		},
 	})
 }
 
-func (h *Handler) DeactivateAPIKey(c *gin.Context) {
+type limitResponseRequest struct {
+	// The body and header values may use the {limit}, {remaining}, {reset}
+	// and {retry_after} variables; neither clears the key's response
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+}
+
+// UpdateAPIKeyLimitResponse sets the response a key gets instead of the
+// standard 429 when it exceeds its rate limit or quota, e.g. with a link to
+// upgrade its plan
+func (h *Handler) UpdateAPIKeyLimitResponse(c *gin.Context) {
+	var request limitResponseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	response, err := services.NormalizeLimitResponse(&database.LimitResponse{Body: request.Body, Headers: request.Headers})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	apiKey, err := h.apiKeyService.UpdateAPIKeyLimitResponse(c.Request.Context(), c.Param("key"), response)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to update API key limit response",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
+
+// DeleteAPIKey soft-deletes a key: it stops working and is no longer listed,
+// but can be brought back with RestoreAPIKey
+func (h *Handler) DeleteAPIKey(c *gin.Context) {
 	apiKey := c.Param("key")
 	if apiKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
 			"error":   "API key required",
 			"message": "Please provide an API key in the URL path",
-		})
+		}))
 		return
 	}
 
-	err := h.apiKeyService.DeactivateAPIKey(apiKey)
+	err := h.apiKeyService.DeleteAPIKey(c.Request.Context(), apiKey)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+		c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
 			"error":   "API key not found",
 			"message": err.Error(),
-		})
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key deleted successfully",
+	})
+}
+
+// RestoreAPIKey brings back a deleted key with its settings, sub-keys and
+// history. Its secret works again right away.
+func (h *Handler) RestoreAPIKey(c *gin.Context) {
+	apiKey, err := h.apiKeyService.RestoreAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": "The key doesn't exist or isn't deleted",
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to restore API key",
+			"message": err.Error(),
+		}))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "API key deactivated successfully",
+		"api_key": apiKey,
+	})
+}
+
+// PurgeAPIKey permanently removes a key along with its overrides and Redis
+// counters, for data removal requests. Use DeleteAPIKey to revoke a key
+// while keeping its record.
+func (h *Handler) PurgeAPIKey(c *gin.Context) {
+	id, err := h.apiKeyService.PurgeAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to purge API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	// The database row is already gone at this point; any Redis keys left
+	// behind on failure still expire with their windows
+	deleted, err := h.rateLimitService.ClearKeyState(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to clear rate limit state",
+			"message": err.Error(),
+			"id":      id,
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "API key purged successfully",
+		"id":                 id,
+		"redis_keys_deleted": deleted,
+	})
+}
+
+type limitOverrideRequest struct {
+	RateLimitRequests int       `json:"rate_limit_requests" binding:"required,gt=0"`
+	ExpiresAt         time.Time `json:"expires_at" binding:"required"`
+}
+
+func (h *Handler) CreateLimitOverride(c *gin.Context) {
+	var request limitOverrideRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	if !request.ExpiresAt.After(h.now()) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": "expires_at must be in the future",
+		}))
+		return
+	}
+
+	override, err := h.apiKeyService.CreateLimitOverride(c.Request.Context(), c.Param("key"), request.RateLimitRequests, request.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create limit override",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"override": override,
+	})
+}
+
+type rotateRequest struct {
+	GracePeriodSeconds int `json:"grace_period_seconds" binding:"gte=0"`
+}
+
+func (h *Handler) RotateAPIKey(c *gin.Context) {
+	var request rotateRequest
+
+	// The body is optional; without it the default grace period applies
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(middleware.InvalidBody(c, err))
+			return
+		}
+	}
+
+	gracePeriod := h.rotationGracePeriod
+	if request.GracePeriodSeconds > 0 {
+		gracePeriod = time.Duration(request.GracePeriodSeconds) * time.Second
+	}
+
+	rotated, err := h.apiKeyService.RotateAPIKey(c.Request.Context(), c.Param("key"), gracePeriod)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to rotate API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":                      rotated.ID,
+		"api_key":                 rotated.APIKey,
+		"key_prefix":              rotated.KeyPrefix,
+		"previous_key_expires_at": rotated.PreviousKeyExpiresAt,
 	})
 }
 
 func (h *Handler) GetStatus(c *gin.Context) {
 	apiKey, exists := c.Get("api_key")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
 			"error": "API key not found in context",
-		})
+		}))
 		return
 	}
 
 	apiKeyRecord := apiKey.(*database.APIKey)
 
-	c.JSON(http.StatusOK, gin.H{
+	respond(c, http.StatusOK, gin.H{
 		"status": "authenticated",
 		"api_key": gin.H{
-			"id":   apiKeyRecord.ID,
-			"name": apiKeyRecord.Name,
+			"id":         apiKeyRecord.ID,
+			"key_prefix": apiKeyRecord.KeyPrefix,
+			"name":       apiKeyRecord.Name,
 		},
 	})
 }
@@ -141,9 +1155,9 @@ func (h *Handler) GetStatus(c *gin.Context) {
 func (h *Handler) GetRateLimitStatus(c *gin.Context) {
 	apiKey, exists := c.Get("api_key")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
 			"error": "API key not found in context",
-		})
+		}))
 		return
 	}
 
@@ -151,47 +1165,55 @@ func (h *Handler) GetRateLimitStatus(c *gin.Context) {
 
 	rateLimitResult, err := h.rateLimitService.GetRateLimitStatus(c.Request.Context(), apiKeyRecord)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		respond(c, http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
 			"error":   "Failed to get rate limit status",
 			"message": err.Error(),
-		})
+		}))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	penalty := gin.H{
+		"active": rateLimitResult.Penalized(),
+	}
+	if rateLimitResult.Penalized() {
+		penalty["expires_at"] = rateLimitResult.PenaltyExpiresAt
+		penalty["level"] = rateLimitResult.PenaltyLevel
+	}
+
+	respond(c, http.StatusOK, gin.H{
 		"rate_limit": gin.H{
 			"limit":      rateLimitResult.Limit,
 			"remaining":  rateLimitResult.Remaining,
 			"reset_time": rateLimitResult.ResetTime,
 			"allowed":    rateLimitResult.Allowed,
+			"penalty":    penalty,
 		},
 	})
 }
 
+type testRequest struct {
+	Message string `json:"message"`
+}
+
 func (h *Handler) TestEndpoint(c *gin.Context) {
 	apiKey, exists := c.Get("api_key")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
 			"error": "API key not found in context",
-		})
+		}))
 		return
 	}
 
 	apiKeyRecord := apiKey.(*database.APIKey)
 
-	var request struct {
-		Message string `json:"message"`
-	}
-
+	var request testRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid request",
-			"message": err.Error(),
-		})
+		status, body := middleware.InvalidBody(c, err)
+		respond(c, status, body)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	respond(c, http.StatusOK, gin.H{
 		"message": "Request processed successfully",
 		"echo":    request.Message,
 		"api_key": gin.H{
@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
 	"grpc-firstls/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -23,24 +26,141 @@ type MockAPIKeyService struct {
 	mock.Mock
 }
 
-func (m *MockAPIKeyService) ValidateAPIKey(apiKey string) (*database.APIKey, error) {
-	args := m.Called(apiKey)
+func (m *MockAPIKeyService) ValidateAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*database.APIKey), args.Error(1)
 }
 
-func (m *MockAPIKeyService) CreateAPIKey(name string, rateLimitRequests int, rateLimitWindowSeconds int) (string, error) {
-	args := m.Called(name, rateLimitRequests, rateLimitWindowSeconds)
+func (m *MockAPIKeyService) CreateAPIKey(ctx context.Context, params services.CreateAPIKeyParams) (string, error) {
+	args := m.Called(ctx, params)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockAPIKeyService) DeactivateAPIKey(apiKey string) error {
-	args := m.Called(apiKey)
+func (m *MockAPIKeyService) CreateAPIKeys(ctx context.Context, params []services.CreateAPIKeyParams) ([]string, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListAPIKeys(ctx context.Context, filter services.APIKeyFilter) ([]*database.APIKey, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyOwner(ctx context.Context, apiKey string, ownerName string, ownerEmail string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, ownerName, ownerEmail)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RenameAPIKey(ctx context.Context, apiKey string, name string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyAlertThresholds(ctx context.Context, apiKey string, thresholds []int64) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, thresholds)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyRefundLimit(ctx context.Context, apiKey string, limit int) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyLimitResponse(ctx context.Context, apiKey string, response *database.LimitResponse) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, response)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListSubKeys(ctx context.Context, parentID string) ([]*database.APIKey, error) {
+	args := m.Called(ctx, parentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) DeleteAPIKey(ctx context.Context, apiKey string) error {
+	args := m.Called(ctx, apiKey)
 	return args.Error(0)
 }
 
+func (m *MockAPIKeyService) RestoreAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) PurgeAPIKey(ctx context.Context, apiKey string) (string, error) {
+	args := m.Called(ctx, apiKey)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockAPIKeyService) CreateLimitOverride(ctx context.Context, apiKey string, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	args := m.Called(ctx, apiKey, rateLimitRequests, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.LimitOverride), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RotateAPIKey(ctx context.Context, apiKey string, gracePeriod time.Duration) (*services.RotatedAPIKey, error) {
+	args := m.Called(ctx, apiKey, gracePeriod)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RotatedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ExportAPIKeys(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.ExportedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ImportAPIKeys(ctx context.Context, keys []*database.ExportedAPIKey, dryRun bool) (*services.ImportReport, error) {
+	args := m.Called(ctx, keys, dryRun)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.ImportReport), args.Error(1)
+}
+
 // MockRateLimitService is a mock implementation of RateLimitServiceInterface
 type MockRateLimitService struct {
 	mock.Mock
@@ -62,6 +182,75 @@ func (m *MockRateLimitService) GetRateLimitStatus(ctx context.Context, apiKey *d
 	return args.Get(0).(*services.RateLimitResult), args.Error(1)
 }
 
+func (m *MockRateLimitService) CheckEndUserLimit(ctx context.Context, apiKey *database.APIKey, endUserID string) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey, endUserID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckOrganizationLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckTokenLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckUniqueLimit(ctx context.Context, apiKey *database.APIKey, rule config.UniqueLimitRule, value string) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey, rule, value)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) RefundRateLimit(ctx context.Context, apiKey *database.APIKey, units int64) (*services.RefundResult, error) {
+	args := m.Called(ctx, apiKey, units)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RefundResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) ReserveRateLimit(ctx context.Context, apiKey *database.APIKey, units int64, ttl time.Duration) (*services.ReservationResult, error) {
+	args := m.Called(ctx, apiKey, units, ttl)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.ReservationResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CommitReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	args := m.Called(ctx, apiKey, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.SettledReservation), args.Error(1)
+}
+
+func (m *MockRateLimitService) CancelReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	args := m.Called(ctx, apiKey, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.SettledReservation), args.Error(1)
+}
+
+func (m *MockRateLimitService) ClearKeyState(ctx context.Context, apiKeyID string) (int64, error) {
+	args := m.Called(ctx, apiKeyID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func setupTestRouter() (*gin.Engine, *MockAPIKeyService, *MockRateLimitService, *Handler) {
 	gin.SetMode(gin.TestMode)
 
@@ -120,7 +309,7 @@ func TestCreateAPIKey_Success(t *testing.T) {
 
 	// Setup mock expectations
 	expectedAPIKey := "ak_1234567890_abcdef"
-	mockAPIKeyService.On("CreateAPIKey", "Test API Key", 100, 3600).Return(expectedAPIKey, nil)
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Test API Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600}).Return(expectedAPIKey, nil)
 
 	// Create request body
 	requestBody := map[string]interface{}{
@@ -143,6 +332,7 @@ func TestCreateAPIKey_Success(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equal(t, expectedAPIKey, response["api_key"])
+	assert.Equal(t, "ak_123456789", response["key_prefix"])
 	assert.Equal(t, "Test API Key", response["name"])
 
 	rateLimit := response["rate_limit"].(map[string]interface{})
@@ -157,7 +347,7 @@ func TestCreateAPIKey_WithDefaults(t *testing.T) {
 
 	// Setup mock expectations with default values
 	expectedAPIKey := "ak_1234567890_abcdef"
-	mockAPIKeyService.On("CreateAPIKey", "Test API Key", 100, 3600).Return(expectedAPIKey, nil)
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Test API Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600}).Return(expectedAPIKey, nil)
 
 	// Create request body without rate limit fields
 	requestBody := map[string]interface{}{
@@ -215,7 +405,7 @@ func TestCreateAPIKey_ServiceError(t *testing.T) {
 	router, mockAPIKeyService, _, _ := setupTestRouter()
 
 	// Setup mock to return error
-	mockAPIKeyService.On("CreateAPIKey", "Test API Key", 100, 3600).Return("", fmt.Errorf("database error"))
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Test API Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600}).Return("", fmt.Errorf("database error"))
 
 	requestBody := map[string]interface{}{
 		"name":                      "Test API Key",
@@ -242,12 +432,74 @@ func TestCreateAPIKey_ServiceError(t *testing.T) {
 	mockAPIKeyService.AssertExpectations(t)
 }
 
-func TestDeactivateAPIKey_Success(t *testing.T) {
+func TestCreateAPIKeys_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("CreateAPIKeys", mock.Anything, []services.CreateAPIKeyParams{
+		{Name: "First", RateLimitRequests: 100, RateLimitWindowSeconds: 3600},
+		{Name: "Second", RateLimitRequests: 10, RateLimitWindowSeconds: 60},
+	}).Return([]string{"ak_first_key_123", "ak_second_key_456"}, nil)
+
+	requestBody := map[string]interface{}{
+		"keys": []map[string]interface{}{
+			{"name": "First"},
+			{"name": "Second", "rate_limit_requests": 10, "rate_limit_window_seconds": 60},
+		},
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys/bulk", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		APIKeys []map[string]interface{} `json:"api_keys"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.APIKeys, 2)
+	assert.Equal(t, "ak_second_key_456", response.APIKeys[1]["api_key"])
+	assert.Equal(t, "Second", response.APIKeys[1]["name"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKeys_InvalidKey(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	requestBody := map[string]interface{}{
+		"keys": []map[string]interface{}{
+			{"name": "First"},
+			{"name": "Second", "allowed_cidrs": []string{"not-a-cidr"}},
+		},
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys/bulk", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(1), response["index"])
+	assert.Contains(t, response["message"], "keys[1]")
+
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKeys", mock.Anything, mock.Anything)
+}
+
+func TestDeleteAPIKey_Success(t *testing.T) {
 	router, mockAPIKeyService, _, _ := setupTestRouter()
 
 	// Setup mock expectations
 	testAPIKey := "ak_1234567890_abcdef"
-	mockAPIKeyService.On("DeactivateAPIKey", testAPIKey).Return(nil)
+	mockAPIKeyService.On("DeleteAPIKey", mock.Anything, testAPIKey).Return(nil)
 
 	req, _ := http.NewRequest("DELETE", "/admin/api-keys/"+testAPIKey, nil)
 	w := httptest.NewRecorder()
@@ -260,12 +512,12 @@ func TestDeactivateAPIKey_Success(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 
-	assert.Equal(t, "API key deactivated successfully", response["message"])
+	assert.Equal(t, "API key deleted successfully", response["message"])
 
 	mockAPIKeyService.AssertExpectations(t)
 }
 
-func TestDeactivateAPIKey_MissingKey(t *testing.T) {
+func TestDeleteAPIKey_MissingKey(t *testing.T) {
 	router, _, _, _ := setupTestRouter()
 
 	// Test with empty key in URL path
@@ -278,12 +530,12 @@ func TestDeactivateAPIKey_MissingKey(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
-func TestDeactivateAPIKey_NotFound(t *testing.T) {
+func TestDeleteAPIKey_NotFound(t *testing.T) {
 	router, mockAPIKeyService, _, _ := setupTestRouter()
 
 	// Setup mock to return error
 	testAPIKey := "ak_1234567890_abcdef"
-	mockAPIKeyService.On("DeactivateAPIKey", testAPIKey).Return(fmt.Errorf("API key not found"))
+	mockAPIKeyService.On("DeleteAPIKey", mock.Anything, testAPIKey).Return(fmt.Errorf("API key not found"))
 
 	req, _ := http.NewRequest("DELETE", "/admin/api-keys/"+testAPIKey, nil)
 	w := httptest.NewRecorder()
@@ -302,6 +554,45 @@ func TestDeactivateAPIKey_NotFound(t *testing.T) {
 	mockAPIKeyService.AssertExpectations(t)
 }
 
+func TestRestoreAPIKey_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	mockAPIKeyService.On("RestoreAPIKey", mock.Anything, apiKey.ID).Return(apiKey, nil)
+
+	req, _ := http.NewRequest("POST", "/admin/api-keys/"+apiKey.ID+"/restore", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, apiKey.ID, response["api_key"].(map[string]interface{})["id"])
+	assert.NotContains(t, response["api_key"], "deleted_at")
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestRestoreAPIKey_NotDeleted(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("RestoreAPIKey", mock.Anything, "ak_active").Return(nil, services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("POST", "/admin/api-keys/ak_active/restore", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "API key not found", response["error"])
+	assert.Equal(t, "The key doesn't exist or isn't deleted", response["message"])
+	mockAPIKeyService.AssertExpectations(t)
+}
+
 func TestGetStatus_Success(t *testing.T) {
 	// Create a test API key
 	testAPIKey := createTestAPIKey()
@@ -503,3 +794,996 @@ func TestTestEndpoint_NoAPIKeyInContext(t *testing.T) {
 
 	assert.Equal(t, "API key not found in context", response["error"])
 }
+
+func TestCreateLimitOverride_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	override := &database.LimitOverride{
+		ID:                "override-id",
+		APIKeyID:          "test-id-123",
+		RateLimitRequests: 5000,
+		ExpiresAt:         expiresAt,
+		CreatedAt:         time.Now(),
+	}
+	mockAPIKeyService.On("CreateLimitOverride", mock.Anything, "test-api-key", 5000, expiresAt).Return(override, nil)
+
+	requestBody := map[string]interface{}{
+		"rate_limit_requests": 5000,
+		"expires_at":          expiresAt.Format(time.RFC3339),
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys/test-api-key/override", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "override-id", response["override"].(map[string]interface{})["id"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateLimitOverride_ExpiryInPast(t *testing.T) {
+	router, _, _, _ := setupTestRouter()
+
+	requestBody := map[string]interface{}{
+		"rate_limit_requests": 5000,
+		"expires_at":          time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys/test-api-key/override", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateLimitOverride_KeyNotFound(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("CreateLimitOverride", mock.Anything, "missing-key", 5000, mock.Anything).Return(nil, services.ErrAPIKeyNotFound)
+
+	requestBody := map[string]interface{}{
+		"rate_limit_requests": 5000,
+		"expires_at":          time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys/missing-key/override", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestGetRateLimitStatus_Penalized(t *testing.T) {
+	testAPIKey := createTestAPIKey()
+	testRateLimitResult := createTestRateLimitResult()
+	testRateLimitResult.Allowed = false
+	testRateLimitResult.PenaltyExpiresAt = time.Now().Add(10 * time.Minute)
+	testRateLimitResult.PenaltyLevel = 2
+
+	_, _, mockRateLimitService, handler := setupTestRouter()
+	mockRateLimitService.On("GetRateLimitStatus", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
+
+	req, _ := http.NewRequest("GET", "/api/rate-limit", nil)
+	w := httptest.NewRecorder()
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Set("api_key", testAPIKey)
+
+	handler.GetRateLimitStatus(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	penalty := response["rate_limit"].(map[string]interface{})["penalty"].(map[string]interface{})
+	assert.Equal(t, true, penalty["active"])
+	assert.Equal(t, float64(2), penalty["level"])
+	assert.NotEmpty(t, penalty["expires_at"])
+
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRotateAPIKey_DefaultGracePeriod(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	rotated := &services.RotatedAPIKey{
+		ID:                   "test-id-123",
+		APIKey:               "ak_new_secret",
+		PreviousKeyExpiresAt: time.Now().Add(DefaultRotationGracePeriod),
+	}
+	mockAPIKeyService.On("RotateAPIKey", mock.Anything, "test-id-123", DefaultRotationGracePeriod).Return(rotated, nil)
+
+	req, _ := http.NewRequest("POST", "/admin/api-keys/test-id-123/rotate", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "ak_new_secret", response["api_key"])
+	assert.Equal(t, "test-id-123", response["id"])
+	assert.NotEmpty(t, response["previous_key_expires_at"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestRotateAPIKey_CustomGracePeriod(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	rotated := &services.RotatedAPIKey{ID: "test-id-123", APIKey: "ak_new_secret", PreviousKeyExpiresAt: time.Now().Add(time.Hour)}
+	mockAPIKeyService.On("RotateAPIKey", mock.Anything, "test-id-123", time.Hour).Return(rotated, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"grace_period_seconds": 3600})
+	req, _ := http.NewRequest("POST", "/admin/api-keys/test-id-123/rotate", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestRotateAPIKey_NotFound(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("RotateAPIKey", mock.Anything, "missing", DefaultRotationGracePeriod).Return(nil, services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("POST", "/admin/api-keys/missing/rotate", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_WithExpiry(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(params services.CreateAPIKeyParams) bool {
+		return params.ExpiresAt != nil && params.ExpiresAt.Equal(expiresAt)
+	})).Return("ak_expiring_key", nil)
+
+	requestBody := map[string]interface{}{
+		"name":       "Expiring Key",
+		"expires_at": expiresAt.Format(time.RFC3339),
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, expiresAt.Format(time.RFC3339), response["expires_at"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_ExpiryInPast(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	requestBody := map[string]interface{}{
+		"name":       "Expired Key",
+		"expires_at": time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestListAPIKeys_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.KeyPrefix = "ak_170000000"
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Limit: defaultListLimit + 1}).Return([]*database.APIKey{apiKey}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	apiKeys := response["api_keys"].([]interface{})
+	assert.Len(t, apiKeys, 1)
+	listed := apiKeys[0].(map[string]interface{})
+	assert.Equal(t, "ak_170000000", listed["key_prefix"])
+	assert.NotContains(t, listed, "key_hash")
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestListAPIKeys_ServiceError(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Limit: defaultListLimit + 1}).Return(nil, assert.AnError)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestPurgeAPIKey_Success(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService, _ := setupTestRouter()
+
+	mockAPIKeyService.On("PurgeAPIKey", mock.Anything, "test-api-key").Return("test-id-123", nil)
+	mockRateLimitService.On("ClearKeyState", mock.Anything, "test-id-123").Return(int64(3), nil)
+
+	req, _ := http.NewRequest("DELETE", "/admin/api-keys/test-api-key/purge", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id-123", response["id"])
+	assert.Equal(t, float64(3), response["redis_keys_deleted"])
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestPurgeAPIKey_NotFound(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService, _ := setupTestRouter()
+
+	mockAPIKeyService.On("PurgeAPIKey", mock.Anything, "missing-key").Return("", services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("DELETE", "/admin/api-keys/missing-key/purge", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockRateLimitService.AssertNotCalled(t, "ClearKeyState", mock.Anything, mock.Anything)
+}
+
+func TestPurgeAPIKey_RedisError(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService, _ := setupTestRouter()
+
+	mockAPIKeyService.On("PurgeAPIKey", mock.Anything, "test-api-key").Return("test-id-123", nil)
+	mockRateLimitService.On("ClearKeyState", mock.Anything, "test-id-123").Return(int64(0), assert.AnError)
+
+	req, _ := http.NewRequest("DELETE", "/admin/api-keys/test-api-key/purge", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "test-id-123")
+}
+
+func TestCreateAPIKey_WithAllowedCIDRs(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(params services.CreateAPIKeyParams) bool {
+		return assert.ObjectsAreEqual([]string{"10.0.0.0/8", "203.0.113.7/32"}, params.AllowedCIDRs)
+	})).Return("ak_restricted_key", nil)
+
+	requestBody := map[string]interface{}{
+		"name":          "Restricted Key",
+		"allowed_cidrs": []string{"10.0.0.0/8", "203.0.113.7"},
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_InvalidCIDR(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	requestBody := map[string]interface{}{
+		"name":          "Restricted Key",
+		"allowed_cidrs": []string{"10.0.0.0/99"},
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestCreateAPIKey_InvalidOrigin(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	requestBody := map[string]interface{}{
+		"name":            "Browser Key",
+		"allowed_origins": []string{"app.example.com"},
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestGetAPIKey_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	lastUsedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	apiKey.LastUsedAt = &lastUsedAt
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-id-123").Return(apiKey, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-id-123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	detail := response["api_key"].(map[string]interface{})
+	assert.Equal(t, "test-id-123", detail["id"])
+	assert.Equal(t, lastUsedAt.Format(time.RFC3339), detail["last_used_at"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestGetAPIKey_NotFound(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "missing-key").Return(nil, services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/missing-key", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetAPIKey_NotModified(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-id-123").Return(apiKey, nil)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/admin/api-keys/test-id-123", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = get(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// A changed key gets a new tag
+	apiKey.Name = "Renamed"
+	w = get(etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+// MockUsageService is a mock implementation of UsageServiceInterface
+type MockUsageService struct {
+	mock.Mock
+}
+
+func (m *MockUsageService) IncrementUsage(ctx context.Context, apiKeyID string) error {
+	args := m.Called(ctx, apiKeyID)
+	return args.Error(0)
+}
+
+func (m *MockUsageService) GetUsage(ctx context.Context, apiKeyID string, days int) (*database.KeyUsage, error) {
+	args := m.Called(ctx, apiKeyID, days)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.KeyUsage), args.Error(1)
+}
+
+func setupUsageTestRouter() (*gin.Engine, *MockAPIKeyService, *MockUsageService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockUsageService := &MockUsageService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithUsageService(mockUsageService))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockAPIKeyService, mockUsageService
+}
+
+func TestGetAPIKeyUsage_Success(t *testing.T) {
+	router, mockAPIKeyService, mockUsageService := setupUsageTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockUsageService.On("GetUsage", mock.Anything, "test-id-123", 7).Return(&database.KeyUsage{
+		APIKeyID:         "test-id-123",
+		LifetimeRequests: 1234,
+		Daily:            []database.DailyUsage{{Day: "2025-06-02", Requests: 9}},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/usage?days=7", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	usage := response["usage"].(map[string]interface{})
+	assert.Equal(t, float64(1234), usage["lifetime_requests"])
+	assert.Len(t, usage["daily"], 1)
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockUsageService.AssertExpectations(t)
+}
+
+func TestGetAPIKeyUsage_InvalidDays(t *testing.T) {
+	router, _, _ := setupUsageTestRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/usage?days=1000", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetAPIKeyUsage_KeyNotFound(t *testing.T) {
+	router, mockAPIKeyService, mockUsageService := setupUsageTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "missing-key").Return(nil, services.ErrAPIKeyNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/missing-key/usage", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockUsageService.AssertNotCalled(t, "GetUsage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetAPIKeyUsage_NotRegisteredWithoutService(t *testing.T) {
+	router, _, _, _ := setupTestRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/usage", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateAPIKey_RequireSignature(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	var issuedSecret string
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(params services.CreateAPIKeyParams) bool {
+		issuedSecret = params.SigningSecret
+		return strings.HasPrefix(params.SigningSecret, "ss_")
+	})).Return("ak_signed_key", nil)
+
+	requestBody := map[string]interface{}{
+		"name":              "Signed Key",
+		"require_signature": true,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, issuedSecret, response["signing_secret"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_SubKey(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	parent := createTestAPIKey()
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, parent.ID).Return(parent, nil)
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Billing Service", ParentID: parent.ID}).Return("ak_1234567890_abcdef", nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":       "Billing Service",
+		"parent_key": parent.ID,
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, parent.ID, response["parent_id"])
+	assert.NotContains(t, response, "rate_limit")
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_SubKeyWithLimits(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":                "Billing Service",
+		"parent_key":          "test-id-123",
+		"rate_limit_requests": 50,
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestCreateAPIKey_NestedSubKey(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	parent := createTestAPIKey()
+	parent.ParentID = "grandparent-id"
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, parent.ID).Return(parent, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":       "Billing Service",
+		"parent_key": parent.ID,
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestCreateAPIKey_SubKeyParentNotFound(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "ak_missing").Return(nil, services.ErrAPIKeyNotFound)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":       "Billing Service",
+		"parent_key": "ak_missing",
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestListSubKeys_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	parent := createTestAPIKey()
+	subKey := createTestAPIKey()
+	subKey.ID = "child-id"
+	subKey.ParentID = parent.ID
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, parent.ID).Return(parent, nil)
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{ParentID: parent.ID, Limit: defaultListLimit + 1}).Return([]*database.APIKey{subKey}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/"+parent.ID+"/sub-keys", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	subKeys := response["sub_keys"].([]interface{})
+	assert.Len(t, subKeys, 1)
+	assert.Equal(t, parent.ID, subKeys[0].(map[string]interface{})["parent_id"])
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestListAPIKeys_FilterByOwner(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.OwnerEmail = "payments@example.com"
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Owner: "payments@example.com", Limit: defaultListLimit + 1}).Return([]*database.APIKey{apiKey}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys?owner=payments@example.com", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	apiKeys := response["api_keys"].([]interface{})
+	assert.Equal(t, "payments@example.com", apiKeys[0].(map[string]interface{})["owner_email"])
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestListAPIKeys_IncludeDeleted(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	deletedAt := time.Now()
+	apiKey.IsActive, apiKey.DeletedAt = false, &deletedAt
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{IncludeDeleted: true, Limit: defaultListLimit + 1}).Return([]*database.APIKey{apiKey}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys?include_deleted=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	apiKeys := response["api_keys"].([]interface{})
+	assert.Contains(t, apiKeys[0], "deleted_at")
+
+	req, _ = http.NewRequest("GET", "/admin/api-keys?include_deleted=maybe", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_WithOwner(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{
+		Name:                   "Test API Key",
+		RateLimitRequests:      100,
+		RateLimitWindowSeconds: 3600,
+		OwnerName:              "Payments Team",
+		OwnerEmail:             "payments@example.com",
+	}).Return("ak_1234567890_abcdef", nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":        "Test API Key",
+		"owner_name":  "Payments Team",
+		"owner_email": "payments@example.com",
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Payments Team", response["owner_name"])
+	assert.Equal(t, "payments@example.com", response["owner_email"])
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_InvalidOwnerEmail(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"name":        "Test API Key",
+		"owner_email": "not-an-email",
+	})
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestUpdateAPIKeyOwner_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.OwnerName = "Search Team"
+	apiKey.OwnerEmail = "search@example.com"
+	mockAPIKeyService.On("UpdateAPIKeyOwner", mock.Anything, apiKey.ID, "Search Team", "search@example.com").Return(apiKey, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"owner_name":  "Search Team",
+		"owner_email": "search@example.com",
+	})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/"+apiKey.ID+"/owner", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestUpdateAPIKeyOwner_NotFound(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("UpdateAPIKeyOwner", mock.Anything, "ak_missing", "Search Team", "").Return(nil, services.ErrAPIKeyNotFound)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"owner_name": "Search Team"})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/ak_missing/owner", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateAPIKeyAlertThresholds_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.AlertThresholds = []int64{80, 100}
+	mockAPIKeyService.On("UpdateAPIKeyAlertThresholds", mock.Anything, apiKey.ID, []int64{80, 100}).Return(apiKey, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"thresholds": []int{100, 80}})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/"+apiKey.ID+"/alert-thresholds", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"alert_thresholds":[80,100]`)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestUpdateAPIKeyAlertThresholds_Invalid(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"thresholds": []int{80, 150}})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/test-id-123/alert-thresholds", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid alert threshold 150")
+	mockAPIKeyService.AssertNotCalled(t, "UpdateAPIKeyAlertThresholds", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateAPIKeyRefundLimit(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.RefundLimit = 10
+	mockAPIKeyService.On("UpdateAPIKeyRefundLimit", mock.Anything, apiKey.ID, 10).Return(apiKey, nil)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/admin/api-keys/"+apiKey.ID+"/refund-limit", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := update(`{"refund_limit": 10}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"refund_limit":10`)
+
+	for _, body := range []string{`{}`, `{"refund_limit": -1}`} {
+		assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+	}
+	mockAPIKeyService.AssertNumberOfCalls(t, "UpdateAPIKeyRefundLimit", 1)
+}
+
+func TestUpdateAPIKeyLimitResponse(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	apiKey := createTestAPIKey()
+	apiKey.LimitResponse = &database.LimitResponse{
+		Body:    `{"error": "Upgrade to raise your limit of {limit}"}`,
+		Headers: map[string]string{"Link": "<https://example.com/upgrade>; rel=\"upgrade\""},
+	}
+	mockAPIKeyService.On("UpdateAPIKeyLimitResponse", mock.Anything, apiKey.ID, apiKey.LimitResponse).Return(apiKey, nil)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/admin/api-keys/"+apiKey.ID+"/limit-response", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := update(`{"body": "{\"error\": \"Upgrade to raise your limit of {limit}\"}", "headers": {"link": "<https://example.com/upgrade>; rel=\"upgrade\""}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"limit_response"`)
+
+	for _, body := range []string{`[]`, `{"headers": {"Bad Header": "x"}}`} {
+		assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+	}
+	mockAPIKeyService.AssertNumberOfCalls(t, "UpdateAPIKeyLimitResponse", 1)
+}
+
+func TestAdminRoutes_RequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"viewer:view-token", "admin:admin-token"})
+	assert.NoError(t, err)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAdminCredentials(credentials))
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Limit: defaultListLimit + 1}).Return([]*database.APIKey{}, nil)
+	mockAPIKeyService.On("DeleteAPIKey", mock.Anything, "ak_test").Return(nil)
+
+	serve := func(method string, path string, token string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/admin/api-keys", ""))
+	assert.Equal(t, http.StatusOK, serve("GET", "/admin/api-keys", "view-token"))
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "/admin/api-keys/ak_test", "view-token"))
+	assert.Equal(t, http.StatusOK, serve("DELETE", "/admin/api-keys/ak_test", "admin-token"))
+	assert.Equal(t, http.StatusOK, serve("GET", "/health", ""))
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestSetupAPIRoutes_ExcludesAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{})
+	apiRouter := gin.New()
+	handler.SetupAPIRoutes(apiRouter)
+	adminRouter := gin.New()
+	handler.SetupAdminRoutes(adminRouter)
+
+	hasRoute := func(router *gin.Engine, method string, path string) bool {
+		for _, route := range router.Routes() {
+			if route.Method == method && route.Path == path {
+				return true
+			}
+		}
+		return false
+	}
+
+	assert.True(t, hasRoute(apiRouter, "GET", "/api/status"))
+	assert.True(t, hasRoute(apiRouter, "GET", "/v1/api/status"))
+	assert.False(t, hasRoute(apiRouter, "GET", "/admin/api-keys"))
+	assert.False(t, hasRoute(apiRouter, "GET", "/v1/admin/api-keys"))
+	assert.True(t, hasRoute(adminRouter, "GET", "/admin/api-keys"))
+	assert.True(t, hasRoute(adminRouter, "GET", "/v1/admin/api-keys"))
+	assert.False(t, hasRoute(adminRouter, "GET", "/api/status"))
+}
+
+func TestAPIMiddleware_OnlyAPIEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Limit: defaultListLimit + 1}).Return([]*database.APIKey{}, nil)
+	refuse := func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusTooManyRequests)
+	}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithAPIMiddleware(refuse))
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	serve := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, serve("/v1/api/status"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/api/status"))
+	// The admin API and health checks don't run it
+	assert.Equal(t, http.StatusOK, serve("/v1/admin/api-keys"))
+	assert.Equal(t, http.StatusOK, serve("/admin/api-keys"))
+	assert.Equal(t, http.StatusOK, serve("/health"))
+}
+
+func TestVersionedRoutes_LegacyPathsDeprecated(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Limit: defaultListLimit + 1}).Return([]*database.APIKey{}, nil)
+
+	req, _ := http.NewRequest("GET", "/v1/admin/api-keys", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	req, _ = http.NewRequest("GET", "/admin/api-keys", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/admin/api-keys>; rel="successor-version"`, w.Header().Get("Link"))
+	assert.Empty(t, w.Header().Get("Sunset"))
+}
+
+func TestVersionedRoutes_LegacySunsetAndDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sunset := time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithLegacyRoutes(true, sunset))
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "Sun, 31 Jan 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+
+	handler = NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithLegacyRoutes(false, time.Time{}))
+	router = gin.New()
+	handler.SetupRoutes(router)
+
+	req, _ = http.NewRequest("GET", "/admin/api-keys", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"grpc-firstls/internal/buildinfo"
+	"grpc-firstls/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessCheckTimeout bounds each dependency check so a hung dependency
+// fails the probe instead of stalling it
+const readinessCheckTimeout = 2 * time.Second
+
+// ReadinessCheck reports whether a dependency needed to serve traffic is
+// usable
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// WithReadinessCheck adds a dependency to the checks run by /readyz
+func WithReadinessCheck(name string, check func(ctx context.Context) error) Option {
+	return func(h *Handler) {
+		h.readinessChecks = append(h.readinessChecks, ReadinessCheck{Name: name, Check: check})
+	}
+}
+
+// SetDraining marks the instance as shutting down, so /readyz fails and load
+// balancers stop routing new traffic to it while in-flight requests finish
+func (h *Handler) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+// WithBuildInfo sets the build information served by /version; defaults to
+// buildinfo.Get()
+func WithBuildInfo(info buildinfo.Info) Option {
+	return func(h *Handler) {
+		h.buildInfo = info
+	}
+}
+
+// registerProbes registers the unversioned liveness, readiness, version and
+// metrics endpoints
+func (h *Handler) registerProbes(router gin.IRouter) {
+	router.GET("/livez", h.Livez)
+	router.GET("/readyz", h.Readyz)
+	router.GET("/version", h.Version)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+}
+
+// Livez reports that the process is up and serving HTTP. It checks no
+// dependencies, so an outage of Postgres or Redis doesn't get the instance
+// restarted.
+func (h *Handler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "alive",
+	})
+}
+
+// Readyz reports whether the instance should receive traffic: it is not
+// draining and every readiness check passes
+func (h *Handler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "draining",
+		})
+		return
+	}
+
+	ready := true
+	checks := gin.H{}
+	for _, check := range h.readinessChecks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessCheckTimeout)
+		err := check.Check(ctx)
+		cancel()
+		if err != nil {
+			ready = false
+			checks[check.Name] = err.Error()
+			continue
+		}
+		checks[check.Name] = "ok"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "not ready",
+			"checks": checks,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ready",
+		"checks": checks,
+	})
+}
+
+// Version reports which build is serving traffic
+func (h *Handler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, h.buildInfo)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/buildinfo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupProbeTestRouter(opts ...Option) (*gin.Engine, *Handler) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, opts...)
+	router := gin.New()
+	handler.SetupRoutes(router)
+	return router, handler
+}
+
+func getProbe(t *testing.T, router *gin.Engine, path string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+func TestLivez_IgnoresDependencies(t *testing.T) {
+	router, _ := setupProbeTestRouter(WithReadinessCheck("database", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}))
+
+	status, response := getProbe(t, router, "/livez")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "alive", response["status"])
+}
+
+func TestReadyz_ChecksDependencies(t *testing.T) {
+	redisErr := errors.New("connection refused")
+	router, _ := setupProbeTestRouter(
+		WithReadinessCheck("database", func(ctx context.Context) error { return nil }),
+		WithReadinessCheck("redis", func(ctx context.Context) error { return redisErr }),
+	)
+
+	status, response := getProbe(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "not ready", response["status"])
+	assert.Equal(t, map[string]interface{}{"database": "ok", "redis": "connection refused"}, response["checks"])
+
+	redisErr = nil
+	status, response = getProbe(t, router, "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ready", response["status"])
+}
+
+func TestReadyz_Draining(t *testing.T) {
+	router, handler := setupProbeTestRouter()
+
+	status, _ := getProbe(t, router, "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+
+	handler.SetDraining(true)
+	status, response := getProbe(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "draining", response["status"])
+
+	// Liveness is unaffected, so the instance isn't restarted mid-drain
+	status, _ = getProbe(t, router, "/livez")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestVersion(t *testing.T) {
+	router, _ := setupProbeTestRouter(WithBuildInfo(buildinfo.Info{
+		Version:   "v1.2.3",
+		Commit:    "abc123",
+		BuildTime: "2025-01-01T00:00:00Z",
+		GoVersion: "go1.19",
+		Features:  []string{"oidc"},
+	}))
+
+	status, response := getProbe(t, router, "/version")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "abc123", response["commit"])
+	assert.Equal(t, "2025-01-01T00:00:00Z", response["build_time"])
+	assert.Equal(t, "go1.19", response["go_version"])
+	assert.Equal(t, []interface{}{"oidc"}, response["features"])
+}
+
+func TestMetrics(t *testing.T) {
+	router, _ := setupProbeTestRouter()
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "ratelimiter_http_panics_recovered_total")
+}
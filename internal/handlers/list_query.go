@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listQuery is the paging, ordering and filtering requested from an admin
+// list endpoint, e.g.
+//
+//	?limit=20&sort=-created_at,name&is_active=true&created_at[gte]=2024-01-01
+//
+// Fields are the JSON names of the listed items' scalar fields. Filters are
+// field=value for equality or field[op]=value with op one of eq, ne, lt,
+// lte, gt, gte, contains (case-insensitive substring) and in (comma
+// separated values). The cursor of the next page is returned as next_cursor
+// and is only valid for the same sort and filters.
+type listQuery struct {
+	limit   int
+	sort    services.ListOrder
+	filters []services.FieldFilter
+
+	// Where the page starts: an offset for lists paged in memory, or the
+	// position of the last key of the previous page for keys, which the
+	// database pages itself
+	offset int
+	after  *services.KeyPosition
+}
+
+// listField is a scalar struct field items can be sorted and filtered by
+type listField struct {
+	name string
+	kind fieldKind
+}
+
+// fieldKind is the type field values are normalised to for comparisons
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	intField
+	boolField
+	timeField
+)
+
+var (
+	filterParamPattern = regexp.MustCompile(`^([a-z0-9_]+)\[([a-z]+)\]$`)
+	timeType           = reflect.TypeOf(time.Time{})
+)
+
+var filterOperators = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true, "contains": true, "in": true,
+}
+
+// Parameters that are never filters
+var listParams = map[string]bool{"limit": true, "cursor": true, "sort": true}
+
+// parseListQuery reads the list parameters for items of type T, which is a
+// struct or a pointer to one, paged in memory by applyListQuery. Query
+// parameters that name neither a list parameter nor a field are left for the
+// endpoint.
+func parseListQuery[T any](c *gin.Context) (listQuery, error) {
+	return parseListParams[T](c, false)
+}
+
+// parseKeyListQuery is parseListQuery for keys, paged by the database with
+// listKeyPage
+func parseKeyListQuery(c *gin.Context) (listQuery, error) {
+	return parseListParams[*database.APIKey](c, true)
+}
+
+func parseListParams[T any](c *gin.Context, keyset bool) (listQuery, error) {
+	fields := listFields(reflect.TypeOf((*T)(nil)).Elem())
+	query := c.Request.URL.Query()
+	q := listQuery{limit: defaultListLimit}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = limit
+	}
+
+	var sortKinds []fieldKind
+	if value := query.Get("sort"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			desc := strings.HasPrefix(name, "-")
+			field, ok := fields[strings.TrimPrefix(name, "-")]
+			if !ok {
+				return q, fmt.Errorf("cannot sort by %q", strings.TrimPrefix(name, "-"))
+			}
+			q.sort = append(q.sort, services.SortField{Field: field.name, Desc: desc})
+			sortKinds = append(sortKinds, field.kind)
+		}
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		var err error
+		if keyset {
+			q.after, err = decodeKeyCursor(value, sortKinds)
+		} else {
+			q.offset, err = decodeCursor(value)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor")
+		}
+	}
+
+	filters, err := parseFilters(query, fields)
+	if err != nil {
+		return q, err
+	}
+	q.filters = filters
+	return q, nil
+}
+
+func parseFilters(query url.Values, fields map[string]*listField) ([]services.FieldFilter, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	// Sorted so the first invalid filter reported doesn't vary
+	sort.Strings(names)
+
+	var filters []services.FieldFilter
+	for _, name := range names {
+		if listParams[name] {
+			continue
+		}
+
+		fieldName, op := name, "eq"
+		if match := filterParamPattern.FindStringSubmatch(name); match != nil {
+			fieldName, op = match[1], match[2]
+			if _, ok := fields[fieldName]; !ok {
+				return nil, fmt.Errorf("cannot filter by %q", fieldName)
+			}
+			if !filterOperators[op] {
+				return nil, fmt.Errorf("unknown filter operator %q", op)
+			}
+		}
+		field, ok := fields[fieldName]
+		if !ok {
+			continue
+		}
+
+		for _, raw := range query[name] {
+			filter, err := newListFilter(field, op, raw)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+func newListFilter(field *listField, op, raw string) (services.FieldFilter, error) {
+	filter := services.FieldFilter{Field: field.name, Op: op}
+
+	switch {
+	case op == "contains" && field.kind != stringField:
+		return filter, fmt.Errorf("%s does not support contains", field.name)
+	case field.kind == boolField && op != "eq" && op != "ne" && op != "in":
+		return filter, fmt.Errorf("%s does not support %s", field.name, op)
+	}
+
+	raws := []string{raw}
+	if op == "in" {
+		raws = strings.Split(raw, ",")
+	}
+	for _, raw := range raws {
+		value, err := parseFieldValue(field.kind, raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid value %q for %s", raw, field.name)
+		}
+		filter.Values = append(filter.Values, value)
+	}
+	return filter, nil
+}
+
+func parseFieldValue(kind fieldKind, raw string) (interface{}, error) {
+	switch kind {
+	case intField:
+		return strconv.ParseInt(raw, 10, 64)
+	case boolField:
+		return strconv.ParseBool(raw)
+	case timeField:
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", raw)
+	}
+	return raw, nil
+}
+
+// listFields returns t's sortable and filterable fields by JSON name
+func listFields(t reflect.Type) map[string]*listField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	fields := map[string]*listField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || name == "" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		var kind fieldKind
+		switch {
+		case fieldType == timeType:
+			kind = timeField
+		case fieldType.Kind() == reflect.String:
+			kind = stringField
+		case fieldType.Kind() == reflect.Bool:
+			kind = boolField
+		case fieldType.Kind() >= reflect.Int && fieldType.Kind() <= reflect.Int64:
+			kind = intField
+		default:
+			continue
+		}
+		fields[name] = &listField{name: name, kind: kind}
+	}
+	return fields
+}
+
+// applyListQuery filters, sorts and pages items, returning the page and the
+// cursor of the next one, or "" on the last page. Items keep their order for
+// equal sort keys, so endpoints pass them in their default order.
+func applyListQuery[T any](items []T, q listQuery) ([]T, string) {
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		keep := true
+		for _, filter := range q.filters {
+			if !filter.Matches(item) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			matched = append(matched, item)
+		}
+	}
+
+	if len(q.sort) > 0 {
+		values := make([][]interface{}, len(matched))
+		order := make([]int, len(matched))
+		for i, item := range matched {
+			values[i] = q.sort.Values(item)
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return q.sort.Compare(values[order[i]], values[order[j]]) < 0
+		})
+		sorted := make([]T, len(matched))
+		for i, index := range order {
+			sorted[i] = matched[index]
+		}
+		matched = sorted
+	}
+
+	if q.offset >= len(matched) {
+		return matched[:0], ""
+	}
+	page := matched[q.offset:]
+	if len(page) <= q.limit {
+		return page, ""
+	}
+	return page[:q.limit], encodeCursor(q.offset + q.limit)
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, nil
+}
+
+// keyCursor is the position of the last key of a page, with its sort
+// values in their query parameter form
+type keyCursor struct {
+	Values    []*string `json:"v,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+func encodeKeyCursor(position services.KeyPosition) string {
+	cursor := keyCursor{Values: make([]*string, len(position.Values)), CreatedAt: position.CreatedAt, ID: position.ID}
+	for i, value := range position.Values {
+		if value != nil {
+			formatted := formatFieldValue(value)
+			cursor.Values[i] = &formatted
+		}
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeKeyCursor reads a cursor for keys sorted by fields of kinds
+func decodeKeyCursor(value string, kinds []fieldKind) (*services.KeyPosition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor keyCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID == "" || len(cursor.Values) != len(kinds) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	position := &services.KeyPosition{Values: make([]interface{}, len(kinds)), CreatedAt: cursor.CreatedAt, ID: cursor.ID}
+	for i, raw := range cursor.Values {
+		if raw == nil {
+			continue
+		}
+		if position.Values[i], err = parseFieldValue(kinds[i], *raw); err != nil {
+			return nil, err
+		}
+	}
+	return position, nil
+}
+
+// formatFieldValue is the inverse of parseFieldValue
+func formatFieldValue(value interface{}) string {
+	switch value := value.(type) {
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case string:
+		return value
+	}
+	return fmt.Sprint(value)
+}
+
+// listResponse writes a page of items under field, with next_cursor when
+// there are more
+func listResponse(c *gin.Context, field string, page interface{}, nextCursor string) {
+	body := gin.H{field: page}
+	if nextCursor != "" {
+		body["next_cursor"] = nextCursor
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func invalidListQuery(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+		"error":   "Invalid request",
+		"message": err.Error(),
+	}))
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func listQueryFromURL(t *testing.T, rawQuery string) (listQuery, error) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/?"+rawQuery, nil)
+	return parseListQuery[*database.APIKey](c)
+}
+
+func listTestKeys() []*database.APIKey {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := base.Add(48 * time.Hour)
+	return []*database.APIKey{
+		{ID: "a", Name: "Payments", IsActive: true, RateLimitRequests: 100, CreatedAt: base},
+		{ID: "b", Name: "Search", IsActive: false, RateLimitRequests: 50, CreatedAt: base.Add(time.Hour), ExpiresAt: &expires},
+		{ID: "c", Name: "payments-batch", IsActive: true, RateLimitRequests: 500, CreatedAt: base.Add(2 * time.Hour)},
+	}
+}
+
+func keyIDs(keys []*database.APIKey) []string {
+	ids := []string{}
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func TestParseListQuery_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"limit=0":                         "limit must be between",
+		"limit=5000":                      "limit must be between",
+		"cursor=not-a-cursor":             "invalid cursor",
+		"sort=key_hash":                   `cannot sort by "key_hash"`,
+		"allowed_cidrs[eq]=x":             `cannot filter by "allowed_cidrs"`,
+		"name[like]=x":                    `unknown filter operator "like"`,
+		"is_active[gt]=true":              "is_active does not support gt",
+		"rate_limit_requests[contains]=1": "rate_limit_requests does not support contains",
+		"created_at[gte]=yesterday":       `invalid value "yesterday" for created_at`,
+	}
+
+	for rawQuery, message := range tests {
+		_, err := listQueryFromURL(t, rawQuery)
+		if assert.Error(t, err, rawQuery) {
+			assert.Contains(t, err.Error(), message, rawQuery)
+		}
+	}
+}
+
+func TestApplyListQuery_Filters(t *testing.T) {
+	tests := map[string][]string{
+		"":                                    {"a", "b", "c"},
+		"is_active=true":                      {"a", "c"},
+		"name[contains]=PAY":                  {"a", "c"},
+		"rate_limit_requests[gte]=100":        {"a", "c"},
+		"rate_limit_requests[in]=50,500":      {"b", "c"},
+		"created_at[gt]=2025-01-01T00:30:00Z": {"b", "c"},
+		"expires_at[lt]=2025-01-05":           {"b"},
+		"expires_at[ne]=2025-01-03T00:00:00Z": {"a", "c"},
+		"is_active=true&name[ne]=Payments":    {"c"},
+		"owner=someone&unrelated=1":           {"a", "b", "c"},
+	}
+
+	for rawQuery, expected := range tests {
+		query, err := listQueryFromURL(t, rawQuery)
+		require.NoError(t, err, rawQuery)
+
+		page, nextCursor := applyListQuery(listTestKeys(), query)
+		assert.Equal(t, expected, keyIDs(page), rawQuery)
+		assert.Empty(t, nextCursor, rawQuery)
+	}
+}
+
+func TestApplyListQuery_Sort(t *testing.T) {
+	tests := map[string][]string{
+		"sort=name":                   {"a", "b", "c"},
+		"sort=-rate_limit_requests":   {"c", "a", "b"},
+		"sort=-is_active,-created_at": {"c", "a", "b"},
+		"sort=expires_at":             {"b", "a", "c"},
+		"sort=-expires_at":            {"b", "a", "c"},
+	}
+
+	for rawQuery, expected := range tests {
+		query, err := listQueryFromURL(t, rawQuery)
+		require.NoError(t, err, rawQuery)
+
+		page, _ := applyListQuery(listTestKeys(), query)
+		assert.Equal(t, expected, keyIDs(page), rawQuery)
+	}
+}
+
+func TestApplyListQuery_Pages(t *testing.T) {
+	query, err := listQueryFromURL(t, "limit=2&sort=-created_at")
+	require.NoError(t, err)
+
+	page, nextCursor := applyListQuery(listTestKeys(), query)
+	assert.Equal(t, []string{"c", "b"}, keyIDs(page))
+	require.NotEmpty(t, nextCursor)
+
+	query, err = listQueryFromURL(t, "limit=2&sort=-created_at&cursor="+nextCursor)
+	require.NoError(t, err)
+
+	page, nextCursor = applyListQuery(listTestKeys(), query)
+	assert.Equal(t, []string{"a"}, keyIDs(page))
+	assert.Empty(t, nextCursor)
+}
+
+func TestListAPIKeys_Paginated(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	keys := listTestKeys()
+	active := []services.FieldFilter{{Field: "is_active", Op: "eq", Values: []interface{}{true}}}
+	sortByName := services.ListOrder{{Field: "name"}}
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Filters: active, Sort: sortByName, Limit: 2}).Return([]*database.APIKey{keys[0], keys[2]}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys?limit=1&is_active=true&sort=name", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	apiKeys := response["api_keys"].([]interface{})
+	require.Len(t, apiKeys, 1)
+	assert.Equal(t, "a", apiKeys[0].(map[string]interface{})["id"])
+
+	// The next page starts after the last key of this one
+	after := &services.KeyPosition{Values: []interface{}{"Payments"}, CreatedAt: keys[0].CreatedAt, ID: "a"}
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{Filters: active, Sort: sortByName, After: after, Limit: 2}).Return([]*database.APIKey{keys[2]}, nil)
+
+	req, _ = http.NewRequest("GET", "/admin/api-keys?limit=1&is_active=true&sort=name&cursor="+response["next_cursor"].(string), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	response = map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	apiKeys = response["api_keys"].([]interface{})
+	require.Len(t, apiKeys, 1)
+	assert.Equal(t, "c", apiKeys[0].(map[string]interface{})["id"])
+	assert.NotContains(t, response, "next_cursor")
+
+	// Offsets of lists paged in memory aren't key cursors
+	req, _ = http.NewRequest("GET", "/admin/api-keys?cursor="+encodeCursor(1), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListAPIKeys_InvalidListQueryFromRepository(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("failed to list API keys: %w", services.ErrInvalidListQuery))
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys?sort=quota_requests", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListAPIKeys_InvalidListQuery(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys?sort=nope", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "ListAPIKeys", mock.Anything, services.APIKeyFilter{})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/live"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLiveEventsHeartbeat is how often an idle event stream sends a
+// heartbeat unless configured otherwise
+const DefaultLiveEventsHeartbeat = 15 * time.Second
+
+// WithLiveEvents enables the stream of live rate limit decisions and key
+// events published to hub. Idle streams send a heartbeat every heartbeat,
+// so proxies don't time them out and clients notice a dead connection.
+func WithLiveEvents(hub *live.Hub, heartbeat time.Duration) Option {
+	return func(h *Handler) {
+		h.liveEvents = hub
+		h.liveEventsHeartbeat = DefaultLiveEventsHeartbeat
+		if heartbeat > 0 {
+			h.liveEventsHeartbeat = heartbeat
+		}
+	}
+}
+
+// StreamEvents streams events as server-sent events until the client goes
+// away or the server shuts down. The api_key_id and type query parameters,
+// repeated or comma separated, limit the stream to those keys and event
+// types.
+func (h *Handler) StreamEvents(c *gin.Context) {
+	sub := h.liveEvents.Subscribe(live.Filter{
+		APIKeyIDs: queryValues(c, "api_key_id"),
+		Types:     queryValues(c, "type"),
+	})
+	defer sub.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(h.liveEventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			err = writeServerSentEvent(c.Writer, event)
+		case <-heartbeat.C:
+			_, err = io.WriteString(c.Writer, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeServerSentEvent writes event as one SSE message named after its type
+func writeServerSentEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// queryValues returns the values of a query parameter that may be repeated
+// or hold a comma separated list
+func queryValues(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(name) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
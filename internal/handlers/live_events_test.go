@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/live"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openEventStream connects to the live events stream and returns its lines
+func openEventStream(t *testing.T, hub *live.Hub, query string) (*http.Response, *bufio.Scanner) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithLiveEvents(hub, 20*time.Millisecond)).SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/v1/admin/events/stream"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewScanner(resp.Body)
+}
+
+// nextEvent skips heartbeats and returns the next event's lines
+func nextEvent(t *testing.T, lines *bufio.Scanner) []string {
+	var event []string
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, ":"):
+		case line == "" && len(event) > 0:
+			return event
+		case line != "":
+			event = append(event, line)
+		}
+	}
+	t.Fatalf("stream ended: %v", lines.Err())
+	return nil
+}
+
+func TestStreamEvents(t *testing.T) {
+	hub := live.NewHub(10)
+	resp, lines := openEventStream(t, hub, "?api_key_id=key-1,key-2&type=request.decided&type=api_key.rotated")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	hub.Log(database.UsageLog{APIKeyID: "key-3", Method: "GET", Route: "/api/test", StatusCode: 200, Decision: "allowed"})
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyCreated, APIKeyID: "key-1"})
+	hub.Log(database.UsageLog{APIKeyID: "key-2", Method: "GET", Route: "/api/test", StatusCode: 429, Decision: "limited", Latency: 1500 * time.Microsecond})
+	hub.Publish(context.Background(), events.Event{ID: "evt-1", Type: events.APIKeyRotated, APIKeyID: "key-1"})
+
+	event := nextEvent(t, lines)
+	require.Len(t, event, 2, "other keys and types are filtered out")
+	assert.Equal(t, "event: request.decided", event[0])
+	assert.Contains(t, event[1], `"api_key_id":"key-2"`)
+	assert.Contains(t, event[1], `"decision":"limited"`)
+	assert.Contains(t, event[1], `"latency_ms":1.5`)
+
+	event = nextEvent(t, lines)
+	assert.Equal(t, []string{"id: evt-1", "event: api_key.rotated"}, event[:2])
+}
+
+func TestStreamEvents_HeartbeatsAndEndsWithHub(t *testing.T) {
+	hub := live.NewHub(10)
+	_, lines := openEventStream(t, hub, "")
+
+	require.True(t, lines.Scan())
+	assert.Equal(t, ": heartbeat", lines.Text())
+
+	hub.Close()
+	for lines.Scan() {
+	}
+	assert.NoError(t, lines.Err(), "the stream ends cleanly")
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/alerting"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/live"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultLiveCounterInterval is how often live counters are sent to the
+// socket clients following them unless configured otherwise
+const DefaultLiveCounterInterval = time.Second
+
+// Channels a live socket client can subscribe to
+const (
+	// Rate limit decisions and key events, as on the event stream
+	channelEvents = "events"
+	// Alerts raised and resolved by the alerting monitor
+	channelAlerts = "alerts"
+	// The rate limit counters of chosen keys, sent on every interval
+	channelCounters = "counters"
+)
+
+const (
+	// Most keys a client can follow the counters of
+	maxSocketCounterKeys = 50
+	// Largest message a client may send
+	maxSocketMessageSize = 4096
+	// Messages waiting to be written before the event subscriptions stop
+	// being read and, once their buffers are full, drop events
+	socketSendBuffer   = 16
+	socketWriteTimeout = 10 * time.Second
+)
+
+// WithLiveCounterInterval sets how often the live socket sends the counters
+// of the keys its clients follow
+func WithLiveCounterInterval(interval time.Duration) Option {
+	return func(h *Handler) {
+		if interval > 0 {
+			h.liveCounterInterval = interval
+		}
+	}
+}
+
+// The admin API authenticates with the Authorization header, which browsers
+// don't send on their own, so any page may open a socket
+var liveSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// socketRequest is a message from a live socket client
+type socketRequest struct {
+	// subscribe or unsubscribe
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+	// Limits events and alerts to these keys; required for counters
+	APIKeyIDs []string `json:"api_key_ids"`
+	// Limits events to these types
+	Types []string `json:"types"`
+}
+
+// socketMessage is a message to a live socket client. Type is subscribed,
+// unsubscribed or error in reply to a request, and event or counters for
+// what the client follows.
+type socketMessage struct {
+	Type      string           `json:"type"`
+	Channel   string           `json:"channel,omitempty"`
+	APIKeyIDs []string         `json:"api_key_ids,omitempty"`
+	Event     *events.Event    `json:"event,omitempty"`
+	Counters  []socketCounters `json:"counters,omitempty"`
+	Message   string           `json:"message,omitempty"`
+}
+
+// socketCounters is the rate limit status of one followed key
+type socketCounters struct {
+	APIKeyID  string    `json:"api_key_id"`
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	ResetTime time.Time `json:"reset_time"`
+	Penalized bool      `json:"penalized"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// liveSocket serves one admin client of the live socket: it follows the
+// channels the client subscribes to and writes what they deliver. Only the
+// write goroutine writes to the connection.
+type liveSocket struct {
+	h    *Handler
+	conn *websocket.Conn
+	send chan socketMessage
+
+	mu            sync.Mutex
+	subscriptions map[string]*live.Subscription
+	counterKeys   []*database.APIKey
+}
+
+// LiveSocket upgrades the request to a WebSocket over which the client
+// subscribes to live events, alerts and key counters, and unsubscribes from
+// them, while the connection lasts. It ends when the server shuts down.
+func (h *Handler) LiveSocket(c *gin.Context) {
+	conn, err := liveSocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered with an error
+		return
+	}
+	socket := &liveSocket{
+		h:             h,
+		conn:          conn,
+		send:          make(chan socketMessage, socketSendBuffer),
+		subscriptions: map[string]*live.Subscription{},
+	}
+	socket.run(c.Request.Context())
+}
+
+// run serves the connection until either side closes it
+func (s *liveSocket) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer s.unsubscribeAll()
+
+	go func() {
+		// Closing the connection ends the read loop below
+		defer s.conn.Close()
+		s.write(ctx)
+	}()
+	go s.sendCounters(ctx)
+	s.read(ctx)
+}
+
+// read handles the client's requests until the connection fails or the
+// client misses two heartbeats
+func (s *liveSocket) read(ctx context.Context) {
+	timeout := 2 * s.h.liveEventsHeartbeat
+	s.conn.SetReadLimit(maxSocketMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(timeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(timeout))
+
+		var request socketRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			s.reply(ctx, socketMessage{Type: "error", Message: "Invalid message: " + err.Error()})
+			continue
+		}
+		s.reply(ctx, s.handle(ctx, request))
+	}
+}
+
+// write sends the queued messages and a ping every heartbeat until ctx is
+// cancelled, a write fails or the hub closes
+func (s *liveSocket) write(ctx context.Context) {
+	heartbeat := time.NewTicker(s.h.liveEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.h.liveEvents.Done():
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			s.conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(socketWriteTimeout))
+			return
+		case message := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			err = s.conn.WriteJSON(message)
+		case <-heartbeat.C:
+			err = s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// reply queues message unless the connection is closing
+func (s *liveSocket) reply(ctx context.Context, message socketMessage) {
+	select {
+	case s.send <- message:
+	case <-ctx.Done():
+	}
+}
+
+// handle applies a subscribe or unsubscribe request and returns the reply
+func (s *liveSocket) handle(ctx context.Context, request socketRequest) socketMessage {
+	failed := func(format string, args ...interface{}) socketMessage {
+		return socketMessage{Type: "error", Channel: request.Channel, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch request.Channel {
+	case channelEvents, channelAlerts, channelCounters:
+	default:
+		return failed("Unknown channel %q: expected events, alerts or counters", request.Channel)
+	}
+
+	switch request.Action {
+	case "subscribe":
+	case "unsubscribe":
+		s.unsubscribe(request.Channel)
+		return socketMessage{Type: "unsubscribed", Channel: request.Channel}
+	default:
+		return failed("Unknown action %q: expected subscribe or unsubscribe", request.Action)
+	}
+
+	if request.Channel == channelCounters {
+		keys, err := s.resolveKeys(ctx, request.APIKeyIDs)
+		if err != nil {
+			return failed("%s", err)
+		}
+		s.mu.Lock()
+		s.counterKeys = keys
+		s.mu.Unlock()
+		ids := make([]string, len(keys))
+		for i, key := range keys {
+			ids[i] = key.ID
+		}
+		return socketMessage{Type: "subscribed", Channel: request.Channel, APIKeyIDs: ids}
+	}
+
+	filter := live.Filter{APIKeyIDs: request.APIKeyIDs, Types: request.Types}
+	if request.Channel == channelAlerts {
+		filter.Types = []string{alerting.AlertTriggered, alerting.AlertResolved}
+	}
+	s.subscribe(ctx, request.Channel, filter)
+	return socketMessage{Type: "subscribed", Channel: request.Channel, APIKeyIDs: request.APIKeyIDs}
+}
+
+// subscribe follows the hub's events matching filter on channel, replacing
+// what the channel followed before
+func (s *liveSocket) subscribe(ctx context.Context, channel string, filter live.Filter) {
+	sub := s.h.liveEvents.Subscribe(filter)
+	s.mu.Lock()
+	previous := s.subscriptions[channel]
+	s.subscriptions[channel] = sub
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+
+	go func() {
+		for event := range sub.Events() {
+			event := event
+			s.reply(ctx, socketMessage{Type: "event", Channel: channel, Event: &event})
+		}
+	}()
+}
+
+func (s *liveSocket) unsubscribe(channel string) {
+	s.mu.Lock()
+	sub := s.subscriptions[channel]
+	delete(s.subscriptions, channel)
+	if channel == channelCounters {
+		s.counterKeys = nil
+	}
+	s.mu.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+func (s *liveSocket) unsubscribeAll() {
+	for _, channel := range []string{channelEvents, channelAlerts, channelCounters} {
+		s.unsubscribe(channel)
+	}
+}
+
+// resolveKeys looks up the keys whose counters a client wants to follow
+func (s *liveSocket) resolveKeys(ctx context.Context, ids []string) ([]*database.APIKey, error) {
+	if len(ids) == 0 || len(ids) > maxSocketCounterKeys {
+		return nil, fmt.Errorf("counters need between 1 and %d api_key_ids, got %d", maxSocketCounterKeys, len(ids))
+	}
+	keys := make([]*database.APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.h.apiKeyService.GetAPIKey(ctx, id)
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return nil, fmt.Errorf("API key %s not found", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API key %s: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sendCounters sends the counters of the followed keys on every interval
+// until ctx is cancelled
+func (s *liveSocket) sendCounters(ctx context.Context) {
+	ticker := time.NewTicker(s.h.liveCounterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		keys := s.counterKeys
+		s.mu.Unlock()
+		if len(keys) == 0 {
+			continue
+		}
+
+		counters := make([]socketCounters, 0, len(keys))
+		for _, key := range keys {
+			status, err := s.h.rateLimitService.GetRateLimitStatus(ctx, key)
+			if err != nil {
+				counters = append(counters, socketCounters{APIKeyID: key.ID, Error: err.Error()})
+				continue
+			}
+			counters = append(counters, socketCounters{
+				APIKeyID:  key.ID,
+				Limit:     status.Limit,
+				Remaining: status.Remaining,
+				ResetTime: status.ResetTime,
+				Penalized: status.Penalized(),
+			})
+		}
+		s.reply(ctx, socketMessage{Type: "counters", Channel: channelCounters, Counters: counters})
+	}
+}
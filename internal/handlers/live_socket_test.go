@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/alerting"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/live"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// openLiveSocket connects to the live socket of a handler using hub and the
+// given services
+func openLiveSocket(t *testing.T, hub *live.Hub, apiKeyService *MockAPIKeyService, rateLimitService *MockRateLimitService) *websocket.Conn {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	NewHandler(apiKeyService, rateLimitService,
+		WithLiveEvents(hub, time.Minute),
+		WithLiveCounterInterval(10*time.Millisecond),
+	).SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/admin/events/socket", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// askSocket sends a request and returns the reply
+func askSocket(t *testing.T, conn *websocket.Conn, request socketRequest) socketMessage {
+	require.NoError(t, conn.WriteJSON(request))
+	var reply socketMessage
+	require.NoError(t, conn.ReadJSON(&reply))
+	return reply
+}
+
+func TestLiveSocket_Events(t *testing.T) {
+	hub := live.NewHub(10)
+	conn := openLiveSocket(t, hub, &MockAPIKeyService{}, &MockRateLimitService{})
+
+	reply := askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "events", APIKeyIDs: []string{"key-1"}, Types: []string{events.APIKeyRotated}})
+	assert.Equal(t, "subscribed", reply.Type)
+	reply = askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "alerts"})
+	assert.Equal(t, "subscribed", reply.Type)
+
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyCreated, APIKeyID: "key-1"})
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyRotated, APIKeyID: "key-1"})
+	hub.Publish(context.Background(), events.Event{Type: alerting.AlertTriggered, Data: map[string]interface{}{"key": alerting.RedisAlert}})
+
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		var message socketMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		require.Equal(t, "event", message.Type)
+		received[message.Channel] = message.Event.Type
+	}
+	assert.Equal(t, map[string]string{"events": events.APIKeyRotated, "alerts": alerting.AlertTriggered}, received,
+		"other types are filtered out")
+
+	reply = askSocket(t, conn, socketRequest{Action: "unsubscribe", Channel: "events"})
+	assert.Equal(t, "unsubscribed", reply.Type)
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyRotated, APIKeyID: "key-1"})
+	reply = askSocket(t, conn, socketRequest{Action: "listen", Channel: "events"})
+	assert.Equal(t, "error", reply.Type, "nothing is sent after unsubscribing")
+	assert.Contains(t, reply.Message, `Unknown action "listen"`)
+
+	reply = askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "logs"})
+	assert.Equal(t, "error", reply.Type)
+}
+
+func TestLiveSocket_Counters(t *testing.T) {
+	apiKeyService := &MockAPIKeyService{}
+	apiKey := &database.APIKey{ID: "key-1"}
+	apiKeyService.On("GetAPIKey", mock.Anything, "key-1").Return(apiKey, nil)
+	apiKeyService.On("GetAPIKey", mock.Anything, "missing").Return(nil, services.ErrAPIKeyNotFound)
+	rateLimitService := &MockRateLimitService{}
+	rateLimitService.On("GetRateLimitStatus", mock.Anything, apiKey).Return(&services.RateLimitResult{Limit: 100, Remaining: 42}, nil)
+	conn := openLiveSocket(t, live.NewHub(10), apiKeyService, rateLimitService)
+
+	reply := askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "counters", APIKeyIDs: []string{"missing"}})
+	assert.Equal(t, "error", reply.Type)
+	assert.Contains(t, reply.Message, "API key missing not found")
+	reply = askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "counters"})
+	assert.Equal(t, "error", reply.Type, "counters need keys")
+
+	// The first counters may come before the reply
+	require.NoError(t, conn.WriteJSON(socketRequest{Action: "subscribe", Channel: "counters", APIKeyIDs: []string{"key-1"}}))
+	received := map[string]socketMessage{}
+	for len(received) < 2 {
+		var message socketMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		received[message.Type] = message
+	}
+	assert.Equal(t, []string{"key-1"}, received["subscribed"].APIKeyIDs)
+	require.Len(t, received["counters"].Counters, 1)
+	assert.Equal(t, socketCounters{APIKeyID: "key-1", Limit: 100, Remaining: 42}, received["counters"].Counters[0])
+}
+
+func TestLiveSocket_EndsWithHub(t *testing.T) {
+	hub := live.NewHub(10)
+	conn := openLiveSocket(t, hub, &MockAPIKeyService{}, &MockRateLimitService{})
+	askSocket(t, conn, socketRequest{Action: "subscribe", Channel: "events"})
+
+	hub.Close()
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+	// Seconds clients are told to wait; the current value is kept if unset
+	RetryAfter *int   `json:"retry_after" binding:"omitempty,min=0"`
+	Message    string `json:"message" binding:"max=500"`
+}
+
+type maintenanceResponse struct {
+	Enabled    bool       `json:"enabled"`
+	RetryAfter int        `json:"retry_after"`
+	Message    string     `json:"message,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// WithMaintenance enables the maintenance endpoints, which switch mode
+func WithMaintenance(mode *middleware.MaintenanceMode) Option {
+	return func(h *Handler) {
+		h.maintenance = mode
+	}
+}
+
+func (h *Handler) GetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"maintenance": newMaintenanceResponse(h.maintenance.State())})
+}
+
+// SetMaintenance puts the public API into maintenance or takes it out
+func (h *Handler) SetMaintenance(c *gin.Context) {
+	var request maintenanceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	state := h.maintenance.State()
+	state.Enabled = *request.Enabled
+	state.Message = request.Message
+	if request.RetryAfter != nil {
+		state.RetryAfter = time.Duration(*request.RetryAfter) * time.Second
+	}
+	h.maintenance.Set(state)
+
+	c.JSON(http.StatusOK, gin.H{"maintenance": newMaintenanceResponse(h.maintenance.State())})
+}
+
+func newMaintenanceResponse(state middleware.MaintenanceState) maintenanceResponse {
+	response := maintenanceResponse{
+		Enabled:    state.Enabled,
+		RetryAfter: state.RetryAfterSeconds(),
+		Message:    state.Message,
+	}
+	if !state.Since.IsZero() {
+		response.Since = &state.Since
+	}
+	return response
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveMaintenance(t *testing.T, mode *middleware.MaintenanceMode, method, body, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"viewer:viewer-token", "admin:admin-token"})
+	require.NoError(t, err)
+
+	router := gin.New()
+	NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithAdminCredentials(credentials), WithMaintenance(mode)).SetupRoutes(router)
+
+	req, _ := http.NewRequest(method, "/v1/admin/maintenance", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMaintenanceEndpoints(t *testing.T) {
+	mode := middleware.NewMaintenanceMode(middleware.MaintenanceState{RetryAfter: 5 * time.Minute})
+
+	w := serveMaintenance(t, mode, "GET", "", "viewer-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"maintenance":{"enabled":false,"retry_after":300}}`, w.Body.String())
+
+	w = serveMaintenance(t, mode, "POST", `{"enabled":true}`, "viewer-token")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, mode.State().Enabled)
+
+	w = serveMaintenance(t, mode, "POST", `{"enabled":true,"message":"Migrating the database"}`, "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"since":`)
+	state := mode.State()
+	assert.True(t, state.Enabled)
+	assert.Equal(t, 5*time.Minute, state.RetryAfter)
+	assert.Equal(t, "Migrating the database", state.Message)
+
+	w = serveMaintenance(t, mode, "POST", `{"enabled":false,"retry_after":60}`, "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"maintenance":{"enabled":false,"retry_after":60}}`, w.Body.String())
+
+	w = serveMaintenance(t, mode, "POST", `{"retry_after":60}`, "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
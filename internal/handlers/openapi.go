@@ -0,0 +1,599 @@
+package handlers
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the Swagger UI assets vendored by
+// scripts/vendor-swagger-ui.sh, so /docs loads no code from a CDN
+//
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+// schema is an OpenAPI schema object
+type schema map[string]interface{}
+
+// apiOperation documents one endpoint. Request and response bodies are
+// described by the same types the handlers bind and return, so the document
+// follows changes to them; TestOpenAPI_CoversRoutes checks that every
+// registered route is listed here.
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	tag     string
+
+	// Minimum admin role, or 0 for the rate limited API
+	role middleware.Role
+
+	params       []schema
+	request      interface{}
+	optionalBody bool
+	status       int
+	response     schema
+	// Media types of the request and response, application/json if empty
+	requestContentType string
+	contentType        string
+
+	// The endpoint authenticates callers from the request body
+	public bool
+
+	// The endpoint accepts an Idempotency-Key header
+	idempotent bool
+
+	// The response is a file download, which is sent without an ETag
+	download bool
+}
+
+// componentTypes are described once under components/schemas and referenced
+var componentTypes = map[reflect.Type]string{
+	reflect.TypeOf(database.APIKey{}):          "APIKey",
+	reflect.TypeOf(database.Plan{}):            "Plan",
+	reflect.TypeOf(database.LimitResponse{}):   "LimitResponse",
+	reflect.TypeOf(database.Organization{}):    "Organization",
+	reflect.TypeOf(database.Project{}):         "Project",
+	reflect.TypeOf(database.LimitOverride{}):   "LimitOverride",
+	reflect.TypeOf(database.KeyUsage{}):        "KeyUsage",
+	reflect.TypeOf(database.KeyAnalytics{}):    "KeyAnalytics",
+	reflect.TypeOf(database.TopConsumers{}):    "TopConsumers",
+	reflect.TypeOf(database.UsageExport{}):     "UsageExport",
+	reflect.TypeOf(database.Webhook{}):         "Webhook",
+	reflect.TypeOf(database.WebhookDelivery{}): "WebhookDelivery",
+	reflect.TypeOf(database.ExportedAPIKey{}):  "ExportedAPIKey",
+	reflect.TypeOf(services.FeatureFlag{}):     "FeatureFlag",
+}
+
+// OpenAPI serves the OpenAPI 3 document describing the API
+func (h *Handler) OpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, h.openAPIDocument())
+}
+
+// Docs serves Swagger UI for the OpenAPI document
+func (h *Handler) Docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
+
+// DocsAsset serves the vendored Swagger UI file named by the file parameter
+func (h *Handler) DocsAsset(c *gin.Context) {
+	assets, _ := fs.Sub(swaggerUI, "swagger-ui")
+	c.FileFromFS(c.Param("file"), http.FS(assets))
+}
+
+func (h *Handler) openAPIDocument() schema {
+	paths := map[string]schema{}
+	// The rate limited API takes API keys, or access tokens issued for them
+	apiSecurity := []schema{{"apiKey": []string{}}}
+	securitySchemes := schema{
+		"apiKey":     schema{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		"adminToken": schema{"type": "http", "scheme": "bearer"},
+	}
+	if h.accessTokens != nil {
+		apiSecurity = append(apiSecurity, schema{"oauth2": []string{}})
+		securitySchemes["oauth2"] = schema{"type": "oauth2", "flows": schema{
+			"clientCredentials": schema{"tokenUrl": CurrentAPIVersion + "/oauth/token", "scopes": schema{}},
+		}}
+	}
+
+	for _, op := range h.operations() {
+		op.idempotent = h.idempotency != nil && op.role != 0 && op.method == http.MethodPost
+		path := openAPIPath(CurrentAPIVersion + op.path)
+		if paths[path] == nil {
+			paths[path] = schema{}
+		}
+		paths[path][strings.ToLower(op.method)] = op.document(apiSecurity)
+	}
+
+	schemas := schema{
+		"Error": object(schema{
+			"error":      schema{"type": "string"},
+			"message":    schema{"type": "string"},
+			"request_id": schema{"type": "string", "description": "Also returned in the X-Request-ID header"},
+		}),
+	}
+	for t, name := range componentTypes {
+		schemas[name] = structSchema(t)
+	}
+
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":   "Rate Limiter API",
+			"version": strings.TrimPrefix(CurrentAPIVersion, "/"),
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas":         schemas,
+			"securitySchemes": securitySchemes,
+		},
+	}
+}
+
+func (op apiOperation) document(apiSecurity []schema) schema {
+	errorResponse := schema{
+		"description": "Error",
+		"content":     schema{"application/json": schema{"schema": ref("Error")}},
+	}
+
+	contentType := op.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	content := schema{contentType: schema{"schema": op.response}}
+	if op.role == 0 && !op.public {
+		// See respond for the encodings the rate limited API negotiates
+		content[binding.MIMEMSGPACK] = schema{"schema": op.response}
+		content[binding.MIMEPROTOBUF] = schema{"schema": schema{
+			"type":        "string",
+			"format":      "binary",
+			"description": "google.protobuf.Struct message with the JSON body's fields",
+		}}
+	}
+
+	doc := schema{
+		"summary": op.summary,
+		"tags":    []string{op.tag},
+		"responses": schema{
+			strconv.Itoa(op.status): schema{
+				"description": http.StatusText(op.status),
+				"content":     content,
+			},
+			"default": errorResponse,
+		},
+	}
+
+	params := op.params
+	for _, name := range pathParams(op.path) {
+		params = append(params, schema{"name": name, "in": "path", "required": true, "schema": schema{"type": "string"}})
+	}
+	if op.idempotent {
+		params = append(params, schema{
+			"name":        middleware.IdempotencyKeyHeader,
+			"in":          "header",
+			"description": "Makes the request safe to retry: retries with the same key get the first response",
+			"schema":      schema{"type": "string", "maxLength": 255},
+		})
+	}
+	// See middleware.ETag; event streams and downloads aren't tagged
+	if op.role != 0 && op.method == http.MethodGet && op.status == http.StatusOK && contentType != "text/event-stream" && !op.download {
+		params = append(params, schema{
+			"name":        "If-None-Match",
+			"in":          "header",
+			"description": "ETag of a previous response; the response is 304 with no body while it hasn't changed",
+			"schema":      schema{"type": "string"},
+		})
+		doc["responses"].(schema)[strconv.Itoa(http.StatusNotModified)] = schema{"description": http.StatusText(http.StatusNotModified)}
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.request != nil {
+		requestContentType := op.requestContentType
+		if requestContentType == "" {
+			requestContentType = "application/json"
+		}
+		doc["requestBody"] = schema{
+			"required": !op.optionalBody,
+			"content":  schema{requestContentType: schema{"schema": schemaOf(reflect.TypeOf(op.request))}},
+		}
+	}
+
+	if op.public {
+		doc["security"] = []schema{}
+	} else if op.role == 0 {
+		doc["security"] = apiSecurity
+	} else {
+		doc["security"] = []schema{{"adminToken": []string{}}}
+		doc["description"] = "Requires the " + op.role.String() + " role when admin authentication is enabled."
+	}
+	return doc
+}
+
+// operations lists the versioned endpoints registered by SetupRoutes
+func (h *Handler) operations() []apiOperation {
+	apiKeyBody := object(schema{"api_key": ref("APIKey")})
+	nextCursor := schema{"type": "string", "description": "Cursor of the next page; absent on the last page"}
+	apiKeyList := func(field string) schema {
+		return object(schema{field: schema{"type": "array", "items": ref("APIKey")}, "next_cursor": nextCursor})
+	}
+	message := object(schema{"message": schema{"type": "string"}})
+	rateLimitChange := object(schema{
+		"limit":      schema{"type": "integer"},
+		"remaining":  schema{"type": "integer"},
+		"reset_time": schema{"type": "string", "format": "date-time"},
+		"allowed":    schema{"type": "boolean"},
+	})
+	createdKey := object(schema{
+		"api_key":        schema{"type": "string", "description": "The new key; only returned once"},
+		"key_prefix":     schema{"type": "string"},
+		"name":           schema{"type": "string"},
+		"parent_id":      schema{"type": "string"},
+		"project_id":     schema{"type": "string"},
+		"signing_secret": schema{"type": "string", "description": "Only returned once, for keys created with require_signature"},
+	})
+
+	ops := []apiOperation{
+		{method: "GET", path: "/api/status", summary: "Show the authenticated API key", tag: "api",
+			status: http.StatusOK, response: object(schema{
+				"status":  schema{"type": "string"},
+				"api_key": object(schema{"id": schema{"type": "string"}, "key_prefix": schema{"type": "string"}, "name": schema{"type": "string"}}),
+			})},
+		{method: "GET", path: "/api/rate-limit", summary: "Show the key's current rate limit window", tag: "api",
+			status: http.StatusOK, response: object(schema{"rate_limit": object(schema{
+				"limit":      schema{"type": "integer"},
+				"remaining":  schema{"type": "integer"},
+				"reset_time": schema{"type": "string", "format": "date-time"},
+				"allowed":    schema{"type": "boolean"},
+				"penalty":    object(schema{"active": schema{"type": "boolean"}, "expires_at": schema{"type": "string", "format": "date-time"}, "level": schema{"type": "integer"}}),
+			})})},
+		{method: "POST", path: "/api/rate-limit/refund", summary: "Give back units of the key's rate limit after a failed downstream call", tag: "api",
+			request: refundRequest{}, optionalBody: true, status: http.StatusOK, response: object(schema{"refunded": schema{"type": "integer"}, "rate_limit": rateLimitChange})},
+		{method: "POST", path: "/api/rate-limit/reservations", summary: "Hold units of the key's rate limit for a long-running job", tag: "api",
+			request: reservationRequest{}, status: http.StatusCreated, response: object(schema{
+				"reservation": object(schema{
+					"id":         schema{"type": "string"},
+					"units":      schema{"type": "integer"},
+					"expires_at": schema{"type": "string", "format": "date-time"},
+				}),
+				"rate_limit": rateLimitChange,
+			})},
+		{method: "POST", path: "/api/rate-limit/reservations/:id/commit", summary: "Keep the units of a reservation counted", tag: "api",
+			status: http.StatusOK, response: object(schema{"committed": schema{"type": "integer"}, "rate_limit": rateLimitChange})},
+		{method: "DELETE", path: "/api/rate-limit/reservations/:id", summary: "Cancel a reservation, giving its units back", tag: "api",
+			status: http.StatusOK, response: object(schema{"released": schema{"type": "integer"}, "rate_limit": rateLimitChange})},
+		{method: "POST", path: "/api/test", summary: "Echo a message", tag: "api", request: testRequest{},
+			status: http.StatusOK, response: object(schema{"message": schema{"type": "string"}, "echo": schema{"type": "string"}})},
+
+		{method: "GET", path: "/admin/api-keys", summary: "List API keys", tag: "api-keys", role: middleware.RoleViewer,
+			params: append(listParameters(),
+				schema{"name": "owner", "in": "query", "description": "Only keys whose owner name or email matches", "schema": schema{"type": "string"}},
+				schema{"name": "include_deleted", "in": "query", "description": "List deleted keys as well", "schema": schema{"type": "boolean", "default": false}}),
+			status: http.StatusOK, response: apiKeyList("api_keys")},
+		{method: "POST", path: "/admin/api-keys", summary: "Create an API key or sub-key", tag: "api-keys", role: middleware.RoleOperator,
+			request: createAPIKeyRequest{}, status: http.StatusCreated, response: createdKey},
+		{method: "POST", path: "/admin/api-keys/bulk", summary: "Create up to 100 API keys, all or none", tag: "api-keys", role: middleware.RoleOperator,
+			request: bulkCreateAPIKeysRequest{}, status: http.StatusCreated, response: object(schema{"api_keys": schema{"type": "array", "items": createdKey}})},
+		{method: "GET", path: "/admin/api-keys/:key", summary: "Get an API key", tag: "api-keys", role: middleware.RoleViewer,
+			status: http.StatusOK, response: apiKeyBody},
+		{method: "PUT", path: "/admin/api-keys/:key/owner", summary: "Update a key's owner", tag: "api-keys", role: middleware.RoleOperator,
+			request: ownerRequest{}, status: http.StatusOK, response: apiKeyBody},
+		{method: "PUT", path: "/admin/api-keys/:key/alert-thresholds", summary: "Set the percentages of its limits at which a key is warned", tag: "api-keys", role: middleware.RoleOperator,
+			request: alertThresholdsRequest{}, status: http.StatusOK, response: apiKeyBody},
+		{method: "PUT", path: "/admin/api-keys/:key/refund-limit", summary: "Set how many units a key may refund per window", tag: "api-keys", role: middleware.RoleOperator,
+			request: refundLimitRequest{}, status: http.StatusOK, response: apiKeyBody},
+		{method: "PUT", path: "/admin/api-keys/:key/limit-response", summary: "Set a key's custom response to requests over its limits", tag: "api-keys", role: middleware.RoleOperator,
+			request: limitResponseRequest{}, status: http.StatusOK, response: apiKeyBody},
+		{method: "DELETE", path: "/admin/api-keys/:key", summary: "Delete an API key, keeping it restorable", tag: "api-keys", role: middleware.RoleAdmin,
+			status: http.StatusOK, response: message},
+		{method: "POST", path: "/admin/api-keys/:key/restore", summary: "Restore a deleted API key", tag: "api-keys", role: middleware.RoleAdmin,
+			status: http.StatusOK, response: apiKeyBody},
+		{method: "DELETE", path: "/admin/api-keys/:key/purge", summary: "Permanently delete an API key and its rate limit state", tag: "api-keys", role: middleware.RoleAdmin,
+			status: http.StatusOK, response: object(schema{"id": schema{"type": "string"}, "redis_keys_deleted": schema{"type": "integer"}})},
+		{method: "POST", path: "/admin/api-keys/:key/override", summary: "Temporarily override a key's limit", tag: "api-keys", role: middleware.RoleOperator,
+			request: limitOverrideRequest{}, status: http.StatusCreated, response: object(schema{"override": ref("LimitOverride")})},
+		{method: "POST", path: "/admin/api-keys/:key/rotate", summary: "Rotate an API key", tag: "api-keys", role: middleware.RoleOperator,
+			request: rotateRequest{}, optionalBody: true, status: http.StatusOK, response: object(schema{
+				"id":                      schema{"type": "string"},
+				"api_key":                 schema{"type": "string"},
+				"key_prefix":              schema{"type": "string"},
+				"previous_key_expires_at": schema{"type": "string", "format": "date-time"},
+			})},
+		{method: "GET", path: "/admin/api-keys/:key/sub-keys", summary: "List a key's sub-keys", tag: "api-keys", role: middleware.RoleViewer,
+			params: listParameters(), status: http.StatusOK, response: apiKeyList("sub_keys")},
+		{method: "GET", path: "/admin/export", summary: "Export every API key with its hash", tag: "api-keys", role: middleware.RoleAdmin,
+			params: []schema{{"name": "format", "in": "query", "description": "json, or csv for a CSV file", "schema": schema{"type": "string", "default": "json", "enum": []string{"json", "csv"}}}},
+			status: http.StatusOK, response: object(schema{"exported_at": schema{"type": "string", "format": "date-time"}, "api_keys": schema{"type": "array", "items": ref("ExportedAPIKey")}}), download: true},
+		{method: "POST", path: "/admin/import", summary: "Import exported API keys, all or none", tag: "api-keys", role: middleware.RoleAdmin,
+			params:  []schema{{"name": "dry_run", "in": "query", "description": "Only check the keys", "schema": schema{"type": "boolean", "default": false}}},
+			request: importRequest{}, status: http.StatusOK, response: structSchema(reflect.TypeOf(services.ImportReport{}))},
+	}
+
+	if h.usageService != nil {
+		ops = append(ops, apiOperation{method: "GET", path: "/admin/api-keys/:key/usage", summary: "Get a key's request counts", tag: "api-keys", role: middleware.RoleViewer,
+			params: append(listParameters(), schema{"name": "days", "in": "query", "description": "Days of daily history (1-365)", "schema": schema{"type": "integer", "default": 30}}),
+			status: http.StatusOK, response: object(schema{"usage": ref("KeyUsage"), "next_cursor": nextCursor})})
+	}
+
+	if h.analyticsService != nil {
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/api-keys/:key/analytics", summary: "Get a time series of a key's requests, 429 responses and cost", tag: "api-keys", role: middleware.RoleViewer,
+				params: []schema{
+					{"name": "from", "in": "query", "description": "Start of the period, rounded down to a bucket; 24 hours before to by default", "schema": schema{"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "description": "End of the period; now by default", "schema": schema{"type": "string", "format": "date-time"}},
+					{"name": "granularity", "in": "query", "description": "Bucket length", "schema": schema{"type": "string", "enum": []string{"minute", "hour", "day"}, "default": "hour"}},
+				},
+				status: http.StatusOK, response: object(schema{"analytics": ref("KeyAnalytics")})},
+			apiOperation{method: "GET", path: "/admin/analytics/top", summary: "Rank keys by request volume, 429 rate and cost", tag: "analytics", role: middleware.RoleViewer,
+				params: []schema{
+					{"name": "window", "in": "query", "description": "How far back to look, a duration of at most 720h", "schema": schema{"type": "string", "default": "24h"}},
+					{"name": "limit", "in": "query", "description": "Keys listed in each ranking (1-100)", "schema": schema{"type": "integer", "default": 10}},
+					{"name": "min_requests", "in": "query", "description": "Fewest requests for a key to be ranked by 429 rate", "schema": schema{"type": "integer", "default": 10}},
+				},
+				status: http.StatusOK, response: object(schema{"top_consumers": ref("TopConsumers")})},
+		)
+	}
+
+	if h.exportService != nil {
+		export := object(schema{"export": ref("UsageExport")})
+		ops = append(ops,
+			apiOperation{method: "POST", path: "/admin/exports", summary: "Request a CSV report of the usage logs of a period", tag: "analytics", role: middleware.RoleViewer,
+				request: usageExportRequest{}, status: http.StatusAccepted, response: export},
+			apiOperation{method: "GET", path: "/admin/exports/:id", summary: "Get the status of a usage export", tag: "analytics", role: middleware.RoleViewer,
+				status: http.StatusOK, response: export},
+			apiOperation{method: "GET", path: "/admin/exports/:id/download", summary: "Download a completed usage export", tag: "analytics", role: middleware.RoleViewer,
+				status: http.StatusOK, response: schema{"type": "string"}, contentType: "text/csv", download: true},
+		)
+	}
+
+	if h.webhookService != nil {
+		webhook := object(schema{"webhook": ref("Webhook")})
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/api-keys/:key/webhook", summary: "Get a key's limit alert webhook", tag: "webhooks", role: middleware.RoleViewer,
+				status: http.StatusOK, response: webhook},
+			apiOperation{method: "PUT", path: "/admin/api-keys/:key/webhook", summary: "Send a key's limit alerts to a URL", tag: "webhooks", role: middleware.RoleOperator,
+				request: webhookRequest{}, status: http.StatusOK, response: object(schema{
+					"webhook": ref("Webhook"),
+					"secret":  schema{"type": "string", "description": "Signing secret of the deliveries; only returned once"},
+				})},
+			apiOperation{method: "DELETE", path: "/admin/api-keys/:key/webhook", summary: "Stop sending a key's limit alerts", tag: "webhooks", role: middleware.RoleOperator,
+				status: http.StatusOK, response: message},
+			apiOperation{method: "GET", path: "/admin/api-keys/:key/webhook/deliveries", summary: "List a key's alert deliveries", tag: "webhooks", role: middleware.RoleViewer,
+				params: listParameters(), status: http.StatusOK, response: object(schema{"deliveries": schema{"type": "array", "items": ref("WebhookDelivery")}, "next_cursor": nextCursor})},
+		)
+	}
+
+	if h.planService != nil {
+		plans := object(schema{"plans": schema{"type": "array", "items": ref("Plan")}, "next_cursor": nextCursor})
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/plans", summary: "List plans", tag: "plans", role: middleware.RoleViewer, params: listParameters(), status: http.StatusOK, response: plans},
+			apiOperation{method: "POST", path: "/admin/plans", summary: "Create a plan", tag: "plans", role: middleware.RoleAdmin, request: planRequest{}, status: http.StatusCreated, response: ref("Plan")},
+			apiOperation{method: "GET", path: "/admin/plans/:id", summary: "Get a plan", tag: "plans", role: middleware.RoleViewer, status: http.StatusOK, response: ref("Plan")},
+			apiOperation{method: "PUT", path: "/admin/plans/:id", summary: "Update a plan", tag: "plans", role: middleware.RoleAdmin, request: planRequest{}, status: http.StatusOK, response: ref("Plan")},
+			apiOperation{method: "DELETE", path: "/admin/plans/:id", summary: "Delete a plan", tag: "plans", role: middleware.RoleAdmin, status: http.StatusOK, response: message},
+			apiOperation{method: "POST", path: "/admin/plans/:id/reassign", summary: "Move a plan's keys to another plan", tag: "plans", role: middleware.RoleAdmin,
+				request: reassignPlanRequest{}, status: http.StatusOK, response: object(schema{"reassigned_keys": schema{"type": "integer", "format": "int64"}, "plan_deleted": schema{"type": "boolean"}})},
+		)
+	}
+
+	if h.organizationService != nil {
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/organizations", summary: "List organizations", tag: "organizations", role: middleware.RoleViewer,
+				params: listParameters(), status: http.StatusOK, response: object(schema{"organizations": schema{"type": "array", "items": ref("Organization")}, "next_cursor": nextCursor})},
+			apiOperation{method: "POST", path: "/admin/organizations", summary: "Create an organization", tag: "organizations", role: middleware.RoleAdmin,
+				request: organizationRequest{}, status: http.StatusCreated, response: ref("Organization")},
+			apiOperation{method: "GET", path: "/admin/organizations/:id", summary: "Get an organization", tag: "organizations", role: middleware.RoleViewer,
+				status: http.StatusOK, response: ref("Organization")},
+			apiOperation{method: "PUT", path: "/admin/organizations/:id", summary: "Rename an organization or change its limit", tag: "organizations", role: middleware.RoleAdmin,
+				request: organizationRequest{}, status: http.StatusOK, response: ref("Organization")},
+			apiOperation{method: "GET", path: "/admin/organizations/:id/projects", summary: "List an organization's projects", tag: "organizations", role: middleware.RoleViewer,
+				params: listParameters(), status: http.StatusOK, response: object(schema{"projects": schema{"type": "array", "items": ref("Project")}, "next_cursor": nextCursor})},
+			apiOperation{method: "POST", path: "/admin/organizations/:id/projects", summary: "Create a project in an organization", tag: "organizations", role: middleware.RoleOperator,
+				request: projectRequest{}, status: http.StatusCreated, response: ref("Project")},
+		)
+	}
+
+	if h.accessTokens != nil {
+		ops = append(ops,
+			apiOperation{method: "POST", path: "/oauth/token", summary: "Exchange a key's ID and secret for an access token", tag: "api",
+				request: accessTokenRequest{}, requestContentType: binding.MIMEPOSTForm, public: true, status: http.StatusOK, response: object(schema{
+					"access_token": schema{"type": "string"},
+					"token_type":   schema{"type": "string"},
+					"expires_in":   schema{"type": "integer"},
+				})},
+			apiOperation{method: "POST", path: "/api/token", summary: "Exchange the API key for a short-lived, optionally scoped down access token", tag: "api",
+				request: tokenExchangeRequest{}, optionalBody: true, status: http.StatusOK, response: object(schema{
+					"access_token": schema{"type": "string"},
+					"token_type":   schema{"type": "string"},
+					"expires_in":   schema{"type": "integer"},
+					"expires_at":   schema{"type": "string", "format": "date-time"},
+				})},
+		)
+	}
+
+	if h.selfServiceEnabled() {
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/me/api-keys", summary: "List the keys of the caller's organization", tag: "self-service", role: middleware.RoleViewer,
+				params: listParameters(), status: http.StatusOK, response: apiKeyList("api_keys")},
+			apiOperation{method: "POST", path: "/me/api-keys", summary: "Create a key on the organization's plan", tag: "self-service", role: middleware.RoleOperator,
+				request: selfServiceKeyRequest{}, status: http.StatusCreated, response: createdKey},
+			apiOperation{method: "PATCH", path: "/me/api-keys/:key", summary: "Rename one of the organization's keys", tag: "self-service", role: middleware.RoleOperator,
+				request: renameAPIKeyRequest{}, status: http.StatusOK, response: apiKeyBody},
+			apiOperation{method: "DELETE", path: "/me/api-keys/:key", summary: "Revoke one of the organization's keys", tag: "self-service", role: middleware.RoleOperator,
+				status: http.StatusOK, response: message},
+		)
+	}
+
+	if h.featureFlags != nil {
+		feature := object(schema{"feature": ref("FeatureFlag")})
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/features", summary: "List feature flags", tag: "features", role: middleware.RoleViewer,
+				status: http.StatusOK, response: object(schema{"features": schema{"type": "array", "items": ref("FeatureFlag")}})},
+			apiOperation{method: "PUT", path: "/admin/features/:name", summary: "Toggle a feature flag at runtime", tag: "features", role: middleware.RoleAdmin,
+				request: featureFlagRequest{}, status: http.StatusOK, response: feature},
+			apiOperation{method: "DELETE", path: "/admin/features/:name", summary: "Remove a feature flag's runtime toggle", tag: "features", role: middleware.RoleAdmin,
+				status: http.StatusOK, response: feature},
+		)
+	}
+
+	if h.maintenance != nil {
+		maintenance := object(schema{"maintenance": structSchema(reflect.TypeOf(maintenanceResponse{}))})
+		ops = append(ops,
+			apiOperation{method: "GET", path: "/admin/maintenance", summary: "Get the maintenance state", tag: "maintenance", role: middleware.RoleViewer,
+				status: http.StatusOK, response: maintenance},
+			apiOperation{method: "POST", path: "/admin/maintenance", summary: "Switch maintenance mode for the public API", tag: "maintenance", role: middleware.RoleAdmin,
+				request: maintenanceRequest{}, status: http.StatusOK, response: maintenance},
+		)
+	}
+
+	if h.configReloader != nil {
+		ops = append(ops, apiOperation{method: "POST", path: "/admin/config/reload", summary: "Reload the configuration", tag: "config", role: middleware.RoleAdmin,
+			status: http.StatusOK, response: object(schema{"status": schema{"type": "string"}})})
+	}
+
+	if h.liveEvents != nil {
+		ops = append(ops, apiOperation{method: "GET", path: "/admin/events/stream", summary: "Stream live rate limit decisions and key events", tag: "events", role: middleware.RoleViewer,
+			params: []schema{
+				{"name": "api_key_id", "in": "query", "description": "Only events of these keys; repeated or comma separated", "schema": schema{"type": "string"}},
+				{"name": "type", "in": "query", "description": "Only events of these types, e.g. request.decided or api_key.rotated; repeated or comma separated", "schema": schema{"type": "string"}},
+			},
+			status: http.StatusOK, contentType: "text/event-stream", response: schema{
+				"type":        "string",
+				"description": "Server-sent events named after the event type, each with the event as JSON data; comment lines are heartbeats",
+			}})
+		ops = append(ops, apiOperation{method: "GET", path: "/admin/events/socket", summary: "Subscribe to live events, alerts and key counters over a WebSocket", tag: "events", role: middleware.RoleViewer,
+			status: http.StatusSwitchingProtocols, response: schema{
+				"type":        "string",
+				"description": `WebSocket of JSON messages. Send {"action":"subscribe","channel":"events","api_key_ids":[...],"types":[...]} for events, channel "alerts" for alerts and channel "counters" with api_key_ids for key counters, and "unsubscribe" to stop; replies have type subscribed, unsubscribed or error, and deliveries type event or counters`,
+			}})
+	}
+
+	return ops
+}
+
+// listParameters documents the query parameters read by parseListQuery.
+// Field filters depend on the listed type and are described in prose.
+func listParameters() []schema {
+	return []schema{
+		{"name": "limit", "in": "query", "description": "Page size", "schema": schema{"type": "integer", "default": defaultListLimit, "minimum": 1, "maximum": maxListLimit}},
+		{"name": "cursor", "in": "query", "description": "next_cursor of the previous page", "schema": schema{"type": "string"}},
+		{"name": "sort", "in": "query", "description": "Comma separated fields, prefixed with - for descending order", "schema": schema{"type": "string"}, "example": "-created_at"},
+		{"name": "filter", "in": "query", "description": "Field filters as field=value or field[op]=value, op one of eq, ne, lt, lte, gt, gte, contains, in",
+			"style": "form", "explode": true, "schema": schema{"type": "object", "additionalProperties": schema{"type": "string"}}},
+	}
+}
+
+// schemaOf describes a Go type as it is encoded by encoding/json
+func schemaOf(t reflect.Type) schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return schema{"type": "string", "format": "date-time"}
+	}
+	if name, ok := componentTypes[t]; ok {
+		return ref(name)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return schema{}
+}
+
+// structSchema describes a struct's JSON fields, taking required fields and
+// simple constraints from its binding tags
+func structSchema(t reflect.Type) schema {
+	properties := schema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaOf(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+			switch rule {
+			case "required":
+				required = append(required, name)
+			case "email":
+				property["format"] = "email"
+			case "gte=0":
+				property["minimum"] = 0
+			case "gt=0":
+				property["minimum"] = 1
+			}
+		}
+		properties[name] = property
+	}
+
+	s := object(properties)
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func object(properties schema) schema {
+	return schema{"type": "object", "properties": properties}
+}
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPIPath converts gin's ":param" segments to OpenAPI's "{param}"
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			params = append(params, segment[1:])
+		}
+	}
+	return params
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/live"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOpenAPITestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	credentials, _ := middleware.ParseAdminCredentials([]string{"admin:platform-token"})
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{},
+		WithAdminCredentials(credentials),
+		WithOrganizationService(&MockOrganizationService{}),
+		WithAccessTokens(services.NewAccessTokenService(nil, nil, time.Minute)),
+		WithPlanService(&MockPlanService{}),
+		WithUsageService(&MockUsageService{}),
+		WithAnalyticsService(&MockAnalyticsService{}),
+		WithWebhookService(&MockWebhookService{}),
+		WithExportService(&MockExportService{}),
+		WithFeatureFlags(services.NewFeatureFlagService(nil, nil, 0)),
+		WithMaintenance(middleware.NewMaintenanceMode(middleware.MaintenanceState{})),
+		WithLiveEvents(live.NewHub(1), 0),
+		WithIdempotency(services.NewIdempotencyService(nil, time.Hour)),
+	)
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+	return router
+}
+
+func getOpenAPIDocument(t *testing.T, router *gin.Engine) map[string]interface{} {
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	return document
+}
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	router := setupOpenAPITestRouter()
+	paths := getOpenAPIDocument(t, router)["paths"].(map[string]interface{})
+
+	documented := 0
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, CurrentAPIVersion+"/") {
+			continue
+		}
+		operations, ok := paths[openAPIPath(route.Path)].(map[string]interface{})
+		if assert.True(t, ok, "%s is not documented", route.Path) {
+			assert.Contains(t, operations, strings.ToLower(route.Method), "%s %s is not documented", route.Method, route.Path)
+		}
+		documented++
+	}
+
+	operations := 0
+	for _, path := range paths {
+		operations += len(path.(map[string]interface{}))
+	}
+	assert.Equal(t, documented, operations, "the document lists operations that are not registered")
+}
+
+func TestOpenAPI_RequestSchemaFromBindingTags(t *testing.T) {
+	router := setupOpenAPITestRouter()
+	paths := getOpenAPIDocument(t, router)["paths"].(map[string]interface{})
+
+	post := paths["/v1/admin/api-keys/{key}/override"].(map[string]interface{})["post"].(map[string]interface{})
+	body := post["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+	assert.ElementsMatch(t, []interface{}{"rate_limit_requests", "expires_at"}, body["required"])
+	properties := body["properties"].(map[string]interface{})
+	assert.Equal(t, "date-time", properties["expires_at"].(map[string]interface{})["format"])
+	assert.Equal(t, float64(1), properties["rate_limit_requests"].(map[string]interface{})["minimum"])
+
+	parameters := post["parameters"].([]interface{})
+	assert.Equal(t, "key", parameters[0].(map[string]interface{})["name"])
+	assert.Equal(t, middleware.IdempotencyKeyHeader, parameters[1].(map[string]interface{})["name"])
+}
+
+func TestOpenAPI_ConditionalAdminReads(t *testing.T) {
+	router := setupOpenAPITestRouter()
+	paths := getOpenAPIDocument(t, router)["paths"].(map[string]interface{})
+
+	list := paths["/v1/admin/api-keys"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Contains(t, list["responses"], "304")
+	parameters := list["parameters"].([]interface{})
+	assert.Equal(t, "If-None-Match", parameters[len(parameters)-1].(map[string]interface{})["name"])
+
+	stream := paths["/v1/admin/events/stream"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.NotContains(t, stream["responses"], "304")
+	export := paths["/v1/admin/export"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.NotContains(t, export["responses"], "304")
+	create := paths["/v1/admin/api-keys"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.NotContains(t, create["responses"], "304")
+}
+
+func TestOpenAPI_OmitsDisabledEndpoints(t *testing.T) {
+	_, _, _, handler := setupTestRouter()
+
+	document := handler.openAPIDocument()
+	paths := document["paths"].(map[string]schema)
+
+	assert.NotContains(t, paths, "/v1/admin/plans")
+	assert.NotContains(t, paths, "/v1/admin/api-keys/{key}/usage")
+	assert.Contains(t, paths, "/v1/admin/api-keys")
+	assert.NotContains(t, paths, "/v1/me/api-keys")
+}
+
+func TestDocs_ServesSwaggerUI(t *testing.T) {
+	router := setupOpenAPITestRouter()
+
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `url: "/openapi.json"`)
+	assert.NotContains(t, w.Body.String(), "https://", "Swagger UI is served from the binary")
+
+	req, _ = http.NewRequest("GET", "/docs/assets/VERSION", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^5\.\d+\.\d+\n$`, w.Body.String())
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithOrganizationService enables the organization and project endpoints and
+// lets keys be created in a project
+func WithOrganizationService(organizationService services.OrganizationServiceInterface) Option {
+	return func(h *Handler) {
+		h.organizationService = organizationService
+	}
+}
+
+// authorizePlatform is authorize for operations that affect every tenant,
+// which credentials scoped to an organization may not perform
+func (h *Handler) authorizePlatform(role middleware.Role, handler gin.HandlerFunc) []gin.HandlerFunc {
+	if len(h.adminAuthenticators) == 0 {
+		return []gin.HandlerFunc{handler}
+	}
+	return []gin.HandlerFunc{middleware.RequireRole(role), middleware.RequirePlatform(), handler}
+}
+
+// requireKeyScope answers 404 for the :key of a request made with a
+// credential scoped to another organization than the key's, as if the key
+// didn't exist
+func (h *Handler) requireKeyScope(c *gin.Context) {
+	organizationID := middleware.AdminOrganization(c)
+	if organizationID == "" || c.Param("key") == "" {
+		c.Next()
+		return
+	}
+
+	apiKey, err := h.apiKeyService.GetAPIKey(c.Request.Context(), c.Param("key"))
+	if err == nil && apiKey.OrganizationID != organizationID {
+		err = services.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": services.ErrAPIKeyNotFound.Error(),
+			}))
+		} else {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+				"error":   "Failed to get API key",
+				"message": err.Error(),
+			}))
+		}
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// organizationVisible reports whether the caller may see organization id:
+// callers scoped to an organization only see their own
+func organizationVisible(c *gin.Context, id string) bool {
+	scope := middleware.AdminOrganization(c)
+	return scope == "" || scope == id
+}
+
+type organizationRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+
+	// Ceiling across all of the organization's keys; 0 = none
+	RateLimitRequests      int `json:"rate_limit_requests" binding:"gte=0"`
+	RateLimitWindowSeconds int `json:"rate_limit_window_seconds" binding:"gte=0"`
+
+	// Plan of the keys the organization creates itself; none disables
+	// self-service. MaxKeys caps its active keys; 0 = none
+	PlanID  string `json:"plan_id"`
+	MaxKeys int    `json:"max_keys" binding:"gte=0"`
+}
+
+func (r organizationRequest) toOrganization() *database.Organization {
+	return &database.Organization{
+		Name:                   r.Name,
+		RateLimitRequests:      r.RateLimitRequests,
+		RateLimitWindowSeconds: r.RateLimitWindowSeconds,
+		PlanID:                 r.PlanID,
+		MaxKeys:                r.MaxKeys,
+	}
+}
+
+type projectRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// ListOrganizations returns a page of the organizations, or only their own
+// for callers scoped to one
+func (h *Handler) ListOrganizations(c *gin.Context) {
+	query, err := parseListQuery[*database.Organization](c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	organizations, err := h.organizationService.ListOrganizations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to list organizations",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	visible := make([]*database.Organization, 0, len(organizations))
+	for _, organization := range organizations {
+		if organizationVisible(c, organization.ID) {
+			visible = append(visible, organization)
+		}
+	}
+
+	page, nextCursor := applyListQuery(visible, query)
+	listResponse(c, "organizations", page, nextCursor)
+}
+
+func (h *Handler) CreateOrganization(c *gin.Context) {
+	var request organizationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	organization, err := h.organizationService.CreateOrganization(c.Request.Context(), request.toOrganization())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create organization",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, organization)
+}
+
+func (h *Handler) GetOrganization(c *gin.Context) {
+	organization, ok := h.visibleOrganization(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// UpdateOrganization renames an organization or changes the ceiling across
+// its keys
+func (h *Handler) UpdateOrganization(c *gin.Context) {
+	var request organizationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	organization, err := h.organizationService.UpdateOrganization(c.Request.Context(), c.Param("id"), request.toOrganization())
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "Organization not found",
+				"message": services.ErrOrganizationNotFound.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to update organization",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// ListProjects returns a page of an organization's projects
+func (h *Handler) ListProjects(c *gin.Context) {
+	query, err := parseListQuery[*database.Project](c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	organization, ok := h.visibleOrganization(c)
+	if !ok {
+		return
+	}
+
+	projects, err := h.organizationService.ListProjects(c.Request.Context(), organization.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to list projects",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	page, nextCursor := applyListQuery(projects, query)
+	listResponse(c, "projects", page, nextCursor)
+}
+
+// CreateProject adds a project to an organization
+func (h *Handler) CreateProject(c *gin.Context) {
+	var request projectRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	organization, ok := h.visibleOrganization(c)
+	if !ok {
+		return
+	}
+
+	project, err := h.organizationService.CreateProject(c.Request.Context(), organization.ID, request.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create project",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, project)
+}
+
+// visibleOrganization returns the organization named by the :id parameter,
+// or answers 404 when it doesn't exist or the caller may not see it
+func (h *Handler) visibleOrganization(c *gin.Context) (*database.Organization, bool) {
+	organization, err := h.organizationService.GetOrganization(c.Request.Context(), c.Param("id"))
+	if err == nil && !organizationVisible(c, organization.ID) {
+		err = services.ErrOrganizationNotFound
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "Organization not found",
+				"message": services.ErrOrganizationNotFound.Error(),
+			}))
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get organization",
+			"message": err.Error(),
+		}))
+		return nil, false
+	}
+	return organization, true
+}
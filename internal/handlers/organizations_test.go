@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockOrganizationService is a mock implementation of OrganizationServiceInterface
+type MockOrganizationService struct {
+	mock.Mock
+}
+
+func (m *MockOrganizationService) CreateOrganization(ctx context.Context, organization *database.Organization) (*database.Organization, error) {
+	args := m.Called(ctx, organization)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationService) GetOrganization(ctx context.Context, id string) (*database.Organization, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationService) UpdateOrganization(ctx context.Context, id string, organization *database.Organization) (*database.Organization, error) {
+	args := m.Called(ctx, id, organization)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationService) ListOrganizations(ctx context.Context) ([]*database.Organization, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.Organization), args.Error(1)
+}
+
+func (m *MockOrganizationService) CreateProject(ctx context.Context, organizationID, name string) (*database.Project, error) {
+	args := m.Called(ctx, organizationID, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Project), args.Error(1)
+}
+
+func (m *MockOrganizationService) GetProject(ctx context.Context, id string) (*database.Project, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Project), args.Error(1)
+}
+
+func (m *MockOrganizationService) ListProjects(ctx context.Context, organizationID string) ([]*database.Project, error) {
+	args := m.Called(ctx, organizationID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.Project), args.Error(1)
+}
+
+// setupOrganizationTestRouter authenticates "platform-token" as an admin of
+// every organization and "acme-token" as an admin of org-acme only
+func setupOrganizationTestRouter(t *testing.T) (*gin.Engine, *MockAPIKeyService, *MockOrganizationService) {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"admin:platform-token", "admin@org-acme:acme-token"})
+	require.NoError(t, err)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockOrganizationService := &MockOrganizationService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{},
+		WithAdminCredentials(credentials), WithOrganizationService(mockOrganizationService), WithPlanService(&MockPlanService{}))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockAPIKeyService, mockOrganizationService
+}
+
+func organizationRequestAs(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestListOrganizations_ScopedToOwnOrganization(t *testing.T) {
+	router, _, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("ListOrganizations", mock.Anything).Return([]*database.Organization{
+		{ID: "org-acme", Name: "Acme"},
+		{ID: "org-globex", Name: "Globex"},
+	}, nil)
+
+	w := organizationRequestAs(router, "GET", "/admin/organizations", "acme-token", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Organizations []database.Organization `json:"organizations"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Organizations, 1)
+	assert.Equal(t, "org-acme", response.Organizations[0].ID)
+
+	w = organizationRequestAs(router, "GET", "/admin/organizations", "platform-token", nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Organizations, 2)
+}
+
+func TestOrganizationRoutes_HideOtherOrganizations(t *testing.T) {
+	router, _, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("GetOrganization", mock.Anything, "org-globex").Return(&database.Organization{ID: "org-globex", Name: "Globex"}, nil)
+
+	w := organizationRequestAs(router, "GET", "/admin/organizations/org-globex/projects", "acme-token", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = organizationRequestAs(router, "POST", "/admin/organizations/org-globex/projects", "acme-token", projectRequest{Name: "Checkout"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockOrganizationService.AssertNotCalled(t, "CreateProject", mock.Anything, mock.Anything, mock.Anything)
+
+	w = organizationRequestAs(router, "POST", "/admin/organizations", "acme-token", organizationRequest{Name: "Initech"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCreateProject(t *testing.T) {
+	router, _, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("GetOrganization", mock.Anything, "org-acme").Return(&database.Organization{ID: "org-acme", Name: "Acme"}, nil)
+	mockOrganizationService.On("CreateProject", mock.Anything, "org-acme", "Checkout").Return(&database.Project{ID: "project-1", OrganizationID: "org-acme", Name: "Checkout"}, nil)
+
+	w := organizationRequestAs(router, "POST", "/admin/organizations/org-acme/projects", "acme-token", projectRequest{Name: "Checkout"})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":"project-1"`)
+	mockOrganizationService.AssertExpectations(t)
+}
+
+func TestScopedCredential_KeysOfOtherOrganizations(t *testing.T) {
+	router, mockAPIKeyService, _ := setupOrganizationTestRouter(t)
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "ak_globex").Return(&database.APIKey{ID: "globex-key", OrganizationID: "org-globex"}, nil)
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "ak_acme").Return(&database.APIKey{ID: "acme-key", OrganizationID: "org-acme"}, nil)
+	mockAPIKeyService.On("DeleteAPIKey", mock.Anything, "ak_acme").Return(nil)
+	mockAPIKeyService.On("ListAPIKeys", mock.Anything, services.APIKeyFilter{OrganizationID: "org-acme", Limit: defaultListLimit + 1}).Return([]*database.APIKey{}, nil)
+
+	w := organizationRequestAs(router, "DELETE", "/admin/api-keys/ak_globex", "acme-token", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "DeleteAPIKey", mock.Anything, "ak_globex")
+
+	w = organizationRequestAs(router, "DELETE", "/admin/api-keys/ak_acme", "acme-token", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = organizationRequestAs(router, "GET", "/admin/api-keys", "acme-token", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = organizationRequestAs(router, "POST", "/admin/plans", "acme-token", planRequest{Name: "Gold"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_Project(t *testing.T) {
+	router, mockAPIKeyService, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("GetProject", mock.Anything, "project-acme").Return(&database.Project{ID: "project-acme", OrganizationID: "org-acme"}, nil)
+	mockOrganizationService.On("GetProject", mock.Anything, "project-globex").Return(&database.Project{ID: "project-globex", OrganizationID: "org-globex"}, nil)
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Checkout", RateLimitRequests: 100, RateLimitWindowSeconds: 60, ProjectID: "project-acme"}).Return("ak_new", nil)
+
+	w := organizationRequestAs(router, "POST", "/admin/api-keys", "acme-token", createAPIKeyRequest{Name: "Checkout", RateLimitRequests: 100, RateLimitWindowSeconds: 60})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "project_id is required")
+
+	w = organizationRequestAs(router, "POST", "/admin/api-keys", "acme-token", createAPIKeyRequest{Name: "Checkout", RateLimitRequests: 100, RateLimitWindowSeconds: 60, ProjectID: "project-globex"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = organizationRequestAs(router, "POST", "/admin/api-keys", "acme-token", createAPIKeyRequest{Name: "Checkout", RateLimitRequests: 100, RateLimitWindowSeconds: 60, ProjectID: "project-acme"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"project_id":"project-acme"`)
+	mockAPIKeyService.AssertExpectations(t)
+}
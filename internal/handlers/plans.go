@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type planRequest struct {
+	Name                   string `json:"name" binding:"required"`
+	RateLimitRequests      int    `json:"rate_limit_requests" binding:"required,gt=0"`
+	RateLimitWindowSeconds int    `json:"rate_limit_window_seconds" binding:"required,gt=0"`
+	QuotaRequests          int    `json:"quota_requests" binding:"gte=0"`
+	QuotaPeriodSeconds     int    `json:"quota_period_seconds" binding:"gte=0"`
+	BurstRequests          int    `json:"burst_requests" binding:"gte=0"`
+
+	// Response to refused requests of the plan's keys without one of their own
+	LimitResponse *database.LimitResponse `json:"limit_response"`
+}
+
+// toPlan validates the request and returns the plan it describes
+func (r planRequest) toPlan() (*database.Plan, error) {
+	limitResponse, err := services.NormalizeLimitResponse(r.LimitResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &database.Plan{
+		Name:                   r.Name,
+		RateLimitRequests:      r.RateLimitRequests,
+		RateLimitWindowSeconds: r.RateLimitWindowSeconds,
+		QuotaRequests:          r.QuotaRequests,
+		QuotaPeriodSeconds:     r.QuotaPeriodSeconds,
+		BurstRequests:          r.BurstRequests,
+		LimitResponse:          limitResponse,
+	}, nil
+}
+
+func (h *Handler) ListPlans(c *gin.Context) {
+	query, err := parseListQuery[*database.Plan](c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	plans, err := h.planService.ListPlans(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to list plans",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	page, nextCursor := applyListQuery(plans, query)
+	listResponse(c, "plans", page, nextCursor)
+}
+
+func (h *Handler) CreatePlan(c *gin.Context) {
+	var request planRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	plan, err := request.toPlan()
+	if err != nil {
+		invalidPlan(c, err)
+		return
+	}
+
+	plan, err = h.planService.CreatePlan(c.Request.Context(), plan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create plan",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+func (h *Handler) GetPlan(c *gin.Context) {
+	plan, err := h.planService.GetPlan(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.planError(c, "Failed to get plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h *Handler) UpdatePlan(c *gin.Context) {
+	var request planRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	plan, err := request.toPlan()
+	if err != nil {
+		invalidPlan(c, err)
+		return
+	}
+
+	plan, err = h.planService.UpdatePlan(c.Request.Context(), c.Param("id"), plan)
+	if err != nil {
+		h.planError(c, "Failed to update plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h *Handler) DeletePlan(c *gin.Context) {
+	if err := h.planService.DeletePlan(c.Request.Context(), c.Param("id")); err != nil {
+		h.planError(c, "Failed to delete plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan deleted successfully",
+	})
+}
+
+type reassignPlanRequest struct {
+	// The plan the keys move to
+	PlanID string `json:"plan_id" binding:"required"`
+
+	// Deletes the emptied plan in the same transaction
+	DeletePlan bool `json:"delete_plan"`
+}
+
+// ReassignPlan moves every key on a plan to another plan, e.g. to retire
+// the plan. Nothing changes if any step fails.
+func (h *Handler) ReassignPlan(c *gin.Context) {
+	var request reassignPlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	if request.PlanID == c.Param("id") {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": "plan_id must be a different plan",
+		}))
+		return
+	}
+
+	reassigned, err := h.planService.ReassignPlan(c.Request.Context(), c.Param("id"), request.PlanID, request.DeletePlan)
+	if err != nil {
+		h.planError(c, "Failed to reassign plan", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reassigned_keys": reassigned,
+		"plan_deleted":    request.DeletePlan,
+	})
+}
+
+// planError maps plan service errors onto HTTP responses
+func (h *Handler) planError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+			"error":   "Plan not found",
+			"message": err.Error(),
+		}))
+	case errors.Is(err, services.ErrPlanInUse):
+		c.JSON(http.StatusConflict, middleware.ErrorBody(c, gin.H{
+			"error":   "Plan in use",
+			"message": err.Error(),
+		}))
+	default:
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   message,
+			"message": err.Error(),
+		}))
+	}
+}
+
+// invalidPlan answers a plan request whose settings are invalid
+func invalidPlan(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+		"error":   "Invalid request",
+		"message": err.Error(),
+	}))
+}
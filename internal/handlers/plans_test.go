@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockPlanService is a mock implementation of PlanServiceInterface
+type MockPlanService struct {
+	mock.Mock
+}
+
+func (m *MockPlanService) CreatePlan(ctx context.Context, plan *database.Plan) (*database.Plan, error) {
+	args := m.Called(ctx, plan)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Plan), args.Error(1)
+}
+
+func (m *MockPlanService) GetPlan(ctx context.Context, id string) (*database.Plan, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Plan), args.Error(1)
+}
+
+func (m *MockPlanService) ListPlans(ctx context.Context) ([]*database.Plan, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.Plan), args.Error(1)
+}
+
+func (m *MockPlanService) UpdatePlan(ctx context.Context, id string, plan *database.Plan) (*database.Plan, error) {
+	args := m.Called(ctx, id, plan)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Plan), args.Error(1)
+}
+
+func (m *MockPlanService) DeletePlan(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockPlanService) ReassignPlan(ctx context.Context, id, targetID string, deletePlan bool) (int64, error) {
+	args := m.Called(ctx, id, targetID, deletePlan)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func setupPlanTestRouter() (*gin.Engine, *MockPlanService) {
+	gin.SetMode(gin.TestMode)
+
+	mockPlanService := &MockPlanService{}
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithPlanService(mockPlanService))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockPlanService
+}
+
+func createTestPlan() *database.Plan {
+	return &database.Plan{
+		ID:                     "plan-id-123",
+		Name:                   "pro",
+		RateLimitRequests:      600,
+		RateLimitWindowSeconds: 60,
+		QuotaRequests:          1000000,
+		QuotaPeriodSeconds:     2592000,
+		BurstRequests:          100,
+		CreatedAt:              time.Now(),
+		UpdatedAt:              time.Now(),
+	}
+}
+
+func TestListPlans_Success(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	mockPlanService.On("ListPlans", mock.Anything).Return([]*database.Plan{createTestPlan()}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/plans", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Len(t, response["plans"], 1)
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestCreatePlan_Success(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	expected := createTestPlan()
+	mockPlanService.On("CreatePlan", mock.Anything, mock.MatchedBy(func(plan *database.Plan) bool {
+		return plan.Name == "pro" && plan.RateLimitRequests == 600 && plan.BurstRequests == 100
+	})).Return(expected, nil)
+
+	requestBody := map[string]interface{}{
+		"name":                      "pro",
+		"rate_limit_requests":       600,
+		"rate_limit_window_seconds": 60,
+		"burst_requests":            100,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/plans", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "plan-id-123", response["id"])
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestCreatePlan_InvalidRequest(t *testing.T) {
+	router, _ := setupPlanTestRouter()
+
+	requestBody := map[string]interface{}{
+		"name": "pro",
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/plans", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreatePlan_InvalidLimitResponse(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	body := []byte(`{"name": "pro", "rate_limit_requests": 600, "rate_limit_window_seconds": 60, "limit_response": {"headers": {"Content-Length": "10"}}}`)
+	req, _ := http.NewRequest("POST", "/admin/plans", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Content-Length")
+	mockPlanService.AssertNotCalled(t, "CreatePlan", mock.Anything, mock.Anything)
+}
+
+func TestGetPlan_NotFound(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	mockPlanService.On("GetPlan", mock.Anything, "missing").Return(nil, services.ErrPlanNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/plans/missing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestDeletePlan_InUse(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	mockPlanService.On("DeletePlan", mock.Anything, "plan-id-123").Return(services.ErrPlanInUse)
+
+	req, _ := http.NewRequest("DELETE", "/admin/plans/plan-id-123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestReassignPlan_Success(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	mockPlanService.On("ReassignPlan", mock.Anything, "plan-id-123", "plan-id-456", true).Return(int64(3), nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"plan_id": "plan-id-456", "delete_plan": true})
+	req, _ := http.NewRequest("POST", "/admin/plans/plan-id-123/reassign", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(3), response["reassigned_keys"])
+	assert.Equal(t, true, response["plan_deleted"])
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestReassignPlan_SamePlan(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"plan_id": "plan-id-123"})
+	req, _ := http.NewRequest("POST", "/admin/plans/plan-id-123/reassign", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockPlanService.AssertNotCalled(t, "ReassignPlan", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdatePlan_ServiceError(t *testing.T) {
+	router, mockPlanService := setupPlanTestRouter()
+
+	mockPlanService.On("UpdatePlan", mock.Anything, "plan-id-123", mock.Anything).Return(nil, fmt.Errorf("database error"))
+
+	requestBody := map[string]interface{}{
+		"name":                      "pro",
+		"rate_limit_requests":       600,
+		"rate_limit_window_seconds": 60,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("PUT", "/admin/plans/plan-id-123", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	mockPlanService.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_WithPlan(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	// Keys on a plan keep zero limits so they inherit from the plan
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, services.CreateAPIKeyParams{Name: "Plan Key", PlanID: "plan-id-123"}).Return("ak_plan", nil)
+
+	requestBody := map[string]interface{}{
+		"name":    "Plan Key",
+		"plan_id": "plan-id-123",
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "plan-id-123", response["plan_id"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http/pprof"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerProfiling serves the net/http/pprof endpoints on group, which is
+// mounted at /debug/pprof. Profiles expose internals and a CPU profile or
+// trace costs noticeable overhead while it runs, so they need the admin role.
+func (h *Handler) registerProfiling(group gin.IRouter) {
+	handle := func(handler gin.HandlerFunc) []gin.HandlerFunc {
+		return h.authorizePlatform(middleware.RoleAdmin, handler)
+	}
+
+	group.GET("/", handle(gin.WrapF(pprof.Index))...)
+	group.GET("/cmdline", handle(gin.WrapF(pprof.Cmdline))...)
+	group.GET("/profile", handle(gin.WrapF(pprof.Profile))...)
+	group.GET("/symbol", handle(gin.WrapF(pprof.Symbol))...)
+	group.POST("/symbol", handle(gin.WrapF(pprof.Symbol))...)
+	group.GET("/trace", handle(gin.WrapF(pprof.Trace))...)
+	// Named runtime profiles: allocs, block, goroutine, heap, mutex, threadcreate
+	group.GET("/:profile", handle(func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})...)
+}
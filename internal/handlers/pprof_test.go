@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupProfilingTestRouter(t *testing.T, enabled bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := middleware.ParseAdminCredentials([]string{"viewer:view-token", "admin:admin-token"})
+	require.NoError(t, err)
+
+	handler := NewHandler(&MockAPIKeyService{}, &MockRateLimitService{}, WithAdminCredentials(credentials), WithProfiling(enabled))
+	router := gin.New()
+	handler.SetupRoutes(router)
+	return router
+}
+
+func serveProfiling(router *gin.Engine, path string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProfiling_RequiresAdminRole(t *testing.T) {
+	router := setupProfilingTestRouter(t, true)
+
+	assert.Equal(t, http.StatusUnauthorized, serveProfiling(router, "/debug/pprof/", "").Code)
+	assert.Equal(t, http.StatusForbidden, serveProfiling(router, "/debug/pprof/heap", "view-token").Code)
+
+	w := serveProfiling(router, "/debug/pprof/", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine")
+
+	w = serveProfiling(router, "/debug/pprof/goroutine?debug=1", "admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine profile")
+}
+
+func TestProfiling_DisabledByDefault(t *testing.T) {
+	router := setupProfilingTestRouter(t, false)
+
+	assert.Equal(t, http.StatusNotFound, serveProfiling(router, "/debug/pprof/", "admin-token").Code)
+}
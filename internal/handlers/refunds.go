@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refundRequest is the number of units to refund, 1 when there is no body
+type refundRequest struct {
+	Units int64 `json:"units" binding:"gte=0"`
+}
+
+// RefundRateLimit gives units of the key's rate limit back, for requests
+// whose downstream call failed. The key's refund limit caps the units
+// refunded per window; fewer units than asked for are refunded once it, or
+// the window's count, is reached. The refund itself isn't counted.
+func (h *Handler) RefundRateLimit(c *gin.Context) {
+	apiKey, exists := c.Get("api_key")
+	if !exists {
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
+			"error": "API key not found in context",
+		}))
+		return
+	}
+	apiKeyRecord := apiKey.(*database.APIKey)
+
+	var request refundRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			status, body := middleware.InvalidBody(c, err)
+			respond(c, status, body)
+			return
+		}
+	}
+	if request.Units == 0 {
+		request.Units = 1
+	}
+
+	refund, err := h.rateLimitService.RefundRateLimit(c.Request.Context(), apiKeyRecord, request.Units)
+	if err != nil {
+		if errors.Is(err, services.ErrRefundsNotAllowed) {
+			respond(c, http.StatusForbidden, middleware.ErrorBody(c, gin.H{
+				"error":   "Refunds not allowed",
+				"message": "This API key may not refund rate limit units",
+			}))
+			return
+		}
+		respond(c, http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to refund rate limit",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"refunded":   refund.Refunded,
+		"rate_limit": rateLimitBody(refund.Status),
+	})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRateLimitService := &MockRateLimitService{}
+	handler := NewHandler(&MockAPIKeyService{}, mockRateLimitService)
+
+	apiKey := createTestAPIKey()
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key", apiKey)
+		c.Next()
+	})
+	handler.SetupRoutes(router)
+
+	refund := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/api/rate-limit/refund", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a body a single unit is refunded
+	mockRateLimitService.On("RefundRateLimit", mock.Anything, apiKey, int64(1)).Return(&services.RefundResult{Refunded: 1, Status: createTestRateLimitResult()}, nil).Once()
+	w := refund("")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Refunded  int64 `json:"refunded"`
+		RateLimit struct {
+			Remaining int64 `json:"remaining"`
+		} `json:"rate_limit"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(1), response.Refunded)
+	assert.Equal(t, int64(99), response.RateLimit.Remaining)
+
+	mockRateLimitService.On("RefundRateLimit", mock.Anything, apiKey, int64(5)).Return(nil, services.ErrRefundsNotAllowed).Once()
+	w = refund(`{"units": 5}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Refunds not allowed")
+
+	w = refund(`{"units": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockRateLimitService.AssertExpectations(t)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reservationRequest is the number of units to reserve and, in seconds,
+// how long to hold them uncommitted; 5 minutes when unset
+type reservationRequest struct {
+	Units     int64 `json:"units" binding:"required,gte=1"`
+	ExpiresIn int   `json:"expires_in" binding:"gte=0"`
+}
+
+// ReserveRateLimit holds units of the key's rate limit for a long-running
+// job, which commits the reservation once it starts or cancels it to give
+// the units back. Reservations neither committed nor cancelled in time are
+// released. The request itself isn't counted, but the units are taken from
+// the plan quota and the organization's ceiling as well as the window.
+func (h *Handler) ReserveRateLimit(c *gin.Context) {
+	apiKeyRecord, ok := apiKeyFromContext(c)
+	if !ok {
+		return
+	}
+
+	var request reservationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		status, body := middleware.InvalidBody(c, err)
+		respond(c, status, body)
+		return
+	}
+
+	result, err := h.rateLimitService.ReserveRateLimit(c.Request.Context(), apiKeyRecord, request.Units, time.Duration(request.ExpiresIn)*time.Second)
+	if err != nil {
+		if errors.Is(err, services.ErrReservationTooLarge) {
+			respond(c, http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+				"error":   "Reservation too large",
+				"message": "A reservation can't hold more units than the key's rate limit, plan quota or organization limit",
+			}))
+			return
+		}
+		if errors.Is(err, services.ErrReservationScopedToken) {
+			respond(c, http.StatusForbidden, middleware.ErrorBody(c, gin.H{
+				"error":   "API key required",
+				"message": "Access tokens with a rate limit of their own can't reserve units",
+			}))
+			return
+		}
+		respond(c, http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to reserve rate limit",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	if result.Reservation == nil {
+		title, message := "Rate limit exceeded", "The current window has too few units left for this reservation. Please try again later."
+		switch result.LimitedBy {
+		case "quota":
+			title, message = "Quota exceeded", "The plan quota has too few units left for this reservation."
+		case "organization":
+			title, message = "Organization rate limit exceeded", "Your organization has too few units left across all of its API keys for this reservation. Please try again later."
+		}
+		respond(c, http.StatusTooManyRequests, middleware.ErrorBody(c, gin.H{
+			"error":       title,
+			"message":     message,
+			"retry_after": int(result.Status.ResetTime.Sub(h.now()).Seconds()),
+		}))
+		return
+	}
+
+	respond(c, http.StatusCreated, gin.H{
+		"reservation": gin.H{
+			"id":         result.Reservation.ID,
+			"units":      result.Reservation.Units,
+			"expires_at": result.Reservation.ExpiresAt,
+		},
+		"rate_limit": rateLimitBody(result.Status),
+	})
+}
+
+// CommitReservation keeps the units of a reservation counted
+func (h *Handler) CommitReservation(c *gin.Context) {
+	h.settleReservation(c, "committed", h.rateLimitService.CommitReservation)
+}
+
+// CancelReservation gives the units of a reservation back
+func (h *Handler) CancelReservation(c *gin.Context) {
+	h.settleReservation(c, "released", h.rateLimitService.CancelReservation)
+}
+
+// settleReservation commits or cancels the reservation :id, reporting its
+// units under field
+func (h *Handler) settleReservation(c *gin.Context, field string, settle func(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error)) {
+	apiKeyRecord, ok := apiKeyFromContext(c)
+	if !ok {
+		return
+	}
+
+	settled, err := settle(c.Request.Context(), apiKeyRecord, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrReservationNotFound) {
+			respond(c, http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "Reservation not found",
+				"message": "The reservation doesn't exist, was already committed or cancelled, or has expired",
+			}))
+			return
+		}
+		respond(c, http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to settle reservation",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		field:        settled.Units,
+		"rate_limit": rateLimitBody(settled.Status),
+	})
+}
+
+// apiKeyFromContext returns the key the request authenticated with,
+// responding with an error when there is none
+func apiKeyFromContext(c *gin.Context) (*database.APIKey, bool) {
+	apiKey, exists := c.Get("api_key")
+	if !exists {
+		respond(c, http.StatusUnauthorized, middleware.ErrorBody(c, gin.H{
+			"error": "API key not found in context",
+		}))
+		return nil, false
+	}
+	return apiKey.(*database.APIKey), true
+}
+
+// rateLimitBody is the rate limit reported after a change to it
+func rateLimitBody(status *services.RateLimitResult) gin.H {
+	return gin.H{
+		"limit":      status.Limit,
+		"remaining":  status.Remaining,
+		"reset_time": status.ResetTime,
+		"allowed":    status.Allowed,
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockRateLimitService := &MockRateLimitService{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	handler := NewHandler(&MockAPIKeyService{}, mockRateLimitService, WithClock(func() time.Time { return now }))
+
+	apiKey := createTestAPIKey()
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key", apiKey)
+		c.Next()
+	})
+	handler.SetupRoutes(router)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	reservation := &services.Reservation{ID: "rsv_1", Units: 5, ExpiresAt: time.Now().Add(time.Minute)}
+	mockRateLimitService.On("ReserveRateLimit", mock.Anything, apiKey, int64(5), time.Minute).
+		Return(&services.ReservationResult{Reservation: reservation, Status: createTestRateLimitResult()}, nil).Once()
+	w := send("POST", "/v1/api/rate-limit/reservations", `{"units": 5, "expires_in": 60}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Reservation struct {
+			ID    string `json:"id"`
+			Units int64  `json:"units"`
+		} `json:"reservation"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "rsv_1", response.Reservation.ID)
+	assert.Equal(t, int64(5), response.Reservation.Units)
+
+	// Without room for the units the reservation is refused
+	refused := createTestRateLimitResult()
+	refused.Allowed = false
+	refused.ResetTime = now.Add(30 * time.Second)
+	mockRateLimitService.On("ReserveRateLimit", mock.Anything, apiKey, int64(50), time.Duration(0)).
+		Return(&services.ReservationResult{Status: refused}, nil).Once()
+	w = send("POST", "/v1/api/rate-limit/reservations", `{"units": 50}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"retry_after":30`)
+
+	// So is one the organization's ceiling has no room for
+	mockRateLimitService.On("ReserveRateLimit", mock.Anything, apiKey, int64(40), time.Duration(0)).
+		Return(&services.ReservationResult{Status: refused, LimitedBy: "organization"}, nil).Once()
+	w = send("POST", "/v1/api/rate-limit/reservations", `{"units": 40}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "Organization rate limit exceeded")
+
+	// Tokens scoped to a limit of their own can't reserve
+	mockRateLimitService.On("ReserveRateLimit", mock.Anything, apiKey, int64(3), time.Duration(0)).Return(nil, services.ErrReservationScopedToken).Once()
+	w = send("POST", "/v1/api/rate-limit/reservations", `{"units": 3}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockRateLimitService.On("ReserveRateLimit", mock.Anything, apiKey, int64(500), time.Duration(0)).Return(nil, services.ErrReservationTooLarge).Once()
+	w = send("POST", "/v1/api/rate-limit/reservations", `{"units": 500}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("POST", "/v1/api/rate-limit/reservations", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockRateLimitService.On("CommitReservation", mock.Anything, apiKey, "rsv_1").
+		Return(&services.SettledReservation{Units: 5, Status: createTestRateLimitResult()}, nil).Once()
+	w = send("POST", "/v1/api/rate-limit/reservations/rsv_1/commit", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"committed":5`)
+
+	mockRateLimitService.On("CancelReservation", mock.Anything, apiKey, "rsv_1").Return(nil, services.ErrReservationNotFound).Once()
+	w = send("DELETE", "/v1/api/rate-limit/reservations/rsv_1", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockRateLimitService.AssertExpectations(t)
+}
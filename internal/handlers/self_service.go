@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// selfServiceEnabled reports whether the /me endpoints are served: they need
+// organizations and admin credentials scoped to them
+func (h *Handler) selfServiceEnabled() bool {
+	return h.organizationService != nil && len(h.adminAuthenticators) > 0
+}
+
+// registerSelfServiceEndpoints registers the endpoints where a customer,
+// authenticated with a credential scoped to their organization, manages the
+// organization's keys without the admin API
+func (h *Handler) registerSelfServiceEndpoints(me gin.IRouter) {
+	me.Use(middleware.RequireOrganization())
+
+	me.GET("/api-keys", h.authorize(middleware.RoleViewer, h.ListAPIKeys)...)
+	me.POST("/api-keys", h.authorize(middleware.RoleOperator, h.CreateOwnAPIKey)...)
+	me.PATCH("/api-keys/:key", h.authorize(middleware.RoleOperator, h.RenameAPIKey)...)
+	me.DELETE("/api-keys/:key", h.authorize(middleware.RoleOperator, h.DeleteAPIKey)...)
+}
+
+type selfServiceKeyRequest struct {
+	Name      string `json:"name" binding:"required,max=255"`
+	ProjectID string `json:"project_id" binding:"required"`
+
+	ExpiresAt      *time.Time `json:"expires_at"`
+	AllowedCIDRs   []string   `json:"allowed_cidrs"`
+	AllowedOrigins []string   `json:"allowed_origins"`
+}
+
+// CreateOwnAPIKey creates a key in one of the caller's projects. Its limits
+// are those of the organization's plan, and the organization may not hold
+// more than its max_keys active keys.
+func (h *Handler) CreateOwnAPIKey(c *gin.Context) {
+	var request selfServiceKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	organization, err := h.organizationService.GetOrganization(c.Request.Context(), middleware.AdminOrganization(c))
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "Organization not found",
+				"message": services.ErrOrganizationNotFound.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get organization",
+			"message": err.Error(),
+		}))
+		return
+	}
+	if organization.PlanID == "" {
+		c.JSON(http.StatusForbidden, middleware.ErrorBody(c, gin.H{
+			"error":   "Self-service not enabled",
+			"message": "The organization has no plan for the keys it creates",
+		}))
+		return
+	}
+
+	createRequest := createAPIKeyRequest{
+		Name:           request.Name,
+		PlanID:         organization.PlanID,
+		ProjectID:      request.ProjectID,
+		ExpiresAt:      request.ExpiresAt,
+		AllowedCIDRs:   request.AllowedCIDRs,
+		AllowedOrigins: request.AllowedOrigins,
+	}
+	params, rejected := h.apiKeyParams(c, &createRequest)
+	if rejected != nil {
+		c.JSON(rejected.status, middleware.ErrorBody(c, gin.H{
+			"error":   rejected.title,
+			"message": rejected.message,
+		}))
+		return
+	}
+	params.OrganizationID = organization.ID
+	params.MaxOrganizationKeys = organization.MaxKeys
+
+	apiKey, err := h.apiKeyService.CreateAPIKey(c.Request.Context(), params)
+	if err != nil {
+		if errors.Is(err, services.ErrKeyLimitReached) {
+			c.JSON(http.StatusConflict, middleware.ErrorBody(c, gin.H{
+				"error":   "Key limit reached",
+				"message": fmt.Sprintf("The organization's plan allows %d active keys", organization.MaxKeys),
+				"limit":   organization.MaxKeys,
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to create API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdAPIKey(apiKey, &createRequest, params))
+}
+
+type renameAPIKeyRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// RenameAPIKey changes the name of a key
+func (h *Handler) RenameAPIKey(c *gin.Context) {
+	var request renameAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+
+	apiKey, err := h.apiKeyService.RenameAPIKey(c.Request.Context(), c.Param("key"), request.Name)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to rename API key",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": apiKey,
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateOwnAPIKey(t *testing.T) {
+	router, mockAPIKeyService, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("GetOrganization", mock.Anything, "org-acme").Return(&database.Organization{ID: "org-acme", PlanID: "plan-free", MaxKeys: 2}, nil)
+	mockOrganizationService.On("GetProject", mock.Anything, "project-acme").Return(&database.Project{ID: "project-acme", OrganizationID: "org-acme"}, nil)
+	params := services.CreateAPIKeyParams{Name: "Checkout", PlanID: "plan-free", ProjectID: "project-acme", OrganizationID: "org-acme", MaxOrganizationKeys: 2}
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, params).Return("ak_new", nil).Once()
+
+	w := organizationRequestAs(router, "POST", "/v1/me/api-keys", "acme-token", selfServiceKeyRequest{Name: "Checkout", ProjectID: "project-acme"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"api_key":"ak_new"`)
+
+	// The service counts the active keys under a lock on the organization
+	mockAPIKeyService.On("CreateAPIKey", mock.Anything, params).Return("", services.ErrKeyLimitReached)
+
+	w = organizationRequestAs(router, "POST", "/v1/me/api-keys", "acme-token", selfServiceKeyRequest{Name: "Checkout", ProjectID: "project-acme"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"limit":2`)
+	mockAPIKeyService.AssertNumberOfCalls(t, "CreateAPIKey", 2)
+}
+
+func TestCreateOwnAPIKey_RequiresPlan(t *testing.T) {
+	router, mockAPIKeyService, mockOrganizationService := setupOrganizationTestRouter(t)
+
+	mockOrganizationService.On("GetOrganization", mock.Anything, "org-acme").Return(&database.Organization{ID: "org-acme"}, nil)
+
+	w := organizationRequestAs(router, "POST", "/v1/me/api-keys", "acme-token", selfServiceKeyRequest{Name: "Checkout", ProjectID: "project-acme"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Self-service not enabled")
+	mockAPIKeyService.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestSelfService_RequiresOrganizationCredential(t *testing.T) {
+	router, mockAPIKeyService, _ := setupOrganizationTestRouter(t)
+
+	w := organizationRequestAs(router, "GET", "/v1/me/api-keys", "platform-token", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = organizationRequestAs(router, "GET", "/v1/me/api-keys", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "ListAPIKeys", mock.Anything, mock.Anything)
+}
+
+func TestSelfService_KeysOfOtherOrganizations(t *testing.T) {
+	router, mockAPIKeyService, _ := setupOrganizationTestRouter(t)
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "ak_globex").Return(&database.APIKey{ID: "globex-key", OrganizationID: "org-globex"}, nil)
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "ak_acme").Return(&database.APIKey{ID: "acme-key", OrganizationID: "org-acme"}, nil)
+	mockAPIKeyService.On("RenameAPIKey", mock.Anything, "ak_acme", "Storefront").Return(&database.APIKey{ID: "acme-key", Name: "Storefront", OrganizationID: "org-acme"}, nil)
+
+	w := organizationRequestAs(router, "PATCH", "/v1/me/api-keys/ak_globex", "acme-token", renameAPIKeyRequest{Name: "Storefront"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = organizationRequestAs(router, "DELETE", "/v1/me/api-keys/ak_globex", "acme-token", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "RenameAPIKey", mock.Anything, "ak_globex", mock.Anything)
+	mockAPIKeyService.AssertNotCalled(t, "DeleteAPIKey", mock.Anything, "ak_globex")
+
+	w = organizationRequestAs(router, "PATCH", "/v1/me/api-keys/ak_acme", "acme-token", renameAPIKeyRequest{Name: "Storefront"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"Storefront"`)
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportColumns are the CSV columns of an export, in order. List columns
+// hold space-separated values.
+var exportColumns = []string{
+	"id", "key_hash", "hash_version", "key_prefix", "name", "is_active",
+	"rate_limit_requests", "rate_limit_window_seconds", "plan",
+	"end_user_limit_requests", "end_user_limit_window_seconds",
+	"allowed_cidrs", "allowed_origins", "require_signature", "parent_id",
+	"owner_name", "owner_email", "expires_at", "created_at", "deleted_at",
+}
+
+type importRequest struct {
+	APIKeys []*database.ExportedAPIKey `json:"api_keys" binding:"required"`
+}
+
+// ExportAPIKeys downloads every key with its stored hash, as JSON or, with
+// format=csv, as CSV. Signing secrets are never exported.
+func (h *Handler) ExportAPIKeys(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid format",
+			"message": "format must be json or csv",
+		}))
+		return
+	}
+
+	apiKeys, err := h.apiKeyService.ExportAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to export API keys",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	exportedAt := h.now().UTC()
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="api-keys-%s.%s"`, exportedAt.Format("20060102T150405Z"), format))
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"exported_at": exportedAt,
+			"api_keys":    apiKeys,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := writeExportCSV(c.Writer, apiKeys); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// ImportAPIKeys restores keys from an export, sent as JSON or as CSV with
+// Content-Type text/csv. With dry_run=true the keys are only checked. If any
+// key can't be imported, nothing is and the response lists every problem.
+func (h *Handler) ImportAPIKeys(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid dry_run",
+			"message": "dry_run must be true or false",
+		}))
+		return
+	}
+
+	var apiKeys []*database.ExportedAPIKey
+	if c.ContentType() == "text/csv" {
+		apiKeys, err = readImportCSV(c.Request.Body)
+	} else {
+		var request importRequest
+		if err = c.ShouldBindJSON(&request); err == nil {
+			apiKeys = request.APIKeys
+		}
+	}
+	if err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	for i, key := range apiKeys {
+		if key == nil {
+			c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+				"error":   "Invalid request",
+				"message": fmt.Sprintf("api_keys[%d] must be an object", i),
+			}))
+			return
+		}
+	}
+
+	report, err := h.apiKeyService.ImportAPIKeys(c.Request.Context(), apiKeys, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to import API keys",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	if len(report.Errors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid API keys",
+			"message": fmt.Sprintf("%d of %d keys can't be imported; nothing was imported", len(report.Errors), len(apiKeys)),
+			"report":  report,
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func writeExportCSV(w io.Writer, apiKeys []*database.ExportedAPIKey) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, key := range apiKeys {
+		expiresAt, deletedAt := "", ""
+		if key.ExpiresAt != nil {
+			expiresAt = key.ExpiresAt.UTC().Format(time.RFC3339Nano)
+		}
+		if key.DeletedAt != nil {
+			deletedAt = key.DeletedAt.UTC().Format(time.RFC3339Nano)
+		}
+		record := []string{
+			key.ID, key.KeyHash, strconv.Itoa(key.HashVersion), key.KeyPrefix, key.Name, strconv.FormatBool(key.IsActive),
+			strconv.Itoa(key.RateLimitRequests), strconv.Itoa(key.RateLimitWindowSeconds), key.Plan,
+			strconv.Itoa(key.EndUserLimitRequests), strconv.Itoa(key.EndUserLimitWindowSeconds),
+			strings.Join(key.AllowedCIDRs, " "), strings.Join(key.AllowedOrigins, " "), strconv.FormatBool(key.RequireSignature), key.ParentID,
+			key.OwnerName, key.OwnerEmail, expiresAt, key.CreatedAt.UTC().Format(time.RFC3339Nano), deletedAt,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// readImportCSV parses a CSV export. Columns are matched by the header, so
+// they may come in any order; id, key_hash, name and created_at are
+// required.
+func readImportCSV(r io.Reader) ([]*database.ExportedAPIKey, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	known := make(map[string]bool, len(exportColumns))
+	for _, column := range exportColumns {
+		known[column] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if !known[column] {
+			return nil, fmt.Errorf("unknown CSV column %q", column)
+		}
+		columns[column] = i
+	}
+	for _, column := range []string{"id", "key_hash", "name", "created_at"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("CSV column %q is required", column)
+		}
+	}
+
+	apiKeys := []*database.ExportedAPIKey{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return apiKeys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseCSVKey(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		apiKeys = append(apiKeys, key)
+	}
+}
+
+func parseCSVKey(record []string, columns map[string]int) (*database.ExportedAPIKey, error) {
+	field := func(column string) string {
+		if i, ok := columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var parseErr error
+	number := func(column string) int {
+		value := field(column)
+		if value == "" || parseErr != nil {
+			return 0
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			parseErr = fmt.Errorf("%s must be a number", column)
+		}
+		return n
+	}
+	boolean := func(column string, fallback bool) bool {
+		value := field(column)
+		if value == "" || parseErr != nil {
+			return fallback
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			parseErr = fmt.Errorf("%s must be true or false", column)
+		}
+		return b
+	}
+	timestamp := func(column string) *time.Time {
+		value := field(column)
+		if value == "" || parseErr != nil {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			parseErr = fmt.Errorf("%s must be an RFC 3339 time", column)
+		}
+		return &t
+	}
+
+	key := &database.ExportedAPIKey{
+		ID:                        field("id"),
+		KeyHash:                   field("key_hash"),
+		HashVersion:               number("hash_version"),
+		KeyPrefix:                 field("key_prefix"),
+		Name:                      field("name"),
+		IsActive:                  boolean("is_active", true),
+		RateLimitRequests:         number("rate_limit_requests"),
+		RateLimitWindowSeconds:    number("rate_limit_window_seconds"),
+		Plan:                      field("plan"),
+		EndUserLimitRequests:      number("end_user_limit_requests"),
+		EndUserLimitWindowSeconds: number("end_user_limit_window_seconds"),
+		AllowedCIDRs:              strings.Fields(field("allowed_cidrs")),
+		AllowedOrigins:            strings.Fields(field("allowed_origins")),
+		RequireSignature:          boolean("require_signature", false),
+		ParentID:                  field("parent_id"),
+		OwnerName:                 field("owner_name"),
+		OwnerEmail:                field("owner_email"),
+		ExpiresAt:                 timestamp("expires_at"),
+		DeletedAt:                 timestamp("deleted_at"),
+	}
+	if createdAt := timestamp("created_at"); createdAt != nil {
+		key.CreatedAt = *createdAt
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return key, nil
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestExportedKey() *database.ExportedAPIKey {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &database.ExportedAPIKey{
+		ID:                     "4f0c2a1e-8d3b-4c5a-9e7f-1a2b3c4d5e6f",
+		KeyHash:                "hash-123",
+		HashVersion:            1,
+		KeyPrefix:              "ak_abc",
+		Name:                   "Exported Key",
+		IsActive:               true,
+		RateLimitRequests:      100,
+		RateLimitWindowSeconds: 60,
+		AllowedCIDRs:           []string{"10.0.0.0/8", "192.168.0.0/16"},
+		OwnerEmail:             "owner@example.com",
+		ExpiresAt:              &expiresAt,
+		CreatedAt:              time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}
+}
+
+func TestExportAPIKeys_JSON(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ExportAPIKeys", mock.Anything).Return([]*database.ExportedAPIKey{createTestExportedKey()}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".json")
+
+	var response struct {
+		APIKeys []*database.ExportedAPIKey `json:"api_keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.APIKeys, 1)
+	assert.Equal(t, "hash-123", response.APIKeys[0].KeyHash)
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestExportAPIKeys_Untagged(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ExportAPIKeys", mock.Anything).Return([]*database.ExportedAPIKey{createTestExportedKey()}, nil)
+
+	// Exports are streamed rather than buffered to be tagged, so even a
+	// wildcard If-None-Match gets the whole file
+	req, _ := http.NewRequest("GET", "/admin/export?format=csv", nil)
+	req.Header.Set("If-None-Match", "*")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func TestExportAPIKeys_CSVRoundTrip(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	exported := createTestExportedKey()
+	mockAPIKeyService.On("ExportAPIKeys", mock.Anything).Return([]*database.ExportedAPIKey{exported}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/export?format=csv", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, exportColumns, records[0])
+
+	// The export imports back to the same keys
+	mockAPIKeyService.On("ImportAPIKeys", mock.Anything, mock.MatchedBy(func(keys []*database.ExportedAPIKey) bool {
+		if len(keys) != 1 {
+			return false
+		}
+		key := keys[0]
+		return key.ID == exported.ID && key.KeyHash == exported.KeyHash && key.IsActive &&
+			key.RateLimitRequests == 100 && assert.ObjectsAreEqual(exported.AllowedCIDRs, key.AllowedCIDRs) &&
+			key.ExpiresAt.Equal(*exported.ExpiresAt) && key.CreatedAt.Equal(exported.CreatedAt)
+	}), true).Return(&services.ImportReport{DryRun: true, Imported: 1, Skipped: []string{}, Errors: []services.ImportError{}}, nil)
+
+	req, _ = http.NewRequest("POST", "/admin/import?dry_run=true", bytes.NewBufferString(w.Body.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestExportAPIKeys_InvalidFormat(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/export?format=xml", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "ExportAPIKeys", mock.Anything)
+}
+
+func TestImportAPIKeys_Success(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ImportAPIKeys", mock.Anything, mock.Anything, false).
+		Return(&services.ImportReport{Imported: 1, Skipped: []string{}, Errors: []services.ImportError{}}, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"api_keys": []*database.ExportedAPIKey{createTestExportedKey()}})
+	req, _ := http.NewRequest("POST", "/admin/import", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(1), response["imported"])
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestImportAPIKeys_Rejected(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	mockAPIKeyService.On("ImportAPIKeys", mock.Anything, mock.Anything, false).Return(&services.ImportReport{
+		Skipped: []string{},
+		Errors:  []services.ImportError{{Index: 0, ID: "4f0c2a1e-8d3b-4c5a-9e7f-1a2b3c4d5e6f", Message: "key hash belongs to key other"}},
+	}, nil)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"api_keys": []*database.ExportedAPIKey{createTestExportedKey()}})
+	req, _ := http.NewRequest("POST", "/admin/import", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "key hash belongs to key other")
+
+	mockAPIKeyService.AssertExpectations(t)
+}
+
+func TestImportAPIKeys_InvalidCSV(t *testing.T) {
+	router, mockAPIKeyService, _, _ := setupTestRouter()
+
+	req, _ := http.NewRequest("POST", "/admin/import", strings.NewReader("id,secret\nabc,def\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "ImportAPIKeys", mock.Anything, mock.Anything, mock.Anything)
+}
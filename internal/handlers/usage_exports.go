@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type usageExportRequest struct {
+	// The usage logs created from From up to To are exported
+	From time.Time `json:"from" binding:"required"`
+	To   time.Time `json:"to" binding:"required"`
+	// Only this key's usage; every key's by default
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// csv, the default, is the only format
+	Format string `json:"format,omitempty"`
+}
+
+// WithExportService enables the usage export endpoints
+func WithExportService(exportService services.ExportServiceInterface) Option {
+	return func(h *Handler) {
+		h.exportService = exportService
+	}
+}
+
+// CreateUsageExport requests a report of the usage logs of a period. The
+// report is generated in the background: the export is returned pending,
+// with its status URL in the Location header.
+func (h *Handler) CreateUsageExport(c *gin.Context) {
+	var request usageExportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	params := services.CreateExportParams{
+		From:     request.From,
+		To:       request.To,
+		APIKeyID: request.APIKeyID,
+		Format:   request.Format,
+	}
+	if params.Format == "" {
+		params.Format = services.ExportCSV
+	}
+	if err := services.ValidateExport(params); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		}))
+		return
+	}
+
+	if params.APIKeyID != "" {
+		apiKey, err := h.apiKeyService.GetAPIKey(c.Request.Context(), params.APIKeyID)
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+				"error":   "Invalid request",
+				"message": "api_key_id does not name an API key",
+			}))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+				"error":   "Failed to get API key",
+				"message": err.Error(),
+			}))
+			return
+		}
+		params.APIKeyID = apiKey.ID
+	}
+
+	export, err := h.exportService.CreateExport(c.Request.Context(), params)
+	if err != nil {
+		h.exportError(c, "Failed to create export", err)
+		return
+	}
+
+	c.Header("Location", c.Request.URL.Path+"/"+export.ID)
+	c.JSON(http.StatusAccepted, gin.H{"export": export})
+}
+
+// GetUsageExport returns the status of an export
+func (h *Handler) GetUsageExport(c *gin.Context) {
+	export, err := h.exportService.GetExport(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.exportError(c, "Failed to get export", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"export": export})
+}
+
+// DownloadUsageExport serves the report of a completed export, answering
+// 409 Conflict while it is still being generated or when it failed
+func (h *Handler) DownloadUsageExport(c *gin.Context) {
+	export, content, err := h.exportService.ExportContent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrExportNotReady) {
+		c.JSON(http.StatusConflict, middleware.ErrorBody(c, gin.H{
+			"error":   "Export not ready",
+			"message": fmt.Sprintf("The export is %s", export.Status),
+			"export":  export,
+		}))
+		return
+	}
+	if err != nil {
+		h.exportError(c, "Failed to download export", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.%s"`,
+		export.From.UTC().Format("20060102T150405Z"), export.To.UTC().Format("20060102T150405Z"), export.Format))
+	c.DataFromReader(http.StatusOK, export.SizeBytes, "text/csv; charset=utf-8", content, nil)
+}
+
+func (h *Handler) exportError(c *gin.Context, title string, err error) {
+	if errors.Is(err, services.ErrExportNotFound) {
+		c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+			"error":   "Export not found",
+			"message": err.Error(),
+		}))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+		"error":   title,
+		"message": err.Error(),
+	}))
+}
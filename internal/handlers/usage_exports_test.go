@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockExportService is a mock implementation of ExportServiceInterface
+type MockExportService struct {
+	mock.Mock
+}
+
+func (m *MockExportService) CreateExport(ctx context.Context, params services.CreateExportParams) (*database.UsageExport, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.UsageExport), args.Error(1)
+}
+
+func (m *MockExportService) GetExport(ctx context.Context, id string) (*database.UsageExport, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.UsageExport), args.Error(1)
+}
+
+func (m *MockExportService) ExportContent(ctx context.Context, id string) (*database.UsageExport, io.Reader, error) {
+	args := m.Called(ctx, id)
+	var export *database.UsageExport
+	if args.Get(0) != nil {
+		export = args.Get(0).(*database.UsageExport)
+	}
+	var content io.Reader
+	if args.Get(1) != nil {
+		content = args.Get(1).(io.Reader)
+	}
+	return export, content, args.Error(2)
+}
+
+func setupExportTestRouter() (*gin.Engine, *MockAPIKeyService, *MockExportService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockExportService := &MockExportService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithExportService(mockExportService))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockAPIKeyService, mockExportService
+}
+
+func TestCreateUsageExport(t *testing.T) {
+	router, mockAPIKeyService, mockExportService := setupExportTestRouter()
+
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-id-123").Return(createTestAPIKey(), nil)
+	mockExportService.On("CreateExport", mock.Anything, services.CreateExportParams{From: from, To: to, APIKeyID: "test-id-123", Format: "csv"}).
+		Return(&database.UsageExport{ID: "export-1", Status: services.ExportPending, Format: "csv", From: from, To: to}, nil)
+
+	body, _ := json.Marshal(gin.H{"from": from, "to": to, "api_key_id": "test-id-123"})
+	req, _ := http.NewRequest("POST", "/admin/exports", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/admin/exports/export-1", w.Header().Get("Location"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "pending", response["export"].(map[string]interface{})["status"])
+	mockExportService.AssertExpectations(t)
+}
+
+func TestCreateUsageExport_InvalidRequest(t *testing.T) {
+	router, mockAPIKeyService, mockExportService := setupExportTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "missing").Return(nil, services.ErrAPIKeyNotFound)
+
+	for _, body := range []string{
+		`{"to": "2025-07-01T00:00:00Z"}`,
+		`{"from": "2025-07-01T00:00:00Z", "to": "2025-06-01T00:00:00Z"}`,
+		`{"from": "2025-06-01T00:00:00Z", "to": "2025-07-01T00:00:00Z", "format": "xlsx"}`,
+		`{"from": "2025-06-01T00:00:00Z", "to": "2025-07-01T00:00:00Z", "api_key_id": "missing"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/admin/exports", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	mockExportService.AssertNotCalled(t, "CreateExport", mock.Anything, mock.Anything)
+}
+
+func TestGetUsageExport_NotFound(t *testing.T) {
+	router, _, mockExportService := setupExportTestRouter()
+
+	mockExportService.On("GetExport", mock.Anything, "missing").Return(nil, services.ErrExportNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/exports/missing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDownloadUsageExport(t *testing.T) {
+	router, _, mockExportService := setupExportTestRouter()
+
+	export := &database.UsageExport{
+		ID:        "export-1",
+		Status:    services.ExportCompleted,
+		Format:    "csv",
+		From:      time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC),
+		SizeBytes: 22,
+	}
+	mockExportService.On("ExportContent", mock.Anything, "export-1").Return(export, strings.NewReader("created_at,api_key_id\n"), nil)
+
+	req, _ := http.NewRequest("GET", "/admin/exports/export-1/download", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="usage-20250601T000000Z-20250701T000000Z.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "22", w.Header().Get("Content-Length"))
+	assert.Equal(t, "created_at,api_key_id\n", w.Body.String())
+}
+
+func TestDownloadUsageExport_NotReady(t *testing.T) {
+	router, _, mockExportService := setupExportTestRouter()
+
+	mockExportService.On("ExportContent", mock.Anything, "export-1").Return(&database.UsageExport{ID: "export-1", Status: services.ExportRunning}, nil, services.ErrExportNotReady)
+
+	req, _ := http.NewRequest("GET", "/admin/exports/export-1/download", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "The export is running")
+}
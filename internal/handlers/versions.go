@@ -0,0 +1,23 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+// CurrentAPIVersion is the path prefix unversioned requests are pointed to
+const CurrentAPIVersion = "/v1"
+
+// APIVersion is one version of the HTTP API, served under its own path
+// prefix. Versions are registered side by side, so a new version can change
+// its endpoints while clients migrate from the previous one.
+type APIVersion struct {
+	Prefix      string
+	API         func(api gin.IRouter)
+	Admin       func(admin gin.IRouter)
+	SelfService func(me gin.IRouter)
+}
+
+// versions lists the API versions currently served
+func (h *Handler) versions() []APIVersion {
+	return []APIVersion{
+		{Prefix: "/v1", API: h.registerAPIEndpoints, Admin: h.registerAdminEndpoints, SelfService: h.registerSelfServiceEndpoints},
+	}
+}
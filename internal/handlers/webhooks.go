@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type webhookRequest struct {
+	// Where the key's alerts are POSTed, an http:// or https:// URL
+	URL string `json:"url" binding:"required,url"`
+}
+
+// WithWebhookService enables the endpoints managing keys' limit alert
+// webhooks
+func WithWebhookService(webhookService services.WebhookServiceInterface) Option {
+	return func(h *Handler) {
+		h.webhookService = webhookService
+	}
+}
+
+func (h *Handler) GetWebhook(c *gin.Context) {
+	apiKey, ok := h.pathKey(c)
+	if !ok {
+		return
+	}
+
+	webhook, err := h.webhookService.GetWebhook(c.Request.Context(), apiKey.ID)
+	if err != nil {
+		h.webhookError(c, "Failed to get webhook", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+// SetWebhook sends a key's limit alerts to a URL. Every call generates a new
+// signing secret, which is only returned here.
+func (h *Handler) SetWebhook(c *gin.Context) {
+	var request webhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(middleware.InvalidBody(c, err))
+		return
+	}
+	if parsed, err := url.Parse(request.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": "url must be an http:// or https:// URL",
+		}))
+		return
+	}
+
+	apiKey, ok := h.pathKey(c)
+	if !ok {
+		return
+	}
+	// Sub-keys share their parent's limits, so alerts are raised for the parent
+	if apiKey.ParentID != "" {
+		c.JSON(http.StatusBadRequest, middleware.ErrorBody(c, gin.H{
+			"error":   "Invalid request",
+			"message": "Alerts of sub-keys are sent to their parent key's webhook",
+		}))
+		return
+	}
+
+	webhook, err := h.webhookService.SetWebhook(c.Request.Context(), apiKey.ID, request.URL)
+	if err != nil {
+		h.webhookError(c, "Failed to set webhook", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	apiKey, ok := h.pathKey(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.DeleteWebhook(c.Request.Context(), apiKey.ID); err != nil {
+		h.webhookError(c, "Failed to delete webhook", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// ListWebhookDeliveries returns the log of a key's alert deliveries, newest
+// first, paged, sorted and filtered like other lists
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	query, err := parseListQuery[database.WebhookDelivery](c)
+	if err != nil {
+		invalidListQuery(c, err)
+		return
+	}
+
+	apiKey, ok := h.pathKey(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(c.Request.Context(), apiKey.ID)
+	if err != nil {
+		h.webhookError(c, "Failed to list webhook deliveries", err)
+		return
+	}
+
+	page, nextCursor := applyListQuery(deliveries, query)
+	listResponse(c, "deliveries", page, nextCursor)
+}
+
+// pathKey looks up the key named by the :key path parameter, answering the
+// request when it doesn't exist
+func (h *Handler) pathKey(c *gin.Context) (*database.APIKey, bool) {
+	apiKey, err := h.apiKeyService.GetAPIKey(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+				"error":   "API key not found",
+				"message": err.Error(),
+			}))
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+			"error":   "Failed to get API key",
+			"message": err.Error(),
+		}))
+		return nil, false
+	}
+	return apiKey, true
+}
+
+func (h *Handler) webhookError(c *gin.Context, title string, err error) {
+	if errors.Is(err, services.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, middleware.ErrorBody(c, gin.H{
+			"error":   "Webhook not found",
+			"message": err.Error(),
+		}))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, middleware.ErrorBody(c, gin.H{
+		"error":   title,
+		"message": err.Error(),
+	}))
+}
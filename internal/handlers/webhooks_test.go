@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockWebhookService is a mock implementation of WebhookServiceInterface
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *services.RateLimitResult) error {
+	args := m.Called(ctx, apiKey, eventType, result)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) SetWebhook(ctx context.Context, apiKeyID, url string) (*database.Webhook, error) {
+	args := m.Called(ctx, apiKeyID, url)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookService) GetWebhook(ctx context.Context, apiKeyID string) (*database.Webhook, error) {
+	args := m.Called(ctx, apiKeyID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookService) DeleteWebhook(ctx context.Context, apiKeyID string) error {
+	args := m.Called(ctx, apiKeyID)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) ListDeliveries(ctx context.Context, apiKeyID string) ([]database.WebhookDelivery, error) {
+	args := m.Called(ctx, apiKeyID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]database.WebhookDelivery), args.Error(1)
+}
+
+func setupWebhookTestRouter() (*gin.Engine, *MockAPIKeyService, *MockWebhookService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockWebhookService := &MockWebhookService{}
+	handler := NewHandler(mockAPIKeyService, &MockRateLimitService{}, WithWebhookService(mockWebhookService))
+
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	return router, mockAPIKeyService, mockWebhookService
+}
+
+func TestSetWebhook_ReturnsSecretOnce(t *testing.T) {
+	router, mockAPIKeyService, mockWebhookService := setupWebhookTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockWebhookService.On("SetWebhook", mock.Anything, "test-id-123", "https://alerts.example.com/hook").Return(&database.Webhook{
+		APIKeyID: "test-id-123",
+		URL:      "https://alerts.example.com/hook",
+		Secret:   "s3cret",
+	}, nil)
+
+	body, _ := json.Marshal(map[string]string{"url": "https://alerts.example.com/hook"})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/test-api-key/webhook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "s3cret", response["secret"])
+	webhook := response["webhook"].(map[string]interface{})
+	assert.Equal(t, "https://alerts.example.com/hook", webhook["url"])
+	assert.NotContains(t, webhook, "secret")
+	mockWebhookService.AssertExpectations(t)
+}
+
+func TestSetWebhook_InvalidURL(t *testing.T) {
+	router, _, mockWebhookService := setupWebhookTestRouter()
+
+	for _, target := range []string{"", "alerts.example.com", "ftp://alerts.example.com/hook"} {
+		body, _ := json.Marshal(map[string]string{"url": target})
+		req, _ := http.NewRequest("PUT", "/admin/api-keys/test-api-key/webhook", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+	mockWebhookService.AssertNotCalled(t, "SetWebhook", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSetWebhook_RejectsSubKeys(t *testing.T) {
+	router, mockAPIKeyService, mockWebhookService := setupWebhookTestRouter()
+
+	subKey := createTestAPIKey()
+	subKey.ParentID = "parent-id"
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "sub-key").Return(subKey, nil)
+
+	body, _ := json.Marshal(map[string]string{"url": "https://alerts.example.com/hook"})
+	req, _ := http.NewRequest("PUT", "/admin/api-keys/sub-key/webhook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockWebhookService.AssertNotCalled(t, "SetWebhook", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetWebhook_NotFound(t *testing.T) {
+	router, mockAPIKeyService, mockWebhookService := setupWebhookTestRouter()
+
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockWebhookService.On("GetWebhook", mock.Anything, "test-id-123").Return(nil, services.ErrWebhookNotFound)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/webhook", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Webhook not found")
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	router, mockAPIKeyService, mockWebhookService := setupWebhookTestRouter()
+
+	now := time.Now()
+	mockAPIKeyService.On("GetAPIKey", mock.Anything, "test-api-key").Return(createTestAPIKey(), nil)
+	mockWebhookService.On("ListDeliveries", mock.Anything, "test-id-123").Return([]database.WebhookDelivery{
+		{ID: "d-2", EventType: "quota.exceeded", Status: services.DeliveryFailed, Attempts: 5, CreatedAt: now},
+		{ID: "d-1", EventType: "rate_limit.exceeded", Status: services.DeliveryDelivered, Attempts: 1, CreatedAt: now.Add(-time.Hour)},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/admin/api-keys/test-api-key/webhook/deliveries?status=failed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Deliveries []database.WebhookDelivery `json:"deliveries"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Deliveries, 1) {
+		assert.Equal(t, "d-2", response.Deliveries[0].ID)
+	}
+}
@@ -0,0 +1,200 @@
+// Package kafka streams per-request usage events to Kafka for analytics
+// outside the service.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// finalFlushTimeout bounds the flush at shutdown
+	finalFlushTimeout = 5 * time.Second
+	// Longest wait between retries of a batch the brokers refused
+	maxRetryDelay = 30 * time.Second
+)
+
+// UsageEvent is the JSON value of a usage message. Messages are keyed by
+// the API key ID, so each key's events stay in order on one partition.
+type UsageEvent struct {
+	APIKeyID   string    `json:"api_key_id"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	StatusCode int       `json:"status_code"`
+	Decision   string    `json:"decision"`
+	Cost       int       `json:"cost"`
+	LatencyMs  float64   `json:"latency_ms"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// messageWriter is the part of kafka-go's Writer the producer uses
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// Producer buffers usage events in memory and produces them in batches, off
+// the request path. A batch stays buffered until the brokers acknowledge it
+// and is retried until they do, so every event is delivered at least once
+// unless the buffer overflows or the service stops while Kafka is down;
+// those events are counted in usage_events_dropped_total.
+type Producer struct {
+	writer    messageWriter
+	events    chan database.UsageLog
+	full      chan struct{}
+	batchSize int
+	interval  time.Duration
+
+	// The batch the brokers last refused, sent again before anything else
+	pending    []kafkago.Message
+	retryDelay time.Duration
+	retryAt    time.Time
+}
+
+// NewProducer produces to cfg.Topic on cfg.Brokers. Connections are made
+// when the first batch is sent.
+func NewProducer(cfg config.KafkaConfig) *Producer {
+	writer := &kafkago.Writer{
+		Addr:         kafkago.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: kafkago.RequireAll,
+		MaxAttempts:  3,
+		BatchSize:    cfg.BatchSize,
+		// Batches are collected by the Producer; the writer sends them as is
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    &kafkago.Transport{ClientID: cfg.ClientID},
+	}
+	return newProducer(writer, cfg.BufferSize, cfg.BatchSize, cfg.FlushInterval)
+}
+
+func newProducer(writer messageWriter, bufferSize, batchSize int, interval time.Duration) *Producer {
+	return &Producer{
+		writer:    writer,
+		events:    make(chan database.UsageLog, bufferSize),
+		full:      make(chan struct{}, 1),
+		batchSize: batchSize,
+		interval:  interval,
+	}
+}
+
+// Log queues entry for producing and reports whether it was accepted; it
+// never blocks on Kafka
+func (p *Producer) Log(entry database.UsageLog) bool {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	select {
+	case p.events <- entry:
+	default:
+		metrics.UsageEventsDropped.WithLabelValues("buffer_full").Inc()
+		return false
+	}
+	if len(p.events) >= p.batchSize {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Run produces buffered events on every tick, or sooner once a batch is
+// full, until ctx is cancelled, then produces what is left and closes the
+// connections
+func (p *Producer) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, so the final flush gets its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			_, err := p.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				lost := len(p.pending) + len(p.events)
+				metrics.UsageEventsDropped.WithLabelValues("shutdown").Add(float64(lost))
+				logging.FromContext(ctx).Error("Final usage event flush failed", zap.Int("dropped", lost), zap.Error(err))
+			}
+			if err := p.writer.Close(); err != nil {
+				logging.FromContext(ctx).Error("Failed to close Kafka producer", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		case <-p.full:
+		}
+		if time.Now().Before(p.retryAt) {
+			continue
+		}
+		if _, err := p.Flush(ctx); err != nil {
+			logging.FromContext(ctx).Error("Usage event flush failed", zap.Duration("retry_in", p.retryDelay), zap.Error(err))
+		}
+	}
+}
+
+// Flush produces the events buffered so far in batches of up to batchSize
+// and returns how many the brokers acknowledged. A batch that fails is kept
+// for the next flush, which backs off exponentially; the events behind it
+// stay buffered.
+func (p *Producer) Flush(ctx context.Context) (int, error) {
+	produced := 0
+	for pending := len(p.events); len(p.pending) > 0 || pending > 0; {
+		if len(p.pending) == 0 {
+			size := pending
+			if size > p.batchSize {
+				size = p.batchSize
+			}
+			pending -= size
+			for len(p.pending) < size {
+				p.pending = append(p.pending, usageMessage(<-p.events))
+			}
+		}
+
+		if err := p.writer.WriteMessages(ctx, p.pending...); err != nil {
+			p.backoff()
+			return produced, fmt.Errorf("failed to produce usage events: %w", err)
+		}
+		produced += len(p.pending)
+		metrics.UsageEventsProduced.Add(float64(len(p.pending)))
+		p.pending = p.pending[:0]
+		p.retryDelay, p.retryAt = 0, time.Time{}
+	}
+	return produced, nil
+}
+
+// backoff delays the next retry of the pending batch
+func (p *Producer) backoff() {
+	if p.retryDelay == 0 {
+		p.retryDelay = p.interval
+	} else if p.retryDelay *= 2; p.retryDelay > maxRetryDelay {
+		p.retryDelay = maxRetryDelay
+	}
+	p.retryAt = time.Now().Add(p.retryDelay)
+}
+
+func usageMessage(entry database.UsageLog) kafkago.Message {
+	// Encoding a struct of plain fields can't fail
+	value, _ := json.Marshal(UsageEvent{
+		APIKeyID:   entry.APIKeyID,
+		Method:     entry.Method,
+		Route:      entry.Route,
+		StatusCode: entry.StatusCode,
+		Decision:   entry.Decision,
+		Cost:       entry.Cost,
+		LatencyMs:  float64(entry.Latency.Microseconds()) / 1000,
+		Timestamp:  entry.CreatedAt.UTC(),
+	})
+	return kafkago.Message{Key: []byte(entry.APIKeyID), Value: value, Time: entry.CreatedAt}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWriter struct {
+	batches [][]kafkago.Message
+	err     error
+	closed  bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.batches = append(w.batches, append([]kafkago.Message(nil), msgs...))
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestProducer_Flush_ProducesInBatches(t *testing.T) {
+	writer := &fakeWriter{}
+	producer := newProducer(writer, 10, 2, time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.True(t, producer.Log(database.UsageLog{
+			APIKeyID:   "key-1",
+			Method:     "GET",
+			Route:      "/api/items/:id",
+			StatusCode: 200,
+			Cost:       1,
+			Decision:   "allowed",
+			Latency:    1500 * time.Microsecond,
+		}))
+	}
+
+	produced, err := producer.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, produced)
+	require.Len(t, writer.batches, 2)
+	assert.Len(t, writer.batches[0], 2)
+	assert.Len(t, writer.batches[1], 1)
+
+	message := writer.batches[0][0]
+	assert.Equal(t, "key-1", string(message.Key))
+	var event UsageEvent
+	require.NoError(t, json.Unmarshal(message.Value, &event))
+	assert.Equal(t, "key-1", event.APIKeyID)
+	assert.Equal(t, "/api/items/:id", event.Route)
+	assert.Equal(t, "allowed", event.Decision)
+	assert.Equal(t, 1.5, event.LatencyMs)
+	assert.False(t, event.Timestamp.IsZero())
+}
+
+func TestProducer_Flush_RetriesRefusedBatch(t *testing.T) {
+	writer := &fakeWriter{err: assert.AnError}
+	producer := newProducer(writer, 10, 5, time.Second)
+	for _, id := range []string{"key-1", "key-2"} {
+		assert.True(t, producer.Log(database.UsageLog{APIKeyID: id}))
+	}
+
+	_, err := producer.Flush(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, time.Second, producer.retryDelay)
+	_, err = producer.Flush(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 2*time.Second, producer.retryDelay)
+
+	// The refused batch goes out first once the brokers are back
+	assert.True(t, producer.Log(database.UsageLog{APIKeyID: "key-3"}))
+	writer.err = nil
+	produced, err := producer.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, produced)
+	require.Len(t, writer.batches, 2)
+	assert.Equal(t, "key-1", string(writer.batches[0][0].Key))
+	assert.Equal(t, "key-3", string(writer.batches[1][0].Key))
+	assert.Zero(t, producer.retryDelay)
+}
+
+func TestProducer_Log_DropsWhenFull(t *testing.T) {
+	producer := newProducer(&fakeWriter{}, 1, 10, time.Minute)
+	assert.True(t, producer.Log(database.UsageLog{APIKeyID: "key-1"}))
+	assert.False(t, producer.Log(database.UsageLog{APIKeyID: "key-1"}))
+}
+
+func TestProducer_Run_FlushesOnShutdown(t *testing.T) {
+	writer := &fakeWriter{}
+	producer := newProducer(writer, 10, 10, time.Hour)
+	assert.True(t, producer.Log(database.UsageLog{APIKeyID: "key-1"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	producer.Run(ctx)
+
+	require.Len(t, writer.batches, 1)
+	assert.True(t, writer.closed)
+}
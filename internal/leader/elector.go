@@ -0,0 +1,188 @@
+// Package leader elects one replica to run the background jobs that must not
+// run on several replicas at once, such as the key expiry sweep, usage
+// flushes and retention. The leader is whoever holds a lease in Redis.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// releaseTimeout bounds giving up the lease at shutdown
+const releaseTimeout = 5 * time.Second
+
+// Lease is a lock that expires unless it is renewed; redis.Client is one
+type Lease interface {
+	// AcquireLease takes the lease for holder if it is free, or renews it
+	// if holder already holds it, and reports whether holder holds it
+	AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up the lease if holder still holds it
+	ReleaseLease(ctx context.Context, key, holder string) error
+}
+
+// Elector campaigns for the lease and runs its jobs while it holds it. Jobs
+// are stopped, and waited for, as soon as the lease may have passed to
+// another replica: when renewing it fails for longer than the lease lasts,
+// or when another replica holds it. If the leader dies, the lease expires
+// and another replica starts the jobs within LeaseTTL plus RenewInterval.
+type Elector struct {
+	lease    Lease
+	key      string
+	id       string
+	ttl      time.Duration
+	interval time.Duration
+
+	mu      sync.Mutex
+	jobs    []func(ctx context.Context)
+	leading atomic.Bool
+}
+
+// NewElector campaigns for cfg.Key on lease under an ID unique to this
+// process
+func NewElector(lease Lease, cfg config.LeaderElectionConfig) *Elector {
+	return &Elector{
+		lease:    lease,
+		key:      cfg.Key,
+		id:       instanceID(),
+		ttl:      cfg.LeaseTTL,
+		interval: cfg.RenewInterval,
+	}
+}
+
+// instanceID is the host name followed by a random suffix, so replicas on
+// one host, and a restarted process, are told apart
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "rate-limiter"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// ID identifies this replica as the lease holder
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// IsLeader reports whether this replica currently runs the jobs
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Go adds a job to run, until its context is cancelled, whenever this
+// replica becomes the leader. Jobs added after Run has started wait for the
+// next election this replica wins.
+func (e *Elector) Go(job func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = append(e.jobs, job)
+}
+
+// Run campaigns on every renew interval until ctx is cancelled, then stops
+// the jobs and releases the lease so another replica can take over without
+// waiting for it to expire
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var stop func()
+	var renewedAt time.Time
+	for {
+		held, err := e.acquire(ctx)
+		switch {
+		case ctx.Err() != nil:
+			// Shutting down, see below
+		case err == nil && held:
+			renewedAt = time.Now()
+			if stop == nil {
+				stop = e.lead(ctx)
+			}
+		case err != nil && stop != nil && time.Since(renewedAt)+e.interval < e.ttl:
+			// The lease is still ours until it expires; try again before then
+			logging.FromContext(ctx).Warn("Failed to renew leader lease", zap.String("key", e.key), zap.Error(err))
+		default:
+			if err != nil {
+				logging.FromContext(ctx).Warn("Leader election failed", zap.String("key", e.key), zap.Error(err))
+			}
+			if stop != nil {
+				stop()
+				stop = nil
+				metrics.LeaderTransitions.WithLabelValues("lost").Inc()
+				logging.FromContext(ctx).Warn("Lost leadership, singleton jobs stopped", zap.String("instance", e.id))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stop()
+				e.release(ctx)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire takes or renews the lease, giving up before the next attempt is
+// due
+func (e *Elector) acquire(ctx context.Context) (bool, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	return e.lease.AcquireLease(acquireCtx, e.key, e.id, e.ttl)
+}
+
+// lead starts the jobs and returns a function that stops them and waits
+// until they have returned
+func (e *Elector) lead(ctx context.Context) func() {
+	e.mu.Lock()
+	jobs := append([]func(ctx context.Context){}, e.jobs...)
+	e.mu.Unlock()
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	var running sync.WaitGroup
+	for _, job := range jobs {
+		running.Add(1)
+		go func(job func(ctx context.Context)) {
+			defer running.Done()
+			job(jobCtx)
+		}(job)
+	}
+	e.leading.Store(true)
+	metrics.Leader.Set(1)
+	metrics.LeaderTransitions.WithLabelValues("acquired").Inc()
+	logging.FromContext(ctx).Info("Became leader, singleton jobs started", zap.String("instance", e.id), zap.Int("jobs", len(jobs)))
+
+	return func() {
+		cancel()
+		running.Wait()
+		e.leading.Store(false)
+		metrics.Leader.Set(0)
+	}
+}
+
+// release gives up the lease at shutdown. ctx is already cancelled, so the
+// release gets its own.
+func (e *Elector) release(ctx context.Context) {
+	releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := e.lease.ReleaseLease(releaseCtx, e.key, e.id); err != nil {
+		logging.FromContext(ctx).Warn("Failed to release leader lease", zap.String("key", e.key), zap.Error(err))
+		return
+	}
+	metrics.LeaderTransitions.WithLabelValues("released").Inc()
+	logging.FromContext(ctx).Info("Released leadership", zap.String("instance", e.id))
+}
@@ -0,0 +1,115 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLease behaves like the Redis lease scripts
+type fakeLease struct {
+	mu        sync.Mutex
+	holder    string
+	expiresAt time.Time
+	down      bool
+}
+
+func (l *fakeLease) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.down {
+		return false, errors.New("connection refused")
+	}
+	if l.holder == holder || l.holder == "" || time.Now().After(l.expiresAt) {
+		l.holder, l.expiresAt = holder, time.Now().Add(ttl)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (l *fakeLease) ReleaseLease(ctx context.Context, key, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == holder {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *fakeLease) setDown(down bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.down = down
+}
+
+var testConfig = config.LeaderElectionConfig{Key: "ratelimiter:leader", LeaseTTL: 100 * time.Millisecond, RenewInterval: 10 * time.Millisecond}
+
+// startElector runs an elector with a job that counts how many copies of
+// it are running
+func startElector(t *testing.T, lease Lease, running *int32) (*Elector, context.CancelFunc, <-chan struct{}) {
+	elector := NewElector(lease, testConfig)
+	elector.Go(func(ctx context.Context) {
+		atomic.AddInt32(running, 1)
+		<-ctx.Done()
+		atomic.AddInt32(running, -1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return elector, cancel, done
+}
+
+func TestElector_FailsOver(t *testing.T) {
+	lease := &fakeLease{}
+	var running int32
+
+	first, stopFirst, firstDone := startElector(t, lease, &running)
+	require.Eventually(t, first.IsLeader, time.Second, time.Millisecond)
+	second, _, _ := startElector(t, lease, &running)
+
+	time.Sleep(5 * testConfig.RenewInterval)
+	assert.False(t, second.IsLeader(), "the lease is held")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&running), "jobs run on one replica")
+
+	// A leader that shuts down releases the lease right away
+	stopFirst()
+	<-firstDone
+	assert.False(t, first.IsLeader())
+	require.Eventually(t, second.IsLeader, testConfig.LeaseTTL/2, time.Millisecond)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestElector_StepsDownWhenRenewalFails(t *testing.T) {
+	lease := &fakeLease{}
+	var running int32
+
+	elector, _, _ := startElector(t, lease, &running)
+	require.Eventually(t, elector.IsLeader, time.Second, time.Millisecond)
+
+	// Brief failures are ridden out while the lease lasts
+	lease.setDown(true)
+	time.Sleep(3 * testConfig.RenewInterval)
+	assert.True(t, elector.IsLeader())
+
+	require.Eventually(t, func() bool { return !elector.IsLeader() }, 2*testConfig.LeaseTTL, time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&running), "jobs stop with the leadership")
+
+	lease.setDown(false)
+	require.Eventually(t, elector.IsLeader, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 1 }, time.Second, time.Millisecond, "jobs start again on a new term")
+}
@@ -0,0 +1,169 @@
+// Package live fans rate limit decisions and API key events out to admin
+// clients as they happen, e.g. dashboards following the server-sent events
+// stream. Nothing is stored: a client sees what happens while it is
+// subscribed.
+package live
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/services"
+)
+
+// RequestDecided is the type of the event published for every request made
+// with an API key, whether the rate limiter allowed it or not
+const RequestDecided = "request.decided"
+
+// Filter selects the events a subscriber receives. Empty fields match
+// everything.
+type Filter struct {
+	APIKeyIDs []string
+	Types     []string
+}
+
+func (f Filter) matches(event events.Event) bool {
+	return matchesAny(f.APIKeyIDs, event.APIKeyID) && matchesAny(f.Types, event.Type)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub delivers every event it is given to the subscribers whose filter
+// matches it. Publishing never blocks: each subscriber has a buffer, and
+// events that don't fit because the subscriber fell behind are dropped and
+// counted in live_events_dropped_total.
+type Hub struct {
+	buffer int
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+// NewHub buffers up to buffer events per subscriber
+func NewHub(buffer int) *Hub {
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &Hub{buffer: buffer, subscribers: make(map[*Subscription]struct{}), done: make(chan struct{})}
+}
+
+// Subscription receives the events matching its filter until it is closed
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan events.Event
+}
+
+// Subscribe starts delivering the events matching filter. The subscription
+// must be closed once it is no longer read.
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{hub: h, filter: filter, events: make(chan events.Event, h.buffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	metrics.LiveSubscribers.Inc()
+	return sub
+}
+
+// Events is closed when the subscription or the hub is closed
+func (s *Subscription) Events() <-chan events.Event {
+	return s.events
+}
+
+// Close stops the deliveries; it may be called more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subscribers[s]; !ok {
+		return
+	}
+	delete(s.hub.subscribers, s)
+	close(s.events)
+	metrics.LiveSubscribers.Dec()
+}
+
+// Close ends every subscription, e.g. at shutdown so the streams don't hold
+// the server open; later subscriptions end right away
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	close(h.done)
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+		metrics.LiveSubscribers.Dec()
+	}
+}
+
+// Done is closed when the hub is closed
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Publish delivers event to the matching subscribers
+func (h *Hub) Publish(ctx context.Context, event events.Event) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			metrics.LiveEventsDropped.Inc()
+		}
+	}
+	return nil
+}
+
+// Log publishes the rate limit decision on a request
+func (h *Hub) Log(entry database.UsageLog) bool {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	_ = h.Publish(context.Background(), events.Event{
+		Type:      RequestDecided,
+		APIKeyID:  entry.APIKeyID,
+		Timestamp: entry.CreatedAt.UTC(),
+		Data: map[string]interface{}{
+			"method":      entry.Method,
+			"route":       entry.Route,
+			"status_code": entry.StatusCode,
+			"decision":    entry.Decision,
+			"cost":        entry.Cost,
+			"latency_ms":  float64(entry.Latency.Microseconds()) / 1000,
+		},
+	})
+	return true
+}
+
+// Ensure Hub is both an event publisher and a usage logger
+var (
+	_ events.Publisher     = (*Hub)(nil)
+	_ services.UsageLogger = (*Hub)(nil)
+)
@@ -0,0 +1,59 @@
+package live
+
+import (
+	"context"
+	"testing"
+
+	"grpc-firstls/internal/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub_DeliversMatchingEvents(t *testing.T) {
+	hub := NewHub(10)
+	all := hub.Subscribe(Filter{})
+	defer all.Close()
+	one := hub.Subscribe(Filter{APIKeyIDs: []string{"key-1"}, Types: []string{events.APIKeyRotated}})
+	defer one.Close()
+
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyRotated, APIKeyID: "key-1"})
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyRotated, APIKeyID: "key-2"})
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyCreated, APIKeyID: "key-1"})
+
+	assert.Len(t, all.Events(), 3)
+	assert.Len(t, one.Events(), 1)
+	assert.Equal(t, "key-1", (<-one.Events()).APIKeyID)
+}
+
+func TestHub_DropsEventsForSlowSubscribers(t *testing.T) {
+	hub := NewHub(2)
+	sub := hub.Subscribe(Filter{})
+	defer sub.Close()
+
+	for i := 0; i < 5; i++ {
+		hub.Publish(context.Background(), events.Event{Type: events.APIKeyCreated})
+	}
+	assert.Len(t, sub.Events(), 2, "publishing doesn't block")
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub(1)
+	sub := hub.Subscribe(Filter{})
+	sub.Close()
+	sub.Close()
+	_, open := <-sub.Events()
+	assert.False(t, open)
+
+	sub = hub.Subscribe(Filter{})
+	hub.Close()
+	hub.Close()
+	_, open = <-sub.Events()
+	assert.False(t, open, "closing the hub ends its subscriptions")
+	_, open = <-hub.Done()
+	assert.False(t, open)
+	sub.Close()
+
+	_, open = <-hub.Subscribe(Filter{}).Events()
+	assert.False(t, open, "subscriptions to a closed hub end right away")
+	hub.Publish(context.Background(), events.Event{Type: events.APIKeyCreated})
+}
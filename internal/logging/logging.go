@@ -0,0 +1,90 @@
+// Package logging builds the service's structured logger and carries
+// request-scoped loggers through contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+type loggerKey struct{}
+
+// New returns a logger writing to stderr at level (debug, info, warn or
+// error) in format: JSON lines for log shippers, or console for humans
+func New(level, format string) (*zap.Logger, error) {
+	atomicLevel, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithLevel(atomicLevel, format)
+}
+
+// ParseLevel parses a log level (debug, info, warn or error) into a level
+// that can be changed later with SetLevel, e.g. on a configuration reload
+func ParseLevel(level string) (zap.AtomicLevel, error) {
+	atomicLevel, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return atomicLevel, fmt.Errorf("invalid log level %q", level)
+	}
+	return atomicLevel, nil
+}
+
+// NewWithLevel is New with a level shared with the caller, so the logger's
+// level follows changes made to it
+func NewWithLevel(level zap.AtomicLevel, format string) (*zap.Logger, error) {
+	var cfg zap.Config
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		cfg = zap.NewProductionConfig()
+		cfg.EncoderConfig.TimeKey = "time"
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case FormatConsole:
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, FormatJSON, FormatConsole)
+	}
+	cfg.Level = level
+	// Stack traces are attached to panics by the recovery middleware instead
+	cfg.DisableStacktrace = true
+
+	return cfg.Build()
+}
+
+// NewJSONLogger returns a logger writing JSON lines to w, e.g. for an
+// access log kept apart from the service logs
+func NewJSONLogger(w io.Writer) *zap.Logger {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(w), zapcore.InfoLevel))
+}
+
+// WithContext returns a copy of ctx carrying logger
+func WithContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, e.g. one tagged with the
+// request ID, or the global logger
+func FromContext(ctx context.Context) *zap.Logger {
+	return FromContextOr(ctx, zap.L())
+}
+
+// FromContextOr returns the logger stored in ctx, or fallback when there is
+// none
+func FromContextOr(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew(t *testing.T) {
+	logger, err := New("warn", FormatJSON)
+	assert.NoError(t, err)
+	assert.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+	assert.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+
+	_, err = New("debug", FormatConsole)
+	assert.NoError(t, err)
+
+	_, err = New("loud", FormatJSON)
+	assert.ErrorContains(t, err, "invalid log level")
+
+	_, err = New("info", "xml")
+	assert.ErrorContains(t, err, "invalid log format")
+}
+
+func TestNewWithLevel_FollowsLevelChanges(t *testing.T) {
+	level, err := ParseLevel("info")
+	assert.NoError(t, err)
+	logger, err := NewWithLevel(level, FormatJSON)
+	assert.NoError(t, err)
+	assert.False(t, logger.Core().Enabled(zapcore.DebugLevel))
+
+	level.SetLevel(zapcore.DebugLevel)
+	assert.True(t, logger.Core().Enabled(zapcore.DebugLevel))
+
+	_, err = ParseLevel("loud")
+	assert.ErrorContains(t, err, "invalid log level")
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, zap.L(), FromContext(context.Background()))
+
+	logger := zap.NewExample()
+	assert.Equal(t, logger, FromContext(WithContext(context.Background(), logger)))
+}
+
+func TestFromContextOr(t *testing.T) {
+	fallback := zap.NewNop()
+	assert.Equal(t, fallback, FromContextOr(context.Background(), fallback))
+
+	logger := zap.NewExample()
+	assert.Equal(t, logger, FromContextOr(WithContext(context.Background(), logger), fallback))
+}
@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is appended to a rotated file's name, after a dot
+const rotatedSuffix = "20060102T150405.000"
+
+// RotatingFile is an io.WriteCloser appending to a file that is rotated
+// once it reaches MaxSize bytes or has been written to for Interval, and
+// keeps the MaxBackups most recent rotated files. Zero values disable the
+// corresponding limit. It is safe for concurrent use.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	now        func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenRotatingFile opens path for appending, creating it if needed
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating first when p would take the file past its size
+// limit or the file is older than the interval. When rotation fails, p is
+// still appended to the current file and the rotation error is returned;
+// the next write tries to rotate again.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.due(int64(len(p))) {
+		if rotateErr = f.rotate(); f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) due(size int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+size > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && f.now().Sub(f.opened) >= f.interval
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. If that fails,
+// the file at path is opened again for appending, so writes carry on there,
+// and the error is returned; f.file is only nil if that fails too.
+func (f *RotatingFile) rotate() error {
+	closeErr := f.file.Close()
+	f.file = nil
+	if closeErr != nil {
+		return f.reopen(fmt.Errorf("rotating log file: %w", closeErr))
+	}
+	rotated := f.path + "." + f.now().UTC().Format(rotatedSuffix)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return f.reopen(fmt.Errorf("rotating log file: %w", err))
+	}
+	if err := f.open(); err != nil {
+		return f.reopen(err)
+	}
+	return f.removeOldBackups()
+}
+
+// reopen opens path again after a failed rotation and returns err, the
+// rotation's error
+func (f *RotatingFile) reopen(err error) error {
+	if openErr := f.open(); openErr != nil {
+		return fmt.Errorf("%w; %v", err, openErr)
+	}
+	return err
+}
+
+// removeOldBackups deletes rotated files beyond the newest maxBackups
+func (f *RotatingFile) removeOldBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	prefix := f.path + "."
+	var rotated []string
+	for _, backup := range backups {
+		if _, err := time.Parse(rotatedSuffix, strings.TrimPrefix(backup, prefix)); err == nil {
+			rotated = append(rotated, backup)
+		}
+	}
+	if len(rotated) <= f.maxBackups {
+		return nil
+	}
+	// The timestamp suffix sorts oldest first
+	sort.Strings(rotated)
+	for _, backup := range rotated[:len(rotated)-f.maxBackups] {
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("removing old log file: %w", err)
+		}
+	}
+	return nil
+}
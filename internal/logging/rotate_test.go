@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(current))
+
+	// The oldest rotated file is removed beyond the two backups
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	oldest, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(oldest))
+}
+
+func TestRotatingFile_RotatesByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &RotatingFile{path: path, interval: time.Hour, now: func() time.Time { return now }}
+	require.NoError(t, f.open())
+	defer f.Close()
+
+	_, err := f.Write([]byte("before\n"))
+	require.NoError(t, err)
+	now = now.Add(59 * time.Minute)
+	_, err = f.Write([]byte("still\n"))
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = f.Write([]byte("after\n"))
+	require.NoError(t, err)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "after\n", string(current))
+	rotated, err := os.ReadFile(path + ".20260101T010000.000")
+	require.NoError(t, err)
+	assert.Equal(t, "before\nstill\n", string(rotated))
+}
+
+func TestRotatingFile_AppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("earlier\n"), 0o644))
+
+	f, err := OpenRotatingFile(path, 0, 0, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("later\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "earlier\nlater\n", string(content))
+
+	_, err = f.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFile_KeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &RotatingFile{path: path, maxSize: 10, now: func() time.Time { return now }}
+	require.NoError(t, f.open())
+	defer f.Close()
+
+	// A directory in the way of the rotated file makes the rename fail
+	blocker := path + "." + now.Format(rotatedSuffix)
+	require.NoError(t, os.MkdirAll(filepath.Join(blocker, "taken"), 0o755))
+
+	_, err := f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	n, err := f.Write([]byte("second\n"))
+	assert.ErrorContains(t, err, "rotating log file")
+	assert.Equal(t, len("second\n"), n)
+
+	// The next write rotates once the way is clear
+	require.NoError(t, os.RemoveAll(blocker))
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "third\n", string(current))
+	rotated, err := os.ReadFile(blocker)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(rotated))
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How KeyRequests labels its series. Exporting every key ID gives one series
+// per key and decision, which doesn't scale to thousands of keys; the other
+// strategies bound the number of series.
+const (
+	// KeyLabelsNone doesn't export per-key metrics
+	KeyLabelsNone = "none"
+	// KeyLabelsKey labels each series with the key ID
+	KeyLabelsKey = "key"
+	// KeyLabelsTopN labels the busiest keys with their ID and adds up the
+	// others under "other"
+	KeyLabelsTopN = "top_n"
+	// KeyLabelsHash labels each series with a bucket of the hashed key ID
+	KeyLabelsHash = "hash"
+	// KeyLabelsPlan labels each series with the key's plan ID, or "none"
+	KeyLabelsPlan = "plan"
+)
+
+// KeyLabelStrategies lists the accepted key label strategies
+var KeyLabelStrategies = []string{KeyLabelsNone, KeyLabelsKey, KeyLabelsTopN, KeyLabelsHash, KeyLabelsPlan}
+
+// otherKeys labels the keys outside the top N
+const otherKeys = "other"
+
+// keyDecision identifies a series before its key label is resolved
+type keyDecision struct {
+	label    string
+	decision string
+}
+
+// KeyRequests counts requests made with an API key by rate limit decision,
+// as ratelimiter_key_requests_total. The key label depends on the strategy:
+// the key ID, its plan, a hash bucket, or the key ID for the topN busiest
+// keys. With top_n a key entering or leaving the top moves its count between
+// its own series and "other", which Prometheus sees as a counter reset.
+type KeyRequests struct {
+	strategy string
+	topN     int
+	buckets  uint32
+	desc     *prometheus.Desc
+
+	mu     sync.Mutex
+	counts map[keyDecision]float64
+}
+
+// NewKeyRequests returns the per-key request counter for strategy, one of
+// KeyLabelStrategies. topN applies to top_n and buckets to hash.
+func NewKeyRequests(strategy string, topN, buckets int) (*KeyRequests, error) {
+	label := "key"
+	switch strategy {
+	case KeyLabelsKey, KeyLabelsTopN:
+		if strategy == KeyLabelsTopN && topN < 1 {
+			return nil, fmt.Errorf("top_n needs at least 1 key, got %d", topN)
+		}
+	case KeyLabelsHash:
+		if buckets < 1 {
+			return nil, fmt.Errorf("hash needs at least 1 bucket, got %d", buckets)
+		}
+		label = "bucket"
+	case KeyLabelsPlan:
+		label = "plan"
+	default:
+		return nil, fmt.Errorf("unknown key label strategy %q", strategy)
+	}
+	return &KeyRequests{
+		strategy: strategy,
+		topN:     topN,
+		buckets:  uint32(buckets),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "", "key_requests_total"),
+			"Requests made with an API key, by rate limit decision.",
+			[]string{label, "decision"}, nil,
+		),
+		counts: make(map[keyDecision]float64),
+	}, nil
+}
+
+// Observe counts a request made with the key keyID, on the plan planID
+// (empty without a plan)
+func (k *KeyRequests) Observe(keyID, planID, decision string) {
+	label := keyID
+	switch k.strategy {
+	case KeyLabelsHash:
+		hash := fnv.New32a()
+		hash.Write([]byte(keyID))
+		label = fmt.Sprintf("%d", hash.Sum32()%k.buckets)
+	case KeyLabelsPlan:
+		label = planID
+		if label == "" {
+			label = "none"
+		}
+	}
+
+	k.mu.Lock()
+	k.counts[keyDecision{label: label, decision: decision}]++
+	k.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector
+func (k *KeyRequests) Describe(ch chan<- *prometheus.Desc) {
+	ch <- k.desc
+}
+
+// Collect implements prometheus.Collector
+func (k *KeyRequests) Collect(ch chan<- prometheus.Metric) {
+	k.mu.Lock()
+	counts := make(map[keyDecision]float64, len(k.counts))
+	for series, count := range k.counts {
+		counts[series] = count
+	}
+	k.mu.Unlock()
+
+	if k.strategy == KeyLabelsTopN {
+		counts = k.foldOthers(counts)
+	}
+	for series, count := range counts {
+		ch <- prometheus.MustNewConstMetric(k.desc, prometheus.CounterValue, count, series.label, series.decision)
+	}
+}
+
+// foldOthers keeps the series of the topN keys with the most requests and
+// adds up the rest under "other"
+func (k *KeyRequests) foldOthers(counts map[keyDecision]float64) map[keyDecision]float64 {
+	totals := make(map[string]float64)
+	for series, count := range counts {
+		totals[series.label] += count
+	}
+	if len(totals) <= k.topN {
+		return counts
+	}
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := make(map[string]bool, k.topN)
+	for _, key := range keys[:k.topN] {
+		top[key] = true
+	}
+
+	folded := make(map[keyDecision]float64)
+	for series, count := range counts {
+		if !top[series.label] {
+			series.label = otherKeys
+		}
+		folded[series] += count
+	}
+	return folded
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func observeKeys(requests *KeyRequests) {
+	for i := 0; i < 3; i++ {
+		requests.Observe("key-a", "pro", "allowed")
+	}
+	requests.Observe("key-a", "pro", "limited")
+	requests.Observe("key-b", "pro", "allowed")
+	requests.Observe("key-b", "pro", "allowed")
+	requests.Observe("key-c", "", "allowed")
+}
+
+func TestKeyRequests_Strategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		expected string
+	}{
+		{KeyLabelsKey, `
+ratelimiter_key_requests_total{decision="allowed",key="key-a"} 3
+ratelimiter_key_requests_total{decision="allowed",key="key-b"} 2
+ratelimiter_key_requests_total{decision="allowed",key="key-c"} 1
+ratelimiter_key_requests_total{decision="limited",key="key-a"} 1
+`},
+		{KeyLabelsTopN, `
+ratelimiter_key_requests_total{decision="allowed",key="key-a"} 3
+ratelimiter_key_requests_total{decision="allowed",key="other"} 3
+ratelimiter_key_requests_total{decision="limited",key="key-a"} 1
+`},
+		{KeyLabelsPlan, `
+ratelimiter_key_requests_total{decision="allowed",plan="none"} 1
+ratelimiter_key_requests_total{decision="allowed",plan="pro"} 5
+ratelimiter_key_requests_total{decision="limited",plan="pro"} 1
+`},
+		{KeyLabelsHash, `
+ratelimiter_key_requests_total{bucket="0",decision="allowed"} 6
+ratelimiter_key_requests_total{bucket="0",decision="limited"} 1
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			requests, err := NewKeyRequests(tt.strategy, 1, 1)
+			require.NoError(t, err)
+			observeKeys(requests)
+
+			expected := "# HELP ratelimiter_key_requests_total Requests made with an API key, by rate limit decision.\n# TYPE ratelimiter_key_requests_total counter" + tt.expected
+			assert.NoError(t, testutil.CollectAndCompare(requests, strings.NewReader(expected)))
+		})
+	}
+}
+
+func TestKeyRequests_HashBoundsSeries(t *testing.T) {
+	requests, err := NewKeyRequests(KeyLabelsHash, 0, 4)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		requests.Observe(key, "", "allowed")
+	}
+	assert.LessOrEqual(t, testutil.CollectAndCount(requests), 4)
+}
+
+func TestNewKeyRequests_Invalid(t *testing.T) {
+	_, err := NewKeyRequests("labels", 10, 10)
+	assert.ErrorContains(t, err, `unknown key label strategy "labels"`)
+	_, err = NewKeyRequests(KeyLabelsTopN, 0, 10)
+	assert.Error(t, err)
+	_, err = NewKeyRequests(KeyLabelsHash, 10, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,264 @@
+// Package metrics holds the service's Prometheus collectors and serves them
+// for scraping.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "ratelimiter"
+
+// Registry collects the service's metrics along with the Go runtime and
+// process metrics
+var Registry = prometheus.NewRegistry()
+
+// PanicsRecovered counts handler panics turned into 500 responses
+var PanicsRecovered = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "http_panics_recovered_total",
+	Help:      "Panics recovered while handling HTTP requests.",
+})
+
+// DatabaseUp is 1 while the last background database check succeeded and 0
+// while the database is unreachable
+var DatabaseUp = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "database_up",
+	Help:      "Whether the last database health check succeeded.",
+})
+
+// DatabaseRetries counts queries retried after a transient database error
+var DatabaseRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "database_retries_total",
+	Help:      "Database operations retried after a transient error.",
+})
+
+// DatabaseReplicaUp is 1 while a read replica is reachable and within the
+// allowed replication lag, so reads are routed to it
+var DatabaseReplicaUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "database_replica_up",
+	Help:      "Whether a read replica is in use for reads.",
+}, []string{"replica"})
+
+// DatabaseReplicaLag is the replication lag last measured on a read replica
+var DatabaseReplicaLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "database_replica_lag_seconds",
+	Help:      "Replication lag of a read replica behind the primary.",
+}, []string{"replica"})
+
+// UsageLogsWritten counts usage_logs rows written by the usage log writer
+var UsageLogsWritten = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "usage_logs_written_total",
+	Help:      "Request records written to usage_logs.",
+})
+
+// UsageLogsDropped counts request records that were not logged, because the
+// buffer was full or the batch could not be written
+var UsageLogsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "usage_logs_dropped_total",
+	Help:      "Request records dropped before reaching usage_logs.",
+}, []string{"reason"})
+
+// UsageEventsProduced counts usage events acknowledged by Kafka
+var UsageEventsProduced = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "usage_events_produced_total",
+	Help:      "Usage events produced to Kafka.",
+})
+
+// UsageEventsDropped counts usage events that never reached Kafka, because
+// the buffer was full or they were still buffered on shutdown
+var UsageEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "usage_events_dropped_total",
+	Help:      "Usage events dropped before reaching Kafka.",
+}, []string{"reason"})
+
+// RetentionRowsDeleted counts rows removed by the retention job, by table
+var RetentionRowsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "retention_deleted_rows_total",
+	Help:      "Rows deleted by the retention job.",
+}, []string{"table"})
+
+// RetentionLastRunRows is the number of rows the last retention run deleted
+// from each table
+var RetentionLastRunRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "retention_last_run_deleted_rows",
+	Help:      "Rows deleted by the most recent retention run.",
+}, []string{"table"})
+
+// UsageRollupLastSuccess is when the usage rollup job last rolled up the
+// usage logs, as a Unix timestamp
+var UsageRollupLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "usage_rollup_last_success_timestamp_seconds",
+	Help:      "Unix time of the last successful usage rollup.",
+})
+
+// WebhookAlerts counts limit alerts by outcome: delivered, failed after
+// every attempt, or dropped because the send buffer was full
+var WebhookAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "webhook_alerts_total",
+	Help:      "Limit alerts sent to key webhooks, by outcome.",
+}, []string{"outcome"})
+
+// NATSEvents counts events published to NATS by type and outcome: published
+// or failed
+var NATSEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "nats_events_total",
+	Help:      "Key lifecycle and limit events published to NATS, by type and outcome.",
+}, []string{"type", "outcome"})
+
+// AlertNotifications counts operational alerts and resolutions sent to
+// Slack or PagerDuty, by notifier and outcome: sent or failed
+var AlertNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "alert_notifications_total",
+	Help:      "Operational alert notifications, by notifier and outcome.",
+}, []string{"notifier", "outcome"})
+
+// Leader is 1 while this instance is the elected leader that runs the
+// singleton background jobs, and 0 otherwise
+var Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "leader",
+	Help:      "Whether this instance runs the singleton background jobs (1) or not (0).",
+})
+
+// LeaderTransitions counts leadership changes of this instance: acquired,
+// lost (the lease couldn't be renewed) or released (at shutdown)
+var LeaderTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "leader_transitions_total",
+	Help:      "Leadership changes of this instance, by transition.",
+}, []string{"transition"})
+
+// LiveSubscribers is the number of admin clients following live events
+var LiveSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "live_subscribers",
+	Help:      "Admin clients currently following live events.",
+})
+
+// LiveEventsDropped counts live events not delivered to a subscriber that
+// fell behind
+var LiveEventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "live_events_dropped_total",
+	Help:      "Live events dropped because a subscriber fell behind.",
+})
+
+// KeyCacheLookups counts API key validations answered by the key cache
+// (hit) and those that went to the database (miss)
+var KeyCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "key_cache_lookups_total",
+	Help:      "API key validations by key cache result: hit or miss.",
+}, []string{"result"})
+
+// KeyCacheEntries is the number of validated keys held in the key cache
+var KeyCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "key_cache_entries",
+	Help:      "Validated API keys currently held in the key cache.",
+})
+
+// KeyCacheInvalidations counts keys dropped from the key cache because they
+// changed, or every key for invalidations of all keys
+var KeyCacheInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "key_cache_invalidations_total",
+	Help:      "Key cache invalidations, by scope: key or all.",
+}, []string{"scope"})
+
+// KeyCacheInvalidationsPublished counts key cache invalidations sent to the
+// other instances, by outcome: published or failed
+var KeyCacheInvalidationsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "key_cache_invalidations_published_total",
+	Help:      "Key cache invalidations published to other instances, by outcome.",
+}, []string{"outcome"})
+
+// RateLimitRefunds counts the units clients refunded to their rate limit
+var RateLimitRefunds = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "rate_limit_refunded_units_total",
+	Help:      "Rate limit units refunded by clients whose downstream call failed.",
+})
+
+// RateLimitReservations counts rate limit reservations by outcome: reserved,
+// refused, committed or cancelled
+var RateLimitReservations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "rate_limit_reservations_total",
+	Help:      "Rate limit reservations, by outcome.",
+}, []string{"outcome"})
+
+// ResponseCacheLookups counts GET requests answered from the response cache
+// (hit), those that went to the handler (miss) and those whose client asked
+// for a fresh response (bypass)
+var ResponseCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "response_cache_lookups_total",
+	Help:      "Response cache lookups, by result: hit, miss or bypass.",
+}, []string{"result"})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		PanicsRecovered,
+		DatabaseUp,
+		DatabaseRetries,
+		DatabaseReplicaUp,
+		DatabaseReplicaLag,
+		UsageLogsWritten,
+		UsageLogsDropped,
+		UsageEventsProduced,
+		UsageEventsDropped,
+		RetentionRowsDeleted,
+		RetentionLastRunRows,
+		UsageRollupLastSuccess,
+		WebhookAlerts,
+		NATSEvents,
+		AlertNotifications,
+		Leader,
+		LeaderTransitions,
+		LiveSubscribers,
+		LiveEventsDropped,
+		KeyCacheLookups,
+		KeyCacheEntries,
+		KeyCacheInvalidations,
+		KeyCacheInvalidationsPublished,
+		RateLimitRefunds,
+		RateLimitReservations,
+		ResponseCacheLookups,
+	)
+}
+
+// RegisterDatabase exports the connection pool statistics of db, e.g.
+// go_sql_open_connections and go_sql_wait_duration_seconds_total, labelled
+// with db_name
+func RegisterDatabase(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// rateLimitDecisionContextKey stores the outcome of the RateLimit middleware
+const rateLimitDecisionContextKey = "rate_limit_decision"
+
+// Access log formats for WithAccessLogOutput
+const (
+	AccessLogFormatJSON     = "json"
+	AccessLogFormatCombined = "combined"
+)
+
+type accessLogOptions struct {
+	output io.Writer
+	format string
+}
+
+// AccessLogOption configures optional AccessLog middleware behaviour
+type AccessLogOption func(*accessLogOptions)
+
+// WithAccessLogOutput writes the access log to output instead of through the
+// service logger, as JSON lines or in the Apache combined log format
+func WithAccessLogOutput(output io.Writer, format string) AccessLogOption {
+	return func(o *accessLogOptions) {
+		o.output = output
+		o.format = format
+	}
+}
+
+// AccessLog logs one structured line per request and makes a logger tagged
+// with the request ID available to everything downstream through
+// logging.FromContext. It must run after RequestID.
+func AccessLog(logger *zap.Logger, opts ...AccessLogOption) gin.HandlerFunc {
+	options := &accessLogOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	// Without an output, access log lines go through the request logger
+	var accessLogger *zap.Logger
+	if options.output != nil && options.format != AccessLogFormatCombined {
+		accessLogger = logging.NewJSONLogger(options.output)
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := GetRequestID(c)
+		requestLogger := logger
+		if requestID != "" {
+			requestLogger = logger.With(zap.String("request_id", requestID))
+		}
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), requestLogger))
+
+		c.Next()
+
+		if options.format == AccessLogFormatCombined && options.output != nil {
+			writeCombinedLine(options.output, c, start)
+			return
+		}
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", c.FullPath()),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		if apiKey := requestAPIKey(c); apiKey != nil {
+			fields = append(fields, zap.String("api_key_id", apiKey.ID), zap.String("key_prefix", apiKey.KeyPrefix))
+		}
+		if decision := c.GetString(rateLimitDecisionContextKey); decision != "" {
+			fields = append(fields, zap.String("rate_limit", decision))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		lineLogger := requestLogger
+		if accessLogger != nil {
+			lineLogger = accessLogger
+			if requestID != "" {
+				fields = append(fields, zap.String("request_id", requestID))
+			}
+		}
+		if status >= 500 {
+			lineLogger.Error("request", fields...)
+		} else {
+			lineLogger.Info("request", fields...)
+		}
+	}
+}
+
+func requestAPIKey(c *gin.Context) *database.APIKey {
+	if apiKey, ok := c.Get("api_key"); ok {
+		if record, ok := apiKey.(*database.APIKey); ok {
+			return record
+		}
+	}
+	return nil
+}
+
+// writeCombinedLine writes the request in the Apache combined log format.
+// The user field holds the API key prefix once a key has been validated.
+func writeCombinedLine(output io.Writer, c *gin.Context, start time.Time) {
+	user := "-"
+	if apiKey := requestAPIKey(c); apiKey != nil && apiKey.KeyPrefix != "" {
+		user = apiKey.KeyPrefix
+	}
+	size := "-"
+	if c.Writer.Size() > 0 {
+		size = strconv.Itoa(c.Writer.Size())
+	}
+	requestLine := c.Request.Method + " " + c.Request.URL.RequestURI() + " " + c.Request.Proto
+
+	fmt.Fprintf(output, "%s - %s [%s] %q %d %s %q %q\n",
+		c.ClientIP(), user, start.Format("02/Jan/2006:15:04:05 -0700"), requestLine,
+		c.Writer.Status(), size, headerOrDash(c, "Referer"), headerOrDash(c, "User-Agent"))
+}
+
+func headerOrDash(c *gin.Context, name string) string {
+	if value := c.GetHeader(name); value != "" {
+		return value
+	}
+	return "-"
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func setupAccessLogTestRouter() (*gin.Engine, *observer.ObservedLogs) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := gin.New()
+	router.Use(RequestID(), AccessLog(zap.New(core)))
+	router.GET("/items/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Warn("something failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+	})
+	return router, logs
+}
+
+func TestAccessLog_LogsRequest(t *testing.T) {
+	router, logs := setupAccessLogTestRouter()
+
+	serveWithRequestID(router, "/items/42", "support-1234")
+
+	entries := logs.FilterMessage("request").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "support-1234", fields["request_id"])
+	assert.Equal(t, "GET", fields["method"])
+	assert.Equal(t, "/items/:id", fields["route"])
+	assert.Equal(t, "/items/42", fields["path"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Contains(t, fields, "latency")
+	assert.NotContains(t, fields, "api_key_id")
+	assert.NotContains(t, fields, "rate_limit")
+}
+
+func TestAccessLog_RequestScopedLogger(t *testing.T) {
+	router, logs := setupAccessLogTestRouter()
+
+	serveWithRequestID(router, "/fail", "support-1234")
+
+	handlerEntries := logs.FilterMessage("something failed").All()
+	require.Len(t, handlerEntries, 1)
+	assert.Equal(t, "support-1234", handlerEntries[0].ContextMap()["request_id"])
+
+	entries := logs.FilterMessage("request").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+}
+
+func TestAccessLog_RateLimitDecision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	router := gin.New()
+	router.Use(RequestID(), AccessLog(zap.New(core)), RateLimit(mockAPIKeyService, mockRateLimitService))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	apiKey := createTestAPIKey()
+	apiKey.KeyPrefix = "rl_test"
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(apiKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "spent-key").Return(apiKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(true, 9), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(false, 0), nil).Once()
+
+	for _, key := range []string{"", "valid-key", "spent-key"} {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := logs.FilterMessage("request").All()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "missing_key", entries[0].ContextMap()["rate_limit"])
+	assert.Equal(t, int64(http.StatusUnauthorized), entries[0].ContextMap()["status"])
+
+	assert.Equal(t, "allowed", entries[1].ContextMap()["rate_limit"])
+	assert.Equal(t, "test-id-123", entries[1].ContextMap()["api_key_id"])
+	assert.Equal(t, "rl_test", entries[1].ContextMap()["key_prefix"])
+
+	assert.Equal(t, "limited", entries[2].ContextMap()["rate_limit"])
+	assert.Equal(t, int64(http.StatusTooManyRequests), entries[2].ContextMap()["status"])
+}
+
+func TestAccessLog_Output(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range []struct {
+		format string
+		match  string
+	}{
+		{AccessLogFormatJSON, `^\{"level":"info","time":"[^"]+","msg":"request","method":"GET","route":"/items/:id","path":"/items/42","status":200,"latency":[0-9.e-]+,"client_ip":"192.0.2.1","request_id":"support-1234"\}\n$`},
+		{AccessLogFormatCombined, `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /items/42\?page=2 HTTP/1\.1" 200 15 "https://app\.example\.com/" "test-agent"\n$`},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			var output bytes.Buffer
+
+			router := gin.New()
+			router.Use(RequestID(), AccessLog(zap.New(core), WithAccessLogOutput(&output, tt.format)))
+			router.GET("/items/:id", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			})
+
+			req, _ := http.NewRequest("GET", "/items/42?page=2", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set(RequestIDHeader, "support-1234")
+			req.Header.Set("Referer", "https://app.example.com/")
+			req.Header.Set("User-Agent", "test-agent")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Regexp(t, tt.match, output.String())
+			assert.Empty(t, logs.FilterMessage("request").All())
+		})
+	}
+}
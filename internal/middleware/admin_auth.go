@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Role is the access level carried by an admin credential. Each role includes
+// the permissions of the roles below it.
+type Role int
+
+const (
+	// RoleViewer can list keys, plans and usage
+	RoleViewer Role = iota + 1
+	// RoleOperator can also create, rotate and edit keys
+	RoleOperator
+	// RoleAdmin can also delete keys and change plans
+	RoleAdmin
+)
+
+// adminRoleContextKey holds the authenticated caller's Role
+const adminRoleContextKey = "admin_role"
+
+// adminOrganizationContextKey holds the ID of the organization the caller's
+// credential is scoped to, if any
+const adminOrganizationContextKey = "admin_organization"
+
+// adminCallerContextKey identifies the credential the caller authenticated
+// with, without revealing it
+const adminCallerContextKey = "admin_caller"
+
+var roleNames = map[string]Role{
+	"viewer":   RoleViewer,
+	"operator": RoleOperator,
+	"admin":    RoleAdmin,
+}
+
+func (r Role) String() string {
+	for name, role := range roleNames {
+		if role == r {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// ParseRole parses a role name (viewer, operator or admin)
+func ParseRole(name string) (Role, error) {
+	role, ok := roleNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown admin role %q", name)
+	}
+	return role, nil
+}
+
+// AdminIdentity is who an admin credential belongs to: the caller's role
+// and, for credentials scoped to an organization, its ID. Scoped callers only
+// see and manage that organization's keys and projects; the others manage the
+// whole platform.
+type AdminIdentity struct {
+	Role           Role
+	OrganizationID string
+}
+
+// AdminAuthenticator resolves an admin bearer token to the caller's
+// identity. A recognised caller without any role gets the zero Role and is
+// refused by every RequireRole check.
+type AdminAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (AdminIdentity, error)
+}
+
+var errUnknownAdminToken = errors.New("unknown admin token")
+
+// errEmptyOrganizationClaim is returned for OIDC tokens whose organization
+// claim is present but names no organization
+var errEmptyOrganizationClaim = errors.New("empty organization claim")
+
+// AdminCredentials maps admin bearer tokens to their identities. Tokens are
+// stored hashed so lookups don't leak timing information about them.
+type AdminCredentials map[string]AdminIdentity
+
+// ParseAdminCredentials parses entries of the form "role:token", e.g.
+// "admin:s3cret", or "role@organization-id:token" for a credential scoped to
+// an organization
+func ParseAdminCredentials(entries []string) (AdminCredentials, error) {
+	credentials := AdminCredentials{}
+	for _, entry := range entries {
+		name, token, ok := strings.Cut(entry, ":")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("invalid admin credential: expected role:token")
+		}
+		name, organizationID, scoped := strings.Cut(name, "@")
+		if scoped && organizationID == "" {
+			return nil, fmt.Errorf("invalid admin credential: expected role@organization-id:token")
+		}
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		credentials[hashAdminToken(token)] = AdminIdentity{Role: role, OrganizationID: organizationID}
+	}
+	return credentials, nil
+}
+
+// Authenticate implements AdminAuthenticator for static tokens
+func (credentials AdminCredentials) Authenticate(ctx context.Context, token string) (AdminIdentity, error) {
+	identity, ok := credentials[hashAdminToken(token)]
+	if !ok {
+		return AdminIdentity{}, errUnknownAdminToken
+	}
+	return identity, nil
+}
+
+// ReloadableAdminCredentials holds admin credentials that can be replaced
+// while the server runs, e.g. when the tokens are rotated in a secrets
+// manager
+type ReloadableAdminCredentials struct {
+	mu          sync.RWMutex
+	credentials AdminCredentials
+}
+
+func NewReloadableAdminCredentials(credentials AdminCredentials) *ReloadableAdminCredentials {
+	return &ReloadableAdminCredentials{credentials: credentials}
+}
+
+// Replace swaps in a new set of credentials; tokens missing from it stop
+// working immediately
+func (r *ReloadableAdminCredentials) Replace(credentials AdminCredentials) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.credentials = credentials
+}
+
+// Authenticate implements AdminAuthenticator
+func (r *ReloadableAdminCredentials) Authenticate(ctx context.Context, token string) (AdminIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.credentials.Authenticate(ctx, token)
+}
+
+// TokenVerifier validates a bearer token and returns its claims, e.g.
+// *oidc.Verifier
+type TokenVerifier interface {
+	Verify(ctx context.Context, rawToken string) (oidc.Claims, error)
+}
+
+// OIDCAuthenticator accepts tokens from an OIDC provider and derives the
+// caller's role from a claim. Claim values are mapped through RoleMapping
+// (e.g. an SSO group name to a role); without a mapping they must be role
+// names. The highest matching role wins. With OrganizationClaim set, tokens
+// carrying that claim are scoped to the organization it names; tokens
+// without it manage the whole platform. Tokens whose claim is present but
+// empty are refused rather than treated as unscoped.
+type OIDCAuthenticator struct {
+	Verifier          TokenVerifier
+	RoleClaim         string
+	RoleMapping       map[string]Role
+	OrganizationClaim string
+}
+
+// Authenticate implements AdminAuthenticator
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, token string) (AdminIdentity, error) {
+	claims, err := a.Verifier.Verify(ctx, token)
+	if err != nil {
+		return AdminIdentity{}, err
+	}
+
+	var identity AdminIdentity
+	if a.OrganizationClaim != "" {
+		if _, ok := claims[a.OrganizationClaim]; ok {
+			organizations := claims.Strings(a.OrganizationClaim)
+			if len(organizations) == 0 || organizations[0] == "" {
+				return AdminIdentity{}, errEmptyOrganizationClaim
+			}
+			identity.OrganizationID = organizations[0]
+		}
+	}
+
+	for _, value := range claims.Strings(a.RoleClaim) {
+		var candidate Role
+		if a.RoleMapping != nil {
+			candidate = a.RoleMapping[value]
+		} else {
+			candidate, _ = ParseRole(value)
+		}
+		if candidate > identity.Role {
+			identity.Role = candidate
+		}
+	}
+	return identity, nil
+}
+
+// ParseRoleMapping parses entries of the form "claim-value:role", e.g.
+// "platform-admins:admin"
+func ParseRoleMapping(entries []string) (map[string]Role, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	mapping := map[string]Role{}
+	for _, entry := range entries {
+		index := strings.LastIndex(entry, ":")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid role mapping %q: expected value:role", entry)
+		}
+		role, err := ParseRole(entry[index+1:])
+		if err != nil {
+			return nil, err
+		}
+		mapping[entry[:index]] = role
+	}
+	return mapping, nil
+}
+
+func hashAdminToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", hash)
+}
+
+// AdminAuth authenticates admin requests by their "Authorization: Bearer"
+// token, trying each authenticator in turn, and stores the caller's role for
+// RequireRole and organization for AdminOrganization
+func AdminAuth(authenticators ...AdminAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || token == "" {
+			c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+				"error":   "Admin credential required",
+				"message": "Please provide an admin token in the Authorization header",
+			}))
+			c.Abort()
+			return
+		}
+
+		identity, ok := AuthenticateAdmin(c.Request.Context(), authenticators, token)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+				"error":   "Invalid admin credential",
+				"message": "The provided admin token is not valid",
+			}))
+			c.Abort()
+			return
+		}
+
+		c.Set(adminRoleContextKey, identity.Role)
+		c.Set(adminOrganizationContextKey, identity.OrganizationID)
+		c.Set(adminCallerContextKey, "token:"+hashAdminToken(token)[:16])
+		c.Next()
+	}
+}
+
+// AuthenticateAdmin tries each authenticator in turn and returns the
+// identity the first that accepts token gives
+func AuthenticateAdmin(ctx context.Context, authenticators []AdminAuthenticator, token string) (AdminIdentity, bool) {
+	for _, authenticator := range authenticators {
+		identity, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return identity, true
+		}
+		if !errors.Is(err, errUnknownAdminToken) {
+			logging.FromContext(ctx).Warn("Admin authentication failed", zap.Error(err))
+		}
+	}
+	return AdminIdentity{}, false
+}
+
+// AdminOrganization returns the ID of the organization the admin caller's
+// credential is scoped to, or "" for callers managing the whole platform and
+// when the admin API is unauthenticated
+func AdminOrganization(c *gin.Context) string {
+	return c.GetString(adminOrganizationContextKey)
+}
+
+// RequirePlatform rejects admin callers whose credential is scoped to an
+// organization, for operations that affect every tenant
+func RequirePlatform() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AdminOrganization(c) != "" {
+			c.JSON(http.StatusForbidden, ErrorBody(c, gin.H{
+				"error":   "Insufficient scope",
+				"message": "This operation requires a credential that is not scoped to an organization",
+			}))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireOrganization rejects admin callers whose credential isn't scoped to
+// an organization, for the self-service endpoints acting on the caller's own
+// organization
+func RequireOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AdminOrganization(c) == "" {
+			c.JSON(http.StatusForbidden, ErrorBody(c, gin.H{
+				"error":   "Insufficient scope",
+				"message": "This operation requires a credential scoped to an organization",
+			}))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole rejects callers authenticated by AdminAuth whose role is below
+// minimum
+func RequireRole(minimum Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get(adminRoleContextKey)
+		if role, ok := role.(Role); !ok || role < minimum {
+			c.JSON(http.StatusForbidden, ErrorBody(c, gin.H{
+				"error":   "Insufficient role",
+				"message": fmt.Sprintf("This operation requires the %s role", minimum),
+			}))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
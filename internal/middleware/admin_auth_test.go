@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAdminAuthTest(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := ParseAdminCredentials([]string{"viewer:view-token", "operator:op-token", "admin:admin-token"})
+	assert.NoError(t, err)
+
+	router := gin.New()
+	admin := router.Group("/admin", AdminAuth(credentials))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	admin.GET("/keys", RequireRole(RoleViewer), ok)
+	admin.POST("/keys", RequireRole(RoleOperator), ok)
+	admin.DELETE("/keys", RequireRole(RoleAdmin), ok)
+	return router
+}
+
+func adminRequest(router *gin.Engine, method string, token string) int {
+	req, _ := http.NewRequest(method, "/admin/keys", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAdminAuth_MissingOrInvalidToken(t *testing.T) {
+	router := setupAdminAuthTest(t)
+
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(router, "GET", ""))
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(router, "GET", "wrong-token"))
+}
+
+func TestRequireRole(t *testing.T) {
+	router := setupAdminAuthTest(t)
+
+	tests := []struct {
+		token  string
+		method string
+		code   int
+	}{
+		{"view-token", "GET", http.StatusOK},
+		{"view-token", "POST", http.StatusForbidden},
+		{"view-token", "DELETE", http.StatusForbidden},
+		{"op-token", "GET", http.StatusOK},
+		{"op-token", "POST", http.StatusOK},
+		{"op-token", "DELETE", http.StatusForbidden},
+		{"admin-token", "GET", http.StatusOK},
+		{"admin-token", "POST", http.StatusOK},
+		{"admin-token", "DELETE", http.StatusOK},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, adminRequest(router, tt.method, tt.token), "%s %s", tt.token, tt.method)
+	}
+}
+
+func TestParseAdminCredentials_Invalid(t *testing.T) {
+	_, err := ParseAdminCredentials([]string{"superuser:token"})
+	assert.Error(t, err)
+
+	_, err = ParseAdminCredentials([]string{"admin-token-without-role"})
+	assert.Error(t, err)
+
+	_, err = ParseAdminCredentials([]string{"admin:"})
+	assert.Error(t, err)
+}
+
+func TestAdminAuth_OrganizationScope(t *testing.T) {
+	credentials, err := ParseAdminCredentials([]string{"admin:platform-token", "operator@org-1:tenant-token"})
+	assert.NoError(t, err)
+	assert.Equal(t, AdminIdentity{Role: RoleOperator, OrganizationID: "org-1"}, credentials[hashAdminToken("tenant-token")])
+
+	router := gin.New()
+	admin := router.Group("/admin", AdminAuth(credentials))
+	admin.GET("/keys", RequireRole(RoleViewer), func(c *gin.Context) { c.String(http.StatusOK, AdminOrganization(c)) })
+	admin.DELETE("/keys", RequireRole(RoleViewer), RequirePlatform(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	admin.POST("/keys", RequireRole(RoleViewer), RequireOrganization(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer tenant-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "org-1", w.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, adminRequest(router, "DELETE", "tenant-token"))
+	assert.Equal(t, http.StatusOK, adminRequest(router, "DELETE", "platform-token"))
+	assert.Equal(t, http.StatusOK, adminRequest(router, "POST", "tenant-token"))
+	assert.Equal(t, http.StatusForbidden, adminRequest(router, "POST", "platform-token"))
+
+	_, err = ParseAdminCredentials([]string{"operator@:token"})
+	assert.Error(t, err)
+}
+
+type fakeTokenVerifier map[string]oidc.Claims
+
+func (f fakeTokenVerifier) Verify(ctx context.Context, rawToken string) (oidc.Claims, error) {
+	claims, ok := f[rawToken]
+	if !ok {
+		return nil, oidc.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	verifier := fakeTokenVerifier{
+		"sso-admin":    {"groups": []interface{}{"engineering", "platform-admins"}},
+		"sso-engineer": {"groups": "engineering"},
+		"sso-sales":    {"groups": []interface{}{"sales"}},
+	}
+	mapping, err := ParseRoleMapping([]string{"platform-admins:admin", "engineering:viewer"})
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authenticator := &OIDCAuthenticator{Verifier: verifier, RoleClaim: "groups", RoleMapping: mapping}
+	admin := router.Group("/admin", AdminAuth(AdminCredentials{}, authenticator))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	admin.GET("/keys", RequireRole(RoleViewer), ok)
+	admin.DELETE("/keys", RequireRole(RoleAdmin), ok)
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, "DELETE", "sso-admin"))
+	assert.Equal(t, http.StatusOK, adminRequest(router, "GET", "sso-engineer"))
+	assert.Equal(t, http.StatusForbidden, adminRequest(router, "DELETE", "sso-engineer"))
+	assert.Equal(t, http.StatusForbidden, adminRequest(router, "GET", "sso-sales"))
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(router, "GET", "forged"))
+}
+
+func TestOIDCAuthenticator_RoleNamesWithoutMapping(t *testing.T) {
+	authenticator := &OIDCAuthenticator{
+		Verifier:  fakeTokenVerifier{"token": {"roles": []interface{}{"viewer", "operator"}}},
+		RoleClaim: "roles",
+	}
+
+	identity, err := authenticator.Authenticate(context.Background(), "token")
+
+	assert.NoError(t, err)
+	assert.Equal(t, AdminIdentity{Role: RoleOperator}, identity)
+}
+
+func TestOIDCAuthenticator_OrganizationClaim(t *testing.T) {
+	authenticator := &OIDCAuthenticator{
+		Verifier: fakeTokenVerifier{
+			"tenant":   {"roles": []interface{}{"viewer"}, "org": "org-1"},
+			"platform": {"roles": []interface{}{"admin"}},
+			"empty":    {"roles": []interface{}{"admin"}, "org": ""},
+			"no-orgs":  {"roles": []interface{}{"admin"}, "org": []interface{}{}},
+			"null":     {"roles": []interface{}{"admin"}, "org": nil},
+		},
+		RoleClaim:         "roles",
+		OrganizationClaim: "org",
+	}
+
+	identity, err := authenticator.Authenticate(context.Background(), "tenant")
+	assert.NoError(t, err)
+	assert.Equal(t, AdminIdentity{Role: RoleViewer, OrganizationID: "org-1"}, identity)
+
+	identity, err = authenticator.Authenticate(context.Background(), "platform")
+	assert.NoError(t, err)
+	assert.Equal(t, AdminIdentity{Role: RoleAdmin}, identity)
+
+	// A present but empty claim must not fall back to platform access
+	for _, token := range []string{"empty", "no-orgs", "null"} {
+		identity, err = authenticator.Authenticate(context.Background(), token)
+		assert.Error(t, err, token)
+		assert.Equal(t, AdminIdentity{}, identity, token)
+	}
+}
+
+func TestReloadableAdminCredentials_Replace(t *testing.T) {
+	initial, err := ParseAdminCredentials([]string{"admin:old-token"})
+	assert.NoError(t, err)
+	credentials := NewReloadableAdminCredentials(initial)
+
+	identity, err := credentials.Authenticate(context.Background(), "old-token")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleAdmin, identity.Role)
+
+	rotated, err := ParseAdminCredentials([]string{"admin:new-token"})
+	assert.NoError(t, err)
+	credentials.Replace(rotated)
+
+	_, err = credentials.Authenticate(context.Background(), "old-token")
+	assert.Error(t, err)
+	identity, err = credentials.Authenticate(context.Background(), "new-token")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleAdmin, identity.Role)
+}
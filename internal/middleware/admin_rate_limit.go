@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AdminRateLimit throttles admin requests so a runaway script can't overload
+// the database through the admin API. Callers are counted per admin
+// credential when AdminAuth ran before it, otherwise (or with byIP) per
+// client IP. The admin API stays usable if the limiter is unavailable.
+func AdminRateLimit(limiter services.AdminRateLimiter, byIP bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := c.GetString(adminCallerContextKey)
+		if byIP || caller == "" {
+			caller = "ip:" + c.ClientIP()
+		}
+		throttle(c, limiter, caller, "Admin rate limit exceeded", "Too many admin requests. Please try again later.")
+	}
+}
+
+// TokenEndpointRateLimit throttles requests to the OAuth2 token endpoint
+// per client IP with the admin API's limits, so client secrets can't be
+// guessed at full speed. Its counters are separate from the admin API's.
+func TokenEndpointRateLimit(limiter services.AdminRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		throttle(c, limiter, "oauth:"+c.ClientIP(), "Token rate limit exceeded", "Too many token requests. Please try again later.")
+	}
+}
+
+// throttle counts the request against caller and aborts it with title and
+// message once over the limit. Requests go through when the limiter is
+// unavailable.
+func throttle(c *gin.Context, limiter services.AdminRateLimiter, caller, title, message string) {
+	result, err := limiter.CheckAdminLimit(c.Request.Context(), caller)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Admin rate limit check failed", zap.Error(err))
+		c.Next()
+		return
+	}
+
+	if result.Limit > 0 {
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		c.Header("X-RateLimit-Reset", result.ResetTime.Format(time.RFC3339))
+	}
+
+	if !result.Allowed {
+		c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+			"error":       title,
+			"message":     message,
+			"retry_after": int(time.Until(result.ResetTime).Seconds()),
+		}))
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdminLimiter allows limit requests per caller
+type fakeAdminLimiter struct {
+	limit  int64
+	counts map[string]int64
+	err    error
+}
+
+func (l *fakeAdminLimiter) CheckAdminLimit(ctx context.Context, caller string) (*services.RateLimitResult, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	l.counts[caller]++
+	return &services.RateLimitResult{
+		Allowed:   l.counts[caller] <= l.limit,
+		Limit:     l.limit,
+		ResetTime: time.Now().Add(time.Minute),
+	}, nil
+}
+
+func setupAdminRateLimitTest(t *testing.T, limiter services.AdminRateLimiter, byIP bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	credentials, err := ParseAdminCredentials([]string{"admin:token-a", "admin:token-b"})
+	assert.NoError(t, err)
+
+	router := gin.New()
+	admin := router.Group("/admin", AdminAuth(credentials), AdminRateLimit(limiter, byIP))
+	admin.GET("/keys", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func TestAdminRateLimit_PerCredential(t *testing.T) {
+	limiter := &fakeAdminLimiter{limit: 1, counts: map[string]int64{}}
+	router := setupAdminRateLimitTest(t, limiter, false)
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, "GET", "token-a"))
+	assert.Equal(t, http.StatusTooManyRequests, adminRequest(router, "GET", "token-a"))
+	assert.Equal(t, http.StatusOK, adminRequest(router, "GET", "token-b"))
+}
+
+func TestAdminRateLimit_PerIP(t *testing.T) {
+	limiter := &fakeAdminLimiter{limit: 1, counts: map[string]int64{}}
+	router := setupAdminRateLimitTest(t, limiter, true)
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, "GET", "token-a"))
+	assert.Equal(t, http.StatusTooManyRequests, adminRequest(router, "GET", "token-b"))
+}
+
+func TestAdminRateLimit_LimiterUnavailable(t *testing.T) {
+	limiter := &fakeAdminLimiter{err: errors.New("redis down")}
+	router := setupAdminRateLimitTest(t, limiter, false)
+
+	req, _ := http.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer token-a")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"grpc-firstls/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit refuses request bodies larger than maxBytes, or the limit of the
+// first rule matching the route, with 413 Request Entity Too Large. Bodies
+// announcing a larger Content-Length are refused up front; others are cut
+// off while being read, which handlers report through InvalidBody. A limit
+// of 0 leaves routes without a rule unlimited.
+func BodyLimit(maxBytes int64, rules ...config.BodyLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := maxBytes
+		for _, rule := range rules {
+			if routeMatches(c, rule.Method, rule.Route) {
+				limit = rule.MaxBytes
+				break
+			}
+		}
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, bodyTooLarge(c, limit))
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// InvalidBody returns the status and error body for a request body that
+// could not be bound: 413 when it was cut off by BodyLimit, 400 otherwise
+func InvalidBody(c *gin.Context, err error) (int, gin.H) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, bodyTooLarge(c, tooLarge.Limit)
+	}
+	return http.StatusBadRequest, ErrorBody(c, gin.H{
+		"error":   "Invalid request",
+		"message": err.Error(),
+	})
+}
+
+func bodyTooLarge(c *gin.Context, limit int64) gin.H {
+	return ErrorBody(c, gin.H{
+		"error":   "Request body too large",
+		"message": "The request body must not exceed " + strconv.FormatInt(limit, 10) + " bytes",
+	})
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-firstls/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupBodyLimitTestRouter(maxBytes int64, rules ...config.BodyLimitRule) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(BodyLimit(maxBytes, rules...))
+	bind := func(c *gin.Context) {
+		var request map[string]interface{}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(InvalidBody(c, err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+	router.POST("/api/test", bind)
+	router.POST("/v1/api/test", bind)
+	router.POST("/admin/api-keys", bind)
+	return router
+}
+
+func postBody(router *gin.Engine, path string, body string, chunked bool) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if chunked {
+		// Unknown length, so the limit is only hit while reading
+		req.ContentLength = -1
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func jsonBody(size int) string {
+	return `{"message":"` + strings.Repeat("a", size) + `"}`
+}
+
+func TestBodyLimit_Global(t *testing.T) {
+	router := setupBodyLimitTestRouter(64)
+
+	assert.Equal(t, http.StatusOK, postBody(router, "/api/test", jsonBody(10), false).Code)
+
+	for _, chunked := range []bool{false, true} {
+		w := postBody(router, "/api/test", jsonBody(100), chunked)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Request body too large", response["error"])
+		assert.Contains(t, response["message"], "64 bytes")
+	}
+}
+
+func TestBodyLimit_PerRoute(t *testing.T) {
+	router := setupBodyLimitTestRouter(64, config.BodyLimitRule{Method: "POST", Route: "/api/test", MaxBytes: 256})
+
+	// The rule applies to every API version of the route
+	assert.Equal(t, http.StatusOK, postBody(router, "/api/test", jsonBody(100), true).Code)
+	assert.Equal(t, http.StatusOK, postBody(router, "/v1/api/test", jsonBody(100), false).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postBody(router, "/v1/api/test", jsonBody(300), true).Code)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postBody(router, "/admin/api-keys", jsonBody(100), false).Code)
+}
+
+func TestBodyLimit_Disabled(t *testing.T) {
+	router := setupBodyLimitTestRouter(0)
+
+	assert.Equal(t, http.StatusOK, postBody(router, "/api/test", jsonBody(1<<16), false).Code)
+}
+
+func TestInvalidBody_Malformed(t *testing.T) {
+	router := setupBodyLimitTestRouter(64)
+
+	w := postBody(router, "/api/test", `{"message":`, false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Invalid request", response["error"])
+}
@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"grpc-firstls/internal/config"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCompressibleTypes are compressed when no content types are
+// configured
+var DefaultCompressibleTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"text/*",
+}
+
+// Compress compresses responses for clients that send Accept-Encoding. The
+// response is buffered until it reaches cfg.MinSize so short bodies are sent
+// as they are, and responses that already carry a Content-Encoding or whose
+// content type is not listed are never compressed. Event streams are
+// excluded unless listed explicitly.
+func Compress(cfg config.CompressionConfig) gin.HandlerFunc {
+	contentTypes := cfg.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressibleTypes
+	}
+
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), cfg.Brotli)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		c.Header("Vary", "Accept-Encoding")
+
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			minSize:        cfg.MinSize,
+			contentTypes:   contentTypes,
+			status:         http.StatusOK,
+		}
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+			// Anything still buffered when a handler panics is dropped, so
+			// Recovery can answer instead
+			if recovered := recover(); recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		c.Next()
+		writer.finish()
+	}
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, or ""
+// when the client accepts neither
+func negotiateEncoding(header string, allowBrotli bool) string {
+	accepted := map[string]bool{}
+	for _, entry := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	switch {
+	case allowBrotli && accepted["br"]:
+		return "br"
+	case accepted["gzip"]:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether to compress it
+type compressWriter struct {
+	gin.ResponseWriter
+
+	encoding     string
+	minSize      int
+	contentTypes []string
+
+	status      int
+	wroteHeader bool
+	buffer      bytes.Buffer
+	decided     bool
+	encoder     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code > 0 && !w.decided {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	w.wroteHeader = true
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *compressWriter) Written() bool {
+	return w.wroteHeader || w.buffer.Len() > 0 || w.decided
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.decided {
+		w.buffer.Write(data)
+		if w.buffer.Len() < w.minSize {
+			return len(data), nil
+		}
+		if err := w.decide(w.eligible()); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been buffered, compressed if eligible, so streaming
+// responses are not held back
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(w.eligible()); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hands the connection over, e.g. for WebSockets, leaving nothing
+// for the writer to send
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+// decide writes the status line and buffered body, starting compression
+// when compress is set
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if compress {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if w.encoding == "br" {
+			w.encoder = brotli.NewWriter(w.ResponseWriter)
+		} else {
+			w.encoder = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buffer.Len() == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+	w.buffer.Reset()
+	return err
+}
+
+// eligible reports whether the response's status, encoding and content
+// type allow compressing it
+func (w *compressWriter) eligible() bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	return compressibleType(header.Get("Content-Type"), w.contentTypes)
+}
+
+// finish sends a response that never reached the minimum size, as it is,
+// and closes the encoder
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+func compressibleType(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) && mediaType != "text/event-stream" {
+				return true
+			}
+			continue
+		}
+		if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
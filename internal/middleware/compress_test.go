@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-firstls/internal/config"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var largeText = strings.Repeat("rate limited ", 200)
+
+func setupCompressTestRouter(cfg config.CompressionConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Recovery(), Compress(cfg))
+	router.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"text": largeText})
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+	})
+	router.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(largeText))
+	})
+	router.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "application/json", []byte(largeText))
+	})
+	router.GET("/empty", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom")
+	})
+	return router
+}
+
+func getWithEncoding(router *gin.Engine, path string, acceptEncoding string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompress_Gzip(t *testing.T) {
+	router := setupCompressTestRouter(config.CompressionConfig{MinSize: 1024})
+
+	w := getWithEncoding(router, "/large", "gzip, deflate")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Less(t, w.Body.Len(), len(largeText))
+
+	reader, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), largeText)
+}
+
+func TestCompress_Brotli(t *testing.T) {
+	router := setupCompressTestRouter(config.CompressionConfig{MinSize: 1024, Brotli: true})
+
+	w := getWithEncoding(router, "/large", "gzip, br")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	body, err := io.ReadAll(brotli.NewReader(w.Body))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), largeText)
+
+	// Clients refusing brotli fall back to gzip
+	w = getWithEncoding(router, "/large", "br;q=0, gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	// Brotli is only used when enabled
+	router = setupCompressTestRouter(config.CompressionConfig{MinSize: 1024})
+	w = getWithEncoding(router, "/large", "br")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestCompress_Skipped(t *testing.T) {
+	router := setupCompressTestRouter(config.CompressionConfig{MinSize: 1024})
+
+	// Below the minimum size
+	w := getWithEncoding(router, "/small", "gzip")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+
+	// Content type not listed
+	w = getWithEncoding(router, "/image", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, largeText, w.Body.String())
+
+	// Already compressed by the handler
+	w = getWithEncoding(router, "/encoded", "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, largeText, w.Body.String())
+
+	// Client doesn't accept compression
+	w = getWithEncoding(router, "/large", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Body.String(), largeText)
+
+	// No body
+	w = getWithEncoding(router, "/empty", "gzip")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestCompress_ContentTypeFilter(t *testing.T) {
+	router := setupCompressTestRouter(config.CompressionConfig{MinSize: 1024, ContentTypes: []string{"image/*"}})
+
+	w := getWithEncoding(router, "/image", "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	w = getWithEncoding(router, "/large", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestCompress_PanicDropsBufferedResponse(t *testing.T) {
+	router := setupCompressTestRouter(config.CompressionConfig{MinSize: 1024})
+
+	w := getWithEncoding(router, "/panic", "gzip")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "partial")
+}
@@ -1,15 +1,48 @@
 package middleware
 
 import (
+	"strings"
+
+	"grpc-firstls/internal/config"
+
 	"github.com/gin-gonic/gin"
 )
 
+// DefaultCORSConfig is the policy applied by CORS: any origin, the methods
+// and headers used by the API, and credentials allowed
+func DefaultCORSConfig() config.CORSConfig {
+	return config.CORSConfig{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "X-API-Key", "X-End-User-ID", "X-Request-ID"},
+		AllowCredentials: true,
+	}
+}
+
 func CORS() gin.HandlerFunc {
+	policy := DefaultCORSConfig()
+	return CORSWithConfig(func() config.CORSConfig { return policy })
+}
+
+// CORSWithConfig applies the policy returned by policy, which is read on
+// every request so it can change while the server runs
+func CORSWithConfig(policy func() config.CORSConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key")
-		c.Header("Access-Control-Allow-Credentials", "true")
+		cors := policy()
+
+		allowedOrigin := corsAllowedOrigin(cors.AllowedOrigins, c.GetHeader("Origin"))
+		if allowedOrigin != "*" {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if allowedOrigin != "" {
+			c.Header("Access-Control-Allow-Origin", allowedOrigin)
+			c.Header("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+			c.Header("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+			c.Header("Access-Control-Expose-Headers", "X-Request-ID")
+			if cors.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -19,3 +52,17 @@ func CORS() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// corsAllowedOrigin returns the Access-Control-Allow-Origin value for origin,
+// or "" when it is not allowed
+func corsAllowedOrigin(allowed []string, origin string) string {
+	for _, entry := range allowed {
+		if entry == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(entry, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"grpc-firstls/internal/config"
+
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -43,7 +45,7 @@ func TestCORS_GetRequest(t *testing.T) {
 	// Check CORS headers
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-End-User-ID, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
 	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
 }
 
@@ -60,7 +62,7 @@ func TestCORS_OptionsRequest(t *testing.T) {
 	// Check CORS headers
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-End-User-ID, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
 	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
 }
 
@@ -77,7 +79,7 @@ func TestCORS_PostRequest(t *testing.T) {
 	// Check CORS headers
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-End-User-ID, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
 	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
 }
 
@@ -95,7 +97,7 @@ func TestCORS_WithOriginHeader(t *testing.T) {
 	// Check CORS headers
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-End-User-ID, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
 	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
 }
 
@@ -113,6 +115,45 @@ func TestCORS_WithCustomHeaders(t *testing.T) {
 	// Check CORS headers
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-End-User-ID, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
 	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
 }
+
+func TestCORSWithConfig_ListedOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	policy := config.CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "X-API-Key"},
+	}
+	router := gin.New()
+	router.Use(CORSWithConfig(func() config.CORSConfig { return policy }))
+	router.GET("/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	serve := func(origin string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	w = serve("https://evil.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// The policy is read on every request
+	policy.AllowedOrigins = []string{"*"}
+	w = serve("https://evil.example.com")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
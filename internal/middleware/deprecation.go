@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionPrefix matches the API version at the start of a path, e.g. "/v1"
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+(/|$)`)
+
+// unversionedPath strips the API version from path, so "/v1/admin/plans"
+// and "/admin/plans" are treated alike
+func unversionedPath(path string) string {
+	if loc := versionPrefix.FindStringIndex(path); loc != nil {
+		return "/" + path[loc[1]:]
+	}
+	return path
+}
+
+// Deprecated marks responses of unversioned endpoints as deprecated
+// (Deprecation header), announces when they will be removed (Sunset, RFC
+// 8594) and links to the same endpoint under successorPrefix.
+func Deprecated(successorPrefix string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", "<"+successorPrefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnversionedPath(t *testing.T) {
+	assert.Equal(t, "/admin/plans", unversionedPath("/v1/admin/plans"))
+	assert.Equal(t, "/api/test", unversionedPath("/v12/api/test"))
+	assert.Equal(t, "/", unversionedPath("/v1"))
+	assert.Equal(t, "/api/test", unversionedPath("/api/test"))
+	assert.Equal(t, "/videos/1", unversionedPath("/videos/1"))
+}
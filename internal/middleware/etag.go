@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag tags 200 responses to GET requests with a weak ETag of their body,
+// and answers requests whose If-None-Match header lists the current tag
+// with 304 Not Modified and no body, so dashboards polling for changes
+// don't download the same response again. Responses are buffered to tag
+// them; streams, which flush or take over the connection, and file
+// downloads, sent with Content-Disposition: attachment, are sent as they
+// are.
+func ETag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		writer := &etagWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		// Anything still buffered when a handler panics is dropped, so
+		// Recovery can answer instead
+		defer func() {
+			c.Writer = writer.ResponseWriter
+		}()
+
+		c.Next()
+		writer.finish(c.GetHeader("If-None-Match"))
+	}
+}
+
+// etagListed reports whether an If-None-Match header lists etag, comparing
+// tags weakly as RFC 9110 asks for
+func etagListed(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter buffers a response until it is complete and can be tagged
+type etagWriter struct {
+	gin.ResponseWriter
+
+	status      int
+	wroteHeader bool
+	buffer      bytes.Buffer
+	sent        bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if code > 0 && !w.sent {
+		w.status = code
+	}
+}
+
+func (w *etagWriter) WriteHeaderNow() {
+	w.wroteHeader = true
+}
+
+func (w *etagWriter) Status() int {
+	if w.sent {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *etagWriter) Written() bool {
+	return w.wroteHeader || w.buffer.Len() > 0 || w.sent
+}
+
+func (w *etagWriter) Write(data []byte) (int, error) {
+	if !w.sent && w.buffer.Len() == 0 && attachment(w.Header()) {
+		w.send()
+	}
+	if w.sent {
+		return w.ResponseWriter.Write(data)
+	}
+	w.wroteHeader = true
+	return w.buffer.Write(data)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been buffered untagged, so streaming responses are
+// not held back
+func (w *etagWriter) Flush() {
+	if !w.sent {
+		w.send()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hands the connection over, e.g. for WebSockets, leaving nothing
+// for the writer to send
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.sent = true
+	return w.ResponseWriter.Hijack()
+}
+
+// attachment reports whether a response is a file download, such as a key
+// export, which can be too large to buffer
+func attachment(header http.Header) bool {
+	disposition := strings.TrimSpace(header.Get("Content-Disposition"))
+	return len(disposition) >= len("attachment") && strings.EqualFold(disposition[:len("attachment")], "attachment")
+}
+
+// finish tags a buffered 200 response and sends it, or sends 304 Not
+// Modified instead when ifNoneMatch lists its tag
+func (w *etagWriter) finish(ifNoneMatch string) {
+	if w.sent {
+		return
+	}
+	if w.status == http.StatusOK {
+		sum := sha256.Sum256(w.buffer.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if ifNoneMatch != "" && etagListed(ifNoneMatch, etag) {
+			w.sent = true
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			w.ResponseWriter.WriteHeaderNow()
+			return
+		}
+	}
+	w.send()
+}
+
+// send writes the status line and the buffered body
+func (w *etagWriter) send() {
+	w.sent = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buffer.Len() == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.ResponseWriter.Write(w.buffer.Bytes())
+}
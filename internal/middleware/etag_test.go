@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupETagRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(ETag())
+	router.GET("/keys", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"api_keys": []string{"key-1"}})
+	})
+	router.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("data: first\n\n")
+		c.Writer.Flush()
+		c.Writer.WriteString("data: second\n\n")
+	})
+	router.GET("/export", func(c *gin.Context) {
+		c.Header("Content-Disposition", `attachment; filename="export.csv"`)
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("id,name\n")
+		// Nothing is held back for tagging
+		c.Writer.WriteString(strconv.Itoa(c.Writer.Size()) + ",key-1\n")
+	})
+	router.POST("/keys", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"api_key": "key-2"})
+	})
+	return router
+}
+
+func serveETag(router *gin.Engine, method, path, ifNoneMatch string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestETag(t *testing.T) {
+	router := setupETagRouter()
+
+	w := serveETag(router, "GET", "/keys", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^W/"[0-9a-f]{32}"$`, etag)
+	assert.JSONEq(t, `{"api_keys":["key-1"]}`, w.Body.String())
+
+	// The same body gets the same tag, and no body while it matches
+	w = serveETag(router, "GET", "/keys", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Body.String())
+
+	w = serveETag(router, "GET", "/keys", `"stale", `+etag[2:])
+	assert.Equal(t, http.StatusNotModified, w.Code, "tags are compared weakly and in lists")
+
+	w = serveETag(router, "GET", "/keys", `W/"stale"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"api_keys":["key-1"]}`, w.Body.String())
+
+	w = serveETag(router, "GET", "/keys", "*")
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestETag_UntaggedResponses(t *testing.T) {
+	router := setupETagRouter()
+
+	w := serveETag(router, "GET", "/missing", "*")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"error":"API key not found"}`, w.Body.String())
+
+	w = serveETag(router, "POST", "/keys", "*")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	// Streams are sent as they are flushed
+	w = serveETag(router, "GET", "/stream", "*")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "data: first\n\ndata: second\n\n", w.Body.String())
+	assert.True(t, w.Flushed)
+
+	// So are downloads
+	w = serveETag(router, "GET", "/export", "*")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "id,name\n8,key-1\n", w.Body.String())
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/oidc"
+)
+
+// ExternalTokenValidator accepts JWTs from an external identity provider in
+// place of API keys, so callers of an existing auth system are rate limited
+// without provisioning keys. Each subject (SubjectClaim, "sub" if empty) of
+// the issuer gets counters of its own; its limit and window in seconds come
+// from RequestsClaim and WindowClaim, and the defaults apply when they are
+// unset or missing from the token.
+type ExternalTokenValidator struct {
+	Verifier      TokenVerifier
+	SubjectClaim  string
+	RequestsClaim string
+	WindowClaim   string
+}
+
+// ValidateToken implements services.TokenValidator
+func (v *ExternalTokenValidator) ValidateToken(ctx context.Context, token string) (*database.APIKey, error) {
+	claims, err := v.Verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectClaim := v.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = "sub"
+	}
+	subject, _ := claims[subjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing %s", oidc.ErrInvalidToken, subjectClaim)
+	}
+	requests, err := limitClaim(claims, v.RequestsClaim)
+	if err != nil {
+		return nil, err
+	}
+	window, err := limitClaim(claims, v.WindowClaim)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, _ := claims["iss"].(string)
+	return &database.APIKey{
+		ID:                     externalKeyID(issuer, subject),
+		KeyPrefix:              subject,
+		Name:                   subject,
+		RateLimitRequests:      requests,
+		RateLimitWindowSeconds: window,
+		IsActive:               true,
+		External:               true,
+	}, nil
+}
+
+// limitClaim reads the non-negative number in claim name, a JSON number or
+// a string holding one; 0 when name is empty or the token lacks it
+func limitClaim(claims oidc.Claims, name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	var value int
+	var err error
+	switch claim := claims[name].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		value = int(claim)
+		if float64(value) != claim {
+			err = errors.New("not an integer")
+		}
+	case string:
+		value, err = strconv.Atoi(claim)
+	default:
+		err = errors.New("not a number")
+	}
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: %s must be a non-negative integer", oidc.ErrInvalidToken, name)
+	}
+	return value, nil
+}
+
+// externalKeyID derives a stable, name-based UUID (RFC 4122 version 5) for
+// the subject of an issuer, under which its counters are kept
+func externalKeyID(issuer, subject string) string {
+	hash := sha1.Sum([]byte(issuer + "\x00" + subject))
+	hash[6] = (hash[6] & 0x0f) | 0x50
+	hash[8] = (hash[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalTokenValidator(t *testing.T) {
+	validator := &ExternalTokenValidator{
+		Verifier: fakeTokenVerifier{
+			"a.b.alice":   {"iss": "https://login.example.com", "sub": "alice", "rate_limit": float64(500), "rate_window": "3600"},
+			"a.b.bob":     {"iss": "https://login.example.com", "sub": "bob"},
+			"a.b.nobody":  {"iss": "https://login.example.com"},
+			"a.b.invalid": {"iss": "https://login.example.com", "sub": "mallory", "rate_limit": float64(-1)},
+		},
+		RequestsClaim: "rate_limit",
+		WindowClaim:   "rate_window",
+	}
+	ctx := context.Background()
+
+	alice, err := validator.ValidateToken(ctx, "a.b.alice")
+	require.NoError(t, err)
+	assert.True(t, alice.External)
+	assert.Equal(t, "alice", alice.Name)
+	assert.Equal(t, 500, alice.RateLimitRequests)
+	assert.Equal(t, 3600, alice.RateLimitWindowSeconds)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, alice.ID)
+
+	// Each subject keeps its counters across tokens, and gets the default
+	// limits without the claims
+	again, err := validator.ValidateToken(ctx, "a.b.alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, again.ID)
+	bob, err := validator.ValidateToken(ctx, "a.b.bob")
+	require.NoError(t, err)
+	assert.NotEqual(t, alice.ID, bob.ID)
+	assert.Zero(t, bob.RateLimitRequests)
+
+	for _, token := range []string{"a.b.nobody", "a.b.invalid", "a.b.unknown"} {
+		_, err = validator.ValidateToken(ctx, token)
+		assert.ErrorIs(t, err, oidc.ErrInvalidToken, token)
+	}
+}
+
+func TestRateLimit_ExternalTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, mock.MatchedBy(func(apiKey *database.APIKey) bool {
+		return apiKey.External && apiKey.Name == "alice"
+	})).Return(createTestRateLimitResult(true, 9), nil)
+	counter := &countingUsageCounter{counts: map[string]int{}}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService,
+		WithTokenValidator(fakeTokens{}),
+		WithTokenValidator(&ExternalTokenValidator{Verifier: fakeTokenVerifier{"a.b.alice": {"iss": "https://login.example.com", "sub": "alice"}}}),
+		WithUsageCounter(counter),
+	))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("Authorization", "Bearer a.b.alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, counter.counts, "external callers have no key to count usage for")
+	mockRateLimitService.AssertExpectations(t)
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader carries the client's key for safely retrying a POST
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the longest key that can be stored
+const maxIdempotencyKeyLength = 255
+
+// Idempotency makes POST requests sent with an Idempotency-Key header safe to
+// retry: the first request with a key is performed and its response stored,
+// and retries of the same request get that response, marked with an
+// Idempotent-Replayed header, instead of e.g. creating another key. Keys are
+// scoped to the admin credential when AdminAuth ran before it. Responses
+// with a 5xx status aren't stored, so such requests can be retried.
+func Idempotency(store services.IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, ErrorBody(c, gin.H{
+				"error":   "Invalid idempotency key",
+				"message": "The " + IdempotencyKeyHeader + " header must not exceed " + strconv.Itoa(maxIdempotencyKeyLength) + " characters",
+			}))
+			c.Abort()
+			return
+		}
+
+		// A key is tied to the method, path and body it was first used with
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(InvalidBody(c, err))
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		io.WriteString(hash, c.Request.Method+" "+c.Request.URL.Path+"\n")
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		ctx := c.Request.Context()
+		scope := c.GetString(adminCallerContextKey)
+		stored, err := store.Begin(ctx, scope, key, requestHash)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyInUse):
+			c.JSON(http.StatusConflict, ErrorBody(c, gin.H{
+				"error":   "Request in progress",
+				"message": err.Error(),
+			}))
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			c.JSON(http.StatusUnprocessableEntity, ErrorBody(c, gin.H{
+				"error":   "Idempotency key reused",
+				"message": err.Error(),
+			}))
+			c.Abort()
+			return
+		case err != nil:
+			logging.FromContext(ctx).Error("Failed to check idempotency key", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, ErrorBody(c, gin.H{
+				"error":   "Failed to check idempotency key",
+				"message": err.Error(),
+			}))
+			c.Abort()
+			return
+		case stored != nil:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		completed := false
+		defer func() {
+			// Requests that panicked may be retried
+			if !completed {
+				if err := store.Release(ctx, scope, key); err != nil {
+					logging.FromContext(ctx).Error("Failed to release idempotency key", zap.Error(err))
+				}
+			}
+		}()
+
+		c.Next()
+
+		if writer.Status() < http.StatusInternalServerError {
+			response := services.IdempotentResponse{
+				StatusCode:  writer.Status(),
+				ContentType: writer.Header().Get("Content-Type"),
+				Body:        writer.body.Bytes(),
+			}
+			if err := store.Complete(ctx, scope, key, response); err != nil {
+				logging.FromContext(ctx).Error("Failed to store idempotent response", zap.Error(err))
+			} else {
+				completed = true
+			}
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the response body it writes
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIdempotencyStore keeps claimed keys and their responses in memory
+type fakeIdempotencyStore struct {
+	hashes    map[string]string
+	responses map[string]*services.IdempotentResponse
+}
+
+func (s *fakeIdempotencyStore) Begin(ctx context.Context, scope, key, requestHash string) (*services.IdempotentResponse, error) {
+	hash, ok := s.hashes[scope+key]
+	switch {
+	case !ok:
+		s.hashes[scope+key] = requestHash
+		return nil, nil
+	case hash != requestHash:
+		return nil, services.ErrIdempotencyKeyReused
+	case s.responses[scope+key] == nil:
+		return nil, services.ErrIdempotencyKeyInUse
+	}
+	return s.responses[scope+key], nil
+}
+
+func (s *fakeIdempotencyStore) Complete(ctx context.Context, scope, key string, response services.IdempotentResponse) error {
+	s.responses[scope+key] = &response
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	delete(s.hashes, scope+key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := &fakeIdempotencyStore{hashes: map[string]string{}, responses: map[string]*services.IdempotentResponse{}}
+	created, failures := 0, 0
+	router := gin.New()
+	router.Use(Idempotency(store))
+	router.POST("/admin/api-keys", func(c *gin.Context) {
+		created++
+		c.JSON(http.StatusCreated, gin.H{"created": created})
+	})
+	router.POST("/admin/flaky", func(c *gin.Context) {
+		failures++
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed"})
+	})
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/admin/api-keys", "retry-1", `{"name":"a"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Retries replay the first response
+	w = post("/admin/api-keys", "retry-1", `{"name":"a"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, `{"created":1}`, w.Body.String())
+	assert.Equal(t, 1, created)
+
+	w = post("/admin/api-keys", "retry-1", `{"name":"b"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Requests without a key are performed every time
+	post("/admin/api-keys", "", `{"name":"a"}`)
+	assert.Equal(t, 2, created)
+
+	assert.Equal(t, http.StatusBadRequest, post("/admin/api-keys", strings.Repeat("k", 256), "").Code)
+
+	// Server errors aren't stored, so the request can be retried
+	post("/admin/flaky", "retry-2", "")
+	post("/admin/flaky", "retry-2", "")
+	assert.Equal(t, 2, failures)
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"grpc-firstls/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyMetrics counts every request made with a valid API key in requests, by
+// rate limit decision, once the request has been handled. Like UsageLog it
+// has to run before RateLimit, which identifies the key.
+func KeyMetrics(requests *metrics.KeyRequests) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		apiKey := requestAPIKey(c)
+		if apiKey == nil {
+			return
+		}
+		requests.Observe(apiKey.ID, apiKey.PlanID, c.GetString(rateLimitDecisionContextKey))
+	}
+}
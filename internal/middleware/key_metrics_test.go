@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-firstls/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyMetrics_CountsDecisions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	requests, err := metrics.NewKeyRequests(metrics.KeyLabelsKey, 0, 0)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(KeyMetrics(requests), RateLimit(mockAPIKeyService, mockRateLimitService))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	apiKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(apiKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(true, 9), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(false, 0), nil).Once()
+
+	for _, key := range []string{"", "valid-key", "valid-key"} {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Requests without a valid key aren't counted
+	assert.NoError(t, testutil.CollectAndCompare(requests, strings.NewReader(`
+# HELP ratelimiter_key_requests_total Requests made with an API key, by rate limit decision.
+# TYPE ratelimiter_key_requests_total counter
+ratelimiter_key_requests_total{decision="allowed",key="test-id-123"} 1
+ratelimiter_key_requests_total{decision="limited",key="test-id-123"} 1
+`)))
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeLimitResponse answers a request refused for exceeding its key's rate
+// limit or quota with the key's custom response, filling in the variables of
+// its body and header values from result
+func (o *rateLimitOptions) writeLimitResponse(c *gin.Context, response *database.LimitResponse, result *services.RateLimitResult) {
+	retryAfter := o.secondsUntil(result.ResetTime)
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+	variables := strings.NewReplacer(
+		"{limit}", strconv.FormatInt(result.Limit, 10),
+		"{remaining}", strconv.FormatInt(result.Remaining, 10),
+		"{reset}", result.ResetTime.UTC().Format(time.RFC3339),
+		"{retry_after}", strconv.Itoa(retryAfter),
+	)
+
+	for name, value := range response.Headers {
+		c.Header(name, variables.Replace(value))
+	}
+	body := variables.Replace(response.Body)
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid([]byte(body)) {
+		contentType = "application/json; charset=utf-8"
+	}
+	// A Content-Type among the headers takes precedence
+	c.Data(http.StatusTooManyRequests, contentType, []byte(body))
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaintenanceMessage is sent to clients when no message is set
+const DefaultMaintenanceMessage = "The API is undergoing maintenance. Please try again later."
+
+// MaintenanceState describes whether the public API is in maintenance, how
+// long clients are told to wait before retrying, and since when
+type MaintenanceState struct {
+	Enabled    bool
+	RetryAfter time.Duration
+	Message    string
+	Since      time.Time
+}
+
+// RetryAfterSeconds is the Retry-After value sent to clients
+func (s MaintenanceState) RetryAfterSeconds() int {
+	return int(s.RetryAfter.Round(time.Second).Seconds())
+}
+
+// MaintenanceMode holds the maintenance state, which can be switched while
+// the server runs
+type MaintenanceMode struct {
+	state atomic.Pointer[MaintenanceState]
+}
+
+// NewMaintenanceMode returns a maintenance mode starting in state
+func NewMaintenanceMode(state MaintenanceState) *MaintenanceMode {
+	m := &MaintenanceMode{}
+	m.Set(state)
+	return m
+}
+
+// State returns the current maintenance state
+func (m *MaintenanceMode) State() MaintenanceState {
+	return *m.state.Load()
+}
+
+// Set replaces the maintenance state. Since is set to now when maintenance
+// starts, and kept while it continues.
+func (m *MaintenanceMode) Set(state MaintenanceState) {
+	if state.Enabled {
+		if current := m.state.Load(); current != nil && current.Enabled {
+			state.Since = current.Since
+		} else {
+			state.Since = time.Now().UTC()
+		}
+	} else {
+		state.Since = time.Time{}
+	}
+	m.state.Store(&state)
+}
+
+// Maintenance answers requests to the public /api endpoints with 503 and
+// Retry-After while maintenance is on. Health checks, documentation and the
+// admin API keep working. It must run before RateLimit so refused requests
+// don't count against any limit.
+func Maintenance(mode *MaintenanceMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := mode.State()
+		if !state.Enabled || !isAPIPath(unversionedPath(c.Request.URL.Path)) {
+			c.Next()
+			return
+		}
+
+		message := state.Message
+		if message == "" {
+			message = DefaultMaintenanceMessage
+		}
+		retryAfter := state.RetryAfterSeconds()
+		if retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+		}
+		c.JSON(http.StatusServiceUnavailable, ErrorBody(c, gin.H{
+			"error":       "Service under maintenance",
+			"message":     message,
+			"retry_after": retryAfter,
+		}))
+		c.Abort()
+	}
+}
+
+func isAPIPath(path string) bool {
+	return path == "/api" || strings.HasPrefix(path, "/api/")
+}
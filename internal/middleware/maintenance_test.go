@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mode := NewMaintenanceMode(MaintenanceState{RetryAfter: 2 * time.Minute})
+	router := gin.New()
+	router.Use(Maintenance(mode))
+	for _, path := range []string{"/v1/api/test", "/api/test", "/v1/admin/api-keys", "/health", "/v1/apis"} {
+		router.GET(path, func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		})
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/v1/api/test").Code)
+
+	mode.Set(MaintenanceState{Enabled: true, RetryAfter: 2 * time.Minute})
+	for _, path := range []string{"/v1/api/test", "/api/test"} {
+		w := serve(path)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Service under maintenance", response["error"])
+		assert.Equal(t, DefaultMaintenanceMessage, response["message"])
+		assert.Equal(t, float64(120), response["retry_after"])
+	}
+	for _, path := range []string{"/v1/admin/api-keys", "/health", "/v1/apis"} {
+		assert.Equal(t, http.StatusOK, serve(path).Code, path)
+	}
+
+	mode.Set(MaintenanceState{})
+	assert.Equal(t, http.StatusOK, serve("/v1/api/test").Code)
+}
+
+func TestMaintenanceMode_Since(t *testing.T) {
+	mode := NewMaintenanceMode(MaintenanceState{})
+	assert.True(t, mode.State().Since.IsZero())
+
+	mode.Set(MaintenanceState{Enabled: true})
+	since := mode.State().Since
+	assert.False(t, since.IsZero())
+
+	// Changing the message keeps the start time
+	mode.Set(MaintenanceState{Enabled: true, Message: "Migrating the database"})
+	assert.Equal(t, since, mode.State().Since)
+	assert.Equal(t, "Migrating the database", mode.State().Message)
+
+	mode.Set(MaintenanceState{})
+	assert.True(t, mode.State().Since.IsZero())
+}
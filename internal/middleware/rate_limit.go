@@ -1,62 +1,387 @@
 package middleware
 
 import (
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/oidc"
 	"grpc-firstls/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-func RateLimit(apiKeyService services.APIKeyServiceInterface, rateLimitService services.RateLimitServiceInterface) gin.HandlerFunc {
+// DefaultEndUserHeader carries the customer's own end-user identifier used for
+// per-end-user sublimits
+const DefaultEndUserHeader = "X-End-User-ID"
+
+type rateLimitOptions struct {
+	uniqueLimits  []config.UniqueLimitRule
+	endUserHeader string
+	usageRecorder services.UsageRecorder
+	usageCounter  services.UsageCounter
+	authFailures  services.AuthFailureTracker
+	limitAlerter  services.LimitAlerter
+	tokens        []services.TokenValidator
+	responseCache services.ResponseCacheInterface
+
+	signatureMaxSkew time.Duration
+	standardHeaders  bool
+	skipPaths        func() []string
+	skipMethods      func() []string
+	skipCIDRs        func() []string
+	skip             func(c *gin.Context) bool
+	keyExtractor     func(c *gin.Context) string
+	missingKeyHint   string
+	failOpen         bool
+	logger           *zap.Logger
+	now              func() time.Time
+}
+
+// RateLimitOption configures optional RateLimit middleware behaviour
+type RateLimitOption func(*rateLimitOptions)
+
+// WithUniqueLimits enables distinct-value limits on the routes matched by rules
+func WithUniqueLimits(rules []config.UniqueLimitRule) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.uniqueLimits = rules
+	}
+}
+
+// WithEndUserHeader sets the header that identifies the end user for
+// per-end-user sublimits
+func WithEndUserHeader(header string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if header != "" {
+			o.endUserHeader = header
+		}
+	}
+}
+
+// WithUsageRecorder records every successful authentication, e.g. to keep
+// last_used_at up to date
+func WithUsageRecorder(recorder services.UsageRecorder) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.usageRecorder = recorder
+	}
+}
+
+// WithUsageCounter counts every request that passes all limits
+func WithUsageCounter(counter services.UsageCounter) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.usageCounter = counter
+	}
+}
+
+// WithLimitAlerter raises an alert for keys refused for exceeding their rate
+// limit or quota, and for keys reaching one of their alert thresholds
+func WithLimitAlerter(alerter services.LimitAlerter) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.limitAlerter = alerter
+	}
+}
+
+// WithAuthFailureTracker locks out client IPs that keep presenting invalid
+// API keys
+func WithAuthFailureTracker(tracker services.AuthFailureTracker) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.authFailures = tracker
+	}
+}
+
+// WithTokenValidator also accepts tokens that stand in for an API key, such
+// as access tokens, wherever an API key is expected. Tokens are told apart
+// from keys by their JWT form. May be given more than once; the first
+// validator to accept a token wins.
+func WithTokenValidator(tokens services.TokenValidator) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.tokens = append(o.tokens, tokens)
+	}
+}
+
+// WithSignatureMaxSkew sets how old (or far in the future) a signed request's
+// timestamp may be
+func WithSignatureMaxSkew(maxSkew time.Duration) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if maxSkew > 0 {
+			o.signatureMaxSkew = maxSkew
+		}
+	}
+}
+
+// WithStandardHeaders also sends the IETF RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers next to the X-RateLimit-* headers
+func WithStandardHeaders(enabled bool) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.standardHeaders = enabled
+	}
+}
+
+// WithSkipPaths exempts the unversioned paths returned by paths from API key
+// checks and rate limiting, next to the built-in health and documentation
+// paths. A trailing "*" matches any path with that prefix. paths is called
+// on every request so the list can change while the server runs.
+func WithSkipPaths(paths func() []string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.skipPaths = paths
+	}
+}
+
+// WithSkipMethods exempts requests with the HTTP methods returned by methods,
+// e.g. OPTIONS, from API key checks and rate limiting. methods is called on
+// every request so the list can change while the server runs.
+func WithSkipMethods(methods func() []string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.skipMethods = methods
+	}
+}
+
+// WithSkipCIDRs exempts clients whose IP address is one of, or in one of the
+// CIDR ranges, returned by cidrs from API key checks and rate limiting. The
+// client IP only comes from forwarding headers set by the router's trusted
+// proxies. cidrs is called on every request so the list can change while
+// the server runs.
+func WithSkipCIDRs(cidrs func() []string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.skipCIDRs = cidrs
+	}
+}
+
+// WithSkip replaces the built-in exemption of the health and documentation
+// paths: requests skip returns true for bypass API key checks and rate
+// limiting. WithSkipPaths, WithSkipMethods and WithSkipCIDRs still apply.
+func WithSkip(skip func(c *gin.Context) bool) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if skip != nil {
+			o.skip = skip
+		}
+	}
+}
+
+// WithAPIKeyExtractor reads the API key from requests with extract instead
+// of the X-API-Key and Authorization headers
+func WithAPIKeyExtractor(extract func(c *gin.Context) string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if extract != nil {
+			o.keyExtractor = extract
+			o.missingKeyHint = "Please provide an API key"
+		}
+	}
+}
+
+// WithAPIKeyHeader reads the API key from header instead of X-API-Key. An
+// "Authorization: Bearer" header is still accepted when it is missing.
+func WithAPIKeyHeader(header string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if header != "" {
+			o.keyExtractor = func(c *gin.Context) string {
+				return APIKeyFromHeader(c, header)
+			}
+			o.missingKeyHint = "Please provide an API key in the " + header + " header or Authorization header"
+		}
+	}
+}
+
+// WithLogger logs through logger for requests whose context carries no
+// logger of its own, instead of through the global logger
+func WithLogger(logger *zap.Logger) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithClock tells the time with now, e.g. a fixed clock in tests, when
+// working out retry_after, RateLimit-Reset and signature timestamp skew
+func WithClock(now func() time.Time) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if now != nil {
+			o.now = now
+		}
+	}
+}
+
+// WithFailOpen lets requests through when their limits can't be checked,
+// e.g. while Redis is unavailable, instead of refusing them with a 500
+func WithFailOpen(enabled bool) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.failOpen = enabled
+	}
+}
+
+func RateLimit(apiKeyService services.APIKeyServiceInterface, rateLimitService services.RateLimitServiceInterface, opts ...RateLimitOption) gin.HandlerFunc {
+	options := &rateLimitOptions{
+		endUserHeader:    DefaultEndUserHeader,
+		signatureMaxSkew: DefaultSignatureMaxSkew,
+		skip:             serviceRoute,
+		keyExtractor:     APIKeyFromRequest,
+		missingKeyHint:   "Please provide an API key in the X-API-Key header or Authorization header",
+		now:              time.Now,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return func(c *gin.Context) {
-		// Skip rate limiting for health check and admin endpoints
-		if c.Request.URL.Path == "/health" || c.Request.URL.Path == "/metrics" || strings.HasPrefix(c.Request.URL.Path, "/admin") {
+		if options.skip(c) || options.exempt(c) {
 			c.Next()
 			return
 		}
 
-		// Get API key from header
-		apiKey := c.GetHeader("X-API-Key")
+		apiKey := options.keyExtractor(c)
 		if apiKey == "" {
-			// Try Authorization header as fallback
-			authHeader := c.GetHeader("Authorization")
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-				apiKey = strings.TrimPrefix(authHeader, "Bearer ")
+			setRateLimitDecision(c, "missing_key")
+			c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+				"error":   "API key required",
+				"message": options.missingKeyHint,
+			}))
+			c.Abort()
+			return
+		}
+
+		// Clients locked out for guessing keys are refused before validation
+		if options.authFailures != nil {
+			lockout, err := options.authFailures.AuthLockout(c.Request.Context(), c.ClientIP())
+			if err != nil {
+				options.loggerFor(c).Error("Failed to check auth lockout", zap.String("client_ip", c.ClientIP()), zap.Error(err))
+			}
+			if lockout > 0 {
+				abortAuthLockout(c, lockout)
+				return
 			}
 		}
 
-		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "API key required",
-				"message": "Please provide an API key in the X-API-Key header or Authorization header",
-			})
+		// Validate API key, or the token standing in for one
+		token := len(options.tokens) > 0 && isToken(apiKey)
+		var apiKeyRecord *database.APIKey
+		var err error
+		if token {
+			apiKeyRecord, err = validateToken(c, options.tokens, apiKey)
+		} else {
+			apiKeyRecord, err = apiKeyService.ValidateAPIKey(c.Request.Context(), apiKey)
+		}
+		if err != nil && !invalidCredential(err) {
+			// Outages of the database or an identity provider say nothing
+			// about the client, so they aren't counted as failed attempts
+			options.loggerFor(c).Error("Failed to validate credential", zap.Error(err))
+			setRateLimitDecision(c, "error")
+			c.JSON(http.StatusServiceUnavailable, ErrorBody(c, gin.H{
+				"error":   "Authentication unavailable",
+				"message": "Unable to validate the credential right now. Please try again later.",
+			}))
 			c.Abort()
 			return
 		}
-
-		// Validate API key
-		apiKeyRecord, err := apiKeyService.ValidateAPIKey(apiKey)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "Invalid API key",
-				"message": "The provided API key is invalid or inactive",
-			})
+			if options.authFailures != nil {
+				lockout, err := options.authFailures.RecordAuthFailure(c.Request.Context(), c.ClientIP())
+				if err != nil {
+					options.loggerFor(c).Error("Failed to record auth failure", zap.String("client_ip", c.ClientIP()), zap.Error(err))
+				}
+				if lockout > 0 {
+					abortAuthLockout(c, lockout)
+					return
+				}
+			}
+			setRateLimitDecision(c, "invalid_key")
+			if token {
+				c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+					"error":   "Invalid access token",
+					"message": "The provided access token is invalid or expired, or its API key is inactive",
+				}))
+			} else {
+				c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+					"error":   "Invalid API key",
+					"message": "The provided API key is invalid or inactive",
+				}))
+			}
 			c.Abort()
 			return
 		}
 
+		// Store API key info in context for use in handlers and the access log
+		c.Set("api_key", apiKeyRecord)
+
+		// Reject requests from networks outside the key's allowlist. ClientIP
+		// only honours forwarding headers from the router's trusted proxies.
+		if !ClientIPAllowed(c.ClientIP(), apiKeyRecord.AllowedCIDRs) {
+			setRateLimitDecision(c, "ip_denied")
+			c.JSON(http.StatusForbidden, ErrorBody(c, gin.H{
+				"error":   "IP address not allowed",
+				"message": "This API key may not be used from your network",
+			}))
+			c.Abort()
+			return
+		}
+
+		// Browser-facing keys only work from their registered sites
+		if !originAllowed(requestOrigin(c), apiKeyRecord.AllowedOrigins) {
+			setRateLimitDecision(c, "origin_denied")
+			c.JSON(http.StatusForbidden, ErrorBody(c, gin.H{
+				"error":   "Origin not allowed",
+				"message": "This API key may not be used from this site",
+			}))
+			c.Abort()
+			return
+		}
+
+		// Keys in signing mode must prove possession of the signing secret
+		if apiKeyRecord.RequireSignature {
+			if err := verifySignature(c, apiKeyRecord.SigningSecret, options.signatureMaxSkew, options.now()); err != nil {
+				setRateLimitDecision(c, "bad_signature")
+				c.JSON(http.StatusUnauthorized, ErrorBody(c, gin.H{
+					"error":   "Invalid signature",
+					"message": err.Error(),
+				}))
+				c.Abort()
+				return
+			}
+		}
+
+		// Callers of external tokens have no key whose usage could be stored
+		if options.usageRecorder != nil && !apiKeyRecord.External {
+			options.usageRecorder.RecordUse(apiKeyRecord.ID)
+		}
+
+		// Refunds aren't counted or limited, so keys that used up their
+		// limit can still give units back. Reservations take their units
+		// from the limit, the plan quota and the organization's ceiling
+		// themselves, and tokens with a limit of their own can't make them.
+		if decision := uncountedRoute(c); decision != "" {
+			setRateLimitDecision(c, decision)
+			c.Next()
+			return
+		}
+
+		// Responses served from the cache aren't counted or limited
+		if options.responseCache != nil {
+			served, store := options.serveCachedResponse(c, apiKeyRecord)
+			if served {
+				return
+			}
+			if store != nil {
+				defer store()
+			}
+		}
+
 		// Check rate limit
 		rateLimitResult, err := rateLimitService.CheckRateLimit(c.Request.Context(), apiKeyRecord)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Rate limit check failed",
-				"message": "Unable to check rate limit",
-			})
-			c.Abort()
+			if options.limitCheckFailed(c, apiKeyRecord, err) {
+				return
+			}
+			c.Next()
 			return
 		}
 
@@ -64,20 +389,450 @@ func RateLimit(apiKeyService services.APIKeyServiceInterface, rateLimitService s
 		c.Header("X-RateLimit-Limit", strconv.FormatInt(rateLimitResult.Limit, 10))
 		c.Header("X-RateLimit-Remaining", strconv.FormatInt(rateLimitResult.Remaining, 10))
 		c.Header("X-RateLimit-Reset", rateLimitResult.ResetTime.Format(time.RFC3339))
+		if options.standardHeaders {
+			options.setStandardRateLimitHeaders(c, rateLimitResult)
+		}
+
+		// Keys serving an abuse cooldown get a distinct message
+		if rateLimitResult.Penalized() {
+			setRateLimitDecision(c, "penalized")
+			c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+				"error":       "Temporarily blocked",
+				"message":     "This API key repeatedly exceeded its rate limit and is in a cooldown period.",
+				"retry_after": options.secondsUntil(rateLimitResult.PenaltyExpiresAt),
+			}))
+			c.Abort()
+			return
+		}
 
 		// Check if rate limit exceeded
 		if !rateLimitResult.Allowed {
-			c.JSON(http.StatusTooManyRequests, gin.H{
+			setRateLimitDecision(c, "limited")
+			if options.limitAlerter != nil {
+				eventType := events.RateLimitExceeded
+				if rateLimitResult.QuotaExceeded {
+					eventType = events.QuotaExceeded
+				}
+				if err := options.limitAlerter.NotifyLimitExceeded(c.Request.Context(), apiKeyRecord, eventType, rateLimitResult); err != nil {
+					options.loggerFor(c).Error("Failed to raise limit alert", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+				}
+			}
+			if apiKeyRecord.LimitResponse != nil {
+				options.writeLimitResponse(c, apiKeyRecord.LimitResponse, rateLimitResult)
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
 				"error":       "Rate limit exceeded",
 				"message":     "You have exceeded your rate limit. Please try again later.",
-				"retry_after": int(time.Until(rateLimitResult.ResetTime).Seconds()),
-			})
+				"retry_after": options.secondsUntil(rateLimitResult.ResetTime),
+			}))
 			c.Abort()
 			return
 		}
 
-		// Store API key info in context for use in handlers
-		c.Set("api_key", apiKeyRecord)
+		// Keys nearing their limit are warned on every request, and alerted
+		// once as each of their thresholds is reached
+		if rateLimitResult.Threshold > 0 {
+			c.Header("X-RateLimit-Warning", ThresholdWarning(rateLimitResult))
+			if rateLimitResult.ThresholdCrossed && options.limitAlerter != nil {
+				if err := options.limitAlerter.NotifyLimitExceeded(c.Request.Context(), apiKeyRecord, events.LimitThresholdReached, rateLimitResult); err != nil {
+					options.loggerFor(c).Error("Failed to raise threshold alert", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+				}
+			}
+		}
+
+		// Limits below that can't be checked are skipped when failing open
+		decision := "allowed"
+
+		// Enforce the ceiling shared by all keys of the key's organization
+		if apiKeyRecord.OrganizationLimitRequests > 0 {
+			organizationResult, err := rateLimitService.CheckOrganizationLimit(c.Request.Context(), apiKeyRecord)
+			if err != nil {
+				if options.limitCheckFailed(c, apiKeyRecord, err) {
+					return
+				}
+				decision = "fail_open"
+			} else if !organizationResult.Allowed {
+				setRateLimitDecision(c, "organization_limited")
+				c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+					"error":       "Organization rate limit exceeded",
+					"message":     "Your organization has exceeded its rate limit across all of its API keys. Please try again later.",
+					"limit":       organizationResult.Limit,
+					"retry_after": options.secondsUntil(organizationResult.ResetTime),
+				}))
+				c.Abort()
+				return
+			}
+		}
+
+		// Enforce the limit an access token was scoped down to
+		if apiKeyRecord.TokenLimitRequests > 0 {
+			tokenResult, err := rateLimitService.CheckTokenLimit(c.Request.Context(), apiKeyRecord)
+			if err != nil {
+				if options.limitCheckFailed(c, apiKeyRecord, err) {
+					return
+				}
+				decision = "fail_open"
+			} else if !tokenResult.Allowed {
+				setRateLimitDecision(c, "token_limited")
+				c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+					"error":       "Token rate limit exceeded",
+					"message":     "This access token has exceeded the rate limit it was issued with. Please try again later.",
+					"limit":       tokenResult.Limit,
+					"retry_after": options.secondsUntil(tokenResult.ResetTime),
+				}))
+				c.Abort()
+				return
+			}
+		}
+
+		// Enforce the per-end-user sublimit when the key declares one
+		if endUserID := c.GetHeader(options.endUserHeader); endUserID != "" && apiKeyRecord.EndUserLimitRequests > 0 {
+			endUserResult, err := rateLimitService.CheckEndUserLimit(c.Request.Context(), apiKeyRecord, endUserID)
+			if err != nil {
+				if options.limitCheckFailed(c, apiKeyRecord, err) {
+					return
+				}
+				decision = "fail_open"
+			} else if !endUserResult.Allowed {
+				setRateLimitDecision(c, "end_user_limited")
+				c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+					"error":       "End-user rate limit exceeded",
+					"message":     "This end user has exceeded its share of the API key's rate limit. Please try again later.",
+					"limit":       endUserResult.Limit,
+					"retry_after": options.secondsUntil(endUserResult.ResetTime),
+				}))
+				c.Abort()
+				return
+			}
+		}
+
+		// Enforce distinct-value limits configured for this route
+		for _, rule := range options.uniqueLimits {
+			if !uniqueRuleMatches(c, rule) {
+				continue
+			}
+			value := uniqueRuleValue(c, rule)
+			if value == "" {
+				continue
+			}
+
+			uniqueResult, err := rateLimitService.CheckUniqueLimit(c.Request.Context(), apiKeyRecord, rule, value)
+			if err != nil {
+				if options.limitCheckFailed(c, apiKeyRecord, err) {
+					return
+				}
+				decision = "fail_open"
+				continue
+			}
+			if !uniqueResult.Allowed {
+				setRateLimitDecision(c, "unique_limited")
+				c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+					"error":       "Unique resource limit exceeded",
+					"message":     "You have used too many distinct " + rule.Field + " values. Please try again later.",
+					"limit":       uniqueResult.Limit,
+					"retry_after": options.secondsUntil(uniqueResult.ResetTime),
+				}))
+				c.Abort()
+				return
+			}
+		}
+
+		// Usage counting must never fail the request
+		if options.usageCounter != nil && !apiKeyRecord.External {
+			if err := options.usageCounter.IncrementUsage(c.Request.Context(), apiKeyRecord.ID); err != nil {
+				options.loggerFor(c).Error("Failed to count usage", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+			}
+		}
+
+		setRateLimitDecision(c, decision)
 		c.Next()
 	}
 }
+
+// exempt reports whether the request's path, method or client IP is one of
+// those configured to skip API key checks and rate limiting
+func (o *rateLimitOptions) exempt(c *gin.Context) bool {
+	if o.skipPaths != nil && pathListed(o.skipPaths(), unversionedPath(c.Request.URL.Path)) {
+		return true
+	}
+	if o.skipMethods != nil {
+		for _, method := range o.skipMethods() {
+			if c.Request.Method == method {
+				return true
+			}
+		}
+	}
+	return o.skipCIDRs != nil && ipListed(o.skipCIDRs(), c.ClientIP())
+}
+
+// ThresholdWarning describes the alert threshold a request reached, e.g.
+// "80% of rate limit used", for the X-RateLimit-Warning header
+func ThresholdWarning(result *services.RateLimitResult) string {
+	limit := "rate limit"
+	if result.ThresholdQuota {
+		limit = "quota"
+	}
+	return strconv.FormatInt(result.Threshold, 10) + "% of " + limit + " used"
+}
+
+// serviceRoute reports the health check, metrics and documentation
+// endpoints, which are never rate limited. The admin, self-service and
+// OAuth2 token endpoints authenticate callers themselves and are served
+// outside of RateLimit instead.
+func serviceRoute(c *gin.Context) bool {
+	path := unversionedPath(c.Request.URL.Path)
+	return path == "/health" || path == "/livez" || path == "/readyz" || path == "/version" || path == "/metrics" || path == "/openapi.json" || path == "/docs" || strings.HasPrefix(path, "/docs/assets/")
+}
+
+// uncountedRoute returns the rate limit decision logged for the endpoints
+// that are authenticated but never counted: the refund and reservation
+// endpoints. It returns "" for every other request.
+func uncountedRoute(c *gin.Context) string {
+	path := unversionedPath(c.Request.URL.Path)
+	switch {
+	case c.Request.Method == http.MethodPost && path == "/api/rate-limit/refund":
+		return "refund"
+	case (c.Request.Method == http.MethodPost || c.Request.Method == http.MethodDelete) &&
+		(path == "/api/rate-limit/reservations" || strings.HasPrefix(path, "/api/rate-limit/reservations/")):
+		return "reservation"
+	}
+	return ""
+}
+
+// APIKeyFromRequest returns the API key in the X-API-Key header, or in an
+// "Authorization: Bearer" header when there is none
+func APIKeyFromRequest(c *gin.Context) string {
+	return APIKeyFromHeader(c, "X-API-Key")
+}
+
+// APIKeyFromHeader returns the API key in header, or in an "Authorization:
+// Bearer" header when there is none
+func APIKeyFromHeader(c *gin.Context, header string) string {
+	if apiKey := c.GetHeader(header); apiKey != "" {
+		return apiKey
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return ""
+}
+
+// validateToken tries each of validators in turn and returns the key of the
+// first one to accept token, or the last error
+func validateToken(c *gin.Context, validators []services.TokenValidator, token string) (*database.APIKey, error) {
+	var rejected error
+	for _, validator := range validators {
+		apiKeyRecord, err := validator.ValidateToken(c.Request.Context(), token)
+		if err == nil {
+			return apiKeyRecord, nil
+		}
+		// A validator that couldn't check the token may have accepted it
+		if !invalidCredential(err) {
+			return nil, err
+		}
+		rejected = err
+	}
+	return nil, rejected
+}
+
+// invalidCredential reports whether err rejects a credential as unknown,
+// inactive or forged, rather than reporting that it couldn't be checked
+func invalidCredential(err error) bool {
+	return errors.Is(err, services.ErrInvalidAPIKey) || errors.Is(err, services.ErrInvalidAccessToken) || errors.Is(err, oidc.ErrInvalidToken)
+}
+
+// isToken reports whether credential has the form of a JWT rather than of an
+// API key
+func isToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// limitCheckFailed handles a limit that couldn't be checked. With fail open
+// the request is marked as let through and false is returned so the caller
+// skips the check; otherwise the request is refused and true is returned.
+func (o *rateLimitOptions) limitCheckFailed(c *gin.Context, apiKeyRecord *database.APIKey, err error) bool {
+	logger := o.loggerFor(c)
+	if o.failOpen {
+		logger.Warn("Rate limit check failed, letting the request through", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+		setRateLimitDecision(c, "fail_open")
+		return false
+	}
+
+	logger.Error("Rate limit check failed", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+	setRateLimitDecision(c, "error")
+	c.JSON(http.StatusInternalServerError, ErrorBody(c, gin.H{
+		"error":   "Rate limit check failed",
+		"message": "Unable to check rate limit",
+	}))
+	c.Abort()
+	return true
+}
+
+// loggerFor returns the request's logger, falling back to the one set with
+// WithLogger
+func (o *rateLimitOptions) loggerFor(c *gin.Context) *zap.Logger {
+	if o.logger == nil {
+		return logging.FromContext(c.Request.Context())
+	}
+	return logging.FromContextOr(c.Request.Context(), o.logger)
+}
+
+// secondsUntil is the whole number of seconds left until t
+func (o *rateLimitOptions) secondsUntil(t time.Time) int {
+	return int(t.Sub(o.now()).Seconds())
+}
+
+// setRateLimitDecision records why the request was let through or refused,
+// for the access log
+func setRateLimitDecision(c *gin.Context, decision string) {
+	c.Set(rateLimitDecisionContextKey, decision)
+}
+
+// setStandardRateLimitHeaders sends the header fields of the IETF
+// "RateLimit header fields for HTTP" draft. Unlike X-RateLimit-Reset, the
+// reset is given in seconds from now, so it doesn't depend on clock sync.
+func (o *rateLimitOptions) setStandardRateLimitHeaders(c *gin.Context, result *services.RateLimitResult) {
+	reset := int64(math.Ceil(result.ResetTime.Sub(o.now()).Seconds()))
+	if reset < 0 {
+		reset = 0
+	}
+	c.Header("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(reset, 10))
+}
+
+func abortAuthLockout(c *gin.Context, lockout time.Duration) {
+	setRateLimitDecision(c, "locked_out")
+	c.JSON(http.StatusTooManyRequests, ErrorBody(c, gin.H{
+		"error":       "Too many failed attempts",
+		"message":     "Too many invalid API keys were sent from your network. Please try again later.",
+		"retry_after": int(lockout.Seconds()),
+	}))
+	c.Abort()
+}
+
+func uniqueRuleMatches(c *gin.Context, rule config.UniqueLimitRule) bool {
+	return routeMatches(c, rule.Method, rule.Route)
+}
+
+// pathListed reports whether path equals an entry of paths, or starts with
+// an entry ending in "*"
+func pathListed(paths []string, path string) bool {
+	for _, entry := range paths {
+		if strings.HasSuffix(entry, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+		} else if path == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMatches reports whether the request is for route, on any API version,
+// and method; an empty method matches all
+func routeMatches(c *gin.Context, method, route string) bool {
+	if method != "" && method != c.Request.Method {
+		return false
+	}
+	path := c.FullPath()
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+	return unversionedPath(path) == route
+}
+
+func uniqueRuleValue(c *gin.Context, rule config.UniqueLimitRule) string {
+	switch rule.Source {
+	case "header":
+		return c.GetHeader(rule.Field)
+	case "query":
+		return c.Query(rule.Field)
+	case "param":
+		return c.Param(rule.Field)
+	}
+	return ""
+}
+
+// ClientIPAllowed reports whether ip falls inside one of the allowed networks.
+// An empty allowlist permits every address.
+func ClientIPAllowed(ip string, allowedCIDRs []string) bool {
+	if len(allowedCIDRs) == 0 {
+		return true
+	}
+
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return false
+	}
+
+	for _, cidr := range allowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipListed reports whether ip is one of entries, IP addresses and CIDR ranges
+func ipListed(entries []string, ip string) bool {
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return false
+	}
+	for _, entry := range entries {
+		if listed := net.ParseIP(entry); listed != nil {
+			if listed.Equal(clientIP) {
+				return true
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOrigin returns the request's Origin, falling back to the origin of
+// the Referer for requests (e.g. same-origin GETs) that omit it
+func requestOrigin(c *gin.Context) string {
+	if origin := c.GetHeader("Origin"); origin != "" {
+		return strings.ToLower(origin)
+	}
+	referer, err := url.Parse(c.GetHeader("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return strings.ToLower(referer.Scheme + "://" + referer.Host)
+}
+
+// originAllowed reports whether origin matches one of the allowed origins,
+// where "scheme://*.domain" matches any subdomain of domain. An empty
+// allowlist permits every request, including those without an origin.
+func originAllowed(origin string, allowedOrigins []string) bool {
+	if len(allowedOrigins) == 0 {
+		return true
+	}
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+	return false
+}
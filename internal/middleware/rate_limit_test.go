@@ -3,17 +3,23 @@ package middleware
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
 	"grpc-firstls/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // MockAPIKeyService is a mock implementation of APIKeyServiceInterface
@@ -21,24 +27,141 @@ type MockAPIKeyService struct {
 	mock.Mock
 }
 
-func (m *MockAPIKeyService) ValidateAPIKey(apiKey string) (*database.APIKey, error) {
-	args := m.Called(apiKey)
+func (m *MockAPIKeyService) ValidateAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*database.APIKey), args.Error(1)
 }
 
-func (m *MockAPIKeyService) CreateAPIKey(name string, rateLimitRequests int, rateLimitWindowSeconds int) (string, error) {
-	args := m.Called(name, rateLimitRequests, rateLimitWindowSeconds)
+func (m *MockAPIKeyService) CreateAPIKey(ctx context.Context, params services.CreateAPIKeyParams) (string, error) {
+	args := m.Called(ctx, params)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockAPIKeyService) DeactivateAPIKey(apiKey string) error {
-	args := m.Called(apiKey)
+func (m *MockAPIKeyService) CreateAPIKeys(ctx context.Context, params []services.CreateAPIKeyParams) ([]string, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListAPIKeys(ctx context.Context, filter services.APIKeyFilter) ([]*database.APIKey, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyOwner(ctx context.Context, apiKey string, ownerName string, ownerEmail string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, ownerName, ownerEmail)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RenameAPIKey(ctx context.Context, apiKey string, name string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyAlertThresholds(ctx context.Context, apiKey string, thresholds []int64) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, thresholds)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyRefundLimit(ctx context.Context, apiKey string, limit int) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKeyLimitResponse(ctx context.Context, apiKey string, response *database.LimitResponse) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey, response)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListSubKeys(ctx context.Context, parentID string) ([]*database.APIKey, error) {
+	args := m.Called(ctx, parentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) DeleteAPIKey(ctx context.Context, apiKey string) error {
+	args := m.Called(ctx, apiKey)
 	return args.Error(0)
 }
 
+func (m *MockAPIKeyService) RestoreAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) PurgeAPIKey(ctx context.Context, apiKey string) (string, error) {
+	args := m.Called(ctx, apiKey)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockAPIKeyService) CreateLimitOverride(ctx context.Context, apiKey string, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	args := m.Called(ctx, apiKey, rateLimitRequests, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.LimitOverride), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RotateAPIKey(ctx context.Context, apiKey string, gracePeriod time.Duration) (*services.RotatedAPIKey, error) {
+	args := m.Called(ctx, apiKey, gracePeriod)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RotatedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ExportAPIKeys(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*database.ExportedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ImportAPIKeys(ctx context.Context, keys []*database.ExportedAPIKey, dryRun bool) (*services.ImportReport, error) {
+	args := m.Called(ctx, keys, dryRun)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.ImportReport), args.Error(1)
+}
+
 // MockRateLimitService is a mock implementation of RateLimitServiceInterface
 type MockRateLimitService struct {
 	mock.Mock
@@ -60,6 +183,85 @@ func (m *MockRateLimitService) GetRateLimitStatus(ctx context.Context, apiKey *d
 	return args.Get(0).(*services.RateLimitResult), args.Error(1)
 }
 
+func (m *MockRateLimitService) CheckEndUserLimit(ctx context.Context, apiKey *database.APIKey, endUserID string) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey, endUserID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckOrganizationLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckTokenLimit(ctx context.Context, apiKey *database.APIKey) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CheckUniqueLimit(ctx context.Context, apiKey *database.APIKey, rule config.UniqueLimitRule, value string) (*services.RateLimitResult, error) {
+	args := m.Called(ctx, apiKey, rule, value)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RateLimitResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) RefundRateLimit(ctx context.Context, apiKey *database.APIKey, units int64) (*services.RefundResult, error) {
+	args := m.Called(ctx, apiKey, units)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.RefundResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) ReserveRateLimit(ctx context.Context, apiKey *database.APIKey, units int64, ttl time.Duration) (*services.ReservationResult, error) {
+	args := m.Called(ctx, apiKey, units, ttl)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.ReservationResult), args.Error(1)
+}
+
+func (m *MockRateLimitService) CommitReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	args := m.Called(ctx, apiKey, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.SettledReservation), args.Error(1)
+}
+
+func (m *MockRateLimitService) CancelReservation(ctx context.Context, apiKey *database.APIKey, id string) (*services.SettledReservation, error) {
+	args := m.Called(ctx, apiKey, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.SettledReservation), args.Error(1)
+}
+
+func (m *MockRateLimitService) ClearKeyState(ctx context.Context, apiKeyID string) (int64, error) {
+	args := m.Called(ctx, apiKeyID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRateLimitService) AuthLockout(ctx context.Context, clientIP string) (time.Duration, error) {
+	args := m.Called(ctx, clientIP)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockRateLimitService) RecordAuthFailure(ctx context.Context, clientIP string) (time.Duration, error) {
+	args := m.Called(ctx, clientIP)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
 func setupTestMiddleware() (*gin.Engine, *MockAPIKeyService, *MockRateLimitService) {
 	gin.SetMode(gin.TestMode)
 	
@@ -79,6 +281,10 @@ func setupTestMiddleware() (*gin.Engine, *MockAPIKeyService, *MockRateLimitServi
 	router.GET("/admin/test", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "admin"})
 	})
+
+	router.GET("/v1/admin/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "admin"})
+	})
 	
 	router.GET("/api/test", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "protected"})
@@ -125,20 +331,110 @@ func TestRateLimit_SkipHealthCheck(t *testing.T) {
 	assert.Equal(t, "healthy", response["status"])
 }
 
-func TestRateLimit_SkipAdminEndpoints(t *testing.T) {
+func TestRateLimit_AdminEndpointsNotSkipped(t *testing.T) {
 	router, _, _ := setupTestMiddleware()
-	
-	req, _ := http.NewRequest("GET", "/admin/test", nil)
-	w := httptest.NewRecorder()
-	
-	router.ServeHTTP(w, req)
-	
-	assert.Equal(t, http.StatusOK, w.Code)
-	
-	var response map[string]interface{}
-	err := json.Unmarshal(w.Body.Bytes(), &response)
-	assert.NoError(t, err)
-	assert.Equal(t, "admin", response["status"])
+
+	// The admin API authenticates its callers itself and is served outside
+	// of RateLimit; in front of it, API keys are required as anywhere else
+	for _, path := range []string{"/admin/test", "/v1/admin/test"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+}
+
+func TestRateLimit_SkipConfiguredPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	skipPaths := []string{"/public/*", "/api/ping"}
+	router := gin.New()
+	router.Use(RateLimit(&MockAPIKeyService{}, &MockRateLimitService{}, WithSkipPaths(func() []string { return skipPaths })))
+	for _, path := range []string{"/public/logo.png", "/v1/api/ping", "/api/pings"} {
+		router.GET(path, func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	serve := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/public/logo.png"))
+	assert.Equal(t, http.StatusOK, serve("/v1/api/ping"))
+	assert.Equal(t, http.StatusUnauthorized, serve("/api/pings"))
+
+	// The list is read on every request
+	skipPaths = nil
+	assert.Equal(t, http.StatusUnauthorized, serve("/public/logo.png"))
+}
+
+func TestRateLimit_SkipMethodsAndCIDRs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	skipMethods := []string{http.MethodOptions}
+	router := gin.New()
+	router.Use(RateLimit(&MockAPIKeyService{}, &MockRateLimitService{},
+		WithSkipMethods(func() []string { return skipMethods }),
+		WithSkipCIDRs(func() []string { return []string{"10.0.0.0/8", "192.0.2.7"} }),
+	))
+	router.Any("/api/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(method, remoteAddr string) int {
+		req, _ := http.NewRequest(method, "/api/test", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodOptions, "203.0.113.1:1234"))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "203.0.113.1:1234"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "10.1.2.3:1234"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "192.0.2.7:1234"))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "192.0.2.8:1234"))
+
+	// The methods are read on every request
+	skipMethods = nil
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodOptions, "203.0.113.1:1234"))
+}
+
+func TestRateLimit_SkipAndKeyExtractor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "tenant-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService,
+		WithSkip(func(c *gin.Context) bool { return c.Request.Method == http.MethodOptions }),
+		WithAPIKeyExtractor(func(c *gin.Context) string { return c.Query("key") }),
+	))
+	router.Any("/admin/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The built-in exemptions are replaced
+	w := serve(http.MethodGet, "/admin/test")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"message":"Please provide an API key"`)
+	assert.Equal(t, http.StatusOK, serve(http.MethodOptions, "/admin/test").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/test?key=tenant-key").Code)
 }
 
 func TestRateLimit_NoAPIKey(t *testing.T) {
@@ -162,7 +458,7 @@ func TestRateLimit_InvalidAPIKey(t *testing.T) {
 	router, mockAPIKeyService, _ := setupTestMiddleware()
 	
 	// Setup mock to return error for invalid API key
-	mockAPIKeyService.On("ValidateAPIKey", "invalid-key").Return(nil, assert.AnError)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "invalid-key").Return(nil, services.ErrInvalidAPIKey)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
 	req.Header.Set("X-API-Key", "invalid-key")
@@ -189,7 +485,7 @@ func TestRateLimit_ValidAPIKey_Allowed(t *testing.T) {
 	testRateLimitResult := createTestRateLimitResult(true, 9)
 	
 	// Setup mock expectations
-	mockAPIKeyService.On("ValidateAPIKey", "valid-key").Return(testAPIKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
 	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
@@ -204,6 +500,7 @@ func TestRateLimit_ValidAPIKey_Allowed(t *testing.T) {
 	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
 	assert.Equal(t, "9", w.Header().Get("X-RateLimit-Remaining"))
 	assert.NotEmpty(t, w.Header().Get("X-RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
 	
 	var response map[string]interface{}
 	err := json.Unmarshal(w.Body.Bytes(), &response)
@@ -222,7 +519,7 @@ func TestRateLimit_ValidAPIKey_RateLimitExceeded(t *testing.T) {
 	testRateLimitResult := createTestRateLimitResult(false, 0)
 	
 	// Setup mock expectations
-	mockAPIKeyService.On("ValidateAPIKey", "valid-key").Return(testAPIKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
 	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
@@ -257,7 +554,7 @@ func TestRateLimit_AuthorizationHeader(t *testing.T) {
 	testRateLimitResult := createTestRateLimitResult(true, 8)
 	
 	// Setup mock expectations
-	mockAPIKeyService.On("ValidateAPIKey", "bearer-key").Return(testAPIKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "bearer-key").Return(testAPIKey, nil)
 	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
@@ -284,8 +581,8 @@ func TestRateLimit_RateLimitServiceError(t *testing.T) {
 	testAPIKey := createTestAPIKey()
 	
 	// Setup mock expectations
-	mockAPIKeyService.On("ValidateAPIKey", "valid-key").Return(testAPIKey, nil)
-	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(nil, assert.AnError)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(nil, services.ErrInvalidAPIKey)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
 	req.Header.Set("X-API-Key", "valid-key")
@@ -313,7 +610,7 @@ func TestRateLimit_ContextHasAPIKey(t *testing.T) {
 	testRateLimitResult := createTestRateLimitResult(true, 7)
 	
 	// Setup mock expectations
-	mockAPIKeyService.On("ValidateAPIKey", "valid-key").Return(testAPIKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
 	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
 	
 	req, _ := http.NewRequest("GET", "/api/test", nil)
@@ -368,3 +665,866 @@ func TestRateLimit_EmptyAuthorizationHeader(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "API key required", response["error"])
 }
+
+func TestRateLimit_PenalizedKey(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	// Create test data - key is serving a cooldown
+	testAPIKey := createTestAPIKey()
+	testRateLimitResult := createTestRateLimitResult(false, 0)
+	testRateLimitResult.PenaltyExpiresAt = time.Now().Add(5 * time.Minute)
+	testRateLimitResult.PenaltyLevel = 1
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(testRateLimitResult, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Temporarily blocked", response["error"])
+	assert.InDelta(t, 300, response["retry_after"], 2)
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_UniqueLimitExceeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	rule := config.UniqueLimitRule{Method: "GET", Route: "/api/items/:id", Source: "param", Field: "id", MaxUnique: 2, Window: time.Hour}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithUniqueLimits([]config.UniqueLimitRule{rule})))
+	router.GET("/api/items/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+	mockRateLimitService.On("CheckUniqueLimit", mock.Anything, testAPIKey, rule, "item-3").Return(&services.RateLimitResult{
+		Allowed:   false,
+		Remaining: 0,
+		ResetTime: time.Now().Add(time.Hour),
+		Limit:     2,
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/items/item-3", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Unique resource limit exceeded", response["error"])
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_UniqueLimitSkippedWithoutValue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	rule := config.UniqueLimitRule{Route: "/api/test", Source: "header", Field: "X-Target-ID", MaxUnique: 2, Window: time.Hour}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithUniqueLimits([]config.UniqueLimitRule{rule})))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRateLimitService.AssertNotCalled(t, "CheckUniqueLimit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_EndUserLimitExceeded(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.EndUserLimitRequests = 2
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+	mockRateLimitService.On("CheckEndUserLimit", mock.Anything, testAPIKey, "user-42").Return(&services.RateLimitResult{
+		Allowed:   false,
+		Remaining: 0,
+		ResetTime: time.Now().Add(time.Minute),
+		Limit:     2,
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("X-End-User-ID", "user-42")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "End-user rate limit exceeded", response["error"])
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_OrganizationLimitExceeded(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.OrganizationID = "org-acme"
+	testAPIKey.OrganizationLimitRequests = 1000
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+	mockRateLimitService.On("CheckOrganizationLimit", mock.Anything, testAPIKey).Return(&services.RateLimitResult{
+		Allowed:   false,
+		ResetTime: time.Now().Add(time.Minute),
+		Limit:     1000,
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Organization rate limit exceeded", response["error"])
+	assert.Equal(t, float64(1000), response["limit"])
+
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_EndUserLimitNotDeclared(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	// Key without a sublimit ignores the end-user header
+	testAPIKey := createTestAPIKey()
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("X-End-User-ID", "user-42")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRateLimitService.AssertNotCalled(t, "CheckEndUserLimit", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_AllowedCIDR(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedCIDRs = []string{"10.0.0.0/8"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.RemoteAddr = "10.1.2.3:51234"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_DisallowedCIDR(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedCIDRs = []string{"10.0.0.0/8"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.RemoteAddr = "203.0.113.7:51234"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "IP address not allowed")
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_CIDRIgnoresUntrustedForwardedFor(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+	assert.NoError(t, router.SetTrustedProxies(nil))
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedCIDRs = []string{"10.0.0.0/8"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+
+	// A spoofed header from an untrusted peer must not satisfy the allowlist
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	req.RemoteAddr = "203.0.113.7:51234"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+}
+
+func TestClientIPAllowed(t *testing.T) {
+	assert.True(t, ClientIPAllowed("198.51.100.1", nil))
+	assert.True(t, ClientIPAllowed("192.168.1.20", []string{"10.0.0.0/8", "192.168.1.0/24"}))
+	assert.True(t, ClientIPAllowed("2001:db8::1", []string{"2001:db8::/32"}))
+	assert.False(t, ClientIPAllowed("192.168.2.20", []string{"192.168.1.0/24"}))
+	assert.False(t, ClientIPAllowed("not-an-ip", []string{"0.0.0.0/0"}))
+}
+
+func TestRateLimit_AllowedOrigin(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedOrigins = []string{"https://app.example.com"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_DisallowedOrigin(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedOrigins = []string{"https://app.example.com"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("Origin", "https://evil.example.net")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Origin not allowed")
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_OriginFromReferer(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupTestMiddleware()
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.AllowedOrigins = []string{"https://*.example.com"}
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set("Referer", "https://shop.example.com/checkout?step=2")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOriginAllowed(t *testing.T) {
+	assert.True(t, originAllowed("", nil))
+	assert.True(t, originAllowed("https://app.example.com", []string{"https://app.example.com"}))
+	assert.True(t, originAllowed("https://a.b.example.com", []string{"https://*.example.com"}))
+	assert.False(t, originAllowed("https://example.com", []string{"https://*.example.com"}))
+	assert.False(t, originAllowed("http://app.example.com", []string{"https://*.example.com"}))
+	assert.False(t, originAllowed("https://evilexample.com", []string{"https://*.example.com"}))
+	assert.False(t, originAllowed("", []string{"https://app.example.com"}))
+}
+
+type recordingUsageRecorder struct {
+	used []string
+}
+
+func (r *recordingUsageRecorder) RecordUse(apiKeyID string) {
+	r.used = append(r.used, apiKeyID)
+}
+
+func TestRateLimit_RecordsUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	recorder := &recordingUsageRecorder{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithUsageRecorder(recorder)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "invalid-key").Return(nil, services.ErrInvalidAPIKey)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	for _, key := range []string{"valid-key", "invalid-key"} {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", key)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Only the successful authentication is recorded
+	assert.Equal(t, []string{testAPIKey.ID}, recorder.used)
+}
+
+type countingUsageCounter struct {
+	counts map[string]int
+}
+
+func (u *countingUsageCounter) IncrementUsage(ctx context.Context, apiKeyID string) error {
+	u.counts[apiKeyID]++
+	return nil
+}
+
+func TestRateLimit_CountsAllowedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	counter := &countingUsageCounter{counts: map[string]int{}}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithUsageCounter(counter)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(false, 0), nil).Once()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", "valid-key")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The throttled request is not counted
+	assert.Equal(t, 1, counter.counts[testAPIKey.ID])
+}
+
+type recordingLimitAlerter struct {
+	alerts []string
+}
+
+func (a *recordingLimitAlerter) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *services.RateLimitResult) error {
+	a.alerts = append(a.alerts, eventType)
+	return nil
+}
+
+func TestRateLimit_AlertsOnExceededLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	alerter := &recordingLimitAlerter{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithLimitAlerter(alerter)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	quotaExceeded := createTestRateLimitResult(false, 0)
+	quotaExceeded.QuotaExceeded = true
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(false, 0), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(quotaExceeded, nil).Once()
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", "valid-key")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []string{events.RateLimitExceeded, events.QuotaExceeded}, alerter.alerts)
+}
+
+func TestRateLimit_WarnsAtAlertThresholds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	alerter := &recordingLimitAlerter{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithLimitAlerter(alerter)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	crossed := createTestRateLimitResult(true, 2)
+	crossed.Threshold, crossed.ThresholdCrossed = 80, true
+	reached := createTestRateLimitResult(true, 1)
+	reached.Threshold, reached.ThresholdQuota = 90, true
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 5), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(crossed, nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(reached, nil).Once()
+
+	var warnings []string
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", "valid-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		warnings = append(warnings, w.Header().Get("X-RateLimit-Warning"))
+	}
+
+	assert.Equal(t, []string{"", "80% of rate limit used", "90% of quota used"}, warnings)
+	// Only the request that reached the threshold raises an alert
+	assert.Equal(t, []string{events.LimitThresholdReached}, alerter.alerts)
+}
+
+func setupAuthFailureTest() (*gin.Engine, *MockAPIKeyService, *MockRateLimitService) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithAuthFailureTracker(mockRateLimitService)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+	return router, mockAPIKeyService, mockRateLimitService
+}
+
+func TestRateLimit_AuthFailureRecorded(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupAuthFailureTest()
+
+	mockRateLimitService.On("AuthLockout", mock.Anything, "192.0.2.1").Return(time.Duration(0), nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "guess-1").Return(nil, services.ErrInvalidAPIKey)
+	mockRateLimitService.On("RecordAuthFailure", mock.Anything, "192.0.2.1").Return(time.Duration(0), nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-API-Key", "guess-1")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockAPIKeyService.AssertExpectations(t)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_ValidationOutageNotCounted(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupAuthFailureTest()
+
+	mockRateLimitService.On("AuthLockout", mock.Anything, "192.0.2.1").Return(time.Duration(0), nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "ak_valid").Return(nil, errors.New("failed to validate API key: connection refused"))
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-API-Key", "ak_valid")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Authentication unavailable")
+	mockRateLimitService.AssertNotCalled(t, "RecordAuthFailure", mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_AuthFailureTriggersLockout(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupAuthFailureTest()
+
+	mockRateLimitService.On("AuthLockout", mock.Anything, "192.0.2.1").Return(time.Duration(0), nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "guess-20").Return(nil, services.ErrInvalidAPIKey)
+	mockRateLimitService.On("RecordAuthFailure", mock.Anything, "192.0.2.1").Return(15*time.Minute, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-API-Key", "guess-20")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Too many failed attempts", response["error"])
+	assert.Equal(t, float64(900), response["retry_after"])
+}
+
+func TestRateLimit_LockedOutClientNotValidated(t *testing.T) {
+	router, mockAPIKeyService, mockRateLimitService := setupAuthFailureTest()
+
+	mockRateLimitService.On("AuthLockout", mock.Anything, "192.0.2.1").Return(10*time.Minute, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	mockAPIKeyService.AssertNotCalled(t, "ValidateAPIKey", mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_StandardHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithStandardHeaders(true)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(&services.RateLimitResult{
+		Allowed:   true,
+		Remaining: 9,
+		ResetTime: time.Now().Add(30 * time.Second),
+		Limit:     10,
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "9", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestRateLimit_HeaderClockAndLoggerOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService,
+		WithStandardHeaders(true),
+		WithAPIKeyHeader("X-Tenant-Key"),
+		WithClock(func() time.Time { return now }),
+		WithLogger(zap.New(core)),
+	))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	limitedKey := createTestAPIKey()
+	brokenKey := createTestAPIKey()
+	brokenKey.KeyPrefix = "broken"
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "limited-key").Return(limitedKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "broken-key").Return(brokenKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, limitedKey).Return(&services.RateLimitResult{
+		Allowed:   false,
+		Remaining: 0,
+		ResetTime: now.Add(42 * time.Second),
+		Limit:     10,
+	}, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, brokenKey).Return(nil, services.ErrInvalidAPIKey)
+
+	serve := func(header, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set(header, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The default header is no longer read
+	w := serve("X-API-Key", "limited-key")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "X-Tenant-Key header")
+
+	// retry_after and RateLimit-Reset follow the clock
+	w = serve("X-Tenant-Key", "limited-key")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "42", w.Header().Get("RateLimit-Reset"))
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(42), response["retry_after"])
+
+	// Requests without a logger of their own log through the given one
+	w = serve("X-Tenant-Key", "broken-key")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	entries := logs.FilterMessage("Rate limit check failed").All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "broken", entries[0].ContextMap()["key_prefix"])
+	}
+}
+
+func TestRateLimit_CustomLimitResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithClock(func() time.Time { return now })))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	jsonKey := createTestAPIKey()
+	jsonKey.LimitResponse = &database.LimitResponse{
+		Body:    `{"error": "Limit of {limit} reached", "retry_after": {retry_after}, "upgrade": "https://example.com/upgrade"}`,
+		Headers: map[string]string{"X-Upgrade-Reset": "{reset}"},
+	}
+	textKey := createTestAPIKey()
+	textKey.KeyPrefix = "text"
+	textKey.LimitResponse = &database.LimitResponse{Body: "{remaining} requests left, upgrade at https://example.com/upgrade"}
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "json-key").Return(jsonKey, nil)
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "text-key").Return(textKey, nil)
+	limited := &services.RateLimitResult{Allowed: false, Remaining: 0, ResetTime: now.Add(42 * time.Second), Limit: 10}
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, mock.Anything).Return(limited, nil)
+
+	serve := func(key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("json-key")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "2024-01-01T12:00:42Z", w.Header().Get("X-Upgrade-Reset"))
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+	assert.JSONEq(t, `{"error": "Limit of 10 reached", "retry_after": 42, "upgrade": "https://example.com/upgrade"}`, w.Body.String())
+
+	w = serve("text-key")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "0 requests left, upgrade at https://example.com/upgrade", w.Body.String())
+}
+
+func TestRateLimit_FailOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithFailOpen(true)))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "protected"})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(nil, services.ErrInvalidAPIKey)
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+	mockRateLimitService.AssertExpectations(t)
+}
+
+// fakeTokens accepts the tokens it maps to a key
+type fakeTokens map[string]*database.APIKey
+
+func (f fakeTokens) ValidateToken(ctx context.Context, token string) (*database.APIKey, error) {
+	if apiKey, ok := f[token]; ok {
+		return apiKey, nil
+	}
+	return nil, services.ErrInvalidAccessToken
+}
+
+func TestRateLimit_AccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	testAPIKey := createTestAPIKey()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithTokenValidator(fakeTokens{"a.b.c": testAPIKey})))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("GET", "/api/test", "a.b.c").Code)
+
+	w := serve("GET", "/api/test", "a.b.forged")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"Invalid access token"`)
+	mockAPIKeyService.AssertNotCalled(t, "ValidateAPIKey", mock.Anything, mock.Anything)
+	mockRateLimitService.AssertNumberOfCalls(t, "CheckRateLimit", 1)
+}
+
+func TestRateLimit_ScopedAccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	scoped := createTestAPIKey()
+	scoped.TokenID = "tok-1"
+	scoped.TokenLimitRequests = 5
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, scoped).Return(createTestRateLimitResult(true, 9), nil)
+	mockRateLimitService.On("CheckTokenLimit", mock.Anything, scoped).Return(&services.RateLimitResult{
+		Allowed:   false,
+		Limit:     5,
+		ResetTime: time.Now().Add(30 * time.Second),
+	}, nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithTokenValidator(fakeTokens{"a.b.c": scoped})))
+	router.GET("/api/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/api/test", nil)
+	req.Header.Set("Authorization", "Bearer a.b.c")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"Token rate limit exceeded"`)
+	mockRateLimitService.AssertExpectations(t)
+}
+
+func TestRateLimit_RefundNotCounted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService))
+	router.POST("/v1/api/rate-limit/refund", func(c *gin.Context) {
+		assert.Equal(t, testAPIKey, c.MustGet("api_key"))
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("POST", "/v1/api/rate-limit/refund", nil)
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Refunds are authenticated, but work even for keys over their limit
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+
+	req, _ = http.NewRequest("POST", "/v1/api/rate-limit/refund", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRateLimit_ReservationsNotCounted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(createTestAPIKey(), nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService))
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.POST("/v1/api/rate-limit/reservations", handler)
+	router.POST("/v1/api/rate-limit/reservations/:id/commit", handler)
+	router.DELETE("/v1/api/rate-limit/reservations/:id", handler)
+
+	// Reservations take their units from the limit themselves
+	for _, request := range []struct{ method, path string }{
+		{"POST", "/v1/api/rate-limit/reservations"},
+		{"POST", "/v1/api/rate-limit/reservations/rsv_1/commit"},
+		{"DELETE", "/v1/api/rate-limit/reservations/rsv_1"},
+	} {
+		req, _ := http.NewRequest(request.method, request.path, nil)
+		req.Header.Set("X-API-Key", "valid-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, request.path)
+	}
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// StatusRecorder observes the status of responses, e.g. to alert on error
+// rates
+type StatusRecorder interface {
+	RecordStatus(status int)
+}
+
+// RecordStatus hands the status of every response to recorder once the
+// handlers after it have run
+func RecordStatus(recorder StatusRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		recorder.RecordStatus(c.Writer.Status())
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type statusCollector struct {
+	statuses []int
+}
+
+func (s *statusCollector) RecordStatus(status int) {
+	s.statuses = append(s.statuses, status)
+}
+
+func TestRecordStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	collector := &statusCollector{}
+	router := gin.New()
+	router.Use(RecordStatus(collector))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/fail", func(c *gin.Context) { c.AbortWithStatus(http.StatusServiceUnavailable) })
+
+	for _, path := range []string{"/ok", "/fail", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusNotFound}, collector.statuses)
+}
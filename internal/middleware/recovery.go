@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// Recovery turns a panic in any later middleware or handler into a 500
+// problem details response, logging the stack with the request ID and
+// counting it in metrics.PanicsRecovered. It must be the first middleware so
+// that panics anywhere else are caught.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// net/http's sentinel for aborting a response is not a failure
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			metrics.PanicsRecovered.Inc()
+			logging.FromContext(c.Request.Context()).Error("Panic recovered",
+				zap.Any("panic", recovered),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.ByteString("stack", debug.Stack()),
+			)
+
+			// Part of a response may already be on the wire
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			body, _ := json.Marshal(ErrorBody(c, gin.H{
+				"type":   "about:blank",
+				"title":  http.StatusText(http.StatusInternalServerError),
+				"status": http.StatusInternalServerError,
+				"detail": "The server encountered an unexpected error",
+			}))
+			// Replace any content type the handler set before panicking
+			c.Header("Content-Type", ProblemContentType)
+			c.Data(http.StatusInternalServerError, ProblemContentType, body)
+			c.Abort()
+		}()
+
+		c.Next()
+	}
+}
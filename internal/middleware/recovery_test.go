@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grpc-firstls/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecovery_ProblemResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := gin.New()
+	router.Use(Recovery(), RequestID(), AccessLog(zap.New(core)))
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	before := testutil.ToFloat64(metrics.PanicsRecovered)
+	w := serveWithRequestID(router, "/panic", "support-1234")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "support-1234", w.Header().Get(RequestIDHeader))
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Internal Server Error", response["title"])
+	assert.Equal(t, float64(http.StatusInternalServerError), response["status"])
+	assert.Equal(t, "support-1234", response["request_id"])
+
+	entries := logs.FilterMessage("Panic recovered").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "support-1234", fields["request_id"])
+	assert.Equal(t, "boom", fields["panic"])
+	assert.Contains(t, fields["stack"], "recovery_test.go")
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.PanicsRecovered))
+}
+
+func TestRecovery_ResponseAlreadyStarted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom")
+	})
+
+	req, _ := http.NewRequest("GET", "/partial", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "partial", w.Body.String())
+}
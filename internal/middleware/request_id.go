@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDContextKey stores the request ID in the Gin context
+const requestIDContextKey = "request_id"
+
+type requestIDKey struct{}
+
+// Accepted client supplied IDs; anything else is replaced so IDs can't be
+// used to inject into log lines or headers
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID assigns every request an ID, reusing a well-formed X-Request-ID
+// from the client or an upstream proxy, so a customer report can be matched
+// with the server's log lines. The ID is returned in the X-Request-ID
+// response header and stored in the Gin and request contexts.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(requestIDContextKey, requestID)
+		c.Request = c.Request.WithContext(ContextWithRequestID(c.Request.Context(), requestID))
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// ContextWithRequestID returns a copy of ctx carrying requestID, for work
+// done on behalf of a request outside of its handler
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// GetRequestID returns the ID RequestID assigned to the request, or ""
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// ErrorBody adds the request ID to an error response body, so clients can
+// quote it when reporting a problem
+func ErrorBody(c *gin.Context, body gin.H) gin.H {
+	if requestID := GetRequestID(c); requestID != "" {
+		body["request_id"] = requestID
+	}
+	return body
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRequestIDTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"gin":     GetRequestID(c),
+			"request": RequestIDFromContext(c.Request.Context()),
+		})
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, ErrorBody(c, gin.H{"error": "Invalid request"}))
+	})
+	return router
+}
+
+func serveWithRequestID(router *gin.Engine, path string, requestID string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestID_Generated(t *testing.T) {
+	router := setupRequestIDTestRouter()
+
+	w := serveWithRequestID(router, "/ok", "")
+	requestID := w.Header().Get(RequestIDHeader)
+	assert.Regexp(t, `^[0-9a-f]{32}$`, requestID)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, requestID, response["gin"])
+	assert.Equal(t, requestID, response["request"])
+
+	other := serveWithRequestID(router, "/ok", "").Header().Get(RequestIDHeader)
+	assert.NotEqual(t, requestID, other)
+}
+
+func TestRequestID_AcceptsClientID(t *testing.T) {
+	router := setupRequestIDTestRouter()
+
+	w := serveWithRequestID(router, "/ok", "req-2024.01:abc_DEF")
+	assert.Equal(t, "req-2024.01:abc_DEF", w.Header().Get(RequestIDHeader))
+
+	// IDs that could forge log lines or are oversized are replaced
+	for _, invalid := range []string{"id with spaces", "id\nforged=1", string(bytes.Repeat([]byte("a"), 129))} {
+		w := serveWithRequestID(router, "/ok", invalid)
+		assert.Regexp(t, `^[0-9a-f]{32}$`, w.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestRequestID_InErrorBody(t *testing.T) {
+	router := setupRequestIDTestRouter()
+
+	w := serveWithRequestID(router, "/fail", "support-1234")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "support-1234", response["request_id"])
+}
+
+func TestErrorBody_WithoutRequestID(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	body := ErrorBody(c, gin.H{"error": "Invalid request"})
+	assert.Equal(t, gin.H{"error": "Invalid request"}, body)
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// CacheStatusHeader tells whether a response came from the response cache:
+// HIT, MISS, or BYPASS when the client asked for a fresh one
+const CacheStatusHeader = "X-Cache"
+
+// maxCachedResponseBytes is the largest response body that is cached
+const maxCachedResponseBytes = 1 << 20
+
+// WithResponseCache answers repeat GET requests with the response cached
+// for their key, without counting them against its limits. Only 200
+// responses of at most 1 MiB are cached, and none marked Cache-Control:
+// no-store or varying on request headers other than Accept;
+// clients send Cache-Control: no-cache to get a fresh response.
+func WithResponseCache(cache services.ResponseCacheInterface) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		if cache != nil {
+			o.responseCache = cache
+		}
+	}
+}
+
+// serveCachedResponse answers the request with the response cached for the
+// key, reporting whether it did. Otherwise it returns a function that caches
+// the response once the request has been handled, or nil for requests that
+// aren't cached.
+func (o *rateLimitOptions) serveCachedResponse(c *gin.Context, apiKeyRecord *database.APIKey) (bool, func()) {
+	if c.Request.Method != http.MethodGet || liveRoute(c) {
+		return false, nil
+	}
+
+	ctx := c.Request.Context()
+	result := "bypass"
+	if !hasDirective(c.Request.Header, "no-cache") {
+		cached, err := o.responseCache.Lookup(ctx, apiKeyRecord, c.Request)
+		if err != nil {
+			// The request is handled as if nothing was cached
+			o.loggerFor(c).Error("Failed to look up cached response", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+		}
+		if cached != nil {
+			metrics.ResponseCacheLookups.WithLabelValues("hit").Inc()
+			setRateLimitDecision(c, "cached")
+			header := c.Writer.Header()
+			for name, values := range cached.Header {
+				if name == "Vary" {
+					addVary(header, values)
+					continue
+				}
+				header[name] = values
+			}
+			c.Header(CacheStatusHeader, "HIT")
+			c.Data(cached.StatusCode, cached.Header.Get("Content-Type"), cached.Body)
+			c.Abort()
+			return true, nil
+		}
+		result = "miss"
+	}
+	metrics.ResponseCacheLookups.WithLabelValues(result).Inc()
+	c.Header(CacheStatusHeader, strings.ToUpper(result))
+
+	writer := &cachingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return false, func() {
+		if writer.Status() != http.StatusOK || writer.overflow || hasDirective(writer.Header(), "no-store") || !cacheableVary(writer.Header()) {
+			return
+		}
+		response := &services.CachedResponse{
+			StatusCode: writer.Status(),
+			Header:     cacheableHeader(writer.Header()),
+			Body:       writer.body.Bytes(),
+		}
+		if err := o.responseCache.Store(ctx, apiKeyRecord, c.Request, response); err != nil {
+			o.loggerFor(c).Error("Failed to cache response", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+		}
+	}
+}
+
+// liveRoute reports the endpoints reporting a key's current rate limit,
+// whose responses must never be cached
+func liveRoute(c *gin.Context) bool {
+	path := unversionedPath(c.Request.URL.Path)
+	return path == "/api/status" || path == "/api/rate-limit"
+}
+
+// hasDirective reports whether the Cache-Control header lists directive
+func hasDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheableVary reports whether a response varies only on request headers
+// the cache handles: Accept, which is part of the cache key, and
+// Accept-Encoding and Origin, whose middleware runs for cached responses as
+// well. Anything else, e.g. Accept-Language from a proxied upstream, could
+// get one client's response replayed to another.
+func cacheableVary(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			switch http.CanonicalHeaderKey(strings.TrimSpace(name)) {
+			case "", "Accept", "Accept-Encoding", "Origin":
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addVary adds the header names listed in values to the Vary header, unless
+// it lists them already
+func addVary(header http.Header, values []string) {
+	listed := map[string]bool{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !listed[name] {
+				listed[name] = true
+				header.Add("Vary", name)
+			}
+		}
+	}
+}
+
+// cacheableHeader returns the headers of a response worth caching, leaving
+// out those describing the request it answered, e.g. its rate limit, and
+// those set by middleware that runs for cached responses as well
+func cacheableHeader(header http.Header) http.Header {
+	cached := make(http.Header, len(header))
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		switch {
+		case lower == "content-length", lower == "content-encoding", lower == "date", lower == "set-cookie",
+			lower == "retry-after", lower == "deprecation", lower == "sunset",
+			lower == strings.ToLower(RequestIDHeader), lower == strings.ToLower(CacheStatusHeader),
+			strings.HasPrefix(lower, "x-ratelimit-"), strings.HasPrefix(lower, "ratelimit-"),
+			strings.HasPrefix(lower, "access-control-"):
+			continue
+		}
+		cached[name] = append([]string(nil), values...)
+	}
+	return cached
+}
+
+// cachingWriter keeps a copy of the response body it writes, giving up once
+// the body is too large to cache
+type cachingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *cachingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cachingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *cachingWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > maxCachedResponseBytes {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
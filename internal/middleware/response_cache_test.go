@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// fakeResponseCache caches responses in memory by key and URL
+type fakeResponseCache struct {
+	responses map[string]*services.CachedResponse
+}
+
+func (f *fakeResponseCache) Lookup(ctx context.Context, apiKey *database.APIKey, request *http.Request) (*services.CachedResponse, error) {
+	return f.responses[apiKey.ID+" "+request.URL.String()], nil
+}
+
+func (f *fakeResponseCache) Store(ctx context.Context, apiKey *database.APIKey, request *http.Request, response *services.CachedResponse) error {
+	f.responses[apiKey.ID+" "+request.URL.String()] = response
+	return nil
+}
+
+func TestRateLimit_ResponseCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	cache := &fakeResponseCache{responses: map[string]*services.CachedResponse{}}
+
+	calls := 0
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithResponseCache(cache)))
+	router.GET("/api/items", func(c *gin.Context) {
+		calls++
+		c.Header("X-Upstream", "items")
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+	router.GET("/api/private", func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"private": true})
+	})
+	router.GET("/api/rate-limit", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"remaining": 99})
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 99), nil)
+
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("X-API-Key", "valid-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/api/items", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get(CacheStatusHeader))
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+	assert.JSONEq(t, `{"calls":1}`, w.Body.String())
+
+	// Repeats are served from the cache without being counted
+	w = serve("/api/items", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get(CacheStatusHeader))
+	assert.Equal(t, "items", w.Header().Get("X-Upstream"))
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+	assert.JSONEq(t, `{"calls":1}`, w.Body.String())
+	mockRateLimitService.AssertNumberOfCalls(t, "CheckRateLimit", 1)
+
+	// Clients can ask for a fresh response, which replaces the cached one
+	w = serve("/api/items", http.Header{"Cache-Control": {"no-cache"}})
+	assert.Equal(t, "BYPASS", w.Header().Get(CacheStatusHeader))
+	assert.JSONEq(t, `{"calls":2}`, w.Body.String())
+	w = serve("/api/items", nil)
+	assert.Equal(t, "HIT", w.Header().Get(CacheStatusHeader))
+	assert.JSONEq(t, `{"calls":2}`, w.Body.String())
+
+	// Responses marked no-store aren't cached
+	serve("/api/private", nil)
+	w = serve("/api/private", nil)
+	assert.Equal(t, "MISS", w.Header().Get(CacheStatusHeader))
+
+	// Nor is the key's current rate limit
+	serve("/api/rate-limit", nil)
+	w = serve("/api/rate-limit", nil)
+	assert.Empty(t, w.Header().Get(CacheStatusHeader))
+	mockRateLimitService.AssertNumberOfCalls(t, "CheckRateLimit", 6)
+}
+
+// memoryResponseCacheStore keeps the responses of a services.ResponseCache
+// in memory
+type memoryResponseCacheStore struct {
+	responses map[string][]byte
+}
+
+func (s *memoryResponseCacheStore) GetCachedResponse(ctx context.Context, key string) ([]byte, error) {
+	return s.responses[key], nil
+}
+
+func (s *memoryResponseCacheStore) SetCachedResponse(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	s.responses[key] = response
+	return nil
+}
+
+func TestRateLimit_ResponseCacheVary(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	cache := services.NewResponseCache(&memoryResponseCacheStore{responses: map[string][]byte{}}, time.Minute, services.ResponseCacheKeyURL, DefaultEndUserHeader)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService, WithResponseCache(cache)))
+	router.GET("/api/negotiated", func(c *gin.Context) {
+		c.Header("Vary", "Accept")
+		c.String(http.StatusOK, "accept=%s", c.GetHeader("Accept"))
+	})
+	router.GET("/api/translated", func(c *gin.Context) {
+		c.Header("Vary", "Accept-Language")
+		c.String(http.StatusOK, "language=%s", c.GetHeader("Accept-Language"))
+	})
+
+	testAPIKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 99), nil)
+
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("X-API-Key", "valid-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Each Accept value gets its own cached response
+	asJSON := http.Header{"Accept": {"application/json"}}
+	asMsgpack := http.Header{"Accept": {"application/msgpack"}}
+	assert.Equal(t, "MISS", serve("/api/negotiated", asJSON).Header().Get(CacheStatusHeader))
+	assert.Equal(t, "MISS", serve("/api/negotiated", asMsgpack).Header().Get(CacheStatusHeader))
+
+	w := serve("/api/negotiated", asJSON)
+	assert.Equal(t, "HIT", w.Header().Get(CacheStatusHeader))
+	assert.Equal(t, "accept=application/json", w.Body.String())
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	w = serve("/api/negotiated", asMsgpack)
+	assert.Equal(t, "HIT", w.Header().Get(CacheStatusHeader))
+	assert.Equal(t, "accept=application/msgpack", w.Body.String())
+
+	// Responses varying on other request headers aren't cached
+	serve("/api/translated", http.Header{"Accept-Language": {"en"}})
+	w = serve("/api/translated", http.Header{"Accept-Language": {"fr"}})
+	assert.Equal(t, "MISS", w.Header().Get(CacheStatusHeader))
+	assert.Equal(t, "language=fr", w.Body.String())
+}
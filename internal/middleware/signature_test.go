@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testSigningSecret = "ss_test_secret"
+
+func setupSignatureTest() (*gin.Engine, *MockRateLimitService, *database.APIKey) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+
+	testAPIKey := createTestAPIKey()
+	testAPIKey.SigningSecret = testSigningSecret
+	testAPIKey.RequireSignature = true
+
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(testAPIKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, testAPIKey).Return(createTestRateLimitResult(true, 9), nil)
+
+	router := gin.New()
+	router.Use(RateLimit(mockAPIKeyService, mockRateLimitService))
+	router.POST("/api/test", func(c *gin.Context) {
+		// Handlers must still see the body after verification
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	return router, mockRateLimitService, testAPIKey
+}
+
+func signedRequest(timestamp int64, body string, signedBody string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/test?dry_run=true", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "valid-key")
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, SignRequest(testSigningSecret, timestamp, "POST", "/api/test?dry_run=true", []byte(signedBody)))
+	return req
+}
+
+func TestRateLimit_ValidSignature(t *testing.T) {
+	router, _, _ := setupSignatureTest()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(time.Now().Unix(), `{"amount":10}`, `{"amount":10}`))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"amount":10}`, w.Body.String())
+}
+
+func TestRateLimit_MissingSignature(t *testing.T) {
+	router, mockRateLimitService, _ := setupSignatureTest()
+
+	req, _ := http.NewRequest("POST", "/api/test", strings.NewReader(`{}`))
+	req.Header.Set("X-API-Key", "valid-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "requires signed requests")
+	mockRateLimitService.AssertNotCalled(t, "CheckRateLimit", mock.Anything, mock.Anything)
+}
+
+func TestRateLimit_TamperedBody(t *testing.T) {
+	router, _, _ := setupSignatureTest()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(time.Now().Unix(), `{"amount":1000}`, `{"amount":10}`))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "signature does not match")
+}
+
+func TestRateLimit_ReplayedSignature(t *testing.T) {
+	router, _, _ := setupSignatureTest()
+
+	// A correctly signed request captured ten minutes ago
+	stale := time.Now().Add(-10 * time.Minute).Unix()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedRequest(stale, `{}`, `{}`))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "outside the allowed window")
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUsageLogRouteLength fits usage_logs.route
+const maxUsageLogRouteLength = 255
+
+// UsageLog records every request made with a valid API key, including those
+// refused by a limit, once the response status is known. Requests made with
+// external tokens are not recorded, as there is no key to record them for.
+// It has to run before RateLimit, which identifies the key. The route is the
+// matched route pattern, or the path for requests that matched no route.
+// Every request is handed to each of loggers.
+func UsageLog(loggers ...services.UsageLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		apiKey := requestAPIKey(c)
+		if apiKey == nil || apiKey.External {
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		if len(route) > maxUsageLogRouteLength {
+			route = route[:maxUsageLogRouteLength]
+		}
+
+		entry := database.UsageLog{
+			APIKeyID:   apiKey.ID,
+			Method:     c.Request.Method,
+			Route:      route,
+			StatusCode: c.Writer.Status(),
+			Cost:       1,
+			Decision:   c.GetString(rateLimitDecisionContextKey),
+			CreatedAt:  start,
+			Latency:    time.Since(start),
+		}
+		for _, logger := range loggers {
+			logger.Log(entry)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingUsageLogger struct {
+	entries []database.UsageLog
+}
+
+func (l *recordingUsageLogger) Log(entry database.UsageLog) bool {
+	l.entries = append(l.entries, entry)
+	return true
+}
+
+func TestUsageLog_RecordsAuthenticatedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockAPIKeyService := &MockAPIKeyService{}
+	mockRateLimitService := &MockRateLimitService{}
+	usageLogger := &recordingUsageLogger{}
+
+	router := gin.New()
+	router.Use(UsageLog(usageLogger), RateLimit(mockAPIKeyService, mockRateLimitService))
+	router.GET("/api/items/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	apiKey := createTestAPIKey()
+	mockAPIKeyService.On("ValidateAPIKey", mock.Anything, "valid-key").Return(apiKey, nil)
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(true, 9), nil).Once()
+	mockRateLimitService.On("CheckRateLimit", mock.Anything, apiKey).Return(createTestRateLimitResult(false, 0), nil).Once()
+
+	for _, key := range []string{"", "valid-key", "valid-key"} {
+		req, _ := http.NewRequest("GET", "/api/items/42", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Requests without a valid key aren't attributed to anyone
+	require.Len(t, usageLogger.entries, 2)
+	first := usageLogger.entries[0]
+	assert.False(t, first.CreatedAt.IsZero())
+	assert.GreaterOrEqual(t, first.Latency, time.Duration(0))
+	first.CreatedAt, first.Latency = time.Time{}, 0
+	assert.Equal(t, database.UsageLog{
+		APIKeyID:   "test-id-123",
+		Method:     "GET",
+		Route:      "/api/items/:id",
+		StatusCode: http.StatusOK,
+		Cost:       1,
+		Decision:   "allowed",
+	}, first)
+	assert.Equal(t, http.StatusTooManyRequests, usageLogger.entries[1].StatusCode)
+	assert.Equal(t, "limited", usageLogger.entries[1].Decision)
+}
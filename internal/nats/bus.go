@@ -0,0 +1,219 @@
+// Package nats publishes key lifecycle and limit-exceeded events to NATS
+// subjects and serves counter resets sent to a control subject.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/redis"
+	"grpc-firstls/internal/services"
+
+	natsgo "github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// ResetCounters is the action of a control request that clears a key's
+// rate limit state
+const ResetCounters = "reset_counters"
+
+// controlTimeout bounds the handling of one control request
+const controlTimeout = 10 * time.Second
+
+// ControlRequest is the JSON body of a message sent to the control subject
+type ControlRequest struct {
+	Action string `json:"action"`
+	// The key's ID, or the API key itself
+	APIKey string `json:"api_key"`
+}
+
+// ControlReply answers a control request that has a reply subject
+type ControlReply struct {
+	OK               bool   `json:"ok"`
+	Error            string `json:"error,omitempty"`
+	APIKeyID         string `json:"api_key_id,omitempty"`
+	RedisKeysDeleted int64  `json:"redis_keys_deleted,omitempty"`
+}
+
+// conn is the part of a NATS connection the bus uses
+type conn interface {
+	PublishMsg(msg *natsgo.Msg) error
+	Subscribe(subject string, handler natsgo.MsgHandler) (*natsgo.Subscription, error)
+	Drain() error
+}
+
+// EventBus publishes events to NATS, each to the subject prefix followed by
+// the event type, e.g. ratelimiter.events.api_key.created. Events carry a
+// Nats-Msg-Id header so JetStream streams can drop duplicates.
+type EventBus struct {
+	conn           conn
+	prefix         string
+	controlSubject string
+	counters       redis.ClientInterface
+	throttle       time.Duration
+	logger         *zap.Logger
+
+	apiKeys    services.APIKeyServiceInterface
+	rateLimits services.RateLimitServiceInterface
+}
+
+// Connect dials the servers of cfg.URL. Startup doesn't wait for NATS: the
+// connection keeps retrying in the background, as it does after losing a
+// server, and events published meanwhile are buffered by the client.
+// Connection changes and control requests are logged to logger.
+func Connect(cfg config.NATSConfig, counters redis.ClientInterface, logger *zap.Logger) (*EventBus, error) {
+	options := []natsgo.Option{
+		natsgo.Name("rate-limiter"),
+		natsgo.MaxReconnects(-1),
+		natsgo.RetryOnFailedConnect(true),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS", zap.Error(err))
+			}
+		}),
+		natsgo.ReconnectHandler(func(c *natsgo.Conn) {
+			logger.Info("Reconnected to NATS", zap.String("server", c.ConnectedUrlRedacted()))
+		}),
+	}
+	if cfg.CredentialsFile != "" {
+		options = append(options, natsgo.UserCredentials(cfg.CredentialsFile))
+	}
+
+	nc, err := natsgo.Connect(cfg.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return newEventBus(nc, cfg, counters, logger), nil
+}
+
+func newEventBus(c conn, cfg config.NATSConfig, counters redis.ClientInterface, logger *zap.Logger) *EventBus {
+	return &EventBus{
+		conn:           c,
+		prefix:         cfg.SubjectPrefix,
+		controlSubject: cfg.ControlSubject,
+		counters:       counters,
+		throttle:       cfg.LimitEventThrottle,
+		logger:         logger,
+	}
+}
+
+// ServeControl lets the bus reset the counters of keys named in requests
+// to the control subject, if one is configured
+func (b *EventBus) ServeControl(apiKeys services.APIKeyServiceInterface, rateLimits services.RateLimitServiceInterface) {
+	b.apiKeys = apiKeys
+	b.rateLimits = rateLimits
+}
+
+// Publish sends event to its subject. An event without an ID is given one.
+func (b *EventBus) Publish(ctx context.Context, event events.Event) error {
+	if event.ID == "" {
+		id, err := database.NewUUID()
+		if err != nil {
+			return err
+		}
+		event.ID = id
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	msg := natsgo.NewMsg(b.prefix + "." + event.Type)
+	msg.Header.Set(natsgo.MsgIdHdr, event.ID)
+	msg.Data = data
+	if err := b.conn.PublishMsg(msg); err != nil {
+		metrics.NATSEvents.WithLabelValues(event.Type, "failed").Inc()
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	metrics.NATSEvents.WithLabelValues(event.Type, "published").Inc()
+	return nil
+}
+
+// NotifyLimitExceeded publishes an event for a refused request or a reached
+// alert threshold, at most once per throttle period for each key and kind of
+// limit
+func (b *EventBus) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *services.RateLimitResult) error {
+	raised, _, err := b.counters.IncrementRateLimit(ctx, "event_throttle:"+services.LimitAlertThrottleKey(apiKey, eventType, result), b.throttle)
+	if err != nil {
+		return fmt.Errorf("failed to throttle limit event: %w", err)
+	}
+	if raised > 1 {
+		return nil
+	}
+	return b.Publish(ctx, services.LimitExceededEvent(apiKey, eventType, result))
+}
+
+// Run serves the control subject, when configured, until ctx is cancelled,
+// then flushes buffered events and closes the connection
+func (b *EventBus) Run(ctx context.Context) {
+	if b.controlSubject != "" && b.rateLimits != nil {
+		if _, err := b.conn.Subscribe(b.controlSubject, b.handleControl); err != nil {
+			logging.FromContext(ctx).Error("Failed to subscribe to NATS control subject", zap.String("subject", b.controlSubject), zap.Error(err))
+		}
+	}
+
+	<-ctx.Done()
+	if err := b.conn.Drain(); err != nil {
+		logging.FromContext(ctx).Error("Failed to drain NATS connection", zap.Error(err))
+	}
+}
+
+// handleControl serves one control request, answering it when it has a
+// reply subject
+func (b *EventBus) handleControl(msg *natsgo.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+
+	reply, err := b.control(ctx, msg.Data)
+	if err != nil {
+		b.logger.Warn("NATS control request failed", zap.String("subject", msg.Subject), zap.Error(err))
+		reply = ControlReply{Error: err.Error()}
+	}
+	if msg.Reply == "" {
+		return
+	}
+	data, _ := json.Marshal(reply)
+	if err := b.conn.PublishMsg(&natsgo.Msg{Subject: msg.Reply, Data: data}); err != nil {
+		b.logger.Warn("Failed to answer NATS control request", zap.Error(err))
+	}
+}
+
+func (b *EventBus) control(ctx context.Context, body []byte) (ControlReply, error) {
+	var request ControlRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ControlReply{}, fmt.Errorf("invalid control request: %w", err)
+	}
+	if request.Action != ResetCounters {
+		return ControlReply{}, fmt.Errorf("unknown control action %q", request.Action)
+	}
+	if request.APIKey == "" {
+		return ControlReply{}, errors.New("api_key is required")
+	}
+
+	apiKey, err := b.apiKeys.GetAPIKey(ctx, request.APIKey)
+	if err != nil {
+		return ControlReply{}, err
+	}
+	// Sub-keys count against their parent's counters
+	keyID := apiKey.LimitKeyID()
+	deleted, err := b.rateLimits.ClearKeyState(ctx, keyID)
+	if err != nil {
+		return ControlReply{}, err
+	}
+	b.logger.Info("Rate limit state reset over NATS", zap.String("api_key_id", keyID), zap.Int64("redis_keys_deleted", deleted))
+	return ControlReply{OK: true, APIKeyID: keyID, RedisKeysDeleted: deleted}, nil
+}
+
+// Ensure EventBus implements events.Publisher and services.LimitAlerter
+var (
+	_ events.Publisher      = (*EventBus)(nil)
+	_ services.LimitAlerter = (*EventBus)(nil)
+)
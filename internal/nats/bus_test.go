@@ -0,0 +1,161 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeConn struct {
+	mu        sync.Mutex
+	published []*natsgo.Msg
+	handlers  map[string]natsgo.MsgHandler
+	drained   bool
+}
+
+func (c *fakeConn) PublishMsg(msg *natsgo.Msg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func (c *fakeConn) Subscribe(subject string, handler natsgo.MsgHandler) (*natsgo.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handlers == nil {
+		c.handlers = map[string]natsgo.MsgHandler{}
+	}
+	c.handlers[subject] = handler
+	return &natsgo.Subscription{}, nil
+}
+
+func (c *fakeConn) Drain() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drained = true
+	return nil
+}
+
+func (c *fakeConn) handler(subject string) natsgo.MsgHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handlers[subject]
+}
+
+type testBus struct {
+	*EventBus
+	conn       *fakeConn
+	apiKeys    *services.APIKeyService
+	rateLimits *services.RateLimitService
+}
+
+func newTestBus(t *testing.T) *testBus {
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema())
+	counters, err := database.NewCounterStore(db, 0, time.Minute)
+	require.NoError(t, err)
+
+	conn := &fakeConn{}
+	bus := newEventBus(conn, config.NATSConfig{
+		SubjectPrefix:      "ratelimiter.events",
+		ControlSubject:     "ratelimiter.control",
+		LimitEventThrottle: time.Minute,
+	}, counters, zap.NewNop())
+	apiKeys := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	rateLimits := services.NewRateLimitService(counters, config.RateLimitConfig{})
+	bus.ServeControl(apiKeys, rateLimits)
+	return &testBus{EventBus: bus, conn: conn, apiKeys: apiKeys, rateLimits: rateLimits}
+}
+
+func TestEventBus_Publish(t *testing.T) {
+	bus := newTestBus(t)
+
+	require.NoError(t, bus.Publish(context.Background(), events.Event{Type: events.APIKeyCreated, APIKeyID: "key-1"}))
+
+	require.Len(t, bus.conn.published, 1)
+	msg := bus.conn.published[0]
+	assert.Equal(t, "ratelimiter.events.api_key.created", msg.Subject)
+	var event events.Event
+	require.NoError(t, json.Unmarshal(msg.Data, &event))
+	assert.Equal(t, "key-1", event.APIKeyID)
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, event.ID, msg.Header.Get(natsgo.MsgIdHdr))
+}
+
+func TestEventBus_NotifyLimitExceeded_Throttles(t *testing.T) {
+	bus := newTestBus(t)
+	ctx := context.Background()
+	apiKey := &database.APIKey{ID: "sub-key", ParentID: "parent-key"}
+	result := &services.RateLimitResult{Limit: 10, Window: time.Minute, ResetTime: time.Now().Add(time.Minute)}
+
+	require.NoError(t, bus.NotifyLimitExceeded(ctx, apiKey, events.RateLimitExceeded, result))
+	require.NoError(t, bus.NotifyLimitExceeded(ctx, apiKey, events.RateLimitExceeded, result))
+	require.NoError(t, bus.NotifyLimitExceeded(ctx, apiKey, events.QuotaExceeded, result))
+
+	require.Len(t, bus.conn.published, 2)
+	assert.Equal(t, "ratelimiter.events.rate_limit.exceeded", bus.conn.published[0].Subject)
+	assert.Equal(t, "ratelimiter.events.quota.exceeded", bus.conn.published[1].Subject)
+	var event events.Event
+	require.NoError(t, json.Unmarshal(bus.conn.published[0].Data, &event))
+	assert.Equal(t, "parent-key", event.APIKeyID)
+	assert.Equal(t, "sub-key", event.Data["sub_key_id"])
+}
+
+func TestEventBus_Control_ResetsCounters(t *testing.T) {
+	bus := newTestBus(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	apiKey, err := bus.apiKeys.CreateAPIKey(ctx, services.CreateAPIKeyParams{Name: "Reset", RateLimitRequests: 1, RateLimitWindowSeconds: 60})
+	require.NoError(t, err)
+	record, err := bus.apiKeys.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	_, err = bus.rateLimits.CheckRateLimit(ctx, record)
+	require.NoError(t, err)
+	limited, err := bus.rateLimits.CheckRateLimit(ctx, record)
+	require.NoError(t, err)
+	require.False(t, limited.Allowed)
+
+	done := make(chan struct{})
+	go func() {
+		bus.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return bus.conn.handler("ratelimiter.control") != nil }, time.Second, time.Millisecond)
+	handler := bus.conn.handler("ratelimiter.control")
+	handler(&natsgo.Msg{Subject: "ratelimiter.control", Reply: "_INBOX.1", Data: []byte(`{"action":"reset_counters","api_key":"` + apiKey + `"}`)})
+	handler(&natsgo.Msg{Subject: "ratelimiter.control", Reply: "_INBOX.2", Data: []byte(`{"action":"shutdown"}`)})
+	cancel()
+	<-done
+	assert.True(t, bus.conn.drained)
+
+	require.Len(t, bus.conn.published, 2)
+	var reset, unknown ControlReply
+	require.NoError(t, json.Unmarshal(bus.conn.published[0].Data, &reset))
+	assert.Equal(t, "_INBOX.1", bus.conn.published[0].Subject)
+	assert.True(t, reset.OK)
+	assert.Equal(t, record.ID, reset.APIKeyID)
+	require.NoError(t, json.Unmarshal(bus.conn.published[1].Data, &unknown))
+	assert.False(t, unknown.OK)
+	assert.Contains(t, unknown.Error, "unknown control action")
+
+	allowed, err := bus.rateLimits.CheckRateLimit(context.Background(), record)
+	require.NoError(t, err)
+	assert.True(t, allowed.Allowed)
+}
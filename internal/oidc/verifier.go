@@ -0,0 +1,347 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSCacheTTL is how long fetched signing keys are trusted before
+// they are fetched again
+const DefaultJWKSCacheTTL = time.Hour
+
+// clockSkew is the leeway applied to exp and nbf
+const clockSkew = time.Minute
+
+// minRefreshInterval limits JWKS refetches triggered by unknown key IDs, so
+// tokens with made-up kids can't be used to hammer the identity provider
+const minRefreshInterval = time.Minute
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the decoded claims of a verified token
+type Claims map[string]interface{}
+
+// Strings returns the claim as a list of strings, accepting either a single
+// string or an array of strings
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Verifier validates bearer tokens issued by an OIDC provider. Signing keys
+// are discovered from the issuer's metadata and cached.
+type Verifier struct {
+	issuer     string
+	audience   string
+	cacheTTL   time.Duration
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu          sync.Mutex
+	jwksURL     string
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastRefresh time.Time
+}
+
+func NewVerifier(issuer string, audience string, cacheTTL time.Duration) *Verifier {
+	if cacheTTL <= 0 {
+		cacheTTL = DefaultJWKSCacheTTL
+	}
+	return &Verifier{
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		audience:   audience,
+		cacheTTL:   cacheTTL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+	}
+}
+
+// Verify checks the token's signature, issuer, audience and validity period
+// and returns its claims
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	key, err := v.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) validateClaims(claims Claims) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	audienceMatched := false
+	for _, aud := range claims.Strings("aud") {
+		if aud == v.audience {
+			audienceMatched = true
+			break
+		}
+	}
+	if !audienceMatched {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// signingKey returns the cached key with the given ID, refreshing the JWKS
+// when the cache has expired or the key is unknown (the provider may have
+// rotated its keys)
+func (v *Verifier) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	key, ok := v.keys[kid]
+	expired := now.Sub(v.fetchedAt) > v.cacheTTL
+	if ok && !expired {
+		return key, nil
+	}
+
+	if expired || now.Sub(v.lastRefresh) >= minRefreshInterval {
+		v.lastRefresh = now
+		keys, err := v.fetchKeys(ctx)
+		if err != nil {
+			// Keep serving known keys if the provider is briefly unavailable
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		v.keys = keys
+		v.fetchedAt = now
+		key, ok = keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if v.jwksURL == "" {
+		var metadata struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+			return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+		}
+		if metadata.JWKSURI == "" {
+			return nil, fmt.Errorf("OIDC discovery document has no jwks_uri")
+		}
+		v.jwksURL = metadata.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we don't support rather than failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC key is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") || rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
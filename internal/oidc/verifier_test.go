@@ -0,0 +1,146 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testProvider struct {
+	server      *httptest.Server
+	key         *rsa.PrivateKey
+	kid         string
+	jwksFetches int32
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	p := &testProvider{key: key, kid: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.server.URL,
+			"jwks_uri": p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&p.jwksFetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": p.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *testProvider) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "rate-limiter-admin",
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"viewer", "operator"},
+	}
+}
+
+func TestVerifier_ValidToken(t *testing.T) {
+	provider := newTestProvider(t)
+	verifier := NewVerifier(provider.server.URL, "rate-limiter-admin", time.Hour)
+
+	claims, err := verifier.Verify(context.Background(), provider.sign(t, "key-1", provider.claims()))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", claims["sub"])
+	assert.Equal(t, []string{"viewer", "operator"}, claims.Strings("roles"))
+
+	// Keys are cached between verifications
+	_, err = verifier.Verify(context.Background(), provider.sign(t, "key-1", provider.claims()))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&provider.jwksFetches))
+}
+
+func TestVerifier_RejectsInvalidClaims(t *testing.T) {
+	provider := newTestProvider(t)
+	verifier := NewVerifier(provider.server.URL, "rate-limiter-admin", time.Hour)
+
+	tests := map[string]func(map[string]interface{}){
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = "other-service" },
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"missing exp":    func(c map[string]interface{}) { delete(c, "exp") },
+		"not yet valid":  func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+	}
+	for name, mutate := range tests {
+		claims := provider.claims()
+		mutate(claims)
+
+		_, err := verifier.Verify(context.Background(), provider.sign(t, "key-1", claims))
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestVerifier_RejectsBadSignature(t *testing.T) {
+	provider := newTestProvider(t)
+	verifier := NewVerifier(provider.server.URL, "rate-limiter-admin", time.Hour)
+
+	token := provider.sign(t, "key-1", provider.claims())
+	tampered := token[:len(token)-4] + "AAAA"
+
+	_, err := verifier.Verify(context.Background(), tampered)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = verifier.Verify(context.Background(), "not-a-token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestVerifier_RefetchesKeysForUnknownKid(t *testing.T) {
+	provider := newTestProvider(t)
+	verifier := NewVerifier(provider.server.URL, "rate-limiter-admin", time.Hour)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	_, err := verifier.Verify(context.Background(), provider.sign(t, "key-1", provider.claims()))
+	assert.NoError(t, err)
+
+	// The provider rotates its key; the next refetch is throttled
+	provider.kid = "key-2"
+	_, err = verifier.Verify(context.Background(), provider.sign(t, "key-2", provider.claims()))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	now = now.Add(2 * time.Minute)
+	_, err = verifier.Verify(context.Background(), provider.sign(t, "key-2", provider.claims()))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&provider.jwksFetches))
+}
@@ -0,0 +1,242 @@
+// Package provisioning reconciles the plans and keys declared in a YAML file
+// into the database at startup, so an environment can be bootstrapped the
+// same way every time. Secrets never appear in the file: each key names the
+// environment variable that holds it.
+package provisioning
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/services"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is a provisioning file
+type File struct {
+	Plans    []Plan   `yaml:"plans"`
+	Policies []Policy `yaml:"policies"`
+	Keys     []Key    `yaml:"keys"`
+}
+
+// Plan is matched to a stored plan by name
+type Plan struct {
+	Name                   string `yaml:"name"`
+	RateLimitRequests      int    `yaml:"rate_limit_requests"`
+	RateLimitWindowSeconds int    `yaml:"rate_limit_window_seconds"`
+	QuotaRequests          int    `yaml:"quota_requests"`
+	QuotaPeriodSeconds     int    `yaml:"quota_period_seconds"`
+	BurstRequests          int    `yaml:"burst_requests"`
+}
+
+// Policy is a named set of key restrictions that keys take on with
+// `policy:`. A key's own settings win over its policy's.
+type Policy struct {
+	Name                      string   `yaml:"name"`
+	AllowedCIDRs              []string `yaml:"allowed_cidrs"`
+	AllowedOrigins            []string `yaml:"allowed_origins"`
+	EndUserLimitRequests      int      `yaml:"end_user_limit_requests"`
+	EndUserLimitWindowSeconds int      `yaml:"end_user_limit_window_seconds"`
+}
+
+// Key is matched to a stored key by ID
+type Key struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	// Environment variable holding the API key. It must be set when the key
+	// is created; afterwards it may be left unset to keep the stored secret.
+	SecretEnv                 string     `yaml:"secret_env"`
+	Plan                      string     `yaml:"plan"`
+	Policy                    string     `yaml:"policy"`
+	RateLimitRequests         int        `yaml:"rate_limit_requests"`
+	RateLimitWindowSeconds    int        `yaml:"rate_limit_window_seconds"`
+	EndUserLimitRequests      int        `yaml:"end_user_limit_requests"`
+	EndUserLimitWindowSeconds int        `yaml:"end_user_limit_window_seconds"`
+	AllowedCIDRs              []string   `yaml:"allowed_cidrs"`
+	AllowedOrigins            []string   `yaml:"allowed_origins"`
+	ParentID                  string     `yaml:"parent_id"`
+	OwnerName                 string     `yaml:"owner_name"`
+	OwnerEmail                string     `yaml:"owner_email"`
+	ExpiresAt                 *time.Time `yaml:"expires_at"`
+}
+
+// Report describes what Reconcile changed. It holds plan names and key IDs
+// only, never secrets, so it can be logged.
+type Report struct {
+	PlansCreated   []string
+	PlansUpdated   []string
+	PlansUnchanged []string
+	Keys           *services.ProvisionReport
+}
+
+// KeyProvisioner stores declared keys; services.APIKeyService is one
+type KeyProvisioner interface {
+	ProvisionAPIKeys(ctx context.Context, keys []services.ProvisionedKey) (*services.ProvisionReport, error)
+}
+
+// Load reads and validates the provisioning file at path. Unknown settings
+// are rejected, so a typo doesn't silently leave a key unrestricted.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provisioning file: %w", err)
+	}
+
+	var file File
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse provisioning file %s: %w", path, err)
+	}
+	if err := file.validate(); err != nil {
+		return nil, fmt.Errorf("invalid provisioning file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// validate checks what doesn't need the database; the keys themselves are
+// checked by ProvisionAPIKeys
+func (f *File) validate() error {
+	plans := make(map[string]bool, len(f.Plans))
+	for i, plan := range f.Plans {
+		switch {
+		case plan.Name == "":
+			return fmt.Errorf("plans[%d]: name is required", i)
+		case plans[plan.Name]:
+			return fmt.Errorf("plans[%d]: plan %q is declared more than once", i, plan.Name)
+		case plan.RateLimitRequests <= 0 || plan.RateLimitWindowSeconds <= 0:
+			return fmt.Errorf("plans[%d]: rate_limit_requests and rate_limit_window_seconds must be positive", i)
+		case plan.QuotaRequests < 0 || plan.QuotaPeriodSeconds < 0 || plan.BurstRequests < 0:
+			return fmt.Errorf("plans[%d]: quota and burst settings must not be negative", i)
+		}
+		plans[plan.Name] = true
+	}
+
+	policies := make(map[string]bool, len(f.Policies))
+	for i, policy := range f.Policies {
+		switch {
+		case policy.Name == "":
+			return fmt.Errorf("policies[%d]: name is required", i)
+		case policies[policy.Name]:
+			return fmt.Errorf("policies[%d]: policy %q is declared more than once", i, policy.Name)
+		}
+		policies[policy.Name] = true
+	}
+
+	for i, key := range f.Keys {
+		if key.Policy != "" && !policies[key.Policy] {
+			return fmt.Errorf("keys[%d]: policy %q is not declared", i, key.Policy)
+		}
+	}
+	return nil
+}
+
+// Reconcile creates the declared plans that don't exist and updates those
+// that differ, then does the same for the keys. Nothing is ever deleted, and
+// plans and keys that aren't declared are left alone.
+func Reconcile(ctx context.Context, file *File, plans services.PlanServiceInterface, keys KeyProvisioner) (*Report, error) {
+	report := &Report{PlansCreated: []string{}, PlansUpdated: []string{}, PlansUnchanged: []string{}}
+	if err := reconcilePlans(ctx, file.Plans, plans, report); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if report.Keys, err = keys.ProvisionAPIKeys(ctx, file.provisionedKeys()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func reconcilePlans(ctx context.Context, declared []Plan, plans services.PlanServiceInterface, report *Report) error {
+	stored, err := plans.ListPlans(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*database.Plan, len(stored))
+	for _, plan := range stored {
+		byName[plan.Name] = plan
+	}
+
+	for _, plan := range declared {
+		desired := &database.Plan{
+			Name:                   plan.Name,
+			RateLimitRequests:      plan.RateLimitRequests,
+			RateLimitWindowSeconds: plan.RateLimitWindowSeconds,
+			QuotaRequests:          plan.QuotaRequests,
+			QuotaPeriodSeconds:     plan.QuotaPeriodSeconds,
+			BurstRequests:          plan.BurstRequests,
+		}
+		current, ok := byName[plan.Name]
+		switch {
+		case !ok:
+			if _, err := plans.CreatePlan(ctx, desired); err != nil {
+				return fmt.Errorf("plan %q: %w", plan.Name, err)
+			}
+			report.PlansCreated = append(report.PlansCreated, plan.Name)
+		case samePlanLimits(current, desired):
+			report.PlansUnchanged = append(report.PlansUnchanged, plan.Name)
+		default:
+			if _, err := plans.UpdatePlan(ctx, current.ID, desired); err != nil {
+				return fmt.Errorf("plan %q: %w", plan.Name, err)
+			}
+			report.PlansUpdated = append(report.PlansUpdated, plan.Name)
+		}
+	}
+	return nil
+}
+
+// provisionedKeys applies the policies to the keys and reads their secrets
+// from the environment
+func (f *File) provisionedKeys() []services.ProvisionedKey {
+	policies := make(map[string]Policy, len(f.Policies))
+	for _, policy := range f.Policies {
+		policies[policy.Name] = policy
+	}
+
+	keys := make([]services.ProvisionedKey, len(f.Keys))
+	for i, key := range f.Keys {
+		policy := policies[key.Policy]
+		keys[i] = services.ProvisionedKey{
+			ID:                        key.ID,
+			Name:                      key.Name,
+			Plan:                      key.Plan,
+			RateLimitRequests:         key.RateLimitRequests,
+			RateLimitWindowSeconds:    key.RateLimitWindowSeconds,
+			EndUserLimitRequests:      key.EndUserLimitRequests,
+			EndUserLimitWindowSeconds: key.EndUserLimitWindowSeconds,
+			AllowedCIDRs:              key.AllowedCIDRs,
+			AllowedOrigins:            key.AllowedOrigins,
+			ParentID:                  key.ParentID,
+			OwnerName:                 key.OwnerName,
+			OwnerEmail:                key.OwnerEmail,
+			ExpiresAt:                 key.ExpiresAt,
+		}
+		if keys[i].EndUserLimitRequests == 0 && keys[i].EndUserLimitWindowSeconds == 0 {
+			keys[i].EndUserLimitRequests = policy.EndUserLimitRequests
+			keys[i].EndUserLimitWindowSeconds = policy.EndUserLimitWindowSeconds
+		}
+		if len(keys[i].AllowedCIDRs) == 0 {
+			keys[i].AllowedCIDRs = policy.AllowedCIDRs
+		}
+		if len(keys[i].AllowedOrigins) == 0 {
+			keys[i].AllowedOrigins = policy.AllowedOrigins
+		}
+		if key.SecretEnv != "" {
+			keys[i].Secret = os.Getenv(key.SecretEnv)
+		}
+	}
+	return keys
+}
+
+func samePlanLimits(a, b *database.Plan) bool {
+	return a.RateLimitRequests == b.RateLimitRequests &&
+		a.RateLimitWindowSeconds == b.RateLimitWindowSeconds &&
+		a.QuotaRequests == b.QuotaRequests &&
+		a.QuotaPeriodSeconds == b.QuotaPeriodSeconds &&
+		a.BurstRequests == b.BurstRequests
+}
@@ -0,0 +1,121 @@
+package provisioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleFile = `
+plans:
+  - name: partner
+    rate_limit_requests: 500
+    rate_limit_window_seconds: 60
+    quota_requests: 100000
+    quota_period_seconds: 2592000
+policies:
+  - name: internal
+    allowed_cidrs: [10.0.0.0/8]
+    end_user_limit_requests: 10
+    end_user_limit_window_seconds: 60
+keys:
+  - id: 3c5e7a9b-1d2f-4a6b-8c0d-2e4f6a8b0c1d
+    name: Checkout service
+    secret_env: CHECKOUT_API_KEY
+    plan: partner
+    policy: internal
+    allowed_cidrs: [192.168.0.0/16]
+    owner_email: payments@example.com
+    expires_at: 2030-01-01T00:00:00Z
+`
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "provisioning.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		message string
+	}{
+		{"unknown setting", "keys:\n  - id: x\n    allowed_cidr: [10.0.0.0/8]\n", "field allowed_cidr not found"},
+		{"plan without name", "plans:\n  - rate_limit_requests: 1\n    rate_limit_window_seconds: 1\n", "plans[0]: name is required"},
+		{"duplicate plan", "plans:\n  - {name: a, rate_limit_requests: 1, rate_limit_window_seconds: 1}\n  - {name: a, rate_limit_requests: 1, rate_limit_window_seconds: 1}\n", "declared more than once"},
+		{"plan without limit", "plans:\n  - name: a\n", "must be positive"},
+		{"undeclared policy", "keys:\n  - id: x\n    policy: missing\n", `policy "missing" is not declared`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, tt.content))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestLoad_Example(t *testing.T) {
+	file, err := Load("../../provisioning.example.yaml")
+	require.NoError(t, err)
+	assert.Len(t, file.Plans, 1)
+	assert.Len(t, file.Keys, 2)
+}
+
+func TestReconcile_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema())
+	plans := services.NewPlanService(db)
+	apiKeys := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	const secret = "checkout-secret-from-the-vault-0001"
+	t.Setenv("CHECKOUT_API_KEY", secret)
+	file, err := Load(writeFile(t, sampleFile))
+	require.NoError(t, err)
+
+	report, err := Reconcile(ctx, file, plans, apiKeys)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"partner"}, report.PlansCreated)
+	assert.Equal(t, []string{"3c5e7a9b-1d2f-4a6b-8c0d-2e4f6a8b0c1d"}, report.Keys.Created)
+
+	key, err := apiKeys.ValidateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, "Checkout service", key.Name)
+	assert.Equal(t, 500, key.RateLimitRequests, "limits come from the plan")
+	assert.Equal(t, []string{"192.168.0.0/16"}, key.AllowedCIDRs, "the key's own settings win")
+	assert.Equal(t, 10, key.EndUserLimitRequests, "the rest come from the policy")
+	require.NotNil(t, key.ExpiresAt)
+	assert.Equal(t, 2030, key.ExpiresAt.Year())
+
+	// Running again changes nothing, even once the secret is gone
+	os.Unsetenv("CHECKOUT_API_KEY")
+	report, err = Reconcile(ctx, file, plans, apiKeys)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"partner"}, report.PlansUnchanged)
+	assert.Len(t, report.Keys.Unchanged, 1)
+
+	file.Plans[0].RateLimitRequests = 1000
+	file.Keys[0].OwnerEmail = "checkout@example.com"
+	report, err = Reconcile(ctx, file, plans, apiKeys)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"partner"}, report.PlansUpdated)
+	assert.Len(t, report.Keys.Updated, 1)
+	key, err = apiKeys.ValidateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, key.RateLimitRequests)
+	key, err = apiKeys.GetAPIKey(ctx, key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout@example.com", key.OwnerEmail)
+}
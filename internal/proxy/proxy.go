@@ -0,0 +1,137 @@
+// Package proxy forwards requests that passed API key authentication and
+// rate limiting to an upstream service, so existing APIs can be put behind
+// the rate limiter without code changes
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// APIKeyIDHeader tells the upstream which API key a request was made with
+const APIKeyIDHeader = "X-API-Key-ID"
+
+type ginContextKey struct{}
+
+// New returns a handler forwarding requests to cfg.Upstream. Request and
+// response bodies are streamed rather than buffered, and responses are
+// flushed as they arrive so server-sent events and long polls work.
+// Register it as the router's NoRoute handler, behind middleware.RateLimit.
+func New(cfg config.ProxyConfig) (gin.HandlerFunc, error) {
+	upstream, err := url.Parse(cfg.Upstream)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", cfg.Upstream)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Timeout
+
+	reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
+	forward := reverseProxy.Director
+	reverseProxy.Director = func(req *http.Request) {
+		host := req.Host
+		forward(req)
+		if !cfg.PreserveHost {
+			req.Host = ""
+		}
+		req.Header.Set("X-Forwarded-Host", host)
+		if req.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+
+		// Only the proxy may say which key a request was made with
+		req.Header.Del(APIKeyIDHeader)
+		c, ok := req.Context().Value(ginContextKey{}).(*gin.Context)
+		if !ok {
+			return
+		}
+		if requestID := middleware.GetRequestID(c); requestID != "" {
+			req.Header.Set(middleware.RequestIDHeader, requestID)
+		}
+		apiKey := requestAPIKey(c)
+		if apiKey == nil {
+			return
+		}
+		req.Header.Set(APIKeyIDHeader, apiKey.ID)
+		// External tokens pass through: the upstream authenticates with them
+		if !cfg.ForwardAPIKey && !apiKey.External {
+			stripAPIKey(req.Header)
+		}
+	}
+	reverseProxy.Transport = transport
+	reverseProxy.FlushInterval = -1
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		c, ok := req.Context().Value(ginContextKey{}).(*gin.Context)
+		if !ok {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		proxyError(c, err)
+	}
+
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), ginContextKey{}, c)
+		reverseProxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	}, nil
+}
+
+// proxyError answers a request the upstream didn't, with 504 when it timed
+// out and 502 otherwise
+func proxyError(c *gin.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		// The client went away; there's nobody to answer
+		c.Abort()
+		return
+	}
+	logging.FromContext(c.Request.Context()).Warn("Upstream request failed", zap.String("path", c.Request.URL.Path), zap.Error(err))
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		c.JSON(http.StatusGatewayTimeout, middleware.ErrorBody(c, gin.H{
+			"error":   "Upstream timeout",
+			"message": "The upstream service did not respond in time",
+		}))
+		return
+	}
+	c.JSON(http.StatusBadGateway, middleware.ErrorBody(c, gin.H{
+		"error":   "Bad gateway",
+		"message": "The upstream service is unavailable",
+	}))
+}
+
+// stripAPIKey removes the API key the rate limiter accepted, so the upstream
+// never sees it. Authorization is only removed when it carried the key.
+func stripAPIKey(header http.Header) {
+	if header.Get("X-API-Key") != "" {
+		header.Del("X-API-Key")
+		return
+	}
+	if strings.HasPrefix(header.Get("Authorization"), "Bearer ") {
+		header.Del("Authorization")
+	}
+}
+
+func requestAPIKey(c *gin.Context) *database.APIKey {
+	if apiKey, ok := c.Get("api_key"); ok {
+		if record, ok := apiKey.(*database.APIKey); ok {
+			return record
+		}
+	}
+	return nil
+}
@@ -7,8 +7,18 @@ import (
 
 // ClientInterface defines the interface for Redis operations
 type ClientInterface interface {
-	IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, error)
+	IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error)
+	ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error)
+	SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error)
+	ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error)
 	GetRateLimitCount(ctx context.Context, key string) (int64, error)
+	GetTTL(ctx context.Context, key string) (time.Duration, error)
+	SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error
+	AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error)
+	DeleteByPattern(ctx context.Context, patterns ...string) (int64, error)
+	IncrementHashField(ctx context.Context, key string, field string, delta int64) error
+	DrainHash(ctx context.Context, key string) (map[string]int64, error)
 }
 
 // Ensure Client implements ClientInterface
@@ -2,7 +2,12 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,45 +15,513 @@ import (
 
 type Client struct {
 	*redis.Client
+	windowJitter time.Duration
+	namespace    string
+
+	mu       sync.RWMutex
+	username string
+	password string
 }
 
-func NewClient(redisURL string) (*Client, error) {
+// ClientOption configures optional Client behaviour
+type ClientOption func(*Client)
+
+// WithWindowJitter adds a random delay of up to jitter to every new window's
+// expiry so counters created at the same moment don't all reset together
+func WithWindowJitter(jitter time.Duration) ClientOption {
+	return func(c *Client) {
+		c.windowJitter = jitter
+	}
+}
+
+// WithNamespace prefixes every key the client reads or writes with
+// "namespace:", so several deployments can share a Redis
+func WithNamespace(namespace string) ClientOption {
+	return func(c *Client) {
+		if namespace != "" {
+			c.namespace = namespace + ":"
+		}
+	}
+}
+
+// key returns the Redis key for key in the client's namespace
+func (c *Client) key(key string) string {
+	return c.namespace + key
+}
+
+func NewClient(redisURL string, opts ...ClientOption) (*Client, error) {
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 
-	client := redis.NewClient(opt)
+	// Credentials are read per connection so UpdateURL can rotate them
+	c := &Client{username: opt.Username, password: opt.Password}
+	opt.CredentialsProvider = c.credentials
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.Client = redis.NewClient(opt)
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
+	if err := c.Ping(ctx).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &Client{client}, nil
+	return c, nil
+}
+
+// UpdateURL picks up new credentials from redisURL, e.g. after the Redis
+// password was rotated. They are used for connections opened from now on;
+// the address and database of the client are not changed.
+func (c *Client) UpdateURL(redisURL string) error {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = opt.Username
+	c.password = opt.Password
+	return nil
+}
+
+func (c *Client) credentials() (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.username, c.password
 }
 
-func (c *Client) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, error) {
-	pipe := c.Pipeline()
-	
-	// Increment counter
-	incr := pipe.Incr(ctx, key)
-	
-	// Set expiration if this is the first request
-	pipe.Expire(ctx, key, window)
-	
-	// Execute pipeline
-	_, err := pipe.Exec(ctx)
+// incrementScript increments a window counter, setting its expiry only when
+// the window starts, and returns {count, remaining ttl in ms}.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// IncrementRateLimit increments the counter for a fixed window and returns the
+// new count together with the time until the window resets.
+func (c *Client) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	expiry := window + c.jitterFor(window)
+
+	result, err := incrementScript.Run(ctx, c.Client, []string{c.key(key)}, expiry.Milliseconds()).Int64Slice()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return incr.Val(), nil
+	return result[0], time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// refundScript takes back up to ARGV[1] units from the unexpired counter
+// KEYS[1], no more than it holds nor than ARGV[2] minus the units already
+// refunded this window, which KEYS[2] tracks and which expires with the
+// counter. Returns the units refunded.
+var refundScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local ttl = redis.call("PTTL", KEYS[1])
+if count <= 0 or ttl <= 0 then
+	return 0
+end
+local refunded = tonumber(redis.call("GET", KEYS[2]) or "0")
+local units = math.min(tonumber(ARGV[1]), count, tonumber(ARGV[2]) - refunded)
+if units <= 0 then
+	return 0
+end
+redis.call("DECRBY", KEYS[1], units)
+redis.call("INCRBY", KEYS[2], units)
+redis.call("PEXPIRE", KEYS[2], ttl)
+return units
+`)
+
+// RefundRateLimit takes back up to units from the counter of the current
+// window, for requests that shouldn't have been counted. No more than
+// maxRefunds units are refunded per window, tracked in refundsKey. Returns
+// the units refunded, which is 0 when the window has ended.
+func (c *Client) RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error) {
+	return refundScript.Run(ctx, c.Client, []string{c.key(key), c.key(refundsKey)}, units, maxRefunds).Int64()
+}
+
+// releaseExpiredReservations is the start of the reservation scripts. The
+// reservations of counter KEYS[1] are kept in the hash KEYS[2] as "units:
+// release time in ms" by reservation ID, and expire with the counter, so
+// reservations of an earlier window are dropped. Reservations not settled by
+// their release time, ARGV[1], are removed and their units taken off the
+// counter; released is the number of units taken off.
+const releaseExpiredReservations = `
+local now = tonumber(ARGV[1])
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local released = 0
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	redis.call("DEL", KEYS[2])
+else
+	local reservations = redis.call("HGETALL", KEYS[2])
+	for i = 1, #reservations, 2 do
+		local units, releaseAt = string.match(reservations[i + 1], "(%d+):(%d+)")
+		if tonumber(releaseAt) <= now then
+			redis.call("HDEL", KEYS[2], reservations[i])
+			released = released + tonumber(units)
+		end
+	end
+	released = math.min(released, count)
+	if released > 0 then
+		count = redis.call("DECRBY", KEYS[1], released)
+	end
+end
+`
+
+// reserveScript adds ARGV[3] units to the counter KEYS[1] if that leaves it
+// within the limit ARGV[4], starting a window of ARGV[5] ms if there is none,
+// and records them as reservation ARGV[2], released after ARGV[6] ms or when
+// the window ends. Returns {reserved, count, remaining ttl in ms}.
+var reserveScript = redis.NewScript(releaseExpiredReservations + `
+local units = tonumber(ARGV[3])
+if count + units > tonumber(ARGV[4]) then
+	return {0, count, redis.call("PTTL", KEYS[1])}
+end
+count = redis.call("INCRBY", KEYS[1], units)
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[5])
+end
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("HSET", KEYS[2], ARGV[2], units .. ":" .. (now + math.min(tonumber(ARGV[6]), ttl)))
+-- Expire the reservations no later than the counter, so a new window
+-- never sees them
+if ttl > 1 then
+	redis.call("PEXPIRE", KEYS[2], ttl - 1)
+else
+	redis.call("DEL", KEYS[2])
+end
+return {1, count, ttl}
+`)
+
+// settleReservationScript removes the unreleased reservation ARGV[2] of
+// counter KEYS[1], taking its units off the counter when ARGV[3] is 1.
+// Returns its units, or 0 if there is no such reservation.
+var settleReservationScript = redis.NewScript(releaseExpiredReservations + `
+local reservation = redis.call("HGET", KEYS[2], ARGV[2])
+if not reservation then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[2])
+local units = tonumber(string.match(reservation, "(%d+):"))
+if ARGV[3] == "1" and math.min(units, count) > 0 then
+	redis.call("DECRBY", KEYS[1], math.min(units, count))
+end
+return units
+`)
+
+// releaseReservationsScript only releases the expired reservations of
+// counter KEYS[1]. Returns the units released.
+var releaseReservationsScript = redis.NewScript(releaseExpiredReservations + `
+return released
+`)
+
+// ReserveRateLimit adds units to the counter key, if that leaves it within
+// limit, as reservation id recorded in reservationsKey. A reservation holds
+// its units until it is settled with SettleReservation, or is released,
+// taking its units off the counter again, once ttl has passed without it
+// being settled. Reservations end with the window, whose length is window
+// if the reservation starts one. Returns whether the units were reserved,
+// the counter's value and the time until the window resets.
+func (c *Client) ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error) {
+	expiry := window + c.jitterFor(window)
+
+	keys := []string{c.key(key), c.key(reservationsKey)}
+	result, err := reserveScript.Run(ctx, c.Client, keys, time.Now().UnixMilli(), id, units, limit, expiry.Milliseconds(), ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return result[0] == 1, result[1], time.Duration(result[2]) * time.Millisecond, nil
+}
+
+// SettleReservation ends reservation id of the counter key before its
+// release. Its units stay counted, unless release is set, when they are
+// taken off the counter. Returns the reservation's units, or 0 if it
+// doesn't exist, was released or belonged to an earlier window.
+func (c *Client) SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error) {
+	releaseArg := 0
+	if release {
+		releaseArg = 1
+	}
+	keys := []string{c.key(key), c.key(reservationsKey)}
+	return settleReservationScript.Run(ctx, c.Client, keys, time.Now().UnixMilli(), id, releaseArg).Int64()
+}
+
+// ReleaseExpiredReservations releases the reservations of the counter key
+// that weren't settled in time, and returns the units taken off the counter
+func (c *Client) ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error) {
+	keys := []string{c.key(key), c.key(reservationsKey)}
+	return releaseReservationsScript.Run(ctx, c.Client, keys, time.Now().UnixMilli()).Int64()
+}
+
+// jitterFor returns a random jitter for a new window, never more than the
+// window itself
+func (c *Client) jitterFor(window time.Duration) time.Duration {
+	maxJitter := c.windowJitter
+	if maxJitter > window {
+		maxJitter = window
+	}
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxJitter)))
 }
 
 func (c *Client) GetRateLimitCount(ctx context.Context, key string) (int64, error) {
-	return c.Get(ctx, key).Int64()
+	return c.Get(ctx, c.key(key)).Int64()
+}
+
+// GetTTL returns the remaining time to live of a key, or 0 if the key does
+// not exist or has no expiry.
+func (c *Client) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.TTL(ctx, c.key(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (c *Client) SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	return c.Set(ctx, c.key(key), value, ttl).Err()
+}
+
+// addUniqueMemberScript adds a member to a set unless doing so would exceed
+// the cardinality limit. Returns {allowed, cardinality}.
+var addUniqueMemberScript = redis.NewScript(`
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then
+	return {1, redis.call("SCARD", KEYS[1])}
+end
+local count = redis.call("SCARD", KEYS[1])
+if count >= tonumber(ARGV[2]) then
+	return {0, count}
+end
+redis.call("SADD", KEYS[1], ARGV[1])
+if count == 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return {1, count + 1}
+`)
+
+// AddUniqueMember records member in the distinct-value set at key, refusing
+// new members once the set holds maxMembers. Members already in the set are
+// always allowed. The set expires window after its first member was added.
+func (c *Client) AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error) {
+	result, err := addUniqueMemberScript.Run(ctx, c.Client, []string{c.key(key)}, member, maxMembers, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, result[1], nil
+}
+
+// DeleteByPattern removes every key matching any of the glob patterns and
+// returns how many keys were deleted. Keys are found with SCAN so large
+// keyspaces are walked incrementally rather than blocking Redis.
+func (c *Client) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	var deleted int64
+	for _, pattern := range patterns {
+		iter := c.Scan(ctx, 0, c.key(pattern), 100).Iterator()
+		var batch []string
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) == 100 {
+				n, err := c.Del(ctx, batch...).Result()
+				if err != nil {
+					return deleted, err
+				}
+				deleted += n
+				batch = batch[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return deleted, err
+		}
+		if len(batch) > 0 {
+			n, err := c.Del(ctx, batch...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += n
+		}
+	}
+	return deleted, nil
+}
+
+func (c *Client) IncrementHashField(ctx context.Context, key string, field string, delta int64) error {
+	return c.HIncrBy(ctx, c.key(key), field, delta).Err()
+}
+
+// drainHashScript reads and deletes a hash in one step so increments that
+// arrive during a drain land in a fresh hash instead of being lost.
+var drainHashScript = redis.NewScript(`
+local values = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return values
+`)
+
+// DrainHash atomically returns and removes every field of a counter hash
+func (c *Client) DrainHash(ctx context.Context, key string) (map[string]int64, error) {
+	values, err := drainHashScript.Run(ctx, c.Client, []string{c.key(key)}).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		count, err := strconv.ParseInt(values[i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counts[values[i]] = count
+	}
+	return counts, nil
+}
+
+// featureFlagsKey holds the runtime feature flag toggles as "true"/"false"
+// hash fields
+const featureFlagsKey = "feature_flags"
+
+// LoadFeatureFlags returns the runtime feature flag toggles
+func (c *Client) LoadFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	values, err := c.HGetAll(ctx, c.key(featureFlagsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]bool, len(values))
+	for name, value := range values {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("feature flag %q: %w", name, err)
+		}
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+// SetFeatureFlag stores a runtime feature flag toggle
+func (c *Client) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	return c.HSet(ctx, c.key(featureFlagsKey), name, strconv.FormatBool(enabled)).Err()
+}
+
+// DeleteFeatureFlag removes a runtime feature flag toggle
+func (c *Client) DeleteFeatureFlag(ctx context.Context, name string) error {
+	return c.HDel(ctx, c.key(featureFlagsKey), name).Err()
+}
+
+// GetCachedResponse returns the response cached under key, or nil when
+// there is none
+func (c *Client) GetCachedResponse(ctx context.Context, key string) ([]byte, error) {
+	response, err := c.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return response, err
+}
+
+// SetCachedResponse caches response under key for ttl
+func (c *Client) SetCachedResponse(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	return c.Set(ctx, c.key(key), response, ttl).Err()
+}
+
+// acquireLeaseScript gives the lease KEYS[1] to holder ARGV[1] for ARGV[2]
+// milliseconds if it is free, or extends it if ARGV[1] already holds it, and
+// returns 1 when ARGV[1] holds it afterwards
+var acquireLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease KEYS[1] only if ARGV[1] still holds it
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLease takes or renews the lease key for holder, valid for ttl, and
+// reports whether holder holds it
+func (c *Client) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	held, err := acquireLeaseScript.Run(ctx, c.Client, []string{c.key(key)}, holder, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return held == 1, nil
+}
+
+// ReleaseLease gives up the lease key if holder holds it
+func (c *Client) ReleaseLease(ctx context.Context, key, holder string) error {
+	return releaseLeaseScript.Run(ctx, c.Client, []string{c.key(key)}, holder).Err()
+}
+
+// listenPingInterval is how long Listen waits for a message before checking
+// that the connection is still alive
+const listenPingInterval = 30 * time.Second
+
+// PublishMessage publishes payload to the subscribers of channel
+func (c *Client) PublishMessage(ctx context.Context, channel, payload string) error {
+	return c.Publish(ctx, c.key(channel), payload).Err()
+}
+
+// Listen subscribes to channel and calls onMessage with every message
+// published to it, until ctx is cancelled or the connection fails. Messages
+// published before onSubscribe is called, or while no one listens, are
+// lost.
+func (c *Client) Listen(ctx context.Context, channel string, onSubscribe func(), onMessage func(payload string)) error {
+	pubsub := c.Subscribe(ctx, c.key(channel))
+	defer pubsub.Close()
+
+	// Receiving doesn't watch ctx; closing the subscription ends it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pubsub.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		message, err := pubsub.ReceiveTimeout(ctx, listenPingInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// Quiet channel; make sure the connection is still there
+			if err := pubsub.Ping(ctx); err != nil {
+				return fmt.Errorf("failed to ping subscription: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive from %s: %w", channel, err)
+		}
+
+		switch message := message.(type) {
+		case *redis.Subscription:
+			if message.Kind == "subscribe" {
+				onSubscribe()
+			}
+		case *redis.Message:
+			onMessage(message.Payload)
+		}
+	}
 }
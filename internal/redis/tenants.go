@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// Tenants keeps the counters of each tenant (organization) under a prefix of
+// its own, "tenant:", on the shared client or on a client of its own to
+// isolate a noisy tenant on another Redis database or deployment. Keys of no
+// tenant stay unprefixed on the shared client.
+type Tenants struct {
+	shared   ClientInterface
+	isolated map[string]ClientInterface
+}
+
+// NewTenants returns Tenants keeping the counters of the tenants in isolated
+// on their client, and those of the others on shared
+func NewTenants(shared ClientInterface, isolated map[string]ClientInterface) *Tenants {
+	return &Tenants{shared: shared, isolated: isolated}
+}
+
+// For returns the client for the keys of tenant, which prefixes them with
+// the tenant ID
+func (t *Tenants) For(tenant string) ClientInterface {
+	if tenant == "" {
+		return t.shared
+	}
+	client, ok := t.isolated[tenant]
+	if !ok {
+		client = t.shared
+	}
+	return &prefixed{ClientInterface: client, prefix: tenant + ":"}
+}
+
+// DeleteByPattern removes the keys matching any of the glob patterns, of
+// every tenant and of no tenant, and returns how many keys were deleted
+func (t *Tenants) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	tenantPatterns := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		tenantPatterns[i] = "*:" + pattern
+	}
+
+	deleted, err := t.shared.DeleteByPattern(ctx, append(patterns, tenantPatterns...)...)
+	if err != nil {
+		return deleted, err
+	}
+
+	// Tenants may share an isolated client; its keys only need deleting once
+	seen := map[ClientInterface]bool{t.shared: true}
+	for _, client := range t.isolated {
+		if seen[client] {
+			continue
+		}
+		seen[client] = true
+
+		n, err := client.DeleteByPattern(ctx, tenantPatterns...)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// prefixed prefixes every key of a ClientInterface
+type prefixed struct {
+	ClientInterface
+	prefix string
+}
+
+func (p *prefixed) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	return p.ClientInterface.IncrementRateLimit(ctx, p.prefix+key, window)
+}
+
+func (p *prefixed) RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error) {
+	return p.ClientInterface.RefundRateLimit(ctx, p.prefix+key, p.prefix+refundsKey, units, maxRefunds)
+}
+
+func (p *prefixed) ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error) {
+	return p.ClientInterface.ReserveRateLimit(ctx, p.prefix+key, p.prefix+reservationsKey, id, units, limit, window, ttl)
+}
+
+func (p *prefixed) SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error) {
+	return p.ClientInterface.SettleReservation(ctx, p.prefix+key, p.prefix+reservationsKey, id, release)
+}
+
+func (p *prefixed) ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error) {
+	return p.ClientInterface.ReleaseExpiredReservations(ctx, p.prefix+key, p.prefix+reservationsKey)
+}
+
+func (p *prefixed) GetRateLimitCount(ctx context.Context, key string) (int64, error) {
+	return p.ClientInterface.GetRateLimitCount(ctx, p.prefix+key)
+}
+
+func (p *prefixed) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	return p.ClientInterface.GetTTL(ctx, p.prefix+key)
+}
+
+func (p *prefixed) SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	return p.ClientInterface.SetWithExpiry(ctx, p.prefix+key, value, ttl)
+}
+
+func (p *prefixed) AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error) {
+	return p.ClientInterface.AddUniqueMember(ctx, p.prefix+key, member, maxMembers, window)
+}
+
+func (p *prefixed) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	prefixedPatterns := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		prefixedPatterns[i] = p.prefix + pattern
+	}
+	return p.ClientInterface.DeleteByPattern(ctx, prefixedPatterns...)
+}
+
+func (p *prefixed) IncrementHashField(ctx context.Context, key string, field string, delta int64) error {
+	return p.ClientInterface.IncrementHashField(ctx, p.prefix+key, field, delta)
+}
+
+func (p *prefixed) DrainHash(ctx context.Context, key string) (map[string]int64, error) {
+	return p.ClientInterface.DrainHash(ctx, p.prefix+key)
+}
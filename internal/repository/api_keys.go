@@ -0,0 +1,163 @@
+// Package repository stores API keys and their limit overrides. Services
+// work against the APIKeyRepository interface, so the storage backend can
+// change without touching key validation, hashing or the admin API.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// ErrAPIKeyNotFound is returned when a KeyRef matches no key, or no key in
+// the state the operation requires (e.g. active)
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKeyRepository stores API keys. Implementations return
+// ErrAPIKeyNotFound, possibly wrapped, when no key matches.
+type APIKeyRepository interface {
+	// FindValid returns the active, unexpired key whose current secret has
+	// one of hashes, or whose rotated-out secret does and is still in its
+	// grace period. The key's parent must be usable too. The result carries
+	// the limits that apply: the key's own (or its parent's, for sub-keys),
+	// else its plan's, plus the newest unexpired limit override.
+	FindValid(ctx context.Context, hashes []string) (*database.APIKey, error)
+
+	// FindValidByID is FindValid for the key with ID id, for credentials
+	// that stand in for the key's secret
+	FindValidByID(ctx context.Context, id string) (*database.APIKey, error)
+
+	// Create stores key and returns its new ID. Zero limits inherit from
+	// the plan, and empty optional fields are stored as NULL.
+	Create(ctx context.Context, key *database.APIKey) (string, error)
+
+	// Get returns a key as shown by the admin API: its own settings, without
+	// secrets or resolved plan limits. Deleted keys are found too.
+	Get(ctx context.Context, ref KeyRef) (*database.APIKey, error)
+
+	// List returns the keys matching query, in query.Sort order and then
+	// newest first, as Get does. Deleted keys are left out unless
+	// query.IncludeDeleted is set. Filters and sorts on fields List doesn't
+	// load return ErrInvalidListQuery.
+	List(ctx context.Context, query APIKeyQuery) ([]*database.APIKey, error)
+
+	// Rename replaces a key's name and returns the updated key
+	Rename(ctx context.Context, ref KeyRef, name string) (*database.APIKey, error)
+
+	// UpdateOwner replaces a key's owner details and returns the updated
+	// key; empty values clear them
+	UpdateOwner(ctx context.Context, ref KeyRef, ownerName, ownerEmail string) (*database.APIKey, error)
+
+	// UpdateAlertThresholds replaces a key's alert thresholds and returns
+	// the updated key; nil clears them
+	UpdateAlertThresholds(ctx context.Context, ref KeyRef, thresholds []int64) (*database.APIKey, error)
+
+	// UpdateRefundLimit replaces the units a key may refund per window and
+	// returns the updated key
+	UpdateRefundLimit(ctx context.Context, ref KeyRef, limit int) (*database.APIKey, error)
+
+	// UpdateLimitResponse replaces the key's custom response to requests over
+	// its limits and returns the updated key; nil clears it
+	UpdateLimitResponse(ctx context.Context, ref KeyRef, response *database.LimitResponse) (*database.APIKey, error)
+
+	// UpdateKeyHash replaces the hash of key id, if it is still oldHash
+	UpdateKeyHash(ctx context.Context, id, oldHash, newHash string, hashVersion int) error
+
+	// Delete marks a key deleted. It stops working but keeps its settings,
+	// sub-keys and overrides, so Restore can bring it back.
+	Delete(ctx context.Context, ref KeyRef) error
+
+	// Restore undoes Delete and returns the restored key; deleted keys only
+	Restore(ctx context.Context, ref KeyRef) (*database.APIKey, error)
+
+	// Purge deletes a key, its sub-keys and its limit overrides, and returns
+	// its ID
+	Purge(ctx context.Context, ref KeyRef) (string, error)
+
+	// CreateLimitOverride grants an active, undeleted key rateLimitRequests
+	// until expiresAt
+	CreateLimitOverride(ctx context.Context, ref KeyRef, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error)
+
+	// Rotate replaces the secret of an active, undeleted key, keeping the
+	// old one valid for rotation.GracePeriod
+	Rotate(ctx context.Context, ref KeyRef, rotation KeyRotation) (*RotatedKey, error)
+
+	// Export returns every key with its stored hash, parents before their
+	// sub-keys, for backups and migrations between environments
+	Export(ctx context.Context) ([]*database.ExportedAPIKey, error)
+
+	// Import stores an exported key under its original ID, hash and
+	// creation time, without a signing secret. Its plan must exist by name
+	// and its parent must already be stored.
+	Import(ctx context.Context, key *database.ExportedAPIKey) error
+
+	// Update replaces the settings of key key.ID with key's: its hash,
+	// name, limits, plan (by name), allowlists, parent, owner and expiry.
+	// Whether the key is active or deleted, its signing secret and its
+	// creation time are kept. A new hash ends the grace period of a rotated-out secret.
+	Update(ctx context.Context, key *database.ExportedAPIKey) error
+
+	// InTx runs fn with a repository whose changes all take effect when fn
+	// returns nil, and none of them when it returns an error. Other callers
+	// don't see the changes before then.
+	InTx(ctx context.Context, fn func(keys APIKeyRepository) error) error
+
+	// LockOrganization makes other transactions calling it for the same
+	// organization wait until the current one ends, so that checks on its
+	// keys, such as counting them, hold until the transaction commits.
+	// Outside of InTx it has no effect.
+	LockOrganization(ctx context.Context, organizationID string) error
+}
+
+// KeyRef identifies a key by ID, or by the candidate hashes of its secret
+// when ID is empty
+type KeyRef struct {
+	ID     string
+	Hashes []string
+}
+
+// APIKeyQuery narrows List; zero fields match every key
+type APIKeyQuery struct {
+	// Matches the owner name or email, case-insensitively
+	Owner string
+
+	// Matches the sub-keys of this key
+	ParentID string
+
+	// Matches the keys of this project, or of every project of this
+	// organization
+	ProjectID      string
+	OrganizationID string
+
+	// Matches deleted keys as well
+	IncludeDeleted bool
+
+	// Conditions on the fields of the keys, all of which must hold
+	Filters []FieldFilter
+
+	// Order of the keys before newest first
+	Sort ListOrder
+
+	// Starts after the key at this position, with Values in Sort order;
+	// nil starts at the first key
+	After *KeyPosition
+
+	// At most this many keys; 0 returns them all
+	Limit int
+}
+
+// KeyRotation is the new secret of a rotated key
+type KeyRotation struct {
+	KeyHash     string
+	KeyPrefix   string
+	HashVersion int
+	GracePeriod time.Duration
+}
+
+// RotatedKey is the result of Rotate
+type RotatedKey struct {
+	ID                   string
+	PreviousKeyExpiresAt time.Time
+}
@@ -0,0 +1,483 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Every backend must pass the same behaviour tests
+func TestAPIKeyRepository_Memory(t *testing.T) {
+	testAPIKeyRepository(t, NewMemoryAPIKeyRepository())
+}
+
+func TestAPIKeyRepository_SQLite(t *testing.T) {
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema())
+
+	testAPIKeyRepository(t, NewSQLAPIKeyRepository(db))
+}
+
+func testAPIKeyRepository(t *testing.T, repo APIKeyRepository) {
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	id, err := repo.Create(ctx, &database.APIKey{
+		KeyHash:                "hash-1",
+		KeyPrefix:              "ak_prefix1",
+		Name:                   "Parent",
+		RateLimitRequests:      10,
+		RateLimitWindowSeconds: 60,
+		ExpiresAt:              &expiresAt,
+		AllowedCIDRs:           []string{"10.0.0.0/8"},
+		SigningSecret:          "ss_secret",
+		HashVersion:            1,
+		OwnerEmail:             "team@example.com",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	_, err = repo.Create(ctx, &database.APIKey{KeyHash: "hash-1", KeyPrefix: "ak_dup", Name: "Duplicate"})
+	assert.Error(t, err, "key hashes are unique")
+
+	subID, err := repo.Create(ctx, &database.APIKey{KeyHash: "hash-2", KeyPrefix: "ak_prefix2", Name: "Sub", ParentID: id})
+	require.NoError(t, err)
+
+	t.Run("find valid", func(t *testing.T) {
+		key, err := repo.FindValid(ctx, []string{"other", "hash-1"})
+		require.NoError(t, err)
+		assert.Equal(t, id, key.ID)
+		assert.Equal(t, "hash-1", key.KeyHash)
+		assert.Equal(t, 1, key.HashVersion)
+		assert.Equal(t, "ss_secret", key.SigningSecret)
+		assert.Equal(t, []string{"10.0.0.0/8"}, key.AllowedCIDRs)
+		require.NotNil(t, key.ExpiresAt)
+		assert.WithinDuration(t, expiresAt, *key.ExpiresAt, time.Millisecond)
+
+		// Sub-keys get their parent's limits
+		sub, err := repo.FindValid(ctx, []string{"hash-2"})
+		require.NoError(t, err)
+		assert.Equal(t, subID, sub.ID)
+		assert.Equal(t, id, sub.ParentID)
+		assert.Equal(t, 10, sub.RateLimitRequests)
+		require.NotNil(t, sub.ParentExpiresAt, "sub-keys end with their parent")
+		assert.WithinDuration(t, expiresAt, *sub.ParentExpiresAt, time.Millisecond)
+		assert.Nil(t, key.ParentExpiresAt)
+
+		_, err = repo.FindValid(ctx, []string{"unknown"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+		sub, err = repo.FindValidByID(ctx, subID)
+		require.NoError(t, err)
+		assert.Equal(t, "hash-2", sub.KeyHash)
+		assert.Equal(t, 10, sub.RateLimitRequests)
+		_, err = repo.FindValidByID(ctx, "5d0a4a59-2b7e-4f43-8f3e-6f5c1e2d3a4c")
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	})
+
+	t.Run("get and list", func(t *testing.T) {
+		key, err := repo.Get(ctx, KeyRef{Hashes: []string{"hash-1"}})
+		require.NoError(t, err)
+		assert.Equal(t, id, key.ID)
+		assert.True(t, key.RequireSignature)
+		assert.Empty(t, key.SigningSecret, "secrets are not returned")
+
+		_, err = repo.Get(ctx, KeyRef{ID: "00000000-0000-4000-8000-000000000000"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+		keys, err := repo.List(ctx, APIKeyQuery{Owner: "TEAM@example.com"})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, id, keys[0].ID)
+
+		keys, err = repo.List(ctx, APIKeyQuery{ParentID: id})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, subID, keys[0].ID)
+
+		keys, err = repo.List(ctx, APIKeyQuery{ParentID: id, Owner: "team@example.com"})
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("filter, sort and page", func(t *testing.T) {
+		names := map[string]string{}
+		for _, key := range []*database.APIKey{
+			{KeyHash: "hash-page-a", KeyPrefix: "ak_page_a", Name: "Page_A", RateLimitRequests: 100},
+			{KeyHash: "hash-page-b", KeyPrefix: "ak_page_b", Name: "page-b", RateLimitRequests: 50, ExpiresAt: &expiresAt},
+			{KeyHash: "hash-page-c", KeyPrefix: "ak_page_c", Name: "page-c", RateLimitRequests: 500},
+		} {
+			keyID, err := repo.Create(ctx, key)
+			require.NoError(t, err)
+			names[keyID] = key.Name
+			t.Cleanup(func() { repo.Purge(ctx, KeyRef{ID: keyID}) })
+			// Newer keys come first among equals
+			time.Sleep(2 * time.Millisecond)
+		}
+		pageKeys := FieldFilter{Field: "name", Op: "contains", Values: []interface{}{"PAGE"}}
+
+		list := func(query APIKeyQuery) []string {
+			query.Filters = append([]FieldFilter{pageKeys}, query.Filters...)
+			keys, err := repo.List(ctx, query)
+			require.NoError(t, err)
+			listed := []string{}
+			for _, key := range keys {
+				listed = append(listed, names[key.ID])
+			}
+			return listed
+		}
+		byName := ListOrder{{Field: "name"}}
+
+		assert.Equal(t, []string{"Page_A", "page-c"}, list(APIKeyQuery{Sort: byName, Filters: []FieldFilter{{Field: "rate_limit_requests", Op: "gte", Values: []interface{}{int64(100)}}}}))
+		assert.Equal(t, []string{"page-b", "page-c"}, list(APIKeyQuery{Sort: byName, Filters: []FieldFilter{{Field: "rate_limit_requests", Op: "in", Values: []interface{}{int64(50), int64(500)}}}}))
+		assert.Equal(t, []string{"Page_A"}, list(APIKeyQuery{Filters: []FieldFilter{{Field: "name", Op: "contains", Values: []interface{}{"e_a"}}}}), "wildcards match themselves")
+		assert.Equal(t, []string{"page-b"}, list(APIKeyQuery{Filters: []FieldFilter{{Field: "expires_at", Op: "lt", Values: []interface{}{expiresAt.Add(time.Second)}}}}))
+		assert.Equal(t, []string{"Page_A", "page-c"}, list(APIKeyQuery{Sort: byName, Filters: []FieldFilter{{Field: "expires_at", Op: "ne", Values: []interface{}{expiresAt}}}}), "unset fields satisfy ne")
+		assert.Equal(t, []string{"page-c", "page-b", "Page_A"}, list(APIKeyQuery{}), "newest first")
+
+		// Pages resume after the last key of the previous page
+		for _, order := range []ListOrder{
+			{{Field: "rate_limit_requests", Desc: true}},
+			{{Field: "expires_at"}},
+			{{Field: "expires_at", Desc: true}, {Field: "is_active"}},
+			nil,
+		} {
+			var paged []string
+			query := APIKeyQuery{Filters: []FieldFilter{pageKeys}, Sort: order, Limit: 1}
+			for {
+				keys, err := repo.List(ctx, query)
+				require.NoError(t, err)
+				if len(keys) == 0 {
+					break
+				}
+				paged = append(paged, names[keys[0].ID])
+				query.After = &KeyPosition{Values: order.Values(keys[0]), CreatedAt: keys[0].CreatedAt, ID: keys[0].ID}
+			}
+			assert.Equal(t, list(APIKeyQuery{Sort: order}), paged, "%v", order)
+			assert.Len(t, paged, 3)
+		}
+		assert.Equal(t, []string{"page-c", "Page_A", "page-b"}, list(APIKeyQuery{Sort: ListOrder{{Field: "rate_limit_requests", Desc: true}}}))
+		assert.Equal(t, "page-b", list(APIKeyQuery{Sort: ListOrder{{Field: "expires_at", Desc: true}}})[0], "unset values sort last")
+
+		_, err := repo.List(ctx, APIKeyQuery{Sort: ListOrder{{Field: "quota_requests"}}})
+		assert.ErrorIs(t, err, ErrInvalidListQuery)
+	})
+
+	t.Run("update owner", func(t *testing.T) {
+		key, err := repo.UpdateOwner(ctx, KeyRef{ID: id}, "Platform", "")
+		require.NoError(t, err)
+		assert.Equal(t, "Platform", key.OwnerName)
+		assert.Empty(t, key.OwnerEmail)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		key, err := repo.Rename(ctx, KeyRef{ID: id}, "Parent key")
+		require.NoError(t, err)
+		assert.Equal(t, "Parent key", key.Name)
+
+		_, err = repo.Rename(ctx, KeyRef{ID: "5d0a4a59-2b7e-4f43-8f3e-6f5c1e2d3a4c"}, "Parent key")
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	})
+
+	t.Run("update key hash", func(t *testing.T) {
+		require.NoError(t, repo.UpdateKeyHash(ctx, id, "stale", "hash-x", 2))
+		key, err := repo.FindValid(ctx, []string{"hash-1"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, key.HashVersion, "only the expected old hash is replaced")
+
+		require.NoError(t, repo.UpdateKeyHash(ctx, id, "hash-1", "hash-1b", 2))
+		key, err = repo.FindValid(ctx, []string{"hash-1b"})
+		require.NoError(t, err)
+		assert.Equal(t, 2, key.HashVersion)
+	})
+
+	t.Run("limit override", func(t *testing.T) {
+		override, err := repo.CreateLimitOverride(ctx, KeyRef{ID: id}, 500, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, id, override.APIKeyID)
+
+		// Sub-keys share the parent's override
+		sub, err := repo.FindValid(ctx, []string{"hash-2"})
+		require.NoError(t, err)
+		assert.Equal(t, 500, sub.OverrideRequests)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		rotated, err := repo.Rotate(ctx, KeyRef{ID: id}, KeyRotation{KeyHash: "hash-3", KeyPrefix: "ak_prefix3", HashVersion: 2, GracePeriod: time.Hour})
+		require.NoError(t, err)
+		assert.Equal(t, id, rotated.ID)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), rotated.PreviousKeyExpiresAt, time.Minute)
+
+		// Both secrets work during the grace period
+		for _, hash := range []string{"hash-1b", "hash-3"} {
+			key, err := repo.FindValid(ctx, []string{hash})
+			require.NoError(t, err, hash)
+			assert.Equal(t, "hash-3", key.KeyHash)
+		}
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		err := repo.InTx(ctx, func(keys APIKeyRepository) error {
+			if _, err := keys.Create(ctx, &database.APIKey{KeyHash: "hash-tx", KeyPrefix: "ak_tx", Name: "Tx"}); err != nil {
+				return err
+			}
+			_, err := keys.FindValid(ctx, []string{"hash-tx"})
+			require.NoError(t, err, "a transaction sees its own writes")
+			_, err = keys.Create(ctx, &database.APIKey{KeyHash: "hash-2", KeyPrefix: "ak_dup", Name: "Duplicate"})
+			return err
+		})
+		assert.Error(t, err)
+		_, err = repo.FindValid(ctx, []string{"hash-tx"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound, "a failed transaction changes nothing")
+
+		err = repo.InTx(ctx, func(keys APIKeyRepository) error {
+			_, err := keys.Create(ctx, &database.APIKey{KeyHash: "hash-tx", KeyPrefix: "ak_tx", Name: "Tx"})
+			return err
+		})
+		require.NoError(t, err)
+		key, err := repo.FindValid(ctx, []string{"hash-tx"})
+		require.NoError(t, err)
+		_, err = repo.Purge(ctx, KeyRef{ID: key.ID})
+		require.NoError(t, err)
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		exported, err := repo.Export(ctx)
+		require.NoError(t, err)
+		position := map[string]int{}
+		for i, key := range exported {
+			position[key.ID] = i
+		}
+		require.Contains(t, position, id)
+		require.Contains(t, position, subID)
+		assert.Less(t, position[id], position[subID], "parents come before their sub-keys")
+
+		parent := exported[position[id]]
+		assert.Equal(t, "hash-3", parent.KeyHash)
+		assert.Equal(t, 2, parent.HashVersion)
+		assert.True(t, parent.RequireSignature)
+		assert.Equal(t, []string{"10.0.0.0/8"}, parent.AllowedCIDRs)
+		assert.Equal(t, id, exported[position[subID]].ParentID)
+
+		createdAt := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Millisecond)
+		imported := &database.ExportedAPIKey{
+			ID:                "0b6d3f0e-7a53-4c1b-9b1e-3f4f1b0c2d5e",
+			KeyHash:           "hash-imported",
+			HashVersion:       2,
+			KeyPrefix:         "ak_imported",
+			Name:              "Imported",
+			IsActive:          true,
+			RateLimitRequests: 20,
+			ParentID:          id,
+			OwnerEmail:        "ops@example.com",
+			CreatedAt:         createdAt,
+		}
+		require.NoError(t, repo.Import(ctx, imported))
+		key, err := repo.Get(ctx, KeyRef{Hashes: []string{"hash-imported"}})
+		require.NoError(t, err)
+		assert.Equal(t, imported.ID, key.ID)
+		assert.Equal(t, id, key.ParentID)
+		assert.WithinDuration(t, createdAt, key.CreatedAt, time.Millisecond)
+		assert.False(t, key.RequireSignature, "signing secrets aren't imported")
+
+		assert.Error(t, repo.Import(ctx, imported), "IDs are unique")
+		assert.Error(t, repo.Import(ctx, &database.ExportedAPIKey{ID: "5d0a4a59-2b7e-4f43-8f3e-6f5c1e2d3a4b", KeyHash: "hash-plan", Name: "Plan", Plan: "missing", CreatedAt: createdAt}))
+
+		_, err = repo.Purge(ctx, KeyRef{ID: imported.ID})
+		require.NoError(t, err)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		exported, err := repo.Export(ctx)
+		require.NoError(t, err)
+		var sub *database.ExportedAPIKey
+		for _, key := range exported {
+			if key.ID == subID {
+				sub = key
+			}
+		}
+		require.NotNil(t, sub)
+
+		sub.KeyHash, sub.KeyPrefix = "hash-2b", "ak_prefix2b"
+		sub.Name = "Renamed"
+		sub.RateLimitRequests = 5
+		sub.AllowedOrigins = []string{"https://app.example.com"}
+		require.NoError(t, repo.Update(ctx, sub))
+
+		key, err := repo.FindValid(ctx, []string{"hash-2b"})
+		require.NoError(t, err)
+		assert.Equal(t, subID, key.ID)
+		_, err = repo.FindValid(ctx, []string{"hash-2"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound, "the old secret stops working")
+		key, err = repo.Get(ctx, KeyRef{ID: subID})
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed", key.Name)
+		assert.Equal(t, 5, key.RateLimitRequests)
+		assert.Equal(t, []string{"https://app.example.com"}, key.AllowedOrigins)
+		assert.Equal(t, id, key.ParentID)
+
+		sub.KeyHash = "hash-3"
+		assert.Error(t, repo.Update(ctx, sub), "key hashes are unique")
+		sub.KeyHash, sub.Plan = "hash-2b", "missing"
+		assert.Error(t, repo.Update(ctx, sub))
+		assert.ErrorIs(t, repo.Update(ctx, &database.ExportedAPIKey{ID: "5d0a4a59-2b7e-4f43-8f3e-6f5c1e2d3a4b", KeyHash: "hash-none", Name: "None"}), ErrAPIKeyNotFound)
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		require.NoError(t, repo.Delete(ctx, KeyRef{ID: id}))
+		assert.ErrorIs(t, repo.Delete(ctx, KeyRef{ID: id}), ErrAPIKeyNotFound, "keys are deleted once")
+		_, err := repo.FindValid(ctx, []string{"hash-3"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+		_, err = repo.FindValid(ctx, []string{"hash-2b"})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound, "sub-keys stop working with their parent")
+
+		key, err := repo.Get(ctx, KeyRef{ID: id})
+		require.NoError(t, err)
+		assert.NotNil(t, key.DeletedAt)
+		assert.False(t, key.IsActive)
+		listed := func(query APIKeyQuery) bool {
+			keys, err := repo.List(ctx, query)
+			require.NoError(t, err)
+			for _, key := range keys {
+				if key.ID == id {
+					return true
+				}
+			}
+			return false
+		}
+		assert.False(t, listed(APIKeyQuery{}), "deleted keys aren't listed")
+		assert.True(t, listed(APIKeyQuery{IncludeDeleted: true}))
+
+		_, err = repo.CreateLimitOverride(ctx, KeyRef{ID: id}, 500, time.Now().Add(time.Hour))
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+		_, err = repo.Rotate(ctx, KeyRef{ID: id}, KeyRotation{KeyHash: "hash-4", GracePeriod: time.Hour})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+		exported, err := repo.Export(ctx)
+		require.NoError(t, err)
+		for _, key := range exported {
+			assert.Equal(t, key.ID == id, key.DeletedAt != nil)
+		}
+
+		key, err = repo.Restore(ctx, KeyRef{Hashes: []string{"hash-3"}})
+		require.NoError(t, err)
+		assert.Equal(t, id, key.ID)
+		assert.Nil(t, key.DeletedAt)
+		assert.True(t, key.IsActive)
+		_, err = repo.Restore(ctx, KeyRef{ID: id})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound, "only deleted keys are restored")
+		_, err = repo.FindValid(ctx, []string{"hash-2b"})
+		assert.NoError(t, err, "sub-keys work again with their parent")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		require.NoError(t, repo.Delete(ctx, KeyRef{ID: id}))
+		purged, err := repo.Purge(ctx, KeyRef{Hashes: []string{"hash-3"}})
+		require.NoError(t, err)
+		assert.Equal(t, id, purged)
+		_, err = repo.Get(ctx, KeyRef{ID: subID})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound, "sub-keys are purged with their parent")
+		assert.ErrorIs(t, repo.Delete(ctx, KeyRef{ID: id}), ErrAPIKeyNotFound)
+		_, err = repo.Restore(ctx, KeyRef{ID: id})
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	})
+}
+
+func TestMemoryAPIKeyRepository_Plans(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryAPIKeyRepository()
+
+	_, err := repo.Create(ctx, &database.APIKey{KeyHash: "hash", PlanID: "pro"})
+	assert.Error(t, err, "plans must exist")
+
+	repo.AddPlan(&database.Plan{ID: "pro", RateLimitRequests: 1000, RateLimitWindowSeconds: 60, QuotaRequests: 50000, QuotaPeriodSeconds: 86400})
+	_, err = repo.Create(ctx, &database.APIKey{KeyHash: "hash", PlanID: "pro", RateLimitWindowSeconds: 10})
+	require.NoError(t, err)
+
+	key, err := repo.FindValid(ctx, []string{"hash"})
+	require.NoError(t, err)
+	assert.Equal(t, 1000, key.RateLimitRequests, "zero limits inherit from the plan")
+	assert.Equal(t, 10, key.RateLimitWindowSeconds)
+	assert.Equal(t, 50000, key.QuotaRequests)
+}
+
+func TestSQLAPIKeyRepository_ReadReplicas(t *testing.T) {
+	ctx := context.Background()
+	connect := func(name string) *database.DB {
+		db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), name+".db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		require.NoError(t, db.InitSchema())
+		return db
+	}
+	// The replica never receives the primary's writes, so reads show where
+	// each call went
+	primary, replica := connect("primary"), connect("replica")
+	repo := NewSQLAPIKeyRepository(primary, WithReadReplicas(replica))
+
+	id, err := repo.Create(ctx, &database.APIKey{KeyHash: "hash", KeyPrefix: "ak_prefix", Name: "Key", OwnerEmail: "team@example.com"})
+	require.NoError(t, err)
+
+	_, err = repo.Get(ctx, KeyRef{ID: id})
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	keys, err := repo.List(ctx, APIKeyQuery{Owner: "team@example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// Keys missing from the replica, e.g. ones created moments ago, are
+	// validated on the primary
+	key, err := repo.FindValid(ctx, []string{"hash"})
+	require.NoError(t, err)
+	assert.Equal(t, id, key.ID)
+	_, err = repo.FindValid(ctx, []string{"unknown"})
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+	// Writes and their results come from the primary
+	key, err = repo.UpdateOwner(ctx, KeyRef{ID: id}, "Platform", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Platform", key.OwnerName)
+	require.NoError(t, repo.Delete(ctx, KeyRef{ID: id}))
+}
+
+// On Postgres a failed statement aborts its transaction, so each import in
+// one runs under a savepoint that the next key's import carries on from
+func TestSQLAPIKeyRepository_ImportSavepoints(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLAPIKeyRepository(db)
+	require.Equal(t, database.Postgres, database.DialectOf(db))
+
+	exec := func(query string) *sqlmock.ExpectedExec {
+		return mock.ExpectExec("^" + regexp.QuoteMeta(query) + "$")
+	}
+	mock.ExpectBegin()
+	exec("SAVEPOINT import_key").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO api_keys").WillReturnError(errors.New(`violates foreign key constraint "api_keys_parent_id_fkey"`))
+	exec("ROLLBACK TO SAVEPOINT import_key").WillReturnResult(sqlmock.NewResult(0, 0))
+	exec("SAVEPOINT import_key").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO api_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+	exec("RELEASE SAVEPOINT import_key").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err = repo.InTx(ctx, func(tx APIKeyRepository) error {
+		orphan := &database.ExportedAPIKey{ID: "key-1", KeyHash: "hash-1", Name: "Orphan", ParentID: "missing", CreatedAt: time.Now()}
+		assert.Error(t, tx.Import(ctx, orphan))
+		return tx.Import(ctx, &database.ExportedAPIKey{ID: "key-2", KeyHash: "hash-2", Name: "Key", CreatedAt: time.Now()})
+	})
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
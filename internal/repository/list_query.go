@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidListQuery is returned, wrapped, by List for filters, sorts and
+// positions it can't apply
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// FieldFilter is a condition on a field of listed items, named by its JSON
+// name. Op is one of eq, ne, lt, lte, gt, gte, contains (a case-insensitive
+// substring) and in, which matches any of Values; the others take one
+// value. Values are strings, int64s, bools or time.Times, as the field is.
+// Unset fields, i.e. nil pointers, only satisfy ne.
+type FieldFilter struct {
+	Field  string
+	Op     string
+	Values []interface{}
+}
+
+// SortField orders listed items by a field, named by its JSON name. Unset
+// fields sort last in either direction.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListOrder is the order of listed items, by each field in turn
+type ListOrder []SortField
+
+// KeyPosition is where a key falls in the order of List: the values of its
+// sort fields, nil where unset, then its creation time and ID, which break
+// ties newest first. Pages of keys resume after the last key of the
+// previous one, so keys created or deleted meanwhile don't shift them.
+type KeyPosition struct {
+	Values    []interface{}
+	CreatedAt time.Time
+	ID        string
+}
+
+// Matches reports whether item, a struct or a pointer to one, satisfies f
+func (f FieldFilter) Matches(item interface{}) bool {
+	value, ok := fieldValue(reflect.ValueOf(item), f.Field)
+	if !ok {
+		return f.Op == "ne"
+	}
+
+	switch f.Op {
+	case "contains":
+		return strings.Contains(strings.ToLower(value.(string)), strings.ToLower(f.Values[0].(string)))
+	case "in":
+		for _, v := range f.Values {
+			if compareValues(value, v) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp := compareValues(value, f.Values[0])
+	switch f.Op {
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// Values returns the fields of item that o sorts by, nil where unset
+func (o ListOrder) Values(item interface{}) []interface{} {
+	v := reflect.ValueOf(item)
+	values := make([]interface{}, len(o))
+	for i, s := range o {
+		if value, ok := fieldValue(v, s.Field); ok {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+// Compare orders a and b, given as their Values: negative when a comes
+// first, positive when b does and 0 when o doesn't tell them apart
+func (o ListOrder) Compare(a, b []interface{}) int {
+	for i, s := range o {
+		if a[i] == nil || b[i] == nil {
+			// Unset values sort last in either direction
+			switch {
+			case a[i] != nil:
+				return -1
+			case b[i] != nil:
+				return 1
+			}
+			continue
+		}
+		if cmp := compareValues(a[i], b[i]); cmp != 0 {
+			if s.Desc {
+				return -cmp
+			}
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareValues orders two values of the same field kind
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		switch b := b.(int64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case bool:
+		if b := b.(bool); a != b {
+			if b {
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	}
+	return 0
+}
+
+// fieldIndexes caches the index of each struct field by type and JSON name
+var fieldIndexes sync.Map
+
+// fieldValue returns the field of item with JSON name name as a string,
+// int64, bool or time.Time, or false if it is a nil pointer or item has no
+// such field
+func fieldValue(item reflect.Value, name string) (interface{}, bool) {
+	for item.Kind() == reflect.Ptr {
+		if item.IsNil() {
+			return nil, false
+		}
+		item = item.Elem()
+	}
+	index, ok := fieldIndex(item.Type(), name)
+	if !ok {
+		return nil, false
+	}
+	v := item.Field(index)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	}
+	return v.Interface(), true
+}
+
+func fieldIndex(t reflect.Type, name string) (int, bool) {
+	cached, ok := fieldIndexes.Load(t)
+	if !ok {
+		indexes := map[string]int{}
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if tag != "" && tag != "-" {
+				indexes[tag] = i
+			}
+		}
+		cached, _ = fieldIndexes.LoadOrStore(t, indexes)
+	}
+	index, ok := cached.(map[string]int)[name]
+	return index, ok
+}
@@ -0,0 +1,698 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// MemoryAPIKeyRepository keeps keys in process memory. Nothing survives a
+// restart and instances don't share keys, so it suits tests and single-node
+// development setups only.
+type MemoryAPIKeyRepository struct {
+	mu        sync.RWMutex
+	keys      map[string]*memoryAPIKey
+	plans     map[string]database.Plan
+	projects  map[string]database.Project
+	orgs      map[string]database.Organization
+	overrides map[string][]database.LimitOverride
+}
+
+// memoryAPIKey is a stored key with the columns APIKey doesn't carry
+type memoryAPIKey struct {
+	database.APIKey
+	previousKeyHash      string
+	previousKeyExpiresAt time.Time
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{
+		keys:      make(map[string]*memoryAPIKey),
+		plans:     make(map[string]database.Plan),
+		projects:  make(map[string]database.Project),
+		orgs:      make(map[string]database.Organization),
+		overrides: make(map[string][]database.LimitOverride),
+	}
+}
+
+// AddPlan makes plan available to keys that reference its ID
+func (r *MemoryAPIKeyRepository) AddPlan(plan *database.Plan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plans[plan.ID] = *plan
+}
+
+// AddOrganization makes organization's limits apply to the keys of its
+// projects
+func (r *MemoryAPIKeyRepository) AddOrganization(organization *database.Organization) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orgs[organization.ID] = *organization
+}
+
+// AddProject makes project available to keys that reference its ID
+func (r *MemoryAPIKeyRepository) AddProject(project *database.Project) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects[project.ID] = *project
+}
+
+func (r *MemoryAPIKeyRepository) FindValid(ctx context.Context, hashes []string) (*database.APIKey, error) {
+	return r.findValid(func(k *memoryAPIKey, now time.Time) bool {
+		return containsString(hashes, k.KeyHash) || (containsString(hashes, k.previousKeyHash) && k.previousKeyExpiresAt.After(now))
+	})
+}
+
+func (r *MemoryAPIKeyRepository) FindValidByID(ctx context.Context, id string) (*database.APIKey, error) {
+	return r.findValid(func(k *memoryAPIKey, now time.Time) bool {
+		return k.ID == id
+	})
+}
+
+// findValid returns the usable key that matches with the limits that apply
+// to it
+func (r *MemoryAPIKeyRepository) findValid(matches func(k *memoryAPIKey, now time.Time) bool) (*database.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	for _, k := range r.keys {
+		if !matches(k, now) {
+			continue
+		}
+		l := k
+		if k.ParentID != "" {
+			l = r.keys[k.ParentID]
+		}
+		if !usable(&k.APIKey, now) || l == nil || !usable(&l.APIKey, now) {
+			continue
+		}
+
+		key := copyAPIKey(&k.APIKey)
+		key.PlanID = l.PlanID
+		key.RateLimitRequests = l.RateLimitRequests
+		key.RateLimitWindowSeconds = l.RateLimitWindowSeconds
+		key.EndUserLimitRequests = l.EndUserLimitRequests
+		key.EndUserLimitWindowSeconds = l.EndUserLimitWindowSeconds
+		key.AlertThresholds = append([]int64(nil), l.AlertThresholds...)
+		key.RefundLimit = l.RefundLimit
+		key.LimitResponse = l.LimitResponse
+		key.ProjectID, key.OrganizationID = l.ProjectID, l.OrganizationID
+		if l != k && l.ExpiresAt != nil {
+			parentExpiresAt := *l.ExpiresAt
+			key.ParentExpiresAt = &parentExpiresAt
+		}
+		if organization, ok := r.orgs[l.OrganizationID]; ok {
+			key.OrganizationLimitRequests = organization.RateLimitRequests
+			key.OrganizationLimitWindowSeconds = organization.RateLimitWindowSeconds
+		}
+		key.QuotaRequests, key.QuotaPeriodSeconds, key.BurstRequests = 0, 0, 0
+		key.LastUsedAt, key.OwnerName, key.OwnerEmail = nil, "", ""
+		if plan, ok := r.plans[l.PlanID]; ok {
+			if key.RateLimitRequests <= 0 {
+				key.RateLimitRequests = plan.RateLimitRequests
+			}
+			if key.RateLimitWindowSeconds <= 0 {
+				key.RateLimitWindowSeconds = plan.RateLimitWindowSeconds
+			}
+			key.QuotaRequests = plan.QuotaRequests
+			key.QuotaPeriodSeconds = plan.QuotaPeriodSeconds
+			key.BurstRequests = plan.BurstRequests
+			if key.LimitResponse == nil {
+				key.LimitResponse = plan.LimitResponse
+			}
+		}
+		if override := r.activeOverride(l.ID, now); override != nil {
+			key.OverrideRequests = override.RateLimitRequests
+			expiresAt := override.ExpiresAt
+			key.OverrideExpiresAt = &expiresAt
+		}
+		return key, nil
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// usable reports whether key is active, undeleted and unexpired
+func usable(key *database.APIKey, now time.Time) bool {
+	return key.IsActive && key.DeletedAt == nil && (key.ExpiresAt == nil || key.ExpiresAt.After(now))
+}
+
+// activeOverride returns the newest unexpired override of key id
+func (r *MemoryAPIKeyRepository) activeOverride(id string, now time.Time) *database.LimitOverride {
+	var newest *database.LimitOverride
+	for i, override := range r.overrides[id] {
+		if override.ExpiresAt.After(now) && (newest == nil || !override.CreatedAt.Before(newest.CreatedAt)) {
+			newest = &r.overrides[id][i]
+		}
+	}
+	return newest
+}
+
+func (r *MemoryAPIKeyRepository) Create(ctx context.Context, key *database.APIKey) (string, error) {
+	id, err := database.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Enforce what the SQL schema's constraints do
+	for _, k := range r.keys {
+		if k.KeyHash == key.KeyHash {
+			return "", fmt.Errorf("duplicate key hash")
+		}
+	}
+	if _, ok := r.plans[key.PlanID]; key.PlanID != "" && !ok {
+		return "", fmt.Errorf("plan %s does not exist", key.PlanID)
+	}
+	if _, ok := r.keys[key.ParentID]; key.ParentID != "" && !ok {
+		return "", fmt.Errorf("parent key %s does not exist", key.ParentID)
+	}
+	project, ok := r.projects[key.ProjectID]
+	if key.ProjectID != "" && !ok {
+		return "", fmt.Errorf("project %s does not exist", key.ProjectID)
+	}
+
+	stored := &memoryAPIKey{APIKey: *copyAPIKey(key)}
+	now := time.Now()
+	stored.ID = id
+	stored.IsActive = true
+	stored.CreatedAt, stored.UpdatedAt = now, now
+	stored.RequireSignature = stored.SigningSecret != ""
+	stored.OrganizationID = project.OrganizationID
+	stored.LastUsedAt = nil
+	stored.QuotaRequests, stored.QuotaPeriodSeconds, stored.BurstRequests = 0, 0, 0
+	stored.OverrideRequests, stored.OverrideExpiresAt = 0, nil
+	r.keys[id] = stored
+	return id, nil
+}
+
+func (r *MemoryAPIKeyRepository) Get(ctx context.Context, ref KeyRef) (*database.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) List(ctx context.Context, filter APIKeyQuery) ([]*database.APIKey, error) {
+	for _, f := range filter.Filters {
+		if _, ok := listColumns[f.Field]; !ok {
+			return nil, fmt.Errorf("%w: keys can't be listed by %q", ErrInvalidListQuery, f.Field)
+		}
+	}
+	for _, s := range filter.Sort {
+		if _, ok := listColumns[s.Field]; !ok {
+			return nil, fmt.Errorf("%w: keys can't be listed by %q", ErrInvalidListQuery, s.Field)
+		}
+	}
+	if filter.After != nil && len(filter.After.Values) != len(filter.Sort) {
+		return nil, fmt.Errorf("%w: the position doesn't match the sort order", ErrInvalidListQuery)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	apiKeys := []*database.APIKey{}
+	for _, k := range r.keys {
+		if filter.ParentID != "" && k.ParentID != filter.ParentID {
+			continue
+		}
+		if filter.Owner != "" && !strings.EqualFold(k.OwnerEmail, filter.Owner) && !strings.EqualFold(k.OwnerName, filter.Owner) {
+			continue
+		}
+		if filter.ProjectID != "" && k.ProjectID != filter.ProjectID {
+			continue
+		}
+		if filter.OrganizationID != "" && k.OrganizationID != filter.OrganizationID {
+			continue
+		}
+		if !filter.IncludeDeleted && k.DeletedAt != nil {
+			continue
+		}
+		key := adminView(k)
+		if matchesAll(key, filter.Filters) && (filter.After == nil || compareKeys(filter.Sort, key, filter.After) > 0) {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return compareKeys(filter.Sort, apiKeys[i], &KeyPosition{
+			Values:    filter.Sort.Values(apiKeys[j]),
+			CreatedAt: apiKeys[j].CreatedAt,
+			ID:        apiKeys[j].ID,
+		}) < 0
+	})
+	if filter.Limit > 0 && len(apiKeys) > filter.Limit {
+		apiKeys = apiKeys[:filter.Limit]
+	}
+	return apiKeys, nil
+}
+
+func matchesAll(key *database.APIKey, filters []FieldFilter) bool {
+	for _, f := range filters {
+		if !f.Matches(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareKeys orders key against position as List does
+func compareKeys(order ListOrder, key *database.APIKey, position *KeyPosition) int {
+	if cmp := order.Compare(order.Values(key), position.Values); cmp != 0 {
+		return cmp
+	}
+	switch {
+	case key.CreatedAt.After(position.CreatedAt):
+		return -1
+	case key.CreatedAt.Before(position.CreatedAt):
+		return 1
+	}
+	return -strings.Compare(key.ID, position.ID)
+}
+
+func (r *MemoryAPIKeyRepository) Rename(ctx context.Context, ref KeyRef, name string) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.Name = name
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) UpdateOwner(ctx context.Context, ref KeyRef, ownerName, ownerEmail string) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.OwnerName, k.OwnerEmail = ownerName, ownerEmail
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) UpdateAlertThresholds(ctx context.Context, ref KeyRef, thresholds []int64) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.AlertThresholds = append([]int64(nil), thresholds...)
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) UpdateRefundLimit(ctx context.Context, ref KeyRef, limit int) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.RefundLimit = limit
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) UpdateLimitResponse(ctx context.Context, ref KeyRef, response *database.LimitResponse) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.LimitResponse = response
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) UpdateKeyHash(ctx context.Context, id, oldHash, newHash string, hashVersion int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.keys[id]; ok && k.KeyHash == oldHash {
+		k.KeyHash, k.HashVersion = newHash, hashVersion
+	}
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Delete(ctx context.Context, ref KeyRef) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil || k.DeletedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	k.DeletedAt = &now
+	k.UpdatedAt = now
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Restore(ctx context.Context, ref KeyRef) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil || k.DeletedAt == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	k.DeletedAt = nil
+	k.UpdatedAt = time.Now()
+	return adminView(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) Purge(ctx context.Context, ref KeyRef) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil {
+		return "", ErrAPIKeyNotFound
+	}
+	r.delete(k.ID)
+	return k.ID, nil
+}
+
+// delete removes key id with its sub-keys and overrides, like the cascade
+// of the SQL schema's foreign keys
+func (r *MemoryAPIKeyRepository) delete(id string) {
+	delete(r.keys, id)
+	delete(r.overrides, id)
+	for _, k := range r.keys {
+		if k.ParentID == id {
+			r.delete(k.ID)
+		}
+	}
+}
+
+func (r *MemoryAPIKeyRepository) CreateLimitOverride(ctx context.Context, ref KeyRef, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	id, err := database.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil || !k.IsActive || k.DeletedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	override := database.LimitOverride{
+		ID:                id,
+		APIKeyID:          k.ID,
+		RateLimitRequests: rateLimitRequests,
+		ExpiresAt:         expiresAt,
+		CreatedAt:         time.Now(),
+	}
+	r.overrides[k.ID] = append(r.overrides[k.ID], override)
+	return &override, nil
+}
+
+func (r *MemoryAPIKeyRepository) Rotate(ctx context.Context, ref KeyRef, rotation KeyRotation) (*RotatedKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.find(ref)
+	if k == nil || !k.IsActive || k.DeletedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	k.previousKeyHash = k.KeyHash
+	k.previousKeyExpiresAt = now.Add(rotation.GracePeriod)
+	k.KeyHash = rotation.KeyHash
+	k.KeyPrefix = rotation.KeyPrefix
+	k.HashVersion = rotation.HashVersion
+	k.UpdatedAt = now
+	return &RotatedKey{ID: k.ID, PreviousKeyExpiresAt: k.previousKeyExpiresAt}, nil
+}
+
+// InTx runs fn against a copy of the keys and keeps the copy if fn
+// succeeds. Other calls wait until fn returns.
+func (r *MemoryAPIKeyRepository) InTx(ctx context.Context, fn func(keys APIKeyRepository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := r.copy()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	r.keys, r.plans, r.projects, r.orgs, r.overrides = tx.keys, tx.plans, tx.projects, tx.orgs, tx.overrides
+	return nil
+}
+
+// LockOrganization has nothing to do: InTx already holds the repository
+// until fn returns
+func (r *MemoryAPIKeyRepository) LockOrganization(ctx context.Context, organizationID string) error {
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Export(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	exported := []*database.ExportedAPIKey{}
+	for _, k := range r.keys {
+		key := copyAPIKey(&k.APIKey)
+		exported = append(exported, &database.ExportedAPIKey{
+			ID:                        key.ID,
+			KeyHash:                   key.KeyHash,
+			HashVersion:               key.HashVersion,
+			KeyPrefix:                 key.KeyPrefix,
+			Name:                      key.Name,
+			IsActive:                  key.IsActive,
+			RateLimitRequests:         key.RateLimitRequests,
+			RateLimitWindowSeconds:    key.RateLimitWindowSeconds,
+			Plan:                      r.plans[key.PlanID].Name,
+			EndUserLimitRequests:      key.EndUserLimitRequests,
+			EndUserLimitWindowSeconds: key.EndUserLimitWindowSeconds,
+			AllowedCIDRs:              key.AllowedCIDRs,
+			AllowedOrigins:            key.AllowedOrigins,
+			RequireSignature:          key.SigningSecret != "",
+			ParentID:                  key.ParentID,
+			OwnerName:                 key.OwnerName,
+			OwnerEmail:                key.OwnerEmail,
+			ExpiresAt:                 key.ExpiresAt,
+			CreatedAt:                 key.CreatedAt,
+			DeletedAt:                 key.DeletedAt,
+		})
+	}
+	sort.Slice(exported, func(i, j int) bool {
+		a, b := exported[i], exported[j]
+		if (a.ParentID != "") != (b.ParentID != "") {
+			return a.ParentID == ""
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return exported, nil
+}
+
+func (r *MemoryAPIKeyRepository) Import(ctx context.Context, key *database.ExportedAPIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Enforce what the SQL schema's constraints do
+	if _, ok := r.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate key ID")
+	}
+	for _, k := range r.keys {
+		if k.KeyHash == key.KeyHash {
+			return fmt.Errorf("duplicate key hash")
+		}
+	}
+	planID, err := r.planID(key.Plan)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.keys[key.ParentID]; key.ParentID != "" && !ok {
+		return fmt.Errorf("parent key %s does not exist", key.ParentID)
+	}
+
+	stored := &memoryAPIKey{APIKey: *copyAPIKey(&database.APIKey{
+		ID:                        key.ID,
+		KeyHash:                   key.KeyHash,
+		HashVersion:               key.HashVersion,
+		KeyPrefix:                 key.KeyPrefix,
+		Name:                      key.Name,
+		IsActive:                  key.IsActive,
+		RateLimitRequests:         key.RateLimitRequests,
+		RateLimitWindowSeconds:    key.RateLimitWindowSeconds,
+		PlanID:                    planID,
+		EndUserLimitRequests:      key.EndUserLimitRequests,
+		EndUserLimitWindowSeconds: key.EndUserLimitWindowSeconds,
+		AllowedCIDRs:              key.AllowedCIDRs,
+		AllowedOrigins:            key.AllowedOrigins,
+		ParentID:                  key.ParentID,
+		OwnerName:                 key.OwnerName,
+		OwnerEmail:                key.OwnerEmail,
+		ExpiresAt:                 key.ExpiresAt,
+		CreatedAt:                 key.CreatedAt,
+		UpdatedAt:                 time.Now(),
+		DeletedAt:                 key.DeletedAt,
+	})}
+	r.keys[key.ID] = stored
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Update(ctx context.Context, key *database.ExportedAPIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[key.ID]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	for _, other := range r.keys {
+		if other.ID != key.ID && other.KeyHash == key.KeyHash {
+			return fmt.Errorf("duplicate key hash")
+		}
+	}
+	planID, err := r.planID(key.Plan)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.keys[key.ParentID]; key.ParentID != "" && !ok {
+		return fmt.Errorf("parent key %s does not exist", key.ParentID)
+	}
+
+	if k.KeyHash != key.KeyHash {
+		k.previousKeyHash, k.previousKeyExpiresAt = "", time.Time{}
+	}
+	updated := copyAPIKey(&k.APIKey)
+	updated.KeyHash = key.KeyHash
+	updated.HashVersion = key.HashVersion
+	updated.KeyPrefix = key.KeyPrefix
+	updated.Name = key.Name
+	updated.RateLimitRequests = key.RateLimitRequests
+	updated.RateLimitWindowSeconds = key.RateLimitWindowSeconds
+	updated.PlanID = planID
+	updated.EndUserLimitRequests = key.EndUserLimitRequests
+	updated.EndUserLimitWindowSeconds = key.EndUserLimitWindowSeconds
+	updated.AllowedCIDRs = key.AllowedCIDRs
+	updated.AllowedOrigins = key.AllowedOrigins
+	updated.ParentID = key.ParentID
+	updated.OwnerName = key.OwnerName
+	updated.OwnerEmail = key.OwnerEmail
+	updated.ExpiresAt = key.ExpiresAt
+	updated.UpdatedAt = time.Now()
+	k.APIKey = *copyAPIKey(updated)
+	return nil
+}
+
+// planID returns the ID of the plan called name, or "" for no plan; r.mu
+// must be held
+func (r *MemoryAPIKeyRepository) planID(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	for id, plan := range r.plans {
+		if plan.Name == name {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("plan %q does not exist", name)
+}
+
+// copy returns a repository with a copy of r's contents; r.mu must be held
+func (r *MemoryAPIKeyRepository) copy() *MemoryAPIKeyRepository {
+	c := NewMemoryAPIKeyRepository()
+	for id, k := range r.keys {
+		stored := *k
+		stored.APIKey = *copyAPIKey(&k.APIKey)
+		c.keys[id] = &stored
+	}
+	for id, plan := range r.plans {
+		c.plans[id] = plan
+	}
+	for id, project := range r.projects {
+		c.projects[id] = project
+	}
+	for id, organization := range r.orgs {
+		c.orgs[id] = organization
+	}
+	for id, overrides := range r.overrides {
+		c.overrides[id] = append([]database.LimitOverride(nil), overrides...)
+	}
+	return c
+}
+
+// find returns the key matching ref, or nil
+func (r *MemoryAPIKeyRepository) find(ref KeyRef) *memoryAPIKey {
+	if ref.ID != "" {
+		return r.keys[ref.ID]
+	}
+	for _, k := range r.keys {
+		if containsString(ref.Hashes, k.KeyHash) {
+			return k
+		}
+	}
+	return nil
+}
+
+// adminView copies k without its secrets, as the SQL repository's admin
+// columns return it, reporting deleted keys inactive
+func adminView(k *memoryAPIKey) *database.APIKey {
+	key := copyAPIKey(&k.APIKey)
+	key.KeyHash, key.SigningSecret, key.HashVersion = "", "", 0
+	key.IsActive = key.IsActive && key.DeletedAt == nil
+	return key
+}
+
+// copyAPIKey copies key so callers can't modify stored keys
+func copyAPIKey(key *database.APIKey) *database.APIKey {
+	c := *key
+	c.AllowedCIDRs = append([]string(nil), key.AllowedCIDRs...)
+	c.AllowedOrigins = append([]string(nil), key.AllowedOrigins...)
+	c.AlertThresholds = append([]int64(nil), key.AlertThresholds...)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	if key.DeletedAt != nil {
+		deletedAt := *key.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+func containsString(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var _ APIKeyRepository = (*MemoryAPIKeyRepository)(nil)
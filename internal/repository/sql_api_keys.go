@@ -0,0 +1,948 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// SQLAPIKeyRepository stores keys in the api_keys and limit_overrides
+// tables. It runs on every database with a dialect, i.e. Postgres, MySQL
+// and SQLite, falling back to extra statements where a database lacks
+// RETURNING or arrays.
+type SQLAPIKeyRepository struct {
+	db      database.Querier
+	reader  database.Querier
+	dialect database.Dialect
+}
+
+// SQLOption configures a SQLAPIKeyRepository
+type SQLOption func(*SQLAPIKeyRepository)
+
+// WithReadReplicas sends FindValid, Get and List to reader, typically
+// database.Replicas, while writes and the reads that follow them stay on the
+// primary. Reads may trail recent writes by the replicas' allowed lag, except
+// that keys FindValid doesn't find on a replica are looked up again on the
+// primary, so a key works as soon as it is created.
+func WithReadReplicas(reader database.DBInterface) SQLOption {
+	return func(r *SQLAPIKeyRepository) {
+		r.reader = reader
+	}
+}
+
+func NewSQLAPIKeyRepository(db database.Querier, opts ...SQLOption) *SQLAPIKeyRepository {
+	r := &SQLAPIKeyRepository{db: db, reader: db, dialect: database.DialectOf(db)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// InTx runs fn in a database transaction on the primary. Reads in fn go to
+// the primary too, so they see fn's own writes.
+func (r *SQLAPIKeyRepository) InTx(ctx context.Context, fn func(keys APIKeyRepository) error) error {
+	return database.RunInTx(ctx, r.db, func(tx *database.Tx) error {
+		return fn(&SQLAPIKeyRepository{db: tx, reader: tx, dialect: r.dialect})
+	})
+}
+
+// LockOrganization touches the organization's row, which takes a row lock
+// on Postgres and MySQL and the database write lock on SQLite
+func (r *SQLAPIKeyRepository) LockOrganization(ctx context.Context, organizationID string) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE organizations SET updated_at = updated_at WHERE id = $1`, organizationID); err != nil {
+		return fmt.Errorf("failed to lock organization: %w", err)
+	}
+	return nil
+}
+
+// inTx runs the statements of a single operation in a transaction, for
+// databases without RETURNING that need a second statement to read back
+// what the first changed
+func (r *SQLAPIKeyRepository) inTx(ctx context.Context, fn func(tx database.Querier) error) error {
+	return database.RunInTx(ctx, r.db, func(tx *database.Tx) error {
+		return fn(tx)
+	})
+}
+
+func (r *SQLAPIKeyRepository) FindValid(ctx context.Context, hashes []string) (*database.APIKey, error) {
+	condition, hashArg := r.hashCondition("k.key_hash", hashes)
+	previousCondition, _ := r.hashCondition("k.previous_key_hash", hashes)
+
+	return r.findValid(ctx, `(`+condition+` OR (`+previousCondition+` AND k.previous_key_expires_at > `+r.dialect.Now()+`))`, hashArg)
+}
+
+func (r *SQLAPIKeyRepository) FindValidByID(ctx context.Context, id string) (*database.APIKey, error) {
+	return r.findValid(ctx, "k.id = $1", id)
+}
+
+// findValid returns the usable key k matching condition on $1 with the
+// limits that apply to it. A key missing from the replicas, e.g. one created
+// moments ago, is looked for on the primary.
+func (r *SQLAPIKeyRepository) findValid(ctx context.Context, condition string, arg interface{}) (*database.APIKey, error) {
+	apiKey, err := r.findValidOn(ctx, r.reader, condition, arg)
+	if errors.Is(err, ErrAPIKeyNotFound) && r.reader != r.db {
+		return r.findValidOn(ctx, r.db, condition, arg)
+	}
+	return apiKey, err
+}
+
+func (r *SQLAPIKeyRepository) findValidOn(ctx context.Context, db database.Querier, condition string, arg interface{}) (*database.APIKey, error) {
+	now := r.dialect.Now()
+
+	// Key-level limits take precedence; a value of 0 inherits from the plan.
+	// Limits, plan and overrides come from l, which is the parent for
+	// sub-keys and the key itself otherwise; a sub-key stops working when its
+	// parent is deactivated, deleted or expires. The override is the newest
+	// unexpired one.
+	query := `
+		SELECT k.id, k.key_hash, k.key_prefix, k.name,
+			CASE WHEN l.rate_limit_requests > 0 THEN l.rate_limit_requests ELSE COALESCE(p.rate_limit_requests, 0) END,
+			CASE WHEN l.rate_limit_window_seconds > 0 THEN l.rate_limit_window_seconds ELSE COALESCE(p.rate_limit_window_seconds, 0) END,
+			k.is_active, k.created_at, k.updated_at,
+			COALESCE(` + r.dialect.Text("l.plan_id") + `, ''), COALESCE(p.quota_requests, 0), COALESCE(p.quota_period_seconds, 0), COALESCE(p.burst_requests, 0),
+			COALESCE(o.rate_limit_requests, 0), o.expires_at,
+			l.end_user_limit_requests, l.end_user_limit_window_seconds, k.expires_at, k.allowed_cidrs, k.allowed_origins,
+			COALESCE(k.signing_secret, ''), COALESCE(` + r.dialect.Text("k.parent_id") + `, ''), k.hash_version, l.alert_thresholds, l.refund_limit,
+			COALESCE(l.limit_response, p.limit_response),
+			COALESCE(` + r.dialect.Text("l.project_id") + `, ''), COALESCE(` + r.dialect.Text("pr.organization_id") + `, ''),
+			COALESCE(org.rate_limit_requests, 0), COALESCE(org.rate_limit_window_seconds, 0), l.expires_at
+		FROM api_keys k
+		JOIN api_keys l ON l.id = COALESCE(k.parent_id, k.id)
+		LEFT JOIN plans p ON p.id = l.plan_id
+		LEFT JOIN projects pr ON pr.id = l.project_id
+		LEFT JOIN organizations org ON org.id = pr.organization_id
+		LEFT JOIN limit_overrides o ON o.id = (
+			SELECT id FROM limit_overrides
+			WHERE api_key_id = l.id AND expires_at > ` + now + `
+			ORDER BY created_at DESC LIMIT 1
+		)
+		WHERE ` + condition + `
+			AND k.is_active = true AND k.deleted_at IS NULL
+			AND (k.expires_at IS NULL OR k.expires_at > ` + now + `)
+			AND l.is_active = true AND l.deleted_at IS NULL
+			AND (l.expires_at IS NULL OR l.expires_at > ` + now + `)
+	`
+
+	var apiKeyRecord database.APIKey
+	var overrideExpiresAt, expiresAt, parentExpiresAt sql.NullTime
+	err := db.QueryRowContext(ctx, query, arg).Scan(
+		&apiKeyRecord.ID,
+		&apiKeyRecord.KeyHash,
+		&apiKeyRecord.KeyPrefix,
+		&apiKeyRecord.Name,
+		&apiKeyRecord.RateLimitRequests,
+		&apiKeyRecord.RateLimitWindowSeconds,
+		&apiKeyRecord.IsActive,
+		&apiKeyRecord.CreatedAt,
+		&apiKeyRecord.UpdatedAt,
+		&apiKeyRecord.PlanID,
+		&apiKeyRecord.QuotaRequests,
+		&apiKeyRecord.QuotaPeriodSeconds,
+		&apiKeyRecord.BurstRequests,
+		&apiKeyRecord.OverrideRequests,
+		&overrideExpiresAt,
+		&apiKeyRecord.EndUserLimitRequests,
+		&apiKeyRecord.EndUserLimitWindowSeconds,
+		&expiresAt,
+		r.dialect.Array(&apiKeyRecord.AllowedCIDRs),
+		r.dialect.Array(&apiKeyRecord.AllowedOrigins),
+		&apiKeyRecord.SigningSecret,
+		&apiKeyRecord.ParentID,
+		&apiKeyRecord.HashVersion,
+		r.dialect.Array(&apiKeyRecord.AlertThresholds),
+		&apiKeyRecord.RefundLimit,
+		database.ScanLimitResponse(&apiKeyRecord.LimitResponse),
+		&apiKeyRecord.ProjectID,
+		&apiKeyRecord.OrganizationID,
+		&apiKeyRecord.OrganizationLimitRequests,
+		&apiKeyRecord.OrganizationLimitWindowSeconds,
+		&parentExpiresAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if overrideExpiresAt.Valid {
+		apiKeyRecord.OverrideExpiresAt = &overrideExpiresAt.Time
+	}
+	if expiresAt.Valid {
+		apiKeyRecord.ExpiresAt = &expiresAt.Time
+	}
+	if parentExpiresAt.Valid && apiKeyRecord.ParentID != "" {
+		apiKeyRecord.ParentExpiresAt = &parentExpiresAt.Time
+	}
+	return &apiKeyRecord, nil
+}
+
+func (r *SQLAPIKeyRepository) Create(ctx context.Context, key *database.APIKey) (string, error) {
+	columns := `key_hash, key_prefix, name, rate_limit_requests, rate_limit_window_seconds, plan_id, end_user_limit_requests, end_user_limit_window_seconds, expires_at, allowed_cidrs, allowed_origins, signing_secret, hash_version, parent_id, owner_name, owner_email, project_id`
+	args := []interface{}{
+		key.KeyHash,
+		key.KeyPrefix,
+		key.Name,
+		key.RateLimitRequests,
+		key.RateLimitWindowSeconds,
+		nullString(key.PlanID),
+		key.EndUserLimitRequests,
+		key.EndUserLimitWindowSeconds,
+		key.ExpiresAt,
+		r.dialect.Array(key.AllowedCIDRs),
+		r.dialect.Array(key.AllowedOrigins),
+		nullString(key.SigningSecret),
+		key.HashVersion,
+		nullString(key.ParentID),
+		nullString(key.OwnerName),
+		nullString(key.OwnerEmail),
+		nullString(key.ProjectID),
+	}
+
+	// Postgres generates the ID; databases without RETURNING get one from us
+	var id string
+	var err error
+	if r.dialect.Returning() {
+		query := `
+			INSERT INTO api_keys (` + columns + `)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			RETURNING id
+		`
+		err = r.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	} else {
+		if id, err = database.NewUUID(); err != nil {
+			return "", err
+		}
+		query := `
+			INSERT INTO api_keys (id, ` + columns + `)
+			VALUES ($18, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		`
+		_, err = r.db.ExecContext(ctx, query, append(args, id)...)
+	}
+	return id, err
+}
+
+// adminColumns are the columns returned by the admin list and detail
+// endpoints. Secrets are never returned; the key prefix identifies each key.
+// Deleted keys are reported inactive.
+func (r *SQLAPIKeyRepository) adminColumns() string {
+	return `id, key_prefix, name, rate_limit_requests, rate_limit_window_seconds, is_active AND deleted_at IS NULL,
+	created_at, updated_at, COALESCE(` + r.dialect.Text("plan_id") + `, ''), end_user_limit_requests, end_user_limit_window_seconds,
+	expires_at, allowed_cidrs, allowed_origins, last_used_at, signing_secret IS NOT NULL, COALESCE(` + r.dialect.Text("parent_id") + `, ''),
+	COALESCE(owner_name, ''), COALESCE(owner_email, ''), alert_thresholds, refund_limit, limit_response, COALESCE(` + r.dialect.Text("project_id") + `, ''),
+	COALESCE((SELECT ` + r.dialect.Text("organization_id") + ` FROM projects WHERE projects.id = api_keys.project_id), ''), deleted_at`
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *SQLAPIKeyRepository) scanAdminAPIKey(row rowScanner) (*database.APIKey, error) {
+	var apiKeyRecord database.APIKey
+	var expiresAt, lastUsedAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&apiKeyRecord.ID,
+		&apiKeyRecord.KeyPrefix,
+		&apiKeyRecord.Name,
+		&apiKeyRecord.RateLimitRequests,
+		&apiKeyRecord.RateLimitWindowSeconds,
+		&apiKeyRecord.IsActive,
+		&apiKeyRecord.CreatedAt,
+		&apiKeyRecord.UpdatedAt,
+		&apiKeyRecord.PlanID,
+		&apiKeyRecord.EndUserLimitRequests,
+		&apiKeyRecord.EndUserLimitWindowSeconds,
+		&expiresAt,
+		r.dialect.Array(&apiKeyRecord.AllowedCIDRs),
+		r.dialect.Array(&apiKeyRecord.AllowedOrigins),
+		&lastUsedAt,
+		&apiKeyRecord.RequireSignature,
+		&apiKeyRecord.ParentID,
+		&apiKeyRecord.OwnerName,
+		&apiKeyRecord.OwnerEmail,
+		r.dialect.Array(&apiKeyRecord.AlertThresholds),
+		&apiKeyRecord.RefundLimit,
+		database.ScanLimitResponse(&apiKeyRecord.LimitResponse),
+		&apiKeyRecord.ProjectID,
+		&apiKeyRecord.OrganizationID,
+		&deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		apiKeyRecord.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKeyRecord.LastUsedAt = &lastUsedAt.Time
+	}
+	if deletedAt.Valid {
+		apiKeyRecord.DeletedAt = &deletedAt.Time
+	}
+	return &apiKeyRecord, nil
+}
+
+func (r *SQLAPIKeyRepository) Get(ctx context.Context, ref KeyRef) (*database.APIKey, error) {
+	condition, value := r.keyCondition(ref)
+
+	query := `SELECT ` + r.adminColumns() + ` FROM api_keys WHERE ` + condition
+
+	apiKeyRecord, err := r.scanAdminAPIKey(r.reader.QueryRowContext(ctx, query, value))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return apiKeyRecord, nil
+}
+
+func (r *SQLAPIKeyRepository) List(ctx context.Context, filter APIKeyQuery) ([]*database.APIKey, error) {
+	query := `SELECT ` + r.adminColumns() + ` FROM api_keys`
+	var conditions []string
+	var args []interface{}
+	if filter.ParentID != "" {
+		args = append(args, filter.ParentID)
+		conditions = append(conditions, fmt.Sprintf(`parent_id = $%d`, len(args)))
+	}
+	if filter.Owner != "" {
+		args = append(args, filter.Owner)
+		conditions = append(conditions, fmt.Sprintf(`LOWER(owner_email) = LOWER($%d) OR LOWER(owner_name) = LOWER($%[1]d)`, len(args)))
+	}
+	if filter.ProjectID != "" {
+		args = append(args, filter.ProjectID)
+		conditions = append(conditions, fmt.Sprintf(`project_id = $%d`, len(args)))
+	}
+	if filter.OrganizationID != "" {
+		args = append(args, filter.OrganizationID)
+		conditions = append(conditions, fmt.Sprintf(`project_id IN (SELECT id FROM projects WHERE organization_id = $%d)`, len(args)))
+	}
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, `deleted_at IS NULL`)
+	}
+	for _, f := range filter.Filters {
+		condition, err := r.filterCondition(f, &args)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if filter.After != nil {
+		condition, err := r.afterCondition(filter.Sort, filter.After, &args)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 1 {
+		query += ` WHERE ` + conditions[0]
+	} else if len(conditions) > 1 {
+		query += ` WHERE (` + strings.Join(conditions, `) AND (`) + `)`
+	}
+	order, err := r.orderBy(filter.Sort)
+	if err != nil {
+		return nil, err
+	}
+	query += ` ORDER BY ` + order
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, filter.Limit)
+	}
+
+	rows, err := r.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*database.APIKey{}
+	for rows.Next() {
+		apiKeyRecord, err := r.scanAdminAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		apiKeys = append(apiKeys, apiKeyRecord)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// listColumn is a field List filters and sorts keys by, computed as
+// adminColumns loads it
+type listColumn struct {
+	expr     func(d database.Dialect) string
+	nullable bool
+}
+
+func plainColumn(expr string) listColumn {
+	return listColumn{expr: func(database.Dialect) string { return expr }}
+}
+
+func textColumn(column string) listColumn {
+	return listColumn{expr: func(d database.Dialect) string { return `COALESCE(` + d.Text(column) + `, '')` }}
+}
+
+func nullableColumn(column string) listColumn {
+	return listColumn{expr: func(database.Dialect) string { return column }, nullable: true}
+}
+
+// listColumns are the fields of database.APIKey, by JSON name, that List
+// loads
+var listColumns = map[string]listColumn{
+	"id":                            {expr: func(d database.Dialect) string { return d.Text("id") }},
+	"key_prefix":                    plainColumn("key_prefix"),
+	"name":                          plainColumn("name"),
+	"rate_limit_requests":           plainColumn("rate_limit_requests"),
+	"rate_limit_window_seconds":     plainColumn("rate_limit_window_seconds"),
+	"is_active":                     plainColumn("(is_active AND deleted_at IS NULL)"),
+	"created_at":                    plainColumn("created_at"),
+	"updated_at":                    plainColumn("updated_at"),
+	"plan_id":                       textColumn("plan_id"),
+	"owner_name":                    plainColumn("COALESCE(owner_name, '')"),
+	"owner_email":                   plainColumn("COALESCE(owner_email, '')"),
+	"parent_id":                     textColumn("parent_id"),
+	"require_signature":             plainColumn("(signing_secret IS NOT NULL)"),
+	"last_used_at":                  nullableColumn("last_used_at"),
+	"expires_at":                    nullableColumn("expires_at"),
+	"deleted_at":                    nullableColumn("deleted_at"),
+	"end_user_limit_requests":       plainColumn("end_user_limit_requests"),
+	"end_user_limit_window_seconds": plainColumn("end_user_limit_window_seconds"),
+	"project_id":                    textColumn("project_id"),
+	"refund_limit":                  plainColumn("refund_limit"),
+	"organization_id": {expr: func(d database.Dialect) string {
+		return `COALESCE((SELECT ` + d.Text("organization_id") + ` FROM projects WHERE projects.id = api_keys.project_id), '')`
+	}},
+}
+
+func (r *SQLAPIKeyRepository) listColumn(field string) (string, bool, error) {
+	column, ok := listColumns[field]
+	if !ok {
+		return "", false, fmt.Errorf("%w: keys can't be listed by %q", ErrInvalidListQuery, field)
+	}
+	return column.expr(r.dialect), column.nullable, nil
+}
+
+// filterCondition is the condition for f, appending its values to args
+func (r *SQLAPIKeyRepository) filterCondition(f FieldFilter, args *[]interface{}) (string, error) {
+	expr, nullable, err := r.listColumn(f.Field)
+	if err != nil {
+		return "", err
+	}
+	if len(f.Values) == 0 {
+		return "", fmt.Errorf("%w: no value to filter %s by", ErrInvalidListQuery, f.Field)
+	}
+	placeholder := func(value interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf(`$%d`, len(*args))
+	}
+
+	switch f.Op {
+	case "contains":
+		pattern, ok := f.Values[0].(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s does not support contains", ErrInvalidListQuery, f.Field)
+		}
+		return `LOWER(` + expr + `) LIKE ` + placeholder(`%`+likeEscaper.Replace(strings.ToLower(pattern))+`%`) + ` ESCAPE '!'`, nil
+	case "in":
+		placeholders := make([]string, len(f.Values))
+		for i, value := range f.Values {
+			placeholders[i] = placeholder(value)
+		}
+		return expr + ` IN (` + strings.Join(placeholders, `, `) + `)`, nil
+	case "ne":
+		if nullable {
+			return expr + ` IS NULL OR ` + expr + ` <> ` + placeholder(f.Values[0]), nil
+		}
+		return expr + ` <> ` + placeholder(f.Values[0]), nil
+	}
+
+	operator, ok := comparisonOperators[f.Op]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown filter operator %q", ErrInvalidListQuery, f.Op)
+	}
+	return expr + ` ` + operator + ` ` + placeholder(f.Values[0]), nil
+}
+
+var comparisonOperators = map[string]string{"eq": "=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+
+// likeEscaper escapes the LIKE wildcards of a pattern with '!', which
+// unlike a backslash means the same to every database
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+// orderBy sorts by order, with unset values last, then newest first
+func (r *SQLAPIKeyRepository) orderBy(order ListOrder) (string, error) {
+	var terms []string
+	for _, s := range order {
+		expr, nullable, err := r.listColumn(s.Field)
+		if err != nil {
+			return "", err
+		}
+		if nullable {
+			terms = append(terms, `(`+expr+` IS NULL)`)
+		}
+		if s.Desc {
+			expr += ` DESC`
+		}
+		terms = append(terms, expr)
+	}
+	terms = append(terms, `created_at DESC`, r.dialect.Text("id")+` DESC`)
+	return strings.Join(terms, `, `), nil
+}
+
+// afterCondition matches the keys that orderBy puts after position: those
+// past it on some term of the order and level with it on every earlier one
+func (r *SQLAPIKeyRepository) afterCondition(order ListOrder, position *KeyPosition, args *[]interface{}) (string, error) {
+	if len(position.Values) != len(order) {
+		return "", fmt.Errorf("%w: the position doesn't match the sort order", ErrInvalidListQuery)
+	}
+
+	var alternatives, level []string
+	compare := func(expr string, desc bool, value interface{}) {
+		*args = append(*args, value)
+		operator := ` > `
+		if desc {
+			operator = ` < `
+		}
+		alternatives = append(alternatives, strings.Join(append(level[:len(level):len(level)], expr+operator+fmt.Sprintf(`$%d`, len(*args))), ` AND `))
+		level = append(level, expr+fmt.Sprintf(` = $%d`, len(*args)))
+	}
+	for i, s := range order {
+		expr, nullable, err := r.listColumn(s.Field)
+		if err != nil {
+			return "", err
+		}
+		value := position.Values[i]
+		switch {
+		case value == nil && !nullable:
+			return "", fmt.Errorf("%w: %s can't be unset", ErrInvalidListQuery, s.Field)
+		case value == nil:
+			// Nothing sorts after an unset value but other unset values
+			level = append(level, expr+` IS NULL`)
+			continue
+		case nullable:
+			alternatives = append(alternatives, strings.Join(append(level[:len(level):len(level)], expr+` IS NULL`), ` AND `))
+			level = append(level, expr+` IS NOT NULL`)
+		}
+		compare(expr, s.Desc, value)
+	}
+	compare(`created_at`, true, position.CreatedAt)
+	compare(r.dialect.Text("id"), true, position.ID)
+
+	return `(` + strings.Join(alternatives, `) OR (`) + `)`, nil
+}
+
+func (r *SQLAPIKeyRepository) Rename(ctx context.Context, ref KeyRef, name string) (*database.APIKey, error) {
+	return r.updateSettings(ctx, ref, `name = $2`, name)
+}
+
+func (r *SQLAPIKeyRepository) UpdateOwner(ctx context.Context, ref KeyRef, ownerName, ownerEmail string) (*database.APIKey, error) {
+	return r.updateSettings(ctx, ref, `owner_name = $2, owner_email = $3`, nullString(ownerName), nullString(ownerEmail))
+}
+
+func (r *SQLAPIKeyRepository) UpdateAlertThresholds(ctx context.Context, ref KeyRef, thresholds []int64) (*database.APIKey, error) {
+	return r.updateSettings(ctx, ref, `alert_thresholds = $2`, r.dialect.Array(thresholds))
+}
+
+func (r *SQLAPIKeyRepository) UpdateRefundLimit(ctx context.Context, ref KeyRef, limit int) (*database.APIKey, error) {
+	return r.updateSettings(ctx, ref, `refund_limit = $2`, limit)
+}
+
+func (r *SQLAPIKeyRepository) UpdateLimitResponse(ctx context.Context, ref KeyRef, response *database.LimitResponse) (*database.APIKey, error) {
+	return r.updateSettings(ctx, ref, `limit_response = $2`, response)
+}
+
+// updateSettings applies assignments, whose placeholders start at $2, to the
+// key ref matches and returns the updated key as Get does
+func (r *SQLAPIKeyRepository) updateSettings(ctx context.Context, ref KeyRef, assignments string, values ...interface{}) (*database.APIKey, error) {
+	return r.updateKey(ctx, ref, "", assignments, values...)
+}
+
+// updateKey is updateSettings for keys that also meet state, a condition
+// on the key's columns; keys that don't are not found
+func (r *SQLAPIKeyRepository) updateKey(ctx context.Context, ref KeyRef, state, assignments string, values ...interface{}) (*database.APIKey, error) {
+	condition, value := r.keyCondition(ref)
+	args := append([]interface{}{value}, values...)
+
+	query := `
+		UPDATE api_keys SET ` + assignments + `, updated_at = ` + r.dialect.Now() + `
+		WHERE ` + condition
+	if state != "" {
+		query += ` AND ` + state
+	}
+
+	var apiKeyRecord *database.APIKey
+	var err error
+	if r.dialect.Returning() {
+		apiKeyRecord, err = r.scanAdminAPIKey(r.db.QueryRowContext(ctx, query+` RETURNING `+r.adminColumns(), args...))
+	} else {
+		err = r.inTx(ctx, func(tx database.Querier) error {
+			result, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			// Keys not in state are still there to read back, so check
+			// whether anything was updated first
+			if state != "" {
+				if rowsAffected, err := result.RowsAffected(); err != nil {
+					return fmt.Errorf("failed to get rows affected: %w", err)
+				} else if rowsAffected == 0 {
+					return ErrAPIKeyNotFound
+				}
+			}
+			apiKeyRecord, err = r.scanAdminAPIKey(tx.QueryRowContext(ctx, `SELECT `+r.adminColumns()+` FROM api_keys WHERE `+condition, value))
+			return err
+		})
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return apiKeyRecord, nil
+}
+
+func (r *SQLAPIKeyRepository) UpdateKeyHash(ctx context.Context, id, oldHash, newHash string, hashVersion int) error {
+	query := `UPDATE api_keys SET key_hash = $3, hash_version = $4 WHERE id = $1 AND key_hash = $2`
+
+	_, err := r.db.ExecContext(ctx, query, id, oldHash, newHash, hashVersion)
+	return err
+}
+
+func (r *SQLAPIKeyRepository) Delete(ctx context.Context, ref KeyRef) error {
+	condition, value := r.keyCondition(ref)
+
+	query := `UPDATE api_keys SET deleted_at = ` + r.dialect.Now() + `, updated_at = ` + r.dialect.Now() + ` WHERE ` + condition + ` AND deleted_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, value)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *SQLAPIKeyRepository) Restore(ctx context.Context, ref KeyRef) (*database.APIKey, error) {
+	return r.updateKey(ctx, ref, `deleted_at IS NOT NULL`, `deleted_at = NULL`)
+}
+
+// Purge relies on the foreign key cascade to remove sub-keys and limit
+// overrides
+func (r *SQLAPIKeyRepository) Purge(ctx context.Context, ref KeyRef) (string, error) {
+	condition, value := r.keyCondition(ref)
+
+	query := `DELETE FROM api_keys WHERE ` + condition
+
+	var id string
+	var err error
+	if r.dialect.Returning() {
+		err = r.db.QueryRowContext(ctx, query+` RETURNING id`, value).Scan(&id)
+	} else {
+		err = r.inTx(ctx, func(tx database.Querier) error {
+			if err := tx.QueryRowContext(ctx, `SELECT id FROM api_keys WHERE `+condition, value).Scan(&id); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1`, id)
+			return err
+		})
+	}
+	if err != nil {
+		return "", notFound(err)
+	}
+	return id, nil
+}
+
+func (r *SQLAPIKeyRepository) CreateLimitOverride(ctx context.Context, ref KeyRef, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	condition, value := r.keyCondition(ref)
+
+	const columns = `id, api_key_id, rate_limit_requests, expires_at, created_at`
+
+	var override database.LimitOverride
+	scan := func(row rowScanner) error {
+		return row.Scan(
+			&override.ID,
+			&override.APIKeyID,
+			&override.RateLimitRequests,
+			&override.ExpiresAt,
+			&override.CreatedAt,
+		)
+	}
+
+	var err error
+	if r.dialect.Returning() {
+		query := `
+			INSERT INTO limit_overrides (api_key_id, rate_limit_requests, expires_at)
+			SELECT id, $2, $3 FROM api_keys WHERE ` + condition + ` AND is_active = true AND deleted_at IS NULL
+			RETURNING ` + columns
+
+		err = scan(r.db.QueryRowContext(ctx, query, value, rateLimitRequests, expiresAt))
+	} else {
+		var id string
+		if id, err = database.NewUUID(); err != nil {
+			return nil, err
+		}
+		query := `
+			INSERT INTO limit_overrides (id, api_key_id, rate_limit_requests, expires_at)
+			SELECT $4, id, $2, $3 FROM api_keys WHERE ` + condition + ` AND is_active = true AND deleted_at IS NULL`
+
+		err = r.inTx(ctx, func(tx database.Querier) error {
+			if _, err := tx.ExecContext(ctx, query, value, rateLimitRequests, expiresAt, id); err != nil {
+				return err
+			}
+			// Nothing was inserted if the key doesn't exist, is inactive or
+			// is deleted
+			return scan(tx.QueryRowContext(ctx, `SELECT `+columns+` FROM limit_overrides WHERE id = $1`, id))
+		})
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &override, nil
+}
+
+func (r *SQLAPIKeyRepository) Rotate(ctx context.Context, ref KeyRef, rotation KeyRotation) (*RotatedKey, error) {
+	condition, value := r.keyCondition(ref)
+
+	query := `
+		UPDATE api_keys
+		SET previous_key_hash = key_hash,
+			previous_key_expires_at = ` + r.dialect.AddSeconds(r.dialect.Now(), "$3") + `,
+			key_hash = $2,
+			key_prefix = $4,
+			hash_version = $5,
+			updated_at = ` + r.dialect.Now() + `
+		WHERE ` + condition + ` AND is_active = true AND deleted_at IS NULL`
+
+	var rotated RotatedKey
+	var err error
+	args := []interface{}{value, rotation.KeyHash, rotation.GracePeriod.Seconds(), rotation.KeyPrefix, rotation.HashVersion}
+	if r.dialect.Returning() {
+		err = r.db.QueryRowContext(ctx, query+`
+		RETURNING id, previous_key_expires_at`, args...).Scan(&rotated.ID, &rotated.PreviousKeyExpiresAt)
+	} else {
+		err = r.inTx(ctx, func(tx database.Querier) error {
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+			// Only a rotated key has the new hash, so no rows means not found
+			return tx.QueryRowContext(ctx, `SELECT id, previous_key_expires_at FROM api_keys WHERE key_hash = $1`, rotation.KeyHash).Scan(&rotated.ID, &rotated.PreviousKeyExpiresAt)
+		})
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &rotated, nil
+}
+
+func (r *SQLAPIKeyRepository) Export(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	query := `
+		SELECT k.id, k.key_hash, k.hash_version, k.key_prefix, k.name, k.is_active,
+			k.rate_limit_requests, k.rate_limit_window_seconds, COALESCE(p.name, ''),
+			k.end_user_limit_requests, k.end_user_limit_window_seconds, k.allowed_cidrs, k.allowed_origins,
+			k.signing_secret IS NOT NULL, COALESCE(` + r.dialect.Text("k.parent_id") + `, ''),
+			COALESCE(k.owner_name, ''), COALESCE(k.owner_email, ''), k.expires_at, k.created_at, k.deleted_at
+		FROM api_keys k
+		LEFT JOIN plans p ON p.id = k.plan_id
+		ORDER BY k.parent_id IS NOT NULL, k.created_at, k.id
+	`
+
+	rows, err := r.reader.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exported := []*database.ExportedAPIKey{}
+	for rows.Next() {
+		var key database.ExportedAPIKey
+		var expiresAt, deletedAt sql.NullTime
+		err := rows.Scan(
+			&key.ID,
+			&key.KeyHash,
+			&key.HashVersion,
+			&key.KeyPrefix,
+			&key.Name,
+			&key.IsActive,
+			&key.RateLimitRequests,
+			&key.RateLimitWindowSeconds,
+			&key.Plan,
+			&key.EndUserLimitRequests,
+			&key.EndUserLimitWindowSeconds,
+			r.dialect.Array(&key.AllowedCIDRs),
+			r.dialect.Array(&key.AllowedOrigins),
+			&key.RequireSignature,
+			&key.ParentID,
+			&key.OwnerName,
+			&key.OwnerEmail,
+			&expiresAt,
+			&key.CreatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		if expiresAt.Valid {
+			key.ExpiresAt = &expiresAt.Time
+		}
+		if deletedAt.Valid {
+			key.DeletedAt = &deletedAt.Time
+		}
+		exported = append(exported, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return exported, nil
+}
+
+func (r *SQLAPIKeyRepository) Import(ctx context.Context, key *database.ExportedAPIKey) error {
+	// In a transaction, a key that fails to import mustn't abort it for the
+	// keys after it
+	if tx, ok := r.db.(*database.Tx); ok {
+		return tx.Savepoint(ctx, "import_key", func() error {
+			return r.insertImported(ctx, key)
+		})
+	}
+	return r.insertImported(ctx, key)
+}
+
+func (r *SQLAPIKeyRepository) insertImported(ctx context.Context, key *database.ExportedAPIKey) error {
+	planID, err := r.planID(ctx, key.Plan)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO api_keys (id, key_hash, hash_version, key_prefix, name, is_active, rate_limit_requests, rate_limit_window_seconds,
+			plan_id, end_user_limit_requests, end_user_limit_window_seconds, allowed_cidrs, allowed_origins, parent_id,
+			owner_name, owner_email, expires_at, created_at, deleted_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, ` + r.dialect.Now() + `)
+	`
+	_, err = r.db.ExecContext(ctx, query,
+		key.ID,
+		key.KeyHash,
+		key.HashVersion,
+		key.KeyPrefix,
+		key.Name,
+		key.IsActive,
+		key.RateLimitRequests,
+		key.RateLimitWindowSeconds,
+		planID,
+		key.EndUserLimitRequests,
+		key.EndUserLimitWindowSeconds,
+		r.dialect.Array(key.AllowedCIDRs),
+		r.dialect.Array(key.AllowedOrigins),
+		nullString(key.ParentID),
+		nullString(key.OwnerName),
+		nullString(key.OwnerEmail),
+		key.ExpiresAt,
+		key.CreatedAt,
+		key.DeletedAt,
+	)
+	return err
+}
+
+func (r *SQLAPIKeyRepository) Update(ctx context.Context, key *database.ExportedAPIKey) error {
+	planID, err := r.planID(ctx, key.Plan)
+	if err != nil {
+		return err
+	}
+
+	// The previous secret's columns come first: MySQL evaluates assignments
+	// in order, so key_hash must still hold the old hash when they're set
+	query := `
+		UPDATE api_keys
+		SET previous_key_hash = CASE WHEN key_hash = $2 THEN previous_key_hash ELSE NULL END,
+			previous_key_expires_at = CASE WHEN key_hash = $2 THEN previous_key_expires_at ELSE NULL END,
+			key_hash = $2, hash_version = $3, key_prefix = $4, name = $5,
+			rate_limit_requests = $6, rate_limit_window_seconds = $7, plan_id = $8,
+			end_user_limit_requests = $9, end_user_limit_window_seconds = $10,
+			allowed_cidrs = $11, allowed_origins = $12, parent_id = $13,
+			owner_name = $14, owner_email = $15, expires_at = $16, updated_at = ` + r.dialect.Now() + `
+		WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query,
+		key.ID,
+		key.KeyHash,
+		key.HashVersion,
+		key.KeyPrefix,
+		key.Name,
+		key.RateLimitRequests,
+		key.RateLimitWindowSeconds,
+		planID,
+		key.EndUserLimitRequests,
+		key.EndUserLimitWindowSeconds,
+		r.dialect.Array(key.AllowedCIDRs),
+		r.dialect.Array(key.AllowedOrigins),
+		nullString(key.ParentID),
+		nullString(key.OwnerName),
+		nullString(key.OwnerEmail),
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// planID returns the ID of the plan called name, or nil for no plan
+func (r *SQLAPIKeyRepository) planID(ctx context.Context, name string) (interface{}, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var id string
+	if err := r.db.QueryRowContext(ctx, `SELECT id FROM plans WHERE name = $1`, name).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("plan %q does not exist", name)
+		}
+		return nil, err
+	}
+	return id, nil
+}
+
+// keyCondition returns the condition and $1 argument matching ref
+func (r *SQLAPIKeyRepository) keyCondition(ref KeyRef) (string, interface{}) {
+	if ref.ID != "" {
+		return "id = $1", ref.ID
+	}
+	return r.hashCondition("key_hash", ref.Hashes)
+}
+
+// hashCondition matches column against every hash as $1. With a single hash
+// this is a plain equality.
+func (r *SQLAPIKeyRepository) hashCondition(column string, hashes []string) (string, interface{}) {
+	if len(hashes) == 1 {
+		return column + " = $1", hashes[0]
+	}
+	return r.dialect.In(column, "$1"), r.dialect.List(hashes)
+}
+
+// notFound maps sql.ErrNoRows to ErrAPIKeyNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// nullString maps an empty string to SQL NULL for optional columns
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+var _ APIKeyRepository = (*SQLAPIKeyRepository)(nil)
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server is an HTTP server together with how it starts listening, e.g.
+// (*http.Server).ListenAndServe. Servers of other protocols, such as the
+// one GRPC returns, have no HTTP server and stop through Shutdown and Close
+// instead.
+type Server struct {
+	Name  string
+	HTTP  *http.Server
+	Serve func(*http.Server) error
+
+	Shutdown func(ctx context.Context) error
+	Close    func()
+}
+
+func (s Server) shutdown(ctx context.Context) error {
+	if s.Shutdown != nil {
+		return s.Shutdown(ctx)
+	}
+	return s.HTTP.Shutdown(ctx)
+}
+
+func (s Server) close() {
+	if s.Close != nil {
+		s.Close()
+		return
+	}
+	s.HTTP.Close()
+}
+
+// ShutdownConfig controls how Run stops its servers. Delay keeps serving
+// after shutdown starts, while readiness already fails, so load balancers
+// stop routing new requests first; Timeout bounds how long in-flight
+// requests may take to finish before connections are closed.
+type ShutdownConfig struct {
+	Delay   time.Duration
+	Timeout time.Duration
+}
+
+// Run serves all servers until ctx is done or one of them fails. It then
+// calls onShutdown, waits cfg.Delay and shuts the servers down gracefully,
+// closing whatever connections are still open after cfg.Timeout. The error
+// of a failed server is returned, otherwise any shutdown error.
+func Run(ctx context.Context, cfg ShutdownConfig, onShutdown func(), servers ...Server) error {
+	failed := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv Server) {
+			if err := srv.Serve(srv.HTTP); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("%s server: %w", srv.Name, err)
+			}
+		}(srv)
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-failed:
+	}
+
+	if onShutdown != nil {
+		onShutdown()
+	}
+	if runErr == nil && cfg.Delay > 0 {
+		time.Sleep(cfg.Delay)
+	}
+
+	shutdownCtx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.Timeout)
+		defer cancel()
+	}
+
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		shutdownErr error
+	)
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv Server) {
+			defer wg.Done()
+			if err := srv.shutdown(shutdownCtx); err != nil {
+				// Drop the connections that didn't finish in time
+				srv.close()
+				mu.Lock()
+				shutdownErr = fmt.Errorf("%s server: %w", srv.Name, err)
+				mu.Unlock()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	if runErr != nil {
+		return runErr
+	}
+	return shutdownErr
+}
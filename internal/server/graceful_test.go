@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer serves requests that take delay, on a random local port
+func slowServer(t *testing.T, delay time.Duration) (Server, string, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{}, 1)
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(delay)
+		io.WriteString(w, "done")
+	})}
+	srv := Server{
+		Name:  "test",
+		HTTP:  httpServer,
+		Serve: func(s *http.Server) error { return s.Serve(listener) },
+	}
+	return srv, "http://" + listener.Addr().String(), started
+}
+
+func TestRun_DrainsInFlightRequests(t *testing.T) {
+	srv, url, started := slowServer(t, 200*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var shutdownCalled bool
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, ShutdownConfig{Timeout: 5 * time.Second}, func() { shutdownCalled = true }, srv)
+	}()
+
+	responses := make(chan string)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	cancel()
+
+	assert.Equal(t, "done", <-responses)
+	require.NoError(t, <-done)
+	assert.True(t, shutdownCalled)
+
+	// New connections are refused once shut down
+	_, err := http.Get(url)
+	assert.Error(t, err)
+}
+
+func TestRun_DrainTimeout(t *testing.T) {
+	srv, url, started := slowServer(t, 2*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, ShutdownConfig{Timeout: 50 * time.Millisecond}, nil, srv)
+	}()
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the drain timeout")
+	}
+}
+
+func TestRun_ServerFailure(t *testing.T) {
+	failing := Server{
+		Name:  "broken",
+		HTTP:  &http.Server{},
+		Serve: func(*http.Server) error { return errors.New("address already in use") },
+	}
+	srv, _, _ := slowServer(t, 0)
+
+	err := Run(context.Background(), ShutdownConfig{Delay: time.Hour, Timeout: time.Second}, nil, failing, srv)
+	assert.EqualError(t, err, "broken server: address already in use")
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// GRPC returns a Server serving grpcServer on address (see Listen). Its
+// graceful shutdown waits for in-flight calls to finish; Close cancels them.
+func GRPC(name, address string, grpcServer *grpc.Server) Server {
+	return Server{
+		Name: name,
+		Serve: func(*http.Server) error {
+			listener, err := Listen(address)
+			if err != nil {
+				return err
+			}
+			if err := grpcServer.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				return err
+			}
+			return nil
+		},
+		Shutdown: func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		Close: grpcServer.Stop,
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// unixPrefix marks a listen address as a Unix domain socket path
+const unixPrefix = "unix:"
+
+// SocketMode is the file mode of Unix domain sockets created by Listen, so
+// only the service's user and group can connect
+const SocketMode os.FileMode = 0o660
+
+// Listen listens on address, either "host:port" for TCP or "unix:/path" for
+// a Unix domain socket. A stale socket file left by a previous run is
+// removed first.
+func Listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixPrefix)
+	if path == "" {
+		return nil, fmt.Errorf("missing socket path in %q", address)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, SocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// ServeOn returns a Serve function for Server that listens on address (see
+// Listen) and serves HTTPS when the server has a TLS configuration, with the
+// certificate in certFile and keyFile or from the configuration itself
+func ServeOn(address, certFile, keyFile string) func(*http.Server) error {
+	return func(s *http.Server) error {
+		listener, err := Listen(address)
+		if err != nil {
+			return err
+		}
+		if s.TLSConfig != nil {
+			return s.ServeTLS(listener, certFile, keyFile)
+		}
+		return s.Serve(listener)
+	}
+}
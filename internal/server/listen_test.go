@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	// A socket file left over from a crash doesn't block the next start
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	listener, err := Listen("unix:" + path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode()&os.ModeSocket)
+	assert.Equal(t, SocketMode, info.Mode().Perm())
+
+	_, err = Listen("unix:")
+	assert.Error(t, err)
+}
+
+func TestListen_TCP(t *testing.T) {
+	listener, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	assert.Equal(t, "tcp", listener.Addr().Network())
+}
+
+func TestServeOn_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	go ServeOn("unix:"+path, "", "")(srv)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err := client.Get("http://unix/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "ok", string(body))
+}
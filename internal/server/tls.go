@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// NewTLSConfig returns a TLS configuration for a listener. When clientCAFile
+// is set, clients must present a certificate signed by one of its CAs
+// (mutual TLS).
+func NewTLSConfig(clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// Cipher suite policies for TLS 1.2 connections; TLS 1.3 suites are not
+// configurable
+const (
+	// CipherPolicyDefault uses Go's default suites
+	CipherPolicyDefault = "default"
+	// CipherPolicyModern allows only forward-secret AEAD suites
+	CipherPolicyModern = "modern"
+)
+
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// ApplyTLSPolicy sets the minimum protocol version ("1.2" or "1.3") and the
+// cipher suite policy of tlsConfig
+func ApplyTLSPolicy(tlsConfig *tls.Config, minVersion, cipherPolicy string) error {
+	switch minVersion {
+	case "", "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("unsupported minimum TLS version %q, expected 1.2 or 1.3", minVersion)
+	}
+
+	switch cipherPolicy {
+	case "", CipherPolicyDefault:
+		tlsConfig.CipherSuites = nil
+	case CipherPolicyModern:
+		tlsConfig.CipherSuites = modernCipherSuites
+	default:
+		return fmt.Errorf("unknown cipher policy %q, expected %s or %s", cipherPolicy, CipherPolicyDefault, CipherPolicyModern)
+	}
+	return nil
+}
+
+// NewACMEManager obtains and renews certificates for domains from Let's
+// Encrypt, caching them in cacheDir so restarts don't request new ones
+func NewACMEManager(domains []string, cacheDir, email string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+}
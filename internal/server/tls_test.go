@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Admin CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestNewTLSConfig_WithoutClientCA(t *testing.T) {
+	tlsConfig, err := NewTLSConfig("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+}
+
+func TestNewTLSConfig_InvalidClientCA(t *testing.T) {
+	_, err := NewTLSConfig(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	_, err = NewTLSConfig(writeFile(t, "empty.pem", []byte("not a certificate")))
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig_RequiresClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	tlsConfig, err := NewTLSConfig(writeFile(t, "ca.pem", ca.pem))
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tlsConfig.Certificates = []tls.Certificate{ca.issue(t, 2, x509.ExtKeyUsageServerAuth)}
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	request := func(certificates []tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: certificates,
+		}}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.Error(t, request(nil))
+	assert.Error(t, request([]tls.Certificate{newTestCA(t).issue(t, 3, x509.ExtKeyUsageClientAuth)}))
+	assert.NoError(t, request([]tls.Certificate{ca.issue(t, 4, x509.ExtKeyUsageClientAuth)}))
+}
+
+func TestApplyTLSPolicy(t *testing.T) {
+	tlsConfig, err := NewTLSConfig("")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ApplyTLSPolicy(tlsConfig, "1.3", CipherPolicyModern))
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, modernCipherSuites, tlsConfig.CipherSuites)
+
+	assert.NoError(t, ApplyTLSPolicy(tlsConfig, "", ""))
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Nil(t, tlsConfig.CipherSuites)
+
+	assert.Error(t, ApplyTLSPolicy(tlsConfig, "1.0", ""))
+	assert.Error(t, ApplyTLSPolicy(tlsConfig, "1.2", "legacy"))
+}
+
+func TestApplyTLSPolicy_ModernRejectsCBC(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+
+	tlsConfig, err := NewTLSConfig("")
+	assert.NoError(t, err)
+	assert.NoError(t, ApplyTLSPolicy(tlsConfig, "1.2", CipherPolicyModern))
+	tlsConfig.Certificates = []tls.Certificate{serverCert}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(suite uint16) error {
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{suite},
+		})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, dial(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256))
+	assert.Error(t, dial(tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA))
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// ErrInvalidAccessToken is returned for access tokens that are malformed,
+// badly signed or expired
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// accessTokenHeader is the JOSE header of every access token
+var accessTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// APIKeyIDValidator validates keys by ID, see APIKeyService.ValidateAPIKeyID
+type APIKeyIDValidator interface {
+	ValidateAPIKeyID(ctx context.Context, id string) (*database.APIKey, error)
+}
+
+// AccessToken is a token issued for an API key
+type AccessToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// TokenScope narrows what an access token may do compared to its key. The
+// key's limits always apply as well, so a token can only be scoped down.
+type TokenScope struct {
+	// Limit of the token's own requests (0 = only the key's limits), per
+	// window in seconds (0 = the key's window)
+	RateLimitRequests      int
+	RateLimitWindowSeconds int
+
+	// How long the token is valid, at most and by default the service's TTL
+	TTL time.Duration
+}
+
+// accessTokenClaims are the claims of an access token: the ID of its key,
+// its own ID, when it was issued and expires, and the limit it was scoped
+// down to
+type accessTokenClaims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	RateLimitRequests      int `json:"rate_limit,omitempty"`
+	RateLimitWindowSeconds int `json:"rate_window,omitempty"`
+}
+
+// AccessTokenService issues short-lived access tokens that stand in for an
+// API key, as in the OAuth2 client credentials grant. Tokens are JWTs signed
+// with HS256 that name their key. Validating one checks its signature and
+// expiry and then looks the key up by ID, so a token stops working before it
+// expires when its key is deactivated; its requests count against the key's
+// limits.
+type AccessTokenService struct {
+	keys   APIKeyIDValidator
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewAccessTokenService signs tokens with secret and lets them authenticate
+// as the key they were issued for, looked up in keys, for ttl
+func NewAccessTokenService(keys APIKeyIDValidator, secret []byte, ttl time.Duration) *AccessTokenService {
+	return &AccessTokenService{keys: keys, secret: secret, ttl: ttl, now: time.Now}
+}
+
+// TTL is how long issued tokens are valid unless scoped to less
+func (s *AccessTokenService) TTL() time.Duration {
+	return s.ttl
+}
+
+// IssueToken returns a new access token for apiKey, narrowed to scope
+func (s *AccessTokenService) IssueToken(apiKey *database.APIKey, scope TokenScope) (*AccessToken, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate access token ID: %w", err)
+	}
+
+	ttl := s.ttl
+	if scope.TTL > 0 && scope.TTL < ttl {
+		ttl = scope.TTL
+	}
+	now := s.now()
+	expiresAt := now.Add(ttl)
+	payload, err := json.Marshal(accessTokenClaims{
+		Subject:                apiKey.ID,
+		ID:                     fmt.Sprintf("%x", id),
+		IssuedAt:               now.Unix(),
+		ExpiresAt:              expiresAt.Unix(),
+		RateLimitRequests:      scope.RateLimitRequests,
+		RateLimitWindowSeconds: scope.RateLimitWindowSeconds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode access token: %w", err)
+	}
+
+	signingInput := accessTokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return &AccessToken{
+		Token:     signingInput + "." + s.sign(signingInput),
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0),
+	}, nil
+}
+
+// ValidateToken checks token's signature and expiry and returns the key it
+// was issued for, as ValidateAPIKey would, along with the token's ID and
+// scope
+func (s *AccessTokenService) ValidateToken(ctx context.Context, token string) (*database.APIKey, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != accessTokenHeader {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidAccessToken)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0]+"."+parts[1]))) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidAccessToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidAccessToken)
+	}
+	var claims accessTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidAccessToken)
+	}
+	if !s.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidAccessToken)
+	}
+
+	apiKey, err := s.keys.ValidateAPIKeyID(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *apiKey
+	scoped.TokenID = claims.ID
+	scoped.TokenLimitRequests = claims.RateLimitRequests
+	scoped.TokenLimitWindowSeconds = claims.RateLimitWindowSeconds
+	return &scoped, nil
+}
+
+func (s *AccessTokenService) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
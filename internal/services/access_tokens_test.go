@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessTokenService(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeyService(repository.NewMemoryAPIKeyRepository())
+	tokens := NewAccessTokenService(keys, []byte("0123456789abcdef0123456789abcdef"), 15*time.Minute)
+
+	apiKey, err := keys.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Mobile app", RateLimitRequests: 10, RateLimitWindowSeconds: 60})
+	require.NoError(t, err)
+	record, err := keys.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+
+	issued, err := tokens.IssueToken(record, TokenScope{})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), issued.ExpiresAt, time.Second)
+
+	validated, err := tokens.ValidateToken(ctx, issued.Token)
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, validated.ID)
+	assert.Equal(t, 10, validated.RateLimitRequests)
+	assert.NotEmpty(t, validated.TokenID)
+	assert.Zero(t, validated.TokenLimitRequests)
+
+	// Tokens signed with another secret, or altered, are rejected
+	other := NewAccessTokenService(keys, []byte("another secret of at least 32 bytes"), 15*time.Minute)
+	_, err = other.ValidateToken(ctx, issued.Token)
+	assert.ErrorIs(t, err, ErrInvalidAccessToken)
+	parts := strings.Split(issued.Token, ".")
+	_, err = tokens.ValidateToken(ctx, parts[0]+"."+parts[1]+"x."+parts[2])
+	assert.ErrorIs(t, err, ErrInvalidAccessToken)
+
+	tokens.now = func() time.Time { return time.Now().Add(16 * time.Minute) }
+	_, err = tokens.ValidateToken(ctx, issued.Token)
+	assert.ErrorIs(t, err, ErrInvalidAccessToken)
+	tokens.now = time.Now
+
+	// Scoped tokens carry their own limit and may expire sooner, never later
+	scoped, err := tokens.IssueToken(record, TokenScope{RateLimitRequests: 5, RateLimitWindowSeconds: 30, TTL: time.Minute})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), scoped.ExpiresAt, time.Second)
+	validated, err = tokens.ValidateToken(ctx, scoped.Token)
+	require.NoError(t, err)
+	assert.Equal(t, 5, validated.TokenLimitRequests)
+	assert.Equal(t, 30, validated.TokenLimitWindowSeconds)
+	assert.Equal(t, 10, validated.RateLimitRequests)
+	long, err := tokens.IssueToken(record, TokenScope{TTL: time.Hour})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), long.ExpiresAt, time.Second)
+
+	// Tokens stop working with their key
+	require.NoError(t, keys.DeleteAPIKey(ctx, record.ID))
+	_, err = tokens.ValidateToken(ctx, issued.Token)
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// Granularities of analytics time series and the length of their buckets
+var analyticsGranularities = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// rollupTables hold the usage rollups of the granularities kept by the
+// UsageRollupJob
+var rollupTables = map[string]string{
+	"hour": "usage_rollups_hourly",
+	"day":  "usage_rollups_daily",
+}
+
+// MaxAnalyticsBuckets is the longest time series a query may return
+const MaxAnalyticsBuckets = 1500
+
+// AnalyticsQuery selects the period of a key's usage to aggregate. From is
+// rounded down to the start of its bucket; buckets are aligned to UTC.
+type AnalyticsQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+// TopConsumersQuery selects the window of usage to rank keys over and how
+// many keys each ranking lists. Keys with fewer than MinRequests requests are
+// left out of the ranking by 429 rate, where a handful of refused requests
+// would otherwise top the list. With OrganizationID set, only that
+// organization's keys are ranked.
+type TopConsumersQuery struct {
+	Window         time.Duration
+	Limit          int
+	MinRequests    int64
+	OrganizationID string
+}
+
+// AnalyticsService aggregates the usage_logs written by the usage log
+// writer into time series for dashboards
+type AnalyticsService struct {
+	db      database.DBInterface
+	rollups bool
+}
+
+// AnalyticsServiceOption configures optional AnalyticsService behaviour
+type AnalyticsServiceOption func(*AnalyticsService)
+
+// WithUsageRollups reads hourly and daily time series and the top consumers
+// from the rollups kept by a UsageRollupJob rather than from usage_logs, so
+// they lag the logs by up to the job's interval. Defaults to aggregating the
+// logs.
+func WithUsageRollups() AnalyticsServiceOption {
+	return func(s *AnalyticsService) {
+		s.rollups = true
+	}
+}
+
+func NewAnalyticsService(db database.DBInterface, opts ...AnalyticsServiceOption) *AnalyticsService {
+	s := &AnalyticsService{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ValidateAnalyticsQuery checks the granularity and that the period is
+// ordered and no longer than MaxAnalyticsBuckets buckets
+func ValidateAnalyticsQuery(query AnalyticsQuery) error {
+	step, ok := analyticsGranularities[query.Granularity]
+	if !ok {
+		return fmt.Errorf("granularity must be minute, hour or day, got %q", query.Granularity)
+	}
+	if !query.To.After(query.From) {
+		return fmt.Errorf("from must be before to")
+	}
+	if buckets := query.To.Sub(query.From.Truncate(step)) / step; buckets > MaxAnalyticsBuckets {
+		return fmt.Errorf("the period spans %d %ss, at most %d are allowed", buckets, query.Granularity, MaxAnalyticsBuckets)
+	}
+	return nil
+}
+
+// KeyAnalytics returns the request count, 429 count and average cost of the
+// key's requests in every bucket of the query's period
+func (s *AnalyticsService) KeyAnalytics(ctx context.Context, apiKeyID string, query AnalyticsQuery) (*database.KeyAnalytics, error) {
+	if err := ValidateAnalyticsQuery(query); err != nil {
+		return nil, err
+	}
+	step := analyticsGranularities[query.Granularity]
+	from := query.From.UTC().Truncate(step)
+	to := query.To.UTC()
+
+	dialect := database.DialectOf(s.db)
+	statement := `
+		SELECT ` + dialect.Bucket("created_at", "$3") + ` AS bucket,
+			COUNT(*),
+			SUM(CASE WHEN status_code = 429 THEN 1 ELSE 0 END),
+			SUM(cost)
+		FROM usage_logs
+		WHERE api_key_id = $1 AND created_at >= $2 AND created_at < $4
+		GROUP BY bucket
+	`
+	if table, ok := rollupTables[query.Granularity]; ok && s.rollups {
+		statement = `
+			SELECT ` + dialect.Bucket("bucket_start", "$3") + ` AS bucket,
+				SUM(requests), SUM(limited), SUM(cost)
+			FROM ` + table + `
+			WHERE api_key_id = $1 AND bucket_start >= $2 AND bucket_start < $4
+			GROUP BY bucket
+		`
+	}
+	rows, err := s.db.QueryContext(ctx, statement, apiKeyID, from, int64(step/time.Second), to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage logs: %w", err)
+	}
+	defer rows.Close()
+
+	counted := map[int64]database.UsageBucket{}
+	for rows.Next() {
+		var start, cost int64
+		var bucket database.UsageBucket
+		if err := rows.Scan(&start, &bucket.Requests, &bucket.Limited, &cost); err != nil {
+			return nil, fmt.Errorf("failed to scan usage bucket: %w", err)
+		}
+		if bucket.Requests > 0 {
+			bucket.AverageCost = float64(cost) / float64(bucket.Requests)
+		}
+		counted[start] = bucket
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage logs: %w", err)
+	}
+
+	// Every bucket is listed, so charts don't have to fill the gaps
+	analytics := &database.KeyAnalytics{
+		APIKeyID:    apiKeyID,
+		From:        from,
+		To:          to,
+		Granularity: query.Granularity,
+		Buckets:     []database.UsageBucket{},
+	}
+	for start := from; start.Before(to); start = start.Add(step) {
+		bucket := counted[start.Unix()]
+		bucket.Start = start
+		analytics.Buckets = append(analytics.Buckets, bucket)
+	}
+	return analytics, nil
+}
+
+// TopConsumers ranks the keys used within the query's window by their
+// request count, the share of their requests refused with 429 and their
+// total cost. Each ranking is aggregated, ordered and cut to query.Limit
+// keys by the database; with WithUsageRollups it sums the hourly rollups of
+// the hours the window starts in and after.
+func (s *AnalyticsService) TopConsumers(ctx context.Context, query TopConsumersQuery) (*database.TopConsumers, error) {
+	since := time.Now().UTC().Add(-query.Window)
+	top := &database.TopConsumers{Window: query.Window.String(), Since: since}
+
+	var err error
+	if top.ByRequests, err = s.rankConsumers(ctx, query, since, "", `requests DESC`); err != nil {
+		return nil, err
+	}
+	if top.ByLimitedRate, err = s.rankConsumers(ctx, query, since, `WHERE requests >= $%d AND limited > 0`, `limited * 1.0 / requests DESC, requests DESC`); err != nil {
+		return nil, err
+	}
+	if top.ByCost, err = s.rankConsumers(ctx, query, since, "", `cost DESC, requests DESC`); err != nil {
+		return nil, err
+	}
+	return top, nil
+}
+
+// rankConsumers returns the query.Limit keys used since since that come
+// first in order, ties broken by key ID so rankings are stable. filter may
+// narrow the keys by their totals, with $%d standing for query.MinRequests.
+func (s *AnalyticsService) rankConsumers(ctx context.Context, query TopConsumersQuery, since time.Time, filter, order string) ([]database.KeyConsumption, error) {
+	dialect := database.DialectOf(s.db)
+	source := `SELECT api_key_id, 1 AS requests, CASE WHEN status_code = 429 THEN 1 ELSE 0 END AS limited, cost
+		FROM usage_logs WHERE created_at >= $1`
+	if s.rollups {
+		since = since.Truncate(time.Hour)
+		source = `SELECT api_key_id, requests, limited, cost FROM usage_rollups_hourly WHERE bucket_start >= $1`
+	}
+
+	args := []interface{}{since, query.Limit}
+	var organization string
+	if query.OrganizationID != "" {
+		args = append(args, query.OrganizationID)
+		organization = fmt.Sprintf(`WHERE k.project_id IN (SELECT id FROM projects WHERE organization_id = $%d)`, len(args))
+	}
+	if filter != "" {
+		args = append(args, query.MinRequests)
+		filter = fmt.Sprintf(filter, len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, key_prefix, requests, limited, cost FROM (
+			SELECT `+dialect.Text("u.api_key_id")+` AS id, k.name, k.key_prefix,
+				SUM(u.requests) AS requests, SUM(u.limited) AS limited, SUM(u.cost) AS cost
+			FROM (`+source+`) u
+			JOIN api_keys k ON k.id = u.api_key_id
+			`+organization+`
+			GROUP BY u.api_key_id, k.name, k.key_prefix
+		) totals
+		`+filter+`
+		ORDER BY `+order+`, id
+		LIMIT $2
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage logs: %w", err)
+	}
+	defer rows.Close()
+
+	consumers := []database.KeyConsumption{}
+	for rows.Next() {
+		var consumer database.KeyConsumption
+		if err := rows.Scan(&consumer.APIKeyID, &consumer.Name, &consumer.KeyPrefix, &consumer.Requests, &consumer.Limited, &consumer.Cost); err != nil {
+			return nil, fmt.Errorf("failed to scan key consumption: %w", err)
+		}
+		consumer.LimitedRate = float64(consumer.Limited) / float64(consumer.Requests)
+		consumers = append(consumers, consumer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage logs: %w", err)
+	}
+	return consumers, nil
+}
+
+// Ensure AnalyticsService implements AnalyticsServiceInterface
+var _ AnalyticsServiceInterface = (*AnalyticsService)(nil)
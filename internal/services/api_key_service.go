@@ -1,101 +1,943 @@
 package services
 
 import (
-	"crypto/sha256"
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"math/big"
+	"net"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/repository"
+
+	"go.uber.org/zap"
 )
 
+var ErrAPIKeyNotFound = repository.ErrAPIKeyNotFound
+
+// ErrInvalidAPIKey is returned when validating a key that is unknown,
+// inactive, deleted or expired, as opposed to one that couldn't be checked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ErrKeyLimitReached is returned when creating a key would exceed
+// CreateAPIKeyParams.MaxOrganizationKeys
+var ErrKeyLimitReached = errors.New("the organization has reached its limit of active keys")
+
+// KeyPrefixLength is how many leading characters of a key are stored in
+// plain text so admins can recognise keys in list views and logs
+const KeyPrefixLength = 12
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
 type APIKeyService struct {
-	db database.DBInterface
-}
-
-func NewAPIKeyService(db database.DBInterface) *APIKeyService {
-	return &APIKeyService{db: db}
-}
-
-func (s *APIKeyService) ValidateAPIKey(apiKey string) (*database.APIKey, error) {
-	keyHash := s.hashAPIKey(apiKey)
-	
-	query := `
-		SELECT id, key_hash, name, rate_limit_requests, rate_limit_window_seconds, is_active, created_at, updated_at
-		FROM api_keys 
-		WHERE key_hash = $1 AND is_active = true
-	`
-	
-	var apiKeyRecord database.APIKey
-	err := s.db.QueryRow(query, keyHash).Scan(
-		&apiKeyRecord.ID,
-		&apiKeyRecord.KeyHash,
-		&apiKeyRecord.Name,
-		&apiKeyRecord.RateLimitRequests,
-		&apiKeyRecord.RateLimitWindowSeconds,
-		&apiKeyRecord.IsActive,
-		&apiKeyRecord.CreatedAt,
-		&apiKeyRecord.UpdatedAt,
-	)
-	
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("invalid API key")
+	keys      repository.APIKeyRepository
+	hashing   *KeyHashing
+	logger    *zap.Logger
+	retry     database.RetryPolicy
+	publisher events.Publisher
+
+	cache       *KeyCache
+	invalidator KeyInvalidator
+}
+
+// APIKeyServiceOption configures optional APIKeyService behaviour
+type APIKeyServiceOption func(*APIKeyService)
+
+// WithKeyHashing sets how keys are hashed at rest; see NewKeyHashing.
+// Defaults to plain SHA-256.
+func WithKeyHashing(hashing *KeyHashing) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.hashing = hashing
+	}
+}
+
+// WithLogger sets the logger for errors outside of a request's scope.
+// Defaults to the global zap logger.
+func WithLogger(logger *zap.Logger) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.logger = logger
+	}
+}
+
+// WithRetry retries reads and idempotent updates that fail with a transient
+// database error, such as a dropped connection. Defaults to no retries.
+func WithRetry(policy database.RetryPolicy) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.retry = policy
+	}
+}
+
+// WithEventPublisher publishes an event whenever a key is created, updated,
+// rotated, deactivated or purged. Defaults to no events.
+func WithEventPublisher(publisher events.Publisher) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.publisher = publisher
+	}
+}
+
+// WithKeyCache answers ValidateAPIKey and ValidateAPIKeyID from cache when
+// it holds the key, and invalidates the cached key when the service changes
+// it. Defaults to no cache.
+func WithKeyCache(cache *KeyCache) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.cache = cache
+	}
+}
+
+// WithKeyInvalidator sends the invalidations of changed keys to invalidator
+// instead of the cache, e.g. a KeyInvalidationBroadcaster that passes them
+// on to other instances
+func WithKeyInvalidator(invalidator KeyInvalidator) APIKeyServiceOption {
+	return func(s *APIKeyService) {
+		s.invalidator = invalidator
+	}
+}
+
+// NewAPIKeyService stores keys in keys, e.g. a repository.SQLAPIKeyRepository
+func NewAPIKeyService(keys repository.APIKeyRepository, opts ...APIKeyServiceOption) *APIKeyService {
+	s := &APIKeyService{keys: keys, hashing: DefaultKeyHashing(), logger: zap.L()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.invalidator == nil && s.cache != nil {
+		s.invalidator = s.cache
+	}
+	return s
+}
+
+// CreateAPIKeyParams holds the attributes of a new API key. Zero rate limits
+// mean the key inherits the limits of its plan (or the service defaults).
+type CreateAPIKeyParams struct {
+	Name                   string
+	RateLimitRequests      int
+	RateLimitWindowSeconds int
+	PlanID                 string
+
+	// Optional per-end-user sublimit; 0 disables it
+	EndUserLimitRequests      int
+	EndUserLimitWindowSeconds int
+
+	// Optional expiry; nil means the key never expires
+	ExpiresAt *time.Time
+
+	// Optional source network allowlist; see NormalizeCIDRs
+	AllowedCIDRs []string
+
+	// Optional browser origin allowlist; see NormalizeOrigins
+	AllowedOrigins []string
+
+	// When set, requests must be HMAC-signed with this secret; see
+	// GenerateSigningSecret
+	SigningSecret string
+
+	// Optional parent key ID. Sub-keys inherit the parent's limits, plan and
+	// quota, so the limit fields above are ignored for them.
+	ParentID string
+
+	// Optional contact details for the team owning the key
+	OwnerName  string
+	OwnerEmail string
+
+	// Optional project the key belongs to; sub-keys belong to their
+	// parent's
+	ProjectID string
+
+	// Optional limit on the active keys of organization OrganizationID,
+	// counting the new one; 0 means no limit. The count and the insert
+	// happen in one transaction under a lock on the organization, so
+	// concurrent creations can't both take the last slot.
+	OrganizationID      string
+	MaxOrganizationKeys int
+}
+
+// ErrInvalidListQuery is returned by ListAPIKeys for filters, sorts and
+// positions on fields the keys can't be listed by
+var ErrInvalidListQuery = repository.ErrInvalidListQuery
+
+// Conditions, orders and positions of paged key listings; see the
+// repository types
+type (
+	FieldFilter = repository.FieldFilter
+	SortField   = repository.SortField
+	ListOrder   = repository.ListOrder
+	KeyPosition = repository.KeyPosition
+)
+
+// APIKeyFilter narrows ListAPIKeys; zero fields match every key
+type APIKeyFilter struct {
+	// Matches the owner name or email, case-insensitively
+	Owner string
+
+	// Matches the sub-keys of the key with this ID
+	ParentID string
+
+	// Matches the keys of this project, or of every project of this
+	// organization
+	ProjectID      string
+	OrganizationID string
+
+	// Lists deleted keys as well
+	IncludeDeleted bool
+
+	// Pages through the keys matching Filters in Sort order, Limit at a
+	// time, starting after the last key of the previous page
+	Filters []FieldFilter
+	Sort    ListOrder
+	After   *KeyPosition
+	Limit   int
+}
+
+// RotatedAPIKey is the result of a key rotation. The previous secret keeps
+// working until PreviousKeyExpiresAt.
+type RotatedAPIKey struct {
+	ID                   string
+	APIKey               string
+	KeyPrefix            string
+	PreviousKeyExpiresAt time.Time
+}
+
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	// Keys in the current format carry a checksum, so typos and random
+	// guesses are rejected without a database round trip
+	if looksLikeChecksummedKey(apiKey) && !VerifyAPIKeyChecksum(apiKey) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	candidates := s.hashing.Candidates(apiKey)
+
+	// Cached under the hash of the current version, which every key gets on
+	// its first validation
+	lookup := candidates[s.hashing.Version()]
+	var generation uint64
+	if s.cache != nil {
+		if cached := s.cache.Get(lookup); cached != nil {
+			return cached, nil
+		}
+		generation = s.cache.Generation()
+	}
+
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.FindValid(ctx, sortedHashes(candidates))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to validate API key: %w", err)
+	}
+	apiKeyRecord.RequireSignature = apiKeyRecord.SigningSecret != ""
+
+	// Keys stored under an older hash version are upgraded on first use.
+	// Matches on the previous (rotated) secret are left alone; that hash
+	// disappears when the grace period ends.
+	if apiKeyRecord.HashVersion != s.hashing.Version() && candidates[apiKeyRecord.HashVersion] == apiKeyRecord.KeyHash {
+		s.rehashAPIKey(ctx, apiKeyRecord, apiKey)
+	}
+
+	// A rotated key's previous secret isn't cached, so it stops working at
+	// the end of its grace period
+	if s.cache != nil && candidates[apiKeyRecord.HashVersion] == apiKeyRecord.KeyHash {
+		s.cache.Put(lookup, apiKeyRecord, generation)
+	}
+
+	return apiKeyRecord, nil
+}
+
+// ValidateAPIKeyID is ValidateAPIKey for the key with ID id, for credentials
+// that stand in for the key, such as access tokens issued for it
+func (s *APIKeyService) ValidateAPIKeyID(ctx context.Context, id string) (*database.APIKey, error) {
+	lookup := "id:" + id
+	var generation uint64
+	if s.cache != nil {
+		if cached := s.cache.Get(lookup); cached != nil {
+			return cached, nil
+		}
+		generation = s.cache.Generation()
+	}
+
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.FindValidByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
 		}
 		return nil, fmt.Errorf("failed to validate API key: %w", err)
 	}
-	
-	return &apiKeyRecord, nil
+	apiKeyRecord.RequireSignature = apiKeyRecord.SigningSecret != ""
+	if s.cache != nil {
+		s.cache.Put(lookup, apiKeyRecord, generation)
+	}
+
+	return apiKeyRecord, nil
+}
+
+// rehashAPIKey stores apiKey's hash under the current version. Failures are
+// logged and retried on the next successful validation.
+func (s *APIKeyService) rehashAPIKey(ctx context.Context, apiKeyRecord *database.APIKey, apiKey string) {
+	newKeyHash := s.hashing.Hash(apiKey)
+
+	if err := s.keys.UpdateKeyHash(ctx, apiKeyRecord.ID, apiKeyRecord.KeyHash, newKeyHash, s.hashing.Version()); err != nil {
+		s.logger.Error("Failed to upgrade API key hash", zap.String("key_prefix", apiKeyRecord.KeyPrefix), zap.Error(err))
+		return
+	}
+	apiKeyRecord.KeyHash = newKeyHash
+	apiKeyRecord.HashVersion = s.hashing.Version()
 }
 
-func (s *APIKeyService) CreateAPIKey(name string, rateLimitRequests int, rateLimitWindowSeconds int) (string, error) {
-	// Generate a new API key
-	apiKey := s.generateAPIKey()
-	keyHash := s.hashAPIKey(apiKey)
-	
-	query := `
-		INSERT INTO api_keys (key_hash, name, rate_limit_requests, rate_limit_window_seconds)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id
-	`
-	
-	var id string
-	err := s.db.QueryRow(query, keyHash, name, rateLimitRequests, rateLimitWindowSeconds).Scan(&id)
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, params CreateAPIKeyParams) (string, error) {
+	if params.MaxOrganizationKeys > 0 {
+		return s.createLimitedAPIKey(ctx, params)
+	}
+
+	apiKey, event, err := s.createAPIKey(ctx, s.keys, params)
 	if err != nil {
 		return "", fmt.Errorf("failed to create API key: %w", err)
 	}
-	
+	s.publish(ctx, event)
+
+	return apiKey, nil
+}
+
+// createLimitedAPIKey creates a key unless its organization already has
+// params.MaxOrganizationKeys active keys
+func (s *APIKeyService) createLimitedAPIKey(ctx context.Context, params CreateAPIKeyParams) (string, error) {
+	var apiKey string
+	var event events.Event
+	err := s.keys.InTx(ctx, func(keys repository.APIKeyRepository) error {
+		if err := keys.LockOrganization(ctx, params.OrganizationID); err != nil {
+			return err
+		}
+		existing, err := keys.List(ctx, repository.APIKeyQuery{OrganizationID: params.OrganizationID})
+		if err != nil {
+			return err
+		}
+		active := 0
+		for _, key := range existing {
+			if key.IsActive {
+				active++
+			}
+		}
+		if active >= params.MaxOrganizationKeys {
+			return ErrKeyLimitReached
+		}
+
+		apiKey, event, err = s.createAPIKey(ctx, keys, params)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	s.publish(ctx, event)
+
 	return apiKey, nil
 }
 
-func (s *APIKeyService) DeactivateAPIKey(apiKey string) error {
-	keyHash := s.hashAPIKey(apiKey)
-	
-	query := `UPDATE api_keys SET is_active = false, updated_at = NOW() WHERE key_hash = $1`
-	
-	result, err := s.db.Exec(query, keyHash)
+// CreateAPIKeys creates a key for each of params and returns them in the
+// same order. The keys are created in one transaction: if any of them
+// fails, none is created.
+func (s *APIKeyService) CreateAPIKeys(ctx context.Context, params []CreateAPIKeyParams) ([]string, error) {
+	apiKeys := make([]string, len(params))
+	created := make([]events.Event, len(params))
+	err := s.keys.InTx(ctx, func(keys repository.APIKeyRepository) error {
+		for i := range params {
+			apiKey, event, err := s.createAPIKey(ctx, keys, params[i])
+			if err != nil {
+				return fmt.Errorf("key %d: %w", i, err)
+			}
+			apiKeys[i], created[i] = apiKey, event
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API keys: %w", err)
+	}
+	for _, event := range created {
+		s.publish(ctx, event)
+	}
+
+	return apiKeys, nil
+}
+
+// createAPIKey generates a key and stores it in keys, returning the event
+// to publish once it is committed
+func (s *APIKeyService) createAPIKey(ctx context.Context, keys repository.APIKeyRepository, params CreateAPIKeyParams) (string, events.Event, error) {
+	apiKey, err := s.generateAPIKey()
+	if err != nil {
+		return "", events.Event{}, err
+	}
+
+	id, err := keys.Create(ctx, &database.APIKey{
+		KeyHash:                   s.hashAPIKey(apiKey),
+		KeyPrefix:                 KeyPrefix(apiKey),
+		Name:                      params.Name,
+		RateLimitRequests:         params.RateLimitRequests,
+		RateLimitWindowSeconds:    params.RateLimitWindowSeconds,
+		PlanID:                    params.PlanID,
+		EndUserLimitRequests:      params.EndUserLimitRequests,
+		EndUserLimitWindowSeconds: params.EndUserLimitWindowSeconds,
+		ExpiresAt:                 params.ExpiresAt,
+		AllowedCIDRs:              params.AllowedCIDRs,
+		AllowedOrigins:            params.AllowedOrigins,
+		SigningSecret:             params.SigningSecret,
+		HashVersion:               s.hashing.Version(),
+		ParentID:                  params.ParentID,
+		OwnerName:                 params.OwnerName,
+		OwnerEmail:                params.OwnerEmail,
+		ProjectID:                 params.ProjectID,
+	})
+	if err != nil {
+		return "", events.Event{}, err
+	}
+
+	data := map[string]interface{}{"key_prefix": KeyPrefix(apiKey), "name": params.Name}
+	if params.ParentID != "" {
+		data["parent_id"] = params.ParentID
+	}
+	if params.ProjectID != "" {
+		data["project_id"] = params.ProjectID
+	}
+	return apiKey, keyEvent(events.APIKeyCreated, id, data), nil
+}
+
+// ListAPIKeys returns the keys matching filter, in filter.Sort order and
+// then newest first
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*database.APIKey, error) {
+	return s.listAPIKeys(ctx, repository.APIKeyQuery{
+		Owner:          filter.Owner,
+		ParentID:       filter.ParentID,
+		ProjectID:      filter.ProjectID,
+		OrganizationID: filter.OrganizationID,
+		IncludeDeleted: filter.IncludeDeleted,
+		Filters:        filter.Filters,
+		Sort:           filter.Sort,
+		After:          filter.After,
+		Limit:          filter.Limit,
+	})
+}
+
+// ListSubKeys returns the sub-keys of the key with ID parentID, newest first
+func (s *APIKeyService) ListSubKeys(ctx context.Context, parentID string) ([]*database.APIKey, error) {
+	return s.listAPIKeys(ctx, repository.APIKeyQuery{ParentID: parentID})
+}
+
+func (s *APIKeyService) listAPIKeys(ctx context.Context, query repository.APIKeyQuery) ([]*database.APIKey, error) {
+	var apiKeys []*database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeys, err = s.keys.List(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return apiKeys, nil
+}
+
+// GetAPIKey returns a single key, referenced by ID or by the API key itself
+func (s *APIKeyService) GetAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.Get(ctx, s.keyRef(apiKey))
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to get API key")
+	}
+
+	return apiKeyRecord, nil
+}
+
+// RenameAPIKey replaces a key's name
+func (s *APIKeyService) RenameAPIKey(ctx context.Context, apiKey string, name string) (*database.APIKey, error) {
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.Rename(ctx, s.keyRef(apiKey), name)
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to rename API key")
+	}
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyUpdated, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// UpdateAPIKeyOwner replaces a key's owner details; empty values clear them
+func (s *APIKeyService) UpdateAPIKeyOwner(ctx context.Context, apiKey string, ownerName string, ownerEmail string) (*database.APIKey, error) {
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.UpdateOwner(ctx, s.keyRef(apiKey), ownerName, ownerEmail)
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to update API key owner")
+	}
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyUpdated, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// UpdateAPIKeyAlertThresholds replaces the percentages of its rate limit or
+// quota at which a key is warned; none clears them
+func (s *APIKeyService) UpdateAPIKeyAlertThresholds(ctx context.Context, apiKey string, thresholds []int64) (*database.APIKey, error) {
+	thresholds, err := NormalizeAlertThresholds(thresholds)
 	if err != nil {
-		return fmt.Errorf("failed to deactivate API key: %w", err)
+		return nil, err
 	}
-	
-	rowsAffected, err := result.RowsAffected()
+
+	var apiKeyRecord *database.APIKey
+	err = s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.UpdateAlertThresholds(ctx, s.keyRef(apiKey), thresholds)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return nil, notFoundOr(err, "failed to update API key alert thresholds")
 	}
-	
-	if rowsAffected == 0 {
-		return fmt.Errorf("API key not found")
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyUpdated, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// UpdateAPIKeyRefundLimit sets how many units of its rate limit a key may
+// refund per window; 0 disallows refunds
+func (s *APIKeyService) UpdateAPIKeyRefundLimit(ctx context.Context, apiKey string, limit int) (*database.APIKey, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("invalid refund limit %d: must not be negative", limit)
 	}
-	
+
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.UpdateRefundLimit(ctx, s.keyRef(apiKey), limit)
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to update API key refund limit")
+	}
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyUpdated, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// UpdateAPIKeyLimitResponse sets the key's custom response to requests over
+// its rate limit or quota; nil falls back to its plan's, or the standard one
+func (s *APIKeyService) UpdateAPIKeyLimitResponse(ctx context.Context, apiKey string, response *database.LimitResponse) (*database.APIKey, error) {
+	response, err := NormalizeLimitResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeyRecord *database.APIKey
+	err = s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.UpdateLimitResponse(ctx, s.keyRef(apiKey), response)
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to update API key limit response")
+	}
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyUpdated, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// DeleteAPIKey soft-deletes a key: it stops working right away but keeps its
+// settings, sub-keys and usage history, so RestoreAPIKey can bring it back.
+// Keys already deleted are not found. The event is api_key.deactivated, as
+// it was before keys could be restored.
+func (s *APIKeyService) DeleteAPIKey(ctx context.Context, apiKey string) error {
+	ref := s.keyRef(apiKey)
+	err := s.withRetry(ctx, func() error {
+		return s.keys.Delete(ctx, ref)
+	})
+	if err != nil {
+		return notFoundOr(err, "failed to delete API key")
+	}
+
+	if s.publisher != nil || s.invalidator != nil {
+		// The event and the cache name the key, which the update doesn't
+		// return
+		apiKeyRecord, err := s.keys.Get(ctx, ref)
+		if err != nil {
+			s.logger.Error("Failed to look up deleted API key", zap.String("event", events.APIKeyDeactivated), zap.Error(err))
+			if s.invalidator != nil {
+				s.invalidator.InvalidateAllAPIKeys(ctx)
+			}
+			return nil
+		}
+		s.invalidate(ctx, apiKeyRecord.ID)
+		s.publish(ctx, keyEvent(events.APIKeyDeactivated, apiKeyRecord.ID, map[string]interface{}{
+			"key_prefix": apiKeyRecord.KeyPrefix,
+			"name":       apiKeyRecord.Name,
+		}))
+	}
+
 	return nil
 }
 
+// RestoreAPIKey undoes DeleteAPIKey and returns the restored key. Keys that
+// aren't deleted are not found.
+func (s *APIKeyService) RestoreAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error) {
+	var apiKeyRecord *database.APIKey
+	err := s.withRetry(ctx, func() (err error) {
+		apiKeyRecord, err = s.keys.Restore(ctx, s.keyRef(apiKey))
+		return err
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to restore API key")
+	}
+	s.invalidate(ctx, apiKeyRecord.ID)
+	s.publish(ctx, keyEvent(events.APIKeyRestored, apiKeyRecord.ID, map[string]interface{}{
+		"key_prefix": apiKeyRecord.KeyPrefix,
+		"name":       apiKeyRecord.Name,
+	}))
+
+	return apiKeyRecord, nil
+}
+
+// PurgeAPIKey permanently deletes a key and returns its ID. Limit overrides
+// and sub-keys go with it; Redis state is cleared separately by
+// RateLimitService.ClearKeyState.
+func (s *APIKeyService) PurgeAPIKey(ctx context.Context, apiKey string) (string, error) {
+	id, err := s.keys.Purge(ctx, s.keyRef(apiKey))
+	if err != nil {
+		return "", notFoundOr(err, "failed to purge API key")
+	}
+	s.invalidate(ctx, id)
+	s.publish(ctx, keyEvent(events.APIKeyPurged, id, nil))
+
+	return id, nil
+}
+
+// CreateLimitOverride grants an active API key a temporary rate limit that
+// replaces its regular limit until expiresAt.
+func (s *APIKeyService) CreateLimitOverride(ctx context.Context, apiKey string, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error) {
+	override, err := s.keys.CreateLimitOverride(ctx, s.keyRef(apiKey), rateLimitRequests, expiresAt)
+	if err != nil {
+		return nil, notFoundOr(err, "failed to create limit override")
+	}
+	s.invalidate(ctx, override.APIKeyID)
+
+	return override, nil
+}
+
+// RotateAPIKey issues a new secret for an active key. The old secret remains
+// valid for gracePeriod so clients can roll credentials without downtime.
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, apiKey string, gracePeriod time.Duration) (*RotatedAPIKey, error) {
+	newAPIKey, err := s.generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := &RotatedAPIKey{APIKey: newAPIKey, KeyPrefix: KeyPrefix(newAPIKey)}
+	result, err := s.keys.Rotate(ctx, s.keyRef(apiKey), repository.KeyRotation{
+		KeyHash:     s.hashAPIKey(newAPIKey),
+		KeyPrefix:   rotated.KeyPrefix,
+		HashVersion: s.hashing.Version(),
+		GracePeriod: gracePeriod,
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "failed to rotate API key")
+	}
+	rotated.ID = result.ID
+	rotated.PreviousKeyExpiresAt = result.PreviousKeyExpiresAt
+	s.invalidate(ctx, rotated.ID)
+	s.publish(ctx, keyEvent(events.APIKeyRotated, rotated.ID, map[string]interface{}{
+		"key_prefix":              rotated.KeyPrefix,
+		"previous_key_expires_at": rotated.PreviousKeyExpiresAt.UTC(),
+	}))
+
+	return rotated, nil
+}
+
+// invalidate drops the cached copies of key id and its sub-keys, which
+// changed
+func (s *APIKeyService) invalidate(ctx context.Context, id string) {
+	if s.invalidator != nil {
+		s.invalidator.InvalidateAPIKey(ctx, id)
+	}
+}
+
+// publish sends a lifecycle event when the service has a publisher. The
+// change has been made by then, so failures are only logged.
+func (s *APIKeyService) publish(ctx context.Context, event events.Event) {
+	if s.publisher == nil {
+		return
+	}
+	if err := s.publisher.Publish(ctx, event); err != nil {
+		s.logger.Error("Failed to publish event", zap.String("event", event.Type), zap.String("api_key_id", event.APIKeyID), zap.Error(err))
+	}
+}
+
+func keyEvent(eventType, apiKeyID string, data map[string]interface{}) events.Event {
+	return events.Event{Type: eventType, APIKeyID: apiKeyID, Timestamp: time.Now().UTC(), Data: data}
+}
+
+// withRetry runs fn under the service's retry policy. Only reads and updates
+// that can safely run twice go through it; inserts, rotations and deletes
+// fail on the first error.
+func (s *APIKeyService) withRetry(ctx context.Context, fn func() error) error {
+	return s.retry.Do(ctx, fn)
+}
+
+// keyRef identifies a key reference, which admin endpoints accept either as
+// the key's ID or as the API key itself
+func (s *APIKeyService) keyRef(apiKey string) repository.KeyRef {
+	if uuidPattern.MatchString(apiKey) {
+		return repository.KeyRef{ID: apiKey}
+	}
+	return repository.KeyRef{Hashes: sortedHashes(s.hashing.Candidates(apiKey))}
+}
+
+// sortedHashes lists the candidate hashes of a key in a stable order
+func sortedHashes(candidates map[int]string) []string {
+	hashes := make([]string, 0, len(candidates))
+	for _, hash := range candidates {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// notFoundOr passes ErrAPIKeyNotFound through and wraps any other error
+// with message
+func notFoundOr(err error, message string) error {
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
 func (s *APIKeyService) hashAPIKey(apiKey string) string {
-	hash := sha256.Sum256([]byte(apiKey))
-	return fmt.Sprintf("%x", hash)
+	return s.hashing.Hash(apiKey)
+}
+
+// API keys look like "ak_" followed by a random base62 secret and a base62
+// CRC32 checksum of that secret, similar to GitHub tokens. The fixed prefix
+// and checksum let secret scanners detect leaked keys with few false
+// positives, and let us reject malformed keys before touching the database.
+const (
+	apiKeyPrefix         = "ak_"
+	apiKeySecretLength   = 30
+	apiKeyChecksumLength = 6
+	base62Alphabet       = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+func (s *APIKeyService) generateAPIKey() (string, error) {
+	secret := make([]byte, apiKeySecretLength)
+	max := big.NewInt(int64(len(base62Alphabet)))
+	for i := range secret {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate API key: %w", err)
+		}
+		secret[i] = base62Alphabet[n.Int64()]
+	}
+
+	return apiKeyPrefix + string(secret) + apiKeyChecksum(string(secret)), nil
+}
+
+// apiKeyChecksum encodes the CRC32 of secret as fixed-width base62
+func apiKeyChecksum(secret string) string {
+	checksum := make([]byte, apiKeyChecksumLength)
+	value := crc32.ChecksumIEEE([]byte(secret))
+	for i := apiKeyChecksumLength - 1; i >= 0; i-- {
+		checksum[i] = base62Alphabet[value%62]
+		value /= 62
+	}
+	return string(checksum)
+}
+
+// looksLikeChecksummedKey reports whether apiKey has the shape of a key in
+// the current format. Keys issued before checksums were introduced do not.
+func looksLikeChecksummedKey(apiKey string) bool {
+	if len(apiKey) != len(apiKeyPrefix)+apiKeySecretLength+apiKeyChecksumLength || !strings.HasPrefix(apiKey, apiKeyPrefix) {
+		return false
+	}
+	for _, r := range apiKey[len(apiKeyPrefix):] {
+		if !strings.ContainsRune(base62Alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateSigningSecret returns a random secret for HMAC request signing
+func GenerateSigningSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	return fmt.Sprintf("ss_%x", secret), nil
+}
+
+// VerifyAPIKeyChecksum reports whether apiKey is a well-formed key whose
+// checksum matches its secret. It needs no database access, so it is suitable
+// for secret scanners.
+func VerifyAPIKeyChecksum(apiKey string) bool {
+	if !looksLikeChecksummedKey(apiKey) {
+		return false
+	}
+	body := apiKey[len(apiKeyPrefix):]
+	secret, checksum := body[:apiKeySecretLength], body[apiKeySecretLength:]
+	return apiKeyChecksum(secret) == checksum
+}
+
+// KeyPrefix returns the non-secret leading characters of an API key
+func KeyPrefix(apiKey string) string {
+	if len(apiKey) <= KeyPrefixLength {
+		return apiKey
+	}
+	return apiKey[:KeyPrefixLength]
+}
+
+// NormalizeCIDRs validates an allowlist and returns it in canonical CIDR
+// form. Bare IP addresses are accepted as single-host networks.
+func NormalizeCIDRs(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR %q", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", entry)
+		}
+		normalized = append(normalized, network.String())
+	}
+
+	return normalized, nil
 }
 
-func (s *APIKeyService) generateAPIKey() string {
-	// Generate a UUID-based API key
-	return fmt.Sprintf("ak_%d_%x", time.Now().Unix(), time.Now().UnixNano())
+// NormalizeAlertThresholds validates alert thresholds, percentages from 1 to
+// 100, and returns them ascending without duplicates
+func NormalizeAlertThresholds(thresholds []int64) ([]int64, error) {
+	if len(thresholds) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]int64, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if threshold < 1 || threshold > 100 {
+			return nil, fmt.Errorf("invalid alert threshold %d: must be a percentage from 1 to 100", threshold)
+		}
+		normalized = append(normalized, threshold)
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i] < normalized[j] })
+
+	unique := normalized[:1]
+	for _, threshold := range normalized[1:] {
+		if threshold != unique[len(unique)-1] {
+			unique = append(unique, threshold)
+		}
+	}
+	return unique, nil
+}
+
+// Bounds on custom limit responses, which are loaded with every key
+const (
+	maxLimitResponseBody    = 4096
+	maxLimitResponseHeaders = 10
+)
+
+// headerNameChars are the characters allowed in HTTP header names
+const headerNameChars = "!#$%&'*+-.^_`|~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// NormalizeLimitResponse validates a custom limit response, returning nil
+// for one without a body or headers. Header names must be valid HTTP header
+// names and values may not contain line breaks.
+func NormalizeLimitResponse(response *database.LimitResponse) (*database.LimitResponse, error) {
+	if response == nil || (response.Body == "" && len(response.Headers) == 0) {
+		return nil, nil
+	}
+	if len(response.Body) > maxLimitResponseBody {
+		return nil, fmt.Errorf("invalid limit response: body is longer than %d bytes", maxLimitResponseBody)
+	}
+	if len(response.Headers) > maxLimitResponseHeaders {
+		return nil, fmt.Errorf("invalid limit response: more than %d headers", maxLimitResponseHeaders)
+	}
+
+	headers := make(map[string]string, len(response.Headers))
+	for name, value := range response.Headers {
+		if name == "" || strings.Trim(name, headerNameChars) != "" {
+			return nil, fmt.Errorf("invalid limit response header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid value for limit response header %s", name)
+		}
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if canonical == "Content-Length" {
+			return nil, fmt.Errorf("invalid limit response header %s: it is set from the body", canonical)
+		}
+		headers[canonical] = value
+	}
+	if len(headers) == 0 {
+		headers = nil
+	}
+	return &database.LimitResponse{Body: response.Body, Headers: headers}, nil
+}
+
+// NormalizeOrigins validates an origin allowlist and returns each entry as a
+// lower-case "scheme://host[:port]". A leading "*." in the host matches any
+// subdomain, e.g. "https://*.example.com".
+func NormalizeOrigins(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		origin, err := url.Parse(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(entry), "/")))
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" || origin.Path != "" || origin.RawQuery != "" {
+			return nil, fmt.Errorf("invalid origin %q", entry)
+		}
+		normalized = append(normalized, origin.Scheme+"://"+origin.Host)
+	}
+
+	return normalized, nil
 }
@@ -1,22 +1,33 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"strings"
 	"testing"
 	"time"
 
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+// apiKeyColumns mirrors the column list selected by ValidateAPIKey
+var apiKeyColumns = []string{"id", "key_hash", "key_prefix", "name", "rate_limit_requests", "rate_limit_window_seconds", "is_active", "created_at", "updated_at", "plan_id", "quota_requests", "quota_period_seconds", "burst_requests", "override_requests", "override_expires_at", "end_user_limit_requests", "end_user_limit_window_seconds", "expires_at", "allowed_cidrs", "allowed_origins", "signing_secret", "parent_id", "hash_version", "alert_thresholds", "refund_limit", "limit_response", "project_id", "organization_id", "organization_rate_limit_requests", "organization_rate_limit_window_seconds", "parent_expires_at"}
+
+// adminAPIKeyColumns mirrors apiKeyAdminColumns
+var adminAPIKeyColumns = []string{"id", "key_prefix", "name", "rate_limit_requests", "rate_limit_window_seconds", "is_active", "created_at", "updated_at", "plan_id", "end_user_limit_requests", "end_user_limit_window_seconds", "expires_at", "allowed_cidrs", "allowed_origins", "last_used_at", "require_signature", "parent_id", "owner_name", "owner_email", "alert_thresholds", "refund_limit", "limit_response", "project_id", "organization_id", "deleted_at"}
+
 // Helper function to create test API key data
 
 func createTestAPIKeyForAPIKeyService() *database.APIKey {
 	return &database.APIKey{
 		ID:                     "test-id-123",
 		KeyHash:                "test-hash-abc123",
+		KeyPrefix:              "ak_170000000",
 		Name:                   "Test API Key",
 		RateLimitRequests:      100,
 		RateLimitWindowSeconds: 3600,
@@ -33,7 +44,7 @@ func TestAPIKeyService_ValidateAPIKey_Success(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Create test data
 	testAPIKey := "ak_1234567890_abcdef"
@@ -41,20 +52,21 @@ func TestAPIKeyService_ValidateAPIKey_Success(t *testing.T) {
 	expectedHash := service.hashAPIKey(testAPIKey)
 
 	// Setup mock expectations
-	rows := sqlmock.NewRows([]string{"id", "key_hash", "name", "rate_limit_requests", "rate_limit_window_seconds", "is_active", "created_at", "updated_at"}).
-		AddRow(expectedAPIKey.ID, expectedAPIKey.KeyHash, expectedAPIKey.Name, expectedAPIKey.RateLimitRequests, expectedAPIKey.RateLimitWindowSeconds, expectedAPIKey.IsActive, expectedAPIKey.CreatedAt, expectedAPIKey.UpdatedAt)
+	rows := sqlmock.NewRows(apiKeyColumns).
+		AddRow(expectedAPIKey.ID, expectedAPIKey.KeyHash, expectedAPIKey.KeyPrefix, expectedAPIKey.Name, expectedAPIKey.RateLimitRequests, expectedAPIKey.RateLimitWindowSeconds, expectedAPIKey.IsActive, expectedAPIKey.CreatedAt, expectedAPIKey.UpdatedAt, "", 0, 0, 0, 0, nil, 0, 0, nil, nil, nil, "", "", 1, nil, 0, nil, "", "", 0, 0, nil)
 
-	mock.ExpectQuery(`SELECT id, key_hash, name, rate_limit_requests, rate_limit_window_seconds, is_active, created_at, updated_at`).
+	mock.ExpectQuery(`SELECT k.id, k.key_hash, k.key_prefix, k.name`).
 		WithArgs(expectedHash).
 		WillReturnRows(rows)
 
 	// Call the method
-	result, err := service.ValidateAPIKey(testAPIKey)
+	result, err := service.ValidateAPIKey(context.Background(), testAPIKey)
 
 	// Assertions
 	assert.NoError(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, expectedAPIKey.ID, result.ID)
+	assert.Equal(t, expectedAPIKey.KeyPrefix, result.KeyPrefix)
 	assert.Equal(t, expectedAPIKey.Name, result.Name)
 	assert.Equal(t, expectedAPIKey.RateLimitRequests, result.RateLimitRequests)
 	assert.Equal(t, expectedAPIKey.RateLimitWindowSeconds, result.RateLimitWindowSeconds)
@@ -71,19 +83,19 @@ func TestAPIKeyService_ValidateAPIKey_NotFound(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Create test data
 	testAPIKey := "invalid-key"
 	expectedHash := service.hashAPIKey(testAPIKey)
 
 	// Setup mock expectations - return sql.ErrNoRows
-	mock.ExpectQuery(`SELECT id, key_hash, name, rate_limit_requests, rate_limit_window_seconds, is_active, created_at, updated_at`).
+	mock.ExpectQuery(`SELECT k.id, k.key_hash, k.key_prefix, k.name`).
 		WithArgs(expectedHash).
 		WillReturnError(sql.ErrNoRows)
 
 	// Call the method
-	result, err := service.ValidateAPIKey(testAPIKey)
+	result, err := service.ValidateAPIKey(context.Background(), testAPIKey)
 
 	// Assertions
 	assert.Error(t, err)
@@ -94,6 +106,45 @@ func TestAPIKeyService_ValidateAPIKey_NotFound(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAPIKeyService_ValidateAPIKey_RejectsExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	// Expired keys are filtered out by the query itself
+	mock.ExpectQuery(`k.expires_at IS NULL OR k.expires_at > NOW\(\)`).
+		WithArgs(service.hashAPIKey("ak_expired")).
+		WillReturnError(sql.ErrNoRows)
+
+	result, err := service.ValidateAPIKey(context.Background(), "ak_expired")
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_CreateAPIKey_WithExpiry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	rows := sqlmock.NewRows([]string{"id"}).AddRow("test-id-123")
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Expiring Key", 100, 3600, nil, 0, 0, expiresAt, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, HashVersionSHA256, nil, nil, nil, nil).
+		WillReturnRows(rows)
+
+	apiKey, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Expiring Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600, ExpiresAt: &expiresAt})
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, apiKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAPIKeyService_ValidateAPIKey_DatabaseError(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
@@ -101,19 +152,19 @@ func TestAPIKeyService_ValidateAPIKey_DatabaseError(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Create test data
 	testAPIKey := "test-key"
 	expectedHash := service.hashAPIKey(testAPIKey)
 
 	// Setup mock expectations - return database error
-	mock.ExpectQuery(`SELECT id, key_hash, name, rate_limit_requests, rate_limit_window_seconds, is_active, created_at, updated_at`).
+	mock.ExpectQuery(`SELECT k.id, k.key_hash, k.key_prefix, k.name`).
 		WithArgs(expectedHash).
 		WillReturnError(assert.AnError)
 
 	// Call the method
-	result, err := service.ValidateAPIKey(testAPIKey)
+	result, err := service.ValidateAPIKey(context.Background(), testAPIKey)
 
 	// Assertions
 	assert.Error(t, err)
@@ -124,6 +175,23 @@ func TestAPIKeyService_ValidateAPIKey_DatabaseError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAPIKeyService_ValidateAPIKey_CancelledContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), WithRetry(database.RetryPolicy{Attempts: 3}))
+
+	// A request that has gone away doesn't reach the database or retry
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := service.ValidateAPIKey(ctx, "test-key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAPIKeyService_CreateAPIKey_Success(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
@@ -131,17 +199,17 @@ func TestAPIKeyService_CreateAPIKey_Success(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations
 	rows := sqlmock.NewRows([]string{"id"}).AddRow("new-id-123")
 
 	mock.ExpectQuery(`INSERT INTO api_keys`).
-		WithArgs(sqlmock.AnyArg(), "Test API Key", 100, 3600).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Test API Key", 100, 3600, nil, 0, 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, HashVersionSHA256, nil, nil, nil, nil).
 		WillReturnRows(rows)
 
 	// Call the method
-	apiKey, err := service.CreateAPIKey("Test API Key", 100, 3600)
+	apiKey, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Test API Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600})
 
 	// Assertions
 	assert.NoError(t, err)
@@ -152,6 +220,33 @@ func TestAPIKeyService_CreateAPIKey_Success(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAPIKeyService_CreateAPIKey_WithPlan(t *testing.T) {
+	// Create a real database connection with sqlmock
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Create service with real database connection
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	// Setup mock expectations - limits of 0 inherit from the plan
+	rows := sqlmock.NewRows([]string{"id"}).AddRow("new-id-456")
+
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Plan Key", 0, 0, "plan-id-123", 0, 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, HashVersionSHA256, nil, nil, nil, nil).
+		WillReturnRows(rows)
+
+	// Call the method
+	apiKey, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Plan Key", PlanID: "plan-id-123"})
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotEmpty(t, apiKey)
+
+	// Verify all expectations were met
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAPIKeyService_CreateAPIKey_DatabaseError(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
@@ -159,15 +254,15 @@ func TestAPIKeyService_CreateAPIKey_DatabaseError(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations - return database error
 	mock.ExpectQuery(`INSERT INTO api_keys`).
-		WithArgs(sqlmock.AnyArg(), "Test API Key", 100, 3600).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Test API Key", 100, 3600, nil, 0, 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, HashVersionSHA256, nil, nil, nil, nil).
 		WillReturnError(assert.AnError)
 
 	// Call the method
-	apiKey, err := service.CreateAPIKey("Test API Key", 100, 3600)
+	apiKey, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Test API Key", RateLimitRequests: 100, RateLimitWindowSeconds: 3600})
 
 	// Assertions
 	assert.Error(t, err)
@@ -178,22 +273,22 @@ func TestAPIKeyService_CreateAPIKey_DatabaseError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestAPIKeyService_DeactivateAPIKey_Success(t *testing.T) {
+func TestAPIKeyService_DeleteAPIKey_Success(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations
-	mock.ExpectExec(`UPDATE api_keys SET is_active = false, updated_at = NOW\(\) WHERE key_hash = \$1`).
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\), updated_at = NOW\(\) WHERE key_hash = \$1 AND deleted_at IS NULL`).
 		WithArgs(sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	// Call the method
-	err = service.DeactivateAPIKey("test-api-key")
+	err = service.DeleteAPIKey(context.Background(), "test-api-key")
 
 	// Assertions
 	assert.NoError(t, err)
@@ -202,22 +297,22 @@ func TestAPIKeyService_DeactivateAPIKey_Success(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestAPIKeyService_DeactivateAPIKey_NotFound(t *testing.T) {
+func TestAPIKeyService_DeleteAPIKey_NotFound(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations - no rows affected
-	mock.ExpectExec(`UPDATE api_keys SET is_active = false, updated_at = NOW\(\) WHERE key_hash = \$1`).
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\), updated_at = NOW\(\) WHERE key_hash = \$1 AND deleted_at IS NULL`).
 		WithArgs(sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// Call the method
-	err = service.DeactivateAPIKey("non-existent-key")
+	err = service.DeleteAPIKey(context.Background(), "non-existent-key")
 
 	// Assertions
 	assert.Error(t, err)
@@ -227,47 +322,83 @@ func TestAPIKeyService_DeactivateAPIKey_NotFound(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestAPIKeyService_DeactivateAPIKey_DatabaseError(t *testing.T) {
+func TestAPIKeyService_DeleteAPIKey_RetriesTransientError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), WithRetry(database.RetryPolicy{Attempts: 3}))
+
+	// The server restarting fails the first attempt; the retry succeeds
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnError(&pq.Error{Code: "57P01"})
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, service.DeleteAPIKey(context.Background(), "test-api-key"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_CreateAPIKey_DoesNotRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), WithRetry(database.RetryPolicy{Attempts: 3}))
+
+	// The insert may have been applied before the connection dropped, so
+	// it is not repeated
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WillReturnError(&pq.Error{Code: "57P01"})
+
+	_, err = service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Test"})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_DeleteAPIKey_DatabaseError(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations - return database error
-	mock.ExpectExec(`UPDATE api_keys SET is_active = false, updated_at = NOW\(\) WHERE key_hash = \$1`).
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\), updated_at = NOW\(\) WHERE key_hash = \$1 AND deleted_at IS NULL`).
 		WithArgs(sqlmock.AnyArg()).
 		WillReturnError(assert.AnError)
 
 	// Call the method
-	err = service.DeactivateAPIKey("test-api-key")
+	err = service.DeleteAPIKey(context.Background(), "test-api-key")
 
 	// Assertions
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to deactivate API key")
+	assert.Contains(t, err.Error(), "failed to delete API key")
 
 	// Verify all expectations were met
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestAPIKeyService_DeactivateAPIKey_RowsAffectedError(t *testing.T) {
+func TestAPIKeyService_DeleteAPIKey_RowsAffectedError(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Setup mock expectations - error getting rows affected
-	mock.ExpectExec(`UPDATE api_keys SET is_active = false, updated_at = NOW\(\) WHERE key_hash = \$1`).
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\), updated_at = NOW\(\) WHERE key_hash = \$1 AND deleted_at IS NULL`).
 		WithArgs(sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewErrorResult(assert.AnError))
 
 	// Call the method
-	err = service.DeactivateAPIKey("test-api-key")
+	err = service.DeleteAPIKey(context.Background(), "test-api-key")
 
 	// Assertions
 	assert.Error(t, err)
@@ -277,6 +408,266 @@ func TestAPIKeyService_DeactivateAPIKey_RowsAffectedError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAPIKeyService_CreateLimitOverride_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	expiresAt := time.Now().Add(24 * time.Hour)
+
+	rows := sqlmock.NewRows([]string{"id", "api_key_id", "rate_limit_requests", "expires_at", "created_at"}).
+		AddRow("override-id", "test-id-123", 5000, expiresAt, time.Now())
+
+	mock.ExpectQuery(`INSERT INTO limit_overrides`).
+		WithArgs(service.hashAPIKey("test-api-key"), 5000, expiresAt).
+		WillReturnRows(rows)
+
+	override, err := service.CreateLimitOverride(context.Background(), "test-api-key", 5000, expiresAt)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id-123", override.APIKeyID)
+	assert.Equal(t, 5000, override.RateLimitRequests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_CreateLimitOverride_KeyNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	expiresAt := time.Now().Add(24 * time.Hour)
+
+	mock.ExpectQuery(`INSERT INTO limit_overrides`).
+		WithArgs(sqlmock.AnyArg(), 5000, expiresAt).
+		WillReturnError(sql.ErrNoRows)
+
+	override, err := service.CreateLimitOverride(context.Background(), "missing-key", 5000, expiresAt)
+
+	assert.Nil(t, override)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_RotateAPIKey_ByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	keyID := "3f6c1b9e-8d2a-4c1e-9f3b-2a7d5e8c1b4a"
+	previousExpiry := time.Now().Add(time.Hour)
+
+	// Setup mock expectations - a UUID reference is looked up by ID
+	mock.ExpectQuery(`UPDATE api_keys\s+SET previous_key_hash = key_hash`).
+		WithArgs(keyID, sqlmock.AnyArg(), float64(3600), sqlmock.AnyArg(), HashVersionSHA256).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "previous_key_expires_at"}).AddRow(keyID, previousExpiry))
+
+	rotated, err := service.RotateAPIKey(context.Background(), keyID, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, keyID, rotated.ID)
+	assert.Contains(t, rotated.APIKey, "ak_")
+	assert.Equal(t, KeyPrefix(rotated.APIKey), rotated.KeyPrefix)
+	assert.Equal(t, previousExpiry, rotated.PreviousKeyExpiresAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_RotateAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	// Setup mock expectations - a raw key reference is looked up by hash
+	mock.ExpectQuery(`WHERE key_hash = \$1 AND is_active = true`).
+		WithArgs(service.hashAPIKey("ak_missing"), sqlmock.AnyArg(), float64(60), sqlmock.AnyArg(), HashVersionSHA256).
+		WillReturnError(sql.ErrNoRows)
+
+	rotated, err := service.RotateAPIKey(context.Background(), "ak_missing", time.Minute)
+
+	assert.Nil(t, rotated)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_DeleteAPIKey_ByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	keyID := "3f6c1b9e-8d2a-4c1e-9f3b-2a7d5e8c1b4a"
+
+	mock.ExpectExec(`UPDATE api_keys SET deleted_at = NOW\(\), updated_at = NOW\(\) WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(keyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = service.DeleteAPIKey(context.Background(), keyID)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ListAPIKeys_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	expiresAt := time.Now().Add(time.Hour)
+	lastUsedAt := time.Now().Add(-time.Minute)
+	rows := sqlmock.NewRows(adminAPIKeyColumns).
+		AddRow("key-1", "ak_170000001", "Newest Key", 100, 3600, true, time.Now(), time.Now(), "", 0, 0, expiresAt, "{10.0.0.0/8,192.168.1.1/32}", "{https://app.example.com}", lastUsedAt, true, "", "", "", nil, 0, nil, "", "", nil).
+		AddRow("key-2", "ak_170000000", "Older Key", 0, 0, false, time.Now(), time.Now(), "plan-id-123", 10, 60, nil, nil, nil, nil, false, "", "", "", nil, 0, nil, "", "", nil)
+	mock.ExpectQuery(`SELECT id, key_prefix, name`).WillReturnRows(rows)
+
+	apiKeys, err := service.ListAPIKeys(context.Background(), APIKeyFilter{})
+
+	assert.NoError(t, err)
+	assert.Len(t, apiKeys, 2)
+	assert.Equal(t, "ak_170000001", apiKeys[0].KeyPrefix)
+	assert.Equal(t, expiresAt, *apiKeys[0].ExpiresAt)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1/32"}, apiKeys[0].AllowedCIDRs)
+	assert.Equal(t, []string{"https://app.example.com"}, apiKeys[0].AllowedOrigins)
+	assert.Equal(t, lastUsedAt, *apiKeys[0].LastUsedAt)
+	assert.True(t, apiKeys[0].RequireSignature)
+	assert.Empty(t, apiKeys[0].KeyHash)
+	assert.Equal(t, "plan-id-123", apiKeys[1].PlanID)
+	assert.Nil(t, apiKeys[1].ExpiresAt)
+	assert.Empty(t, apiKeys[1].AllowedCIDRs)
+	assert.Nil(t, apiKeys[1].LastUsedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ListAPIKeys_DatabaseError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	mock.ExpectQuery(`SELECT id, key_prefix, name`).WillReturnError(assert.AnError)
+
+	apiKeys, err := service.ListAPIKeys(context.Background(), APIKeyFilter{})
+
+	assert.Error(t, err)
+	assert.Nil(t, apiKeys)
+	assert.Contains(t, err.Error(), "failed to list API keys")
+}
+
+func TestNormalizeCIDRs(t *testing.T) {
+	cidrs, err := NormalizeCIDRs([]string{"10.1.2.3/8", " 192.168.1.1 ", "2001:db8::1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1/32", "2001:db8::1/128"}, cidrs)
+
+	cidrs, err = NormalizeCIDRs(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, cidrs)
+
+	_, err = NormalizeCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = NormalizeCIDRs([]string{"example.com"})
+	assert.Error(t, err)
+}
+
+func TestNormalizeOrigins(t *testing.T) {
+	origins, err := NormalizeOrigins([]string{"https://App.Example.com/", "https://*.example.com", "http://localhost:3000"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://app.example.com", "https://*.example.com", "http://localhost:3000"}, origins)
+
+	for _, invalid := range []string{"app.example.com", "ftp://example.com", "https://example.com/path", "https://"} {
+		_, err := NormalizeOrigins([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	assert.Equal(t, "ak_170000000", KeyPrefix("ak_1700000000_17a3f9c2b4d5e6f7"))
+	assert.Equal(t, "ak_short", KeyPrefix("ak_short"))
+}
+
+func TestAPIKeyService_GetAPIKey_ByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	keyID := "3f6c1b9e-8d2a-4c1e-9f3b-2a7d5e8c1b4a"
+	lastUsedAt := time.Now().Add(-time.Hour)
+
+	rows := sqlmock.NewRows(adminAPIKeyColumns).
+		AddRow(keyID, "ak_170000000", "Test API Key", 100, 3600, true, time.Now(), time.Now(), "", 0, 0, nil, nil, nil, lastUsedAt, false, "", "", "", nil, 0, nil, "", "", nil)
+	mock.ExpectQuery(`SELECT id, key_prefix, name.* FROM api_keys WHERE id = \$1`).
+		WithArgs(keyID).
+		WillReturnRows(rows)
+
+	apiKey, err := service.GetAPIKey(context.Background(), keyID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, keyID, apiKey.ID)
+	assert.Equal(t, lastUsedAt, *apiKey.LastUsedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_GetAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	mock.ExpectQuery(`FROM api_keys WHERE key_hash = \$1`).
+		WithArgs(service.hashAPIKey("ak_missing")).
+		WillReturnError(sql.ErrNoRows)
+
+	apiKey, err := service.GetAPIKey(context.Background(), "ak_missing")
+
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	assert.Nil(t, apiKey)
+}
+
+func TestAPIKeyService_PurgeAPIKey_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	mock.ExpectQuery(`DELETE FROM api_keys WHERE key_hash = \$1 RETURNING id`).
+		WithArgs(service.hashAPIKey("ak_purge_me")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("test-id-123"))
+
+	id, err := service.PurgeAPIKey(context.Background(), "ak_purge_me")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id-123", id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_PurgeAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	keyID := "3f6c1b9e-8d2a-4c1e-9f3b-2a7d5e8c1b4a"
+
+	mock.ExpectQuery(`DELETE FROM api_keys WHERE id = \$1`).
+		WithArgs(keyID).
+		WillReturnError(sql.ErrNoRows)
+
+	id, err := service.PurgeAPIKey(context.Background(), keyID)
+
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	assert.Empty(t, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAPIKeyService_hashAPIKey(t *testing.T) {
 	// Create a real database connection with sqlmock
 	db, mock, err := sqlmock.New()
@@ -284,7 +675,7 @@ func TestAPIKeyService_hashAPIKey(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Test that the same input produces the same hash
 	apiKey := "test-api-key-123"
@@ -312,12 +703,13 @@ func TestAPIKeyService_generateAPIKey(t *testing.T) {
 	defer db.Close()
 
 	// Create service with real database connection
-	service := NewAPIKeyService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
 
 	// Generate multiple API keys
-	key1 := service.generateAPIKey()
-	time.Sleep(1 * time.Millisecond) // Ensure different timestamps
-	key2 := service.generateAPIKey()
+	key1, err := service.generateAPIKey()
+	assert.NoError(t, err)
+	key2, err := service.generateAPIKey()
+	assert.NoError(t, err)
 
 	// Keys should be different
 	assert.NotEqual(t, key1, key2)
@@ -328,10 +720,251 @@ func TestAPIKeyService_generateAPIKey(t *testing.T) {
 	assert.Contains(t, key1, "ak_")
 	assert.Contains(t, key2, "ak_")
 
-	// Keys should contain timestamp information
-	assert.Contains(t, key1, "_")
-	assert.Contains(t, key2, "_")
+	// Keys should carry a valid checksum
+	assert.Len(t, key1, 39)
+	assert.True(t, VerifyAPIKeyChecksum(key1))
+	assert.True(t, VerifyAPIKeyChecksum(key2))
 
 	// Verify all expectations were met
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestVerifyAPIKeyChecksum(t *testing.T) {
+	secret := "abcdefghijABCDEFGHIJ0123456789"
+	valid := "ak_" + secret + apiKeyChecksum(secret)
+	assert.True(t, VerifyAPIKeyChecksum(valid))
+
+	// A single changed character invalidates the checksum
+	tampered := "ak_" + "bbcdefghijABCDEFGHIJ0123456789" + apiKeyChecksum(secret)
+	assert.False(t, VerifyAPIKeyChecksum(tampered))
+
+	assert.False(t, VerifyAPIKeyChecksum("ak_1700000000_17a3f9c2b4d5e6f7"))
+	assert.False(t, VerifyAPIKeyChecksum("sk_"+secret+apiKeyChecksum(secret)))
+}
+
+func TestAPIKeyService_ValidateAPIKey_RejectsBadChecksumWithoutQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	apiKey, err := service.generateAPIKey()
+	assert.NoError(t, err)
+
+	// Change the last checksum character
+	replacement := "0"
+	if strings.HasSuffix(apiKey, "0") {
+		replacement = "1"
+	}
+	corrupted := apiKey[:len(apiKey)-1] + replacement
+
+	result, err := service.ValidateAPIKey(context.Background(), corrupted)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	// No query is expected; sqlmock fails the test on unexpected calls
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ValidateAPIKey_UpgradesLegacyHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	hashing, err := NewKeyHashing(HashAlgorithmHMACSHA256, "pepper")
+	assert.NoError(t, err)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), WithKeyHashing(hashing))
+
+	testAPIKey := "ak_1234567890_abcdef"
+	legacyHash := DefaultKeyHashing().Hash(testAPIKey)
+	expectedAPIKey := createTestAPIKeyForAPIKeyService()
+
+	rows := sqlmock.NewRows(apiKeyColumns).
+		AddRow(expectedAPIKey.ID, legacyHash, expectedAPIKey.KeyPrefix, expectedAPIKey.Name, expectedAPIKey.RateLimitRequests, expectedAPIKey.RateLimitWindowSeconds, expectedAPIKey.IsActive, expectedAPIKey.CreatedAt, expectedAPIKey.UpdatedAt, "", 0, 0, 0, 0, nil, 0, 0, nil, nil, nil, "", "", HashVersionSHA256, nil, 0, nil, "", "", 0, 0, nil)
+
+	mock.ExpectQuery(`WHERE \(k.key_hash = ANY\(\$1\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE api_keys SET key_hash = \$3, hash_version = \$4`).
+		WithArgs(expectedAPIKey.ID, legacyHash, hashing.Hash(testAPIKey), HashVersionHMACSHA256).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := service.ValidateAPIKey(context.Background(), testAPIKey)
+
+	assert.NoError(t, err)
+	assert.Equal(t, hashing.Hash(testAPIKey), result.KeyHash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ValidateAPIKey_CurrentHashNotRewritten(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	hashing, err := NewKeyHashing(HashAlgorithmHMACSHA256, "pepper")
+	assert.NoError(t, err)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), WithKeyHashing(hashing))
+
+	testAPIKey := "ak_1234567890_abcdef"
+	expectedAPIKey := createTestAPIKeyForAPIKeyService()
+
+	rows := sqlmock.NewRows(apiKeyColumns).
+		AddRow(expectedAPIKey.ID, hashing.Hash(testAPIKey), expectedAPIKey.KeyPrefix, expectedAPIKey.Name, expectedAPIKey.RateLimitRequests, expectedAPIKey.RateLimitWindowSeconds, expectedAPIKey.IsActive, expectedAPIKey.CreatedAt, expectedAPIKey.UpdatedAt, "", 0, 0, 0, 0, nil, 0, 0, nil, nil, nil, "", "", HashVersionHMACSHA256, nil, 0, nil, "", "", 0, 0, nil)
+
+	mock.ExpectQuery(`SELECT k.id, k.key_hash, k.key_prefix, k.name`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(rows)
+
+	_, err = service.ValidateAPIKey(context.Background(), testAPIKey)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ListSubKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	rows := sqlmock.NewRows(adminAPIKeyColumns).
+		AddRow("child-id", "ak_child0000", "Billing Service", 0, 0, true, time.Now(), time.Now(), "", 0, 0, nil, nil, nil, nil, false, "parent-id", "", "", nil, 0, nil, "", "", nil)
+	mock.ExpectQuery(`FROM api_keys WHERE \(parent_id = \$1\) AND \(deleted_at IS NULL\)`).
+		WithArgs("parent-id").
+		WillReturnRows(rows)
+
+	subKeys, err := service.ListSubKeys(context.Background(), "parent-id")
+
+	assert.NoError(t, err)
+	assert.Len(t, subKeys, 1)
+	assert.Equal(t, "parent-id", subKeys[0].ParentID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ValidateAPIKey_SubKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	testAPIKey := "ak_1234567890_abcdef"
+	expectedAPIKey := createTestAPIKeyForAPIKeyService()
+
+	// Limits in the row are the parent's, resolved by the join
+	rows := sqlmock.NewRows(apiKeyColumns).
+		AddRow(expectedAPIKey.ID, service.hashAPIKey(testAPIKey), expectedAPIKey.KeyPrefix, expectedAPIKey.Name, 500, 60, true, expectedAPIKey.CreatedAt, expectedAPIKey.UpdatedAt, "", 0, 0, 0, 0, nil, 0, 0, nil, nil, nil, "", "parent-id", 1, nil, 0, nil, "", "", 0, 0, nil)
+
+	mock.ExpectQuery(`JOIN api_keys l ON l.id = COALESCE\(k.parent_id, k.id\)`).
+		WithArgs(service.hashAPIKey(testAPIKey)).
+		WillReturnRows(rows)
+
+	result, err := service.ValidateAPIKey(context.Background(), testAPIKey)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "parent-id", result.ParentID)
+	assert.Equal(t, "parent-id", result.LimitKeyID())
+	assert.Equal(t, 500, result.RateLimitRequests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_ListAPIKeys_FilterByOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	rows := sqlmock.NewRows(adminAPIKeyColumns).
+		AddRow("key-1", "ak_170000001", "Payments Key", 100, 3600, true, time.Now(), time.Now(), "", 0, 0, nil, nil, nil, nil, false, "", "Payments Team", "payments@example.com", nil, 0, nil, "", "", nil)
+	mock.ExpectQuery(`WHERE \(LOWER\(owner_email\) = LOWER\(\$1\) OR LOWER\(owner_name\) = LOWER\(\$1\)\) AND \(deleted_at IS NULL\) ORDER BY created_at DESC`).
+		WithArgs("Payments@Example.com").
+		WillReturnRows(rows)
+
+	apiKeys, err := service.ListAPIKeys(context.Background(), APIKeyFilter{Owner: "Payments@Example.com"})
+
+	assert.NoError(t, err)
+	assert.Len(t, apiKeys, 1)
+	assert.Equal(t, "Payments Team", apiKeys[0].OwnerName)
+	assert.Equal(t, "payments@example.com", apiKeys[0].OwnerEmail)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_UpdateAPIKeyOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	keyID := "123e4567-e89b-12d3-a456-426614174000"
+
+	rows := sqlmock.NewRows(adminAPIKeyColumns).
+		AddRow(keyID, "ak_170000000", "Test API Key", 100, 3600, true, time.Now(), time.Now(), "", 0, 0, nil, nil, nil, nil, false, "", "Search Team", "", nil, 0, nil, "", "", nil)
+	mock.ExpectQuery(`UPDATE api_keys SET owner_name = \$2, owner_email = \$3`).
+		WithArgs(keyID, "Search Team", nil).
+		WillReturnRows(rows)
+
+	apiKey, err := service.UpdateAPIKeyOwner(context.Background(), keyID, "Search Team", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Search Team", apiKey.OwnerName)
+	assert.Empty(t, apiKey.OwnerEmail)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_UpdateAPIKeyOwner_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	mock.ExpectQuery(`UPDATE api_keys SET owner_name`).
+		WillReturnError(sql.ErrNoRows)
+
+	apiKey, err := service.UpdateAPIKeyOwner(context.Background(), "ak_missing", "Search Team", "search@example.com")
+
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+	assert.Nil(t, apiKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyService_MemoryRepository(t *testing.T) {
+	service := NewAPIKeyService(repository.NewMemoryAPIKeyRepository())
+	ctx := context.Background()
+
+	apiKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Memory", RateLimitRequests: 10, RateLimitWindowSeconds: 60})
+	assert.NoError(t, err)
+
+	record, err := service.ValidateAPIKey(ctx, apiKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "Memory", record.Name)
+
+	rotated, err := service.RotateAPIKey(ctx, apiKey, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, record.ID, rotated.ID)
+	_, err = service.ValidateAPIKey(ctx, rotated.APIKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.DeleteAPIKey(ctx, record.ID))
+	_, err = service.ValidateAPIKey(ctx, rotated.APIKey)
+	assert.EqualError(t, err, "invalid API key")
+	assert.ErrorIs(t, service.DeleteAPIKey(ctx, "ak_unknown"), ErrAPIKeyNotFound)
+
+	listed, err := service.ListAPIKeys(ctx, APIKeyFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, listed)
+	listed, err = service.ListAPIKeys(ctx, APIKeyFilter{IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+
+	restored, err := service.RestoreAPIKey(ctx, rotated.APIKey)
+	assert.NoError(t, err)
+	assert.Equal(t, record.ID, restored.ID)
+	_, err = service.ValidateAPIKey(ctx, rotated.APIKey)
+	assert.NoError(t, err)
+	_, err = service.RestoreAPIKey(ctx, record.ID)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// ExpirySweeper periodically deactivates API keys whose expires_at has
+// passed and publishes an event for each one. ValidateAPIKey already rejects
+// expired keys, so the sweeper only keeps is_active in step with reality.
+// Deleted keys are left alone; they are swept once restored.
+type ExpirySweeper struct {
+	db        database.DBInterface
+	publisher events.Publisher
+	interval  time.Duration
+}
+
+func NewExpirySweeper(db database.DBInterface, publisher events.Publisher, interval time.Duration) *ExpirySweeper {
+	return &ExpirySweeper{
+		db:        db,
+		publisher: publisher,
+		interval:  interval,
+	}
+}
+
+// Run sweeps on every tick until ctx is cancelled. A non-positive interval
+// disables the sweeper.
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Sweep(ctx); err != nil {
+				logging.FromContext(ctx).Error("Key expiry sweep failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Sweep deactivates all expired keys and returns how many were affected
+func (s *ExpirySweeper) Sweep(ctx context.Context) (int, error) {
+	dialect := database.DialectOf(s.db)
+	update := `
+		UPDATE api_keys
+		SET is_active = false, updated_at = ` + dialect.Now() + `
+		WHERE is_active = true AND deleted_at IS NULL AND expires_at IS NOT NULL AND expires_at <= ` + dialect.Now()
+
+	var expired []events.Event
+	var err error
+	if dialect.Returning() {
+		expired, err = s.expiredKeys(ctx, update+` RETURNING id, key_prefix, name, expires_at`)
+	} else {
+		// Without RETURNING, read the expired keys first and deactivate
+		// exactly those
+		expired, err = s.expiredKeys(ctx, `SELECT id, key_prefix, name, expires_at FROM api_keys WHERE is_active = true AND deleted_at IS NULL AND expires_at IS NOT NULL AND expires_at <= `+dialect.Now())
+		if err == nil && len(expired) > 0 {
+			ids := make([]string, len(expired))
+			for i, event := range expired {
+				ids[i] = event.APIKeyID
+			}
+			_, err = s.db.ExecContext(ctx, `UPDATE api_keys SET is_active = false, updated_at = `+dialect.Now()+` WHERE `+dialect.In("id", "$1"), dialect.List(ids))
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to deactivate expired keys: %w", err)
+	}
+
+	for _, event := range expired {
+		if err := s.publisher.Publish(ctx, event); err != nil {
+			logging.FromContext(ctx).Error("Failed to publish event", zap.String("event", event.Type), zap.String("api_key_id", event.APIKeyID), zap.Error(err))
+		}
+	}
+
+	return len(expired), nil
+}
+
+// expiredKeys runs query and returns an APIKeyExpired event for each row of
+// id, key_prefix, name and expires_at
+func (s *ExpirySweeper) expiredKeys(ctx context.Context, query string) ([]events.Event, error) {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []events.Event
+	for rows.Next() {
+		var id, keyPrefix, name string
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &keyPrefix, &name, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expired key: %w", err)
+		}
+		expired = append(expired, events.Event{
+			Type:      events.APIKeyExpired,
+			APIKeyID:  id,
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"key_prefix": keyPrefix,
+				"name":       name,
+				"expires_at": expiresAt,
+			},
+		})
+	}
+	return expired, rows.Err()
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/events"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestExpirySweeper_Sweep_DeactivatesAndPublishes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	publisher := &recordingPublisher{}
+	sweeper := NewExpirySweeper(db, publisher, time.Minute)
+
+	expiredAt := time.Now().Add(-time.Minute)
+	rows := sqlmock.NewRows([]string{"id", "key_prefix", "name", "expires_at"}).
+		AddRow("key-1", "ak_17000000", "First Key", expiredAt).
+		AddRow("key-2", "ak_17000001", "Second Key", expiredAt)
+	mock.ExpectQuery(`UPDATE api_keys\s+SET is_active = false`).WillReturnRows(rows)
+
+	count, err := sweeper.Sweep(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Len(t, publisher.events, 2)
+	assert.Equal(t, events.APIKeyExpired, publisher.events[0].Type)
+	assert.Equal(t, "key-1", publisher.events[0].APIKeyID)
+	assert.Equal(t, "Second Key", publisher.events[1].Data["name"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExpirySweeper_Sweep_NothingExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	publisher := &recordingPublisher{}
+	sweeper := NewExpirySweeper(db, publisher, time.Minute)
+
+	mock.ExpectQuery(`UPDATE api_keys`).WillReturnRows(sqlmock.NewRows([]string{"id", "key_prefix", "name", "expires_at"}))
+
+	count, err := sweeper.Sweep(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Empty(t, publisher.events)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExpirySweeper_Sweep_DatabaseError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	sweeper := NewExpirySweeper(db, &recordingPublisher{}, time.Minute)
+
+	mock.ExpectQuery(`UPDATE api_keys`).WillReturnError(assert.AnError)
+
+	_, err = sweeper.Sweep(context.Background())
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to deactivate expired keys")
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// Statuses of usage exports
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// ExportCSV is the format of usage exports: one row per usage log, with a
+// header row
+const ExportCSV = "csv"
+
+const (
+	// A running export not finished within this long is assumed to have lost
+	// its worker, e.g. to a crash, and is picked up again
+	exportStaleAfter = 15 * time.Minute
+	// Errors kept with failed exports
+	exportMaxErrorLength = 1024
+	// Reports are stored in parts of about this many bytes
+	exportChunkSize = 1 << 20
+	// Usage logs read at a time while rendering
+	exportPageSize = 1000
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not completed")
+)
+
+// exportColumns head the CSV exports
+var exportColumns = []string{"created_at", "api_key_id", "key_name", "method", "route", "status_code", "cost", "decision"}
+
+// CreateExportParams selects the usage logs to export: those created from
+// From up to To, of every key unless APIKeyID is set
+type CreateExportParams struct {
+	From     time.Time
+	To       time.Time
+	APIKeyID string
+	Format   string
+}
+
+// ExportService produces usage reports in the background. Requested exports
+// are stored as pending in usage_exports; workers claim them one at a time,
+// so several replicas can share the work, and store the generated report
+// in usage_export_chunks as it is written, until the retention job deletes
+// the export and its chunks with it.
+type ExportService struct {
+	db        database.DBInterface
+	dialect   database.Dialect
+	interval  time.Duration
+	maxRows   int
+	chunkSize int
+	pageSize  int
+	wake      chan struct{}
+}
+
+func NewExportService(db database.DBInterface, cfg config.ExportConfig) *ExportService {
+	return &ExportService{
+		db:        db,
+		dialect:   database.DialectOf(db),
+		interval:  cfg.PollInterval,
+		maxRows:   cfg.MaxRows,
+		chunkSize: exportChunkSize,
+		pageSize:  exportPageSize,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// ValidateExport checks the format and that the period is ordered
+func ValidateExport(params CreateExportParams) error {
+	if params.Format != ExportCSV {
+		return fmt.Errorf("format must be %s, got %q", ExportCSV, params.Format)
+	}
+	if !params.To.After(params.From) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// CreateExport requests an export, which is generated in the background.
+// The returned export is pending; poll GetExport until it is completed.
+func (s *ExportService) CreateExport(ctx context.Context, params CreateExportParams) (*database.UsageExport, error) {
+	if err := ValidateExport(params); err != nil {
+		return nil, err
+	}
+	id, err := database.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeyID interface{}
+	if params.APIKeyID != "" {
+		apiKeyID = params.APIKeyID
+	}
+	insert := `
+		INSERT INTO usage_exports (id, status, format, api_key_id, period_start, period_end)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := s.db.ExecContext(ctx, insert, id, ExportPending, params.Format, apiKeyID, params.From.UTC(), params.To.UTC()); err != nil {
+		return nil, fmt.Errorf("failed to create export: %w", err)
+	}
+
+	// Wake this replica's worker rather than waiting for its next poll
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return s.GetExport(ctx, id)
+}
+
+// GetExport returns an export's status, without its content
+func (s *ExportService) GetExport(ctx context.Context, id string) (*database.UsageExport, error) {
+	var export database.UsageExport
+	var apiKeyID sql.NullString
+	var startedAt, completedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, status, format, api_key_id, period_start, period_end, row_count,
+			COALESCE(LENGTH(content), 0) + COALESCE((SELECT SUM(LENGTH(c.content)) FROM usage_export_chunks c WHERE c.export_id = usage_exports.id), 0),
+			error, created_at, started_at, completed_at
+		FROM usage_exports
+		WHERE id = $1
+	`, id).Scan(&export.ID, &export.Status, &export.Format, &apiKeyID, &export.From, &export.To, &export.Rows, &export.SizeBytes,
+		&export.Error, &export.CreatedAt, &startedAt, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export: %w", err)
+	}
+	export.APIKeyID = apiKeyID.String
+	if startedAt.Valid {
+		export.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	return &export, nil
+}
+
+// ExportContent returns a completed export and a reader of its report, or
+// ErrExportNotReady while it is still being generated or when it failed. The
+// report is read from the database a chunk at a time, within ctx.
+func (s *ExportService) ExportContent(ctx context.Context, id string) (*database.UsageExport, io.Reader, error) {
+	export, err := s.GetExport(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != ExportCompleted {
+		return export, nil, ErrExportNotReady
+	}
+
+	// Reports generated before they were stored in chunks come first
+	var legacy []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT content FROM usage_exports WHERE id = $1`, id).Scan(&legacy); err != nil {
+		return nil, nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	return export, &exportReader{ctx: ctx, db: s.db, id: id, chunk: legacy}, nil
+}
+
+// exportReader reads a report's chunks in order, fetching the next one once
+// the current one has been read
+type exportReader struct {
+	ctx   context.Context
+	db    database.DBInterface
+	id    string
+	seq   int
+	chunk []byte
+	done  bool
+}
+
+func (r *exportReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.db.QueryRowContext(r.ctx, `
+			SELECT seq, content FROM usage_export_chunks
+			WHERE export_id = $1 AND seq >= $2
+			ORDER BY seq
+			LIMIT 1
+		`, r.id, r.seq).Scan(&r.seq, &r.chunk)
+		if errors.Is(err, sql.ErrNoRows) {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read export: %w", err)
+		}
+		r.seq++
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// Run generates requested exports every poll interval, and whenever one is
+// requested on this replica, until ctx is cancelled. A non-positive interval
+// disables the worker.
+func (s *ExportService) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if _, err := s.ProcessPending(ctx); err != nil {
+			logging.FromContext(ctx).Error("Usage export failed", zap.Error(err))
+		}
+	}
+}
+
+// ProcessPending generates every export waiting for a worker and returns how
+// many were processed. Exports that fail are marked failed; an error is only
+// returned when exports couldn't be claimed or stored.
+func (s *ExportService) ProcessPending(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		export, err := s.claim(ctx)
+		if err != nil || export == nil {
+			return processed, err
+		}
+		if err := s.generate(ctx, export); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// claim marks the oldest pending export, or one whose worker went away, as
+// running and returns it, or nil when no export is waiting
+func (s *ExportService) claim(ctx context.Context) (*database.UsageExport, error) {
+	for {
+		stale := time.Now().Add(-exportStaleAfter)
+		waiting := `status = $1 OR (status = $2 AND started_at < $3)`
+
+		var id string
+		err := s.db.QueryRowContext(ctx, `
+			SELECT id FROM usage_exports
+			WHERE `+waiting+`
+			ORDER BY created_at
+			LIMIT 1
+		`, ExportPending, ExportRunning, stale).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find pending exports: %w", err)
+		}
+
+		// Another worker may claim the export first, then look again
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE usage_exports SET status = $4, started_at = $5
+			WHERE id = $6 AND (`+waiting+`)
+		`, ExportPending, ExportRunning, stale, ExportRunning, time.Now().UTC(), id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim export: %w", err)
+		}
+		if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+			if err != nil {
+				return nil, fmt.Errorf("failed to claim export: %w", err)
+			}
+			continue
+		}
+		return s.GetExport(ctx, id)
+	}
+}
+
+// generate renders a claimed export and stores the outcome. An export
+// interrupted by shutdown goes back to pending for the next worker.
+func (s *ExportService) generate(ctx context.Context, export *database.UsageExport) error {
+	logger := logging.FromContext(ctx).With(zap.String("export_id", export.ID))
+
+	rows, size, err := s.render(ctx, export)
+	status, message := ExportCompleted, ""
+	switch {
+	case ctx.Err() != nil:
+		status, rows = ExportPending, 0
+	case err != nil:
+		status, rows, message = ExportFailed, 0, err.Error()
+		if len(message) > exportMaxErrorLength {
+			message = message[:exportMaxErrorLength]
+		}
+		logger.Warn("Usage export failed", zap.Error(err))
+	default:
+		logger.Info("Usage export completed", zap.Int64("rows", rows), zap.Int64("bytes", size))
+	}
+
+	var completedAt interface{}
+	if status != ExportPending {
+		completedAt = time.Now().UTC()
+	}
+
+	// Recorded with its own context so the outcome is kept while shutting down
+	updateCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if status != ExportCompleted {
+		if err := s.deleteChunks(updateCtx, export.ID); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(updateCtx, `
+		UPDATE usage_exports
+		SET status = $1, row_count = $2, error = $3, completed_at = $4
+		WHERE id = $5
+	`, status, rows, message, completedAt, export.ID)
+	if err != nil {
+		return fmt.Errorf("failed to store export %s: %w", export.ID, err)
+	}
+	return nil
+}
+
+// deleteChunks drops what was stored of an export's report
+func (s *ExportService) deleteChunks(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM usage_export_chunks WHERE export_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to clear export %s: %w", id, err)
+	}
+	return nil
+}
+
+// render writes the export's usage logs as CSV, oldest first, to its chunks
+// and returns the number of rows and bytes written, failing when there are
+// more rows than the configured maximum. Chunks left by a worker that went
+// away are replaced.
+func (s *ExportService) render(ctx context.Context, export *database.UsageExport) (int64, int64, error) {
+	if err := s.deleteChunks(ctx, export.ID); err != nil {
+		return 0, 0, err
+	}
+
+	chunks := &chunkWriter{ctx: ctx, db: s.db, id: export.ID, size: s.chunkSize}
+	w := csv.NewWriter(chunks)
+	if err := w.Write(exportColumns); err != nil {
+		return 0, 0, err
+	}
+	var count int64
+	var last *database.UsageLog
+	for {
+		logs, names, err := s.readUsage(ctx, export, last)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(logs) == 0 {
+			break
+		}
+		if count += int64(len(logs)); count > int64(s.maxRows) {
+			return 0, 0, fmt.Errorf("the export has more than %d rows, request a shorter period", s.maxRows)
+		}
+		for i, log := range logs {
+			record := []string{
+				log.CreatedAt.UTC().Format(time.RFC3339Nano),
+				log.APIKeyID,
+				names[i],
+				log.Method,
+				log.Route,
+				strconv.Itoa(log.StatusCode),
+				strconv.Itoa(log.Cost),
+				log.Decision,
+			}
+			if err := w.Write(record); err != nil {
+				return 0, 0, err
+			}
+		}
+		last = &logs[len(logs)-1]
+
+		// Hand the page to chunks, storing every chunk it completes
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return 0, 0, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, 0, err
+	}
+	if err := chunks.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return count, chunks.written, nil
+}
+
+// readUsage returns the next page of the export's usage logs after last,
+// or the first one when last is nil, with the names of their keys. Pages
+// are read whole so no query is left open while chunks are stored.
+func (s *ExportService) readUsage(ctx context.Context, export *database.UsageExport, last *database.UsageLog) ([]database.UsageLog, []string, error) {
+	query := `
+		SELECT u.id, u.created_at, ` + s.dialect.Text("u.api_key_id") + `, k.name, u.method, u.route, u.status_code, u.cost, u.decision
+		FROM usage_logs u
+		JOIN api_keys k ON k.id = u.api_key_id
+		WHERE u.created_at >= $1 AND u.created_at < $2
+	`
+	args := []interface{}{export.From, export.To}
+	if export.APIKeyID != "" {
+		args = append(args, export.APIKeyID)
+		query += fmt.Sprintf(` AND u.api_key_id = $%d`, len(args))
+	}
+	if last != nil {
+		args = append(args, last.CreatedAt, last.ID)
+		query += fmt.Sprintf(` AND (u.created_at > $%d OR (u.created_at = $%d AND u.id > $%d))`, len(args)-1, len(args)-1, len(args))
+	}
+	query += fmt.Sprintf(` ORDER BY u.created_at, u.id LIMIT %d`, s.pageSize)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read usage logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []database.UsageLog
+	var names []string
+	for rows.Next() {
+		var log database.UsageLog
+		var name string
+		if err := rows.Scan(&log.ID, &log.CreatedAt, &log.APIKeyID, &name, &log.Method, &log.Route, &log.StatusCode, &log.Cost, &log.Decision); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan usage log: %w", err)
+		}
+		logs = append(logs, log)
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read usage logs: %w", err)
+	}
+	return logs, names, nil
+}
+
+// chunkWriter stores what is written to it as an export's chunks, each
+// once it holds size bytes
+type chunkWriter struct {
+	ctx     context.Context
+	db      database.DBInterface
+	id      string
+	size    int
+	seq     int
+	buf     bytes.Buffer
+	written int64
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	if w.buf.Len() >= w.size {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush stores the bytes written since the last chunk, if any
+func (w *chunkWriter) Flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.db.ExecContext(w.ctx, `
+		INSERT INTO usage_export_chunks (export_id, seq, content) VALUES ($1, $2, $3)
+	`, w.id, w.seq, w.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+	w.seq++
+	w.written += int64(w.buf.Len())
+	w.buf.Reset()
+	return nil
+}
+
+// Ensure ExportService implements ExportServiceInterface
+var _ ExportServiceInterface = (*ExportService)(nil)
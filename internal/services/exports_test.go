@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestExportService(t *testing.T, maxRows int) (*ExportService, *database.APIKey) {
+	db := newSQLiteDB(t)
+	ctx := context.Background()
+	apiKey, err := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db)).ValidateAPIKey(ctx, "hello")
+	require.NoError(t, err)
+
+	writer := NewUsageLogWriter(db, 10, 10, time.Minute)
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	writer.Log(database.UsageLog{APIKeyID: apiKey.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, Decision: "allowed", CreatedAt: start})
+	writer.Log(database.UsageLog{APIKeyID: apiKey.ID, Method: "GET", Route: "/api/test", StatusCode: 429, Cost: 1, Decision: "rate_limited", CreatedAt: start.Add(time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: apiKey.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: start.Add(48 * time.Hour)})
+	_, err = writer.Flush(ctx)
+	require.NoError(t, err)
+
+	return NewExportService(db, config.ExportConfig{PollInterval: time.Minute, MaxRows: maxRows}), apiKey
+}
+
+func TestExportService_GeneratesCSV(t *testing.T) {
+	ctx := context.Background()
+	service, apiKey := newTestExportService(t, 100)
+
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	export, err := service.CreateExport(ctx, CreateExportParams{From: from, To: from.Add(24 * time.Hour), APIKeyID: apiKey.ID, Format: ExportCSV})
+	require.NoError(t, err)
+	assert.Equal(t, ExportPending, export.Status)
+	assert.Equal(t, from, export.From.UTC())
+
+	_, _, err = service.ExportContent(ctx, export.ID)
+	assert.ErrorIs(t, err, ErrExportNotReady)
+
+	processed, err := service.ProcessPending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+
+	export, reader, err := service.ExportContent(ctx, export.ID)
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, ExportCompleted, export.Status)
+	assert.Equal(t, int64(2), export.Rows, "usage outside the period isn't exported")
+	assert.Equal(t, int64(len(content)), export.SizeBytes)
+	require.NotNil(t, export.CompletedAt)
+
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, exportColumns, records[0])
+	assert.Equal(t, []string{"2025-06-01T12:01:00Z", apiKey.ID, apiKey.Name, "GET", "/api/test", "429", "1", "rate_limited"}, records[2])
+
+	processed, err = service.ProcessPending(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, processed, "exports are generated once")
+}
+
+func TestExportService_StoresChunks(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestExportService(t, 100)
+	service.chunkSize = 64
+	service.pageSize = 1
+
+	from := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	export, err := service.CreateExport(ctx, CreateExportParams{From: from, To: from.AddDate(0, 2, 0), Format: ExportCSV})
+	require.NoError(t, err)
+	_, err = service.ProcessPending(ctx)
+	require.NoError(t, err)
+
+	var chunks int
+	require.NoError(t, service.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM usage_export_chunks WHERE export_id = $1`, export.ID).Scan(&chunks))
+	assert.Greater(t, chunks, 2, "the report is stored in parts")
+
+	export, reader, err := service.ExportContent(ctx, export.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), export.Rows)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), export.SizeBytes)
+
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4, "every page of usage logs is exported")
+	assert.Equal(t, "2025-06-01T12:00:00Z", records[1][0])
+	assert.Equal(t, "2025-06-03T12:00:00Z", records[3][0])
+}
+
+func TestExportService_FailsAboveMaxRows(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestExportService(t, 2)
+
+	from := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	export, err := service.CreateExport(ctx, CreateExportParams{From: from, To: from.AddDate(0, 2, 0), Format: ExportCSV})
+	require.NoError(t, err)
+	_, err = service.ProcessPending(ctx)
+	require.NoError(t, err)
+
+	export, err = service.GetExport(ctx, export.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ExportFailed, export.Status)
+	assert.Contains(t, export.Error, "more than 2 rows")
+	assert.Zero(t, export.SizeBytes, "a failed export keeps none of its report")
+}
+
+func TestExportService_Validation(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.ErrorContains(t, ValidateExport(CreateExportParams{From: from, To: from.Add(time.Hour), Format: "parquet"}), "format must be csv")
+	assert.ErrorContains(t, ValidateExport(CreateExportParams{From: from, To: from, Format: ExportCSV}), "from must be before to")
+
+	service, _ := newTestExportService(t, 10)
+	_, err := service.GetExport(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrExportNotFound)
+}
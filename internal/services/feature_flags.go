@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/logging"
+
+	"go.uber.org/zap"
+)
+
+// Sources of a feature flag's current value
+const (
+	FeatureFlagSourceConfig  = "config"
+	FeatureFlagSourceRuntime = "runtime"
+)
+
+var (
+	ErrRuntimeFlagsDisabled = errors.New("runtime feature flags are not enabled")
+	ErrInvalidFlagName      = errors.New("flag names may only contain lowercase letters, digits, '_', '-' and '.'")
+)
+
+// FeatureFlag is a named capability, whether it is on, and whether that
+// comes from the configuration or a runtime toggle
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// FeatureFlagStore keeps runtime toggles shared by all instances, e.g. in
+// Redis
+type FeatureFlagStore interface {
+	LoadFeatureFlags(ctx context.Context) (map[string]bool, error)
+	SetFeatureFlag(ctx context.Context, name string, enabled bool) error
+	DeleteFeatureFlag(ctx context.Context, name string) error
+}
+
+// FeatureFlagService answers whether a capability is enabled. Flags come from
+// the configuration; with a store, runtime toggles override them and are
+// re-read every interval so all instances converge. Enabled never blocks on
+// the store.
+type FeatureFlagService struct {
+	store    FeatureFlagStore
+	interval time.Duration
+
+	defaults  atomic.Pointer[map[string]bool]
+	overrides atomic.Pointer[map[string]bool]
+}
+
+// NewFeatureFlagService returns flags defaulting to defaults. store may be
+// nil, in which case flags can only change through the configuration.
+func NewFeatureFlagService(defaults map[string]bool, store FeatureFlagStore, interval time.Duration) *FeatureFlagService {
+	s := &FeatureFlagService{store: store, interval: interval}
+	s.SetDefaults(defaults)
+	s.overrides.Store(&map[string]bool{})
+	return s
+}
+
+// SetDefaults replaces the flags taken from the configuration
+func (s *FeatureFlagService) SetDefaults(defaults map[string]bool) {
+	copied := make(map[string]bool, len(defaults))
+	for name, enabled := range defaults {
+		copied[name] = enabled
+	}
+	s.defaults.Store(&copied)
+}
+
+// Enabled reports whether the flag is on. Unknown flags, and all flags of a
+// nil service, are off.
+func (s *FeatureFlagService) Enabled(name string) bool {
+	if s == nil {
+		return false
+	}
+	if enabled, ok := (*s.overrides.Load())[name]; ok {
+		return enabled
+	}
+	return (*s.defaults.Load())[name]
+}
+
+// List returns every configured or toggled flag, sorted by name
+func (s *FeatureFlagService) List() []FeatureFlag {
+	defaults, overrides := *s.defaults.Load(), *s.overrides.Load()
+
+	flags := make([]FeatureFlag, 0, len(defaults)+len(overrides))
+	for name, enabled := range overrides {
+		flags = append(flags, FeatureFlag{Name: name, Enabled: enabled, Source: FeatureFlagSourceRuntime})
+	}
+	for name, enabled := range defaults {
+		if _, toggled := overrides[name]; !toggled {
+			flags = append(flags, FeatureFlag{Name: name, Enabled: enabled, Source: FeatureFlagSourceConfig})
+		}
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// Set toggles a flag at runtime for all instances, overriding the
+// configuration until Reset
+func (s *FeatureFlagService) Set(ctx context.Context, name string, enabled bool) error {
+	if s.store == nil {
+		return ErrRuntimeFlagsDisabled
+	}
+	if !config.ValidFlagName(name) {
+		return ErrInvalidFlagName
+	}
+	if err := s.store.SetFeatureFlag(ctx, name, enabled); err != nil {
+		return fmt.Errorf("failed to store feature flag: %w", err)
+	}
+	return s.Refresh(ctx)
+}
+
+// Reset removes a runtime toggle so the flag follows the configuration again
+func (s *FeatureFlagService) Reset(ctx context.Context, name string) error {
+	if s.store == nil {
+		return ErrRuntimeFlagsDisabled
+	}
+	if err := s.store.DeleteFeatureFlag(ctx, name); err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	return s.Refresh(ctx)
+}
+
+// Refresh re-reads the runtime toggles from the store
+func (s *FeatureFlagService) Refresh(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	overrides, err := s.store.LoadFeatureFlags(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	if overrides == nil {
+		overrides = map[string]bool{}
+	}
+	s.overrides.Store(&overrides)
+	return nil
+}
+
+// Run refreshes the runtime toggles on every tick until ctx is cancelled.
+// When the store can't be read the last known toggles stay in effect.
+func (s *FeatureFlagService) Run(ctx context.Context) {
+	if s.store == nil || s.interval <= 0 {
+		return
+	}
+	if err := s.Refresh(ctx); err != nil {
+		logging.FromContext(ctx).Error("Feature flag refresh failed", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				logging.FromContext(ctx).Error("Feature flag refresh failed", zap.Error(err))
+			}
+		}
+	}
+}
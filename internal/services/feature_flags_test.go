@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryFlagStore struct {
+	flags map[string]bool
+	err   error
+}
+
+func (s *memoryFlagStore) LoadFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	copied := map[string]bool{}
+	for name, enabled := range s.flags {
+		copied[name] = enabled
+	}
+	return copied, nil
+}
+
+func (s *memoryFlagStore) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	s.flags[name] = enabled
+	return nil
+}
+
+func (s *memoryFlagStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	delete(s.flags, name)
+	return nil
+}
+
+func TestFeatureFlagService_ConfigOnly(t *testing.T) {
+	flags := NewFeatureFlagService(map[string]bool{"shadow_mode": true, "anonymous_tier": false}, nil, time.Second)
+
+	assert.True(t, flags.Enabled("shadow_mode"))
+	assert.False(t, flags.Enabled("anonymous_tier"))
+	assert.False(t, flags.Enabled("unknown"))
+	assert.ErrorIs(t, flags.Set(context.Background(), "shadow_mode", false), ErrRuntimeFlagsDisabled)
+	assert.ErrorIs(t, flags.Reset(context.Background(), "shadow_mode"), ErrRuntimeFlagsDisabled)
+
+	flags.SetDefaults(map[string]bool{"anonymous_tier": true})
+	assert.False(t, flags.Enabled("shadow_mode"))
+	assert.True(t, flags.Enabled("anonymous_tier"))
+
+	var disabled *FeatureFlagService
+	assert.False(t, disabled.Enabled("shadow_mode"))
+}
+
+func TestFeatureFlagService_RuntimeToggles(t *testing.T) {
+	store := &memoryFlagStore{flags: map[string]bool{}}
+	flags := NewFeatureFlagService(map[string]bool{"shadow_mode": true}, store, time.Second)
+	ctx := context.Background()
+
+	require.NoError(t, flags.Set(ctx, "shadow_mode", false))
+	require.NoError(t, flags.Set(ctx, "sliding_window", true))
+	assert.False(t, flags.Enabled("shadow_mode"))
+	assert.True(t, flags.Enabled("sliding_window"))
+	assert.Equal(t, []FeatureFlag{
+		{Name: "shadow_mode", Enabled: false, Source: FeatureFlagSourceRuntime},
+		{Name: "sliding_window", Enabled: true, Source: FeatureFlagSourceRuntime},
+	}, flags.List())
+
+	require.NoError(t, flags.Reset(ctx, "shadow_mode"))
+	assert.True(t, flags.Enabled("shadow_mode"))
+	assert.Equal(t, FeatureFlag{Name: "shadow_mode", Enabled: true, Source: FeatureFlagSourceConfig}, flags.List()[0])
+
+	assert.ErrorIs(t, flags.Set(ctx, "Shadow Mode", true), ErrInvalidFlagName)
+}
+
+func TestFeatureFlagService_RefreshKeepsTogglesWhenStoreFails(t *testing.T) {
+	store := &memoryFlagStore{flags: map[string]bool{"anonymous_tier": true}}
+	flags := NewFeatureFlagService(nil, store, time.Second)
+	ctx := context.Background()
+
+	require.NoError(t, flags.Refresh(ctx))
+	assert.True(t, flags.Enabled("anonymous_tier"))
+
+	store.err = errors.New("connection refused")
+	assert.Error(t, flags.Refresh(ctx))
+	assert.True(t, flags.Enabled("anonymous_tier"))
+}
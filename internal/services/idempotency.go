@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// A request still in progress after this long is assumed to have lost its
+// server, e.g. to a crash, and its key may be claimed again
+const idempotencyStaleAfter = 5 * time.Minute
+
+var (
+	ErrIdempotencyKeyInUse  = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyKeyReused = errors.New("this idempotency key was already used for a different request")
+)
+
+// IdempotentResponse is the stored response to a request made with an
+// idempotency key
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyService remembers the responses to requests made with an
+// idempotency key in idempotency_keys, so that retrying a request replays
+// its response instead of performing it again. Keys are scoped to the caller
+// and remembered for the retention period; the retention job deletes them
+// afterwards.
+//
+// Responses to creates and rotations carry the minted API keys, so the
+// table only holds a hash of each idempotency key and the responses are
+// encrypted with a key derived from it. Retries, which send the idempotency
+// key, can have theirs replayed; a copy of the table reveals no API keys.
+type IdempotencyService struct {
+	db        database.DBInterface
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewIdempotencyService remembers keys for retention; zero remembers them
+// until they are deleted
+func NewIdempotencyService(db database.DBInterface, retention time.Duration) *IdempotencyService {
+	return &IdempotencyService{db: db, retention: retention, now: time.Now}
+}
+
+// Begin claims key of scope for the request whose hash is requestHash. It
+// returns nil when the request should be performed, followed by Complete
+// with its response or Release if it failed, and the stored response when
+// the request was already performed. A key still claimed by its request
+// fails with ErrIdempotencyKeyInUse, and one used for a different request
+// with ErrIdempotencyKeyReused.
+func (s *IdempotencyService) Begin(ctx context.Context, scope, key, requestHash string) (*IdempotentResponse, error) {
+	rawKey, key := key, hashIdempotencyKey(key)
+	for attempt := 0; ; attempt++ {
+		insert := `
+			INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+		`
+		_, insertErr := s.db.ExecContext(ctx, insert, scope, key, requestHash, s.now().UTC())
+		if insertErr == nil {
+			return nil, nil
+		}
+
+		// The key exists, unless the insert failed for another reason
+		var storedHash, contentType string
+		var statusCode int
+		var body []byte
+		var createdAt time.Time
+		err := s.db.QueryRowContext(ctx, `
+			SELECT request_hash, status_code, content_type, response_body, created_at
+			FROM idempotency_keys
+			WHERE scope = $1 AND idempotency_key = $2
+		`, scope, key).Scan(&storedHash, &statusCode, &contentType, &body, &createdAt)
+		if errors.Is(err, sql.ErrNoRows) && attempt == 0 {
+			continue
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to claim idempotency key: %w", insertErr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+		}
+
+		// Keys past their retention, and requests that never finished, are
+		// forgotten and the key claimed again
+		if cutoff, ok := s.expiry(statusCode); ok && createdAt.Before(cutoff) && attempt == 0 {
+			if _, err := s.db.ExecContext(ctx, `
+				DELETE FROM idempotency_keys
+				WHERE scope = $1 AND idempotency_key = $2 AND status_code = $3 AND created_at < $4
+			`, scope, key, statusCode, cutoff.UTC()); err != nil {
+				return nil, fmt.Errorf("failed to expire idempotency key: %w", err)
+			}
+			continue
+		}
+
+		switch {
+		case storedHash != requestHash:
+			return nil, ErrIdempotencyKeyReused
+		case statusCode == 0:
+			return nil, ErrIdempotencyKeyInUse
+		}
+		body, err = openIdempotentResponse(rawKey, scope, body)
+		if err != nil {
+			return nil, err
+		}
+		return &IdempotentResponse{StatusCode: statusCode, ContentType: contentType, Body: body}, nil
+	}
+}
+
+// expiry returns when a key with statusCode was created before it is
+// forgotten, if it ever is
+func (s *IdempotencyService) expiry(statusCode int) (time.Time, bool) {
+	if statusCode == 0 {
+		return s.now().Add(-idempotencyStaleAfter), true
+	}
+	if s.retention > 0 {
+		return s.now().Add(-s.retention), true
+	}
+	return time.Time{}, false
+}
+
+// Complete stores the response to the request that claimed key, which is
+// replayed to its retries
+func (s *IdempotencyService) Complete(ctx context.Context, scope, key string, response IdempotentResponse) error {
+	body, err := sealIdempotentResponse(key, scope, response.Body)
+	if err != nil {
+		return err
+	}
+	update := `
+		UPDATE idempotency_keys
+		SET status_code = $1, content_type = $2, response_body = $3
+		WHERE scope = $4 AND idempotency_key = $5
+	`
+	if _, err := s.db.ExecContext(ctx, update, response.StatusCode, response.ContentType, body, scope, hashIdempotencyKey(key)); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release forgets key, claimed by a request that failed, so it can be
+// retried
+func (s *IdempotencyService) Release(ctx context.Context, scope, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2 AND status_code = 0`, scope, hashIdempotencyKey(key)); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// hashIdempotencyKey returns the form key is stored in
+func hashIdempotencyKey(key string) string {
+	return hex.EncodeToString(deriveFromIdempotencyKey(key, "lookup"))
+}
+
+// deriveFromIdempotencyKey derives a 32 byte value for purpose from key
+func deriveFromIdempotencyKey(key, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// responseCipher returns the AEAD responses stored under key are sealed with
+func responseCipher(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveFromIdempotencyKey(key, "response"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create response cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealIdempotentResponse encrypts body for storage under key of scope,
+// prefixed with its nonce
+func sealIdempotentResponse(key, scope string, body []byte) ([]byte, error) {
+	aead, err := responseCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate response nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, body, []byte(scope)), nil
+}
+
+// openIdempotentResponse decrypts a body sealed by sealIdempotentResponse
+func openIdempotentResponse(key, scope string, sealed []byte) ([]byte, error) {
+	aead, err := responseCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("failed to decrypt stored response: too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	body, err := aead.Open(nil, nonce, ciphertext, []byte(scope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt stored response: %w", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyService(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	service := NewIdempotencyService(db, 24*time.Hour)
+
+	stored, err := service.Begin(ctx, "token:abc", "retry-1", "hash-1")
+	require.NoError(t, err)
+	assert.Nil(t, stored, "the first request is performed")
+
+	// Retries wait for the first request to finish
+	_, err = service.Begin(ctx, "token:abc", "retry-1", "hash-1")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyInUse)
+
+	response := IdempotentResponse{StatusCode: 201, ContentType: "application/json", Body: []byte(`{"api_key":"ak_123"}`)}
+	require.NoError(t, service.Complete(ctx, "token:abc", "retry-1", response))
+
+	stored, err = service.Begin(ctx, "token:abc", "retry-1", "hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, &response, stored)
+
+	// Neither the minted key nor the idempotency key is stored in plaintext
+	var storedKey string
+	var storedBody []byte
+	require.NoError(t, db.QueryRow(`SELECT idempotency_key, response_body FROM idempotency_keys WHERE scope = 'token:abc'`).Scan(&storedKey, &storedBody))
+	assert.NotEqual(t, "retry-1", storedKey)
+	assert.NotContains(t, string(storedBody), "ak_123")
+	assert.NotEmpty(t, storedBody)
+
+	_, err = service.Begin(ctx, "token:abc", "retry-1", "hash-2")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Keys are scoped to the caller
+	stored, err = service.Begin(ctx, "token:def", "retry-1", "hash-2")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// Released keys can be claimed again
+	require.NoError(t, service.Release(ctx, "token:def", "retry-1"))
+	stored, err = service.Begin(ctx, "token:def", "retry-1", "hash-3")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// Keys are forgotten after the retention period, and abandoned requests
+	// after a while
+	service.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	stored, err = service.Begin(ctx, "token:abc", "retry-1", "hash-2")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	stored, err = service.Begin(ctx, "token:def", "retry-1", "hash-4")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
@@ -2,19 +2,168 @@ package services
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"time"
 
+	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
 )
 
 // APIKeyServiceInterface defines the interface for API key operations
 type APIKeyServiceInterface interface {
-	ValidateAPIKey(apiKey string) (*database.APIKey, error)
-	CreateAPIKey(name string, rateLimitRequests int, rateLimitWindowSeconds int) (string, error)
-	DeactivateAPIKey(apiKey string) error
+	ValidateAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error)
+	CreateAPIKey(ctx context.Context, params CreateAPIKeyParams) (string, error)
+	CreateAPIKeys(ctx context.Context, params []CreateAPIKeyParams) ([]string, error)
+	ListAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*database.APIKey, error)
+	ListSubKeys(ctx context.Context, parentID string) ([]*database.APIKey, error)
+	GetAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error)
+	RenameAPIKey(ctx context.Context, apiKey string, name string) (*database.APIKey, error)
+	UpdateAPIKeyOwner(ctx context.Context, apiKey string, ownerName string, ownerEmail string) (*database.APIKey, error)
+	UpdateAPIKeyAlertThresholds(ctx context.Context, apiKey string, thresholds []int64) (*database.APIKey, error)
+	UpdateAPIKeyRefundLimit(ctx context.Context, apiKey string, limit int) (*database.APIKey, error)
+	UpdateAPIKeyLimitResponse(ctx context.Context, apiKey string, response *database.LimitResponse) (*database.APIKey, error)
+	DeleteAPIKey(ctx context.Context, apiKey string) error
+	RestoreAPIKey(ctx context.Context, apiKey string) (*database.APIKey, error)
+	PurgeAPIKey(ctx context.Context, apiKey string) (string, error)
+	CreateLimitOverride(ctx context.Context, apiKey string, rateLimitRequests int, expiresAt time.Time) (*database.LimitOverride, error)
+	RotateAPIKey(ctx context.Context, apiKey string, gracePeriod time.Duration) (*RotatedAPIKey, error)
+	ExportAPIKeys(ctx context.Context) ([]*database.ExportedAPIKey, error)
+	ImportAPIKeys(ctx context.Context, keys []*database.ExportedAPIKey, dryRun bool) (*ImportReport, error)
 }
 
 // RateLimitServiceInterface defines the interface for rate limiting operations
 type RateLimitServiceInterface interface {
 	CheckRateLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error)
 	GetRateLimitStatus(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error)
+	CheckEndUserLimit(ctx context.Context, apiKey *database.APIKey, endUserID string) (*RateLimitResult, error)
+	CheckOrganizationLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error)
+	CheckTokenLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error)
+	CheckUniqueLimit(ctx context.Context, apiKey *database.APIKey, rule config.UniqueLimitRule, value string) (*RateLimitResult, error)
+	RefundRateLimit(ctx context.Context, apiKey *database.APIKey, units int64) (*RefundResult, error)
+	ReserveRateLimit(ctx context.Context, apiKey *database.APIKey, units int64, ttl time.Duration) (*ReservationResult, error)
+	CommitReservation(ctx context.Context, apiKey *database.APIKey, id string) (*SettledReservation, error)
+	CancelReservation(ctx context.Context, apiKey *database.APIKey, id string) (*SettledReservation, error)
+	ClearKeyState(ctx context.Context, apiKeyID string) (int64, error)
+}
+
+// TokenValidator resolves tokens that stand in for an API key, such as
+// access tokens, to the key they authenticate as
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*database.APIKey, error)
+}
+
+// AccessTokenServiceInterface issues access tokens for API keys and
+// validates them
+type AccessTokenServiceInterface interface {
+	TokenValidator
+	IssueToken(apiKey *database.APIKey, scope TokenScope) (*AccessToken, error)
+	TTL() time.Duration
+}
+
+// IdempotencyStore remembers the responses to requests made with an
+// idempotency key, see IdempotencyService
+type IdempotencyStore interface {
+	Begin(ctx context.Context, scope, key, requestHash string) (*IdempotentResponse, error)
+	Complete(ctx context.Context, scope, key string, response IdempotentResponse) error
+	Release(ctx context.Context, scope, key string) error
+}
+
+// ResponseCacheInterface answers repeat GET requests with the responses
+// cached for the key, see ResponseCache
+type ResponseCacheInterface interface {
+	Lookup(ctx context.Context, apiKey *database.APIKey, request *http.Request) (*CachedResponse, error)
+	Store(ctx context.Context, apiKey *database.APIKey, request *http.Request, response *CachedResponse) error
+}
+
+// AdminRateLimiter throttles calls to the admin API per caller
+type AdminRateLimiter interface {
+	CheckAdminLimit(ctx context.Context, caller string) (*RateLimitResult, error)
+}
+
+// AuthFailureTracker locks out clients that keep presenting invalid API keys
+type AuthFailureTracker interface {
+	AuthLockout(ctx context.Context, clientIP string) (time.Duration, error)
+	RecordAuthFailure(ctx context.Context, clientIP string) (time.Duration, error)
+}
+
+// UsageRecorder records that an API key authenticated successfully
+type UsageRecorder interface {
+	RecordUse(apiKeyID string)
+}
+
+// UsageCounter counts requests served for an API key
+type UsageCounter interface {
+	IncrementUsage(ctx context.Context, apiKeyID string) error
+}
+
+// UsageLogger records authenticated requests for usage analytics
+type UsageLogger interface {
+	Log(entry database.UsageLog) bool
+}
+
+// UsageServiceInterface defines the interface for persistent usage counters
+type UsageServiceInterface interface {
+	UsageCounter
+	GetUsage(ctx context.Context, apiKeyID string, days int) (*database.KeyUsage, error)
+}
+
+// AnalyticsServiceInterface defines the interface for usage analytics
+type AnalyticsServiceInterface interface {
+	KeyAnalytics(ctx context.Context, apiKeyID string, query AnalyticsQuery) (*database.KeyAnalytics, error)
+	TopConsumers(ctx context.Context, query TopConsumersQuery) (*database.TopConsumers, error)
+}
+
+// ExportServiceInterface defines the interface for usage exports
+type ExportServiceInterface interface {
+	CreateExport(ctx context.Context, params CreateExportParams) (*database.UsageExport, error)
+	GetExport(ctx context.Context, id string) (*database.UsageExport, error)
+	ExportContent(ctx context.Context, id string) (*database.UsageExport, io.Reader, error)
+}
+
+// LimitAlerter raises alerts for keys whose requests were refused for
+// exceeding their rate limit or quota (events.RateLimitExceeded and
+// events.QuotaExceeded), or reached one of their alert thresholds
+// (events.LimitThresholdReached)
+type LimitAlerter interface {
+	NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *RateLimitResult) error
+}
+
+// WebhookServiceInterface defines the interface for limit alert webhooks
+type WebhookServiceInterface interface {
+	LimitAlerter
+	SetWebhook(ctx context.Context, apiKeyID, url string) (*database.Webhook, error)
+	GetWebhook(ctx context.Context, apiKeyID string) (*database.Webhook, error)
+	DeleteWebhook(ctx context.Context, apiKeyID string) error
+	ListDeliveries(ctx context.Context, apiKeyID string) ([]database.WebhookDelivery, error)
+}
+
+// FeatureFlagServiceInterface defines the interface for feature flags
+type FeatureFlagServiceInterface interface {
+	Enabled(name string) bool
+	List() []FeatureFlag
+	Set(ctx context.Context, name string, enabled bool) error
+	Reset(ctx context.Context, name string) error
+}
+
+// PlanServiceInterface defines the interface for plan management operations
+type PlanServiceInterface interface {
+	CreatePlan(ctx context.Context, plan *database.Plan) (*database.Plan, error)
+	GetPlan(ctx context.Context, id string) (*database.Plan, error)
+	ListPlans(ctx context.Context) ([]*database.Plan, error)
+	UpdatePlan(ctx context.Context, id string, plan *database.Plan) (*database.Plan, error)
+	DeletePlan(ctx context.Context, id string) error
+	ReassignPlan(ctx context.Context, id, targetID string, deletePlan bool) (int64, error)
+}
+
+// OrganizationServiceInterface defines the interface for managing tenants
+// and their projects
+type OrganizationServiceInterface interface {
+	CreateOrganization(ctx context.Context, organization *database.Organization) (*database.Organization, error)
+	GetOrganization(ctx context.Context, id string) (*database.Organization, error)
+	UpdateOrganization(ctx context.Context, id string, organization *database.Organization) (*database.Organization, error)
+	ListOrganizations(ctx context.Context) ([]*database.Organization, error)
+	CreateProject(ctx context.Context, organizationID, name string) (*database.Project, error)
+	GetProject(ctx context.Context, id string) (*database.Project, error)
+	ListProjects(ctx context.Context, organizationID string) ([]*database.Project, error)
 }
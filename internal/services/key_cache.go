@@ -0,0 +1,182 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/metrics"
+)
+
+// KeyInvalidator is told when keys change, so copies of them cached by
+// ValidateAPIKey are dropped instead of being used until they expire
+type KeyInvalidator interface {
+	// InvalidateAPIKey drops the key with ID id and its sub-keys
+	InvalidateAPIKey(ctx context.Context, id string)
+	// InvalidateAllAPIKeys drops every key, for changes that affect keys
+	// in bulk, such as a plan's limits
+	InvalidateAllAPIKeys(ctx context.Context)
+}
+
+// KeyCache is a size-bounded LRU cache of validated keys, which spares the
+// database a query per request. Entries are kept for at most the cache's
+// TTL and never past the expiry of the key or of its limit override, and are
+// dropped as soon as the key changes through a KeyInvalidator. Invalid keys
+// aren't cached, so revoked keys are never served from the cache after
+// their invalidation.
+type KeyCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	order   *list.List
+	entries map[string]*list.Element
+	// Lookups of the entries holding each key ID, as the key itself or as
+	// the parent of a sub-key
+	byKeyID map[string]map[string]struct{}
+	// Incremented on every invalidation, so lookups that started before one
+	// don't cache what they read
+	generation uint64
+}
+
+type keyCacheEntry struct {
+	lookup    string
+	apiKey    *database.APIKey
+	expiresAt time.Time
+}
+
+// NewKeyCache holds up to size keys for at most ttl
+func NewKeyCache(size int, ttl time.Duration) *KeyCache {
+	return &KeyCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		byKeyID: make(map[string]map[string]struct{}),
+	}
+}
+
+// Get returns a copy of the key cached under lookup, or nil
+func (c *KeyCache) Get(lookup string) *database.APIKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[lookup]
+	if !ok {
+		metrics.KeyCacheLookups.WithLabelValues("miss").Inc()
+		return nil
+	}
+	entry := element.Value.(*keyCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		metrics.KeyCacheLookups.WithLabelValues("miss").Inc()
+		return nil
+	}
+	c.order.MoveToFront(element)
+	metrics.KeyCacheLookups.WithLabelValues("hit").Inc()
+
+	apiKey := *entry.apiKey
+	return &apiKey
+}
+
+// Generation identifies the cache's state before a lookup; pass it to Put
+func (c *KeyCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Put caches a copy of apiKey under lookup, unless the cache was
+// invalidated since generation, when apiKey may already be stale
+func (c *KeyCache) Put(lookup string, apiKey *database.APIKey, generation uint64) {
+	expiresAt := c.now().Add(c.ttl)
+	for _, limit := range []*time.Time{apiKey.ExpiresAt, apiKey.ParentExpiresAt, apiKey.OverrideExpiresAt} {
+		if limit != nil && limit.Before(expiresAt) {
+			expiresAt = *limit
+		}
+	}
+	cached := *apiKey
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 || generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[lookup]; ok {
+		c.remove(element)
+	}
+
+	entry := &keyCacheEntry{lookup: lookup, apiKey: &cached, expiresAt: expiresAt}
+	c.entries[lookup] = c.order.PushFront(entry)
+	for _, id := range entryKeyIDs(entry) {
+		if c.byKeyID[id] == nil {
+			c.byKeyID[id] = make(map[string]struct{})
+		}
+		c.byKeyID[id][lookup] = struct{}{}
+	}
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	metrics.KeyCacheEntries.Set(float64(c.order.Len()))
+}
+
+// InvalidateAPIKey implements KeyInvalidator
+func (c *KeyCache) InvalidateAPIKey(ctx context.Context, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for lookup := range c.byKeyID[id] {
+		if element, ok := c.entries[lookup]; ok {
+			c.remove(element)
+		}
+	}
+	metrics.KeyCacheEntries.Set(float64(c.order.Len()))
+	metrics.KeyCacheInvalidations.WithLabelValues("key").Inc()
+}
+
+// InvalidateAllAPIKeys implements KeyInvalidator
+func (c *KeyCache) InvalidateAllAPIKeys(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byKeyID = make(map[string]map[string]struct{})
+	metrics.KeyCacheEntries.Set(0)
+	metrics.KeyCacheInvalidations.WithLabelValues("all").Inc()
+}
+
+// Len returns the number of cached keys
+func (c *KeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops element; the caller holds mu
+func (c *KeyCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*keyCacheEntry)
+	delete(c.entries, entry.lookup)
+	for _, id := range entryKeyIDs(entry) {
+		delete(c.byKeyID[id], entry.lookup)
+		if len(c.byKeyID[id]) == 0 {
+			delete(c.byKeyID, id)
+		}
+	}
+}
+
+// entryKeyIDs lists the keys whose changes invalidate entry
+func entryKeyIDs(entry *keyCacheEntry) []string {
+	if entry.apiKey.ParentID != "" {
+		return []string{entry.apiKey.ID, entry.apiKey.ParentID}
+	}
+	return []string{entry.apiKey.ID}
+}
+
+// Ensure KeyCache implements KeyInvalidator
+var _ KeyInvalidator = (*KeyCache)(nil)
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts the validations that reach the repository
+type countingRepository struct {
+	repository.APIKeyRepository
+	lookups int
+}
+
+func (r *countingRepository) FindValid(ctx context.Context, hashes []string) (*database.APIKey, error) {
+	r.lookups++
+	return r.APIKeyRepository.FindValid(ctx, hashes)
+}
+
+func (r *countingRepository) FindValidByID(ctx context.Context, id string) (*database.APIKey, error) {
+	r.lookups++
+	return r.APIKeyRepository.FindValidByID(ctx, id)
+}
+
+func TestKeyCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewKeyCache(2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Put("a", &database.APIKey{ID: "1", Name: "first"}, cache.Generation())
+	cached := cache.Get("a")
+	require.NotNil(t, cached)
+	assert.Equal(t, "first", cached.Name)
+
+	// Callers get copies they may change
+	cached.Name = "changed"
+	assert.Equal(t, "first", cache.Get("a").Name)
+
+	// The least recently used key is evicted
+	cache.Put("b", &database.APIKey{ID: "2"}, cache.Generation())
+	cache.Get("a")
+	cache.Put("c", &database.APIKey{ID: "3"}, cache.Generation())
+	assert.Nil(t, cache.Get("b"))
+	assert.NotNil(t, cache.Get("a"))
+	assert.Equal(t, 2, cache.Len())
+
+	// Keys are kept for the TTL, and never past their expiry
+	expiresAt := now.Add(10 * time.Second)
+	cache.Put("d", &database.APIKey{ID: "4", ExpiresAt: &expiresAt}, cache.Generation())
+	now = now.Add(30 * time.Second)
+	assert.Nil(t, cache.Get("d"))
+	assert.NotNil(t, cache.Get("a"))
+	now = now.Add(time.Minute)
+	assert.Nil(t, cache.Get("a"))
+
+	// Invalidating a key drops its sub-keys, and what was read before the
+	// invalidation isn't cached
+	cache.Put("parent", &database.APIKey{ID: "5"}, cache.Generation())
+	cache.Put("child", &database.APIKey{ID: "6", ParentID: "5"}, cache.Generation())
+	generation := cache.Generation()
+	cache.InvalidateAPIKey(ctx, "5")
+	assert.Nil(t, cache.Get("parent"))
+	assert.Nil(t, cache.Get("child"))
+	cache.Put("parent", &database.APIKey{ID: "5"}, generation)
+	assert.Nil(t, cache.Get("parent"))
+
+	cache.Put("parent", &database.APIKey{ID: "5"}, cache.Generation())
+	cache.InvalidateAllAPIKeys(ctx)
+	assert.Zero(t, cache.Len())
+}
+
+func TestAPIKeyService_KeyCache(t *testing.T) {
+	ctx := context.Background()
+	keys := &countingRepository{APIKeyRepository: repository.NewMemoryAPIKeyRepository()}
+	service := NewAPIKeyService(keys, WithKeyCache(NewKeyCache(100, time.Minute)))
+
+	apiKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Mobile app", RateLimitRequests: 10, RateLimitWindowSeconds: 60})
+	require.NoError(t, err)
+	record, err := service.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+
+	// Repeat validations are served from the cache
+	for i := 0; i < 3; i++ {
+		_, err = service.ValidateAPIKey(ctx, apiKey)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, keys.lookups)
+
+	// Changes take effect right away
+	_, err = service.RenameAPIKey(ctx, record.ID, "Web app")
+	require.NoError(t, err)
+	record, err = service.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, "Web app", record.Name)
+	assert.Equal(t, 2, keys.lookups)
+
+	// The previous secret of a rotated key is checked on every use
+	rotated, err := service.RotateAPIKey(ctx, record.ID, time.Hour)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = service.ValidateAPIKey(ctx, apiKey)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 4, keys.lookups)
+
+	// Deactivating a key revokes its sub-keys too
+	subKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Worker", ParentID: record.ID})
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(ctx, subKey)
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(ctx, rotated.APIKey)
+	require.NoError(t, err)
+	require.NoError(t, service.DeleteAPIKey(ctx, record.ID))
+	_, err = service.ValidateAPIKey(ctx, rotated.APIKey)
+	assert.Error(t, err)
+	_, err = service.ValidateAPIKey(ctx, subKey)
+	assert.Error(t, err)
+}
+
+func TestAPIKeyService_KeyCacheParentExpiry(t *testing.T) {
+	ctx := context.Background()
+	keys := &countingRepository{APIKeyRepository: repository.NewMemoryAPIKeyRepository()}
+	cache := NewKeyCache(100, time.Minute)
+	service := NewAPIKeyService(keys, WithKeyCache(cache))
+
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	parent, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Contract", RateLimitRequests: 10, RateLimitWindowSeconds: 60, ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	record, err := service.ValidateAPIKey(ctx, parent)
+	require.NoError(t, err)
+	subKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Worker", ParentID: record.ID})
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(ctx, subKey)
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(ctx, subKey)
+	require.NoError(t, err)
+	assert.Equal(t, 2, keys.lookups)
+
+	// The sub-key isn't served from the cache once its parent has expired,
+	// well before the cache's TTL, and is refused
+	time.Sleep(time.Until(expiresAt) + 10*time.Millisecond)
+	_, err = service.ValidateAPIKey(ctx, subKey)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	assert.Equal(t, 3, keys.lookups)
+}
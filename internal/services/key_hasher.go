@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Hash versions stored in api_keys.hash_version
+const (
+	HashVersionSHA256     = 1
+	HashVersionHMACSHA256 = 2
+	HashVersionArgon2id   = 3
+)
+
+// Supported values of API_KEY_HASH_ALGORITHM
+const (
+	HashAlgorithmSHA256     = "sha256"
+	HashAlgorithmHMACSHA256 = "hmac-sha256"
+	HashAlgorithmArgon2id   = "argon2id"
+)
+
+// Argon2id cost parameters (OWASP minimum: 19 MiB, 2 iterations). The hash
+// runs on every validation, so these trade resistance to offline cracking
+// for per-request latency.
+const (
+	argon2idTime    = 2
+	argon2idMemory  = 19 * 1024
+	argon2idThreads = 1
+	argon2idKeyLen  = 32
+)
+
+// KeyHasher turns an API key into the value stored in api_keys.key_hash.
+// Hashes must be deterministic so keys can be looked up by hash.
+type KeyHasher interface {
+	Version() int
+	Hash(apiKey string) string
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Version() int { return HashVersionSHA256 }
+
+func (sha256Hasher) Hash(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%x", hash)
+}
+
+// hmacHasher keys SHA-256 with a server-side pepper, so a leaked database
+// alone is not enough to test guessed keys
+type hmacHasher struct {
+	pepper []byte
+}
+
+func (hmacHasher) Version() int { return HashVersionHMACSHA256 }
+
+func (h hmacHasher) Hash(apiKey string) string {
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(apiKey))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// argon2idHasher uses the pepper as a fixed salt. Per-key salts would rule
+// out lookup by hash, and generated keys carry enough entropy that the
+// memory-hard cost matters mostly for legacy low-entropy keys.
+type argon2idHasher struct {
+	pepper []byte
+}
+
+func (argon2idHasher) Version() int { return HashVersionArgon2id }
+
+func (h argon2idHasher) Hash(apiKey string) string {
+	hash := argon2.IDKey([]byte(apiKey), h.pepper, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeyLen)
+	return fmt.Sprintf("%x", hash)
+}
+
+// KeyHashing hashes new keys with the configured algorithm while still
+// recognising keys stored under older ones, so existing keys keep working
+// and are upgraded as they are used.
+type KeyHashing struct {
+	current KeyHasher
+	hashers []KeyHasher
+}
+
+// DefaultKeyHashing is plain SHA-256, the scheme keys were originally stored with
+func DefaultKeyHashing() *KeyHashing {
+	return &KeyHashing{current: sha256Hasher{}, hashers: []KeyHasher{sha256Hasher{}}}
+}
+
+// NewKeyHashing builds the hashing scheme for algorithm. The HMAC and
+// argon2id algorithms require a pepper; when one is set, HMAC hashes are
+// always recognised so keys can move from hmac-sha256 to argon2id.
+func NewKeyHashing(algorithm, pepper string) (*KeyHashing, error) {
+	if algorithm == "" || algorithm == HashAlgorithmSHA256 {
+		hashing := DefaultKeyHashing()
+		if pepper != "" {
+			hashing.hashers = append(hashing.hashers, hmacHasher{pepper: []byte(pepper)})
+		}
+		return hashing, nil
+	}
+
+	if algorithm != HashAlgorithmHMACSHA256 && algorithm != HashAlgorithmArgon2id {
+		return nil, fmt.Errorf("unsupported API key hash algorithm %q", algorithm)
+	}
+	if pepper == "" {
+		return nil, fmt.Errorf("API key hash algorithm %q requires a pepper", algorithm)
+	}
+
+	hashing := &KeyHashing{
+		current: hmacHasher{pepper: []byte(pepper)},
+		hashers: []KeyHasher{sha256Hasher{}, hmacHasher{pepper: []byte(pepper)}},
+	}
+	if algorithm == HashAlgorithmArgon2id {
+		hashing.current = argon2idHasher{pepper: []byte(pepper)}
+		hashing.hashers = append(hashing.hashers, hashing.current)
+	}
+	return hashing, nil
+}
+
+// Version returns the hash version new keys are stored with
+func (h *KeyHashing) Version() int {
+	return h.current.Version()
+}
+
+// Hash hashes apiKey with the current algorithm
+func (h *KeyHashing) Hash(apiKey string) string {
+	return h.current.Hash(apiKey)
+}
+
+// Candidates returns the hash of apiKey under every recognised version
+func (h *KeyHashing) Candidates(apiKey string) map[int]string {
+	candidates := make(map[int]string, len(h.hashers))
+	for _, hasher := range h.hashers {
+		candidates[hasher.Version()] = hasher.Hash(apiKey)
+	}
+	return candidates
+}
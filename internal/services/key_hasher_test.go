@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeyHashing(t *testing.T) {
+	hashing, err := NewKeyHashing("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, HashVersionSHA256, hashing.Version())
+	assert.Len(t, hashing.Candidates("ak_test"), 1)
+
+	hashing, err = NewKeyHashing(HashAlgorithmHMACSHA256, "pepper")
+	assert.NoError(t, err)
+	assert.Equal(t, HashVersionHMACSHA256, hashing.Version())
+	assert.Len(t, hashing.Candidates("ak_test"), 2)
+
+	hashing, err = NewKeyHashing(HashAlgorithmArgon2id, "pepper")
+	assert.NoError(t, err)
+	assert.Equal(t, HashVersionArgon2id, hashing.Version())
+	assert.Len(t, hashing.Candidates("ak_test"), 3)
+
+	_, err = NewKeyHashing(HashAlgorithmHMACSHA256, "")
+	assert.Error(t, err)
+
+	_, err = NewKeyHashing("md5", "pepper")
+	assert.Error(t, err)
+}
+
+func TestKeyHashing_Hash(t *testing.T) {
+	hashing, err := NewKeyHashing(HashAlgorithmArgon2id, "pepper")
+	assert.NoError(t, err)
+
+	candidates := hashing.Candidates("ak_test")
+	assert.Equal(t, hashing.Hash("ak_test"), candidates[HashVersionArgon2id])
+	assert.Equal(t, DefaultKeyHashing().Hash("ak_test"), candidates[HashVersionSHA256])
+	assert.NotEqual(t, candidates[HashVersionSHA256], candidates[HashVersionHMACSHA256])
+	assert.NotEqual(t, candidates[HashVersionHMACSHA256], candidates[HashVersionArgon2id])
+
+	// Hashes are deterministic so keys can be looked up by hash
+	assert.Equal(t, hashing.Hash("ak_test"), hashing.Hash("ak_test"))
+
+	other, err := NewKeyHashing(HashAlgorithmHMACSHA256, "other-pepper")
+	assert.NoError(t, err)
+	assert.NotEqual(t, candidates[HashVersionHMACSHA256], other.Hash("ak_test"))
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// keyInvalidationRetryDelay is how long KeyInvalidationBroadcaster.Run waits
+// before listening again after losing its subscription
+const keyInvalidationRetryDelay = time.Second
+
+// MessageChannel carries messages between instances; redis.Client is one
+type MessageChannel interface {
+	// PublishMessage delivers payload to every listener of channel
+	PublishMessage(ctx context.Context, channel, payload string) error
+	// Listen calls onMessage with the messages published to channel from
+	// the moment onSubscribe is called, until ctx is cancelled or the
+	// connection fails
+	Listen(ctx context.Context, channel string, onSubscribe func(), onMessage func(payload string)) error
+}
+
+// keyInvalidation is the message sent for an invalidation; without an
+// APIKeyID every key is invalidated
+type keyInvalidation struct {
+	Origin   string `json:"origin"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+}
+
+// KeyInvalidationBroadcaster is the KeyInvalidator of instances that share
+// a message channel: invalidations drop keys from the local cache right
+// away and are published, so the other instances drop them as well. Run
+// applies the invalidations published by the other instances.
+//
+// Messages sent while an instance isn't subscribed are lost, so it empties
+// its cache whenever it (re)subscribes; while it is disconnected, changes
+// reach it within the cache's TTL.
+type KeyInvalidationBroadcaster struct {
+	cache   *KeyCache
+	channel MessageChannel
+	name    string
+	origin  string
+}
+
+// NewKeyInvalidationBroadcaster invalidates keys in cache and publishes the
+// invalidations to channel name
+func NewKeyInvalidationBroadcaster(cache *KeyCache, channel MessageChannel, name string) *KeyInvalidationBroadcaster {
+	origin := make([]byte, 8)
+	_, _ = rand.Read(origin)
+	return &KeyInvalidationBroadcaster{cache: cache, channel: channel, name: name, origin: hex.EncodeToString(origin)}
+}
+
+// InvalidateAPIKey implements KeyInvalidator
+func (b *KeyInvalidationBroadcaster) InvalidateAPIKey(ctx context.Context, id string) {
+	b.cache.InvalidateAPIKey(ctx, id)
+	b.publish(ctx, keyInvalidation{Origin: b.origin, APIKeyID: id})
+}
+
+// InvalidateAllAPIKeys implements KeyInvalidator
+func (b *KeyInvalidationBroadcaster) InvalidateAllAPIKeys(ctx context.Context) {
+	b.cache.InvalidateAllAPIKeys(ctx)
+	b.publish(ctx, keyInvalidation{Origin: b.origin})
+}
+
+// publish tells the other instances about an invalidation. The local cache
+// is already up to date, so failures are only logged; the other instances
+// pick up the change within the cache's TTL.
+func (b *KeyInvalidationBroadcaster) publish(ctx context.Context, invalidation keyInvalidation) {
+	payload, err := json.Marshal(invalidation)
+	if err == nil {
+		err = b.channel.PublishMessage(ctx, b.name, string(payload))
+	}
+	if err != nil {
+		metrics.KeyCacheInvalidationsPublished.WithLabelValues("failed").Inc()
+		logging.FromContext(ctx).Error("Failed to publish key invalidation", zap.String("api_key_id", invalidation.APIKeyID), zap.Error(err))
+		return
+	}
+	metrics.KeyCacheInvalidationsPublished.WithLabelValues("published").Inc()
+}
+
+// Run applies the invalidations of the other instances until ctx is
+// cancelled, subscribing again whenever the subscription is lost
+func (b *KeyInvalidationBroadcaster) Run(ctx context.Context) {
+	for {
+		err := b.channel.Listen(ctx, b.name, func() {
+			// Invalidations may have been missed while not subscribed
+			b.cache.InvalidateAllAPIKeys(ctx)
+		}, func(payload string) {
+			b.receive(ctx, payload)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logging.FromContext(ctx).Warn("Lost key invalidation subscription", zap.String("channel", b.name), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(keyInvalidationRetryDelay):
+		}
+	}
+}
+
+// receive applies an invalidation published by another instance
+func (b *KeyInvalidationBroadcaster) receive(ctx context.Context, payload string) {
+	var invalidation keyInvalidation
+	if err := json.Unmarshal([]byte(payload), &invalidation); err != nil {
+		logging.FromContext(ctx).Warn("Ignoring malformed key invalidation", zap.String("channel", b.name), zap.Error(err))
+		return
+	}
+	switch {
+	case invalidation.Origin == b.origin:
+		// Applied when it was published
+	case invalidation.APIKeyID == "":
+		b.cache.InvalidateAllAPIKeys(ctx)
+	default:
+		b.cache.InvalidateAPIKey(ctx, invalidation.APIKeyID)
+	}
+}
+
+// Ensure KeyInvalidationBroadcaster implements KeyInvalidator
+var _ KeyInvalidator = (*KeyInvalidationBroadcaster)(nil)
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryChannel delivers messages to the listeners of a channel in-process
+type memoryChannel struct {
+	mu        sync.Mutex
+	listeners map[string][]func(string)
+}
+
+func (m *memoryChannel) PublishMessage(ctx context.Context, channel, payload string) error {
+	m.mu.Lock()
+	listeners := append([]func(string){}, m.listeners[channel]...)
+	m.mu.Unlock()
+	for _, listener := range listeners {
+		listener(payload)
+	}
+	return nil
+}
+
+func (m *memoryChannel) Listen(ctx context.Context, channel string, onSubscribe func(), onMessage func(payload string)) error {
+	m.mu.Lock()
+	if m.listeners == nil {
+		m.listeners = make(map[string][]func(string))
+	}
+	m.listeners[channel] = append(m.listeners[channel], onMessage)
+	m.mu.Unlock()
+	onSubscribe()
+	<-ctx.Done()
+	return nil
+}
+
+func (m *memoryChannel) subscribers(channel string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.listeners[channel])
+}
+
+func TestKeyInvalidationBroadcaster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	channel := &memoryChannel{}
+	caches := []*KeyCache{NewKeyCache(10, time.Minute), NewKeyCache(10, time.Minute)}
+	broadcasters := make([]*KeyInvalidationBroadcaster, len(caches))
+	for i, cache := range caches {
+		broadcasters[i] = NewKeyInvalidationBroadcaster(cache, channel, "key_invalidations")
+		go broadcasters[i].Run(ctx)
+	}
+	assert.Eventually(t, func() bool { return channel.subscribers("key_invalidations") == 2 }, time.Second, time.Millisecond)
+
+	fill := func() {
+		for _, cache := range caches {
+			cache.Put("a", &database.APIKey{ID: "1"}, cache.Generation())
+			cache.Put("b", &database.APIKey{ID: "2"}, cache.Generation())
+		}
+	}
+
+	// An invalidation on one instance reaches every instance
+	fill()
+	broadcasters[0].InvalidateAPIKey(ctx, "1")
+	for _, cache := range caches {
+		assert.Nil(t, cache.Get("a"))
+		assert.NotNil(t, cache.Get("b"))
+	}
+
+	fill()
+	broadcasters[1].InvalidateAllAPIKeys(ctx)
+	for _, cache := range caches {
+		assert.Zero(t, cache.Len())
+	}
+
+	// Malformed messages are ignored
+	fill()
+	assert.NoError(t, channel.PublishMessage(ctx, "key_invalidations", "not json"))
+	assert.Equal(t, 2, caches[0].Len())
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/repository"
+)
+
+// minProvisionedSecretLength keeps declared secrets from being guessable
+const minProvisionedSecretLength = 24
+
+// ProvisionedKey is the declared state of a key, e.g. from a provisioning
+// file. Settings left empty are cleared on the stored key.
+type ProvisionedKey struct {
+	ID string
+	// The API key itself. It is required to create the key; for a key that
+	// exists, a secret that doesn't match the stored one replaces it, and
+	// an empty one keeps it.
+	Secret                    string
+	Name                      string
+	Plan                      string
+	RateLimitRequests         int
+	RateLimitWindowSeconds    int
+	EndUserLimitRequests      int
+	EndUserLimitWindowSeconds int
+	AllowedCIDRs              []string
+	AllowedOrigins            []string
+	ParentID                  string
+	OwnerName                 string
+	OwnerEmail                string
+	ExpiresAt                 *time.Time
+}
+
+// ProvisionReport lists the IDs of the keys ProvisionAPIKeys created,
+// updated, and found as declared
+type ProvisionReport struct {
+	Created   []string
+	Updated   []string
+	Unchanged []string
+}
+
+// ProvisionAPIKeys brings the stored keys in line with keys: missing keys
+// are created under their declared ID and secret, and keys whose settings
+// differ are updated. Keys that aren't declared are left alone, as is
+// whether a key is active. Everything happens in one transaction, so an
+// error leaves the keys as they were.
+func (s *APIKeyService) ProvisionAPIKeys(ctx context.Context, keys []ProvisionedKey) (*ProvisionReport, error) {
+	report := &ProvisionReport{Created: []string{}, Updated: []string{}, Unchanged: []string{}}
+	var changed []events.Event
+	err := s.keys.InTx(ctx, func(tx repository.APIKeyRepository) error {
+		exported, err := tx.Export(ctx)
+		if err != nil {
+			return err
+		}
+		stored := make(map[string]*database.ExportedAPIKey, len(exported))
+		for _, key := range exported {
+			stored[key.ID] = key
+		}
+
+		// Parents are stored before the sub-keys that reference them
+		ordered := append([]ProvisionedKey(nil), keys...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].ParentID == "" && ordered[j].ParentID != ""
+		})
+
+		declared := make(map[string]bool, len(ordered))
+		for i := range ordered {
+			key := &ordered[i]
+			if declared[key.ID] {
+				return fmt.Errorf("key %s is declared more than once", key.ID)
+			}
+			declared[key.ID] = true
+
+			event, err := s.provisionAPIKey(ctx, tx, key, stored[key.ID], report)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.ID, err)
+			}
+			if event != nil {
+				changed = append(changed, *event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision API keys: %w", err)
+	}
+	for _, event := range changed {
+		s.invalidate(ctx, event.APIKeyID)
+		s.publish(ctx, event)
+	}
+	return report, nil
+}
+
+// provisionAPIKey creates or updates one declared key and returns the event
+// to publish once the transaction commits, or nil if nothing changed
+func (s *APIKeyService) provisionAPIKey(ctx context.Context, tx repository.APIKeyRepository, key *ProvisionedKey, current *database.ExportedAPIKey, report *ProvisionReport) (*events.Event, error) {
+	desired, err := s.provisionedSettings(key, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && sameKeySettings(current, desired) {
+		report.Unchanged = append(report.Unchanged, key.ID)
+		return nil, nil
+	}
+	if current == nil || current.KeyHash != desired.KeyHash {
+		existing, err := tx.Get(ctx, repository.KeyRef{Hashes: sortedHashes(s.hashing.Candidates(key.Secret))})
+		if err == nil && existing.ID != key.ID {
+			return nil, fmt.Errorf("secret belongs to key %s", existing.ID)
+		} else if err != nil && !errors.Is(err, repository.ErrAPIKeyNotFound) {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{"key_prefix": desired.KeyPrefix, "name": desired.Name, "provisioned": true}
+	if current == nil {
+		if err := tx.Import(ctx, desired); err != nil {
+			return nil, err
+		}
+		report.Created = append(report.Created, key.ID)
+		event := keyEvent(events.APIKeyCreated, key.ID, data)
+		return &event, nil
+	}
+
+	if err := tx.Update(ctx, desired); err != nil {
+		return nil, err
+	}
+	report.Updated = append(report.Updated, key.ID)
+	data["secret_replaced"] = current.KeyHash != desired.KeyHash
+	event := keyEvent(events.APIKeyUpdated, key.ID, data)
+	return &event, nil
+}
+
+// provisionedSettings validates key and returns the stored form it
+// declares, based on current when the key exists
+func (s *APIKeyService) provisionedSettings(key *ProvisionedKey, current *database.ExportedAPIKey) (*database.ExportedAPIKey, error) {
+	switch {
+	case !uuidPattern.MatchString(key.ID):
+		return nil, fmt.Errorf("id must be a UUID")
+	case key.Name == "":
+		return nil, fmt.Errorf("name is required")
+	case key.ParentID != "" && !uuidPattern.MatchString(key.ParentID):
+		return nil, fmt.Errorf("parent_id must be a UUID")
+	case key.ParentID == key.ID:
+		return nil, fmt.Errorf("a key can't be its own parent")
+	case key.RateLimitRequests < 0 || key.RateLimitWindowSeconds < 0 || key.EndUserLimitRequests < 0 || key.EndUserLimitWindowSeconds < 0:
+		return nil, fmt.Errorf("limits must not be negative")
+	case current == nil && key.Secret == "":
+		return nil, fmt.Errorf("a secret is required to create the key")
+	case key.Secret != "" && len(key.Secret) < minProvisionedSecretLength:
+		return nil, fmt.Errorf("secret must be at least %d characters", minProvisionedSecretLength)
+	case looksLikeChecksummedKey(key.Secret) && !VerifyAPIKeyChecksum(key.Secret):
+		return nil, fmt.Errorf("secret has an invalid checksum")
+	}
+
+	allowedCIDRs, err := NormalizeCIDRs(key.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	allowedOrigins, err := NormalizeOrigins(key.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := &database.ExportedAPIKey{IsActive: true, CreatedAt: time.Now().UTC()}
+	if current != nil {
+		stored := *current
+		desired = &stored
+	}
+	desired.ID = key.ID
+	desired.Name = key.Name
+	desired.Plan = key.Plan
+	desired.RateLimitRequests = key.RateLimitRequests
+	desired.RateLimitWindowSeconds = key.RateLimitWindowSeconds
+	desired.EndUserLimitRequests = key.EndUserLimitRequests
+	desired.EndUserLimitWindowSeconds = key.EndUserLimitWindowSeconds
+	desired.AllowedCIDRs = allowedCIDRs
+	desired.AllowedOrigins = allowedOrigins
+	desired.ParentID = key.ParentID
+	desired.OwnerName = key.OwnerName
+	desired.OwnerEmail = key.OwnerEmail
+	desired.ExpiresAt = key.ExpiresAt
+
+	// A secret that still matches is kept under its hash version; keys on
+	// an older version are upgraded on first use as usual
+	if key.Secret != "" && (current == nil || s.hashing.Candidates(key.Secret)[current.HashVersion] != current.KeyHash) {
+		desired.KeyHash = s.hashAPIKey(key.Secret)
+		desired.HashVersion = s.hashing.Version()
+		desired.KeyPrefix = KeyPrefix(key.Secret)
+	}
+	return desired, nil
+}
+
+// sameKeySettings reports whether a and b differ in nothing Update changes
+func sameKeySettings(a, b *database.ExportedAPIKey) bool {
+	return a.KeyHash == b.KeyHash &&
+		a.Name == b.Name &&
+		a.Plan == b.Plan &&
+		a.RateLimitRequests == b.RateLimitRequests &&
+		a.RateLimitWindowSeconds == b.RateLimitWindowSeconds &&
+		a.EndUserLimitRequests == b.EndUserLimitRequests &&
+		a.EndUserLimitWindowSeconds == b.EndUserLimitWindowSeconds &&
+		sameStrings(a.AllowedCIDRs, b.AllowedCIDRs) &&
+		sameStrings(a.AllowedOrigins, b.AllowedOrigins) &&
+		a.ParentID == b.ParentID &&
+		a.OwnerName == b.OwnerName &&
+		a.OwnerEmail == b.OwnerEmail &&
+		sameTime(a.ExpiresAt, b.ExpiresAt)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+)
+
+// ImportReport describes the outcome of ImportAPIKeys. If any key has an
+// error nothing is imported.
+type ImportReport struct {
+	DryRun bool `json:"dry_run"`
+
+	// Keys imported, or on a dry run the keys that would be
+	Imported int `json:"imported"`
+
+	// IDs of keys that already exist with the same hash and were left alone
+	Skipped []string `json:"skipped"`
+
+	Errors []ImportError `json:"errors"`
+}
+
+// ImportError is a key that can't be imported
+type ImportError struct {
+	Index   int    `json:"index"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// errImportRolledBack ends the import transaction without committing it
+var errImportRolledBack = errors.New("import rolled back")
+
+// ExportAPIKeys returns every key with its stored hash, parents before their
+// sub-keys, in the form ImportAPIKeys accepts
+func (s *APIKeyService) ExportAPIKeys(ctx context.Context) ([]*database.ExportedAPIKey, error) {
+	var exported []*database.ExportedAPIKey
+	err := s.withRetry(ctx, func() (err error) {
+		exported, err = s.keys.Export(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export API keys: %w", err)
+	}
+	return exported, nil
+}
+
+// ImportAPIKeys restores exported keys under their original IDs and hashes.
+// Keys that already exist with the same hash are skipped, so an export can
+// be imported again. The keys are imported in one transaction: if any of
+// them has an error, the report lists every error and nothing is imported.
+// A dry run checks the keys against the database the same way, then rolls
+// back.
+//
+// Keys that require signed requests are rejected, since their signing
+// secrets aren't exported; importing them without one would let unsigned
+// requests through.
+func (s *APIKeyService) ImportAPIKeys(ctx context.Context, keys []*database.ExportedAPIKey, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun, Skipped: []string{}, Errors: []ImportError{}}
+	reject := func(i int, format string, args ...interface{}) {
+		report.Errors = append(report.Errors, ImportError{Index: i, ID: keys[i].ID, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ids := make(map[string]bool, len(keys))
+	hashes := make(map[string]bool, len(keys))
+	valid := make([]bool, len(keys))
+	for i, key := range keys {
+		if err := normalizeExportedKey(key); err != nil {
+			reject(i, "%s", err)
+			continue
+		}
+		if ids[key.ID] || hashes[key.KeyHash] {
+			reject(i, "duplicate key in import")
+			continue
+		}
+		ids[key.ID], hashes[key.KeyHash] = true, true
+		valid[i] = true
+	}
+
+	err := s.keys.InTx(ctx, func(tx repository.APIKeyRepository) error {
+		for i, key := range keys {
+			if !valid[i] {
+				continue
+			}
+			existing, err := tx.Get(ctx, repository.KeyRef{Hashes: []string{key.KeyHash}})
+			switch {
+			case err == nil && existing.ID == key.ID:
+				report.Skipped = append(report.Skipped, key.ID)
+				continue
+			case err == nil:
+				reject(i, "key hash belongs to key %s", existing.ID)
+				continue
+			case !errors.Is(err, repository.ErrAPIKeyNotFound):
+				return err
+			}
+			if _, err := tx.Get(ctx, repository.KeyRef{ID: key.ID}); err == nil {
+				reject(i, "key %s already exists with a different secret", key.ID)
+				continue
+			} else if !errors.Is(err, repository.ErrAPIKeyNotFound) {
+				return err
+			}
+
+			if err := tx.Import(ctx, key); err != nil {
+				reject(i, "%s", err)
+				continue
+			}
+			report.Imported++
+		}
+		if len(report.Errors) > 0 || dryRun {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportRolledBack) {
+		return nil, fmt.Errorf("failed to import API keys: %w", err)
+	}
+	if len(report.Errors) > 0 {
+		report.Imported = 0
+	}
+	return report, nil
+}
+
+// normalizeExportedKey checks what the database can't, and normalizes the
+// allowlists as key creation does
+func normalizeExportedKey(key *database.ExportedAPIKey) error {
+	switch {
+	case !uuidPattern.MatchString(key.ID):
+		return fmt.Errorf("id must be a UUID")
+	case key.KeyHash == "":
+		return fmt.Errorf("key_hash is required")
+	case key.Name == "":
+		return fmt.Errorf("name is required")
+	case key.RequireSignature:
+		return fmt.Errorf("keys that require signed requests can't be imported, their signing secret isn't exported")
+	case key.ParentID != "" && !uuidPattern.MatchString(key.ParentID):
+		return fmt.Errorf("parent_id must be a UUID")
+	case key.RateLimitRequests < 0 || key.RateLimitWindowSeconds < 0 || key.EndUserLimitRequests < 0 || key.EndUserLimitWindowSeconds < 0:
+		return fmt.Errorf("limits must not be negative")
+	case key.CreatedAt.IsZero():
+		return fmt.Errorf("created_at is required")
+	}
+	if key.HashVersion == 0 {
+		key.HashVersion = 1
+	}
+
+	var err error
+	if key.AllowedCIDRs, err = NormalizeCIDRs(key.AllowedCIDRs); err != nil {
+		return err
+	}
+	if key.AllowedOrigins, err = NormalizeOrigins(key.AllowedOrigins); err != nil {
+		return err
+	}
+	return nil
+}
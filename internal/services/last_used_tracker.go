@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// finalFlushTimeout bounds the flush at shutdown, and each write when uses
+// are written through
+const finalFlushTimeout = 5 * time.Second
+
+// LastUsedTracker collects successful authentications in memory and writes
+// each key's latest use to api_keys.last_used_at in periodic batches, keeping
+// the write off the request path. With a non-positive interval there are no
+// batches: each use is written as it is recorded.
+type LastUsedTracker struct {
+	db       database.DBInterface
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func NewLastUsedTracker(db database.DBInterface, interval time.Duration) *LastUsedTracker {
+	return &LastUsedTracker{
+		db:       db,
+		interval: interval,
+		pending:  make(map[string]time.Time),
+	}
+}
+
+// RecordUse notes that the key was used now. It only blocks on the database
+// when uses are written through, i.e. the interval is not positive.
+func (t *LastUsedTracker) RecordUse(apiKeyID string) {
+	t.mu.Lock()
+	t.pending[apiKeyID] = time.Now()
+	t.mu.Unlock()
+
+	if t.interval <= 0 {
+		// Nothing else drains pending, so flush it now; a failed write is
+		// retried with the next use
+		ctx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+		defer cancel()
+		if _, err := t.Flush(ctx); err != nil {
+			logging.FromContext(ctx).Error("Last-used write failed", zap.Error(err))
+		}
+	}
+}
+
+// Run flushes on every tick until ctx is cancelled, then flushes once more.
+// It returns at once when uses are written through.
+func (t *LastUsedTracker) Run(ctx context.Context) {
+	if t.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, so the final flush gets its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			_, err := t.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				logging.FromContext(ctx).Error("Final last-used flush failed", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if _, err := t.Flush(ctx); err != nil {
+				logging.FromContext(ctx).Error("Last-used flush failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush writes all pending timestamps, in a single statement on Postgres,
+// and returns how many keys were included. On failure the batch is merged
+// back so it is retried on the next flush.
+func (t *LastUsedTracker) Flush(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[string]time.Time)
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := t.write(ctx, batch); err != nil {
+		t.requeue(batch)
+		return 0, fmt.Errorf("failed to update last used times: %w", err)
+	}
+
+	return len(batch), nil
+}
+
+func (t *LastUsedTracker) write(ctx context.Context, batch map[string]time.Time) error {
+	// Databases without arrays get one statement per key; the updates are
+	// idempotent, so a partly written batch is simply retried
+	if database.DialectOf(t.db) != database.Postgres {
+		for id, ts := range batch {
+			query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+			if _, err := t.db.ExecContext(ctx, query, id, ts.UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(batch))
+	usedAt := make([]string, 0, len(batch))
+	for id, ts := range batch {
+		ids = append(ids, id)
+		usedAt = append(usedAt, ts.UTC().Format(time.RFC3339Nano))
+	}
+
+	query := `
+		UPDATE api_keys k
+		SET last_used_at = u.used_at
+		FROM unnest($1::uuid[], $2::timestamptz[]) AS u(id, used_at)
+		WHERE k.id = u.id AND (k.last_used_at IS NULL OR k.last_used_at < u.used_at)
+	`
+
+	_, err := t.db.ExecContext(ctx, query, pq.Array(ids), pq.Array(usedAt))
+	return err
+}
+
+// requeue merges a failed batch back, keeping any newer uses recorded since
+func (t *LastUsedTracker) requeue(batch map[string]time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, ts := range batch {
+		if current, ok := t.pending[id]; !ok || current.Before(ts) {
+			t.pending[id] = ts
+		}
+	}
+}
+
+// Ensure LastUsedTracker implements UsageRecorder
+var _ UsageRecorder = (*LastUsedTracker)(nil)
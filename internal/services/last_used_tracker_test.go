@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastUsedTracker_Flush_BatchesPendingKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker := NewLastUsedTracker(db, time.Minute)
+	tracker.RecordUse("key-1")
+	tracker.RecordUse("key-2")
+	tracker.RecordUse("key-1")
+
+	mock.ExpectExec(`UPDATE api_keys k\s+SET last_used_at = u.used_at`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	count, err := tracker.Flush(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Nothing left to write until the next use
+	count, err = tracker.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestLastUsedTracker_Flush_RequeuesOnError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker := NewLastUsedTracker(db, time.Minute)
+	tracker.RecordUse("key-1")
+
+	mock.ExpectExec(`UPDATE api_keys k`).WillReturnError(assert.AnError)
+	mock.ExpectExec(`UPDATE api_keys k`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err = tracker.Flush(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to update last used times")
+
+	// The failed batch is retried on the next flush
+	count, err := tracker.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLastUsedTracker_RecordUse_WritesThroughWithoutInterval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker := NewLastUsedTracker(db, 0)
+
+	mock.ExpectExec(`UPDATE api_keys k`).WillReturnResult(sqlmock.NewResult(0, 1))
+	tracker.RecordUse("key-1")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Nothing is left pending for a flush that never comes
+	count, err := tracker.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
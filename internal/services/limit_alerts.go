@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+)
+
+// LimitAlerters raises each alert with every one of its alerters, e.g. key
+// webhooks and an event bus, and returns the first error
+type LimitAlerters []LimitAlerter
+
+func (a LimitAlerters) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *RateLimitResult) error {
+	var first error
+	for _, alerter := range a {
+		if err := alerter.NotifyLimitExceeded(ctx, apiKey, eventType, result); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// LimitExceededEvent describes a refusal of apiKey's request, or for
+// events.LimitThresholdReached the request that reached one of its alert
+// thresholds, named in data.threshold_percent. Sub-keys share their parent's
+// limits, so the event is raised for the parent and names the sub-key in
+// data.sub_key_id.
+func LimitExceededEvent(apiKey *database.APIKey, eventType string, result *RateLimitResult) events.Event {
+	keyID := apiKey.LimitKeyID()
+	data := map[string]interface{}{
+		"limit":          result.Limit,
+		"window_seconds": int64(result.Window / time.Second),
+		"reset_time":     result.ResetTime.UTC(),
+	}
+	if result.QuotaExceeded || (eventType == events.LimitThresholdReached && result.ThresholdQuota) {
+		data["limit"], data["window_seconds"] = apiKey.QuotaRequests, apiKey.QuotaPeriodSeconds
+	}
+	if eventType == events.LimitThresholdReached {
+		data["threshold_percent"] = result.Threshold
+		data["quota"] = result.ThresholdQuota
+	}
+	if apiKey.ID != keyID {
+		data["sub_key_id"] = apiKey.ID
+	}
+	return events.Event{Type: eventType, APIKeyID: keyID, Timestamp: time.Now().UTC(), Data: data}
+}
+
+// LimitAlertThrottleKey identifies the alerts throttled together: those of
+// one kind for a key, and for threshold alerts of one threshold, so reaching
+// 100% isn't held back by the alert for 80%
+func LimitAlertThrottleKey(apiKey *database.APIKey, eventType string, result *RateLimitResult) string {
+	key := apiKey.LimitKeyID() + ":" + eventType
+	if eventType == events.LimitThresholdReached {
+		key += ":" + strconv.FormatInt(result.Threshold, 10)
+	}
+	return key
+}
+
+// Ensure LimitAlerters implements LimitAlerter
+var _ LimitAlerter = LimitAlerters(nil)
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"grpc-firstls/internal/database"
+)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrProjectNotFound      = errors.New("project not found")
+)
+
+// OrganizationService manages the organizations (tenants) of the platform and
+// their projects, which API keys belong to
+type OrganizationService struct {
+	db      database.DBInterface
+	dialect database.Dialect
+	keys    KeyInvalidator
+}
+
+// OrganizationServiceOption configures optional OrganizationService
+// behaviour
+type OrganizationServiceOption func(*OrganizationService)
+
+// WithOrganizationKeyInvalidator invalidates cached keys when organizations
+// change, so their keys pick up new organization limits right away
+func WithOrganizationKeyInvalidator(keys KeyInvalidator) OrganizationServiceOption {
+	return func(s *OrganizationService) {
+		s.keys = keys
+	}
+}
+
+func NewOrganizationService(db database.DBInterface, opts ...OrganizationServiceOption) *OrganizationService {
+	s := &OrganizationService{db: db, dialect: database.DialectOf(db)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+const (
+	organizationColumns = `id, name, rate_limit_requests, rate_limit_window_seconds, plan_id, max_keys, created_at, updated_at`
+	projectColumns      = `id, organization_id, name, created_at, updated_at`
+)
+
+func scanOrganization(row rowScanner) (*database.Organization, error) {
+	var organization database.Organization
+	var planID sql.NullString
+	if err := row.Scan(&organization.ID, &organization.Name, &organization.RateLimitRequests, &organization.RateLimitWindowSeconds,
+		&planID, &organization.MaxKeys, &organization.CreatedAt, &organization.UpdatedAt); err != nil {
+		return nil, err
+	}
+	organization.PlanID = planID.String
+	return &organization, nil
+}
+
+func scanProject(row rowScanner) (*database.Project, error) {
+	var project database.Project
+	if err := row.Scan(&project.ID, &project.OrganizationID, &project.Name, &project.CreatedAt, &project.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (s *OrganizationService) CreateOrganization(ctx context.Context, organization *database.Organization) (*database.Organization, error) {
+	args := []interface{}{organization.Name, organization.RateLimitRequests, organization.RateLimitWindowSeconds,
+		organizationPlan(organization), organization.MaxKeys}
+
+	var created *database.Organization
+	var err error
+	if s.dialect.Returning() {
+		query := `INSERT INTO organizations (name, rate_limit_requests, rate_limit_window_seconds, plan_id, max_keys) VALUES ($1, $2, $3, $4, $5) RETURNING ` + organizationColumns
+		created, err = scanOrganization(s.db.QueryRowContext(ctx, query, args...))
+	} else {
+		var id string
+		if id, err = database.NewUUID(); err != nil {
+			return nil, err
+		}
+		query := `INSERT INTO organizations (name, rate_limit_requests, rate_limit_window_seconds, plan_id, max_keys, id) VALUES ($1, $2, $3, $4, $5, $6)`
+		if _, err = s.db.ExecContext(ctx, query, append(args, id)...); err == nil {
+			created, err = s.GetOrganization(ctx, id)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	return created, nil
+}
+
+func (s *OrganizationService) GetOrganization(ctx context.Context, id string) (*database.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations WHERE id = $1`
+
+	organization, err := scanOrganization(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return organization, nil
+}
+
+// UpdateOrganization replaces the name, limits and self-service settings of
+// organization id. A new limit applies to its keys as they are next
+// validated.
+func (s *OrganizationService) UpdateOrganization(ctx context.Context, id string, organization *database.Organization) (*database.Organization, error) {
+	query := `
+		UPDATE organizations
+		SET name = $2, rate_limit_requests = $3, rate_limit_window_seconds = $4, plan_id = $5, max_keys = $6,
+			updated_at = ` + s.dialect.Now() + `
+		WHERE id = $1`
+	args := []interface{}{id, organization.Name, organization.RateLimitRequests, organization.RateLimitWindowSeconds,
+		organizationPlan(organization), organization.MaxKeys}
+
+	var updated *database.Organization
+	var err error
+	if s.dialect.Returning() {
+		updated, err = scanOrganization(s.db.QueryRowContext(ctx, query+`
+		RETURNING `+organizationColumns, args...))
+	} else if _, err = s.db.ExecContext(ctx, query, args...); err == nil {
+		updated, err = scanOrganization(s.db.QueryRowContext(ctx, `SELECT `+organizationColumns+` FROM organizations WHERE id = $1`, id))
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("failed to update organization: %w", err)
+	}
+	if s.keys != nil {
+		s.keys.InvalidateAllAPIKeys(ctx)
+	}
+
+	return updated, nil
+}
+
+// organizationPlan returns the plan_id column value of organization: NULL
+// without a plan
+func organizationPlan(organization *database.Organization) interface{} {
+	if organization.PlanID == "" {
+		return nil
+	}
+	return organization.PlanID
+}
+
+func (s *OrganizationService) ListOrganizations(ctx context.Context) ([]*database.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	organizations := []*database.Organization{}
+	for rows.Next() {
+		organization, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		organizations = append(organizations, organization)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+
+	return organizations, nil
+}
+
+// CreateProject adds a project to organization organizationID
+func (s *OrganizationService) CreateProject(ctx context.Context, organizationID, name string) (*database.Project, error) {
+	if _, err := s.GetOrganization(ctx, organizationID); err != nil {
+		return nil, err
+	}
+
+	var created *database.Project
+	var err error
+	if s.dialect.Returning() {
+		query := `INSERT INTO projects (organization_id, name) VALUES ($1, $2) RETURNING ` + projectColumns
+		created, err = scanProject(s.db.QueryRowContext(ctx, query, organizationID, name))
+	} else {
+		var id string
+		if id, err = database.NewUUID(); err != nil {
+			return nil, err
+		}
+		if _, err = s.db.ExecContext(ctx, `INSERT INTO projects (id, organization_id, name) VALUES ($1, $2, $3)`, id, organizationID, name); err == nil {
+			created, err = s.GetProject(ctx, id)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	return created, nil
+}
+
+func (s *OrganizationService) GetProject(ctx context.Context, id string) (*database.Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE id = $1`
+
+	project, err := scanProject(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrProjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	return project, nil
+}
+
+// ListProjects returns the projects of organization organizationID
+func (s *OrganizationService) ListProjects(ctx context.Context, organizationID string) ([]*database.Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE organization_id = $1 ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	projects := []*database.Project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	return projects, nil
+}
+
+var _ OrganizationServiceInterface = (*OrganizationService)(nil)
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"grpc-firstls/internal/database"
+)
+
+var (
+	ErrPlanNotFound = errors.New("plan not found")
+	ErrPlanInUse    = errors.New("plan is still referenced by API keys or organizations")
+)
+
+type PlanService struct {
+	db      database.DBInterface
+	dialect database.Dialect
+	keys    KeyInvalidator
+}
+
+// PlanServiceOption configures optional PlanService behaviour
+type PlanServiceOption func(*PlanService)
+
+// WithPlanKeyInvalidator invalidates cached keys when plans change, so keys
+// pick up new plan limits right away
+func WithPlanKeyInvalidator(keys KeyInvalidator) PlanServiceOption {
+	return func(s *PlanService) {
+		s.keys = keys
+	}
+}
+
+func NewPlanService(db database.DBInterface, opts ...PlanServiceOption) *PlanService {
+	s := &PlanService{db: db, dialect: database.DialectOf(db)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+const planColumns = `id, name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests, limit_response, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPlan(row rowScanner) (*database.Plan, error) {
+	var plan database.Plan
+	err := row.Scan(
+		&plan.ID,
+		&plan.Name,
+		&plan.RateLimitRequests,
+		&plan.RateLimitWindowSeconds,
+		&plan.QuotaRequests,
+		&plan.QuotaPeriodSeconds,
+		&plan.BurstRequests,
+		database.ScanLimitResponse(&plan.LimitResponse),
+		&plan.CreatedAt,
+		&plan.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (s *PlanService) CreatePlan(ctx context.Context, plan *database.Plan) (*database.Plan, error) {
+	args := []interface{}{
+		plan.Name,
+		plan.RateLimitRequests,
+		plan.RateLimitWindowSeconds,
+		plan.QuotaRequests,
+		plan.QuotaPeriodSeconds,
+		plan.BurstRequests,
+		plan.LimitResponse,
+	}
+
+	var created *database.Plan
+	var err error
+	if s.dialect.Returning() {
+		query := `
+			INSERT INTO plans (name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests, limit_response)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING ` + planColumns
+
+		created, err = scanPlan(s.db.QueryRowContext(ctx, query, args...))
+	} else {
+		var id string
+		if id, err = database.NewUUID(); err != nil {
+			return nil, err
+		}
+		query := `
+			INSERT INTO plans (id, name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests, limit_response)
+			VALUES ($8, $1, $2, $3, $4, $5, $6, $7)
+		`
+		if _, err = s.db.ExecContext(ctx, query, append(args, id)...); err == nil {
+			created, err = scanPlan(s.db.QueryRowContext(ctx, `SELECT `+planColumns+` FROM plans WHERE id = $1`, id))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plan: %w", err)
+	}
+
+	return created, nil
+}
+
+func (s *PlanService) GetPlan(ctx context.Context, id string) (*database.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE id = $1`
+
+	plan, err := scanPlan(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+func (s *PlanService) ListPlans(ctx context.Context) ([]*database.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans ORDER BY rate_limit_requests, name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []*database.Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+
+	return plans, nil
+}
+
+func (s *PlanService) UpdatePlan(ctx context.Context, id string, plan *database.Plan) (*database.Plan, error) {
+	query := `
+		UPDATE plans
+		SET name = $2, rate_limit_requests = $3, rate_limit_window_seconds = $4,
+			quota_requests = $5, quota_period_seconds = $6, burst_requests = $7, limit_response = $8, updated_at = ` + s.dialect.Now() + `
+		WHERE id = $1`
+	args := []interface{}{
+		id,
+		plan.Name,
+		plan.RateLimitRequests,
+		plan.RateLimitWindowSeconds,
+		plan.QuotaRequests,
+		plan.QuotaPeriodSeconds,
+		plan.BurstRequests,
+		plan.LimitResponse,
+	}
+
+	var updated *database.Plan
+	var err error
+	if s.dialect.Returning() {
+		updated, err = scanPlan(s.db.QueryRowContext(ctx, query+`
+		RETURNING `+planColumns, args...))
+	} else if _, err = s.db.ExecContext(ctx, query, args...); err == nil {
+		updated, err = scanPlan(s.db.QueryRowContext(ctx, `SELECT `+planColumns+` FROM plans WHERE id = $1`, id))
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to update plan: %w", err)
+	}
+	s.invalidateKeys(ctx)
+
+	return updated, nil
+}
+
+// ReassignPlan moves every key and organization on plan id to plan targetID
+// and, with deletePlan, then deletes plan id. It returns how many keys were
+// moved. Everything happens in one transaction, so a failure leaves the keys
+// on their old plan.
+func (s *PlanService) ReassignPlan(ctx context.Context, id, targetID string, deletePlan bool) (int64, error) {
+	var reassigned int64
+	err := database.RunInTx(ctx, s.db, func(tx *database.Tx) error {
+		for _, planID := range []string{targetID, id} {
+			var exists string
+			if err := tx.QueryRowContext(ctx, `SELECT id FROM plans WHERE id = $1`, planID).Scan(&exists); err != nil {
+				if err == sql.ErrNoRows {
+					return fmt.Errorf("%w: %s", ErrPlanNotFound, planID)
+				}
+				return fmt.Errorf("failed to get plan: %w", err)
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `UPDATE api_keys SET plan_id = $2, updated_at = `+s.dialect.Now()+` WHERE plan_id = $1`, id, targetID)
+		if err != nil {
+			return fmt.Errorf("failed to reassign API keys: %w", err)
+		}
+		if reassigned, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE organizations SET plan_id = $2, updated_at = `+s.dialect.Now()+` WHERE plan_id = $1`, id, targetID); err != nil {
+			return fmt.Errorf("failed to reassign organizations: %w", err)
+		}
+
+		if deletePlan {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM plans WHERE id = $1`, id); err != nil {
+				return fmt.Errorf("failed to delete plan: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.invalidateKeys(ctx)
+
+	return reassigned, nil
+}
+
+// invalidateKeys drops every cached key, since any of them may be on a plan
+// that changed
+func (s *PlanService) invalidateKeys(ctx context.Context) {
+	if s.keys != nil {
+		s.keys.InvalidateAllAPIKeys(ctx)
+	}
+}
+
+func (s *PlanService) DeletePlan(ctx context.Context, id string) error {
+	// Keys on the plan, and organizations whose self-service keys get it
+	var references int
+	query := `SELECT (SELECT COUNT(*) FROM api_keys WHERE plan_id = $1) + (SELECT COUNT(*) FROM organizations WHERE plan_id = $1)`
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&references); err != nil {
+		return fmt.Errorf("failed to check plan usage: %w", err)
+	}
+	if references > 0 {
+		return ErrPlanInUse
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM plans WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete plan: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrPlanNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var planColumnNames = []string{"id", "name", "rate_limit_requests", "rate_limit_window_seconds", "quota_requests", "quota_period_seconds", "burst_requests", "limit_response", "created_at", "updated_at"}
+
+func createTestPlan() *database.Plan {
+	return &database.Plan{
+		ID:                     "plan-id-123",
+		Name:                   "pro",
+		RateLimitRequests:      600,
+		RateLimitWindowSeconds: 60,
+		QuotaRequests:          1000000,
+		QuotaPeriodSeconds:     2592000,
+		BurstRequests:          100,
+		CreatedAt:              time.Now(),
+		UpdatedAt:              time.Now(),
+	}
+}
+
+func planRow(plan *database.Plan) *sqlmock.Rows {
+	return sqlmock.NewRows(planColumnNames).
+		AddRow(plan.ID, plan.Name, plan.RateLimitRequests, plan.RateLimitWindowSeconds, plan.QuotaRequests, plan.QuotaPeriodSeconds, plan.BurstRequests, plan.LimitResponse, plan.CreatedAt, plan.UpdatedAt)
+}
+
+func TestPlanService_CreatePlan_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+	plan := createTestPlan()
+
+	mock.ExpectQuery(`INSERT INTO plans`).
+		WithArgs(plan.Name, plan.RateLimitRequests, plan.RateLimitWindowSeconds, plan.QuotaRequests, plan.QuotaPeriodSeconds, plan.BurstRequests, plan.LimitResponse).
+		WillReturnRows(planRow(plan))
+
+	result, err := service.CreatePlan(context.Background(), plan)
+
+	assert.NoError(t, err)
+	assert.Equal(t, plan.ID, result.ID)
+	assert.Equal(t, plan.BurstRequests, result.BurstRequests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_GetPlan_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+
+	mock.ExpectQuery(`SELECT id, name`).
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+
+	result, err := service.GetPlan(context.Background(), "missing")
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_ListPlans_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+	plan := createTestPlan()
+
+	mock.ExpectQuery(`SELECT id, name`).WillReturnRows(planRow(plan))
+
+	plans, err := service.ListPlans(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, plans, 1)
+	assert.Equal(t, "pro", plans[0].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_UpdatePlan_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+	plan := createTestPlan()
+
+	mock.ExpectQuery(`UPDATE plans`).
+		WithArgs("missing", plan.Name, plan.RateLimitRequests, plan.RateLimitWindowSeconds, plan.QuotaRequests, plan.QuotaPeriodSeconds, plan.BurstRequests, plan.LimitResponse).
+		WillReturnError(sql.ErrNoRows)
+
+	result, err := service.UpdatePlan(context.Background(), "missing", plan)
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_DeletePlan_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM api_keys WHERE plan_id = \$1`).
+		WithArgs("plan-id-123").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`DELETE FROM plans WHERE id = \$1`).
+		WithArgs("plan-id-123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = service.DeletePlan(context.Background(), "plan-id-123")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_DeletePlan_InUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM api_keys WHERE plan_id = \$1`).
+		WithArgs("plan-id-123").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	err = service.DeletePlan(context.Background(), "plan-id-123")
+
+	assert.ErrorIs(t, err, ErrPlanInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanService_ReassignPlan_RollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewPlanService(db)
+
+	mock.ExpectBegin()
+	for _, id := range []string{"plan-id-456", "plan-id-123"} {
+		mock.ExpectQuery(`SELECT id FROM plans WHERE id = \$1`).
+			WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+	}
+	mock.ExpectExec(`UPDATE api_keys SET plan_id = \$2`).
+		WithArgs("plan-id-123", "plan-id-456").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE organizations SET plan_id = \$2`).
+		WithArgs("plan-id-123", "plan-id-456").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM plans WHERE id = \$1`).
+		WithArgs("plan-id-123").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, err = service.ReassignPlan(context.Background(), "plan-id-123", "plan-id-456", true)
+
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,106 +2,654 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/metrics"
 	"grpc-firstls/internal/redis"
 )
 
+// ErrRefundsNotAllowed is returned by RefundRateLimit for keys without a
+// refund limit
+var ErrRefundsNotAllowed = errors.New("refunds are not allowed for this API key")
+
 type RateLimitService struct {
 	redisClient redis.ClientInterface
-	config      config.RateLimitConfig
+	tenants     *redis.Tenants
+	current     atomic.Pointer[config.RateLimitConfig]
+}
+
+// RateLimitServiceOption configures optional RateLimitService behaviour
+type RateLimitServiceOption func(*RateLimitService)
+
+// WithTenants keeps the counters, quotas, penalties and unique-value sets of
+// a key with tenants, under the prefix of the key's organization, instead of
+// on the service's client
+func WithTenants(tenants *redis.Tenants) RateLimitServiceOption {
+	return func(s *RateLimitService) {
+		s.tenants = tenants
+	}
+}
+
+func NewRateLimitService(redisClient redis.ClientInterface, config config.RateLimitConfig, opts ...RateLimitServiceOption) *RateLimitService {
+	s := &RateLimitService{redisClient: redisClient}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.SetConfig(config)
+	return s
 }
 
-func NewRateLimitService(redisClient redis.ClientInterface, config config.RateLimitConfig) *RateLimitService {
-	return &RateLimitService{
-		redisClient: redisClient,
-		config:      config,
+// countersFor returns the client holding the state of apiKey: that of its
+// organization when tenants are set up
+func (s *RateLimitService) countersFor(apiKey *database.APIKey) redis.ClientInterface {
+	if s.tenants == nil {
+		return s.redisClient
 	}
+	return s.tenants.For(apiKey.OrganizationID)
+}
+
+// SetConfig replaces the default limits, penalty, admin throttle and
+// lockout settings. Requests already being checked finish with the
+// previous settings.
+func (s *RateLimitService) SetConfig(config config.RateLimitConfig) {
+	s.current.Store(&config)
+}
+
+func (s *RateLimitService) config() *config.RateLimitConfig {
+	return s.current.Load()
 }
 
 type RateLimitResult struct {
-	Allowed      bool
-	Remaining    int64
-	ResetTime    time.Time
-	Limit        int64
+	Allowed   bool
+	Remaining int64
+	ResetTime time.Time
+	Limit     int64
+	// Window is the length of the limit's window; unset for penalties
+	Window time.Duration
+
+	// Set while the key is serving an abuse cooldown
+	PenaltyExpiresAt time.Time
+	PenaltyLevel     int64
+
+	// Set when the request was refused for the plan quota rather than the
+	// window limit
+	QuotaExceeded bool
+
+	// Threshold is the highest of the key's alert thresholds, in percent,
+	// reached by an allowed request: of the window limit, or of the plan
+	// quota when ThresholdQuota is set. ThresholdCrossed is set for the
+	// request that reached it.
+	Threshold        int64
+	ThresholdQuota   bool
+	ThresholdCrossed bool
 }
 
-func (s *RateLimitService) CheckRateLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error) {
-	// Use API key ID as the Redis key
-	redisKey := fmt.Sprintf("rate_limit:%s", apiKey.ID)
-	
-	// Get rate limit configuration from API key or use defaults
+// Penalized reports whether the key is currently blocked by an abuse cooldown
+func (r *RateLimitResult) Penalized() bool {
+	return !r.PenaltyExpiresAt.IsZero()
+}
+
+// limitsFor returns the effective request ceiling (including any plan burst
+// allowance) and window for an API key, falling back to the configured defaults.
+// An unexpired limit override replaces the key's regular limit.
+func (s *RateLimitService) limitsFor(apiKey *database.APIKey) (int64, time.Duration) {
 	limit := int64(apiKey.RateLimitRequests)
 	window := time.Duration(apiKey.RateLimitWindowSeconds) * time.Second
-	
+
+	if apiKey.OverrideRequests > 0 && apiKey.OverrideExpiresAt != nil && time.Now().Before(*apiKey.OverrideExpiresAt) {
+		limit = int64(apiKey.OverrideRequests)
+	}
+
 	// If API key doesn't have specific limits, use defaults
 	if limit <= 0 {
-		limit = int64(s.config.DefaultRequests)
+		limit = int64(s.config().DefaultRequests)
 	}
 	if window <= 0 {
-		window = s.config.DefaultWindow
+		window = s.config().DefaultWindow
+	}
+
+	// Burst allowance lets short spikes exceed the steady-state limit
+	if apiKey.BurstRequests > 0 {
+		limit += int64(apiKey.BurstRequests)
+	}
+
+	return limit, window
+}
+
+func (s *RateLimitService) CheckRateLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error) {
+	// Sub-keys are counted against their parent
+	redisKey := fmt.Sprintf("rate_limit:%s", apiKey.LimitKeyID())
+
+	// Get rate limit configuration from API key or use defaults
+	limit, window := s.limitsFor(apiKey)
+
+	// Keys serving a cooldown are rejected without touching the window counter
+	if s.penaltiesEnabled() {
+		penalized, err := s.activePenalty(ctx, apiKey, limit)
+		if err != nil {
+			return nil, err
+		}
+		if penalized != nil {
+			return penalized, nil
+		}
 	}
-	
+
 	// Increment counter and get current count
-	currentCount, err := s.redisClient.IncrementRateLimit(ctx, redisKey, window)
+	currentCount, ttl, err := s.countersFor(apiKey).IncrementRateLimit(ctx, redisKey, window)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check rate limit: %w", err)
 	}
-	
+
 	// Check if limit exceeded
 	allowed := currentCount <= limit
+	if !allowed {
+		// Reservations not committed in time may still hold units; they
+		// are only released when they would make a difference
+		released, err := s.releaseExpiredReservations(ctx, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		currentCount -= released
+		allowed = currentCount <= limit
+	}
 	remaining := limit - currentCount
 	if remaining < 0 {
 		remaining = 0
 	}
-	
-	// Calculate reset time
-	resetTime := time.Now().Add(window)
-	
-	return &RateLimitResult{
+
+	// Calculate reset time from the (possibly jittered) window expiry
+	resetTime := resetTimeFor(ttl, window)
+
+	result := &RateLimitResult{
 		Allowed:   allowed,
 		Remaining: remaining,
 		ResetTime: resetTime,
 		Limit:     limit,
+		Window:    window,
+	}
+	if allowed {
+		result.Threshold, result.ThresholdCrossed = thresholdReached(apiKey.AlertThresholds, currentCount, limit)
+	}
+
+	// Requests rejected by the window limit don't count against the plan quota
+	if allowed && apiKey.QuotaRequests > 0 && apiKey.QuotaPeriodSeconds > 0 {
+		if err := s.checkQuota(ctx, apiKey, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if !allowed && s.penaltiesEnabled() {
+		if err := s.recordViolation(ctx, apiKey, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// CheckEndUserLimit enforces the key's per-end-user sublimit so a single user
+// of a customer's integration can't consume the whole key limit. Keys without
+// a sublimit are always allowed.
+func (s *RateLimitService) CheckEndUserLimit(ctx context.Context, apiKey *database.APIKey, endUserID string) (*RateLimitResult, error) {
+	limit := int64(apiKey.EndUserLimitRequests)
+	window := time.Duration(apiKey.EndUserLimitWindowSeconds) * time.Second
+	if window <= 0 {
+		_, window = s.limitsFor(apiKey)
+	}
+
+	if limit <= 0 {
+		return &RateLimitResult{Allowed: true, ResetTime: time.Now().Add(window), Window: window}, nil
+	}
+
+	redisKey := fmt.Sprintf("rate_limit:%s:user:%s", apiKey.LimitKeyID(), endUserID)
+	currentCount, ttl, err := s.countersFor(apiKey).IncrementRateLimit(ctx, redisKey, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check end-user limit: %w", err)
+	}
+
+	remaining := limit - currentCount
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   currentCount <= limit,
+		Remaining: remaining,
+		ResetTime: resetTimeFor(ttl, window),
+		Limit:     limit,
+		Window:    window,
+	}, nil
+}
+
+// CheckOrganizationLimit enforces the ceiling of the key's organization, a
+// window counter shared by all of the organization's keys. Keys outside an
+// organization, or in one without a ceiling, are always allowed.
+func (s *RateLimitService) CheckOrganizationLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error) {
+	limit := int64(apiKey.OrganizationLimitRequests)
+	window := time.Duration(apiKey.OrganizationLimitWindowSeconds) * time.Second
+	if window <= 0 {
+		window = s.config().DefaultWindow
+	}
+
+	if limit <= 0 || apiKey.OrganizationID == "" {
+		return &RateLimitResult{Allowed: true, ResetTime: time.Now().Add(window), Window: window}, nil
+	}
+
+	redisKey := fmt.Sprintf("rate_limit:org:%s", apiKey.OrganizationID)
+	currentCount, ttl, err := s.countersFor(apiKey).IncrementRateLimit(ctx, redisKey, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check organization limit: %w", err)
+	}
+	if currentCount > limit {
+		released, err := s.countersFor(apiKey).ReleaseExpiredReservations(ctx, redisKey, redisKey+":reservations")
+		if err != nil {
+			return nil, fmt.Errorf("failed to release expired reservations: %w", err)
+		}
+		currentCount -= released
+	}
+
+	remaining := limit - currentCount
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   currentCount <= limit,
+		Remaining: remaining,
+		ResetTime: resetTimeFor(ttl, window),
+		Limit:     limit,
+		Window:    window,
+	}, nil
+}
+
+// CheckTokenLimit enforces the limit an access token was scoped down to, a
+// window counter of the token's own, on top of its key's limits. Requests
+// authenticated otherwise, or with an unscoped token, are always allowed.
+func (s *RateLimitService) CheckTokenLimit(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error) {
+	limit := int64(apiKey.TokenLimitRequests)
+	window := time.Duration(apiKey.TokenLimitWindowSeconds) * time.Second
+	if window <= 0 {
+		_, window = s.limitsFor(apiKey)
+	}
+
+	if limit <= 0 || apiKey.TokenID == "" {
+		return &RateLimitResult{Allowed: true, ResetTime: time.Now().Add(window), Window: window}, nil
+	}
+
+	redisKey := fmt.Sprintf("rate_limit:%s:token:%s", apiKey.LimitKeyID(), apiKey.TokenID)
+	currentCount, ttl, err := s.countersFor(apiKey).IncrementRateLimit(ctx, redisKey, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token limit: %w", err)
+	}
+
+	remaining := limit - currentCount
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   currentCount <= limit,
+		Remaining: remaining,
+		ResetTime: resetTimeFor(ttl, window),
+		Limit:     limit,
+		Window:    window,
+	}, nil
+}
+
+// CheckUniqueLimit enforces a distinct-value limit: the key may use at most
+// rule.MaxUnique different values of the rule's field within rule.Window.
+// Repeating a value that was already seen in the window is always allowed.
+func (s *RateLimitService) CheckUniqueLimit(ctx context.Context, apiKey *database.APIKey, rule config.UniqueLimitRule, value string) (*RateLimitResult, error) {
+	redisKey := fmt.Sprintf("unique:%s:%s:%s:%s", apiKey.LimitKeyID(), rule.Method, rule.Route, rule.Field)
+	limit := int64(rule.MaxUnique)
+
+	allowed, count, err := s.countersFor(apiKey).AddUniqueMember(ctx, redisKey, value, limit, rule.Window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check unique limit: %w", err)
+	}
+
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   allowed,
+		Remaining: remaining,
+		ResetTime: time.Now().Add(rule.Window),
+		Limit:     limit,
 	}, nil
 }
 
+// CheckAdminLimit enforces the admin API throttle for caller, an admin
+// credential or client IP. Callers are always allowed when the throttle is
+// disabled.
+func (s *RateLimitService) CheckAdminLimit(ctx context.Context, caller string) (*RateLimitResult, error) {
+	adminConfig := s.config().Admin
+	limit := int64(adminConfig.Requests)
+	window := adminConfig.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	if limit <= 0 {
+		return &RateLimitResult{Allowed: true, ResetTime: time.Now().Add(window)}, nil
+	}
+
+	redisKey := fmt.Sprintf("admin_rate_limit:%s", caller)
+	currentCount, ttl, err := s.redisClient.IncrementRateLimit(ctx, redisKey, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check admin limit: %w", err)
+	}
+
+	remaining := limit - currentCount
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   currentCount <= limit,
+		Remaining: remaining,
+		ResetTime: resetTimeFor(ttl, window),
+		Limit:     limit,
+		Window:    window,
+	}, nil
+}
+
+// AuthLockout returns how long clientIP remains locked out after too many
+// invalid API keys, or 0 if it isn't locked out
+func (s *RateLimitService) AuthLockout(ctx context.Context, clientIP string) (time.Duration, error) {
+	if !s.authFailureTrackingEnabled() {
+		return 0, nil
+	}
+	ttl, err := s.redisClient.GetTTL(ctx, fmt.Sprintf("auth_lockout:%s", clientIP))
+	if err != nil {
+		return 0, fmt.Errorf("failed to check auth lockout: %w", err)
+	}
+	return ttl, nil
+}
+
+// RecordAuthFailure counts an invalid API key presented by clientIP and, once
+// Threshold failures were seen within Period, locks the client out. It
+// returns the lockout duration when this failure triggered one.
+func (s *RateLimitService) RecordAuthFailure(ctx context.Context, clientIP string) (time.Duration, error) {
+	if !s.authFailureTrackingEnabled() {
+		return 0, nil
+	}
+	failureConfig := s.config().AuthFailures
+
+	failures, _, err := s.redisClient.IncrementRateLimit(ctx, fmt.Sprintf("auth_failures:%s", clientIP), failureConfig.Period)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record auth failure: %w", err)
+	}
+	if failures < int64(failureConfig.Threshold) {
+		return 0, nil
+	}
+
+	if err := s.redisClient.SetWithExpiry(ctx, fmt.Sprintf("auth_lockout:%s", clientIP), failures, failureConfig.Lockout); err != nil {
+		return 0, fmt.Errorf("failed to apply auth lockout: %w", err)
+	}
+	return failureConfig.Lockout, nil
+}
+
+func (s *RateLimitService) authFailureTrackingEnabled() bool {
+	failureConfig := s.config().AuthFailures
+	return failureConfig.Threshold > 0 && failureConfig.Lockout > 0
+}
+
+func (s *RateLimitService) penaltiesEnabled() bool {
+	penaltyConfig := s.config().Penalty
+	return penaltyConfig.Threshold > 0 && penaltyConfig.BaseCooldown > 0
+}
+
+// activePenalty returns a rejecting result if the key is currently serving a
+// cooldown, or nil otherwise.
+func (s *RateLimitService) activePenalty(ctx context.Context, apiKey *database.APIKey, limit int64) (*RateLimitResult, error) {
+	ttl, err := s.countersFor(apiKey).GetTTL(ctx, fmt.Sprintf("penalty:%s", apiKey.LimitKeyID()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check penalty: %w", err)
+	}
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	level, err := s.countersFor(apiKey).GetRateLimitCount(ctx, fmt.Sprintf("penalty_level:%s", apiKey.LimitKeyID()))
+	if err != nil {
+		level = 0
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	return &RateLimitResult{
+		Allowed:          false,
+		Remaining:        0,
+		ResetTime:        expiresAt,
+		Limit:            limit,
+		PenaltyExpiresAt: expiresAt,
+		PenaltyLevel:     level,
+	}, nil
+}
+
+// recordViolation counts a rejected request and, once the key has exceeded its
+// limit Threshold times within Period, blocks it for a cooldown that doubles
+// with every penalty applied in the last 24 hours.
+func (s *RateLimitService) recordViolation(ctx context.Context, apiKey *database.APIKey, result *RateLimitResult) error {
+	penaltyConfig := s.config().Penalty
+
+	violations, _, err := s.countersFor(apiKey).IncrementRateLimit(ctx, fmt.Sprintf("penalty_violations:%s", apiKey.LimitKeyID()), penaltyConfig.Period)
+	if err != nil {
+		return fmt.Errorf("failed to record violation: %w", err)
+	}
+	if violations < int64(penaltyConfig.Threshold) {
+		return nil
+	}
+
+	level, _, err := s.countersFor(apiKey).IncrementRateLimit(ctx, fmt.Sprintf("penalty_level:%s", apiKey.LimitKeyID()), 24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("failed to escalate penalty: %w", err)
+	}
+
+	cooldown := penaltyConfig.BaseCooldown
+	for i := int64(1); i < level; i++ {
+		cooldown *= 2
+		if penaltyConfig.MaxCooldown > 0 && cooldown >= penaltyConfig.MaxCooldown {
+			cooldown = penaltyConfig.MaxCooldown
+			break
+		}
+	}
+
+	if err := s.countersFor(apiKey).SetWithExpiry(ctx, fmt.Sprintf("penalty:%s", apiKey.LimitKeyID()), level, cooldown); err != nil {
+		return fmt.Errorf("failed to apply penalty: %w", err)
+	}
+
+	// Start counting violations afresh once the cooldown ends
+	if err := s.countersFor(apiKey).SetWithExpiry(ctx, fmt.Sprintf("penalty_violations:%s", apiKey.LimitKeyID()), 0, penaltyConfig.Period); err != nil {
+		return fmt.Errorf("failed to reset violations: %w", err)
+	}
+
+	result.ResetTime = time.Now().Add(cooldown)
+	result.PenaltyExpiresAt = result.ResetTime
+	result.PenaltyLevel = level
+
+	return nil
+}
+
+// checkQuota enforces the long-term plan quota, denying the request once the
+// quota for the current period is used up.
+func (s *RateLimitService) checkQuota(ctx context.Context, apiKey *database.APIKey, result *RateLimitResult) error {
+	quotaKey := fmt.Sprintf("quota:%s", apiKey.LimitKeyID())
+	period := time.Duration(apiKey.QuotaPeriodSeconds) * time.Second
+
+	used, ttl, err := s.countersFor(apiKey).IncrementRateLimit(ctx, quotaKey, period)
+	if err != nil {
+		return fmt.Errorf("failed to check quota: %w", err)
+	}
+	if used > int64(apiKey.QuotaRequests) {
+		released, err := s.countersFor(apiKey).ReleaseExpiredReservations(ctx, quotaKey, quotaKey+":reservations")
+		if err != nil {
+			return fmt.Errorf("failed to release expired reservations: %w", err)
+		}
+		used -= released
+	}
+
+	if used > int64(apiKey.QuotaRequests) {
+		result.Allowed = false
+		result.Remaining = 0
+		result.ResetTime = resetTimeFor(ttl, period)
+		result.QuotaExceeded = true
+		result.Threshold, result.ThresholdQuota, result.ThresholdCrossed = 0, false, false
+		return nil
+	}
+
+	// The quota's warning wins when more of it is used than of the window
+	if threshold, crossed := thresholdReached(apiKey.AlertThresholds, used, int64(apiKey.QuotaRequests)); threshold > result.Threshold {
+		result.Threshold, result.ThresholdQuota, result.ThresholdCrossed = threshold, true, crossed
+	}
+	return nil
+}
+
+// thresholdReached returns the highest of thresholds, ascending percentages,
+// that count requests reach of limit, and whether the last of them crossed it
+func thresholdReached(thresholds []int64, count, limit int64) (int64, bool) {
+	if limit <= 0 {
+		return 0, false
+	}
+	for i := len(thresholds) - 1; i >= 0; i-- {
+		if count*100 >= thresholds[i]*limit {
+			return thresholds[i], (count-1)*100 < thresholds[i]*limit
+		}
+	}
+	return 0, false
+}
+
 func (s *RateLimitService) GetRateLimitStatus(ctx context.Context, apiKey *database.APIKey) (*RateLimitResult, error) {
-	redisKey := fmt.Sprintf("rate_limit:%s", apiKey.ID)
-	
+	redisKey := fmt.Sprintf("rate_limit:%s", apiKey.LimitKeyID())
+
+	if _, err := s.releaseExpiredReservations(ctx, apiKey); err != nil {
+		return nil, err
+	}
+
 	// Get current count without incrementing
-	currentCount, err := s.redisClient.GetRateLimitCount(ctx, redisKey)
+	currentCount, err := s.countersFor(apiKey).GetRateLimitCount(ctx, redisKey)
 	if err != nil {
 		// If key doesn't exist, count is 0
 		currentCount = 0
 	}
-	
+
 	// Get rate limit configuration
-	limit := int64(apiKey.RateLimitRequests)
-	window := time.Duration(apiKey.RateLimitWindowSeconds) * time.Second
-	
-	if limit <= 0 {
-		limit = int64(s.config.DefaultRequests)
-	}
-	if window <= 0 {
-		window = s.config.DefaultWindow
+	limit, window := s.limitsFor(apiKey)
+
+	if s.penaltiesEnabled() {
+		penalized, err := s.activePenalty(ctx, apiKey, limit)
+		if err != nil {
+			return nil, err
+		}
+		if penalized != nil {
+			return penalized, nil
+		}
 	}
-	
+
 	allowed := currentCount < limit
 	remaining := limit - currentCount
 	if remaining < 0 {
 		remaining = 0
 	}
-	
+
 	resetTime := time.Now().Add(window)
-	
+	if currentCount > 0 {
+		if ttl, err := s.countersFor(apiKey).GetTTL(ctx, redisKey); err == nil && ttl > 0 {
+			resetTime = time.Now().Add(ttl)
+		}
+	}
+
 	return &RateLimitResult{
 		Allowed:   allowed,
 		Remaining: remaining,
 		ResetTime: resetTime,
 		Limit:     limit,
+		Window:    window,
 	}, nil
 }
+
+// RefundResult is the outcome of RefundRateLimit
+type RefundResult struct {
+	// Refunded is the number of units taken back, which can be fewer than
+	// asked for once the key's refund limit or window count is reached
+	Refunded int64
+	// Status is the key's rate limit after the refund
+	Status *RateLimitResult
+}
+
+// RefundRateLimit takes back up to units requests from the current window
+// of a key's rate limit, for requests whose downstream call failed. A key
+// refunds at most its refund limit per window, and never more than the
+// window has counted; sub-keys refund to their parent's window within their
+// parent's limit. The plan quota and the organization and access token
+// limits aren't refunded.
+func (s *RateLimitService) RefundRateLimit(ctx context.Context, apiKey *database.APIKey, units int64) (*RefundResult, error) {
+	if apiKey.RefundLimit <= 0 {
+		return nil, ErrRefundsNotAllowed
+	}
+	if units <= 0 {
+		return nil, fmt.Errorf("invalid refund of %d units: must be positive", units)
+	}
+
+	redisKey := fmt.Sprintf("rate_limit:%s", apiKey.LimitKeyID())
+	refundsKey := fmt.Sprintf("rate_limit:%s:refunds", apiKey.LimitKeyID())
+	refunded, err := s.countersFor(apiKey).RefundRateLimit(ctx, redisKey, refundsKey, units, int64(apiKey.RefundLimit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to refund rate limit: %w", err)
+	}
+	metrics.RateLimitRefunds.Add(float64(refunded))
+
+	status, err := s.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RefundResult{Refunded: refunded, Status: status}, nil
+}
+
+// ClearKeyState deletes every Redis key holding counters, quotas, penalties,
+// unique-value sets, webhook alert and event throttles or cached responses
+// for an API key, returning how many were removed.
+func (s *RateLimitService) ClearKeyState(ctx context.Context, apiKeyID string) (int64, error) {
+	patterns := []string{
+		fmt.Sprintf("rate_limit:%s", apiKeyID),
+		fmt.Sprintf("rate_limit:%s:*", apiKeyID),
+		fmt.Sprintf("quota:%s", apiKeyID),
+		fmt.Sprintf("quota:%s:*", apiKeyID),
+		fmt.Sprintf("penalty:%s", apiKeyID),
+		fmt.Sprintf("penalty_violations:%s", apiKeyID),
+		fmt.Sprintf("penalty_level:%s", apiKeyID),
+		fmt.Sprintf("unique:%s:*", apiKeyID),
+		fmt.Sprintf("webhook_throttle:%s:*", apiKeyID),
+		fmt.Sprintf("event_throttle:%s:*", apiKeyID),
+		fmt.Sprintf("response_cache:%s:*", apiKeyID),
+	}
+
+	// The key's organization isn't known here, so every tenant's keys are searched
+	deleteByPattern := s.redisClient.DeleteByPattern
+	if s.tenants != nil {
+		deleteByPattern = s.tenants.DeleteByPattern
+	}
+
+	deleted, err := deleteByPattern(ctx, patterns...)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to clear rate limit state: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// resetTimeFor converts a counter's remaining TTL into the window reset time,
+// falling back to a full window when the TTL is unknown
+func resetTimeFor(ttl time.Duration, window time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = window
+	}
+	return time.Now().Add(ttl)
+}
@@ -7,9 +7,11 @@ import (
 
 	"grpc-firstls/internal/config"
 	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/redis"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockRedisClient is a mock implementation of redis.ClientInterface
@@ -17,8 +19,28 @@ type MockRedisClient struct {
 	mock.Mock
 }
 
-func (m *MockRedisClient) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, error) {
+func (m *MockRedisClient) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
 	args := m.Called(ctx, key, window)
+	return args.Get(0).(int64), args.Get(1).(time.Duration), args.Error(2)
+}
+
+func (m *MockRedisClient) RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error) {
+	args := m.Called(ctx, key, refundsKey, units, maxRefunds)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRedisClient) ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error) {
+	args := m.Called(ctx, key, reservationsKey, id, units, limit, window, ttl)
+	return args.Bool(0), args.Get(1).(int64), args.Get(2).(time.Duration), args.Error(3)
+}
+
+func (m *MockRedisClient) SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error) {
+	args := m.Called(ctx, key, reservationsKey, id, release)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRedisClient) ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error) {
+	args := m.Called(ctx, key, reservationsKey)
 	return args.Get(0).(int64), args.Error(1)
 }
 
@@ -27,8 +49,49 @@ func (m *MockRedisClient) GetRateLimitCount(ctx context.Context, key string) (in
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func createTestRateLimitService() (*RateLimitService, *MockRedisClient) {
+func (m *MockRedisClient) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockRedisClient) SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	args := m.Called(ctx, key, value, ttl)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error) {
+	args := m.Called(ctx, key, member, maxMembers, window)
+	return args.Bool(0), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockRedisClient) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	args := m.Called(ctx, patterns)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRedisClient) IncrementHashField(ctx context.Context, key string, field string, delta int64) error {
+	args := m.Called(ctx, key, field, delta)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) DrainHash(ctx context.Context, key string) (map[string]int64, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+// newRateLimitRedisClient returns a mock for the rate limit service, which
+// looks for expired reservations when refusing requests and reporting status
+func newRateLimitRedisClient() *MockRedisClient {
 	mockRedisClient := &MockRedisClient{}
+	mockRedisClient.On("ReleaseExpiredReservations", mock.Anything, mock.Anything, mock.Anything).Return(int64(0), nil).Maybe()
+	return mockRedisClient
+}
+
+func createTestRateLimitService() (*RateLimitService, *MockRedisClient) {
+	mockRedisClient := newRateLimitRedisClient()
 	config := config.RateLimitConfig{
 		DefaultRequests: 100,
 		DefaultWindow:   time.Hour,
@@ -71,7 +134,7 @@ func TestRateLimitService_CheckRateLimit_Allowed(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock expectations - current count is 5, limit is 10
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(5), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(5), time.Duration(60)*time.Second, nil)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -87,6 +150,25 @@ func TestRateLimitService_CheckRateLimit_Allowed(t *testing.T) {
 	mockRedisClient.AssertExpectations(t)
 }
 
+func TestRateLimitService_CheckRateLimit_SubKeyChargesParent(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.ParentID = "parent-id-456"
+	ctx := context.Background()
+
+	// Sub-keys share their parent's counter
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:parent-id-456", time.Duration(60)*time.Second).Return(int64(3), time.Duration(60)*time.Second, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(7), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
 func TestRateLimitService_CheckRateLimit_Exceeded(t *testing.T) {
 	service, mockRedisClient := createTestRateLimitService()
 
@@ -95,7 +177,7 @@ func TestRateLimitService_CheckRateLimit_Exceeded(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock expectations - current count is 11, limit is 10
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), time.Duration(60)*time.Second, nil)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -119,7 +201,7 @@ func TestRateLimitService_CheckRateLimit_WithDefaults(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock expectations - should use default window (1 hour)
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-456", time.Hour).Return(int64(50), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-456", time.Hour).Return(int64(50), time.Hour, nil)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -134,6 +216,25 @@ func TestRateLimitService_CheckRateLimit_WithDefaults(t *testing.T) {
 	mockRedisClient.AssertExpectations(t)
 }
 
+func TestRateLimitService_SetConfig_ChangesDefaults(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyWithDefaultsForRateLimit()
+	ctx := context.Background()
+
+	service.SetConfig(config.RateLimitConfig{
+		DefaultRequests: 10,
+		DefaultWindow:   time.Minute,
+	})
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-456", time.Minute).Return(int64(4), time.Minute, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), result.Limit)
+	assert.Equal(t, int64(6), result.Remaining)
+	mockRedisClient.AssertExpectations(t)
+}
+
 func TestRateLimitService_CheckRateLimit_RedisError(t *testing.T) {
 	service, mockRedisClient := createTestRateLimitService()
 
@@ -142,7 +243,7 @@ func TestRateLimitService_CheckRateLimit_RedisError(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock to return error
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(0), assert.AnError)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(0), time.Duration(60)*time.Second, assert.AnError)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -164,6 +265,7 @@ func TestRateLimitService_GetRateLimitStatus_Allowed(t *testing.T) {
 
 	// Setup mock expectations - current count is 3, limit is 10
 	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(3), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
 
 	// Call the method
 	result, err := service.GetRateLimitStatus(ctx, testAPIKey)
@@ -188,6 +290,7 @@ func TestRateLimitService_GetRateLimitStatus_Exceeded(t *testing.T) {
 
 	// Setup mock expectations - current count is 12, limit is 10
 	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(12), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
 
 	// Call the method
 	result, err := service.GetRateLimitStatus(ctx, testAPIKey)
@@ -236,6 +339,7 @@ func TestRateLimitService_GetRateLimitStatus_WithDefaults(t *testing.T) {
 
 	// Setup mock expectations
 	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-456").Return(int64(25), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-456").Return(30*time.Second, nil)
 
 	// Call the method
 	result, err := service.GetRateLimitStatus(ctx, testAPIKey)
@@ -258,7 +362,7 @@ func TestRateLimitService_CheckRateLimit_EdgeCase_ExactlyAtLimit(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock expectations - current count is exactly at limit (10)
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(10), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(10), time.Duration(60)*time.Second, nil)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -282,7 +386,7 @@ func TestRateLimitService_CheckRateLimit_EdgeCase_OneOverLimit(t *testing.T) {
 	ctx := context.Background()
 
 	// Setup mock expectations - current count is 1 over limit (11)
-	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), time.Duration(60)*time.Second, nil)
 
 	// Call the method
 	result, err := service.CheckRateLimit(ctx, testAPIKey)
@@ -307,6 +411,7 @@ func TestRateLimitService_GetRateLimitStatus_EdgeCase_ExactlyAtLimit(t *testing.
 
 	// Setup mock expectations - current count is exactly at limit (10)
 	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(10), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
 
 	// Call the method
 	result, err := service.GetRateLimitStatus(ctx, testAPIKey)
@@ -321,3 +426,707 @@ func TestRateLimitService_GetRateLimitStatus_EdgeCase_ExactlyAtLimit(t *testing.
 
 	mockRedisClient.AssertExpectations(t)
 }
+
+func TestRateLimitService_CheckRateLimit_PlanBurst(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Create test data - plan grants 5 burst requests on top of the limit of 10
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.BurstRequests = 5
+	ctx := context.Background()
+
+	// Setup mock expectations - current count is 12, within limit + burst
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(12), time.Duration(60)*time.Second, nil)
+
+	// Call the method
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(15), result.Limit)
+	assert.Equal(t, int64(3), result.Remaining) // 15 - 12 = 3
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_PlanQuotaExceeded(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Create test data - plan quota of 1000 requests per 30 days
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.QuotaRequests = 1000
+	testAPIKey.QuotaPeriodSeconds = 2592000
+	ctx := context.Background()
+
+	// Setup mock expectations - window allows, quota is used up
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(1), time.Duration(60)*time.Second, nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "quota:test-id-123", time.Duration(2592000)*time.Second).Return(int64(1001), time.Duration(2592000)*time.Second, nil)
+
+	// Call the method
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(0), result.Remaining)
+	assert.True(t, result.ResetTime.After(time.Now().Add(24*time.Hour)))
+	assert.True(t, result.QuotaExceeded)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_AlertThresholds(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Thresholds at 50% and 80% of the limit of 10
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.AlertThresholds = []int64{50, 80}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		count     int64
+		threshold int64
+		crossed   bool
+	}{
+		{count: 4},
+		{count: 5, threshold: 50, crossed: true},
+		{count: 6, threshold: 50},
+		{count: 8, threshold: 80, crossed: true},
+		{count: 10, threshold: 80},
+		{count: 11},
+	} {
+		mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(tc.count, time.Duration(60)*time.Second, nil).Once()
+
+		result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.threshold, result.Threshold, "count %d", tc.count)
+		assert.Equal(t, tc.crossed, result.ThresholdCrossed, "count %d", tc.count)
+		assert.False(t, result.ThresholdQuota)
+	}
+}
+
+func TestRateLimitService_CheckRateLimit_QuotaAlertThreshold(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// The window is barely used, while 800 of 1000 quota requests is 80%
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.QuotaRequests = 1000
+	testAPIKey.QuotaPeriodSeconds = 2592000
+	testAPIKey.AlertThresholds = []int64{80}
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(1), time.Duration(60)*time.Second, nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "quota:test-id-123", time.Duration(2592000)*time.Second).Return(int64(800), time.Duration(2592000)*time.Second, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(80), result.Threshold)
+	assert.True(t, result.ThresholdQuota)
+	assert.True(t, result.ThresholdCrossed)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_ActiveOverride(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Create test data - a temporary override raises the limit from 10 to 50
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	expiresAt := time.Now().Add(time.Hour)
+	testAPIKey.OverrideRequests = 50
+	testAPIKey.OverrideExpiresAt = &expiresAt
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(20), time.Duration(60)*time.Second, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(50), result.Limit)
+	assert.Equal(t, int64(30), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_ExpiredOverride(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Create test data - override expired, regular limit of 10 applies
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	expiresAt := time.Now().Add(-time.Minute)
+	testAPIKey.OverrideRequests = 50
+	testAPIKey.OverrideExpiresAt = &expiresAt
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(20), time.Duration(60)*time.Second, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(10), result.Limit)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func createTestRateLimitServiceWithPenalties() (*RateLimitService, *MockRedisClient) {
+	mockRedisClient := newRateLimitRedisClient()
+	config := config.RateLimitConfig{
+		DefaultRequests: 100,
+		DefaultWindow:   time.Hour,
+		Penalty: config.PenaltyConfig{
+			Threshold:    3,
+			Period:       10 * time.Minute,
+			BaseCooldown: 5 * time.Minute,
+			MaxCooldown:  time.Hour,
+		},
+	}
+	service := NewRateLimitService(mockRedisClient, config)
+	return service, mockRedisClient
+}
+
+func TestRateLimitService_CheckRateLimit_ActivePenalty(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithPenalties()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	// Setup mock expectations - key is in a cooldown, window counter untouched
+	mockRedisClient.On("GetTTL", ctx, "penalty:test-id-123").Return(4*time.Minute, nil)
+	mockRedisClient.On("GetRateLimitCount", ctx, "penalty_level:test-id-123").Return(int64(1), nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Penalized())
+	assert.Equal(t, int64(1), result.PenaltyLevel)
+	assert.True(t, result.PenaltyExpiresAt.After(time.Now().Add(3*time.Minute)))
+
+	mockRedisClient.AssertExpectations(t)
+	mockRedisClient.AssertNotCalled(t, "IncrementRateLimit", ctx, "rate_limit:test-id-123", mock.Anything)
+}
+
+func TestRateLimitService_CheckRateLimit_EscalatesPenalty(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithPenalties()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	// Setup mock expectations - third violation, second penalty in 24h doubles the cooldown
+	mockRedisClient.On("GetTTL", ctx, "penalty:test-id-123").Return(time.Duration(0), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), time.Duration(60)*time.Second, nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "penalty_violations:test-id-123", 10*time.Minute).Return(int64(3), 10*time.Minute, nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "penalty_level:test-id-123", 24*time.Hour).Return(int64(2), 24*time.Hour, nil)
+	mockRedisClient.On("SetWithExpiry", ctx, "penalty:test-id-123", int64(2), 10*time.Minute).Return(nil)
+	mockRedisClient.On("SetWithExpiry", ctx, "penalty_violations:test-id-123", int64(0), 10*time.Minute).Return(nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Penalized())
+	assert.Equal(t, int64(2), result.PenaltyLevel)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_ViolationBelowThreshold(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithPenalties()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	mockRedisClient.On("GetTTL", ctx, "penalty:test-id-123").Return(time.Duration(0), nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(11), time.Duration(60)*time.Second, nil)
+	mockRedisClient.On("IncrementRateLimit", ctx, "penalty_violations:test-id-123", 10*time.Minute).Return(int64(1), 10*time.Minute, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.False(t, result.Penalized())
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckUniqueLimit_Exceeded(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+	rule := config.UniqueLimitRule{Method: "POST", Route: "/api/test", Source: "header", Field: "X-Target-ID", MaxUnique: 100, Window: time.Hour}
+
+	// Setup mock expectations - set already holds 100 distinct targets
+	mockRedisClient.On("AddUniqueMember", ctx, "unique:test-id-123:POST:/api/test:X-Target-ID", "target-101", int64(100), time.Hour).Return(false, int64(100), nil)
+
+	result, err := service.CheckUniqueLimit(ctx, testAPIKey, rule, "target-101")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(100), result.Limit)
+	assert.Equal(t, int64(0), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckUniqueLimit_Allowed(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+	rule := config.UniqueLimitRule{Route: "/api/test", Source: "query", Field: "target", MaxUnique: 10, Window: time.Hour}
+
+	mockRedisClient.On("AddUniqueMember", ctx, "unique:test-id-123::/api/test:target", "a", int64(10), time.Hour).Return(true, int64(4), nil)
+
+	result, err := service.CheckUniqueLimit(ctx, testAPIKey, rule, "a")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(6), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckEndUserLimit_Exceeded(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// Create test data - each end user may make 3 requests per key window
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.EndUserLimitRequests = 3
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123:user:user-42", time.Duration(60)*time.Second).Return(int64(4), time.Duration(60)*time.Second, nil)
+
+	result, err := service.CheckEndUserLimit(ctx, testAPIKey, "user-42")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(3), result.Limit)
+	assert.Equal(t, int64(0), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckEndUserLimit_OwnWindow(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.EndUserLimitRequests = 3
+	testAPIKey.EndUserLimitWindowSeconds = 10
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123:user:user-42", 10*time.Second).Return(int64(1), 10*time.Second, nil)
+
+	result, err := service.CheckEndUserLimit(ctx, testAPIKey, "user-42")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(2), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckOrganizationLimit(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// All keys of the organization share 1000 requests per minute
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.OrganizationID = "org-acme"
+	testAPIKey.OrganizationLimitRequests = 1000
+	testAPIKey.OrganizationLimitWindowSeconds = 60
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:org:org-acme", time.Minute).Return(int64(1001), 30*time.Second, nil)
+
+	result, err := service.CheckOrganizationLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(1000), result.Limit)
+	assert.Equal(t, int64(0), result.Remaining)
+
+	// Keys outside an organization aren't counted
+	testAPIKey.OrganizationID = ""
+	result, err = service.CheckOrganizationLimit(ctx, testAPIKey)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckTokenLimit(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	// A token scoped down to 5 requests per window of its key
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.TokenID = "tok-1"
+	testAPIKey.TokenLimitRequests = 5
+	ctx := context.Background()
+
+	window := time.Duration(testAPIKey.RateLimitWindowSeconds) * time.Second
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:"+testAPIKey.ID+":token:tok-1", window).Return(int64(6), 30*time.Second, nil)
+
+	result, err := service.CheckTokenLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(5), result.Limit)
+	assert.Equal(t, window, result.Window)
+
+	// Requests authenticated with the key itself aren't counted
+	testAPIKey.TokenID = ""
+	result, err = service.CheckTokenLimit(ctx, testAPIKey)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_Tenants(t *testing.T) {
+	shared := &MockRedisClient{}
+	isolated := &MockRedisClient{}
+	tenants := redis.NewTenants(shared, map[string]redis.ClientInterface{"org-noisy": isolated})
+	service := NewRateLimitService(shared, config.RateLimitConfig{DefaultRequests: 100, DefaultWindow: time.Hour}, WithTenants(tenants))
+	ctx := context.Background()
+
+	// Each key's counter lives under its organization, on the organization's
+	// Redis when it has one
+	shared.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Minute).Return(int64(1), time.Minute, nil)
+	shared.On("IncrementRateLimit", ctx, "org-acme:rate_limit:test-id-123", time.Minute).Return(int64(1), time.Minute, nil)
+	isolated.On("IncrementRateLimit", ctx, "org-noisy:rate_limit:test-id-123", time.Minute).Return(int64(1), time.Minute, nil)
+
+	for _, organizationID := range []string{"", "org-acme", "org-noisy"} {
+		testAPIKey := createTestAPIKeyForRateLimitService()
+		testAPIKey.OrganizationID = organizationID
+		_, err := service.CheckRateLimit(ctx, testAPIKey)
+		assert.NoError(t, err)
+	}
+
+	// Clearing a key searches every tenant
+	shared.On("DeleteByPattern", ctx, []string{
+		"rate_limit:test-id-123", "rate_limit:test-id-123:*", "quota:test-id-123", "quota:test-id-123:*", "penalty:test-id-123",
+		"penalty_violations:test-id-123", "penalty_level:test-id-123", "unique:test-id-123:*",
+		"webhook_throttle:test-id-123:*", "event_throttle:test-id-123:*", "response_cache:test-id-123:*",
+		"*:rate_limit:test-id-123", "*:rate_limit:test-id-123:*", "*:quota:test-id-123", "*:quota:test-id-123:*", "*:penalty:test-id-123",
+		"*:penalty_violations:test-id-123", "*:penalty_level:test-id-123", "*:unique:test-id-123:*",
+		"*:webhook_throttle:test-id-123:*", "*:event_throttle:test-id-123:*", "*:response_cache:test-id-123:*",
+	}).Return(int64(1), nil)
+	isolated.On("DeleteByPattern", ctx, mock.Anything).Return(int64(2), nil)
+
+	deleted, err := service.ClearKeyState(ctx, "test-id-123")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+
+	shared.AssertExpectations(t)
+	isolated.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_ResetReflectsWindowTTL(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	// Setup mock expectations - the jittered window has 67s left
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Duration(60)*time.Second).Return(int64(2), 67*time.Second, nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(67*time.Second), result.ResetTime, time.Second)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_RefundRateLimit(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.RefundLimit = 5
+	ctx := context.Background()
+
+	mockRedisClient.On("RefundRateLimit", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:refunds", int64(3), int64(5)).Return(int64(2), nil)
+	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(4), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
+
+	refund, err := service.RefundRateLimit(ctx, testAPIKey, 3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), refund.Refunded)
+	assert.Equal(t, int64(6), refund.Status.Remaining)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_RefundRateLimit_NotAllowed(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	_, err := service.RefundRateLimit(ctx, testAPIKey, 1)
+
+	assert.ErrorIs(t, err, ErrRefundsNotAllowed)
+	mockRedisClient.AssertNotCalled(t, "RefundRateLimit", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRateLimitService_ClearKeyState(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	ctx := context.Background()
+
+	mockRedisClient.On("DeleteByPattern", ctx, []string{
+		"rate_limit:test-id-123",
+		"rate_limit:test-id-123:*",
+		"quota:test-id-123",
+		"quota:test-id-123:*",
+		"penalty:test-id-123",
+		"penalty_violations:test-id-123",
+		"penalty_level:test-id-123",
+		"unique:test-id-123:*",
+		"webhook_throttle:test-id-123:*",
+		"event_throttle:test-id-123:*",
+		"response_cache:test-id-123:*",
+	}).Return(int64(4), nil)
+
+	deleted, err := service.ClearKeyState(ctx, "test-id-123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), deleted)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_ClearKeyState_RedisError(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	ctx := context.Background()
+
+	mockRedisClient.On("DeleteByPattern", ctx, mock.Anything).Return(int64(0), assert.AnError)
+
+	_, err := service.ClearKeyState(ctx, "test-id-123")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to clear rate limit state")
+}
+
+func TestRateLimitService_CheckAdminLimit(t *testing.T) {
+	mockRedisClient := newRateLimitRedisClient()
+	service := NewRateLimitService(mockRedisClient, config.RateLimitConfig{
+		Admin: config.AdminRateLimitConfig{Requests: 2, Window: time.Minute},
+	})
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "admin_rate_limit:ip:10.0.0.1", time.Minute).Return(int64(3), 30*time.Second, nil)
+
+	result, err := service.CheckAdminLimit(ctx, "ip:10.0.0.1")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(2), result.Limit)
+	assert.Equal(t, int64(0), result.Remaining)
+
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckAdminLimit_Disabled(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+
+	result, err := service.CheckAdminLimit(context.Background(), "ip:10.0.0.1")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	mockRedisClient.AssertNotCalled(t, "IncrementRateLimit")
+}
+
+func createTestRateLimitServiceWithAuthFailures() (*RateLimitService, *MockRedisClient) {
+	mockRedisClient := newRateLimitRedisClient()
+	service := NewRateLimitService(mockRedisClient, config.RateLimitConfig{
+		AuthFailures: config.AuthFailureConfig{Threshold: 3, Period: 10 * time.Minute, Lockout: 15 * time.Minute},
+	})
+	return service, mockRedisClient
+}
+
+func TestRateLimitService_RecordAuthFailure_BelowThreshold(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithAuthFailures()
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "auth_failures:192.0.2.1", 10*time.Minute).Return(int64(2), 10*time.Minute, nil)
+
+	lockout, err := service.RecordAuthFailure(ctx, "192.0.2.1")
+
+	assert.NoError(t, err)
+	assert.Zero(t, lockout)
+	mockRedisClient.AssertNotCalled(t, "SetWithExpiry", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRateLimitService_RecordAuthFailure_LocksOut(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithAuthFailures()
+	ctx := context.Background()
+
+	mockRedisClient.On("IncrementRateLimit", ctx, "auth_failures:192.0.2.1", 10*time.Minute).Return(int64(3), 10*time.Minute, nil)
+	mockRedisClient.On("SetWithExpiry", ctx, "auth_lockout:192.0.2.1", int64(3), 15*time.Minute).Return(nil)
+
+	lockout, err := service.RecordAuthFailure(ctx, "192.0.2.1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, lockout)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_AuthLockout(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitServiceWithAuthFailures()
+	ctx := context.Background()
+
+	mockRedisClient.On("GetTTL", ctx, "auth_lockout:192.0.2.1").Return(5*time.Minute, nil)
+
+	lockout, err := service.AuthLockout(ctx, "192.0.2.1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, lockout)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_ReserveRateLimit(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", mock.AnythingOfType("string"), int64(4), int64(10), time.Minute, DefaultReservationTTL).
+		Return(true, int64(7), 30*time.Second, nil).Once()
+
+	result, err := service.ReserveRateLimit(ctx, testAPIKey, 4, 0)
+
+	require.NoError(t, err)
+	require.NotNil(t, result.Reservation)
+	assert.Regexp(t, "^rsv_[0-9a-f]{24}$", result.Reservation.ID)
+	assert.Equal(t, int64(4), result.Reservation.Units)
+	// Reservations never outlast the window
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), result.Reservation.ExpiresAt, time.Second)
+	assert.Equal(t, int64(3), result.Status.Remaining)
+
+	// Without room for every unit nothing is reserved
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", mock.AnythingOfType("string"), int64(4), int64(10), time.Minute, time.Second).
+		Return(false, int64(7), 30*time.Second, nil).Once()
+
+	result, err = service.ReserveRateLimit(ctx, testAPIKey, 4, time.Second)
+
+	require.NoError(t, err)
+	assert.Nil(t, result.Reservation)
+	assert.False(t, result.Status.Allowed)
+
+	_, err = service.ReserveRateLimit(ctx, testAPIKey, 11, 0)
+	assert.ErrorIs(t, err, ErrReservationTooLarge)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_ReserveRateLimit_QuotaAndOrganization(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.QuotaRequests = 1000
+	testAPIKey.QuotaPeriodSeconds = 86400
+	testAPIKey.OrganizationID = "org-1"
+	testAPIKey.OrganizationLimitRequests = 8
+	testAPIKey.OrganizationLimitWindowSeconds = 60
+	ctx := context.Background()
+
+	// The units are held in the quota and the organization's ceiling too,
+	// for as long as in the window
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", mock.AnythingOfType("string"), int64(4), int64(10), time.Minute, DefaultReservationTTL).
+		Return(true, int64(4), 30*time.Second, nil).Once()
+	mockRedisClient.On("ReserveRateLimit", ctx, "quota:test-id-123", "quota:test-id-123:reservations", mock.AnythingOfType("string"), int64(4), int64(1000), 24*time.Hour, 30*time.Second).
+		Return(true, int64(500), time.Hour, nil).Once()
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:org:org-1", "rate_limit:org:org-1:reservations", mock.AnythingOfType("string"), int64(4), int64(8), time.Minute, 30*time.Second).
+		Return(true, int64(6), 20*time.Second, nil).Once()
+
+	result, err := service.ReserveRateLimit(ctx, testAPIKey, 4, 0)
+
+	require.NoError(t, err)
+	require.NotNil(t, result.Reservation)
+	assert.WithinDuration(t, time.Now().Add(20*time.Second), result.Reservation.ExpiresAt, time.Second)
+
+	// A full organization refuses the reservation, and the units already
+	// held in the window and quota are given back
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", mock.AnythingOfType("string"), int64(3), int64(10), time.Minute, DefaultReservationTTL).
+		Return(true, int64(7), 30*time.Second, nil).Once()
+	mockRedisClient.On("ReserveRateLimit", ctx, "quota:test-id-123", "quota:test-id-123:reservations", mock.AnythingOfType("string"), int64(3), int64(1000), 24*time.Hour, 30*time.Second).
+		Return(true, int64(503), time.Hour, nil).Once()
+	mockRedisClient.On("ReserveRateLimit", ctx, "rate_limit:org:org-1", "rate_limit:org:org-1:reservations", mock.AnythingOfType("string"), int64(3), int64(8), time.Minute, 30*time.Second).
+		Return(false, int64(6), 20*time.Second, nil).Once()
+	mockRedisClient.On("SettleReservation", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", mock.AnythingOfType("string"), true).Return(int64(3), nil).Once()
+	mockRedisClient.On("SettleReservation", ctx, "quota:test-id-123", "quota:test-id-123:reservations", mock.AnythingOfType("string"), true).Return(int64(3), nil).Once()
+
+	result, err = service.ReserveRateLimit(ctx, testAPIKey, 3, 0)
+
+	require.NoError(t, err)
+	assert.Nil(t, result.Reservation)
+	assert.Equal(t, "organization", result.LimitedBy)
+	assert.Equal(t, int64(8), result.Status.Limit)
+
+	// Reservations can't exceed the organization's ceiling either
+	_, err = service.ReserveRateLimit(ctx, testAPIKey, 9, 0)
+	assert.ErrorIs(t, err, ErrReservationTooLarge)
+
+	// Committing and cancelling settle every counter
+	mockRedisClient.On("SettleReservation", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", "rsv_1", false).Return(int64(4), nil).Once()
+	mockRedisClient.On("SettleReservation", ctx, "quota:test-id-123", "quota:test-id-123:reservations", "rsv_1", false).Return(int64(4), nil).Once()
+	mockRedisClient.On("SettleReservation", ctx, "rate_limit:org:org-1", "rate_limit:org:org-1:reservations", "rsv_1", false).Return(int64(4), nil).Once()
+	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(4), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
+
+	_, err = service.CommitReservation(ctx, testAPIKey, "rsv_1")
+	require.NoError(t, err)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_ReserveRateLimit_ScopedToken(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	testAPIKey.TokenID = "token-1"
+	testAPIKey.TokenLimitRequests = 5
+
+	_, err := service.ReserveRateLimit(context.Background(), testAPIKey, 1, 0)
+
+	assert.ErrorIs(t, err, ErrReservationScopedToken)
+	mockRedisClient.AssertNotCalled(t, "ReserveRateLimit")
+}
+
+func TestRateLimitService_SettleReservation(t *testing.T) {
+	service, mockRedisClient := createTestRateLimitService()
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	mockRedisClient.On("SettleReservation", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", "rsv_1", true).Return(int64(4), nil)
+	mockRedisClient.On("SettleReservation", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations", "rsv_2", false).Return(int64(0), nil)
+	mockRedisClient.On("GetRateLimitCount", ctx, "rate_limit:test-id-123").Return(int64(3), nil)
+	mockRedisClient.On("GetTTL", ctx, "rate_limit:test-id-123").Return(30*time.Second, nil)
+
+	settled, err := service.CancelReservation(ctx, testAPIKey, "rsv_1")
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), settled.Units)
+	assert.Equal(t, int64(7), settled.Status.Remaining)
+
+	_, err = service.CommitReservation(ctx, testAPIKey, "rsv_2")
+	assert.ErrorIs(t, err, ErrReservationNotFound)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestRateLimitService_CheckRateLimit_ReleasesExpiredReservations(t *testing.T) {
+	mockRedisClient := &MockRedisClient{}
+	service := NewRateLimitService(mockRedisClient, config.RateLimitConfig{DefaultRequests: 100, DefaultWindow: time.Hour})
+	testAPIKey := createTestAPIKeyForRateLimitService()
+	ctx := context.Background()
+
+	// A request refused only for reservations that weren't committed in
+	// time is allowed once their units are released
+	mockRedisClient.On("IncrementRateLimit", ctx, "rate_limit:test-id-123", time.Minute).Return(int64(11), 30*time.Second, nil)
+	mockRedisClient.On("ReleaseExpiredReservations", ctx, "rate_limit:test-id-123", "rate_limit:test-id-123:reservations").Return(int64(5), nil)
+
+	result, err := service.CheckRateLimit(ctx, testAPIKey)
+
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(4), result.Remaining)
+	mockRedisClient.AssertExpectations(t)
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/metrics"
+)
+
+// DefaultReservationTTL is how long a reservation holds its units when no
+// expiry is asked for
+const DefaultReservationTTL = 5 * time.Minute
+
+var (
+	// ErrReservationNotFound is returned when committing or cancelling a
+	// reservation that doesn't exist, was already settled or has expired
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrReservationTooLarge is returned for reservations of more units than
+	// the key's limit, which could never be granted
+	ErrReservationTooLarge = errors.New("reservation exceeds the rate limit")
+	// ErrReservationScopedToken is returned for reservations made with an
+	// access token scoped to a limit of its own, which reservations can't
+	// hold units of
+	ErrReservationScopedToken = errors.New("access tokens with a limit of their own can't reserve")
+)
+
+// Reservation is a number of units of a key's rate limit held for a job
+type Reservation struct {
+	ID    string
+	Units int64
+	// ExpiresAt is when the units are released unless the reservation is
+	// committed first; never past the end of the window
+	ExpiresAt time.Time
+}
+
+// ReservationResult is the outcome of ReserveRateLimit
+type ReservationResult struct {
+	// Reservation is nil when the window, the plan quota or the
+	// organization's ceiling has too few units left
+	Reservation *Reservation
+	// Status is the key's rate limit after the reservation, or the quota
+	// or ceiling that refused it
+	Status *RateLimitResult
+	// LimitedBy is "quota" or "organization" when the plan quota or the
+	// organization's ceiling refused the reservation rather than the window
+	LimitedBy string
+}
+
+// SettledReservation is the outcome of CommitReservation and
+// CancelReservation
+type SettledReservation struct {
+	// Units is the number of units the reservation held
+	Units int64
+	// Status is the key's rate limit after the reservation was settled
+	Status *RateLimitResult
+}
+
+// reservationKeys returns the counter a key's reservations are taken from
+// and the key they are recorded in
+func reservationKeys(apiKey *database.APIKey) (string, string) {
+	return fmt.Sprintf("rate_limit:%s", apiKey.LimitKeyID()), fmt.Sprintf("rate_limit:%s:reservations", apiKey.LimitKeyID())
+}
+
+// reservedCounter is a counter besides the key's window that reservations
+// also take their units from
+type reservedCounter struct {
+	key             string
+	reservationsKey string
+	limit           int64
+	window          time.Duration
+	// limitedBy is reported when the counter refuses a reservation
+	limitedBy string
+}
+
+// reservedCounters returns the plan quota and organization ceiling a key's
+// reservations take their units from along with its window, so holding
+// units never lets a key past either
+func (s *RateLimitService) reservedCounters(apiKey *database.APIKey) []reservedCounter {
+	var counters []reservedCounter
+	if apiKey.QuotaRequests > 0 && apiKey.QuotaPeriodSeconds > 0 {
+		quotaKey := fmt.Sprintf("quota:%s", apiKey.LimitKeyID())
+		counters = append(counters, reservedCounter{
+			key:             quotaKey,
+			reservationsKey: quotaKey + ":reservations",
+			limit:           int64(apiKey.QuotaRequests),
+			window:          time.Duration(apiKey.QuotaPeriodSeconds) * time.Second,
+			limitedBy:       "quota",
+		})
+	}
+	if apiKey.OrganizationLimitRequests > 0 && apiKey.OrganizationID != "" {
+		window := time.Duration(apiKey.OrganizationLimitWindowSeconds) * time.Second
+		if window <= 0 {
+			window = s.config().DefaultWindow
+		}
+		organizationKey := fmt.Sprintf("rate_limit:org:%s", apiKey.OrganizationID)
+		counters = append(counters, reservedCounter{
+			key:             organizationKey,
+			reservationsKey: organizationKey + ":reservations",
+			limit:           int64(apiKey.OrganizationLimitRequests),
+			window:          window,
+			limitedBy:       "organization",
+		})
+	}
+	return counters
+}
+
+// ReserveRateLimit holds units of the current window of a key's rate limit,
+// so a long-running job can make sure of its capacity before it starts.
+// The units count as requests right away; they are given back if the
+// reservation is cancelled, or isn't committed within ttl, and are never
+// held past the window. The units are taken from the plan quota and the
+// organization's ceiling too. Without room for all of them in each nothing
+// is reserved and the result has no Reservation. Access tokens scoped to a
+// limit of their own can't reserve.
+func (s *RateLimitService) ReserveRateLimit(ctx context.Context, apiKey *database.APIKey, units int64, ttl time.Duration) (*ReservationResult, error) {
+	if units <= 0 {
+		return nil, fmt.Errorf("invalid reservation of %d units: must be positive", units)
+	}
+	if apiKey.TokenID != "" && apiKey.TokenLimitRequests > 0 {
+		return nil, ErrReservationScopedToken
+	}
+	limit, window := s.limitsFor(apiKey)
+	if units > limit {
+		return nil, ErrReservationTooLarge
+	}
+	counters := s.reservedCounters(apiKey)
+	for _, counter := range counters {
+		if units > counter.limit {
+			return nil, ErrReservationTooLarge
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+
+	// Keys serving a cooldown can't reserve either
+	if s.penaltiesEnabled() {
+		penalized, err := s.activePenalty(ctx, apiKey, limit)
+		if err != nil {
+			return nil, err
+		}
+		if penalized != nil {
+			metrics.RateLimitReservations.WithLabelValues("refused").Inc()
+			return &ReservationResult{Status: penalized}, nil
+		}
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate reservation ID: %w", err)
+	}
+	reservation := &Reservation{ID: fmt.Sprintf("rsv_%x", id), Units: units}
+
+	redisKey, reservationsKey := reservationKeys(apiKey)
+	reserved, count, windowTTL, err := s.countersFor(apiKey).ReserveRateLimit(ctx, redisKey, reservationsKey, reservation.ID, units, limit, window, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve rate limit: %w", err)
+	}
+
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	status := &RateLimitResult{
+		Allowed:   reserved,
+		Remaining: remaining,
+		ResetTime: resetTimeFor(windowTTL, window),
+		Limit:     limit,
+		Window:    window,
+	}
+	if !reserved {
+		metrics.RateLimitReservations.WithLabelValues("refused").Inc()
+		return &ReservationResult{Status: status}, nil
+	}
+
+	if windowTTL > 0 && windowTTL < ttl {
+		ttl = windowTTL
+	}
+
+	// The quota and ceiling hold the units as long as the window does. If
+	// one has too few left, those already reserved are given back.
+	for i, counter := range counters {
+		reserved, _, counterTTL, err := s.countersFor(apiKey).ReserveRateLimit(ctx, counter.key, counter.reservationsKey, reservation.ID, units, counter.limit, counter.window, ttl)
+		if err == nil && reserved {
+			if counterTTL > 0 && counterTTL < ttl {
+				ttl = counterTTL
+			}
+			continue
+		}
+		if releaseErr := s.releaseReservation(ctx, apiKey, reservation.ID, counters[:i]); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to reserve %s: %w", counter.limitedBy, err)
+		}
+		metrics.RateLimitReservations.WithLabelValues("refused").Inc()
+		return &ReservationResult{
+			Status: &RateLimitResult{
+				ResetTime:     resetTimeFor(counterTTL, counter.window),
+				Limit:         counter.limit,
+				Window:        counter.window,
+				QuotaExceeded: counter.limitedBy == "quota",
+			},
+			LimitedBy: counter.limitedBy,
+		}, nil
+	}
+	metrics.RateLimitReservations.WithLabelValues("reserved").Inc()
+
+	reservation.ExpiresAt = time.Now().Add(ttl)
+	return &ReservationResult{Reservation: reservation, Status: status}, nil
+}
+
+// releaseReservation gives the units of reservation id back to the key's
+// window and to counters
+func (s *RateLimitService) releaseReservation(ctx context.Context, apiKey *database.APIKey, id string, counters []reservedCounter) error {
+	redisKey, reservationsKey := reservationKeys(apiKey)
+	if _, err := s.countersFor(apiKey).SettleReservation(ctx, redisKey, reservationsKey, id, true); err != nil {
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+	for _, counter := range counters {
+		if _, err := s.countersFor(apiKey).SettleReservation(ctx, counter.key, counter.reservationsKey, id, true); err != nil {
+			return fmt.Errorf("failed to release reservation: %w", err)
+		}
+	}
+	return nil
+}
+
+// CommitReservation keeps the units of reservation id counted, once the
+// job they were held for has started
+func (s *RateLimitService) CommitReservation(ctx context.Context, apiKey *database.APIKey, id string) (*SettledReservation, error) {
+	return s.settleReservation(ctx, apiKey, id, false)
+}
+
+// CancelReservation gives the units of reservation id back to the key's
+// rate limit
+func (s *RateLimitService) CancelReservation(ctx context.Context, apiKey *database.APIKey, id string) (*SettledReservation, error) {
+	return s.settleReservation(ctx, apiKey, id, true)
+}
+
+func (s *RateLimitService) settleReservation(ctx context.Context, apiKey *database.APIKey, id string, release bool) (*SettledReservation, error) {
+	redisKey, reservationsKey := reservationKeys(apiKey)
+	units, err := s.countersFor(apiKey).SettleReservation(ctx, redisKey, reservationsKey, id, release)
+	if err != nil {
+		return nil, fmt.Errorf("failed to settle reservation: %w", err)
+	}
+	if units == 0 {
+		return nil, ErrReservationNotFound
+	}
+	for _, counter := range s.reservedCounters(apiKey) {
+		if _, err := s.countersFor(apiKey).SettleReservation(ctx, counter.key, counter.reservationsKey, id, release); err != nil {
+			return nil, fmt.Errorf("failed to settle %s reservation: %w", counter.limitedBy, err)
+		}
+	}
+	if release {
+		metrics.RateLimitReservations.WithLabelValues("cancelled").Inc()
+	} else {
+		metrics.RateLimitReservations.WithLabelValues("committed").Inc()
+	}
+
+	status, err := s.GetRateLimitStatus(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SettledReservation{Units: units, Status: status}, nil
+}
+
+// releaseExpiredReservations gives back the units of a key's reservations
+// that weren't committed in time, returning how many were released
+func (s *RateLimitService) releaseExpiredReservations(ctx context.Context, apiKey *database.APIKey) (int64, error) {
+	redisKey, reservationsKey := reservationKeys(apiKey)
+	released, err := s.countersFor(apiKey).ReleaseExpiredReservations(ctx, redisKey, reservationsKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release expired reservations: %w", err)
+	}
+	return released, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+)
+
+// Strategies for telling the cached responses of a key apart
+const (
+	// ResponseCacheKeyURL caches a response per path and query string; the
+	// order of the query parameters doesn't matter
+	ResponseCacheKeyURL = "url"
+	// ResponseCacheKeyPath caches a response per path, ignoring the query
+	// string
+	ResponseCacheKeyPath = "path"
+)
+
+// CachedResponse is a response stored in the response cache
+type CachedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body"`
+}
+
+// ResponseCacheStore keeps cached responses until they expire, e.g. in
+// Redis
+type ResponseCacheStore interface {
+	// GetCachedResponse returns nil when nothing is cached under key
+	GetCachedResponse(ctx context.Context, key string) ([]byte, error)
+	SetCachedResponse(ctx context.Context, key string, response []byte, ttl time.Duration) error
+}
+
+// ResponseCache caches the responses to GET requests per API key for TTL,
+// so that repeat requests are answered without reaching the handler. Keys
+// never see each other's responses, and neither do their end users.
+type ResponseCache struct {
+	store         ResponseCacheStore
+	ttl           time.Duration
+	keyBy         string
+	endUserHeader string
+}
+
+// NewResponseCache caches responses in store for ttl, telling requests apart
+// by keyBy: ResponseCacheKeyURL or ResponseCacheKeyPath. Requests for
+// different end users, named by endUserHeader, are cached apart.
+func NewResponseCache(store ResponseCacheStore, ttl time.Duration, keyBy, endUserHeader string) *ResponseCache {
+	return &ResponseCache{store: store, ttl: ttl, keyBy: keyBy, endUserHeader: endUserHeader}
+}
+
+// Lookup returns the response cached for the key's request, or nil when
+// there is none
+func (r *ResponseCache) Lookup(ctx context.Context, apiKey *database.APIKey, request *http.Request) (*CachedResponse, error) {
+	data, err := r.store.GetCachedResponse(ctx, r.cacheKey(apiKey, request))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var response CachedResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+	return &response, nil
+}
+
+// Store caches response to the key's request
+func (r *ResponseCache) Store(ctx context.Context, apiKey *database.APIKey, request *http.Request, response *CachedResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode cached response: %w", err)
+	}
+	return r.store.SetCachedResponse(ctx, r.cacheKey(apiKey, request), data, r.ttl)
+}
+
+// cacheKey is where the response to the key's request is cached:
+// response_cache:<key ID>:<hash of the request>. The request's Accept
+// header is part of the hash, since responses can be negotiated on it, and
+// so is its end user, whose responses are their own.
+func (r *ResponseCache) cacheKey(apiKey *database.APIKey, request *http.Request) string {
+	target := request.Method + " " + request.URL.Path
+	if r.keyBy != ResponseCacheKeyPath {
+		target += "?" + request.URL.Query().Encode()
+	}
+	target += "\nAccept: " + strings.Join(request.Header.Values("Accept"), ", ")
+	if r.endUserHeader != "" {
+		target += "\n" + r.endUserHeader + ": " + strings.Join(request.Header.Values(r.endUserHeader), ", ")
+	}
+	return fmt.Sprintf("response_cache:%s:%x", apiKey.ID, sha256.Sum256([]byte(target)))
+}
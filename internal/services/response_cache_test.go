@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResponseCacheStore keeps cached responses in memory
+type fakeResponseCacheStore struct {
+	responses map[string][]byte
+	ttls      map[string]time.Duration
+}
+
+func (s *fakeResponseCacheStore) GetCachedResponse(ctx context.Context, key string) ([]byte, error) {
+	return s.responses[key], nil
+}
+
+func (s *fakeResponseCacheStore) SetCachedResponse(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	s.responses[key] = response
+	s.ttls[key] = ttl
+	return nil
+}
+
+func TestResponseCache(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeResponseCacheStore{responses: map[string][]byte{}, ttls: map[string]time.Duration{}}
+	cache := NewResponseCache(store, time.Minute, ResponseCacheKeyURL, "X-End-User-ID")
+	apiKey := &database.APIKey{ID: "key-1"}
+	other := &database.APIKey{ID: "key-2"}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/items?page=2&sort=name", nil)
+	cached, err := cache.Lookup(ctx, apiKey, request)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+
+	response := &CachedResponse{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"items":[]}`)}
+	require.NoError(t, cache.Store(ctx, apiKey, request, response))
+	for key, ttl := range store.ttls {
+		assert.Regexp(t, `^response_cache:key-1:[0-9a-f]{64}$`, key)
+		assert.Equal(t, time.Minute, ttl)
+	}
+
+	// The order of the query parameters doesn't matter
+	cached, err = cache.Lookup(ctx, apiKey, httptest.NewRequest(http.MethodGet, "/api/items?sort=name&page=2", nil))
+	require.NoError(t, err)
+	assert.Equal(t, response, cached)
+
+	// Keys don't see each other's responses, nor those of other queries
+	cached, err = cache.Lookup(ctx, other, request)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+	cached, err = cache.Lookup(ctx, apiKey, httptest.NewRequest(http.MethodGet, "/api/items?page=3", nil))
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+
+	// Nor those negotiated for other Accept headers
+	msgpack := httptest.NewRequest(http.MethodGet, "/api/items?page=2&sort=name", nil)
+	msgpack.Header.Set("Accept", "application/msgpack")
+	cached, err = cache.Lookup(ctx, apiKey, msgpack)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+
+	// Nor those of other end users of the key
+	endUser := httptest.NewRequest(http.MethodGet, "/api/items?page=2&sort=name", nil)
+	endUser.Header.Set("X-End-User-ID", "user-1")
+	cached, err = cache.Lookup(ctx, apiKey, endUser)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+	require.NoError(t, cache.Store(ctx, apiKey, endUser, response))
+	cached, err = cache.Lookup(ctx, apiKey, endUser)
+	require.NoError(t, err)
+	assert.Equal(t, response, cached)
+	endUser.Header.Set("X-End-User-ID", "user-2")
+	cached, err = cache.Lookup(ctx, apiKey, endUser)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+
+	// Keyed by path, the query string is ignored
+	byPath := NewResponseCache(store, time.Minute, ResponseCacheKeyPath, "X-End-User-ID")
+	require.NoError(t, byPath.Store(ctx, apiKey, request, response))
+	cached, err = byPath.Lookup(ctx, apiKey, httptest.NewRequest(http.MethodGet, "/api/items?page=3", nil))
+	require.NoError(t, err)
+	assert.Equal(t, response, cached)
+}
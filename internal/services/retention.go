@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy deletes the rows of Table whose Column, a timestamp, is
+// older than Period. A zero Period keeps the rows forever.
+type RetentionPolicy struct {
+	Table  string
+	Column string
+	Period time.Duration
+}
+
+// RetentionJob periodically deletes rows that have outlived their retention
+// period, so tables that grow with traffic stay bounded
+type RetentionJob struct {
+	db       database.DBInterface
+	policies []RetentionPolicy
+	interval time.Duration
+}
+
+func NewRetentionJob(db database.DBInterface, policies []RetentionPolicy, interval time.Duration) *RetentionJob {
+	return &RetentionJob{
+		db:       db,
+		policies: policies,
+		interval: interval,
+	}
+}
+
+// Run prunes on every tick until ctx is cancelled. A non-positive interval
+// disables the job.
+func (j *RetentionJob) Run(ctx context.Context) {
+	if j.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := j.Prune(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("Retention run failed", zap.Error(err))
+			}
+			for table, rows := range deleted {
+				if rows > 0 {
+					logging.FromContext(ctx).Info("Pruned old rows", zap.String("table", table), zap.Int64("rows", rows))
+				}
+			}
+		}
+	}
+}
+
+// Prune deletes the expired rows of every policy and returns how many were
+// deleted per table. A failing policy doesn't stop the others; the first
+// error is returned.
+func (j *RetentionJob) Prune(ctx context.Context) (map[string]int64, error) {
+	deleted := make(map[string]int64, len(j.policies))
+	var firstErr error
+	for _, policy := range j.policies {
+		if policy.Period <= 0 {
+			continue
+		}
+		cutoff := time.Now().Add(-policy.Period)
+		result, err := j.db.ExecContext(ctx, `DELETE FROM `+policy.Table+` WHERE `+policy.Column+` < $1`, cutoff)
+		var rows int64
+		if err == nil {
+			rows, err = result.RowsAffected()
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to prune %s: %w", policy.Table, err)
+			}
+			continue
+		}
+		deleted[policy.Table] = rows
+		metrics.RetentionRowsDeleted.WithLabelValues(policy.Table).Add(float64(rows))
+		metrics.RetentionLastRunRows.WithLabelValues(policy.Table).Set(float64(rows))
+	}
+	return deleted, firstErr
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionJob_Prune(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	job := NewRetentionJob(db, []RetentionPolicy{
+		{Table: "usage_logs", Column: "created_at", Period: 30 * 24 * time.Hour},
+		{Table: "limit_overrides", Column: "expires_at", Period: 0},
+		{Table: "api_key_usage_daily", Column: "day", Period: time.Hour},
+	}, time.Hour)
+
+	// Policies without a period are skipped, and a failure doesn't stop the
+	// remaining policies
+	mock.ExpectExec(`DELETE FROM usage_logs WHERE created_at < \$1`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnError(assert.AnError)
+	mock.ExpectExec(`DELETE FROM api_key_usage_daily WHERE day < \$1`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	deleted, err := job.Prune(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to prune usage_logs")
+	assert.Equal(t, map[string]int64{"api_key_usage_daily": 4}, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
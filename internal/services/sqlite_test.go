@@ -0,0 +1,784 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSQLiteDB returns a migrated SQLite database, which runs the services'
+// queries for real without Postgres
+func newSQLiteDB(t *testing.T) *database.DB {
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema())
+	return db
+}
+
+func TestAPIKeyService_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	apiKey, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{
+		Name:                   "Local",
+		RateLimitRequests:      10,
+		RateLimitWindowSeconds: 60,
+		ExpiresAt:              &expiresAt,
+		AllowedCIDRs:           []string{"10.0.0.0/8"},
+		OwnerEmail:             "team@example.com",
+	})
+	require.NoError(t, err)
+
+	record, err := service.ValidateAPIKey(context.Background(), apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, "Local", record.Name)
+	assert.Equal(t, []string{"10.0.0.0/8"}, record.AllowedCIDRs)
+	require.NotNil(t, record.ExpiresAt)
+	assert.WithinDuration(t, expiresAt, *record.ExpiresAt, time.Millisecond)
+
+	keys, err := service.ListAPIKeys(context.Background(), APIKeyFilter{Owner: "TEAM@example.com"})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	override, err := service.CreateLimitOverride(context.Background(), record.ID, 500, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, override.APIKeyID)
+	record, err = service.ValidateAPIKey(context.Background(), apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, 500, record.OverrideRequests)
+
+	rotated, err := service.RotateAPIKey(context.Background(), record.ID, time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), rotated.PreviousKeyExpiresAt, time.Minute)
+	_, err = service.ValidateAPIKey(context.Background(), apiKey)
+	assert.NoError(t, err, "previous key works during the grace period")
+	_, err = service.ValidateAPIKey(context.Background(), rotated.APIKey)
+	assert.NoError(t, err)
+
+	require.NoError(t, service.DeleteAPIKey(context.Background(), record.ID))
+	_, err = service.ValidateAPIKey(context.Background(), rotated.APIKey)
+	assert.Error(t, err)
+
+	restored, err := service.RestoreAPIKey(context.Background(), record.ID)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	_, err = service.ValidateAPIKey(context.Background(), rotated.APIKey)
+	assert.NoError(t, err, "restored keys work again")
+	_, err = service.RestoreAPIKey(context.Background(), record.ID)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound, "only deleted keys are restored")
+
+	id, err := service.PurgeAPIKey(context.Background(), record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, id)
+	_, err = service.PurgeAPIKey(context.Background(), record.ID)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyService_SQLiteSampleKey(t *testing.T) {
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	record, err := service.ValidateAPIKey(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "Test API Key", record.Name)
+}
+
+func TestBackgroundWriters_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	past := time.Now().Add(-time.Minute)
+	_, err := service.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Expired", ExpiresAt: &past})
+	require.NoError(t, err)
+	publisher := &recordingPublisher{}
+	swept, err := NewExpirySweeper(db, publisher, time.Minute).Sweep(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, swept)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, "Expired", publisher.events[0].Data["name"])
+
+	record, err := service.ValidateAPIKey(context.Background(), "hello")
+	require.NoError(t, err)
+	tracker := NewLastUsedTracker(db, time.Minute)
+	tracker.RecordUse(record.ID)
+	flushed, err := tracker.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	detail, err := service.GetAPIKey(context.Background(), record.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, detail.LastUsedAt)
+
+	ctx := context.Background()
+	today := time.Now().UTC().Format(usageDayFormat)
+	redisClient := &MockRedisClient{}
+	redisClient.On("DrainHash", ctx, "usage:pending").Return(map[string]int64{record.ID + ":" + today: 3}, nil).Twice()
+	usage := NewUsageService(redisClient, db, time.Minute)
+	for i := 0; i < 2; i++ {
+		written, err := usage.Flush(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, written)
+	}
+
+	counts, err := usage.GetUsage(context.Background(), record.ID, 7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counts.LifetimeRequests)
+	require.Len(t, counts.Daily, 1)
+	assert.Equal(t, today, counts.Daily[0].Day)
+	assert.Equal(t, int64(6), counts.Daily[0].Requests)
+}
+
+func TestUsageLogWriter_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	record, err := service.ValidateAPIKey(context.Background(), "hello")
+	require.NoError(t, err)
+
+	writer := NewUsageLogWriter(db, 10, 10, time.Minute)
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, Decision: "allowed"})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 429, Cost: 1, Decision: "limited"})
+	writer.Log(database.UsageLog{APIKeyID: "00000000-0000-0000-0000-000000000000", Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1})
+
+	written, err := writer.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+
+	// Records for keys that no longer exist are skipped
+	var logged int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM usage_logs WHERE api_key_id = $1 AND route = $2`, record.ID, "/api/test").Scan(&logged))
+	assert.Equal(t, 2, logged)
+	var total int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM usage_logs`).Scan(&total))
+	assert.Equal(t, 2, total)
+}
+
+func TestAnalyticsService_KeyAnalytics_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	record, err := service.ValidateAPIKey(context.Background(), "hello")
+	require.NoError(t, err)
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	writer := NewUsageLogWriter(db, 10, 10, time.Minute)
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: hour.Add(-2*time.Hour + time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 2, CreatedAt: hour.Add(-2*time.Hour + 59*time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 429, Cost: 1, CreatedAt: hour.Add(-2*time.Hour + 30*time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: hour.Add(time.Minute)})
+	_, err = writer.Flush(context.Background())
+	require.NoError(t, err)
+
+	analytics, err := NewAnalyticsService(db).KeyAnalytics(context.Background(), record.ID, AnalyticsQuery{
+		From:        hour.Add(-150 * time.Minute),
+		To:          hour.Add(30 * time.Minute),
+		Granularity: "hour",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, hour.Add(-3*time.Hour), analytics.From, "from is rounded down to its bucket")
+	require.Len(t, analytics.Buckets, 4)
+	assert.Equal(t, database.UsageBucket{Start: hour.Add(-3 * time.Hour)}, analytics.Buckets[0])
+	assert.Equal(t, database.UsageBucket{Start: hour.Add(-2 * time.Hour), Requests: 3, Limited: 1, AverageCost: 4.0 / 3}, analytics.Buckets[1])
+	assert.Equal(t, database.UsageBucket{Start: hour.Add(-time.Hour)}, analytics.Buckets[2], "empty buckets are listed")
+	assert.Equal(t, int64(1), analytics.Buckets[3].Requests)
+
+	_, err = NewAnalyticsService(db).KeyAnalytics(context.Background(), record.ID, AnalyticsQuery{From: hour.Add(-48 * time.Hour), To: hour, Granularity: "minute"})
+	assert.ErrorContains(t, err, "the period spans 2880 minutes")
+}
+
+func TestAnalyticsService_TopConsumers_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	ids := map[string]string{}
+	for _, name := range []string{"busy", "refused", "costly"} {
+		key, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: name})
+		require.NoError(t, err)
+		record, err := service.ValidateAPIKey(ctx, key)
+		require.NoError(t, err)
+		ids[name] = record.ID
+	}
+
+	writer := NewUsageLogWriter(db, 100, 100, time.Minute)
+	log := func(name string, count, status, cost int, age time.Duration) {
+		for i := 0; i < count; i++ {
+			writer.Log(database.UsageLog{APIKeyID: ids[name], Method: "GET", Route: "/api/test", StatusCode: status, Cost: cost, CreatedAt: time.Now().Add(-age)})
+		}
+	}
+	log("busy", 20, 200, 1, time.Minute)
+	log("refused", 5, 200, 1, time.Minute)
+	log("refused", 5, 429, 1, time.Minute)
+	log("costly", 4, 200, 10, time.Minute)
+	log("costly", 30, 200, 1, 48*time.Hour)
+	_, err := writer.Flush(ctx)
+	require.NoError(t, err)
+
+	top, err := NewAnalyticsService(db).TopConsumers(ctx, TopConsumersQuery{Window: 24 * time.Hour, Limit: 2, MinRequests: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "24h0m0s", top.Window)
+	require.Len(t, top.ByRequests, 2, "rankings are limited")
+	assert.Equal(t, ids["busy"], top.ByRequests[0].APIKeyID)
+	assert.Equal(t, "busy", top.ByRequests[0].Name)
+	assert.Equal(t, int64(20), top.ByRequests[0].Requests, "requests outside the window don't count")
+	assert.Equal(t, ids["refused"], top.ByRequests[1].APIKeyID)
+	require.Len(t, top.ByLimitedRate, 1, "keys without refusals aren't ranked by 429 rate")
+	assert.Equal(t, 0.5, top.ByLimitedRate[0].LimitedRate)
+	assert.Equal(t, ids["costly"], top.ByCost[0].APIKeyID)
+	assert.Equal(t, int64(40), top.ByCost[0].Cost)
+
+	top, err = NewAnalyticsService(db).TopConsumers(ctx, TopConsumersQuery{Window: 24 * time.Hour, Limit: 2, MinRequests: 20})
+	require.NoError(t, err)
+	assert.Empty(t, top.ByLimitedRate, "keys with fewer than MinRequests requests aren't ranked by 429 rate")
+
+	// The hourly rollups give the same rankings
+	_, err = NewUsageRollupJob(db, time.Minute, 3*time.Hour).Aggregate(ctx, time.Now().Add(-72*time.Hour), time.Now())
+	require.NoError(t, err)
+	query := TopConsumersQuery{Window: 24 * time.Hour, Limit: 2, MinRequests: 5}
+	fromLogs, err := NewAnalyticsService(db).TopConsumers(ctx, query)
+	require.NoError(t, err)
+	fromRollups, err := NewAnalyticsService(db, WithUsageRollups()).TopConsumers(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, fromLogs.ByRequests, fromRollups.ByRequests)
+	assert.Equal(t, fromLogs.ByLimitedRate, fromRollups.ByLimitedRate)
+	assert.Equal(t, fromLogs.ByCost, fromRollups.ByCost)
+}
+
+func TestUsageRollupJob_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	record, err := service.ValidateAPIKey(ctx, "hello")
+	require.NoError(t, err)
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	writer := NewUsageLogWriter(db, 10, 10, time.Minute)
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: hour.Add(-2*time.Hour + time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 429, Cost: 1, CreatedAt: hour.Add(-2*time.Hour + 30*time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "POST", Route: "/api/items", StatusCode: 200, Cost: 3, CreatedAt: hour.Add(-2*time.Hour + 45*time.Minute)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: hour.Add(time.Minute)})
+	_, err = writer.Flush(ctx)
+	require.NoError(t, err)
+
+	job := NewUsageRollupJob(db, time.Minute, 3*time.Hour)
+	for i := 0; i < 2; i++ {
+		written, err := job.Aggregate(ctx, hour.Add(-3*time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 3, written, "one rollup per key, route and hour, however often the period is aggregated")
+	}
+
+	var requests, limited, cost int64
+	require.NoError(t, db.QueryRow(`SELECT requests, limited, cost FROM usage_rollups_hourly WHERE route = '/api/test' AND bucket_start = ?`, hour.Add(-2*time.Hour).Format("2006-01-02 15:04:05.000")).Scan(&requests, &limited, &cost))
+	assert.Equal(t, []int64{2, 1, 2}, []int64{requests, limited, cost})
+
+	// A record logged late is counted in its hour when the hour is
+	// aggregated again
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: hour.Add(-2*time.Hour + 50*time.Minute)})
+	_, err = writer.Flush(ctx)
+	require.NoError(t, err)
+	_, err = job.Aggregate(ctx, hour.Add(-2*time.Hour), time.Now())
+	require.NoError(t, err)
+
+	var total int64
+	require.NoError(t, db.QueryRow(`SELECT SUM(requests) FROM usage_rollups_daily`).Scan(&total))
+	assert.Equal(t, int64(5), total, "daily rollups follow the hourly ones")
+
+	query := AnalyticsQuery{From: hour.Add(-3 * time.Hour), To: hour.Add(30 * time.Minute), Granularity: "hour"}
+	fromLogs, err := NewAnalyticsService(db).KeyAnalytics(ctx, record.ID, query)
+	require.NoError(t, err)
+	fromRollups, err := NewAnalyticsService(db, WithUsageRollups()).KeyAnalytics(ctx, record.ID, query)
+	require.NoError(t, err)
+	assert.Equal(t, fromLogs, fromRollups)
+	assert.Equal(t, database.UsageBucket{Start: hour.Add(-2 * time.Hour), Requests: 4, Limited: 1, AverageCost: 1.5}, fromRollups.Buckets[1])
+}
+
+func TestRetentionJob_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	record, err := service.ValidateAPIKey(context.Background(), "hello")
+	require.NoError(t, err)
+
+	writer := NewUsageLogWriter(db, 10, 10, time.Minute)
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1, CreatedAt: time.Now().Add(-48 * time.Hour)})
+	writer.Log(database.UsageLog{APIKeyID: record.ID, Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1})
+	_, err = writer.Flush(context.Background())
+	require.NoError(t, err)
+
+	job := NewRetentionJob(db, []RetentionPolicy{{Table: "usage_logs", Column: "created_at", Period: 24 * time.Hour}}, time.Hour)
+	deleted, err := job.Prune(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted["usage_logs"])
+
+	var remaining int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM usage_logs`).Scan(&remaining))
+	assert.Equal(t, 1, remaining)
+}
+
+func TestAPIKeyService_CreateAPIKeys_SQLite(t *testing.T) {
+	ctx := context.Background()
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	apiKeys, err := service.CreateAPIKeys(ctx, []CreateAPIKeyParams{
+		{Name: "First", OwnerEmail: "bulk@example.com"},
+		{Name: "Second", OwnerEmail: "bulk@example.com"},
+	})
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 2)
+	for i, name := range []string{"First", "Second"} {
+		record, err := service.ValidateAPIKey(ctx, apiKeys[i])
+		require.NoError(t, err)
+		assert.Equal(t, name, record.Name)
+	}
+
+	// The missing plan fails the second key, and the first is rolled back
+	_, err = service.CreateAPIKeys(ctx, []CreateAPIKeyParams{
+		{Name: "Third", OwnerEmail: "bulk@example.com"},
+		{Name: "Fourth", OwnerEmail: "bulk@example.com", PlanID: "00000000-0000-4000-8000-000000000000"},
+	})
+	assert.ErrorContains(t, err, "key 1")
+	keys, err := service.ListAPIKeys(ctx, APIKeyFilter{Owner: "bulk@example.com"})
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestAPIKeyService_AlertThresholds_SQLite(t *testing.T) {
+	ctx := context.Background()
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	parent, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Parent"})
+	require.NoError(t, err)
+	record, err := service.GetAPIKey(ctx, parent)
+	require.NoError(t, err)
+	sub, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Sub", ParentID: record.ID})
+	require.NoError(t, err)
+
+	updated, err := service.UpdateAPIKeyAlertThresholds(ctx, record.ID, []int64{100, 80, 80})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{80, 100}, updated.AlertThresholds)
+
+	// Sub-keys are warned at their parent's thresholds
+	validated, err := service.ValidateAPIKey(ctx, sub)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{80, 100}, validated.AlertThresholds)
+
+	updated, err = service.UpdateAPIKeyAlertThresholds(ctx, record.ID, nil)
+	require.NoError(t, err)
+	assert.Nil(t, updated.AlertThresholds)
+
+	_, err = service.UpdateAPIKeyAlertThresholds(ctx, record.ID, []int64{0})
+	assert.ErrorContains(t, err, "invalid alert threshold 0")
+	_, err = service.UpdateAPIKeyAlertThresholds(ctx, "00000000-0000-4000-8000-000000000000", []int64{80})
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyService_RefundLimit_SQLite(t *testing.T) {
+	ctx := context.Background()
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	parent, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Parent"})
+	require.NoError(t, err)
+	record, err := service.GetAPIKey(ctx, parent)
+	require.NoError(t, err)
+	assert.Zero(t, record.RefundLimit)
+	sub, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Sub", ParentID: record.ID})
+	require.NoError(t, err)
+
+	updated, err := service.UpdateAPIKeyRefundLimit(ctx, record.ID, 25)
+	require.NoError(t, err)
+	assert.Equal(t, 25, updated.RefundLimit)
+
+	// Sub-keys refund within their parent's limit
+	validated, err := service.ValidateAPIKey(ctx, sub)
+	require.NoError(t, err)
+	assert.Equal(t, 25, validated.RefundLimit)
+
+	_, err = service.UpdateAPIKeyRefundLimit(ctx, record.ID, -1)
+	assert.ErrorContains(t, err, "invalid refund limit")
+	_, err = service.UpdateAPIKeyRefundLimit(ctx, "00000000-0000-4000-8000-000000000000", 5)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyService_LimitResponse_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	plans := NewPlanService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	plan, err := plans.CreatePlan(ctx, &database.Plan{
+		Name:                   "starter",
+		RateLimitRequests:      10,
+		RateLimitWindowSeconds: 60,
+		LimitResponse:          &database.LimitResponse{Body: "Upgrade at https://example.com/pricing"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, plan.LimitResponse)
+	assert.Equal(t, "Upgrade at https://example.com/pricing", plan.LimitResponse.Body)
+
+	parent, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Parent", PlanID: plan.ID})
+	require.NoError(t, err)
+	record, err := service.GetAPIKey(ctx, parent)
+	require.NoError(t, err)
+	assert.Nil(t, record.LimitResponse)
+	sub, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Sub", ParentID: record.ID})
+	require.NoError(t, err)
+
+	// Keys without one of their own use their plan's
+	validated, err := service.ValidateAPIKey(ctx, sub)
+	require.NoError(t, err)
+	require.NotNil(t, validated.LimitResponse)
+	assert.Equal(t, plan.LimitResponse.Body, validated.LimitResponse.Body)
+
+	updated, err := service.UpdateAPIKeyLimitResponse(ctx, record.ID, &database.LimitResponse{
+		Body:    `{"error": "limit {limit} reached"}`,
+		Headers: map[string]string{"x-upgrade-url": "https://example.com/upgrade"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, updated.LimitResponse)
+	assert.Equal(t, map[string]string{"X-Upgrade-Url": "https://example.com/upgrade"}, updated.LimitResponse.Headers)
+	validated, err = service.ValidateAPIKey(ctx, sub)
+	require.NoError(t, err)
+	assert.Equal(t, `{"error": "limit {limit} reached"}`, validated.LimitResponse.Body)
+
+	// An empty response clears the key's own
+	updated, err = service.UpdateAPIKeyLimitResponse(ctx, record.ID, &database.LimitResponse{})
+	require.NoError(t, err)
+	assert.Nil(t, updated.LimitResponse)
+
+	_, err = service.UpdateAPIKeyLimitResponse(ctx, record.ID, &database.LimitResponse{Headers: map[string]string{"Bad Header": "x"}})
+	assert.ErrorContains(t, err, "invalid limit response header name")
+	_, err = service.UpdateAPIKeyLimitResponse(ctx, record.ID, &database.LimitResponse{Headers: map[string]string{"X-Note": "a\r\nSet-Cookie: b"}})
+	assert.ErrorContains(t, err, "invalid value for limit response header")
+	_, err = service.UpdateAPIKeyLimitResponse(ctx, "00000000-0000-4000-8000-000000000000", &database.LimitResponse{Body: "x"})
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyService_PublishesLifecycleEvents_SQLite(t *testing.T) {
+	ctx := context.Background()
+	publisher := &recordingPublisher{}
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)), WithEventPublisher(publisher))
+
+	apiKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Events"})
+	require.NoError(t, err)
+	record, err := service.GetAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	_, err = service.UpdateAPIKeyOwner(ctx, record.ID, "Ops", "ops@example.com")
+	require.NoError(t, err)
+	rotated, err := service.RotateAPIKey(ctx, apiKey, time.Hour)
+	require.NoError(t, err)
+	// Referenced by the key itself, so the event's ID is looked up
+	require.NoError(t, service.DeleteAPIKey(ctx, rotated.APIKey))
+	_, err = service.RestoreAPIKey(ctx, record.ID)
+	require.NoError(t, err)
+	_, err = service.PurgeAPIKey(ctx, record.ID)
+	require.NoError(t, err)
+	// Failed changes publish nothing
+	_, err = service.PurgeAPIKey(ctx, record.ID)
+	require.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+	var types []string
+	for _, event := range publisher.events {
+		types = append(types, event.Type)
+		assert.Equal(t, record.ID, event.APIKeyID, event.Type)
+	}
+	assert.Equal(t, []string{
+		events.APIKeyCreated,
+		events.APIKeyUpdated,
+		events.APIKeyRotated,
+		events.APIKeyDeactivated,
+		events.APIKeyRestored,
+		events.APIKeyPurged,
+	}, types)
+	assert.Equal(t, KeyPrefix(apiKey), publisher.events[0].Data["key_prefix"])
+	assert.Equal(t, rotated.KeyPrefix, publisher.events[2].Data["key_prefix"])
+}
+
+func TestPlanService_ReassignPlan_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	plans := NewPlanService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+
+	planIDs := map[string]string{}
+	all, err := plans.ListPlans(ctx)
+	require.NoError(t, err)
+	for _, plan := range all {
+		planIDs[plan.Name] = plan.ID
+	}
+	apiKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Free", PlanID: planIDs["free"]})
+	require.NoError(t, err)
+
+	_, err = plans.ReassignPlan(ctx, planIDs["free"], "00000000-0000-4000-8000-000000000000", true)
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+	_, err = plans.GetPlan(ctx, planIDs["free"])
+	assert.NoError(t, err, "nothing changes when the target plan is missing")
+
+	reassigned, err := plans.ReassignPlan(ctx, planIDs["free"], planIDs["pro"], true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reassigned)
+
+	record, err := service.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, planIDs["pro"], record.PlanID)
+	_, err = plans.GetPlan(ctx, planIDs["free"])
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+}
+
+func TestOrganizationService_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	organizations := NewOrganizationService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	ctx := context.Background()
+
+	acme, err := organizations.CreateOrganization(ctx, &database.Organization{Name: "Acme"})
+	require.NoError(t, err)
+	globex, err := organizations.CreateOrganization(ctx, &database.Organization{Name: "Globex"})
+	require.NoError(t, err)
+	project, err := organizations.CreateProject(ctx, acme.ID, "Checkout")
+	require.NoError(t, err)
+	assert.Equal(t, acme.ID, project.OrganizationID)
+	_, err = organizations.CreateProject(ctx, "00000000-0000-0000-0000-000000000000", "Orphan")
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+
+	listed, err := organizations.ListOrganizations(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, "Acme", listed[0].Name)
+	projects, err := organizations.ListProjects(ctx, globex.ID)
+	require.NoError(t, err)
+	assert.Empty(t, projects)
+
+	apiKey, err := service.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Checkout", ProjectID: project.ID})
+	require.NoError(t, err)
+	record, err := service.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, project.ID, record.ProjectID)
+	assert.Equal(t, acme.ID, record.OrganizationID)
+
+	keys, err := service.ListAPIKeys(ctx, APIKeyFilter{OrganizationID: acme.ID})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, acme.ID, keys[0].OrganizationID)
+	keys, err = service.ListAPIKeys(ctx, APIKeyFilter{OrganizationID: globex.ID})
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	acme, err = organizations.UpdateOrganization(ctx, acme.ID, &database.Organization{Name: "Acme", RateLimitRequests: 10000, RateLimitWindowSeconds: 60})
+	require.NoError(t, err)
+	assert.Equal(t, 10000, acme.RateLimitRequests)
+	record, err = service.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, 10000, record.OrganizationLimitRequests)
+	assert.Equal(t, 60, record.OrganizationLimitWindowSeconds)
+	_, err = organizations.UpdateOrganization(ctx, "00000000-0000-0000-0000-000000000000", &database.Organization{Name: "Nobody"})
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+
+	plans := NewPlanService(db)
+	all, err := plans.ListPlans(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+	acme, err = organizations.UpdateOrganization(ctx, acme.ID, &database.Organization{Name: "Acme", PlanID: all[0].ID, MaxKeys: 5})
+	require.NoError(t, err)
+	assert.Equal(t, all[0].ID, acme.PlanID)
+	assert.Equal(t, 5, acme.MaxKeys)
+	assert.ErrorIs(t, plans.DeletePlan(ctx, all[0].ID), ErrPlanInUse, "organizations keep their self-service plan")
+}
+
+func TestAPIKeyService_MaxOrganizationKeys_SQLite(t *testing.T) {
+	db := newSQLiteDB(t)
+	organizations := NewOrganizationService(db)
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	ctx := context.Background()
+
+	acme, err := organizations.CreateOrganization(ctx, &database.Organization{Name: "Acme"})
+	require.NoError(t, err)
+	project, err := organizations.CreateProject(ctx, acme.ID, "Checkout")
+	require.NoError(t, err)
+	params := CreateAPIKeyParams{Name: "Checkout", ProjectID: project.ID, OrganizationID: acme.ID, MaxOrganizationKeys: 3}
+
+	// Concurrent creations can't both take the last slot
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = service.CreateAPIKey(ctx, params)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrKeyLimitReached)
+	}
+	assert.Equal(t, 3, created)
+
+	// Deleted keys free their slot
+	keys, err := service.ListAPIKeys(ctx, APIKeyFilter{OrganizationID: acme.ID})
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	require.NoError(t, service.DeleteAPIKey(ctx, keys[0].ID))
+	_, err = service.CreateAPIKey(ctx, params)
+	assert.NoError(t, err)
+}
+
+func TestAPIKeyService_ExportImport_SQLite(t *testing.T) {
+	ctx := context.Background()
+	source := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+	target := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	apiKey, err := source.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Migrated", OwnerEmail: "team@example.com", AllowedCIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	record, err := source.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	_, err = source.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Sub", ParentID: record.ID})
+	require.NoError(t, err)
+
+	exported, err := source.ExportAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, exported, 3, "the sample key, the parent and its sub-key")
+
+	// Every database has its own sample key, under a different ID
+	report, err := target.ImportAPIKeys(ctx, exported, true)
+	require.NoError(t, err)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 0, report.Errors[0].Index)
+	assert.Contains(t, report.Errors[0].Message, "key hash belongs to key")
+	assert.Zero(t, report.Imported)
+	exported = exported[1:]
+
+	report, err = target.ImportAPIKeys(ctx, exported, true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 2, report.Imported)
+	_, err = target.ValidateAPIKey(ctx, apiKey)
+	assert.Error(t, err, "a dry run imports nothing")
+
+	report, err = target.ImportAPIKeys(ctx, exported, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	imported, err := target.ValidateAPIKey(ctx, apiKey)
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, imported.ID)
+	assert.Equal(t, []string{"10.0.0.0/8"}, imported.AllowedCIDRs)
+
+	// Importing again skips every key
+	report, err = target.ImportAPIKeys(ctx, exported, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Imported)
+	assert.Len(t, report.Skipped, 2)
+
+	// One bad key rejects the whole import
+	fresh := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+	signed := *exported[1]
+	signed.RequireSignature = true
+	report, err = fresh.ImportAPIKeys(ctx, []*database.ExportedAPIKey{exported[0], &signed}, false)
+	require.NoError(t, err)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 1, report.Errors[0].Index)
+	assert.Zero(t, report.Imported)
+}
+
+func TestAPIKeyService_ProvisionAPIKeys_SQLite(t *testing.T) {
+	ctx := context.Background()
+	service := NewAPIKeyService(repository.NewSQLAPIKeyRepository(newSQLiteDB(t)))
+
+	const parentID, subID = "6f1c2b3a-4d5e-4f60-8a71-9b2c3d4e5f60", "7a2d3c4b-5e6f-4071-8b82-0c3d4e5f6071"
+	parentSecret, subSecret := "provisioned-parent-secret-0001", "provisioned-sub-secret-000001"
+	declared := []ProvisionedKey{
+		// Declared before its parent, which is stored first regardless
+		{ID: subID, Secret: subSecret, Name: "Sub", ParentID: parentID},
+		{ID: parentID, Secret: parentSecret, Name: "Parent", RateLimitRequests: 10, RateLimitWindowSeconds: 60, AllowedCIDRs: []string{"10.0.0.1"}},
+	}
+
+	report, err := service.ProvisionAPIKeys(ctx, declared)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{parentID, subID}, report.Created)
+	parent, err := service.ValidateAPIKey(ctx, parentSecret)
+	require.NoError(t, err)
+	assert.Equal(t, parentID, parent.ID)
+	assert.Equal(t, []string{"10.0.0.1/32"}, parent.AllowedCIDRs)
+
+	// Provisioning is idempotent, and a key can be declared without its secret
+	declared[1].Secret = ""
+	report, err = service.ProvisionAPIKeys(ctx, declared)
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Empty(t, report.Updated)
+	assert.Len(t, report.Unchanged, 2)
+
+	declared[1].RateLimitRequests = 20
+	declared[0].Secret = "provisioned-sub-secret-000002"
+	report, err = service.ProvisionAPIKeys(ctx, declared)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{parentID, subID}, report.Updated)
+	parent, err = service.ValidateAPIKey(ctx, parentSecret)
+	require.NoError(t, err)
+	assert.Equal(t, 20, parent.RateLimitRequests)
+	_, err = service.ValidateAPIKey(ctx, subSecret)
+	assert.Error(t, err, "a replaced secret stops working")
+	_, err = service.ValidateAPIKey(ctx, declared[0].Secret)
+	assert.NoError(t, err)
+
+	// One bad key fails the whole run
+	_, err = service.ProvisionAPIKeys(ctx, []ProvisionedKey{
+		{ID: parentID, Name: "Renamed"},
+		{ID: "8b3e4d5c-6f70-4182-9c93-1d4e5f607182", Secret: parentSecret, Name: "Copy"},
+	})
+	assert.ErrorContains(t, err, "secret belongs to key "+parentID)
+	_, err = service.ProvisionAPIKeys(ctx, []ProvisionedKey{{ID: "8b3e4d5c-6f70-4182-9c93-1d4e5f607182", Name: "No secret"}})
+	assert.ErrorContains(t, err, "a secret is required")
+	_, err = service.ProvisionAPIKeys(ctx, []ProvisionedKey{{ID: "8b3e4d5c-6f70-4182-9c93-1d4e5f607182", Secret: "short", Name: "Short"}})
+	assert.ErrorContains(t, err, "at least")
+	record, err := service.GetAPIKey(ctx, parentID)
+	require.NoError(t, err)
+	assert.Equal(t, "Parent", record.Name)
+}
+
+func TestRateLimitService_CounterStore_SQLite(t *testing.T) {
+	store, err := database.NewCounterStore(newSQLiteDB(t), 0, time.Minute)
+	require.NoError(t, err)
+	service := NewRateLimitService(store, config.RateLimitConfig{
+		DefaultRequests: 2,
+		DefaultWindow:   time.Minute,
+		Penalty:         config.PenaltyConfig{Threshold: 2, Period: 10 * time.Minute, BaseCooldown: 5 * time.Minute, MaxCooldown: time.Hour},
+	})
+	ctx := context.Background()
+	apiKey := &database.APIKey{ID: "key-1"}
+
+	for i := 0; i < 2; i++ {
+		result, err := service.CheckRateLimit(ctx, apiKey)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	// The second rejection starts a cooldown
+	for i := 0; i < 2; i++ {
+		result, err := service.CheckRateLimit(ctx, apiKey)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+	}
+	status, err := service.GetRateLimitStatus(ctx, apiKey)
+	require.NoError(t, err)
+	assert.True(t, status.Penalized())
+	assert.Equal(t, int64(1), status.PenaltyLevel)
+
+	deleted, err := service.ClearKeyState(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), deleted)
+
+	result, err := service.CheckRateLimit(ctx, apiKey)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// UsageLogWriter buffers request records in memory and inserts them into
+// usage_logs in batches, keeping the write off the request path. The buffer
+// is bounded: while the database can't keep up and the buffer is full, new
+// records are dropped and counted in usage_logs_dropped_total rather than
+// slowing requests down.
+type UsageLogWriter struct {
+	db        database.DBInterface
+	entries   chan database.UsageLog
+	full      chan struct{}
+	batchSize int
+	interval  time.Duration
+}
+
+// NewUsageLogWriter buffers up to bufferSize records, writing them every
+// interval or as soon as batchSize records are waiting
+func NewUsageLogWriter(db database.DBInterface, bufferSize, batchSize int, interval time.Duration) *UsageLogWriter {
+	return &UsageLogWriter{
+		db:        db,
+		entries:   make(chan database.UsageLog, bufferSize),
+		full:      make(chan struct{}, 1),
+		batchSize: batchSize,
+		interval:  interval,
+	}
+}
+
+// Log queues entry for writing and reports whether it was accepted; it never
+// blocks on the database
+func (w *UsageLogWriter) Log(entry database.UsageLog) bool {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	select {
+	case w.entries <- entry:
+	default:
+		metrics.UsageLogsDropped.WithLabelValues("buffer_full").Inc()
+		return false
+	}
+	if len(w.entries) >= w.batchSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Run writes buffered records on every tick, or sooner once a batch is
+// full, until ctx is cancelled, then writes what is left
+func (w *UsageLogWriter) Run(ctx context.Context) {
+	if w.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, so the final flush gets its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			_, err := w.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				logging.FromContext(ctx).Error("Final usage log flush failed", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+		if _, err := w.Flush(ctx); err != nil {
+			logging.FromContext(ctx).Error("Usage log flush failed", zap.Error(err))
+		}
+	}
+}
+
+// Flush writes the records buffered so far in batches of up to batchSize and
+// returns how many were written, including records for purged keys that the
+// database skipped. A batch that fails is dropped along with the rest of the
+// flush, so a database outage can't pile up records in memory.
+func (w *UsageLogWriter) Flush(ctx context.Context) (int, error) {
+	written := 0
+	for pending := len(w.entries); pending > 0; {
+		size := pending
+		if size > w.batchSize {
+			size = w.batchSize
+		}
+		batch := make([]database.UsageLog, 0, size)
+		for len(batch) < size {
+			batch = append(batch, <-w.entries)
+		}
+		pending -= size
+
+		if err := w.write(ctx, batch); err != nil {
+			metrics.UsageLogsDropped.WithLabelValues("write_failed").Add(float64(len(batch) + pending))
+			w.discard(pending)
+			return written, fmt.Errorf("failed to write usage logs: %w", err)
+		}
+		written += len(batch)
+		metrics.UsageLogsWritten.Add(float64(len(batch)))
+	}
+	return written, nil
+}
+
+// discard drops the next n buffered records
+func (w *UsageLogWriter) discard(n int) {
+	for i := 0; i < n; i++ {
+		<-w.entries
+	}
+}
+
+func (w *UsageLogWriter) write(ctx context.Context, batch []database.UsageLog) error {
+	// Records for keys that have since been purged are dropped by the join
+	if database.DialectOf(w.db) != database.Postgres {
+		return database.RunInTx(ctx, w.db, func(tx *database.Tx) error {
+			query := `
+				INSERT INTO usage_logs (api_key_id, method, route, status_code, cost, decision, created_at)
+				SELECT id, $2, $3, $4, $5, $6, $7 FROM api_keys WHERE id = $1
+			`
+			for _, entry := range batch {
+				if _, err := tx.ExecContext(ctx, query, entry.APIKeyID, entry.Method, entry.Route, entry.StatusCode, entry.Cost, entry.Decision, entry.CreatedAt.UTC()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	ids := make([]string, len(batch))
+	methods := make([]string, len(batch))
+	routes := make([]string, len(batch))
+	statuses := make([]int64, len(batch))
+	costs := make([]int64, len(batch))
+	decisions := make([]string, len(batch))
+	createdAt := make([]string, len(batch))
+	for i, entry := range batch {
+		ids[i] = entry.APIKeyID
+		methods[i] = entry.Method
+		routes[i] = entry.Route
+		statuses[i] = int64(entry.StatusCode)
+		costs[i] = int64(entry.Cost)
+		decisions[i] = entry.Decision
+		createdAt[i] = entry.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	query := `
+		INSERT INTO usage_logs (api_key_id, method, route, status_code, cost, decision, created_at)
+		SELECT b.api_key_id, b.method, b.route, b.status_code, b.cost, b.decision, b.created_at
+		FROM unnest($1::uuid[], $2::text[], $3::text[], $4::int[], $5::int[], $6::text[], $7::timestamptz[])
+			AS b(api_key_id, method, route, status_code, cost, decision, created_at)
+		JOIN api_keys k ON k.id = b.api_key_id
+	`
+
+	_, err := w.db.ExecContext(ctx, query, pq.Array(ids), pq.Array(methods), pq.Array(routes),
+		pq.Array(statuses), pq.Array(costs), pq.Array(decisions), pq.Array(createdAt))
+	return err
+}
+
+// Ensure UsageLogWriter implements UsageLogger
+var _ UsageLogger = (*UsageLogWriter)(nil)
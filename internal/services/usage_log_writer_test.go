@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageLogWriter_Flush_WritesInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	writer := NewUsageLogWriter(db, 10, 2, time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.True(t, writer.Log(database.UsageLog{APIKeyID: "key-1", Method: "GET", Route: "/api/test", StatusCode: 200, Cost: 1}))
+	}
+
+	mock.ExpectExec(`INSERT INTO usage_logs`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO usage_logs`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	written, err := writer.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, written)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUsageLogWriter_Log_DropsWhenFull(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	writer := NewUsageLogWriter(db, 2, 10, time.Minute)
+	assert.True(t, writer.Log(database.UsageLog{APIKeyID: "key-1"}))
+	assert.True(t, writer.Log(database.UsageLog{APIKeyID: "key-1"}))
+	assert.False(t, writer.Log(database.UsageLog{APIKeyID: "key-1"}))
+
+	// A failed batch is dropped rather than kept in memory
+	mock.ExpectExec(`INSERT INTO usage_logs`).WillReturnError(assert.AnError)
+	_, err = writer.Flush(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write usage logs")
+
+	written, err := writer.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, written)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
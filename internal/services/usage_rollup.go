@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// usageRollup is one key's usage of one route over an hour or a day
+type usageRollup struct {
+	start    int64
+	apiKeyID string
+	route    string
+	requests int64
+	limited  int64
+	cost     int64
+}
+
+// UsageRollupJob periodically rolls usage_logs up into hourly totals per key
+// and route, kept in usage_rollups_hourly, and the hourly totals into daily
+// ones, kept in usage_rollups_daily. Analytics read the rollups instead of
+// scanning every logged request, and the rollups outlive the logs' retention
+// period.
+type UsageRollupJob struct {
+	db       database.DBInterface
+	interval time.Duration
+	lookback time.Duration
+}
+
+// NewUsageRollupJob re-aggregates the hours from lookback ago up to now on
+// every run, so requests logged late, e.g. after a failed flush, are counted
+// in their hour
+func NewUsageRollupJob(db database.DBInterface, interval, lookback time.Duration) *UsageRollupJob {
+	return &UsageRollupJob{
+		db:       db,
+		interval: interval,
+		lookback: lookback,
+	}
+}
+
+// Run rolls up on every tick until ctx is cancelled. A non-positive interval
+// disables the job.
+func (j *UsageRollupJob) Run(ctx context.Context) {
+	if j.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if _, err := j.Aggregate(ctx, now.Add(-j.lookback), now); err != nil {
+				logging.FromContext(ctx).Error("Usage rollup failed", zap.Error(err))
+				continue
+			}
+			metrics.UsageRollupLastSuccess.Set(float64(now.Unix()))
+		}
+	}
+}
+
+// Aggregate recomputes the rollups of every hour and day overlapping from to
+// to, replacing the stored ones, and returns how many hourly rollups were
+// written. Aggregating a period again gives the same rollups, so it is safe
+// to repeat, e.g. to backfill after enabling the job, as long as the
+// period's usage logs haven't been deleted by the retention job. Each day is
+// aggregated in its own transaction.
+func (j *UsageRollupJob) Aggregate(ctx context.Context, from, to time.Time) (int, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC()
+	if end := to.Truncate(time.Hour); end.Before(to) {
+		to = end.Add(time.Hour)
+	}
+
+	written := 0
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		hoursFrom, hoursTo := day, day.Add(24*time.Hour)
+		if hoursFrom.Before(from) {
+			hoursFrom = from
+		}
+		if hoursTo.After(to) {
+			hoursTo = to
+		}
+
+		var hourly int
+		err := database.RunInTx(ctx, j.db, func(tx *database.Tx) error {
+			dialect := tx.Dialect()
+			var err error
+			hourly, err = j.replace(ctx, tx, "usage_rollups_hourly", hoursFrom, hoursTo, `
+				SELECT `+dialect.Bucket("created_at", "$1")+` AS bucket, `+dialect.Text("api_key_id")+`, route,
+					COUNT(*),
+					SUM(CASE WHEN status_code = 429 THEN 1 ELSE 0 END),
+					SUM(cost)
+				FROM usage_logs
+				WHERE created_at >= $2 AND created_at < $3
+				GROUP BY bucket, api_key_id, route
+			`, int64(time.Hour/time.Second))
+			if err != nil {
+				return err
+			}
+
+			_, err = j.replace(ctx, tx, "usage_rollups_daily", day, day.Add(24*time.Hour), `
+				SELECT `+dialect.Bucket("bucket_start", "$1")+` AS bucket, `+dialect.Text("api_key_id")+`, route,
+					SUM(requests), SUM(limited), SUM(cost)
+				FROM usage_rollups_hourly
+				WHERE bucket_start >= $2 AND bucket_start < $3
+				GROUP BY bucket, api_key_id, route
+			`, int64(24*time.Hour/time.Second))
+			return err
+		})
+		if err != nil {
+			return written, fmt.Errorf("failed to roll up usage of %s: %w", day.Format(usageDayFormat), err)
+		}
+		written += hourly
+	}
+	return written, nil
+}
+
+// replace deletes the rollups in table from from to to and inserts the ones
+// returned by query, which selects the bucket start in Unix seconds, key ID,
+// route, requests, 429 responses and cost of the period bound to $2 and $3
+// in buckets of the seconds bound to $1
+func (j *UsageRollupJob) replace(ctx context.Context, tx *database.Tx, table string, from, to time.Time, query string, step int64) (int, error) {
+	rows, err := tx.QueryContext(ctx, query, step, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to aggregate %s: %w", table, err)
+	}
+	var rollups []usageRollup
+	for rows.Next() {
+		var rollup usageRollup
+		if err := rows.Scan(&rollup.start, &rollup.apiKeyID, &rollup.route, &rollup.requests, &rollup.limited, &rollup.cost); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		rollups = append(rollups, rollup)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to aggregate %s: %w", table, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE bucket_start >= $1 AND bucket_start < $2`, from, to); err != nil {
+		return 0, fmt.Errorf("failed to clear %s: %w", table, err)
+	}
+	for _, rollup := range rollups {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO `+table+` (api_key_id, route, bucket_start, requests, limited, cost)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, rollup.apiKeyID, rollup.route, time.Unix(rollup.start, 0).UTC(), rollup.requests, rollup.limited, rollup.cost); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", table, err)
+		}
+	}
+	return len(rollups), nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/redis"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// usagePendingKey is the Redis hash holding request counts that have not yet
+// been flushed to Postgres. Fields are "<api key id>:<YYYY-MM-DD>".
+const usagePendingKey = "usage:pending"
+
+const usageDayFormat = "2006-01-02"
+
+// UsageService counts requests per key and day in Redis and periodically
+// flushes the counts to Postgres, where lifetime and daily totals are kept.
+type UsageService struct {
+	redisClient redis.ClientInterface
+	db          database.DBInterface
+	interval    time.Duration
+}
+
+func NewUsageService(redisClient redis.ClientInterface, db database.DBInterface, interval time.Duration) *UsageService {
+	return &UsageService{
+		redisClient: redisClient,
+		db:          db,
+		interval:    interval,
+	}
+}
+
+// IncrementUsage counts one request for the key against the current UTC day
+func (s *UsageService) IncrementUsage(ctx context.Context, apiKeyID string) error {
+	field := apiKeyID + ":" + time.Now().UTC().Format(usageDayFormat)
+	return s.redisClient.IncrementHashField(ctx, usagePendingKey, field, 1)
+}
+
+// Run flushes on every tick until ctx is cancelled. A non-positive interval
+// disables the worker.
+func (s *UsageService) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Flush(ctx); err != nil {
+				logging.FromContext(ctx).Error("Usage flush failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush moves all pending counts from Redis into the database and returns how
+// many key/day counters were written. If the write fails the counts are
+// added back to Redis so the next flush retries them.
+func (s *UsageService) Flush(ctx context.Context) (int, error) {
+	pending, err := s.redisClient.DrainHash(ctx, usagePendingKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pending usage: %w", err)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(pending))
+	days := make([]string, 0, len(pending))
+	counts := make([]int64, 0, len(pending))
+	for field, count := range pending {
+		id, day, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		ids = append(ids, id)
+		days = append(days, day)
+		counts = append(counts, count)
+	}
+
+	if err := s.write(ctx, ids, days, counts); err != nil {
+		s.requeue(ctx, pending)
+		return 0, fmt.Errorf("failed to write usage: %w", err)
+	}
+
+	return len(ids), nil
+}
+
+func (s *UsageService) write(ctx context.Context, ids, days []string, counts []int64) error {
+	if database.DialectOf(s.db) != database.Postgres {
+		return s.writeEach(ctx, ids, days, counts)
+	}
+
+	// One statement so daily and lifetime totals never disagree. Counts for
+	// keys that have since been purged are dropped by the join.
+	query := `
+		WITH batch AS (
+			SELECT b.api_key_id, b.day, b.request_count
+			FROM unnest($1::uuid[], $2::date[], $3::bigint[]) AS b(api_key_id, day, request_count)
+			JOIN api_keys k ON k.id = b.api_key_id
+		), daily AS (
+			INSERT INTO api_key_usage_daily (api_key_id, day, request_count)
+			SELECT api_key_id, day, request_count FROM batch
+			ON CONFLICT (api_key_id, day)
+			DO UPDATE SET request_count = api_key_usage_daily.request_count + EXCLUDED.request_count
+		)
+		UPDATE api_keys k
+		SET lifetime_requests = k.lifetime_requests + t.total
+		FROM (SELECT api_key_id, SUM(request_count) AS total FROM batch GROUP BY api_key_id) t
+		WHERE k.id = t.api_key_id
+	`
+
+	_, err := s.db.ExecContext(ctx, query, pq.Array(ids), pq.Array(days), pq.Array(counts))
+	return err
+}
+
+// writeEach writes the counts one key and day at a time for databases
+// without arrays, in a transaction so a failed flush can be retried without
+// counting twice
+func (s *UsageService) writeEach(ctx context.Context, ids, days []string, counts []int64) error {
+	beginner, ok := s.db.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return fmt.Errorf("database does not support transactions")
+	}
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range ids {
+		result, err := tx.ExecContext(ctx, `UPDATE api_key_usage_daily SET request_count = request_count + ? WHERE api_key_id = ? AND day = ?`, counts[i], ids[i], days[i])
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			// Counts for keys that have since been purged insert nothing
+			insert := `INSERT INTO api_key_usage_daily (api_key_id, day, request_count) SELECT id, ?, ? FROM api_keys WHERE id = ?`
+			if _, err := tx.ExecContext(ctx, insert, days[i], counts[i], ids[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE api_keys SET lifetime_requests = lifetime_requests + ? WHERE id = ?`, counts[i], ids[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *UsageService) requeue(ctx context.Context, pending map[string]int64) {
+	for field, count := range pending {
+		if err := s.redisClient.IncrementHashField(ctx, usagePendingKey, field, count); err != nil {
+			logging.FromContext(ctx).Error("Failed to requeue usage", zap.String("counter", field), zap.Error(err))
+		}
+	}
+}
+
+// GetUsage returns the key's lifetime count and its daily counts for the
+// last days days, most recent first
+func (s *UsageService) GetUsage(ctx context.Context, apiKeyID string, days int) (*database.KeyUsage, error) {
+	usage := &database.KeyUsage{APIKeyID: apiKeyID, Daily: []database.DailyUsage{}}
+
+	err := s.db.QueryRowContext(ctx, `SELECT lifetime_requests FROM api_keys WHERE id = $1`, apiKeyID).Scan(&usage.LifetimeRequests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lifetime usage: %w", err)
+	}
+
+	query := `
+		SELECT day, request_count FROM api_key_usage_daily
+		WHERE api_key_id = $1 AND day > ` + database.DialectOf(s.db).DaysAgo("$2") + `
+		ORDER BY day DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, apiKeyID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		var daily database.DailyUsage
+		if err := rows.Scan(&day, &daily.Requests); err != nil {
+			return nil, fmt.Errorf("failed to scan daily usage: %w", err)
+		}
+		daily.Day = day.Format(usageDayFormat)
+		usage.Daily = append(usage.Daily, daily)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get daily usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// Ensure UsageService implements UsageServiceInterface
+var _ UsageServiceInterface = (*UsageService)(nil)
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageService_IncrementUsage(t *testing.T) {
+	mockRedisClient := &MockRedisClient{}
+	service := NewUsageService(mockRedisClient, nil, time.Minute)
+	ctx := context.Background()
+
+	field := "test-id-123:" + time.Now().UTC().Format("2006-01-02")
+	mockRedisClient.On("IncrementHashField", ctx, "usage:pending", field, int64(1)).Return(nil)
+
+	err := service.IncrementUsage(ctx, "test-id-123")
+
+	assert.NoError(t, err)
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestUsageService_Flush_WritesPendingCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mockRedisClient := &MockRedisClient{}
+	service := NewUsageService(mockRedisClient, db, time.Minute)
+	ctx := context.Background()
+
+	mockRedisClient.On("DrainHash", ctx, "usage:pending").Return(map[string]int64{
+		"key-1:2025-06-01": 40,
+		"key-1:2025-06-02": 2,
+		"key-2:2025-06-02": 7,
+	}, nil)
+	mock.ExpectExec(`INSERT INTO api_key_usage_daily`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	count, err := service.Flush(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestUsageService_Flush_NothingPending(t *testing.T) {
+	mockRedisClient := &MockRedisClient{}
+	service := NewUsageService(mockRedisClient, nil, time.Minute)
+	ctx := context.Background()
+
+	mockRedisClient.On("DrainHash", ctx, "usage:pending").Return(map[string]int64{}, nil)
+
+	count, err := service.Flush(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestUsageService_Flush_RequeuesOnDatabaseError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mockRedisClient := &MockRedisClient{}
+	service := NewUsageService(mockRedisClient, db, time.Minute)
+	ctx := context.Background()
+
+	mockRedisClient.On("DrainHash", ctx, "usage:pending").Return(map[string]int64{"key-1:2025-06-01": 40}, nil)
+	mockRedisClient.On("IncrementHashField", ctx, "usage:pending", "key-1:2025-06-01", int64(40)).Return(nil)
+	mock.ExpectExec(`INSERT INTO api_key_usage_daily`).WillReturnError(assert.AnError)
+
+	_, err = service.Flush(ctx)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write usage")
+	mockRedisClient.AssertExpectations(t)
+}
+
+func TestUsageService_GetUsage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewUsageService(nil, db, time.Minute)
+
+	mock.ExpectQuery(`SELECT lifetime_requests FROM api_keys WHERE id = \$1`).
+		WithArgs("test-id-123").
+		WillReturnRows(sqlmock.NewRows([]string{"lifetime_requests"}).AddRow(int64(1234)))
+	mock.ExpectQuery(`SELECT day, request_count FROM api_key_usage_daily`).
+		WithArgs("test-id-123", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "request_count"}).
+			AddRow(time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), int64(9)).
+			AddRow(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), int64(40)))
+
+	usage, err := service.GetUsage(context.Background(), "test-id-123", 7)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1234), usage.LifetimeRequests)
+	assert.Len(t, usage.Daily, 2)
+	assert.Equal(t, "2025-06-02", usage.Daily[0].Day)
+	assert.Equal(t, int64(40), usage.Daily[1].Requests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
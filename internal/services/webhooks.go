@@ -0,0 +1,366 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/logging"
+	"grpc-firstls/internal/metrics"
+	"grpc-firstls/internal/redis"
+
+	"go.uber.org/zap"
+)
+
+// Headers of webhook deliveries
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	webhookWorkers       = 4
+	webhookMaxRetryDelay = time.Minute
+	// Deliveries listed per key, newest first
+	webhookDeliveryListLimit = 1000
+	// Receiver errors kept in the delivery log
+	webhookMaxErrorLength = 1024
+)
+
+var (
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	errWebhookBufferFull = errors.New("webhook buffer is full")
+)
+
+// SignWebhook computes the hex HMAC-SHA256 signature of a webhook delivery:
+// the Unix timestamp and the body, separated by a newline
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookService sends signed alerts to a key's webhook when the key exceeds
+// its rate limit or quota. Alerts are throttled per key and kind in the
+// counter store, so a key that stays over its limit raises one alert per
+// throttle period, and are sent in the background with retries. Every
+// delivery and its attempts are recorded in webhook_deliveries.
+type WebhookService struct {
+	db       database.DBInterface
+	dialect  database.Dialect
+	counters redis.ClientInterface
+	client   *http.Client
+
+	maxAttempts int
+	throttle    time.Duration
+	retryDelay  time.Duration
+	alerts      chan events.Event
+}
+
+func NewWebhookService(db database.DBInterface, counters redis.ClientInterface, cfg config.WebhookConfig) *WebhookService {
+	return &WebhookService{
+		db:          db,
+		dialect:     database.DialectOf(db),
+		counters:    counters,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		maxAttempts: cfg.MaxAttempts,
+		throttle:    cfg.Throttle,
+		retryDelay:  time.Second,
+		alerts:      make(chan events.Event, cfg.BufferSize),
+	}
+}
+
+// SetWebhook sends the alerts of a key to url, signed with a newly generated
+// secret that is only returned here
+func (s *WebhookService) SetWebhook(ctx context.Context, apiKeyID, url string) (*database.Webhook, error) {
+	secret, err := GenerateSigningSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.RunInTx(ctx, s.db, func(tx *database.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE webhooks SET url = $1, secret = $2, updated_at = `+s.dialect.Now()+` WHERE api_key_id = $3`, url, secret, apiKeyID)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhooks (api_key_id, url, secret) VALUES ($1, $2, $3)`, apiKeyID, url, secret)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set webhook: %w", err)
+	}
+	return s.GetWebhook(ctx, apiKeyID)
+}
+
+// GetWebhook returns a key's webhook, with its secret
+func (s *WebhookService) GetWebhook(ctx context.Context, apiKeyID string) (*database.Webhook, error) {
+	var webhook database.Webhook
+	err := s.db.QueryRowContext(ctx, `SELECT api_key_id, url, secret, created_at, updated_at FROM webhooks WHERE api_key_id = $1`, apiKeyID).
+		Scan(&webhook.APIKeyID, &webhook.URL, &webhook.Secret, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook stops sending a key's alerts. Its delivery log is kept until
+// the retention job removes it.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, apiKeyID string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE api_key_id = $1`, apiKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListDeliveries returns a key's most recent deliveries, newest first
+func (s *WebhookService) ListDeliveries(ctx context.Context, apiKeyID string) ([]database.WebhookDelivery, error) {
+	query := `
+		SELECT id, api_key_id, event_type, url, payload, status, attempts, response_status, error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE api_key_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, apiKeyID, webhookDeliveryListLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []database.WebhookDelivery{}
+	for rows.Next() {
+		var delivery database.WebhookDelivery
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&delivery.ID, &delivery.APIKeyID, &delivery.EventType, &delivery.URL, &delivery.Payload,
+			&delivery.Status, &delivery.Attempts, &delivery.ResponseStatus, &delivery.Error, &delivery.CreatedAt, &deliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// NotifyLimitExceeded raises an alert of eventType, events.RateLimitExceeded
+// or events.QuotaExceeded, for a key whose request was refused with result,
+// or events.LimitThresholdReached for one that reached an alert threshold.
+// Alerts of sub-keys are raised for the parent key whose limits they share.
+// It never waits for the delivery, and returns an error only when the alert
+// was lost.
+func (s *WebhookService) NotifyLimitExceeded(ctx context.Context, apiKey *database.APIKey, eventType string, result *RateLimitResult) error {
+	raised, _, err := s.counters.IncrementRateLimit(ctx, "webhook_throttle:"+LimitAlertThrottleKey(apiKey, eventType, result), s.throttle)
+	if err != nil {
+		return fmt.Errorf("failed to throttle webhook alert: %w", err)
+	}
+	if raised > 1 {
+		return nil
+	}
+
+	event := LimitExceededEvent(apiKey, eventType, result)
+	if event.ID, err = database.NewUUID(); err != nil {
+		return err
+	}
+
+	select {
+	case s.alerts <- event:
+		return nil
+	default:
+		metrics.WebhookAlerts.WithLabelValues("dropped").Inc()
+		return errWebhookBufferFull
+	}
+}
+
+// Run sends queued alerts until ctx is cancelled. Deliveries still being
+// retried then are recorded as failed.
+func (s *WebhookService) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-s.alerts:
+					s.deliver(ctx, event)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver sends event to its key's webhook, if the key has one, retrying
+// with exponential backoff until it is accepted or every attempt is used
+func (s *WebhookService) deliver(ctx context.Context, event events.Event) {
+	logger := logging.FromContext(ctx).With(zap.String("api_key_id", event.APIKeyID), zap.String("event_id", event.ID))
+
+	webhook, err := s.GetWebhook(ctx, event.APIKeyID)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up webhook", zap.Error(err))
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode webhook event", zap.Error(err))
+		return
+	}
+	delivery := database.WebhookDelivery{
+		ID:        event.ID,
+		APIKeyID:  event.APIKeyID,
+		EventType: event.Type,
+		URL:       webhook.URL,
+		Payload:   string(payload),
+		Status:    DeliveryPending,
+	}
+	insert := `
+		INSERT INTO webhook_deliveries (id, api_key_id, event_type, url, payload, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := s.db.ExecContext(ctx, insert, delivery.ID, delivery.APIKeyID, delivery.EventType, delivery.URL, delivery.Payload, delivery.Status); err != nil {
+		logger.Error("Failed to record webhook delivery", zap.Error(err))
+		return
+	}
+
+	delay := s.retryDelay
+	for {
+		delivery.Attempts++
+		delivery.ResponseStatus, err = s.send(ctx, webhook, event, payload)
+		switch {
+		case err == nil:
+			delivery.Status, delivery.Error = DeliveryDelivered, ""
+		case delivery.Attempts >= s.maxAttempts:
+			delivery.Status, delivery.Error = DeliveryFailed, err.Error()
+		default:
+			delivery.Error = err.Error()
+		}
+		if delivery.Status == DeliveryPending {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				delivery.Status = DeliveryFailed
+			case <-timer.C:
+			}
+			if delay *= 2; delay > webhookMaxRetryDelay {
+				delay = webhookMaxRetryDelay
+			}
+		}
+
+		if err := s.recordAttempt(&delivery); err != nil {
+			logger.Error("Failed to record webhook delivery", zap.Error(err))
+		}
+		if delivery.Status != DeliveryPending {
+			break
+		}
+	}
+
+	if delivery.Status == DeliveryDelivered {
+		metrics.WebhookAlerts.WithLabelValues("delivered").Inc()
+		return
+	}
+	metrics.WebhookAlerts.WithLabelValues("failed").Inc()
+	logger.Warn("Webhook delivery failed",
+		zap.String("url", webhook.URL),
+		zap.Int("attempts", delivery.Attempts),
+		zap.String("error", delivery.Error),
+	)
+}
+
+// send makes one delivery attempt, returning the receiver's status code.
+// Anything but a 2xx response is an error.
+func (s *WebhookService) send(ctx context.Context, webhook *database.Webhook, event events.Event, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rate-limiter-webhooks")
+	req.Header.Set(WebhookIDHeader, event.ID)
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt updates the delivery log after an attempt. It gets its own
+// context so the outcome is recorded even while shutting down.
+func (s *WebhookService) recordAttempt(delivery *database.WebhookDelivery) error {
+	if len(delivery.Error) > webhookMaxErrorLength {
+		delivery.Error = delivery.Error[:webhookMaxErrorLength]
+	}
+	if delivery.Status == DeliveryDelivered {
+		now := time.Now().UTC()
+		delivery.DeliveredAt = &now
+	}
+
+	updateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, response_status = $3, error = $4, delivered_at = $5
+		WHERE id = $6
+	`
+	_, err := s.db.ExecContext(updateCtx, query, delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.Error, delivery.DeliveredAt, delivery.ID)
+	return err
+}
+
+// Ensure WebhookService implements WebhookServiceInterface
+var _ WebhookServiceInterface = (*WebhookService)(nil)
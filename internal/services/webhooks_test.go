@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/events"
+	"grpc-firstls/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhookService(t *testing.T) (*WebhookService, *database.APIKey) {
+	db := newSQLiteDB(t)
+	store, err := database.NewCounterStore(db, 0, time.Minute)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	keys := NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	key, err := keys.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Alerts", RateLimitRequests: 10, RateLimitWindowSeconds: 60})
+	require.NoError(t, err)
+	apiKey, err := keys.GetAPIKey(ctx, key)
+	require.NoError(t, err)
+
+	service := NewWebhookService(db, store, config.WebhookConfig{
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Throttle:    time.Hour,
+		BufferSize:  10,
+	})
+	service.retryDelay = time.Millisecond
+	return service, apiKey
+}
+
+// waitForDelivery runs the service until the key's first delivery is no
+// longer pending
+func waitForDelivery(t *testing.T, service *WebhookService, apiKeyID string) database.WebhookDelivery {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var delivery database.WebhookDelivery
+	require.Eventually(t, func() bool {
+		deliveries, err := service.ListDeliveries(context.Background(), apiKeyID)
+		require.NoError(t, err)
+		if len(deliveries) == 0 || deliveries[0].Status == DeliveryPending {
+			return false
+		}
+		delivery = deliveries[0]
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return delivery
+}
+
+func TestWebhookService_DeliversSignedAlert(t *testing.T) {
+	service, apiKey := newTestWebhookService(t)
+	ctx := context.Background()
+
+	var received atomic.Value
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Store(r.Clone(context.Background()))
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		webhook, err := service.GetWebhook(context.Background(), apiKey.ID)
+		if err != nil || r.Header.Get(WebhookSignatureHeader) != SignWebhook(webhook.Secret, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event events.Event
+		if json.Unmarshal(body, &event) != nil || event.Type != events.RateLimitExceeded {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	webhook, err := service.SetWebhook(ctx, apiKey.ID, receiver.URL)
+	require.NoError(t, err)
+	assert.NotEmpty(t, webhook.Secret)
+
+	result := &RateLimitResult{Limit: 10, Window: time.Minute, ResetTime: time.Now().Add(time.Minute)}
+	require.NoError(t, service.NotifyLimitExceeded(ctx, apiKey, events.RateLimitExceeded, result))
+	// Throttled until the next period
+	require.NoError(t, service.NotifyLimitExceeded(ctx, apiKey, events.RateLimitExceeded, result))
+	assert.Len(t, service.alerts, 1)
+
+	delivery := waitForDelivery(t, service, apiKey.ID)
+	assert.Equal(t, DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusNoContent, delivery.ResponseStatus)
+	assert.Equal(t, events.RateLimitExceeded, delivery.EventType)
+	assert.NotNil(t, delivery.DeliveredAt)
+
+	req := received.Load().(*http.Request)
+	assert.Equal(t, delivery.ID, req.Header.Get(WebhookIDHeader))
+	assert.Equal(t, events.RateLimitExceeded, req.Header.Get(WebhookEventHeader))
+}
+
+func TestWebhookService_RetriesThenFails(t *testing.T) {
+	service, apiKey := newTestWebhookService(t)
+	ctx := context.Background()
+
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	_, err := service.SetWebhook(ctx, apiKey.ID, receiver.URL)
+	require.NoError(t, err)
+	result := &RateLimitResult{Limit: 100, QuotaExceeded: true, ResetTime: time.Now().Add(time.Hour)}
+	require.NoError(t, service.NotifyLimitExceeded(ctx, apiKey, events.QuotaExceeded, result))
+
+	delivery := waitForDelivery(t, service, apiKey.ID)
+	assert.Equal(t, DeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, delivery.ResponseStatus)
+	assert.Contains(t, delivery.Error, "500")
+	assert.Nil(t, delivery.DeliveredAt)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestWebhookService_WithoutWebhook(t *testing.T) {
+	service, apiKey := newTestWebhookService(t)
+	ctx := context.Background()
+
+	_, err := service.GetWebhook(ctx, apiKey.ID)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+	assert.ErrorIs(t, service.DeleteWebhook(ctx, apiKey.ID), ErrWebhookNotFound)
+
+	// Alerts of keys without a webhook are dropped without a delivery
+	require.NoError(t, service.NotifyLimitExceeded(ctx, apiKey, events.RateLimitExceeded, &RateLimitResult{}))
+	service.deliver(ctx, <-service.alerts)
+	deliveries, err := service.ListDeliveries(ctx, apiKey.ID)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestWebhookService_SetWebhookReplacesSecret(t *testing.T) {
+	service, apiKey := newTestWebhookService(t)
+	ctx := context.Background()
+
+	first, err := service.SetWebhook(ctx, apiKey.ID, "https://alerts.example.com/a")
+	require.NoError(t, err)
+	second, err := service.SetWebhook(ctx, apiKey.ID, "https://alerts.example.com/b")
+	require.NoError(t, err)
+	assert.Equal(t, "https://alerts.example.com/b", second.URL)
+	assert.NotEqual(t, first.Secret, second.Secret)
+
+	require.NoError(t, service.DeleteWebhook(ctx, apiKey.ID))
+	_, err = service.GetWebhook(ctx, apiKey.ID)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+}
+
+func TestSignWebhook(t *testing.T) {
+	signature := SignWebhook("secret", 1700000000, []byte(`{"type":"quota.exceeded"}`))
+	assert.Len(t, signature, 64)
+	assert.Equal(t, signature, SignWebhook("secret", 1700000000, []byte(`{"type":"quota.exceeded"}`)))
+	assert.NotEqual(t, signature, SignWebhook("secret", 1700000001, []byte(`{"type":"quota.exceeded"}`)))
+}
@@ -0,0 +1,267 @@
+// Package client is the Go client of the rate limiter's REST API. It manages
+// API keys through the admin API and checks keys against their limits
+// through the rate limited API, retrying requests that were refused for
+// being over a limit or while the service was unavailable.
+//
+// Services that talk gRPC can use the generated clients in api/ratelimit/v1
+// instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 200 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Client calls one rate limiter instance. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	adminToken string
+	userAgent  string
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAdminToken authenticates admin API calls with token, one of the
+// service's ADMIN_TOKENS or an access token from its OIDC issuer
+func WithAdminToken(token string) Option {
+	return func(c *Client) {
+		c.adminToken = token
+	}
+}
+
+// WithHTTPClient sends requests with httpClient instead of a client with a
+// 30 second timeout
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithRetries sets how often a request is retried after a 429, 502, 503 or
+// 504, 3 by default. 0 disables retries.
+func WithRetries(maxRetries int) Option {
+	return func(c *Client) {
+		if maxRetries >= 0 {
+			c.maxRetries = maxRetries
+		}
+	}
+}
+
+// WithBackoff sets the delays between retries of responses that don't say
+// when to retry: they start at min and double up to max. Delays the service
+// asks for with Retry-After are waited out as long as they don't exceed max;
+// longer ones are returned as errors right away.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		if min > 0 && max >= min {
+			c.minBackoff, c.maxBackoff = min, max
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New returns a client of the rate limiter at baseURL, e.g.
+// "https://rate-limiter.internal:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("client: invalid base URL %q", baseURL)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+
+	c := &Client{
+		baseURL:    parsed,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		userAgent:  "rate-limiter-go-client",
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// request is one API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+
+	// Credentials: the admin token, or a key for the rate limited API
+	admin  bool
+	apiKey string
+
+	// Whether a 429 is an answer rather than a reason to retry
+	noRetryLimited bool
+}
+
+// do sends req, retrying as configured, and decodes a successful response
+// into out when it isn't nil. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return fmt.Errorf("client: encoding request: %w", err)
+		}
+	}
+
+	// req.path is escaped already
+	target := *c.baseURL
+	path, err := url.PathUnescape(req.path)
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+	target.RawPath = target.EscapedPath() + req.path
+	target.Path += path
+	target.RawQuery = req.query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		resp, data, err := c.send(ctx, req, target.String(), body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 300 {
+			if out != nil && len(data) > 0 {
+				if err := json.Unmarshal(data, out); err != nil {
+					return fmt.Errorf("client: decoding %s %s response: %w", req.method, req.path, err)
+				}
+			}
+			return nil
+		}
+
+		apiErr := newError(resp, data)
+		if attempt >= c.maxRetries || !retryable(req.method, resp.StatusCode) ||
+			(req.noRetryLimited && resp.StatusCode == http.StatusTooManyRequests) {
+			return apiErr
+		}
+		delay := c.backoff(attempt)
+		if apiErr.RetryAfter > 0 {
+			if apiErr.RetryAfter > c.maxBackoff {
+				return apiErr
+			}
+			delay = apiErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, target string, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.admin && c.adminToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+	if req.apiKey != "" {
+		httpReq.Header.Set("X-API-Key", req.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client: %s %s: %w", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client: reading %s %s response: %w", req.method, req.path, err)
+	}
+	return resp, data, nil
+}
+
+// retryable reports whether a request that got status may be sent again. A
+// 429 means the request wasn't served, so any request may be retried; after
+// a gateway error only requests that are safe to repeat are.
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+	}
+	return false
+}
+
+// backoff is the jittered delay before retry attempt+1
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.minBackoff << uint(attempt)
+	if delay <= 0 || delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// errMissingArgument is returned before any request is sent
+func errMissingArgument(name string) error {
+	return errors.New("client: " + name + " is required")
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := New(server.URL+"/", append([]Option{WithBackoff(time.Millisecond, 50*time.Millisecond)}, opts...)...)
+	require.NoError(t, err)
+	return c
+}
+
+func TestClient_ManagesKeys(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/admin/api-keys":
+			var params CreateAPIKeyParams
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			assert.Equal(t, "Orders", params.Name)
+			assert.Equal(t, 10, params.RateLimitRequests)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"api_key":"ak_secret","key_prefix":"ak_secr","name":"Orders"}`))
+		case "GET /v1/admin/api-keys":
+			assert.Equal(t, "acme", r.URL.Query().Get("owner"))
+			assert.Equal(t, "2", r.URL.Query().Get("limit"))
+			assert.Equal(t, "true", r.URL.Query().Get("include_deleted"))
+			w.Write([]byte(`{"api_keys":[{"id":"key-1","name":"Orders","is_active":true}],"next_cursor":"abc"}`))
+		case "GET /v1/admin/api-keys/key 1":
+			w.Write([]byte(`{"api_key":{"id":"key 1","rate_limit_requests":10}}`))
+		case "POST /v1/admin/api-keys/key-1/rotate":
+			var body map[string]int
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, 60, body["grace_period_seconds"])
+			w.Write([]byte(`{"id":"key-1","api_key":"ak_new","key_prefix":"ak_new","previous_key_expires_at":"2030-01-01T00:00:00Z"}`))
+		case "DELETE /v1/admin/api-keys/key-1":
+			w.Write([]byte(`{"message":"API key deleted successfully"}`))
+		case "POST /v1/admin/api-keys/key-1/restore":
+			w.Write([]byte(`{"api_key":{"id":"key-1","is_active":true}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}, WithAdminToken("admin-token"))
+	ctx := context.Background()
+
+	created, err := c.CreateAPIKey(ctx, CreateAPIKeyParams{Name: "Orders", RateLimitRequests: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "ak_secret", created.APIKey)
+
+	page, err := c.ListAPIKeys(ctx, ListAPIKeysOptions{Owner: "acme", Limit: 2, IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, page.APIKeys, 1)
+	assert.True(t, page.APIKeys[0].IsActive)
+	assert.Equal(t, "abc", page.NextCursor)
+
+	apiKey, err := c.GetAPIKey(ctx, "key 1")
+	require.NoError(t, err)
+	assert.Equal(t, 10, apiKey.RateLimitRequests)
+
+	rotated, err := c.RotateAPIKey(ctx, "key-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "ak_new", rotated.APIKey)
+	assert.Equal(t, 2030, rotated.PreviousKeyExpiresAt.Year())
+
+	require.NoError(t, c.DeactivateAPIKey(ctx, "key-1"))
+	restored, err := c.RestoreAPIKey(ctx, "key-1")
+	require.NoError(t, err)
+	assert.True(t, restored.IsActive)
+	assert.Nil(t, restored.DeletedAt)
+
+	_, err = c.GetAPIKey(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"API key not found","message":"api key not found","request_id":"req-1"}`))
+	})
+
+	_, err := c.GetAPIKey(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrUnauthorized)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "API key not found", apiErr.Title)
+	assert.Equal(t, "req-1", apiErr.RequestID)
+}
+
+func TestClient_RetriesHonoringRetryAfter(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"Rate limit exceeded","retry_after":0}`))
+		default:
+			w.Write([]byte(`{"api_keys":[]}`))
+		}
+	})
+	_, err := c.ListAPIKeys(context.Background(), ListAPIKeysOptions{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+
+	// Waits longer than the maximum backoff aren't waited out
+	atomic.StoreInt32(&calls, 0)
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	_, err = c.ListAPIKeys(context.Background(), ListAPIKeysOptions{})
+	assert.ErrorIs(t, err, ErrRateLimited)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 2*time.Minute, apiErr.RetryAfter)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// Creating a key isn't repeated after a gateway error, as it may have
+	// been created
+	atomic.StoreInt32(&calls, 0)
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}, WithRetries(5))
+	_, err = c.CreateAPIKey(context.Background(), CreateAPIKeyParams{Name: "Orders"})
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestClient_RetriesStopWithContext(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, WithBackoff(time.Second, time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := c.ListAPIKeys(ctx, ListAPIKeysOptions{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClient_CheckRateLimit(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/api/rate-limit", r.URL.Path)
+		switch r.Header.Get("X-API-Key") {
+		case "ak_valid":
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Write([]byte(`{"rate_limit":{"limit":2,"remaining":1,"reset_time":"2030-01-01T00:00:00Z","allowed":true,"penalty":{"active":false}}}`))
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", "2")
+			w.Header().Set("X-RateLimit-Reset", "2030-01-01T00:00:00Z")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"Rate limit exceeded","retry_after":30}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Invalid API key"}`))
+		}
+	})
+	ctx := context.Background()
+
+	limit, err := c.CheckRateLimit(ctx, "ak_valid")
+	require.NoError(t, err)
+	assert.True(t, limit.Allowed)
+	assert.Equal(t, 1, limit.Remaining)
+
+	limit, err = c.CheckRateLimit(ctx, "ak_valid")
+	require.NoError(t, err)
+	assert.False(t, limit.Allowed)
+	assert.Equal(t, 2, limit.Limit)
+	assert.Equal(t, "Rate limit exceeded", limit.Reason)
+	assert.Equal(t, 30*time.Second, limit.RetryAfter)
+	assert.Equal(t, 2030, limit.ResetTime.Year())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls), "refused checks aren't retried")
+
+	_, err = c.CheckRateLimit(ctx, "ak_invalid")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestClient_RefundRateLimit(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/api/rate-limit/refund", r.URL.Path)
+		if r.Header.Get("X-API-Key") != "ak_refundable" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"Refunds not allowed"}`))
+			return
+		}
+		var body struct {
+			Units int `json:"units"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, 3, body.Units)
+		w.Write([]byte(`{"refunded":2,"rate_limit":{"limit":10,"remaining":8,"reset_time":"2030-01-01T00:00:00Z","allowed":true}}`))
+	})
+	ctx := context.Background()
+
+	refund, err := c.RefundRateLimit(ctx, "ak_refundable", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, refund.Refunded)
+	assert.Equal(t, 8, refund.RateLimit.Remaining)
+
+	_, err = c.RefundRateLimit(ctx, "ak_other", 3)
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestClient_Reservations(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/api/rate-limit/reservations":
+			var body struct {
+				Units     int `json:"units"`
+				ExpiresIn int `json:"expires_in"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, 60, body.ExpiresIn)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"reservation":{"id":"rsv_1","units":%d,"expires_at":"2030-01-01T00:00:00Z"}}`, body.Units)
+		case "POST /v1/api/rate-limit/reservations/rsv_1/commit":
+			w.Write([]byte(`{"committed":5}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Reservation not found"}`))
+		}
+	})
+	ctx := context.Background()
+
+	reservation, err := c.ReserveRateLimit(ctx, "ak_1", 5, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "rsv_1", reservation.ID)
+	assert.Equal(t, 5, reservation.Units)
+
+	assert.NoError(t, c.CommitReservation(ctx, "ak_1", "rsv_1"))
+	assert.ErrorIs(t, c.CancelReservation(ctx, "ak_1", "rsv_1"), ErrNotFound)
+}
+
+func TestNew_InvalidBaseURL(t *testing.T) {
+	for _, baseURL := range []string{"", "rate-limiter:8080", "ftp://rate-limiter"} {
+		_, err := New(baseURL)
+		assert.Error(t, err, baseURL)
+	}
+}
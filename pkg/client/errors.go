@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Sentinel errors matched by *Error with errors.Is
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrInvalid      = errors.New("invalid request")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnavailable  = errors.New("service unavailable")
+)
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	// The response's short error, e.g. "API key not found"
+	Title   string
+	Message string
+	// The service's ID of the request, for finding it in its logs
+	RequestID string
+	// How long to wait before retrying, from a 429 or 503
+	RetryAfter time.Duration
+
+	// The X-RateLimit-* headers of a refused rate limit check
+	limit     int
+	resetTime time.Time
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("client: %d", e.StatusCode)
+	if e.Title != "" {
+		msg += " " + e.Title
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is matches the sentinel error of the response's status
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// errorBody is the JSON body of error responses
+type errorBody struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+func newError(resp *http.Response, data []byte) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	apiErr.limit, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	apiErr.resetTime, _ = time.Parse(time.RFC3339, resp.Header.Get("X-RateLimit-Reset"))
+
+	var body errorBody
+	if json.Unmarshal(data, &body) != nil {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+		return apiErr
+	}
+	apiErr.Title, apiErr.Message = body.Error, body.Message
+	if body.RequestID != "" {
+		apiErr.RequestID = body.RequestID
+	}
+	if apiErr.RetryAfter == 0 && body.RetryAfter > 0 {
+		apiErr.RetryAfter = time.Duration(body.RetryAfter) * time.Second
+	}
+	return apiErr
+}
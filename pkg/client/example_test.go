@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"grpc-firstls/pkg/client"
+)
+
+func Example() {
+	c, err := client.New("http://localhost:8080", client.WithAdminToken("admin-token"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	created, err := c.CreateAPIKey(ctx, client.CreateAPIKeyParams{
+		Name:                   "Orders service",
+		RateLimitRequests:      1000,
+		RateLimitWindowSeconds: 3600,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("store this key now, it isn't shown again:", created.APIKey)
+}
+
+func ExampleClient_CheckRateLimit() {
+	c, err := client.New("http://localhost:8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limit, err := c.CheckRateLimit(context.Background(), "ak_...")
+	if errors.Is(err, client.ErrUnauthorized) {
+		fmt.Println("invalid key")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !limit.Allowed {
+		fmt.Printf("%s, retry in %s\n", limit.Reason, limit.RetryAfter)
+		return
+	}
+	fmt.Println(limit.Remaining, "requests left until", limit.ResetTime)
+}
+
+func ExampleClient_RotateAPIKey() {
+	c, err := client.New("http://localhost:8080", client.WithAdminToken("admin-token"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The previous key keeps working for an hour while clients switch over
+	rotated, err := c.RotateAPIKey(context.Background(), "key-id", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(rotated.APIKey, "replaces the previous key at", rotated.PreviousKeyExpiresAt)
+}
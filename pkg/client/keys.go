@@ -0,0 +1,368 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const apiVersion = "/v1"
+
+// APIKey is a key as returned by the admin API, without its secret
+type APIKey struct {
+	ID                     string `json:"id"`
+	KeyPrefix              string `json:"key_prefix"`
+	Name                   string `json:"name"`
+	RateLimitRequests      int    `json:"rate_limit_requests"`
+	RateLimitWindowSeconds int    `json:"rate_limit_window_seconds"`
+	IsActive               bool   `json:"is_active"`
+
+	PlanID             string `json:"plan_id,omitempty"`
+	QuotaRequests      int    `json:"quota_requests"`
+	QuotaPeriodSeconds int    `json:"quota_period_seconds"`
+	BurstRequests      int    `json:"burst_requests"`
+
+	OwnerName  string `json:"owner_name,omitempty"`
+	OwnerEmail string `json:"owner_email,omitempty"`
+	ParentID   string `json:"parent_id,omitempty"`
+
+	ProjectID      string `json:"project_id,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+
+	AllowedCIDRs     []string `json:"allowed_cidrs,omitempty"`
+	AllowedOrigins   []string `json:"allowed_origins,omitempty"`
+	RequireSignature bool     `json:"require_signature"`
+
+	EndUserLimitRequests      int `json:"end_user_limit_requests"`
+	EndUserLimitWindowSeconds int `json:"end_user_limit_window_seconds"`
+
+	// Percentages of its limits at which the key is warned
+	AlertThresholds []int64 `json:"alert_thresholds,omitempty"`
+
+	// Units of its limit the key may refund per window; 0 when it may not
+	RefundLimit int `json:"refund_limit,omitempty"`
+
+	// Custom response the key gets when refused for exceeding its rate
+	// limit or quota; nil for the plan's or the standard one
+	LimitResponse *LimitResponse `json:"limit_response,omitempty"`
+
+	OverrideRequests  int        `json:"override_requests,omitempty"`
+	OverrideExpiresAt *time.Time `json:"override_expires_at,omitempty"`
+
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// When the key was deleted; nil unless it is
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// LimitResponse is a custom 429 response. The body and header values may use
+// the {limit}, {remaining}, {reset} and {retry_after} variables.
+type LimitResponse struct {
+	Body    string            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// CreateAPIKeyParams are the settings of a new key. Limits left at 0 get
+// the service's defaults of 100 requests per hour, unless the key is on a
+// plan or is a sub-key.
+type CreateAPIKeyParams struct {
+	Name                   string `json:"name"`
+	RateLimitRequests      int    `json:"rate_limit_requests,omitempty"`
+	RateLimitWindowSeconds int    `json:"rate_limit_window_seconds,omitempty"`
+	PlanID                 string `json:"plan_id,omitempty"`
+
+	EndUserLimitRequests      int `json:"end_user_limit_requests,omitempty"`
+	EndUserLimitWindowSeconds int `json:"end_user_limit_window_seconds,omitempty"`
+
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	AllowedCIDRs     []string   `json:"allowed_cidrs,omitempty"`
+	AllowedOrigins   []string   `json:"allowed_origins,omitempty"`
+	RequireSignature bool       `json:"require_signature,omitempty"`
+
+	// Creates a sub-key sharing this key's limits; an ID or key
+	ParentKey string `json:"parent_key,omitempty"`
+
+	OwnerName  string `json:"owner_name,omitempty"`
+	OwnerEmail string `json:"owner_email,omitempty"`
+
+	// Project of the key; required for tokens scoped to an organization
+	ProjectID string `json:"project_id,omitempty"`
+}
+
+// CreatedAPIKey is a new key. APIKey and SigningSecret are only ever
+// returned here.
+type CreatedAPIKey struct {
+	APIKey        string `json:"api_key"`
+	KeyPrefix     string `json:"key_prefix"`
+	Name          string `json:"name"`
+	ParentID      string `json:"parent_id,omitempty"`
+	ProjectID     string `json:"project_id,omitempty"`
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// RotatedAPIKey is the new secret of a rotated key. The previous secret
+// keeps working until PreviousKeyExpiresAt.
+type RotatedAPIKey struct {
+	ID                   string    `json:"id"`
+	APIKey               string    `json:"api_key"`
+	KeyPrefix            string    `json:"key_prefix"`
+	PreviousKeyExpiresAt time.Time `json:"previous_key_expires_at"`
+}
+
+// ListAPIKeysOptions selects a page of keys
+type ListAPIKeysOptions struct {
+	// Only keys whose owner name or email contains Owner
+	Owner string
+	// Page size, 100 by default and at most 1000
+	Limit int
+	// NextCursor of the previous page
+	Cursor string
+	// Fields to sort by, e.g. "-created_at"
+	Sort string
+	// List deleted keys as well
+	IncludeDeleted bool
+}
+
+// APIKeyPage is a page of keys. NextCursor is empty on the last page.
+type APIKeyPage struct {
+	APIKeys    []APIKey `json:"api_keys"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// RateLimit is the state of a key's limit
+type RateLimit struct {
+	Allowed   bool      `json:"allowed"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetTime time.Time `json:"reset_time"`
+	Penalty   Penalty   `json:"penalty"`
+
+	// Why the key was refused, e.g. "Rate limit exceeded", and how long
+	// until it may be used again; only set when it isn't Allowed
+	Reason     string        `json:"-"`
+	RetryAfter time.Duration `json:"-"`
+}
+
+// Refund is the outcome of RefundRateLimit
+type Refund struct {
+	// Refunded is the number of units given back, which can be fewer than
+	// asked for once the key's refund limit is reached
+	Refunded  int       `json:"refunded"`
+	RateLimit RateLimit `json:"rate_limit"`
+}
+
+// Reservation is a number of units of a key's limit held for a job by
+// ReserveRateLimit
+type Reservation struct {
+	ID    string `json:"id"`
+	Units int    `json:"units"`
+	// ExpiresAt is when the units are given back unless the reservation
+	// is committed first
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Penalty is the abuse cooldown of a key that kept exceeding its limit
+type Penalty struct {
+	Active    bool      `json:"active"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Level     int       `json:"level"`
+}
+
+// CreateAPIKey creates a key. It needs the operator role.
+func (c *Client) CreateAPIKey(ctx context.Context, params CreateAPIKeyParams) (*CreatedAPIKey, error) {
+	if params.Name == "" {
+		return nil, errMissingArgument("name")
+	}
+	var created CreatedAPIKey
+	if err := c.do(ctx, request{method: http.MethodPost, path: apiVersion + "/admin/api-keys", body: params, admin: true}, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetAPIKey returns a key by ID or by the key itself
+func (c *Client) GetAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if key == "" {
+		return nil, errMissingArgument("key")
+	}
+	var response struct {
+		APIKey APIKey `json:"api_key"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: apiVersion + "/admin/api-keys/" + url.PathEscape(key), admin: true}, &response); err != nil {
+		return nil, err
+	}
+	return &response.APIKey, nil
+}
+
+// ListAPIKeys returns a page of keys
+func (c *Client) ListAPIKeys(ctx context.Context, opts ListAPIKeysOptions) (*APIKeyPage, error) {
+	query := url.Values{}
+	if opts.Owner != "" {
+		query.Set("owner", opts.Owner)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+
+	var page APIKeyPage
+	if err := c.do(ctx, request{method: http.MethodGet, path: apiVersion + "/admin/api-keys", query: query, admin: true}, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// RotateAPIKey replaces a key's secret. The previous secret keeps working
+// for gracePeriod, or the service's KEY_ROTATION_GRACE_PERIOD when it is 0.
+func (c *Client) RotateAPIKey(ctx context.Context, key string, gracePeriod time.Duration) (*RotatedAPIKey, error) {
+	if key == "" {
+		return nil, errMissingArgument("key")
+	}
+	req := request{method: http.MethodPost, path: apiVersion + "/admin/api-keys/" + url.PathEscape(key) + "/rotate", admin: true}
+	if gracePeriod > 0 {
+		req.body = map[string]int64{"grace_period_seconds": int64(gracePeriod / time.Second)}
+	}
+
+	var rotated RotatedAPIKey
+	if err := c.do(ctx, req, &rotated); err != nil {
+		return nil, err
+	}
+	return &rotated, nil
+}
+
+// DeactivateAPIKey deletes a key, which stops working until RestoreAPIKey
+// brings it back. It needs the admin role.
+func (c *Client) DeactivateAPIKey(ctx context.Context, key string) error {
+	if key == "" {
+		return errMissingArgument("key")
+	}
+	return c.do(ctx, request{method: http.MethodDelete, path: apiVersion + "/admin/api-keys/" + url.PathEscape(key), admin: true}, nil)
+}
+
+// RestoreAPIKey brings back a deleted key and returns it. Keys that aren't
+// deleted fail with ErrNotFound. It needs the admin role.
+func (c *Client) RestoreAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if key == "" {
+		return nil, errMissingArgument("key")
+	}
+	var response struct {
+		APIKey APIKey `json:"api_key"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: apiVersion + "/admin/api-keys/" + url.PathEscape(key) + "/restore", admin: true}, &response); err != nil {
+		return nil, err
+	}
+	return &response.APIKey, nil
+}
+
+// CheckRateLimit counts one request against apiKey's limit and returns the
+// limit's state. A key over its limit isn't an error: the result isn't
+// Allowed and says when to retry. Invalid keys fail with ErrUnauthorized,
+// and keys that may not be used from here with ErrForbidden.
+func (c *Client) CheckRateLimit(ctx context.Context, apiKey string) (*RateLimit, error) {
+	if apiKey == "" {
+		return nil, errMissingArgument("API key")
+	}
+	var response struct {
+		RateLimit RateLimit `json:"rate_limit"`
+	}
+	// Retrying a refused check would only count more requests
+	req := request{method: http.MethodGet, path: apiVersion + "/api/rate-limit", apiKey: apiKey, noRetryLimited: true}
+	err := c.do(ctx, req, &response)
+	var refused *Error
+	if !errors.As(err, &refused) || refused.StatusCode != http.StatusTooManyRequests {
+		if err != nil {
+			return nil, err
+		}
+		return &response.RateLimit, nil
+	}
+	return &RateLimit{
+		Limit:      refused.limit,
+		ResetTime:  refused.resetTime,
+		Reason:     refused.Title,
+		RetryAfter: refused.RetryAfter,
+	}, nil
+}
+
+// RefundRateLimit gives units of apiKey's limit back, e.g. for requests
+// whose downstream call failed, and returns the limit's state afterwards.
+// Keys without a refund limit fail with ErrForbidden.
+func (c *Client) RefundRateLimit(ctx context.Context, apiKey string, units int) (*Refund, error) {
+	if apiKey == "" {
+		return nil, errMissingArgument("API key")
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   apiVersion + "/api/rate-limit/refund",
+		body:   map[string]int{"units": units},
+		apiKey: apiKey,
+	}
+	var refund Refund
+	if err := c.do(ctx, req, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// ReserveRateLimit holds units of apiKey's limit for a long-running job
+// until it commits or cancels the reservation, for at most expiresIn (5
+// minutes when 0) and never past the current window. When the window has
+// too few units left it fails with ErrRateLimited.
+func (c *Client) ReserveRateLimit(ctx context.Context, apiKey string, units int, expiresIn time.Duration) (*Reservation, error) {
+	if apiKey == "" {
+		return nil, errMissingArgument("API key")
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   apiVersion + "/api/rate-limit/reservations",
+		body:   map[string]int{"units": units, "expires_in": int(expiresIn.Seconds())},
+		apiKey: apiKey,
+	}
+	var response struct {
+		Reservation Reservation `json:"reservation"`
+	}
+	if err := c.do(ctx, req, &response); err != nil {
+		return nil, err
+	}
+	return &response.Reservation, nil
+}
+
+// CommitReservation keeps the units of reservation id counted. Reservations
+// already settled or expired fail with ErrNotFound.
+func (c *Client) CommitReservation(ctx context.Context, apiKey, id string) error {
+	return c.settleReservation(ctx, http.MethodPost, apiKey, id, "/commit")
+}
+
+// CancelReservation gives the units of reservation id back. Reservations
+// already settled or expired fail with ErrNotFound.
+func (c *Client) CancelReservation(ctx context.Context, apiKey, id string) error {
+	return c.settleReservation(ctx, http.MethodDelete, apiKey, id, "")
+}
+
+func (c *Client) settleReservation(ctx context.Context, method, apiKey, id, suffix string) error {
+	if apiKey == "" {
+		return errMissingArgument("API key")
+	}
+	if id == "" {
+		return errMissingArgument("reservation ID")
+	}
+	req := request{
+		method: method,
+		path:   apiVersion + "/api/rate-limit/reservations/" + url.PathEscape(id) + suffix,
+		apiKey: apiKey,
+	}
+	return c.do(ctx, req, nil)
+}
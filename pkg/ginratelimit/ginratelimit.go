@@ -0,0 +1,291 @@
+// Package ginratelimit lets other Gin applications enforce the rate limiter's
+// API keys and limits in-process. It validates keys against the rate
+// limiter's database and counts requests in the Redis it shares with the
+// service (or in the database, for services running without Redis), so a
+// key's limit is the same whether its requests go through the service or
+// through an embedding application.
+package ginratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/middleware"
+	"grpc-firstls/internal/redis"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Config connects the middleware to the rate limiter's database and Redis.
+// The key hashing settings and default limits must match the service's, or
+// keys won't validate or will be limited differently.
+type Config struct {
+	DatabaseURL string
+	RedisURL    string
+
+	// Where counters are kept, "redis" (the default) or "postgres" like the
+	// service's RATE_LIMIT_BACKEND. With "postgres" RedisURL isn't needed.
+	RateLimitBackend string
+
+	// The service's REDIS_NAMESPACE and REDIS_TENANT_URLS, which must match
+	// for the middleware to share its counters
+	RedisNamespace  string
+	RedisTenantURLs map[string]string
+
+	KeyHashAlgorithm string
+	KeyHashPepper    string
+
+	// Limit of keys that don't set their own
+	DefaultRequests int
+	DefaultWindow   time.Duration
+
+	// The rest of the service's limit settings, when read by ConfigFromEnv
+	rateLimits *config.RateLimitConfig
+}
+
+// ConfigFromEnv reads the Config from the environment variables the rate
+// limiter itself is configured with, including its penalty and window
+// jitter settings
+func ConfigFromEnv() (Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		DatabaseURL:      cfg.DatabaseURL,
+		RedisURL:         cfg.RedisURL,
+		RateLimitBackend: cfg.RateLimitBackend,
+		RedisNamespace:   cfg.RedisNamespace,
+		RedisTenantURLs:  cfg.RedisTenantURLs,
+		KeyHashAlgorithm: cfg.KeyHashAlgorithm,
+		KeyHashPepper:    cfg.KeyHashPepper,
+		DefaultRequests:  cfg.RateLimitConfig.DefaultRequests,
+		DefaultWindow:    cfg.RateLimitConfig.DefaultWindow,
+		rateLimits:       &cfg.RateLimitConfig,
+	}, nil
+}
+
+// Limiter holds the connections the middleware checks keys and limits with.
+// One Limiter can back any number of middleware instances.
+type Limiter struct {
+	db      *database.DB
+	redis   *redis.Client
+	tenants []*redis.Client
+
+	apiKeyService    services.APIKeyServiceInterface
+	rateLimitService services.RateLimitServiceInterface
+}
+
+// New connects to the database and Redis in cfg. The database schema, and
+// cleaning up expired counters kept in the database, are left to the rate
+// limiter service.
+func New(cfg Config) (*Limiter, error) {
+	postgresBackend := cfg.RateLimitBackend == "postgres"
+	if cfg.RateLimitBackend != "" && cfg.RateLimitBackend != "redis" && !postgresBackend {
+		return nil, fmt.Errorf("ginratelimit: unknown rate limit backend %q", cfg.RateLimitBackend)
+	}
+	if cfg.DatabaseURL == "" || (cfg.RedisURL == "" && !postgresBackend) {
+		return nil, errors.New("ginratelimit: DatabaseURL and RedisURL are required")
+	}
+
+	rateLimits := config.RateLimitConfig{}
+	if cfg.rateLimits != nil {
+		rateLimits = *cfg.rateLimits
+	}
+	if cfg.DefaultRequests > 0 {
+		rateLimits.DefaultRequests = cfg.DefaultRequests
+	}
+	if cfg.DefaultWindow > 0 {
+		rateLimits.DefaultWindow = cfg.DefaultWindow
+	}
+	if rateLimits.DefaultRequests <= 0 || rateLimits.DefaultWindow <= 0 {
+		return nil, errors.New("ginratelimit: DefaultRequests and DefaultWindow must be positive")
+	}
+
+	keyHashing, err := services.NewKeyHashing(cfg.KeyHashAlgorithm, cfg.KeyHashPepper)
+	if err != nil {
+		return nil, fmt.Errorf("ginratelimit: %w", err)
+	}
+	db, err := database.NewConnection(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("ginratelimit: %w", err)
+	}
+	limiter := &Limiter{
+		db:            db,
+		apiKeyService: services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db), services.WithKeyHashing(keyHashing)),
+	}
+
+	var counters redis.ClientInterface
+	isolatedTenants := map[string]redis.ClientInterface{}
+	if postgresBackend {
+		counterStore, err := database.NewCounterStore(db, rateLimits.WindowJitter, time.Minute)
+		if err == nil {
+			err = counterStore.CheckSchema(context.Background())
+		}
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("ginratelimit: %w", err)
+		}
+		counters = counterStore
+	} else {
+		redisOptions := []redis.ClientOption{redis.WithWindowJitter(rateLimits.WindowJitter), redis.WithNamespace(cfg.RedisNamespace)}
+		limiter.redis, err = redis.NewClient(cfg.RedisURL, redisOptions...)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("ginratelimit: %w", err)
+		}
+		counters = limiter.redis
+
+		for tenant, tenantURL := range cfg.RedisTenantURLs {
+			tenantClient, err := redis.NewClient(tenantURL, redisOptions...)
+			if err != nil {
+				limiter.Close()
+				return nil, fmt.Errorf("ginratelimit: organization %s: %w", tenant, err)
+			}
+			limiter.tenants = append(limiter.tenants, tenantClient)
+			isolatedTenants[tenant] = tenantClient
+		}
+	}
+	limiter.rateLimitService = services.NewRateLimitService(counters, rateLimits,
+		services.WithTenants(redis.NewTenants(counters, isolatedTenants)))
+	return limiter, nil
+}
+
+// Close closes the database and Redis connections
+func (l *Limiter) Close() error {
+	var redisErr error
+	if l.redis != nil {
+		redisErr = l.redis.Close()
+	}
+	for _, tenant := range l.tenants {
+		if err := tenant.Close(); err != nil && redisErr == nil {
+			redisErr = err
+		}
+	}
+	if err := l.db.Close(); err != nil {
+		return err
+	}
+	return redisErr
+}
+
+type options struct {
+	apiKeyHeader  string
+	keyExtractor  func(c *gin.Context) string
+	endUserHeader string
+	skip          func(c *gin.Context) bool
+	failOpen      bool
+	standard      bool
+	logger        *zap.Logger
+}
+
+// Option configures a middleware instance
+type Option func(*options)
+
+// WithAPIKeyHeader reads the API key from header instead of X-API-Key. An
+// "Authorization: Bearer" header is still accepted when it is missing.
+func WithAPIKeyHeader(header string) Option {
+	return func(o *options) {
+		if header != "" {
+			o.apiKeyHeader = header
+		}
+	}
+}
+
+// WithKeyExtractor reads the API key from requests with extract, e.g. from a
+// cookie or query parameter, instead of from headers
+func WithKeyExtractor(extract func(c *gin.Context) string) Option {
+	return func(o *options) {
+		o.keyExtractor = extract
+	}
+}
+
+// WithEndUserHeader sets the header that identifies the end user for
+// per-end-user sublimits, X-End-User-ID by default
+func WithEndUserHeader(header string) Option {
+	return func(o *options) {
+		if header != "" {
+			o.endUserHeader = header
+		}
+	}
+}
+
+// WithSkip lets the requests skip returns true for, e.g. health checks,
+// through without an API key
+func WithSkip(skip func(c *gin.Context) bool) Option {
+	return func(o *options) {
+		o.skip = skip
+	}
+}
+
+// WithFailOpen lets requests through when their limits can't be checked,
+// e.g. while Redis is unavailable, instead of refusing them with a 500
+func WithFailOpen(enabled bool) Option {
+	return func(o *options) {
+		o.failOpen = enabled
+	}
+}
+
+// WithStandardHeaders also sends the IETF RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers next to the X-RateLimit-*
+// headers
+func WithStandardHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.standard = enabled
+	}
+}
+
+// WithLogger logs failed limit checks and alerts through logger instead of
+// through the global logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Middleware authenticates requests by API key and enforces the key's limits,
+// with the same checks and responses as the rate limiter's own API: the
+// key's allowed networks and origins, signatures, penalties and end-user
+// sublimits. Requests over a limit get a 429.
+func (l *Limiter) Middleware(opts ...Option) gin.HandlerFunc {
+	o := &options{endUserHeader: middleware.DefaultEndUserHeader}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	skip := o.skip
+	if skip == nil {
+		skip = func(*gin.Context) bool { return false }
+	}
+	rateLimitOptions := []middleware.RateLimitOption{
+		middleware.WithSkip(skip),
+		middleware.WithEndUserHeader(o.endUserHeader),
+		middleware.WithFailOpen(o.failOpen),
+		middleware.WithStandardHeaders(o.standard),
+		middleware.WithLogger(o.logger),
+	}
+	switch {
+	case o.keyExtractor != nil:
+		rateLimitOptions = append(rateLimitOptions, middleware.WithAPIKeyExtractor(o.keyExtractor))
+	case o.apiKeyHeader != "":
+		rateLimitOptions = append(rateLimitOptions, middleware.WithAPIKeyHeader(o.apiKeyHeader))
+	}
+	return middleware.RateLimit(l.apiKeyService, l.rateLimitService, rateLimitOptions...)
+}
+
+// APIKeyID returns the ID of the API key a request was authenticated with,
+// or "" for skipped requests
+func APIKeyID(c *gin.Context) string {
+	if apiKey, ok := c.Get("api_key"); ok {
+		if record, ok := apiKey.(*database.APIKey); ok {
+			return record.ID
+		}
+	}
+	return ""
+}
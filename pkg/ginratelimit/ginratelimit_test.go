@@ -0,0 +1,110 @@
+package ginratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/config"
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLimiter backs a Limiter with SQLite for both keys and counters, and
+// creates a key allowing two requests
+func newTestLimiter(t *testing.T) (*Limiter, string) {
+	db, err := database.NewConnection("sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(context.Background())
+	require.NoError(t, err)
+	counters, err := database.NewCounterStore(db, 0, time.Minute)
+	require.NoError(t, err)
+
+	apiKeyService := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db))
+	apiKey, err := apiKeyService.CreateAPIKey(context.Background(), services.CreateAPIKeyParams{
+		Name:                   "Embedded",
+		RateLimitRequests:      2,
+		RateLimitWindowSeconds: 60,
+	})
+	require.NoError(t, err)
+
+	return &Limiter{
+		apiKeyService:    apiKeyService,
+		rateLimitService: services.NewRateLimitService(counters, config.RateLimitConfig{DefaultRequests: 100, DefaultWindow: time.Hour}),
+	}, apiKey
+}
+
+func newRouter(limiter *Limiter, opts ...Option) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(limiter.Middleware(opts...))
+	router.GET("/orders", func(c *gin.Context) {
+		c.String(http.StatusOK, APIKeyID(c))
+	})
+	router.GET("/health", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return router
+}
+
+func serve(router *gin.Engine, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter, apiKey := newTestLimiter(t)
+	router := newRouter(limiter, WithSkip(func(c *gin.Context) bool {
+		return c.Request.URL.Path == "/health"
+	}))
+
+	w := serve(router, "/orders", "X-API-Key", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.String(), "handlers see the key's ID")
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, serve(router, "/orders", "Authorization", "Bearer "+apiKey).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "/orders", "X-API-Key", apiKey).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "/orders").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "/orders", "X-API-Key", "ak_invalid").Code)
+	assert.Equal(t, http.StatusOK, serve(router, "/health").Code)
+}
+
+func TestMiddleware_KeyOptions(t *testing.T) {
+	limiter, apiKey := newTestLimiter(t)
+
+	router := newRouter(limiter, WithAPIKeyHeader("X-Tenant-Key"), WithStandardHeaders(true))
+	w := serve(router, "/orders", "X-Tenant-Key", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "/orders", "X-API-Key", apiKey).Code)
+
+	// Nothing is exempt by default, unlike in the service itself
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "/health").Code)
+
+	router = newRouter(limiter, WithKeyExtractor(func(c *gin.Context) string { return c.Query("key") }))
+	assert.Equal(t, http.StatusOK, serve(router, "/orders?key="+apiKey).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "/orders?key="+apiKey).Code)
+}
+
+func TestNew_Validation(t *testing.T) {
+	_, err := New(Config{RedisURL: "redis://localhost:6379"})
+	assert.Error(t, err)
+	_, err = New(Config{DatabaseURL: "sqlite://:memory:", RedisURL: "redis://localhost:6379"})
+	assert.ErrorContains(t, err, "DefaultRequests")
+}
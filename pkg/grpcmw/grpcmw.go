@@ -0,0 +1,236 @@
+// Package grpcmw provides gRPC server interceptors that enforce the rate
+// limiter's limits inside other gRPC services. Every call is checked with the
+// rate limiter's CheckRateLimit API before it reaches the handler.
+package grpcmw
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Checker checks a call against the rate limiter. The RateLimitServiceClient
+// of a connection to its gRPC API is one.
+type Checker interface {
+	CheckRateLimit(ctx context.Context, in *ratelimitv1.CheckRateLimitRequest, opts ...grpc.CallOption) (*ratelimitv1.CheckRateLimitResponse, error)
+}
+
+type options struct {
+	keyFunc     func(ctx context.Context) string
+	endUserFunc func(ctx context.Context) string
+	skip        func(fullMethod string) bool
+	failOpen    bool
+	timeout     time.Duration
+}
+
+// Option configures the interceptors
+type Option func(*options)
+
+// WithKeyFunc sets how the API key is read from a call. By default it comes
+// from the "x-api-key" metadata, or an "authorization: Bearer" entry, like
+// the REST API's headers.
+func WithKeyFunc(keyFunc func(ctx context.Context) string) Option {
+	return func(o *options) {
+		if keyFunc != nil {
+			o.keyFunc = keyFunc
+		}
+	}
+}
+
+// WithEndUserFunc sets how the end user of a call is identified for
+// per-end-user sublimits. By default it comes from the "x-end-user-id"
+// metadata.
+func WithEndUserFunc(endUserFunc func(ctx context.Context) string) Option {
+	return func(o *options) {
+		if endUserFunc != nil {
+			o.endUserFunc = endUserFunc
+		}
+	}
+}
+
+// WithSkip exempts the methods skip returns true for, e.g. health checks,
+// from API keys and limits
+func WithSkip(skip func(fullMethod string) bool) Option {
+	return func(o *options) {
+		o.skip = skip
+	}
+}
+
+// WithFailOpen lets calls through when the rate limiter can't be reached,
+// instead of failing them with UNAVAILABLE
+func WithFailOpen(enabled bool) Option {
+	return func(o *options) {
+		o.failOpen = enabled
+	}
+}
+
+// WithTimeout bounds each check, 1 second by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+type contextKey struct{}
+
+// FromContext returns the check that let the call through, or nil for
+// skipped methods and calls let through by WithFailOpen
+func FromContext(ctx context.Context) *ratelimitv1.CheckRateLimitResponse {
+	response, _ := ctx.Value(contextKey{}).(*ratelimitv1.CheckRateLimitResponse)
+	return response
+}
+
+// UnaryServerInterceptor checks every unary call with checker
+func UnaryServerInterceptor(checker Checker, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := o.check(ctx, checker, info.FullMethod, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) })
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor checks every streaming call with checker when the
+// stream opens. The whole stream counts as one request.
+func StreamServerInterceptor(checker Checker, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := o.check(stream.Context(), checker, info.FullMethod, stream.SetHeader)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &checkedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{keyFunc: defaultKey, endUserFunc: metadataValue("x-end-user-id"), timeout: time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// check runs the rate limit check for a call, sending its limits back in
+// header metadata. Over the limit it fails with RESOURCE_EXHAUSTED and a
+// retry-after entry; an invalid key fails with the rate limiter's code.
+func (o *options) check(ctx context.Context, checker Checker, fullMethod string, setHeader func(metadata.MD) error) (context.Context, error) {
+	if o.skip != nil && o.skip(fullMethod) {
+		return ctx, nil
+	}
+
+	apiKey := o.keyFunc(ctx)
+	if apiKey == "" {
+		return ctx, status.Error(codes.Unauthenticated, "Please provide an API key in the x-api-key or authorization metadata")
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	response, err := checker.CheckRateLimit(checkCtx, &ratelimitv1.CheckRateLimitRequest{
+		ApiKey:    apiKey,
+		EndUserId: o.endUserFunc(ctx),
+		ClientIp:  peerIP(ctx),
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+			return ctx, status.Error(status.Code(err), status.Convert(err).Message())
+		}
+		if o.failOpen {
+			return ctx, nil
+		}
+		return ctx, status.Error(codes.Unavailable, "Unable to check rate limit")
+	}
+
+	rateLimit := response.RateLimit
+	if response.Reason == "end_user_limited" {
+		rateLimit = response.EndUserRateLimit
+	}
+	md := limitMetadata(rateLimit)
+	if !response.Allowed {
+		if rateLimit != nil {
+			md.Set("retry-after", strconv.FormatInt(rateLimit.RetryAfterSeconds, 10))
+		}
+		_ = setHeader(md)
+		return ctx, status.Errorf(codes.ResourceExhausted, "Rate limit exceeded (%s)", response.Reason)
+	}
+	_ = setHeader(md)
+	return context.WithValue(ctx, contextKey{}, response), nil
+}
+
+// limitMetadata mirrors the REST API's X-RateLimit-* headers
+func limitMetadata(rateLimit *ratelimitv1.RateLimit) metadata.MD {
+	md := metadata.MD{}
+	if rateLimit == nil {
+		return md
+	}
+	md.Set("x-ratelimit-limit", strconv.FormatInt(rateLimit.Limit, 10))
+	md.Set("x-ratelimit-remaining", strconv.FormatInt(rateLimit.Remaining, 10))
+	if rateLimit.ResetTime != nil {
+		md.Set("x-ratelimit-reset", rateLimit.ResetTime.AsTime().Format(time.RFC3339))
+	}
+	return md
+}
+
+func defaultKey(ctx context.Context) string {
+	if key := metadataValue("x-api-key")(ctx); key != "" {
+		return key
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if key := strings.TrimPrefix(value, "Bearer "); key != value && key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// metadataValue returns a function reading the first value of an incoming
+// metadata entry
+func metadataValue(key string) func(ctx context.Context) string {
+	return func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+}
+
+// peerIP returns the IP address of the caller, or "" when it isn't
+// connected over IP
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// checkedStream carries the context with the check result to stream handlers
+type checkedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *checkedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,164 @@
+package grpcmw
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	ratelimitv1 "grpc-firstls/api/ratelimit/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeChecker allows "good-key" up to limit calls and rejects other keys
+type fakeChecker struct {
+	limit    int64
+	calls    int64
+	requests []*ratelimitv1.CheckRateLimitRequest
+	err      error
+}
+
+func (f *fakeChecker) CheckRateLimit(ctx context.Context, in *ratelimitv1.CheckRateLimitRequest, opts ...grpc.CallOption) (*ratelimitv1.CheckRateLimitResponse, error) {
+	f.requests = append(f.requests, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if in.ApiKey != "good-key" {
+		return nil, status.Error(codes.Unauthenticated, "The provided API key is invalid or inactive")
+	}
+	f.calls++
+	remaining := f.limit - f.calls
+	if remaining < 0 {
+		remaining = 0
+	}
+	response := &ratelimitv1.CheckRateLimitResponse{
+		Allowed:  f.calls <= f.limit,
+		ApiKeyId: "key-id",
+		RateLimit: &ratelimitv1.RateLimit{
+			Limit:             f.limit,
+			Remaining:         remaining,
+			ResetTime:         timestamppb.New(time.Now().Add(time.Minute)),
+			RetryAfterSeconds: 60,
+		},
+	}
+	if !response.Allowed {
+		response.Reason = "limited"
+	}
+	return response, nil
+}
+
+// newHealthClient serves the health service behind the interceptors
+func newHealthClient(t *testing.T, checker Checker, opts ...Option) healthpb.HealthClient {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(checker, opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(checker, opts...)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	listener := bufconn.Listen(1 << 20)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func withKey(key string, pairs ...string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), append([]string{"x-api-key", key}, pairs...)...)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	checker := &fakeChecker{limit: 1}
+	client := newHealthClient(t, checker)
+
+	var header metadata.MD
+	_, err := client.Check(withKey("good-key", "x-end-user-id", "user-1"), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, header.Get("x-ratelimit-limit"))
+	assert.Equal(t, []string{"0"}, header.Get("x-ratelimit-remaining"))
+	require.Len(t, checker.requests, 1)
+	assert.Equal(t, "user-1", checker.requests[0].EndUserId)
+
+	_, err = client.Check(withKey("good-key"), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"60"}, header.Get("retry-after"))
+
+	_, err = client.Check(withKey("bad-key"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Bearer tokens work like the REST API's Authorization header
+	bearer := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer good-key")
+	_, err = client.Check(bearer, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := newHealthClient(t, &fakeChecker{limit: 1})
+
+	stream, err := client.Watch(withKey("good-key"), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	stream, err = client.Watch(withKey("good-key"), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestInterceptor_Options(t *testing.T) {
+	down := &fakeChecker{err: status.Error(codes.Unavailable, "connection refused")}
+	_, err := newHealthClient(t, down).Check(withKey("good-key"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = newHealthClient(t, down, WithFailOpen(true)).Check(withKey("good-key"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	skipped := &fakeChecker{err: errors.New("not called")}
+	client := newHealthClient(t, skipped, WithSkip(func(fullMethod string) bool {
+		return fullMethod == healthpb.Health_Check_FullMethodName
+	}))
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, skipped.requests)
+
+	custom := &fakeChecker{limit: 5}
+	client = newHealthClient(t, custom, WithKeyFunc(func(ctx context.Context) string {
+		return metadataValue("tenant-key")(ctx)
+	}))
+	_, err = client.Check(metadata.AppendToOutgoingContext(context.Background(), "tenant-key", "good-key"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	checker := &fakeChecker{limit: 5}
+	interceptor := UnaryServerInterceptor(checker)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "good-key"))
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		response := FromContext(ctx)
+		require.NotNil(t, response)
+		assert.Equal(t, "key-id", response.ApiKeyId)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Nil(t, FromContext(context.Background()))
+}
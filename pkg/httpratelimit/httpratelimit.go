@@ -0,0 +1,111 @@
+// Package httpratelimit adapts the rate limiter's key checks and limits to
+// plain net/http, for services built on the standard library, chi, echo or
+// any other router that accepts func(http.Handler) http.Handler middleware.
+// It shares a ginratelimit.Limiter and its checks; responses are the same as
+// the rate limiter's own API.
+package httpratelimit
+
+import (
+	"context"
+	"net/http"
+
+	"grpc-firstls/pkg/ginratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+type options struct {
+	limiterOptions []ginratelimit.Option
+}
+
+// Option configures the middleware
+type Option func(*options)
+
+// WithAPIKeyHeader reads the API key from header instead of X-API-Key. An
+// "Authorization: Bearer" header is still accepted when it is missing.
+func WithAPIKeyHeader(header string) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithAPIKeyHeader(header))
+	}
+}
+
+// WithKeyExtractor reads the API key from requests with extract instead of
+// from headers
+func WithKeyExtractor(extract func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithKeyExtractor(func(c *gin.Context) string {
+			return extract(c.Request)
+		}))
+	}
+}
+
+// WithEndUserHeader sets the header that identifies the end user for
+// per-end-user sublimits, X-End-User-ID by default
+func WithEndUserHeader(header string) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithEndUserHeader(header))
+	}
+}
+
+// WithSkip lets the requests skip returns true for through without an API
+// key
+func WithSkip(skip func(r *http.Request) bool) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithSkip(func(c *gin.Context) bool {
+			return skip(c.Request)
+		}))
+	}
+}
+
+// WithFailOpen lets requests through when their limits can't be checked,
+// instead of refusing them with a 500
+func WithFailOpen(enabled bool) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithFailOpen(enabled))
+	}
+}
+
+// WithStandardHeaders also sends the IETF RateLimit-* headers next to the
+// X-RateLimit-* headers
+func WithStandardHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.limiterOptions = append(o.limiterOptions, ginratelimit.WithStandardHeaders(enabled))
+	}
+}
+
+type contextKey struct{}
+
+// APIKeyID returns the ID of the API key a request was authenticated with,
+// or "" for skipped requests
+func APIKeyID(r *http.Request) string {
+	id, _ := r.Context().Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware returns middleware that authenticates requests by API key and
+// enforces the key's limits before calling the next handler. Requests that
+// fail a check are answered without reaching it.
+func Middleware(limiter *ginratelimit.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	check := limiter.Middleware(o.limiterOptions...)
+
+	return func(next http.Handler) http.Handler {
+		// The checks run as Gin middleware in front of a catch-all route that
+		// hands the request on, without any of Gin's routing behaviour
+		engine := gin.New()
+		engine.RedirectTrailingSlash = false
+		engine.RedirectFixedPath = false
+		engine.Use(check)
+		engine.Any("/*path", func(c *gin.Context) {
+			r := c.Request
+			if id := ginratelimit.APIKeyID(c); id != "" {
+				r = r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+			}
+			next.ServeHTTP(c.Writer, r)
+		})
+		return engine
+	}
+}
@@ -0,0 +1,105 @@
+package httpratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grpc-firstls/internal/database"
+	"grpc-firstls/internal/repository"
+	"grpc-firstls/internal/services"
+	"grpc-firstls/pkg/ginratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLimiter migrates a SQLite database, creates a key allowing two
+// requests and opens a Limiter counting in that database
+func newTestLimiter(t *testing.T) (*ginratelimit.Limiter, string) {
+	gin.SetMode(gin.TestMode)
+	databaseURL := "sqlite://" + filepath.Join(t.TempDir(), "rate_limiter.db")
+	db, err := database.NewConnection(databaseURL)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Migrate(context.Background())
+	require.NoError(t, err)
+
+	apiKey, err := services.NewAPIKeyService(repository.NewSQLAPIKeyRepository(db)).CreateAPIKey(context.Background(), services.CreateAPIKeyParams{
+		Name:                   "Embedded",
+		RateLimitRequests:      2,
+		RateLimitWindowSeconds: 60,
+	})
+	require.NoError(t, err)
+
+	limiter, err := ginratelimit.New(ginratelimit.Config{
+		DatabaseURL:      databaseURL,
+		RateLimitBackend: "postgres",
+		DefaultRequests:  100,
+		DefaultWindow:    time.Hour,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter, apiKey
+}
+
+func newHandler(limiter *ginratelimit.Limiter, opts ...Option) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(APIKeyID(r)))
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	return Middleware(limiter, opts...)(mux)
+}
+
+func serve(handler http.Handler, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter, apiKey := newTestLimiter(t)
+	handler := newHandler(limiter, WithSkip(func(r *http.Request) bool {
+		return r.URL.Path == "/health"
+	}))
+
+	w := serve(handler, "/orders/1", "X-API-Key", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.String(), "handlers see the key's ID")
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "/orders/1", "Authorization", "Bearer "+apiKey).Code)
+	w = serve(handler, "/orders/1", "X-API-Key", apiKey)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "Rate limit exceeded")
+
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, "/orders/1").Code)
+
+	// Skipped requests reach the handler, which writes no status of its own
+	w = serve(handler, "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestMiddleware_KeyOptions(t *testing.T) {
+	limiter, apiKey := newTestLimiter(t)
+
+	handler := newHandler(limiter, WithAPIKeyHeader("X-Tenant-Key"))
+	assert.Equal(t, http.StatusOK, serve(handler, "/orders/1", "X-Tenant-Key", apiKey).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, "/orders/1", "X-API-Key", apiKey).Code)
+
+	handler = newHandler(limiter, WithKeyExtractor(func(r *http.Request) string {
+		return r.URL.Query().Get("key")
+	}))
+	assert.Equal(t, http.StatusOK, serve(handler, "/orders/1?key="+apiKey).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "/orders/1?key="+apiKey).Code)
+}
@@ -0,0 +1,216 @@
+-- Initialize the rate_limiter database on MySQL 8 or MariaDB 10.5+
+-- Mirrors init-db.sql; UUIDs are stored as text and list columns as JSON arrays
+
+-- Create the plans table (tiers whose limits keys inherit unless overridden)
+-- quota_requests = 0 means no long-term quota
+CREATE TABLE IF NOT EXISTS plans (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(64) UNIQUE NOT NULL,
+    rate_limit_requests INTEGER NOT NULL,
+    rate_limit_window_seconds INTEGER NOT NULL,
+    quota_requests INTEGER NOT NULL DEFAULT 0,
+    quota_period_seconds INTEGER NOT NULL DEFAULT 0,
+    burst_requests INTEGER NOT NULL DEFAULT 0,
+    limit_response JSON,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+-- Seed the default plans
+INSERT IGNORE INTO plans (id, name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests)
+VALUES
+    (UUID(), 'free', 60, 60, 10000, 2592000, 0),
+    (UUID(), 'pro', 600, 60, 1000000, 2592000, 100),
+    (UUID(), 'enterprise', 6000, 60, 0, 0, 1000);
+
+-- Tenants and their projects; see init-db.sql
+CREATE TABLE IF NOT EXISTS organizations (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    rate_limit_requests INT NOT NULL DEFAULT 0,
+    rate_limit_window_seconds INT NOT NULL DEFAULT 0,
+    plan_id CHAR(36),
+    max_keys INT NOT NULL DEFAULT 0,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (plan_id) REFERENCES plans(id)
+);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id CHAR(36) PRIMARY KEY,
+    organization_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY uq_projects_organization_name (organization_id, name),
+    FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+-- Create the api_keys table; see init-db.sql for what each column is for
+CREATE TABLE IF NOT EXISTS api_keys (
+    id CHAR(36) PRIMARY KEY,
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    rate_limit_requests INTEGER NOT NULL DEFAULT 100,
+    rate_limit_window_seconds INTEGER NOT NULL DEFAULT 3600,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    plan_id CHAR(36),
+    end_user_limit_requests INTEGER NOT NULL DEFAULT 0,
+    end_user_limit_window_seconds INTEGER NOT NULL DEFAULT 0,
+    previous_key_hash VARCHAR(255),
+    previous_key_expires_at DATETIME(6),
+    expires_at DATETIME(6),
+    key_prefix VARCHAR(16) NOT NULL DEFAULT '',
+    allowed_cidrs JSON,
+    allowed_origins JSON,
+    last_used_at DATETIME(6),
+    lifetime_requests BIGINT NOT NULL DEFAULT 0,
+    signing_secret VARCHAR(255),
+    hash_version SMALLINT NOT NULL DEFAULT 1,
+    parent_id CHAR(36),
+    owner_name VARCHAR(255),
+    owner_email VARCHAR(255),
+    alert_thresholds JSON,
+    refund_limit INTEGER NOT NULL DEFAULT 0,
+    limit_response JSON,
+    project_id CHAR(36),
+    deleted_at DATETIME(6),
+    INDEX idx_api_keys_is_active (is_active),
+    INDEX idx_api_keys_created_at (created_at),
+    INDEX idx_api_keys_previous_key_hash (previous_key_hash),
+    INDEX idx_api_keys_key_prefix (key_prefix),
+    INDEX idx_api_keys_expires_at (expires_at),
+    INDEX idx_api_keys_owner_email (owner_email),
+    FOREIGN KEY (plan_id) REFERENCES plans(id),
+    FOREIGN KEY (parent_id) REFERENCES api_keys(id) ON DELETE CASCADE,
+    FOREIGN KEY (project_id) REFERENCES projects(id)
+);
+
+-- Temporary limit boosts (e.g. for customer launch events)
+CREATE TABLE IF NOT EXISTS limit_overrides (
+    id CHAR(36) PRIMARY KEY,
+    api_key_id CHAR(36) NOT NULL,
+    rate_limit_requests INTEGER NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_limit_overrides_api_key_id (api_key_id, expires_at),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- Per-day request counts, flushed from Redis by the usage worker
+CREATE TABLE IF NOT EXISTS api_key_usage_daily (
+    api_key_id CHAR(36) NOT NULL,
+    day DATE NOT NULL,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- One row per authenticated request, written in batches by the usage log writer
+CREATE TABLE IF NOT EXISTS usage_logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    api_key_id CHAR(36) NOT NULL,
+    method VARCHAR(16) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    status_code SMALLINT NOT NULL,
+    cost INTEGER NOT NULL DEFAULT 1,
+    decision VARCHAR(32) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_usage_logs_api_key_id (api_key_id, created_at),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- Hourly and daily usage totals per key and route, rolled up from usage_logs
+CREATE TABLE IF NOT EXISTS usage_rollups_hourly (
+    api_key_id CHAR(36) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    bucket_start DATETIME(6) NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route),
+    INDEX idx_usage_rollups_hourly_bucket_start (bucket_start),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS usage_rollups_daily (
+    api_key_id CHAR(36) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    bucket_start DATETIME(6) NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route),
+    INDEX idx_usage_rollups_daily_bucket_start (bucket_start),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- Usage reports requested through POST /admin/exports, generated in the background
+CREATE TABLE IF NOT EXISTS usage_exports (
+    id CHAR(36) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    api_key_id CHAR(36),
+    period_start DATETIME(6) NOT NULL,
+    period_end DATETIME(6) NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    content LONGBLOB,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    started_at DATETIME(6),
+    completed_at DATETIME(6),
+    INDEX idx_usage_exports_status (status, created_at),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- Responses to admin requests sent with an Idempotency-Key header, replayed to
+-- retries of the same request; a status code of 0 means still in progress
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response_body LONGBLOB,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (scope, idempotency_key),
+    INDEX idx_idempotency_keys_created_at (created_at)
+);
+
+-- Where limit alerts of a key are sent, and the log of sending them
+CREATE TABLE IF NOT EXISTS webhooks (
+    api_key_id CHAR(36) PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id CHAR(36) PRIMARY KEY,
+    api_key_id CHAR(36) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    url TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    delivered_at DATETIME(6),
+    INDEX idx_webhook_deliveries_api_key_id (api_key_id, created_at),
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+-- Insert a sample API key for testing
+INSERT IGNORE INTO api_keys (id, key_hash, name, rate_limit_requests, rate_limit_window_seconds)
+VALUES (
+    UUID(),
+    'a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3', -- hash of 'hello'
+    'Test API Key',
+    10,
+    60
+);
@@ -4,6 +4,46 @@
 -- Enable UUID extension
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Create the plans table (tiers whose limits keys inherit unless overridden)
+-- quota_requests = 0 means no long-term quota
+CREATE TABLE IF NOT EXISTS plans (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(64) UNIQUE NOT NULL,
+    rate_limit_requests INTEGER NOT NULL,
+    rate_limit_window_seconds INTEGER NOT NULL,
+    quota_requests INTEGER NOT NULL DEFAULT 0,
+    quota_period_seconds INTEGER NOT NULL DEFAULT 0,
+    burst_requests INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Seed the default plans
+INSERT INTO plans (name, rate_limit_requests, rate_limit_window_seconds, quota_requests, quota_period_seconds, burst_requests)
+VALUES
+    ('free', 60, 60, 10000, 2592000, 0),
+    ('pro', 600, 60, 1000000, 2592000, 100),
+    ('enterprise', 6000, 60, 0, 0, 1000)
+ON CONFLICT (name) DO NOTHING;
+
+-- Tenants of the platform; org-scoped admin credentials only see their own
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Groups of keys within an organization, e.g. one per application
+CREATE TABLE IF NOT EXISTS projects (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (organization_id, name)
+);
+
 -- Create the api_keys table
 CREATE TABLE IF NOT EXISTS api_keys (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -16,10 +56,252 @@ CREATE TABLE IF NOT EXISTS api_keys (
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Keys reference a plan; a rate limit of 0 on the key means "inherit from plan"
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id);
+
+-- Optional per-end-user sublimit keyed by the X-End-User-ID header (0 = disabled)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS end_user_limit_requests INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS end_user_limit_window_seconds INTEGER NOT NULL DEFAULT 0;
+
+-- Previous secret kept valid during a rotation grace period
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_hash VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Optional expiry; expired keys are rejected and swept to inactive
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Leading characters of the key so admins can identify it without the secret
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(16) NOT NULL DEFAULT '';
+
+-- Source networks allowed to use the key (NULL = any)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
+
+-- Browser origins allowed to use the key, e.g. https://*.example.com (NULL = any)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_origins TEXT[];
+
+-- Last successful authentication, flushed in batches by the server
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
+
+-- Lifetime request count, flushed from Redis by the usage worker
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS lifetime_requests BIGINT NOT NULL DEFAULT 0;
+
+-- HMAC secret for keys that require signed requests (NULL = signing not required)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(255);
+
+-- How key_hash was computed: 1 = SHA-256, 2 = HMAC-SHA256 with pepper, 3 = argon2id
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS hash_version SMALLINT NOT NULL DEFAULT 1;
+
+-- Parent of a sub-key; sub-keys share the parent's limits and quota and are deleted with it
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES api_keys(id) ON DELETE CASCADE;
+
+-- Team or person responsible for the key
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_name VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_email VARCHAR(255);
+
+-- Percentages of the rate limit or quota that raise a warning when reached (NULL = none)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS alert_thresholds INTEGER[];
+
+-- Units the key may refund per rate limit window (0 = refunds not allowed)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS refund_limit INTEGER NOT NULL DEFAULT 0;
+
+-- Custom response to requests over the rate limit or quota (NULL = the plan's, or the standard one)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS limit_response JSONB;
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS limit_response JSONB;
+
+-- Project the key belongs to (NULL = not part of any organization)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS project_id UUID REFERENCES projects(id);
+
+-- When the key was deleted (NULL = not deleted); deleted keys can be restored.
+-- Keys deactivated by hand before deletes became restorable count as deleted
+-- since their last update; expired keys stay deactivated. Backfilled once,
+-- when the column is added.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'api_keys' AND column_name = 'deleted_at'
+    ) THEN
+        ALTER TABLE api_keys ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+        UPDATE api_keys SET deleted_at = COALESCE(updated_at, NOW()), is_active = true
+        WHERE is_active = false AND (expires_at IS NULL OR expires_at > NOW());
+    END IF;
+END $$;
+
+-- Ceiling on the requests of all of an organization's keys together (0 = none)
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS rate_limit_requests INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS rate_limit_window_seconds INTEGER NOT NULL DEFAULT 0;
+-- Plan of the keys an organization creates itself through /v1/me, and how
+-- many active keys it may hold (0 = no cap)
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id);
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS max_keys INTEGER NOT NULL DEFAULT 0;
+
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash);
 CREATE INDEX IF NOT EXISTS idx_api_keys_is_active ON api_keys(is_active);
 CREATE INDEX IF NOT EXISTS idx_api_keys_created_at ON api_keys(created_at);
+CREATE INDEX IF NOT EXISTS idx_api_keys_plan_id ON api_keys(plan_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_key_prefix ON api_keys(key_prefix);
+CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys(expires_at) WHERE is_active = true;
+CREATE INDEX IF NOT EXISTS idx_api_keys_parent_id ON api_keys(parent_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner_email ON api_keys(LOWER(owner_email));
+CREATE INDEX IF NOT EXISTS idx_api_keys_project_id ON api_keys(project_id);
+
+-- Temporary limit boosts (e.g. for customer launch events)
+CREATE TABLE IF NOT EXISTS limit_overrides (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    rate_limit_requests INTEGER NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_limit_overrides_api_key_id ON limit_overrides(api_key_id, expires_at);
+
+-- Per-day request counts, flushed from Redis by the usage worker
+CREATE TABLE IF NOT EXISTS api_key_usage_daily (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+);
+
+-- One row per authenticated request, written in batches by the usage log writer
+CREATE TABLE IF NOT EXISTS usage_logs (
+    id BIGSERIAL PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    status_code SMALLINT NOT NULL,
+    cost INTEGER NOT NULL DEFAULT 1,
+    decision VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_logs_api_key_id ON usage_logs(api_key_id, created_at);
+
+-- Hourly and daily usage totals per key and route, rolled up from usage_logs
+CREATE TABLE IF NOT EXISTS usage_rollups_hourly (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    route VARCHAR(255) NOT NULL,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_rollups_hourly_bucket_start ON usage_rollups_hourly(bucket_start);
+
+CREATE TABLE IF NOT EXISTS usage_rollups_daily (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    route VARCHAR(255) NOT NULL,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    requests BIGINT NOT NULL,
+    limited BIGINT NOT NULL,
+    cost BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, bucket_start, route)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_rollups_daily_bucket_start ON usage_rollups_daily(bucket_start);
+
+-- Usage reports requested through POST /admin/exports, generated in the background
+CREATE TABLE IF NOT EXISTS usage_exports (
+    id UUID PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    content BYTEA,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_exports_status ON usage_exports(status, created_at);
+
+-- Responses to admin requests sent with an Idempotency-Key header, replayed to
+-- retries of the same request; a status code of 0 means still in progress
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response_body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+-- Where limit alerts of a key are sent, and the log of sending them
+CREATE TABLE IF NOT EXISTS webhooks (
+    api_key_id UUID PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    url TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_api_key_id ON webhook_deliveries(api_key_id, created_at);
+
+-- Rate limit state for RATE_LIMIT_BACKEND=postgres. Counters are rebuilt by
+-- traffic, so they skip the write-ahead log like Redis skips persistence;
+-- unflushed usage counts in rate_limit_hash_fields are kept.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT PRIMARY KEY,
+    value BIGINT NOT NULL DEFAULT 0,
+    expires_at BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expires_at ON rate_limit_counters(expires_at);
+
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_set_members (
+    key TEXT NOT NULL,
+    member TEXT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (key, member)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_set_members_expires_at ON rate_limit_set_members(expires_at);
+
+-- Reserved units of a counter, released at release_at unless settled before;
+-- expires_at is the end of the counter's window they were reserved in
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_reservations (
+    key TEXT NOT NULL,
+    id TEXT NOT NULL,
+    units BIGINT NOT NULL,
+    release_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (key, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_reservations_expires_at ON rate_limit_reservations(expires_at);
+
+CREATE TABLE IF NOT EXISTS rate_limit_hash_fields (
+    key TEXT NOT NULL,
+    field TEXT NOT NULL,
+    value BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, field)
+);
 
 -- Insert a sample API key for testing (hash for 'test-api-key-123')
 INSERT INTO api_keys (key_hash, name, rate_limit_requests, rate_limit_window_seconds) 
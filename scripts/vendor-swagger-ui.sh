@@ -0,0 +1,31 @@
+#!/bin/sh
+# Vendors the Swagger UI assets served under /docs/assets into
+# internal/handlers/swagger-ui, at the version pinned in its VERSION file.
+# The npm tarball is checked against the integrity hash the registry
+# publishes for that version before anything is extracted. Commit the
+# result; the server embeds the files and loads nothing from a CDN.
+set -eu
+
+dir="$(cd "$(dirname "$0")/.." && pwd)/internal/handlers/swagger-ui"
+version="$(cat "$dir/VERSION")"
+registry="https://registry.npmjs.org/swagger-ui-dist"
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+
+curl -fsSL "$registry/$version" -o "$work/metadata.json"
+expected="$(sed -n 's/.*"integrity":"sha512-\([^"]*\)".*/\1/p' "$work/metadata.json")"
+if [ -z "$expected" ]; then
+	echo "no sha512 integrity published for swagger-ui-dist@$version" >&2
+	exit 1
+fi
+
+curl -fsSL "$registry/-/swagger-ui-dist-$version.tgz" -o "$work/package.tgz"
+actual="$(openssl dgst -sha512 -binary "$work/package.tgz" | openssl base64 -A)"
+if [ "$actual" != "$expected" ]; then
+	echo "swagger-ui-dist@$version doesn't match its published integrity" >&2
+	exit 1
+fi
+
+tar -xzf "$work/package.tgz" -C "$work" package/swagger-ui.css package/swagger-ui-bundle.js
+cp "$work/package/swagger-ui.css" "$work/package/swagger-ui-bundle.js" "$dir/"
+echo "vendored swagger-ui-dist@$version into $dir"
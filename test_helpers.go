@@ -52,6 +52,11 @@ func (m *MockDB) QueryRow(query string, args ...interface{}) *sql.Row {
 	return nil
 }
 
+func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	// Mock implementation - in real tests, you'd use a proper mock
+	return nil, nil
+}
+
 func (m *MockDB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	// Mock implementation - in real tests, you'd use a proper mock
 	return nil, nil
@@ -68,15 +73,79 @@ func NewMockRedisClient() *MockRedisClient {
 	}
 }
 
-func (m *MockRedisClient) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, error) {
+func (m *MockRedisClient) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
 	m.counters[key]++
-	return m.counters[key], nil
+	return m.counters[key], window, nil
+}
+
+func (m *MockRedisClient) RefundRateLimit(ctx context.Context, key, refundsKey string, units, maxRefunds int64) (int64, error) {
+	refunded := units
+	if refunded > m.counters[key] {
+		refunded = m.counters[key]
+	}
+	if refunded > maxRefunds-m.counters[refundsKey] {
+		refunded = maxRefunds - m.counters[refundsKey]
+	}
+	if refunded <= 0 {
+		return 0, nil
+	}
+	m.counters[key] -= refunded
+	m.counters[refundsKey] += refunded
+	return refunded, nil
+}
+
+func (m *MockRedisClient) ReserveRateLimit(ctx context.Context, key, reservationsKey, id string, units, limit int64, window, ttl time.Duration) (bool, int64, time.Duration, error) {
+	if m.counters[key]+units > limit {
+		return false, m.counters[key], window, nil
+	}
+	m.counters[key] += units
+	m.counters[reservationsKey+":"+id] = units
+	return true, m.counters[key], window, nil
+}
+
+func (m *MockRedisClient) SettleReservation(ctx context.Context, key, reservationsKey, id string, release bool) (int64, error) {
+	units := m.counters[reservationsKey+":"+id]
+	delete(m.counters, reservationsKey+":"+id)
+	if release {
+		m.counters[key] -= units
+	}
+	return units, nil
+}
+
+func (m *MockRedisClient) ReleaseExpiredReservations(ctx context.Context, key, reservationsKey string) (int64, error) {
+	return 0, nil
 }
 
 func (m *MockRedisClient) GetRateLimitCount(ctx context.Context, key string) (int64, error) {
 	return m.counters[key], nil
 }
 
+func (m *MockRedisClient) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (m *MockRedisClient) SetWithExpiry(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	m.counters[key] = value
+	return nil
+}
+
+func (m *MockRedisClient) AddUniqueMember(ctx context.Context, key string, member string, maxMembers int64, window time.Duration) (bool, int64, error) {
+	return true, 1, nil
+}
+
+func (m *MockRedisClient) DeleteByPattern(ctx context.Context, patterns ...string) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockRedisClient) IncrementHashField(ctx context.Context, key string, field string, delta int64) error {
+	m.counters[key+":"+field] += delta
+	return nil
+}
+
+func (m *MockRedisClient) DrainHash(ctx context.Context, key string) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
 // TestData provides test data for various scenarios
 type TestData struct{}
 